@@ -0,0 +1,76 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"testcontainers-demo/repository"
+)
+
+// healthTimeout bounds a health check so a hung dependency fails the probe
+// instead of stalling it
+const healthTimeout = 2 * time.Second
+
+// Dependency states in the /healthz body
+const (
+	healthOK       = "ok"
+	healthDegraded = "degraded"
+)
+
+// Healthchecker is implemented by UserRepository and CachedUserRepository
+type Healthchecker interface {
+	Healthcheck(ctx context.Context) error
+}
+
+// HealthHandler serves readiness probes. It responds 200 with the state of each
+// dependency, e.g. {"postgres":"ok","redis":"ok"}, or 503 with the failing
+// ones marked "degraded".
+func HealthHandler(checker Healthchecker, dependencies ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), healthTimeout)
+		defer cancel()
+
+		status := make(map[string]string, len(dependencies))
+		for _, dep := range dependencies {
+			status[dep] = healthOK
+		}
+
+		err := checker.Healthcheck(ctx)
+		if err == nil {
+			writeJSON(w, http.StatusOK, status)
+			return
+		}
+
+		log.Printf("api: health check failed: %v", err)
+		failed := failedDependencies(err)
+		if len(failed) == 0 {
+			// Not attributable to one dependency, so none of them is known good
+			failed = dependencies
+		}
+		for _, dep := range failed {
+			status[dep] = healthDegraded
+		}
+		writeJSON(w, http.StatusServiceUnavailable, status)
+	})
+}
+
+// failedDependencies collects the dependencies named by the *HealthErrors in
+// err, which may be a join of several
+func failedDependencies(err error) []string {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var deps []string
+		for _, e := range joined.Unwrap() {
+			deps = append(deps, failedDependencies(e)...)
+		}
+		return deps
+	}
+
+	var healthErr *repository.HealthError
+	if errors.As(err, &healthErr) {
+		return []string{healthErr.Dependency}
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestHealthz tests the readiness endpoint of the API server
+func TestHealthz(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	resp := do(t, http.MethodGet, srv.URL+"/healthz", nil)
+	expectStatus(t, resp, http.StatusOK)
+
+	var body map[string]string
+	decode(t, resp, &body)
+	if want := map[string]string{"postgres": "ok"}; !reflect.DeepEqual(body, want) {
+		t.Errorf("Expected %v, got: %v", want, body)
+	}
+}
+
+// TestHealthzRedisDown tests that stopping Redis flips the cached
+// repository's health to 503 while postgres stays ok
+func TestHealthzRedisDown(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	redisContainer := testhelpers.StartRedisContainer(ctx, t)
+	if redisContainer.RedisContainer == nil {
+		t.Skip("Can't stop an external Redis")
+	}
+
+	cachedRepo := repository.NewCachedUserRepository(testDB, redisContainer.Client)
+	srv := httptest.NewServer(HealthHandler(cachedRepo, repository.DependencyPostgres, repository.DependencyRedis))
+	t.Cleanup(srv.Close)
+
+	t.Run("Healthy", func(t *testing.T) {
+		resp := do(t, http.MethodGet, srv.URL, nil)
+		expectStatus(t, resp, http.StatusOK)
+
+		var body map[string]string
+		decode(t, resp, &body)
+		if want := map[string]string{"postgres": "ok", "redis": "ok"}; !reflect.DeepEqual(body, want) {
+			t.Errorf("Expected %v, got: %v", want, body)
+		}
+	})
+
+	t.Run("Redis Stopped", func(t *testing.T) {
+		if err := redisContainer.Stop(ctx, nil); err != nil {
+			t.Fatalf("Failed to stop Redis: %v", err)
+		}
+
+		resp := do(t, http.MethodGet, srv.URL, nil)
+		expectStatus(t, resp, http.StatusServiceUnavailable)
+
+		var body map[string]string
+		decode(t, resp, &body)
+		if want := map[string]string{"postgres": "ok", "redis": "degraded"}; !reflect.DeepEqual(body, want) {
+			t.Errorf("Expected %v, got: %v", want, body)
+		}
+	})
+}
@@ -0,0 +1,82 @@
+package api_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/api"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/repository/mocks"
+)
+
+// ExampleNewServer_mock tests a handler against a mocks.UserStore: no
+// database, just the error the repository would return
+func ExampleNewServer_mock() {
+	store := &mocks.UserStore{
+		GetByIDFunc: func(ctx context.Context, id int) (*models.User, error) {
+			return nil, fmt.Errorf("get user %d: %w", id, repository.ErrUserNotFound)
+		},
+	}
+	server := api.NewServer(store)
+
+	rec := httptest.NewRecorder()
+	server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users/42", nil))
+
+	fmt.Println(rec.Code, strings.TrimSpace(rec.Body.String()))
+	fmt.Println(store.CallsTo("GetByID")[0].Args)
+	// Output:
+	// 404 {"error":"user not found"}
+	// [42]
+}
+
+// TestMockNotFound tests that every route taking a user ID answers 404 when
+// the repository reports ErrUserNotFound, and that it asked for that ID
+func TestMockNotFound(t *testing.T) {
+	notFound := fmt.Errorf("wrapped: %w", repository.ErrUserNotFound)
+	store := &mocks.UserStore{
+		GetByIDFunc: func(context.Context, int) (*models.User, error) { return nil, notFound },
+		UpdateFunc:  func(context.Context, int, string, string) error { return notFound },
+		DeleteFunc:  func(context.Context, int) error { return notFound },
+	}
+	server := api.NewServer(store)
+
+	for _, tc := range []struct {
+		method, body, call string
+	}{
+		{http.MethodGet, "", "GetByID"},
+		{http.MethodPut, `{"email":"mock@example.com","name":"Mock User"}`, "Update"},
+		{http.MethodDelete, "", "Delete"},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			store.Reset()
+			rec := httptest.NewRecorder()
+			server.ServeHTTP(rec, httptest.NewRequest(tc.method, "/users/7", strings.NewReader(tc.body)))
+
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("Expected status 404, got: %d %s", rec.Code, rec.Body.String())
+			}
+			calls := store.Calls()
+			if len(calls) != 1 || calls[0].Method != tc.call || calls[0].Args[0] != 7 {
+				t.Errorf("Expected one %s of user 7, got: %+v", tc.call, calls)
+			}
+		})
+	}
+
+	t.Run("Unconfigured Method", func(t *testing.T) {
+		// Listing isn't configured, so the mock fails the request loudly
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status 500, got: %d", rec.Code)
+		}
+		if _, err := store.CountUsers(context.Background()); !errors.Is(err, mocks.ErrNotConfigured) {
+			t.Errorf("Expected ErrNotConfigured, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,390 @@
+// Package api exposes the user repository over a JSON REST API
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/repository"
+
+	"github.com/google/uuid"
+)
+
+// Server serves the users REST API
+type Server struct {
+	repo repository.PostgresUserStore
+	mux  *http.ServeMux
+}
+
+// NewServer creates a new API server backed by the given repository: a
+// *repository.UserRepository, or a mocks.UserStore in unit tests
+func NewServer(repo repository.PostgresUserStore) *Server {
+	s := &Server{
+		repo: repo,
+		mux:  http.NewServeMux(),
+	}
+
+	s.mux.HandleFunc("GET /users", s.listUsers)
+	s.mux.HandleFunc("GET /users/recent", s.recentUsers)
+	s.mux.HandleFunc("GET /users/{id}", s.getUser)
+	s.mux.HandleFunc("GET /users/email-available", s.emailAvailable)
+	s.mux.HandleFunc("POST /users", s.createUser)
+	s.mux.HandleFunc("PUT /users/{id}", s.updateUser)
+	s.mux.HandleFunc("DELETE /users/{id}", s.deleteUser)
+	s.mux.HandleFunc("GET /admin/stats", s.userStats)
+	s.mux.Handle("GET /healthz", HealthHandler(repo, repository.DependencyPostgres))
+
+	return s
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// UserRequest is the body of POST /users and PUT /users/{id}
+type UserRequest struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// validate checks the request before it reaches the repository
+func (req UserRequest) validate() error {
+	if strings.TrimSpace(req.Email) == "" {
+		return errors.New("email is required")
+	}
+	if strings.TrimSpace(req.Name) == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// UserResponse is a user as the API returns it: the public fields only,
+// with created_at in RFC 3339 UTC to the second. Internal fields of
+// models.User (the password hash, version, erasure flag, avatar object key)
+// never reach a client, whatever is added to the model later.
+type UserResponse struct {
+	ID        int         `json:"id"`
+	UUID      uuid.UUID   `json:"uuid"`
+	Email     string      `json:"email"`
+	Name      string      `json:"name"`
+	Role      models.Role `json:"role"`
+	CreatedAt time.Time   `json:"created_at"`
+}
+
+// NewUserResponse converts a user for a response
+func NewUserResponse(u models.User) UserResponse {
+	return UserResponse{
+		ID:        u.ID,
+		UUID:      u.UUID,
+		Email:     u.Email,
+		Name:      u.Name,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt.UTC().Truncate(time.Second),
+	}
+}
+
+// NewUserResponses converts users for a response; nil becomes an empty list
+func NewUserResponses(users []models.User) []UserResponse {
+	resp := make([]UserResponse, len(users))
+	for i, u := range users {
+		resp[i] = NewUserResponse(u)
+	}
+	return resp
+}
+
+// UserPage is the body of every list endpoint: a page of users, and the
+// next_cursor to pass back as ?cursor= for the page after it
+type UserPage = pagination.Page[UserResponse]
+
+// NewUserPage converts a page of users for a response
+func NewUserPage(page repository.UserPage) UserPage {
+	return pagination.Map(page, NewUserResponse)
+}
+
+// Page sizes of the list endpoints' ?limit=
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 500
+)
+
+// EmailAvailableResponse is the body of GET /users/email-available
+type EmailAvailableResponse struct {
+	Email     string `json:"email"`
+	Available bool   `json:"available"`
+}
+
+// ErrorResponse is the body of every non-2xx response
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// listUsers handles GET /users?role=&cursor=&limit=, a page of the users
+// ordered by ID, or of those with role
+func (s *Server) listUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, ok := pageLimit(w, query.Get("limit"))
+	if !ok {
+		return
+	}
+
+	var page repository.UserPage
+	var err error
+	if role := query.Get("role"); role != "" {
+		page, err = s.repo.ListByRole(r.Context(), models.Role(role), query.Get("cursor"), limit)
+	} else {
+		page, err = s.repo.ListPaginated(r.Context(), query.Get("cursor"), limit)
+	}
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewUserPage(page))
+}
+
+// recentUsers handles GET /users/recent?days=&cursor=&limit=, a page of
+// the users created in the last days days, 7 by default, newest first
+func (s *Server) recentUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, ok := pageLimit(w, query.Get("limit"))
+	if !ok {
+		return
+	}
+	days := 7
+	if raw := query.Get("days"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "days must be a non-negative integer")
+			return
+		}
+		days = n
+	}
+
+	page, err := s.repo.GetRecentUsers(r.Context(), days, query.Get("cursor"), limit)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, NewUserPage(page))
+}
+
+// pageLimit parses a ?limit= of 1 to MaxPageSize, DefaultPageSize when
+// empty, writing a 400 for anything else
+func pageLimit(w http.ResponseWriter, raw string) (int, bool) {
+	if raw == "" {
+		return DefaultPageSize, true
+	}
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > MaxPageSize {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", MaxPageSize))
+		return 0, false
+	}
+	return limit, true
+}
+
+// getUser handles GET /users/{id}
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewUserResponse(*user))
+}
+
+// emailAvailable handles GET /users/email-available?email=... for signup
+// forms. The answer is advisory: POST /users can still return 409.
+func (s *Server) emailAvailable(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("email")
+	if strings.TrimSpace(email) == "" {
+		writeError(w, http.StatusBadRequest, "email is required")
+		return
+	}
+
+	available, err := s.repo.IsEmailAvailable(r.Context(), email)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, EmailAvailableResponse{
+		Email:     repository.NormalizeEmail(email),
+		Available: available,
+	})
+}
+
+// userStats handles GET /admin/stats?from=YYYY-MM-DD&to=YYYY-MM-DD for the
+// admin dashboard: the signups per day from from through to, in UTC,
+// defaulting to the last 30 days. Like every endpoint, it is
+// unauthenticated; put it behind the admin network.
+func (s *Server) userStats(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := repository.ParseStatsRange(query.Get("from"), query.Get("to"), time.Now())
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	stats, err := s.repo.GetUserStats(r.Context(), from, to)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// IdempotencyKeyHeader makes a POST /users safe to retry: requests with the
+// same key create one user and all get it back
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// createUser handles POST /users
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeUserRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var user *models.User
+	var err error
+	if key := r.Header.Get(IdempotencyKeyHeader); key != "" {
+		user, err = s.repo.CreateIdempotent(r.Context(), key, req.Email, req.Name)
+	} else {
+		user, err = s.repo.Create(r.Context(), req.Email, req.Name)
+	}
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/users/%d", user.ID))
+	writeJSON(w, http.StatusCreated, NewUserResponse(*user))
+}
+
+// updateUser handles PUT /users/{id}
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	req, ok := decodeUserRequest(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.repo.Update(r.Context(), id, req.Email, req.Name); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	user, err := s.repo.GetByID(r.Context(), id)
+	if err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, NewUserResponse(*user))
+}
+
+// deleteUser handles DELETE /users/{id}
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := s.pathID(w, r)
+	if !ok {
+		return
+	}
+
+	if err := s.repo.Delete(r.Context(), id); err != nil {
+		writeRepoError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pathID parses the {id} path segment, which is either the integer ID or the
+// user's UUID. A UUID is resolved to the ID with a lookup, so unknown UUIDs
+// get a 404; anything else gets a 400.
+func (s *Server) pathID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	raw := r.PathValue("id")
+	if id, err := strconv.Atoi(raw); err == nil {
+		return id, true
+	}
+
+	uid, err := repository.ParseUUID(raw)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid user id")
+		return 0, false
+	}
+	user, err := s.repo.GetByUUID(r.Context(), uid)
+	if err != nil {
+		writeRepoError(w, err)
+		return 0, false
+	}
+	return user.ID, true
+}
+
+// decodeUserRequest decodes and validates a UserRequest body, writing a 400 on failure
+func decodeUserRequest(w http.ResponseWriter, r *http.Request) (UserRequest, bool) {
+	var req UserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return req, false
+	}
+	if err := req.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+// writeRepoError maps repository errors to HTTP status codes; clients see
+// only the sentinel message, not the operation and key recorded by RepoError
+func writeRepoError(w http.ResponseWriter, err error) {
+	var validationErr *repository.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		writeError(w, http.StatusBadRequest, validationErr.Error())
+	case errors.Is(err, pagination.ErrInvalidCursor):
+		writeError(w, http.StatusBadRequest, pagination.ErrInvalidCursor.Error())
+	case errors.Is(err, repository.ErrUserNotFound):
+		writeError(w, http.StatusNotFound, repository.ErrUserNotFound.Error())
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		writeError(w, http.StatusConflict, repository.ErrDuplicateEmail.Error())
+	case errors.Is(err, repository.ErrHasOrders):
+		writeError(w, http.StatusConflict, repository.ErrHasOrders.Error())
+	case errors.Is(err, repository.ErrIdempotencyConflict):
+		writeError(w, http.StatusUnprocessableEntity, repository.ErrIdempotencyConflict.Error())
+	default:
+		log.Printf("api: %v", err)
+		writeError(w, http.StatusInternalServerError, "internal server error")
+	}
+}
+
+// writeError writes an ErrorResponse with the given status
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, ErrorResponse{Error: message})
+}
+
+// writeJSON writes v as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: failed to encode response: %v", err)
+	}
+}
@@ -0,0 +1,565 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// Global test database connection, nil when Docker is unavailable
+var testDB *sql.DB
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testDB = c.DB
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testDB == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// newTestServer runs the API on an httptest server backed by the test database
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(NewServer(repository.NewUserRepository(testDB)))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// do sends a request with an optional JSON body and returns the response
+func do(t *testing.T, method, url string, body interface{}) *http.Response {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("Failed to encode body: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// decode reads a JSON response body into v
+func decode(t *testing.T, resp *http.Response, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+}
+
+// expectStatus fails the test if the response status doesn't match
+func expectStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+	if resp.StatusCode != want {
+		t.Fatalf("Expected status %d, got: %d", want, resp.StatusCode)
+	}
+}
+
+// walkPages follows a list endpoint's next_cursor from url to the last
+// page, failing the test unless every page agrees with the others, and
+// returns the users of all the pages in order
+func walkPages(t *testing.T, url string) []UserResponse {
+	t.Helper()
+	var users []UserResponse
+	var total int64 = -1
+	cursor := ""
+	for pages := 1; ; pages++ {
+		pageURL := url
+		if cursor != "" {
+			pageURL += "&cursor=" + cursor
+		}
+		resp := do(t, http.MethodGet, pageURL, nil)
+		expectStatus(t, resp, http.StatusOK)
+		var page UserPage
+		decode(t, resp, &page)
+
+		if total == -1 {
+			total = page.TotalCount
+		}
+		if page.TotalCount != total {
+			t.Fatalf("Page %d: expected total_count %d as on the first page, got: %d", pages, total, page.TotalCount)
+		}
+		if page.Items == nil {
+			t.Fatalf("Page %d: expected an items list, got null", pages)
+		}
+		users = append(users, page.Items...)
+		if int64(len(users)) > total {
+			t.Fatalf("Page %d: walked %d users of %d", pages, len(users), total)
+		}
+		if !page.HasMore {
+			if page.NextCursor != "" {
+				t.Errorf("Page %d: expected no next_cursor on the last page, got: %q", pages, page.NextCursor)
+			}
+			if int64(len(users)) != total {
+				t.Errorf("Expected pages to add up to total_count %d, got: %d users", total, len(users))
+			}
+			return users
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// TestUserLifecycle drives create, read, update, list, and delete over HTTP
+func TestUserLifecycle(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	// Create
+	resp := do(t, http.MethodPost, srv.URL+"/users", UserRequest{Email: "http@example.com", Name: "HTTP User"})
+	expectStatus(t, resp, http.StatusCreated)
+
+	var created UserResponse
+	decode(t, resp, &created)
+	if created.ID == 0 {
+		t.Fatal("Expected non-zero ID for created user")
+	}
+	if created.Email != "http@example.com" {
+		t.Errorf("Expected email 'http@example.com', got: %s", created.Email)
+	}
+	if loc := resp.Header.Get("Location"); loc != fmt.Sprintf("/users/%d", created.ID) {
+		t.Errorf("Expected Location header /users/%d, got: %s", created.ID, loc)
+	}
+	userURL := fmt.Sprintf("%s/users/%d", srv.URL, created.ID)
+
+	// Read
+	resp = do(t, http.MethodGet, userURL, nil)
+	expectStatus(t, resp, http.StatusOK)
+
+	var fetched UserResponse
+	decode(t, resp, &fetched)
+	if fetched.Name != "HTTP User" {
+		t.Errorf("Expected name 'HTTP User', got: %s", fetched.Name)
+	}
+
+	// Update
+	resp = do(t, http.MethodPut, userURL, UserRequest{Email: "http.updated@example.com", Name: "HTTP Updated"})
+	expectStatus(t, resp, http.StatusOK)
+
+	var updated UserResponse
+	decode(t, resp, &updated)
+	if updated.Email != "http.updated@example.com" || updated.Name != "HTTP Updated" {
+		t.Errorf("Expected updated user, got: %+v", updated)
+	}
+
+	// List
+	users := walkPages(t, srv.URL+"/users?limit=100")
+	found := false
+	for _, u := range users {
+		if u.ID == created.ID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		t.Error("Expected created user in list")
+	}
+
+	// Delete
+	resp = do(t, http.MethodDelete, userURL, nil)
+	expectStatus(t, resp, http.StatusNoContent)
+
+	resp = do(t, http.MethodGet, userURL, nil)
+	expectStatus(t, resp, http.StatusNotFound)
+}
+
+// TestErrorResponses tests status codes and error bodies for failure cases
+func TestErrorResponses(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		// alice@example.com comes from the seed data
+		resp := do(t, http.MethodPost, srv.URL+"/users", UserRequest{Email: "alice@example.com", Name: "Another Alice"})
+		expectStatus(t, resp, http.StatusConflict)
+
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Expected Content-Type application/json, got: %s", ct)
+		}
+
+		var body map[string]interface{}
+		decode(t, resp, &body)
+		if len(body) != 1 {
+			t.Errorf("Expected exactly one field in error body, got: %v", body)
+		}
+		if body["error"] != repository.ErrDuplicateEmail.Error() {
+			t.Errorf("Expected error %q, got: %v", repository.ErrDuplicateEmail.Error(), body["error"])
+		}
+	})
+
+	t.Run("Update To Duplicate Email", func(t *testing.T) {
+		resp := do(t, http.MethodPut, srv.URL+"/users/2", UserRequest{Email: "alice@example.com", Name: "Bob Johnson"})
+		expectStatus(t, resp, http.StatusConflict)
+	})
+
+	t.Run("Delete User With Orders", func(t *testing.T) {
+		resp := do(t, http.MethodPost, srv.URL+"/users", UserRequest{Email: "has.orders@example.com", Name: "Has Orders"})
+		expectStatus(t, resp, http.StatusCreated)
+		var created UserResponse
+		decode(t, resp, &created)
+		if _, err := repository.NewOrderRepository(testDB).Create(context.Background(), created.ID, 1500); err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+
+		userURL := fmt.Sprintf("%s/users/%d", srv.URL, created.ID)
+		resp = do(t, http.MethodDelete, userURL, nil)
+		expectStatus(t, resp, http.StatusConflict)
+		var body ErrorResponse
+		decode(t, resp, &body)
+		if body.Error != repository.ErrHasOrders.Error() {
+			t.Errorf("Expected error %q, got: %q", repository.ErrHasOrders.Error(), body.Error)
+		}
+
+		resp = do(t, http.MethodGet, userURL, nil)
+		expectStatus(t, resp, http.StatusOK)
+	})
+
+	t.Run("User Not Found", func(t *testing.T) {
+		for _, method := range []string{http.MethodGet, http.MethodDelete} {
+			resp := do(t, method, srv.URL+"/users/9999", nil)
+			expectStatus(t, resp, http.StatusNotFound)
+
+			var body ErrorResponse
+			decode(t, resp, &body)
+			if body.Error != repository.ErrUserNotFound.Error() {
+				t.Errorf("%s: expected error %q, got: %q", method, repository.ErrUserNotFound.Error(), body.Error)
+			}
+		}
+
+		resp := do(t, http.MethodPut, srv.URL+"/users/9999", UserRequest{Email: "nobody@example.com", Name: "Nobody"})
+		expectStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("Invalid ID", func(t *testing.T) {
+		resp := do(t, http.MethodGet, srv.URL+"/users/abc", nil)
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("Validation Failures", func(t *testing.T) {
+		cases := map[string]UserRequest{
+			"missing email": {Name: "No Email"},
+			"missing name":  {Email: "noname@example.com"},
+			"blank name":    {Email: "blank@example.com", Name: "   "},
+		}
+		for name, req := range cases {
+			t.Run(name, func(t *testing.T) {
+				resp := do(t, http.MethodPost, srv.URL+"/users", req)
+				expectStatus(t, resp, http.StatusBadRequest)
+
+				var body ErrorResponse
+				decode(t, resp, &body)
+				if body.Error == "" {
+					t.Error("Expected error message in body")
+				}
+			})
+		}
+	})
+
+	t.Run("Malformed Body", func(t *testing.T) {
+		resp, err := http.Post(srv.URL+"/users", "application/json", bytes.NewBufferString("{not json"))
+		if err != nil {
+			t.Fatalf("POST failed: %v", err)
+		}
+		defer resp.Body.Close()
+		expectStatus(t, resp, http.StatusBadRequest)
+	})
+}
+
+// TestUUIDPaths tests addressing users by UUID as well as by integer ID
+func TestUUIDPaths(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	resp := do(t, http.MethodPost, srv.URL+"/users", UserRequest{Email: "uuid.path@example.com", Name: "UUID Path"})
+	expectStatus(t, resp, http.StatusCreated)
+	var created UserResponse
+	decode(t, resp, &created)
+	uuidURL := srv.URL + "/users/" + created.UUID.String()
+
+	t.Run("Get", func(t *testing.T) {
+		resp := do(t, http.MethodGet, uuidURL, nil)
+		expectStatus(t, resp, http.StatusOK)
+
+		var user UserResponse
+		decode(t, resp, &user)
+		if user.ID != created.ID || user.UUID != created.UUID {
+			t.Errorf("Expected user %d (%s), got: %+v", created.ID, created.UUID, user)
+		}
+	})
+
+	t.Run("Unknown UUID", func(t *testing.T) {
+		resp := do(t, http.MethodGet, srv.URL+"/users/00000000-0000-0000-0000-000000000000", nil)
+		expectStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		resp := do(t, http.MethodDelete, uuidURL, nil)
+		expectStatus(t, resp, http.StatusNoContent)
+
+		resp = do(t, http.MethodGet, fmt.Sprintf("%s/users/%d", srv.URL, created.ID), nil)
+		expectStatus(t, resp, http.StatusNotFound)
+	})
+}
+
+// TestEmailAvailable tests the signup form's email check
+func TestEmailAvailable(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	check := func(t *testing.T, email string) EmailAvailableResponse {
+		t.Helper()
+		resp := do(t, http.MethodGet, srv.URL+"/users/email-available?email="+url.QueryEscape(email), nil)
+		expectStatus(t, resp, http.StatusOK)
+		var body EmailAvailableResponse
+		decode(t, resp, &body)
+		return body
+	}
+
+	if body := check(t, " Alice@Example.com"); body.Available || body.Email != "alice@example.com" {
+		t.Errorf("Expected alice@example.com to be taken, got: %+v", body)
+	}
+	if body := check(t, "signup@example.com"); !body.Available {
+		t.Errorf("Expected signup@example.com to be available, got: %+v", body)
+	}
+
+	resp := do(t, http.MethodGet, srv.URL+"/users/email-available", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+	resp = do(t, http.MethodGet, srv.URL+"/users/email-available?email=not-an-email", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+// TestUserStats tests the dashboard's stats over a range seeded with known
+// signup days, and the 400 for a malformed day
+func TestUserStats(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	// June 2019, which no other test's users fall in
+	day := func(d int) time.Time { return time.Date(2019, time.June, d, 12, 0, 0, 0, time.UTC) }
+	fixtures.SeedUsers(t, testDB,
+		fixtures.NewUser().WithEmail("one@stats.test").WithCreatedAt(day(1)),
+		fixtures.NewUser().WithEmail("two@stats.test").WithCreatedAt(day(3)),
+		fixtures.NewUser().WithEmail("three@other.test").WithCreatedAt(day(3)),
+	)
+
+	resp := do(t, http.MethodGet, srv.URL+"/admin/stats?from=2019-06-01&to=2019-06-03", nil)
+	expectStatus(t, resp, http.StatusOK)
+	var stats repository.UserStats
+	decode(t, resp, &stats)
+
+	var signups []int
+	for _, d := range stats.Days {
+		signups = append(signups, d.Signups)
+	}
+	if !slices.Equal(signups, []int{1, 0, 2}) || stats.Total != 3 {
+		t.Errorf("Expected signups [1 0 2] totalling 3, got: %v totalling %d", signups, stats.Total)
+	}
+	if stats.Busiest == nil || !stats.Busiest.Day.Equal(time.Date(2019, time.June, 3, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected June 3rd busiest, got: %+v", stats.Busiest)
+	}
+	if want := []repository.DomainCount{{Domain: "stats.test", Users: 2}, {Domain: "other.test", Users: 1}}; !slices.Equal(stats.Domains, want) {
+		t.Errorf("Expected domains %v, got: %v", want, stats.Domains)
+	}
+
+	resp = do(t, http.MethodGet, srv.URL+"/admin/stats?from=June", nil)
+	expectStatus(t, resp, http.StatusBadRequest)
+}
+
+// TestUserResponse tests that responses carry only the public fields, with
+// created_at in RFC 3339 UTC, both from the conversion and over HTTP
+func TestUserResponse(t *testing.T) {
+	requirePostgres(t)
+	publicKeys := []string{"created_at", "email", "id", "name", "role", "uuid"}
+	// object decodes a JSON object
+	object := func(t *testing.T, data []byte) map[string]interface{} {
+		t.Helper()
+		var fields map[string]interface{}
+		if err := json.Unmarshal(data, &fields); err != nil {
+			t.Fatalf("Failed to decode %s: %v", data, err)
+		}
+		return fields
+	}
+	expectPublic := func(t *testing.T, fields map[string]interface{}) {
+		t.Helper()
+		var got []string
+		for key := range fields {
+			got = append(got, key)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, publicKeys) {
+			t.Errorf("Expected keys %v, got: %v", publicKeys, got)
+		}
+	}
+
+	t.Run("Conversion", func(t *testing.T) {
+		user := models.User{
+			ID:           7,
+			Email:        "dto@example.com",
+			Name:         "DTO User",
+			Role:         models.RoleAdmin,
+			CreatedAt:    time.Date(2024, 6, 30, 23, 59, 59, 123456789, time.FixedZone("NPT", 5*3600+45*60)),
+			AvatarKey:    "avatars/7",
+			Version:      4,
+			Erased:       true,
+			PasswordHash: "$2a$10$secret",
+		}
+		data, err := json.Marshal(NewUserResponse(user))
+		if err != nil {
+			t.Fatalf("Failed to marshal response: %v", err)
+		}
+		fields := object(t, data)
+		expectPublic(t, fields)
+		if got := fields["created_at"]; got != "2024-06-30T18:14:59Z" {
+			t.Errorf("Expected created_at 2024-06-30T18:14:59Z, got: %v", got)
+		}
+		if got := NewUserResponses(nil); got == nil || len(got) != 0 {
+			t.Errorf("Expected an empty list for no users, got: %#v", got)
+		}
+	})
+
+	t.Run("Over HTTP", func(t *testing.T) {
+		srv := newTestServer(t)
+		resp := do(t, http.MethodPost, srv.URL+"/users", UserRequest{Email: "dto.http@example.com", Name: "DTO HTTP"})
+		expectStatus(t, resp, http.StatusCreated)
+		var created UserResponse
+		decode(t, resp, &created)
+
+		// GetByID reads the version and avatar key, so this response would carry them
+		resp = do(t, http.MethodGet, fmt.Sprintf("%s/users/%d", srv.URL, created.ID), nil)
+		expectStatus(t, resp, http.StatusOK)
+		var raw json.RawMessage
+		decode(t, resp, &raw)
+		fields := object(t, raw)
+		expectPublic(t, fields)
+		if _, err := time.Parse(time.RFC3339, fields["created_at"].(string)); err != nil {
+			t.Errorf("Expected an RFC 3339 created_at, got: %v", fields["created_at"])
+		}
+	})
+}
+
+// TestIdempotencyKey tests that POST /users retried with the same
+// Idempotency-Key returns the user the first request created
+func TestIdempotencyKey(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+
+	// post creates a user with the Idempotency-Key header set to key
+	post := func(t *testing.T, key string, body UserRequest) *http.Response {
+		t.Helper()
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatalf("Failed to encode body: %v", err)
+		}
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/users", bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Failed to build request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST /users failed: %v", err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	// Generated emails are unique per run, which a key must be too
+	key := "create-" + fixtures.GenerateEmail(t)
+	body := UserRequest{Email: fixtures.GenerateEmail(t), Name: "Retried User"}
+	resp := post(t, key, body)
+	expectStatus(t, resp, http.StatusCreated)
+	var first UserResponse
+	decode(t, resp, &first)
+
+	resp = post(t, key, body)
+	expectStatus(t, resp, http.StatusCreated)
+	var again UserResponse
+	decode(t, resp, &again)
+	if again.ID != first.ID {
+		t.Errorf("Expected the retry to return user %d, got: %d", first.ID, again.ID)
+	}
+
+	resp = post(t, key, UserRequest{Email: body.Email, Name: "Someone Else"})
+	expectStatus(t, resp, http.StatusUnprocessableEntity)
+}
+
+// TestListEndpoints walks every list endpoint a page at a time: the pages
+// must add up to the whole list in its order, with no user twice
+func TestListEndpoints(t *testing.T) {
+	requirePostgres(t)
+	srv := newTestServer(t)
+	for i := 0; i < 5; i++ {
+		resp := do(t, http.MethodPost, srv.URL+"/users", UserRequest{Email: fixtures.GenerateEmail(t), Name: "Paged User"})
+		expectStatus(t, resp, http.StatusCreated)
+	}
+
+	for name, tc := range map[string]struct {
+		path  string
+		order func(a, b UserResponse) bool // whether a may come before b
+	}{
+		"Users": {"/users?", func(a, b UserResponse) bool { return a.ID < b.ID }},
+		"Users By Role": {"/users?role=member&", func(a, b UserResponse) bool {
+			return a.ID < b.ID && a.Role == models.RoleMember && b.Role == models.RoleMember
+		}},
+		// Newest first; created_at is to the second, so ties can't be told apart
+		"Recent Users": {"/users/recent?days=1&", func(a, b UserResponse) bool { return !a.CreatedAt.Before(b.CreatedAt) }},
+	} {
+		t.Run(name, func(t *testing.T) {
+			all := walkPages(t, srv.URL+tc.path+"limit="+strconv.Itoa(MaxPageSize))
+			if len(all) < 5 {
+				t.Fatalf("Expected at least the 5 new users, got: %d", len(all))
+			}
+			for i := 1; i < len(all); i++ {
+				if !tc.order(all[i-1], all[i]) {
+					t.Fatalf("Expected user %d before %d", all[i-1].ID, all[i].ID)
+				}
+			}
+
+			for _, limit := range []int{1, 2, 3} {
+				paged := walkPages(t, srv.URL+tc.path+"limit="+strconv.Itoa(limit))
+				// created_at is truncated to the second, so compare IDs
+				if !slices.EqualFunc(paged, all, func(a, b UserResponse) bool { return a.ID == b.ID }) {
+					t.Errorf("Limit %d: expected the pages to walk the same %d users, got %d", limit, len(all), len(paged))
+				}
+			}
+
+			for _, query := range []string{"cursor=not-a-cursor", "limit=0", "limit=" + strconv.Itoa(MaxPageSize+1), "limit=ten"} {
+				resp := do(t, http.MethodGet, srv.URL+tc.path+query, nil)
+				expectStatus(t, resp, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+// Package audit records who changed which user in the audit_log table. Its
+// Repository wraps the writes of a repository.UserRepository so every change
+// and its audit entry commit or roll back together.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+)
+
+// Action is the kind of write an Entry records
+type Action string
+
+const (
+	ActionCreate Action = "create"
+	ActionUpdate Action = "update"
+	ActionDelete Action = "delete"
+)
+
+// ErrMissingActor is returned for a write without an actor; every audit
+// entry has to name who made the change
+var ErrMissingActor = errors.New("audit: actor is required")
+
+// Entry is one row of audit_log. OldRow is nil for a create and NewRow is
+// nil for a delete.
+type Entry struct {
+	ID        int64
+	UserID    int
+	Action    Action
+	OldRow    *models.User
+	NewRow    *models.User
+	Actor     string
+	CreatedAt time.Time
+}
+
+// Repository writes users through a UserRepository and records an Entry for
+// each write in the same transaction. Without WithTx each write runs in a
+// transaction of its own; writes are then not retried, because a transaction
+// is retried as a whole (see UserRepository.WithTx).
+type Repository struct {
+	db    *sql.DB
+	users *repository.UserRepository
+
+	// tx is the caller's transaction, set by WithTx
+	tx *sql.Tx
+}
+
+// NewRepository creates an audited repository over users, beginning its
+// transactions on db
+func NewRepository(db *sql.DB, users *repository.UserRepository) *Repository {
+	return &Repository{db: db, users: users}
+}
+
+// WithTx returns a copy of the repository whose writes and audit entries run
+// inside tx; committing or rolling back tx is up to the caller
+func (r *Repository) WithTx(tx *sql.Tx) *Repository {
+	return &Repository{db: r.db, users: r.users, tx: tx}
+}
+
+// Create inserts a new user on behalf of actor
+func (r *Repository) Create(ctx context.Context, actor, email, name string) (*models.User, error) {
+	var user *models.User
+	err := r.inTx(ctx, actor, func(tx *sql.Tx, users *repository.UserRepository) (err error) {
+		if user, err = users.Create(ctx, email, name); err != nil {
+			return err
+		}
+		return insertEntry(ctx, tx, user.ID, ActionCreate, nil, user, actor)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Update modifies a user's email and name on behalf of actor
+func (r *Repository) Update(ctx context.Context, actor string, id int, email, name string) error {
+	return r.inTx(ctx, actor, func(tx *sql.Tx, users *repository.UserRepository) error {
+		old, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := users.Update(ctx, id, email, name); err != nil {
+			return err
+		}
+		updated, err := users.GetByID(ctx, id)
+		if err != nil {
+			return err
+		}
+		return insertEntry(ctx, tx, id, ActionUpdate, old, updated, actor)
+	})
+}
+
+// Delete removes a user on behalf of actor
+func (r *Repository) Delete(ctx context.Context, actor string, id int) error {
+	return r.inTx(ctx, actor, func(tx *sql.Tx, users *repository.UserRepository) error {
+		old, err := lockUser(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+		if err := users.Delete(ctx, id); err != nil {
+			return err
+		}
+		return insertEntry(ctx, tx, id, ActionDelete, old, nil, actor)
+	})
+}
+
+// GetAuditTrail returns the entries recorded for userID, newest first. The
+// trail of a deleted user is kept.
+func (r *Repository) GetAuditTrail(ctx context.Context, userID int) ([]Entry, error) {
+	query := `
+		SELECT id, user_id, action, old_row, new_row, actor, created_at
+		FROM audit_log
+		WHERE user_id = $1
+		ORDER BY id DESC
+	`
+	var rows *sql.Rows
+	var err error
+	if r.tx != nil {
+		rows, err = r.tx.QueryContext(ctx, query, userID)
+	} else {
+		rows, err = r.db.QueryContext(ctx, query, userID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit trail of user %d: %w", userID, err)
+	}
+	defer rows.Close()
+
+	entries := []Entry{}
+	for rows.Next() {
+		var entry Entry
+		var oldRow, newRow []byte
+		err := rows.Scan(&entry.ID, &entry.UserID, &entry.Action, &oldRow, &newRow, &entry.Actor, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		if entry.OldRow, err = decodeRow(oldRow); err != nil {
+			return nil, fmt.Errorf("failed to decode old row of audit entry %d: %w", entry.ID, err)
+		}
+		if entry.NewRow, err = decodeRow(newRow); err != nil {
+			return nil, fmt.Errorf("failed to decode new row of audit entry %d: %w", entry.ID, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit entries: %w", err)
+	}
+
+	return entries, nil
+}
+
+// inTx runs fn in the caller's transaction, or in a new one that is committed
+// if fn succeeds. users is bound to the same transaction.
+func (r *Repository) inTx(ctx context.Context, actor string, fn func(tx *sql.Tx, users *repository.UserRepository) error) error {
+	if actor == "" {
+		return ErrMissingActor
+	}
+	if r.tx != nil {
+		return fn(r.tx, r.users.WithTx(r.tx))
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx, r.users.WithTx(tx)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// lockUser reads user id and locks the row until the end of tx, so the old
+// row recorded is the one the write replaces. A missing user is returned as
+// nil; the write that follows then reports repository.ErrUserNotFound.
+func lockUser(ctx context.Context, tx *sql.Tx, id int) (*models.User, error) {
+	var user models.User
+	err := tx.QueryRowContext(ctx,
+		"SELECT id, uuid, email, COALESCE(name, ''), role, created_at FROM users WHERE id = $1 FOR UPDATE", id,
+	).Scan(&user.ID, &user.UUID, &user.Email, &user.Name, &user.Role, &user.CreatedAt)
+
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock user %d: %w", id, err)
+	}
+	// As the repository scans it, whatever the session's TimeZone
+	user.CreatedAt = user.CreatedAt.UTC()
+	return &user, nil
+}
+
+// insertEntry records a write of user userID made by actor
+func insertEntry(ctx context.Context, tx *sql.Tx, userID int, action Action, old, updated *models.User, actor string) error {
+	oldRow, err := encodeRow(old)
+	if err != nil {
+		return err
+	}
+	newRow, err := encodeRow(updated)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO audit_log (user_id, action, old_row, new_row, actor) VALUES ($1, $2, $3, $4, $5)",
+		userID, action, oldRow, newRow, actor,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record audit entry for user %d: %w", userID, err)
+	}
+	return nil
+}
+
+// encodeRow marshals user for a jsonb column; nil becomes SQL NULL
+func encodeRow(user *models.User) (interface{}, error) {
+	if user == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode user %d: %w", user.ID, err)
+	}
+	return string(data), nil
+}
+
+// decodeRow unmarshals a jsonb column written by encodeRow
+func decodeRow(data []byte) (*models.User, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var user models.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,188 @@
+package audit_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+
+	"testcontainers-demo/audit"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// newRepository returns an audited repository backed by a fresh database
+func newRepository(ctx context.Context, t *testing.T) (*audit.Repository, *sql.DB) {
+	t.Helper()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	users := repository.NewUserRepository(db)
+	t.Cleanup(func() { users.Close() })
+	return audit.NewRepository(db, users), db
+}
+
+// TestAuditTrail tests that every write records its old and new row, newest first
+func TestAuditTrail(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	repo, _ := newRepository(ctx, t)
+
+	user, err := repo.Create(ctx, "admin@example.com", "audited@example.com", "Audited User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Run("Two Updates", func(t *testing.T) {
+		if err := repo.Update(ctx, "alice", user.ID, "first@example.com", "Audited User"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if err := repo.Update(ctx, "bob", user.ID, "second@example.com", "Audited User"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		entries, err := repo.GetAuditTrail(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get audit trail: %v", err)
+		}
+
+		var updates []audit.Entry
+		for _, entry := range entries {
+			if entry.Action == audit.ActionUpdate {
+				updates = append(updates, entry)
+			}
+		}
+		if len(updates) != 2 {
+			t.Fatalf("Expected 2 update entries, got: %+v", entries)
+		}
+
+		// Newest first
+		second, first := updates[0], updates[1]
+		if first.Actor != "alice" || first.OldRow.Email != "audited@example.com" || first.NewRow.Email != "first@example.com" {
+			t.Errorf("Expected alice's change from audited@ to first@, got: %s %+v -> %+v", first.Actor, first.OldRow, first.NewRow)
+		}
+		if second.Actor != "bob" || second.OldRow.Email != "first@example.com" || second.NewRow.Email != "second@example.com" {
+			t.Errorf("Expected bob's change from first@ to second@, got: %s %+v -> %+v", second.Actor, second.OldRow, second.NewRow)
+		}
+		if second.ID <= first.ID {
+			t.Errorf("Expected newest entry first, got IDs %d then %d", second.ID, first.ID)
+		}
+	})
+
+	t.Run("Create And Delete", func(t *testing.T) {
+		if err := repo.Delete(ctx, "carol", user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		entries, err := repo.GetAuditTrail(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get audit trail: %v", err)
+		}
+		if len(entries) != 4 {
+			t.Fatalf("Expected 4 entries, got: %d", len(entries))
+		}
+
+		deleted, created := entries[0], entries[3]
+		if deleted.Action != audit.ActionDelete || deleted.NewRow != nil || deleted.OldRow == nil || deleted.OldRow.Email != "second@example.com" {
+			t.Errorf("Expected the delete of second@example.com, got: %+v", deleted)
+		}
+		if created.Action != audit.ActionCreate || created.OldRow != nil || created.NewRow == nil || created.NewRow.UUID != user.UUID {
+			t.Errorf("Expected the create of %s, got: %+v", user.UUID, created)
+		}
+		if created.Actor != "admin@example.com" {
+			t.Errorf("Expected actor admin@example.com, got: %q", created.Actor)
+		}
+	})
+
+	t.Run("Failed Writes Record Nothing", func(t *testing.T) {
+		if err := repo.Update(ctx, "alice", 99999, "ghost@example.com", "Ghost"); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if err := repo.Update(ctx, "alice", 2, "alice@example.com", "Bob Johnson"); !errors.Is(err, repository.ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		if err := repo.Delete(ctx, "", 1); !errors.Is(err, audit.ErrMissingActor) {
+			t.Fatalf("Expected ErrMissingActor, got: %v", err)
+		}
+
+		for _, id := range []int{1, 2, 99999} {
+			entries, err := repo.GetAuditTrail(ctx, id)
+			if err != nil {
+				t.Fatalf("Failed to get audit trail: %v", err)
+			}
+			if len(entries) != 0 {
+				t.Errorf("Expected no entries for user %d, got: %+v", id, entries)
+			}
+		}
+	})
+}
+
+// TestAuditRolledBackTx tests that rolling back the caller's transaction
+// discards the audit entries along with the writes
+func TestAuditRolledBackTx(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	repo, db := newRepository(ctx, t)
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	txRepo := repo.WithTx(tx)
+
+	if err := txRepo.Update(ctx, "alice", 1, "rolled.back@example.com", "Alice Smith"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	created, err := txRepo.Create(ctx, "alice", "new.rolled.back@example.com", "Rolled Back")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// The transaction sees its own entries
+	entries, err := txRepo.GetAuditTrail(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to get audit trail in transaction: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Expected 1 entry inside the transaction, got: %d", len(entries))
+	}
+
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Failed to roll back: %v", err)
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM audit_log").Scan(&count); err != nil {
+		t.Fatalf("Failed to count audit entries: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected no audit entries after rollback, got: %d", count)
+	}
+	for _, id := range []int{1, created.ID} {
+		entries, err := repo.GetAuditTrail(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to get audit trail: %v", err)
+		}
+		if len(entries) != 0 {
+			t.Errorf("Expected no entries for user %d, got: %+v", id, entries)
+		}
+	}
+}
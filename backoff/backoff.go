@@ -0,0 +1,30 @@
+// Package backoff computes the wait between retries for every package that
+// retries. The wait doubles per attempt up to a cap, so no number of
+// attempts can overflow it into a busy loop, and is jittered so callers
+// that failed together don't retry together.
+package backoff
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// Delay returns the wait before retry number attempt, counting from 1:
+// base doubled per attempt up to maxDelay, with the upper half randomized.
+// It is 0 if base or maxDelay is.
+func Delay(attempt int, base, maxDelay time.Duration) time.Duration {
+	d := min(base, maxDelay)
+	for i := 1; i < attempt && d < maxDelay; i++ {
+		// Doubling past half of maxDelay could overflow
+		if d > maxDelay/2 {
+			d = maxDelay
+		} else {
+			d *= 2
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(half+1)
+}
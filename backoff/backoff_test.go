@@ -0,0 +1,38 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDelay tests that delays double per attempt within the jitter bounds,
+// stop at the cap, and never overflow however many attempts there are
+func TestDelay(t *testing.T) {
+	const base, maxDelay = 10 * time.Millisecond, time.Second
+
+	for _, tc := range []struct {
+		attempt int
+		full    time.Duration
+	}{
+		{1, base},
+		{2, 2 * base},
+		{4, 8 * base},
+		{7, 640 * time.Millisecond},
+		{8, maxDelay},
+		{64, maxDelay},
+		{1 << 20, maxDelay},
+	} {
+		for range 50 {
+			if d := Delay(tc.attempt, base, maxDelay); d < tc.full/2 || d > tc.full {
+				t.Fatalf("Attempt %d: expected delay in [%s, %s], got: %s", tc.attempt, tc.full/2, tc.full, d)
+			}
+		}
+	}
+
+	if d := Delay(1<<20, time.Second, time.Duration(1<<63-1)); d <= 0 {
+		t.Errorf("Expected a positive delay up to the largest cap, got: %s", d)
+	}
+	if d := Delay(3, 0, maxDelay); d != 0 {
+		t.Errorf("Expected no delay without a base, got: %s", d)
+	}
+}
@@ -0,0 +1,130 @@
+// Package cache holds the Redis helpers shared by the cached repository and
+// maintenance jobs
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	// ErrLockNotAcquired is returned when another holder has the lock and
+	// the wait, if any, ran out
+	ErrLockNotAcquired = errors.New("lock not acquired")
+
+	// ErrLockLost is returned when the lock expired while fn was running, so
+	// another holder may have run concurrently; the other holder's lock is
+	// left alone
+	ErrLockLost = errors.New("lock expired before release")
+)
+
+// releaseScript deletes the lock only if it still holds our token, so a
+// holder whose TTL ran out can't delete the lock someone else acquired since
+var releaseScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0
+`)
+
+// Locker takes single-instance Redis locks (SET NX PX) for exclusive
+// per-user work
+type Locker struct {
+	client        *redis.Client
+	wait          time.Duration
+	retryInterval time.Duration
+}
+
+// LockerOption configures a Locker
+type LockerOption func(*Locker)
+
+// WithLockWait makes a contended acquire retry every interval for up to wait
+// before giving up with ErrLockNotAcquired, instead of failing immediately
+func WithLockWait(wait, interval time.Duration) LockerOption {
+	return func(l *Locker) {
+		l.wait = wait
+		l.retryInterval = interval
+	}
+}
+
+// NewLocker creates a Locker on client
+func NewLocker(client *redis.Client, opts ...LockerOption) *Locker {
+	l := &Locker{client: client, retryInterval: 50 * time.Millisecond}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// userLockKey is the Redis key of userID's lock
+func userLockKey(userID int) string {
+	return fmt.Sprintf("lock:user:%d", userID)
+}
+
+// WithUserLock runs fn while holding userID's lock for at most ttl. The ctx
+// passed to fn is cancelled when the lock expires, so long-running work can
+// stop before it overlaps the next holder. It returns ErrLockNotAcquired if
+// the lock is held elsewhere, fn's error, or ErrLockLost if fn outlived ttl.
+func (l *Locker) WithUserLock(ctx context.Context, userID int, ttl time.Duration, fn func(ctx context.Context) error) error {
+	key := userLockKey(userID)
+	token, err := newToken()
+	if err != nil {
+		return err
+	}
+
+	if err := l.acquire(ctx, key, token, ttl); err != nil {
+		return fmt.Errorf("user %d: %w", userID, err)
+	}
+
+	fnCtx, cancel := context.WithTimeout(ctx, ttl)
+	fnErr := fn(fnCtx)
+	cancel()
+
+	// Release even if ctx is done, so the lock doesn't sit until its TTL
+	released, err := releaseScript.Run(context.WithoutCancel(ctx), l.client, []string{key}, token).Int()
+	switch {
+	case err != nil:
+		err = fmt.Errorf("user %d: failed to release lock: %w", userID, err)
+	case released == 0:
+		err = fmt.Errorf("user %d: %w", userID, ErrLockLost)
+	}
+	return errors.Join(fnErr, err)
+}
+
+// acquire sets key to token if it's free, retrying for up to l.wait
+func (l *Locker) acquire(ctx context.Context, key, token string, ttl time.Duration) error {
+	deadline := time.Now().Add(l.wait)
+	for {
+		ok, err := l.client.SetNX(ctx, key, token, ttl).Result()
+		if err != nil {
+			return fmt.Errorf("failed to acquire lock: %w", err)
+		}
+		if ok {
+			return nil
+		}
+		if !time.Now().Add(l.retryInterval).Before(deadline) {
+			return ErrLockNotAcquired
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.retryInterval):
+		}
+	}
+}
+
+// newToken returns a random value identifying one acquisition of a lock
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
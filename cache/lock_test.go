@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"testcontainers-demo/testhelpers"
+)
+
+// TestWithUserLock tests mutual exclusion between contending holders
+func TestWithUserLock(t *testing.T) {
+	ctx := context.Background()
+	client := testhelpers.StartRedis(ctx, t)
+
+	// contend runs 20 goroutines through locker for user 1, each holding the
+	// lock for a few milliseconds, and returns how many ran fn, how many were
+	// turned away, and the most that were ever inside fn at once
+	contend := func(t *testing.T, locker *Locker) (ran, refused, maxActive int64) {
+		var active int64
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				err := locker.WithUserLock(ctx, 1, 5*time.Second, func(context.Context) error {
+					n := atomic.AddInt64(&active, 1)
+					for {
+						peak := atomic.LoadInt64(&maxActive)
+						if n <= peak || atomic.CompareAndSwapInt64(&maxActive, peak, n) {
+							break
+						}
+					}
+					time.Sleep(5 * time.Millisecond)
+					atomic.AddInt64(&active, -1)
+					atomic.AddInt64(&ran, 1)
+					return nil
+				})
+				switch {
+				case errors.Is(err, ErrLockNotAcquired):
+					atomic.AddInt64(&refused, 1)
+				case err != nil:
+					t.Errorf("Unexpected error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+		return ran, refused, maxActive
+	}
+
+	t.Run("Fail Fast", func(t *testing.T) {
+		ran, refused, maxActive := contend(t, NewLocker(client))
+		if maxActive != 1 {
+			t.Errorf("Expected at most one holder at a time, got: %d", maxActive)
+		}
+		if ran < 1 || ran+refused != 20 {
+			t.Errorf("Expected at least one run and the rest refused, got %d ran and %d refused", ran, refused)
+		}
+	})
+
+	t.Run("Bounded Wait", func(t *testing.T) {
+		ran, refused, maxActive := contend(t, NewLocker(client, WithLockWait(10*time.Second, 2*time.Millisecond)))
+		if maxActive != 1 {
+			t.Errorf("Expected at most one holder at a time, got: %d", maxActive)
+		}
+		if ran != 20 || refused != 0 {
+			t.Errorf("Expected all 20 to run in turn, got %d ran and %d refused", ran, refused)
+		}
+	})
+
+	t.Run("Wait Runs Out", func(t *testing.T) {
+		locker := NewLocker(client, WithLockWait(50*time.Millisecond, 10*time.Millisecond))
+		release := make(chan struct{})
+		held := make(chan struct{})
+		go locker.WithUserLock(ctx, 2, 5*time.Second, func(context.Context) error {
+			close(held)
+			<-release
+			return nil
+		})
+		<-held
+		defer close(release)
+
+		start := time.Now()
+		err := locker.WithUserLock(ctx, 2, time.Second, func(context.Context) error {
+			t.Error("Expected fn not to run while the lock is held")
+			return nil
+		})
+		if !errors.Is(err, ErrLockNotAcquired) {
+			t.Errorf("Expected ErrLockNotAcquired, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the wait to be bounded, took: %s", elapsed)
+		}
+	})
+
+	t.Run("Other Users Independent", func(t *testing.T) {
+		locker := NewLocker(client)
+		err := locker.WithUserLock(ctx, 3, time.Second, func(ctx context.Context) error {
+			return locker.WithUserLock(ctx, 4, time.Second, func(context.Context) error { return nil })
+		})
+		if err != nil {
+			t.Errorf("Expected locks on different users not to contend, got: %v", err)
+		}
+	})
+
+	t.Run("Error From Fn", func(t *testing.T) {
+		errJob := errors.New("recompute failed")
+		locker := NewLocker(client)
+		if err := locker.WithUserLock(ctx, 5, time.Second, func(context.Context) error { return errJob }); !errors.Is(err, errJob) {
+			t.Errorf("Expected fn's error, got: %v", err)
+		}
+		if n, _ := client.Exists(ctx, userLockKey(5)).Result(); n != 0 {
+			t.Error("Expected the lock to be released after fn failed")
+		}
+	})
+}
+
+// TestWithUserLockExpiry tests that a holder outliving its TTL doesn't
+// release the lock the next holder acquired
+func TestWithUserLockExpiry(t *testing.T) {
+	ctx := context.Background()
+	client := testhelpers.StartRedis(ctx, t)
+	locker := NewLocker(client)
+
+	secondHolding := make(chan struct{})
+	firstDone := make(chan struct{})
+	secondErr := make(chan error, 1)
+
+	firstErr := locker.WithUserLock(ctx, 1, 100*time.Millisecond, func(fnCtx context.Context) error {
+		<-fnCtx.Done()
+		if !errors.Is(fnCtx.Err(), context.DeadlineExceeded) {
+			t.Errorf("Expected fn's ctx to end with the TTL, got: %v", fnCtx.Err())
+		}
+
+		// Let Redis expire the key, then let someone else take the lock
+		time.Sleep(50 * time.Millisecond)
+		go func() {
+			secondErr <- locker.WithUserLock(ctx, 1, 10*time.Second, func(context.Context) error {
+				close(secondHolding)
+				<-firstDone
+				if n, _ := client.Exists(ctx, userLockKey(1)).Result(); n != 1 {
+					t.Error("Expected the stale holder to leave the new lock in place")
+				}
+				return nil
+			})
+		}()
+		select {
+		case <-secondHolding:
+		case <-time.After(5 * time.Second):
+			t.Error("Expected the lock to be free after its TTL")
+		}
+		return nil
+	})
+	close(firstDone)
+
+	if !errors.Is(firstErr, ErrLockLost) {
+		t.Errorf("Expected ErrLockLost for the stale release, got: %v", firstErr)
+	}
+	if err := <-secondErr; err != nil {
+		t.Errorf("Expected the second holder to release cleanly, got: %v", err)
+	}
+}
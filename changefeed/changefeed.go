@@ -0,0 +1,194 @@
+// Package changefeed pushes user changes to subscribers as they commit,
+// through Postgres LISTEN/NOTIFY. Migration 0020 makes every write to users
+// notify the user_changes channel; a Listener holds a connection listening
+// on it and delivers each notification as a UserChange.
+//
+// NOTIFY is fire and forget: a notification sent while no connection is
+// listening is lost. So a Listener that reconnects calls its resync
+// callback, where a subscriber re-reads whatever it keeps in step. For
+// changes that must not be missed, use the user_events outbox instead.
+package changefeed
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"testcontainers-demo/backoff"
+	"testcontainers-demo/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Channel is the channel migration 0020's trigger notifies
+const Channel = "user_changes"
+
+// Defaults for NewListener's options
+const (
+	DefaultMinBackoff = 100 * time.Millisecond
+	DefaultMaxBackoff = 10 * time.Second
+	DefaultBuffer     = 64
+)
+
+// UserChange is one committed write to a user, as the trigger reports it.
+// Type is user.created, user.updated, or user.deleted; Version is the
+// user's version after the write, or before it for user.deleted.
+type UserChange struct {
+	Type     models.EventType `json:"type"`
+	UserID   int              `json:"user_id"`
+	TenantID string           `json:"tenant_id"`
+	Version  int              `json:"version"`
+}
+
+// Listener subscribes to the user_changes channel of one database
+type Listener struct {
+	dsn        func() string
+	resync     func(ctx context.Context)
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	buffer     int
+}
+
+// Option configures a Listener
+type Option func(*Listener)
+
+// WithResync sets the callback a subscription runs each time it has
+// reconnected, before it delivers anything more: the changes made while it
+// was down are lost, so fn should bring the subscriber back in step, e.g.
+// by invalidating a cache. Changes committed while fn runs are delivered
+// after it.
+func WithResync(fn func(ctx context.Context)) Option {
+	return func(l *Listener) {
+		l.resync = fn
+	}
+}
+
+// WithReconnectBackoff sets the wait before the first reconnect attempt,
+// doubled, with jitter, after each failed one up to maxBackoff
+func WithReconnectBackoff(minBackoff, maxBackoff time.Duration) Option {
+	return func(l *Listener) {
+		l.minBackoff = minBackoff
+		l.maxBackoff = maxBackoff
+	}
+}
+
+// WithBuffer sets the capacity of the channel Subscribe returns
+func WithBuffer(n int) Option {
+	return func(l *Listener) {
+		l.buffer = n
+	}
+}
+
+// NewListener returns a listener on the database at dsn
+func NewListener(dsn string, opts ...Option) *Listener {
+	return NewListenerFunc(func() string { return dsn }, opts...)
+}
+
+// NewListenerFunc is NewListener calling dsn for each connection it makes,
+// so a database that moves, as a restarted container can, is followed
+func NewListenerFunc(dsn func() string, opts ...Option) *Listener {
+	l := &Listener{
+		dsn:        dsn,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		buffer:     DefaultBuffer,
+	}
+	for _, opt := range opts {
+		opt(l)
+	}
+	return l
+}
+
+// Subscribe connects and listens on Channel, then delivers each change on
+// the returned channel, in commit order, until ctx is done, when it closes
+// the channel. Every change committed after Subscribe returns is delivered
+// unless the connection drops. A dropped connection is redialed until it
+// succeeds, with the resync callback run once it has. An error is returned
+// only if the first connection fails.
+func (l *Listener) Subscribe(ctx context.Context) (<-chan UserChange, error) {
+	conn, err := l.listen(ctx)
+	if err != nil {
+		return nil, err
+	}
+	changes := make(chan UserChange, l.buffer)
+	go l.run(ctx, conn, changes)
+	return changes, nil
+}
+
+// run delivers conn's notifications to changes, reconnecting as needed,
+// until ctx is done
+func (l *Listener) run(ctx context.Context, conn *pgx.Conn, changes chan<- UserChange) {
+	defer close(changes)
+	defer func() {
+		if conn != nil {
+			conn.Close(context.Background())
+		}
+	}()
+
+	for {
+		n, err := conn.WaitForNotification(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			log.Printf("changefeed: lost connection, reconnecting: %v", err)
+			conn.Close(context.Background())
+			if conn = l.reconnect(ctx); conn == nil {
+				return
+			}
+			if l.resync != nil {
+				l.resync(ctx)
+			}
+			continue
+		}
+
+		var change UserChange
+		if err := json.Unmarshal([]byte(n.Payload), &change); err != nil {
+			log.Printf("changefeed: dropping undecodable notification %q: %v", n.Payload, err)
+			continue
+		}
+		select {
+		case changes <- change:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reconnect dials until a connection is listening again, backing off
+// between attempts; nil once ctx is done
+func (l *Listener) reconnect(ctx context.Context) *pgx.Conn {
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(backoff.Delay(attempt, l.minBackoff, l.maxBackoff))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		conn, err := l.listen(ctx)
+		if err == nil {
+			return conn
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		log.Printf("changefeed: reconnect attempt %d failed: %v", attempt, err)
+	}
+}
+
+// listen dials the database and runs LISTEN on a new connection
+func (l *Listener) listen(ctx context.Context) (*pgx.Conn, error) {
+	conn, err := pgx.Connect(ctx, l.dsn())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{Channel}.Sanitize()); err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to listen on %s: %w", Channel, err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,222 @@
+package changefeed_test
+
+import (
+	"context"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"testcontainers-demo/changefeed"
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// deliveryTimeout is how long a test waits for one change
+const deliveryTimeout = 10 * time.Second
+
+// testContainer provides the database the listeners subscribe to. It is nil
+// when Docker is unavailable.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// nextChange returns the next change to user id on changes, skipping other
+// users' changes, and fails t if none arrives in time
+func nextChange(t *testing.T, changes <-chan changefeed.UserChange, id int) changefeed.UserChange {
+	t.Helper()
+	timeout := time.After(deliveryTimeout)
+	for {
+		select {
+		case change, ok := <-changes:
+			if !ok {
+				t.Fatalf("Channel closed waiting for a change to user %d", id)
+			}
+			if change.UserID == id {
+				return change
+			}
+		case <-timeout:
+			t.Fatalf("No change to user %d within %s", id, deliveryTimeout)
+		}
+	}
+}
+
+// TestSubscribe tests that every write through the repository arrives, in
+// order, as a change of the right type and version
+func TestSubscribe(t *testing.T) {
+	requirePostgres(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	repo := repository.NewUserRepository(testContainer.DB)
+
+	changes, err := changefeed.NewListener(testContainer.ConnStr).Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	t.Run("CRUD", func(t *testing.T) {
+		user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Listened To")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := repo.Update(ctx, user.ID, user.Email, "Renamed"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if err := repo.UpdateWithRole(ctx, user.ID, user.Email, "Promoted", models.RoleAdmin); err != nil {
+			t.Fatalf("Failed to update role: %v", err)
+		}
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		for _, want := range []changefeed.UserChange{
+			{Type: models.EventUserCreated, UserID: user.ID, TenantID: repository.DefaultTenant, Version: 1},
+			{Type: models.EventUserUpdated, UserID: user.ID, TenantID: repository.DefaultTenant, Version: 2},
+			{Type: models.EventUserUpdated, UserID: user.ID, TenantID: repository.DefaultTenant, Version: 3},
+			{Type: models.EventUserDeleted, UserID: user.ID, TenantID: repository.DefaultTenant, Version: 3},
+		} {
+			if got := nextChange(t, changes, user.ID); got != want {
+				t.Errorf("Expected %+v, got: %+v", want, got)
+			}
+		}
+	})
+
+	t.Run("Rolled Back Write", func(t *testing.T) {
+		tx, err := testContainer.DB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		rolledBack, err := repo.WithTx(tx).Create(ctx, fixtures.GenerateEmail(t), "Rolled Back")
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+
+		// Delivered in commit order, so the rolled-back user's change would be first
+		user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Committed")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		timeout := time.After(deliveryTimeout)
+		for {
+			select {
+			case change := <-changes:
+				if change.UserID == rolledBack.ID {
+					t.Fatalf("Expected no change for a rolled-back write, got: %+v", change)
+				}
+				if change.UserID == user.ID {
+					return
+				}
+			case <-timeout:
+				t.Fatalf("No change to user %d within %s", user.ID, deliveryTimeout)
+			}
+		}
+	})
+
+	t.Run("Skips Undecodable Notifications", func(t *testing.T) {
+		if _, err := testContainer.DB.ExecContext(ctx, "SELECT pg_notify($1, 'not json')", changefeed.Channel); err != nil {
+			t.Fatalf("Failed to notify: %v", err)
+		}
+		user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "After Garbage")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if got := nextChange(t, changes, user.ID); got.Type != models.EventUserCreated {
+			t.Errorf("Expected user.created, got: %+v", got)
+		}
+	})
+
+	t.Run("Closes When Done", func(t *testing.T) {
+		cancel()
+		timeout := time.After(deliveryTimeout)
+		for {
+			select {
+			case _, ok := <-changes:
+				if !ok {
+					return
+				}
+			case <-timeout:
+				t.Fatal("Expected the channel closed once the context is done")
+			}
+		}
+	})
+}
+
+// TestReconnect restarts a Postgres container of its own under a
+// subscription, and checks it reconnects, runs the resync callback once,
+// and delivers the changes made after it
+func TestReconnect(t *testing.T) {
+	requirePostgres(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Both would hand over a database other tests are using
+	if os.Getenv("TEST_DATABASE_URL") != "" || os.Getenv("TESTCONTAINERS_REUSE") == "1" {
+		t.Skip("Needs a container of its own to restart")
+	}
+	container, err := testhelpers.StartPostgres(ctx, testhelpers.WithImage(testhelpers.PostgresImage()))
+	if err != nil {
+		t.Fatalf("Failed to start Postgres: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(context.Background()) })
+	repo := repository.NewUserRepository(container.DB)
+
+	var resyncs atomic.Int32
+	resynced := make(chan struct{}, 1)
+	listener := changefeed.NewListenerFunc(container.DSN,
+		changefeed.WithReconnectBackoff(50*time.Millisecond, time.Second),
+		changefeed.WithResync(func(context.Context) {
+			resyncs.Add(1)
+			select {
+			case resynced <- struct{}{}:
+			default:
+			}
+		}),
+	)
+	changes, err := listener.Subscribe(ctx)
+	if err != nil {
+		t.Fatalf("Failed to subscribe: %v", err)
+	}
+
+	before, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Before Restart")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	nextChange(t, changes, before.ID)
+
+	container.RestartPostgres(ctx, t)
+
+	select {
+	case <-resynced:
+	case <-time.After(30 * time.Second):
+		t.Fatal("Expected the resync callback after the restart")
+	}
+
+	after, err := repo.Create(ctx, fixtures.GenerateEmail(t), "After Restart")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if got := nextChange(t, changes, after.ID); got.Type != models.EventUserCreated {
+		t.Errorf("Expected user.created, got: %+v", got)
+	}
+	if n := resyncs.Load(); n != 1 {
+		t.Errorf("Expected one resync, got: %d", n)
+	}
+}
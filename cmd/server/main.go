@@ -0,0 +1,91 @@
+// Command server serves the users REST API. It is configured by
+// config.Load: from the environment, over the YAML file CONFIG_FILE names,
+// if any, over the defaults. The essentials are:
+//
+//	DATABASE_URL  Postgres connection string (required)
+//	REDIS_ADDR    Redis host:port (required unless REDIS_URL is set)
+//	REDIS_URL     Redis URL, overriding REDIS_ADDR; redis+cluster:// and
+//	              redis+sentinel:// select Cluster and Sentinel
+//	ADDR          listen address, default ":8080"
+//
+// The database is expected to be migrated already.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"testcontainers-demo/api"
+	"testcontainers-demo/config"
+	"testcontainers-demo/repository"
+)
+
+func main() {
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// run serves until SIGINT or SIGTERM, then shuts down gracefully
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	cfg, err := config.Load("")
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	log.Printf("server: configuration %v", cfg)
+
+	conn, err := cfg.Postgres.Connect(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+	defer conn.Close()
+
+	cachedRepo, err := repository.NewCachedUserRepositoryFromConfig(conn, cfg)
+	if err != nil {
+		return err
+	}
+	defer cachedRepo.Close()
+
+	repo := repository.NewUserRepository(conn)
+	defer repo.Close()
+
+	// The API's own /healthz only checks Postgres; the process needs Redis too
+	mux := http.NewServeMux()
+	mux.Handle("GET /healthz", api.HealthHandler(
+		cachedRepo,
+		repository.DependencyPostgres, repository.DependencyRedis,
+	))
+	mux.Handle("/", api.NewServer(repo))
+
+	srv := &http.Server{Addr: cfg.Server.Addr, Handler: mux, ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout}
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("server: listening on %s", cfg.Server.Addr)
+		errCh <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("failed to shut down: %w", err)
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
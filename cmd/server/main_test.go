@@ -0,0 +1,58 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"testcontainers-demo/api"
+	"testcontainers-demo/db"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestServerEndToEnd runs the application image against Postgres and Redis
+// on a shared network, creates a user over HTTP, and checks the row landed
+// in Postgres
+func TestServerEndToEnd(t *testing.T) {
+	ctx := context.Background()
+	stack := testhelpers.StartStack(ctx, t)
+	baseURL := testhelpers.StartApp(ctx, t, stack.Network, stack.InternalPostgresDSN, stack.InternalRedisAddr)
+
+	body, err := json.Marshal(api.UserRequest{Email: "e2e@example.com", Name: "End To End"})
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	resp, err := http.Post(baseURL+"/users", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Expected status 201, got: %d", resp.StatusCode)
+	}
+	var created models.User
+	if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+
+	// Check Postgres directly rather than through the API
+	conn, err := db.Connect(ctx, stack.PostgresDSN)
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %v", err)
+	}
+	defer conn.Close()
+
+	var id int
+	var name string
+	err = conn.QueryRowContext(ctx, "SELECT id, name FROM users WHERE email = $1", "e2e@example.com").Scan(&id, &name)
+	if err != nil {
+		t.Fatalf("Failed to find created user in Postgres: %v", err)
+	}
+	if id != created.ID || name != "End To End" {
+		t.Errorf("Expected user %d named End To End, got: %d %q", created.ID, id, name)
+	}
+}
@@ -0,0 +1,399 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"testcontainers-demo/devtools"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+)
+
+// command is one usersctl subcommand. flags registers the subcommand's own
+// flags on fs and returns the function that runs it on the remaining args.
+type command struct {
+	flags func(fs *flag.FlagSet) runFunc
+}
+
+// runFunc runs a parsed subcommand
+type runFunc func(ctx context.Context, e *env, args []string) error
+
+// commands are the subcommands by name
+var commands = map[string]command{
+	"list":   {listFlags},
+	"get":    {getFlags},
+	"create": {createFlags},
+	"update": {updateFlags},
+	"delete": {deleteFlags},
+	"search": {searchFlags},
+	"stats":  {statsFlags},
+	"seed":   {seedFlags},
+	"reset":  {resetFlags},
+}
+
+// env is what a command runs against
+type env struct {
+	db    *sql.DB
+	repo  *repository.UserRepository
+	store store // the writes and by-ID reads, cached when Redis is configured
+	out   io.Writer
+	json  bool
+}
+
+// store is the part of the repository whose cached and uncached methods differ
+type store interface {
+	get(ctx context.Context, id int) (*models.User, error)
+	create(ctx context.Context, email, name string) (*models.User, error)
+	update(ctx context.Context, id int, email, name string) error
+	delete(ctx context.Context, id int) error
+}
+
+// plainStore is the store without Redis
+type plainStore struct{ repo *repository.UserRepository }
+
+func (s plainStore) get(ctx context.Context, id int) (*models.User, error) {
+	return s.repo.GetByID(ctx, id)
+}
+
+func (s plainStore) create(ctx context.Context, email, name string) (*models.User, error) {
+	return s.repo.Create(ctx, email, name)
+}
+
+func (s plainStore) update(ctx context.Context, id int, email, name string) error {
+	return s.repo.Update(ctx, id, email, name)
+}
+
+func (s plainStore) delete(ctx context.Context, id int) error {
+	return s.repo.Delete(ctx, id)
+}
+
+// cachedStore is the store with Redis: reads go through the cache, writes invalidate it
+type cachedStore struct {
+	repo *repository.CachedUserRepository
+}
+
+func (s cachedStore) get(ctx context.Context, id int) (*models.User, error) {
+	return s.repo.GetByIDCached(ctx, id)
+}
+
+func (s cachedStore) create(ctx context.Context, email, name string) (*models.User, error) {
+	return s.repo.CreateCached(ctx, email, name)
+}
+
+func (s cachedStore) update(ctx context.Context, id int, email, name string) error {
+	return s.repo.UpdateCached(ctx, id, email, name)
+}
+
+func (s cachedStore) delete(ctx context.Context, id int) error {
+	return s.repo.DeleteCached(ctx, id)
+}
+
+// listFlags is "list [-role ROLE]": every user, or those with ROLE, by ID
+func listFlags(fs *flag.FlagSet) runFunc {
+	role := fs.String("role", "", "only list users with this role")
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 0 {
+			return usageError("list takes no arguments")
+		}
+		var users []models.User
+		var err error
+		if *role != "" {
+			if !models.Role(*role).Valid() {
+				return usageError("unknown role %q", *role)
+			}
+			var page repository.UserPage
+			page, err = e.repo.ListByRole(ctx, models.Role(*role), "", 0)
+			users = page.Items
+		} else {
+			users, err = e.repo.List(ctx)
+		}
+		if err != nil {
+			return err
+		}
+		return e.printUsers(users)
+	}
+}
+
+// getFlags is "get ID|EMAIL|UUID"
+func getFlags(*flag.FlagSet) runFunc {
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 1 {
+			return usageError("get takes one ID, email, or UUID")
+		}
+		var user *models.User
+		var err error
+		if id, convErr := strconv.Atoi(args[0]); convErr == nil {
+			user, err = e.store.get(ctx, id)
+		} else if strings.Contains(args[0], "@") {
+			user, err = e.repo.GetByEmail(ctx, args[0])
+		} else {
+			id, parseErr := repository.ParseUUID(args[0])
+			if parseErr != nil {
+				return parseErr
+			}
+			user, err = e.repo.GetByUUID(ctx, id)
+		}
+		if err != nil {
+			return err
+		}
+		return e.printUser(*user)
+	}
+}
+
+// createFlags is "create -email EMAIL -name NAME"
+func createFlags(fs *flag.FlagSet) runFunc {
+	email := fs.String("email", "", "email of the new user (required)")
+	name := fs.String("name", "", "name of the new user (required)")
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 0 {
+			return usageError("create takes no arguments, use -email and -name")
+		}
+		// Missing flags are left to the repository's validation, which names the field
+		user, err := e.store.create(ctx, *email, *name)
+		if err != nil {
+			return err
+		}
+		return e.printUser(*user)
+	}
+}
+
+// updateFlags is "update [-email EMAIL] [-name NAME] ID"; fields not given keep their value
+func updateFlags(fs *flag.FlagSet) runFunc {
+	email := fs.String("email", "", "new email")
+	name := fs.String("name", "", "new name")
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := idArg("update", args)
+		if err != nil {
+			return err
+		}
+		user, err := e.store.get(ctx, id)
+		if err != nil {
+			return err
+		}
+		if *email != "" {
+			user.Email = *email
+		}
+		if *name != "" {
+			user.Name = *name
+		}
+		if err := e.store.update(ctx, id, user.Email, user.Name); err != nil {
+			return err
+		}
+
+		updated, err := e.store.get(ctx, id)
+		if err != nil {
+			return err
+		}
+		return e.printUser(*updated)
+	}
+}
+
+// deleteFlags is "delete ID"
+func deleteFlags(*flag.FlagSet) runFunc {
+	return func(ctx context.Context, e *env, args []string) error {
+		id, err := idArg("delete", args)
+		if err != nil {
+			return err
+		}
+		if err := e.store.delete(ctx, id); err != nil {
+			return err
+		}
+
+		if e.json {
+			return e.printJSON(struct {
+				Deleted int `json:"deleted"`
+			}{id})
+		}
+		_, err = fmt.Fprintf(e.out, "Deleted user %d\n", id)
+		return err
+	}
+}
+
+// searchFlags is "search TEXT": users whose name contains TEXT, ignoring case
+func searchFlags(*flag.FlagSet) runFunc {
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 1 {
+			return usageError("search takes one text to look for")
+		}
+		users, err := e.repo.FindByNamePattern(ctx, repository.EscapeLike(args[0]))
+		if err != nil {
+			return err
+		}
+		return e.printUsers(users)
+	}
+}
+
+// stats is the output of the stats command
+type stats struct {
+	Total   int                   `json:"total"`
+	ByRole  map[models.Role]int   `json:"by_role"`
+	Signups *repository.UserStats `json:"signups,omitempty"` // only with -from or -to
+}
+
+// statsFlags is "stats [-from DAY] [-to DAY]": how many users there are, in
+// total and per role, and with either flag, their signups from -from
+// through -to
+func statsFlags(fs *flag.FlagSet) runFunc {
+	from := fs.String("from", "", "also report signups from this day, YYYY-MM-DD in UTC (default 30 days ending with -to)")
+	to := fs.String("to", "", "also report signups through this day, YYYY-MM-DD in UTC (default today)")
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 0 {
+			return usageError("stats takes no arguments")
+		}
+		var signups *repository.UserStats
+		if *from != "" || *to != "" {
+			start, end, err := repository.ParseStatsRange(*from, *to, time.Now())
+			if err != nil {
+				return err
+			}
+			if signups, err = e.repo.GetUserStats(ctx, start, end); err != nil {
+				return err
+			}
+		}
+		return printStats(ctx, e, signups)
+	}
+}
+
+// printStats prints the user counts, followed by signups unless it is nil
+func printStats(ctx context.Context, e *env, signups *repository.UserStats) error {
+	total, err := e.repo.CountUsers(ctx)
+	if err != nil {
+		return err
+	}
+	byRole, err := e.repo.CountByRole(ctx)
+	if err != nil {
+		return err
+	}
+	// Every role appears, so scripts can rely on the keys
+	s := stats{Total: total, ByRole: make(map[models.Role]int, len(models.Roles)), Signups: signups}
+	for _, role := range models.Roles {
+		s.ByRole[role] = byRole[role]
+	}
+
+	if e.json {
+		return e.printJSON(s)
+	}
+	w := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ROLE\tUSERS")
+	for _, role := range models.Roles {
+		fmt.Fprintf(w, "%s\t%d\n", role, s.ByRole[role])
+	}
+	fmt.Fprintf(w, "total\t%d\n", s.Total)
+	if signups != nil {
+		fmt.Fprintln(w, "\nDAY\tSIGNUPS")
+		for _, d := range signups.Days {
+			fmt.Fprintf(w, "%s\t%d\n", d.Day.Format(time.DateOnly), d.Signups)
+		}
+		fmt.Fprintf(w, "total\t%d\n", signups.Total)
+		if signups.Busiest != nil {
+			fmt.Fprintf(w, "busiest\t%s\n", signups.Busiest.Day.Format(time.DateOnly))
+		}
+		fmt.Fprintln(w, "\nDOMAIN\tSIGNUPS")
+		for _, d := range signups.Domains {
+			fmt.Fprintf(w, "%s\t%d\n", d.Domain, d.Users)
+		}
+	}
+	return w.Flush()
+}
+
+// seedFlags is "seed [-profile PROFILE] [-seed N] [-reset]": loads a devtools
+// profile, after emptying the database with -reset
+func seedFlags(fs *flag.FlagSet) runFunc {
+	profile := fs.String("profile", devtools.ProfileDemo, "users to load: "+strings.Join(devtools.Profiles, ", "))
+	seed := fs.Uint64("seed", devtools.DefaultSeed, "seed the generated users derive from")
+	reset := fs.Bool("reset", false, "empty the database first, so IDs start at 1")
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 0 {
+			return usageError("seed takes no arguments")
+		}
+		if !slices.Contains(devtools.Profiles, *profile) {
+			return usageError("unknown profile %q, want one of %s", *profile, strings.Join(devtools.Profiles, ", "))
+		}
+		if *reset {
+			if err := devtools.Reset(ctx, e.db); err != nil {
+				return err
+			}
+		}
+		if err := devtools.SeedDatabase(ctx, e.db, *profile, devtools.WithSeed(*seed)); err != nil {
+			return err
+		}
+		// Seeding bypasses the cache, so the stats are read from Postgres
+		return printStats(ctx, e, nil)
+	}
+}
+
+// resetFlags is "reset": deletes every user and their history
+func resetFlags(*flag.FlagSet) runFunc {
+	return func(ctx context.Context, e *env, args []string) error {
+		if len(args) != 0 {
+			return usageError("reset takes no arguments")
+		}
+		if err := devtools.Reset(ctx, e.db); err != nil {
+			return err
+		}
+		if e.json {
+			return e.printJSON(struct {
+				Reset bool `json:"reset"`
+			}{true})
+		}
+		_, err := fmt.Fprintln(e.out, "Deleted every user")
+		return err
+	}
+}
+
+// idArg parses the single user ID argument of cmd
+func idArg(cmd string, args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, usageError("%s takes one user ID", cmd)
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil {
+		return 0, usageError("invalid user ID %q", args[0])
+	}
+	return id, nil
+}
+
+// printUser prints user as a JSON object or a one-row table
+func (e *env) printUser(user models.User) error {
+	if e.json {
+		return e.printJSON(user)
+	}
+	return e.printTable([]models.User{user})
+}
+
+// printUsers prints users as a JSON array, empty rather than null, or a table
+func (e *env) printUsers(users []models.User) error {
+	if e.json {
+		if users == nil {
+			users = []models.User{}
+		}
+		return e.printJSON(users)
+	}
+	return e.printTable(users)
+}
+
+// printTable prints users as aligned columns under a header
+func (e *env) printTable(users []models.User) error {
+	w := tabwriter.NewWriter(e.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tEMAIL\tNAME\tROLE\tCREATED")
+	for _, u := range users {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", u.ID, u.Email, u.Name, u.Role, u.CreatedAt.UTC().Format(time.DateTime))
+	}
+	return w.Flush()
+}
+
+// printJSON prints v as indented JSON
+func (e *env) printJSON(v interface{}) error {
+	enc := json.NewEncoder(e.out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
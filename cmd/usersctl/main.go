@@ -0,0 +1,151 @@
+// Command usersctl manages users in a running database:
+//
+//	usersctl list   [-role ROLE]
+//	usersctl get    ID|EMAIL|UUID
+//	usersctl create -email EMAIL -name NAME
+//	usersctl update [-email EMAIL] [-name NAME] ID
+//	usersctl delete ID
+//	usersctl search TEXT
+//	usersctl stats  [-from DAY] [-to DAY]
+//	usersctl seed   [-profile PROFILE] [-seed N] [-reset]
+//	usersctl reset
+//
+// Every command takes -database-url and -redis-addr, defaulting to
+// DATABASE_URL and REDIS_ADDR. Redis is optional: with it, get reads
+// through the cache and writes invalidate it. -json prints JSON, in the
+// API's encoding, instead of a table.
+//
+// The exit status is 0 on success, 2 for invalid input or usage, 3 when the
+// user doesn't exist, 4 when the email is taken, and 1 for anything else.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"testcontainers-demo/db"
+	"testcontainers-demo/repository"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Environment variables usersctl reads when the flags are not given
+const (
+	databaseURLEnv = "DATABASE_URL"
+	redisAddrEnv   = "REDIS_ADDR"
+)
+
+// Exit statuses
+const (
+	exitOK       = 0
+	exitError    = 1
+	exitUsage    = 2 // also invalid input, e.g. a malformed email
+	exitNotFound = 3
+	exitConflict = 4
+)
+
+// errUsage marks errors in the command line itself
+var errUsage = errors.New("usage")
+
+// usageError returns an error wrapping errUsage with a message
+func usageError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{errUsage}, args...)...)
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr, os.Getenv)
+	stop()
+	os.Exit(code)
+}
+
+// run executes the command line args, writing results to stdout and errors
+// to stderr, and returns the exit status. It reads the environment only
+// through getenv, so tests can call it directly.
+func run(ctx context.Context, args []string, stdout, stderr io.Writer, getenv func(string) string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: usersctl list|get|create|update|delete|search|stats|seed|reset [flags] [args]")
+		return exitUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "usersctl: unknown command %q\n", args[0])
+		return exitUsage
+	}
+
+	fs := flag.NewFlagSet("usersctl "+args[0], flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var opts options
+	fs.StringVar(&opts.databaseURL, "database-url", getenv(databaseURLEnv), "Postgres connection string (default $"+databaseURLEnv+")")
+	fs.StringVar(&opts.redisAddr, "redis-addr", getenv(redisAddrEnv), "Redis host:port, optional (default $"+redisAddrEnv+")")
+	fs.BoolVar(&opts.json, "json", false, "print JSON instead of a table")
+	bind := cmd.flags(fs)
+	if err := fs.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+
+	err := execute(ctx, opts, func(e *env) error { return bind(ctx, e, fs.Args()) }, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "usersctl %s: %v\n", args[0], err)
+	}
+	return exitCode(err)
+}
+
+// options are the flags every command takes
+type options struct {
+	databaseURL string
+	redisAddr   string
+	json        bool
+}
+
+// execute connects to the databases in opts and runs cmd against them
+func execute(ctx context.Context, opts options, cmd func(*env) error, stdout io.Writer) error {
+	if opts.databaseURL == "" {
+		return usageError("-database-url or %s is required", databaseURLEnv)
+	}
+
+	conn, err := db.Connect(ctx, opts.databaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to connect to Postgres: %w", err)
+	}
+	defer conn.Close()
+
+	repo := repository.NewUserRepository(conn)
+	defer repo.Close()
+	e := &env{db: conn, repo: repo, store: plainStore{repo}, out: stdout, json: opts.json}
+
+	if opts.redisAddr != "" {
+		cache := redis.NewClient(&redis.Options{Addr: opts.redisAddr})
+		defer cache.Close()
+		e.store = cachedStore{repository.NewCachedUserRepository(conn, cache)}
+	}
+
+	return cmd(e)
+}
+
+// exitCode maps err to the exit status documented on the package
+func exitCode(err error) int {
+	var validationErr *repository.ValidationError
+	var uuidErr *repository.InvalidUUIDError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, errUsage), errors.As(err, &validationErr), errors.As(err, &uuidErr):
+		return exitUsage
+	case errors.Is(err, repository.ErrUserNotFound):
+		return exitNotFound
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		return exitConflict
+	default:
+		return exitError
+	}
+}
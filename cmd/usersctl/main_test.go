@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"testcontainers-demo/devtools"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// testContainer is the database the commands run against. It is nil when
+// Docker is unavailable, and only the integration tests need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// result is what one usersctl invocation printed and returned
+type result struct {
+	stdout, stderr string
+	code           int
+}
+
+// usersctl runs the command line args with env as the environment
+func usersctl(t *testing.T, env map[string]string, args ...string) result {
+	t.Helper()
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), args, &stdout, &stderr, func(key string) string { return env[key] })
+	return result{stdout: stdout.String(), stderr: stderr.String(), code: code}
+}
+
+// expectCode fails t unless r exited with code
+func expectCode(t *testing.T, r result, code int) {
+	t.Helper()
+	if r.code != code {
+		t.Fatalf("Expected exit status %d, got %d; stdout: %q, stderr: %q", code, r.code, r.stdout, r.stderr)
+	}
+}
+
+// decode unmarshals r's stdout into v
+func decode(t *testing.T, r result, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(r.stdout), v); err != nil {
+		t.Fatalf("Expected JSON output, got %q: %v", r.stdout, err)
+	}
+}
+
+// TestUsage tests the errors found before connecting to anything
+func TestUsage(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		args []string
+		want string
+	}{
+		{"No Command", nil, nil, "usage: usersctl"},
+		{"Unknown Command", nil, []string{"frobnicate"}, `unknown command "frobnicate"`},
+		{"Unknown Flag", nil, []string{"list", "-verbose"}, "flag provided but not defined"},
+		{"No Database", nil, []string{"list"}, "DATABASE_URL is required"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			r := usersctl(t, tc.env, tc.args...)
+			expectCode(t, r, exitUsage)
+			if !strings.Contains(r.stderr, tc.want) {
+				t.Errorf("Expected stderr to mention %q, got: %q", tc.want, r.stderr)
+			}
+		})
+	}
+}
+
+// TestExitCode tests how errors map to exit statuses
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, exitOK},
+		{usageError("bad"), exitUsage},
+		{&repository.RepoError{Op: "UserRepository.Create", Err: &repository.ValidationError{}}, exitUsage},
+		{&repository.InvalidUUIDError{Input: "x", Err: errors.New("bad")}, exitUsage},
+		{&repository.RepoError{Op: "UserRepository.GetByID", Err: repository.ErrUserNotFound}, exitNotFound},
+		{&repository.RepoError{Op: "UserRepository.Create", Err: repository.ErrDuplicateEmail}, exitConflict},
+		{errors.New("connection refused"), exitError},
+	}
+	for _, tc := range tests {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestCommands runs every command against Postgres, with and without Redis,
+// checking output and exit statuses
+func TestCommands(t *testing.T) {
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+	ctx := context.Background()
+
+	configs := []struct {
+		name string
+		env  map[string]string
+	}{
+		{"Postgres", map[string]string{databaseURLEnv: testContainer.ConnStr}},
+		{"Postgres And Redis", map[string]string{
+			databaseURLEnv: testContainer.ConnStr,
+			redisAddrEnv:   testhelpers.StartRedis(ctx, t).Options().Addr,
+		}},
+	}
+	for i, cfg := range configs {
+		t.Run(cfg.name, func(t *testing.T) {
+			testCommands(t, cfg.env, fmt.Sprintf("cli%d", i))
+		})
+	}
+}
+
+// testCommands is TestCommands for one environment; prefix keeps its
+// emails and names apart from the other runs'
+func testCommands(t *testing.T, env map[string]string, prefix string) {
+	email := prefix + "@example.com"
+	name := "Usersctl " + strings.ToUpper(prefix)
+
+	r := usersctl(t, env, "create", "-json", "-email", email, "-name", name)
+	expectCode(t, r, exitOK)
+	var created models.User
+	decode(t, r, &created)
+	if created.Email != email || created.Name != name || created.Role != models.RoleMember {
+		t.Fatalf("Expected %s <%s> as a member, got: %+v", name, email, created)
+	}
+	id := strconv.Itoa(created.ID)
+
+	t.Run("JSON Is Stable", func(t *testing.T) {
+		r := usersctl(t, env, "get", "-json", id)
+		expectCode(t, r, exitOK)
+		var fields map[string]interface{}
+		decode(t, r, &fields)
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		want := []string{"created_at", "email", "id", "name", "role", "uuid", "version"}
+		if !reflect.DeepEqual(keys, want) {
+			t.Errorf("Expected keys %v, got: %v", want, keys)
+		}
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		expectCode(t, usersctl(t, env, "create", "-email", email, "-name", "Again"), exitConflict)
+		expectCode(t, usersctl(t, env, "create", "-email", "not-an-email", "-name", "Bad"), exitUsage)
+		expectCode(t, usersctl(t, env, "create", "-email", prefix+"-noname@example.com"), exitUsage)
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		for _, key := range []string{id, email, created.UUID.String()} {
+			r := usersctl(t, env, "get", "-json", key)
+			expectCode(t, r, exitOK)
+			var got models.User
+			decode(t, r, &got)
+			if got.ID != created.ID {
+				t.Errorf("get %s: expected user %d, got: %d", key, created.ID, got.ID)
+			}
+		}
+
+		r := usersctl(t, env, "get", id)
+		expectCode(t, r, exitOK)
+		lines := strings.Split(strings.TrimSpace(r.stdout), "\n")
+		if len(lines) != 2 || !strings.HasPrefix(lines[0], "ID") || !strings.Contains(lines[1], email) {
+			t.Errorf("Expected a header and one row for %s, got: %q", email, r.stdout)
+		}
+
+		expectCode(t, usersctl(t, env, "get", "999999"), exitNotFound)
+		expectCode(t, usersctl(t, env, "get", "nobody-"+email), exitNotFound)
+		expectCode(t, usersctl(t, env, "get", "not-a-uuid"), exitUsage)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		r := usersctl(t, env, "update", "-json", "-name", name+" Renamed", id)
+		expectCode(t, r, exitOK)
+		var updated models.User
+		decode(t, r, &updated)
+		if updated.Name != name+" Renamed" || updated.Email != email {
+			t.Errorf("Expected only the name to change, got: %+v", updated)
+		}
+
+		// A read after the write sees it, through the cache when there is one
+		r = usersctl(t, env, "get", "-json", id)
+		expectCode(t, r, exitOK)
+		decode(t, r, &updated)
+		if updated.Name != name+" Renamed" {
+			t.Errorf("Expected get to see the new name, got: %s", updated.Name)
+		}
+
+		other := usersctl(t, env, "create", "-email", prefix+"-other@example.com", "-name", "Other")
+		expectCode(t, other, exitOK)
+		expectCode(t, usersctl(t, env, "update", "-email", prefix+"-other@example.com", id), exitConflict)
+		expectCode(t, usersctl(t, env, "update", "-email", "bad", id), exitUsage)
+		expectCode(t, usersctl(t, env, "update", "-name", "Ghost", "999999"), exitNotFound)
+		expectCode(t, usersctl(t, env, "update", "abc"), exitUsage)
+	})
+
+	t.Run("Search", func(t *testing.T) {
+		r := usersctl(t, env, "search", "-json", strings.ToLower(name))
+		expectCode(t, r, exitOK)
+		var found []models.User
+		decode(t, r, &found)
+		if len(found) != 1 || found[0].ID != created.ID {
+			t.Errorf("Expected to find user %d, got: %+v", created.ID, found)
+		}
+
+		// No match is an empty array, not null, and % is not a wildcard
+		r = usersctl(t, env, "search", "-json", "%")
+		expectCode(t, r, exitOK)
+		if strings.TrimSpace(r.stdout) != "[]" {
+			t.Errorf("Expected [], got: %q", r.stdout)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		r := usersctl(t, env, "list", "-json")
+		expectCode(t, r, exitOK)
+		var users []models.User
+		decode(t, r, &users)
+		var listed bool
+		for _, u := range users {
+			listed = listed || u.ID == created.ID
+		}
+		if !listed {
+			t.Errorf("Expected user %d in the list", created.ID)
+		}
+
+		r = usersctl(t, env, "list", "-json", "-role", "admin")
+		expectCode(t, r, exitOK)
+		decode(t, r, &users)
+		for _, u := range users {
+			if u.Role != models.RoleAdmin {
+				t.Errorf("Expected only admins, got: %+v", u)
+			}
+		}
+		expectCode(t, usersctl(t, env, "list", "-role", "superuser"), exitUsage)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		r := usersctl(t, env, "stats", "-json")
+		expectCode(t, r, exitOK)
+		var s stats
+		decode(t, r, &s)
+		sum := 0
+		for _, role := range models.Roles {
+			n, ok := s.ByRole[role]
+			if !ok {
+				t.Errorf("Expected a count for %s", role)
+			}
+			sum += n
+		}
+		if s.Total < 1 || s.Total != sum {
+			t.Errorf("Expected a positive total equal to the per-role sum %d, got: %d", sum, s.Total)
+		}
+		if s.Signups != nil {
+			t.Errorf("Expected no signups without -from or -to, got: %+v", s.Signups)
+		}
+	})
+
+	t.Run("Stats With Signups", func(t *testing.T) {
+		today := time.Now().UTC().Format(time.DateOnly)
+		r := usersctl(t, env, "stats", "-json", "-to", today)
+		expectCode(t, r, exitOK)
+		var s stats
+		decode(t, r, &s)
+		if s.Signups == nil || len(s.Signups.Days) != 30 {
+			t.Fatalf("Expected 30 days of signups, got: %+v", s.Signups)
+		}
+		// The user created above signed up today
+		if last := s.Signups.Days[29]; last.Day.Format(time.DateOnly) != today || last.Signups < 1 {
+			t.Errorf("Expected a signup today, got: %+v", last)
+		}
+
+		r = usersctl(t, env, "stats", "-from", today)
+		expectCode(t, r, exitOK)
+		if !strings.Contains(r.stdout, "DAY") || !strings.Contains(r.stdout, "example.com") {
+			t.Errorf("Expected tables of days and domains, got: %q", r.stdout)
+		}
+
+		expectCode(t, usersctl(t, env, "stats", "-from", "June"), exitUsage)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		r := usersctl(t, env, "delete", id)
+		expectCode(t, r, exitOK)
+		if r.stdout != "Deleted user "+id+"\n" {
+			t.Errorf("Expected a confirmation, got: %q", r.stdout)
+		}
+		expectCode(t, usersctl(t, env, "get", id), exitNotFound)
+		expectCode(t, usersctl(t, env, "delete", id), exitNotFound)
+	})
+}
+
+// TestSeed tests seed and reset against the shared database, restoring it
+// afterwards
+func TestSeed(t *testing.T) {
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+	t.Cleanup(func() { testContainer.ResetDB(t) })
+	env := map[string]string{databaseURLEnv: testContainer.ConnStr}
+
+	total := func(t *testing.T, args ...string) int {
+		t.Helper()
+		r := usersctl(t, env, args...)
+		expectCode(t, r, exitOK)
+		var s stats
+		decode(t, r, &s)
+		return s.Total
+	}
+
+	if n := total(t, "seed", "-json", "-reset", "-profile", "minimal"); n != 2 {
+		t.Errorf("Expected 2 users after seeding minimal, got: %d", n)
+	}
+	if n := total(t, "seed", "-json", "-reset"); n != devtools.DemoUsers {
+		t.Errorf("Expected %d users after seeding demo, got: %d", devtools.DemoUsers, n)
+	}
+	r := usersctl(t, env, "get", "-json", "1")
+	expectCode(t, r, exitOK)
+	var first models.User
+	decode(t, r, &first)
+	if want := devtools.GenerateUsers(devtools.DefaultSeed, devtools.DemoUsers)[0]; first.UUID != want.UUID || first.Email != want.Email {
+		t.Errorf("Expected user 1 to be %s <%s>, got: %+v", want.Name, want.Email, first)
+	}
+
+	expectCode(t, usersctl(t, env, "seed", "-profile", "huge"), exitUsage)
+
+	expectCode(t, usersctl(t, env, "reset"), exitOK)
+	if n := total(t, "stats", "-json"); n != 0 {
+		t.Errorf("Expected no users after reset, got: %d", n)
+	}
+}
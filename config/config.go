@@ -0,0 +1,386 @@
+// Package config loads the application's configuration from the
+// environment, an optional YAML file, and defaults, in that order of
+// precedence
+package config
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"testcontainers-demo/db"
+
+	"gopkg.in/yaml.v3"
+)
+
+// FileEnv names the YAML file Load reads when it is given no path
+const FileEnv = "CONFIG_FILE"
+
+// Environment variables Load reads, besides the db package's pool
+// variables (db.DriverEnv and the rest), which it reads too
+const (
+	DatabaseURLEnv       = "DATABASE_URL"
+	RedisAddrEnv         = "REDIS_ADDR"
+	RedisURLEnv          = "REDIS_URL"
+	RedisPasswordEnv     = "REDIS_PASSWORD"
+	CacheTTLEnv          = "CACHE_TTL"
+	CacheTimeoutEnv      = "CACHE_TIMEOUT"
+	CacheNegativeTTLEnv  = "CACHE_NEGATIVE_TTL"
+	CacheRefreshAheadEnv = "CACHE_REFRESH_AHEAD"
+	CacheFenceTTLEnv     = "CACHE_FENCE_TTL"
+	AddrEnv              = "ADDR"
+	ShutdownTimeoutEnv   = "SHUTDOWN_TIMEOUT"
+	ReadHeaderTimeoutEnv = "READ_HEADER_TIMEOUT"
+)
+
+// Defaults for the settings without one in the db package; the cache's
+// match the repository's own
+const (
+	DefaultCacheTTL          = 5 * time.Minute
+	DefaultCacheTimeout      = 50 * time.Millisecond
+	DefaultFenceTTL          = 10 * time.Second
+	DefaultAddr              = ":8080"
+	DefaultShutdownTimeout   = 10 * time.Second
+	DefaultReadHeaderTimeout = 5 * time.Second
+)
+
+// redacted replaces every secret in formatted output
+const redacted = "xxxxx"
+
+// Config is the whole application configuration. Its String and GoString
+// mask secrets, so it is safe to log with any verb; use Redacted for a copy
+// to encode some other way.
+type Config struct {
+	Postgres Postgres `yaml:"postgres"`
+	Redis    Redis    `yaml:"redis"`
+	Cache    Cache    `yaml:"cache"`
+	Server   Server   `yaml:"server"`
+}
+
+// Postgres configures the connection pool; see db.Config
+type Postgres struct {
+	// URL is the connection string, in URL or keyword/value form
+	URL             string        `yaml:"url"`
+	Driver          string        `yaml:"driver"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	ConnMaxIdleTime time.Duration `yaml:"conn_max_idle_time"`
+	PingTimeout     time.Duration `yaml:"ping_timeout"`
+}
+
+// Redis says where the cache is: URL, in any scheme
+// repository.NewCachedUserRepositoryFromURL accepts, or else Addr and
+// Password for a single server
+type Redis struct {
+	Addr     string `yaml:"addr"`
+	URL      string `yaml:"url"`
+	Password string `yaml:"password"`
+}
+
+// Cache configures the cached repository; zero NegativeTTL, RefreshAhead,
+// or FenceTTL turns that feature off
+type Cache struct {
+	TTL          time.Duration `yaml:"ttl"`
+	Timeout      time.Duration `yaml:"timeout"`
+	NegativeTTL  time.Duration `yaml:"negative_ttl"`
+	RefreshAhead time.Duration `yaml:"refresh_ahead"`
+	FenceTTL     time.Duration `yaml:"fence_ttl"`
+}
+
+// Server configures the HTTP server
+type Server struct {
+	Addr              string        `yaml:"addr"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout"`
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+}
+
+// Default returns the configuration before any file or environment
+// variable is applied. It doesn't validate: no default says where Postgres
+// or Redis is.
+func Default() Config {
+	return Config{
+		Postgres: Postgres{
+			Driver:          db.DefaultDriver,
+			MaxOpenConns:    db.DefaultMaxOpenConns,
+			MaxIdleConns:    db.DefaultMaxIdleConns,
+			ConnMaxLifetime: db.DefaultConnMaxLifetime,
+			ConnMaxIdleTime: db.DefaultConnMaxIdleTime,
+			PingTimeout:     db.DefaultPingTimeout,
+		},
+		Cache: Cache{
+			TTL:      DefaultCacheTTL,
+			Timeout:  DefaultCacheTimeout,
+			FenceTTL: DefaultFenceTTL,
+		},
+		Server: Server{
+			Addr:              DefaultAddr,
+			ShutdownTimeout:   DefaultShutdownTimeout,
+			ReadHeaderTimeout: DefaultReadHeaderTimeout,
+		},
+	}
+}
+
+// Load returns the defaults, overridden by the YAML file at path, then by
+// the environment, and validates the result. An empty path falls back to
+// $CONFIG_FILE, and with neither there is no file. The file may set any
+// subset of the fields, but an unknown one is an error, as a misspelt key
+// would otherwise be ignored. Durations use time.ParseDuration syntax
+// ("30s", "5m") in both sources, and an empty environment variable counts
+// as unset.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path == "" {
+		path = os.Getenv(FileEnv)
+	}
+	if path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return Config{}, err
+		}
+	}
+	if err := cfg.loadEnv(); err != nil {
+		return Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// loadFile overrides the fields path sets
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	// An empty file decodes to io.EOF and leaves everything as it was
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides the fields whose environment variable is set,
+// reporting every value that doesn't parse
+func (c *Config) loadEnv() error {
+	for env, dst := range map[string]*string{
+		DatabaseURLEnv:   &c.Postgres.URL,
+		db.DriverEnv:     &c.Postgres.Driver,
+		RedisAddrEnv:     &c.Redis.Addr,
+		RedisURLEnv:      &c.Redis.URL,
+		RedisPasswordEnv: &c.Redis.Password,
+		AddrEnv:          &c.Server.Addr,
+	} {
+		if v := os.Getenv(env); v != "" {
+			*dst = v
+		}
+	}
+
+	var errs []error
+	for env, dst := range map[string]*int{
+		db.MaxOpenConnsEnv: &c.Postgres.MaxOpenConns,
+		db.MaxIdleConnsEnv: &c.Postgres.MaxIdleConns,
+	} {
+		if v := os.Getenv(env); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not an integer", env, v))
+				continue
+			}
+			*dst = n
+		}
+	}
+	for env, dst := range map[string]*time.Duration{
+		db.ConnMaxLifetimeEnv: &c.Postgres.ConnMaxLifetime,
+		db.ConnMaxIdleTimeEnv: &c.Postgres.ConnMaxIdleTime,
+		db.PingTimeoutEnv:     &c.Postgres.PingTimeout,
+		CacheTTLEnv:           &c.Cache.TTL,
+		CacheTimeoutEnv:       &c.Cache.Timeout,
+		CacheNegativeTTLEnv:   &c.Cache.NegativeTTL,
+		CacheRefreshAheadEnv:  &c.Cache.RefreshAhead,
+		CacheFenceTTLEnv:      &c.Cache.FenceTTL,
+		ShutdownTimeoutEnv:    &c.Server.ShutdownTimeout,
+		ReadHeaderTimeoutEnv:  &c.Server.ReadHeaderTimeout,
+	} {
+		if v := os.Getenv(env); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %q is not a duration such as \"30s\"", env, v))
+				continue
+			}
+			*dst = d
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate reports every invalid field, each error naming the field by its
+// YAML path and environment variable, saying what is wrong, and quoting
+// the value unless it may hold a secret
+func (c Config) Validate() error {
+	var errs []error
+	fail := func(field, env, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s (%s): %s", field, env, fmt.Sprintf(format, args...)))
+	}
+	atLeast := func(field, env string, d, floor time.Duration) {
+		if d < floor {
+			fail(field, env, "must be at least %s, got %s", floor, d)
+		}
+	}
+
+	if c.Postgres.URL == "" {
+		fail("postgres.url", DatabaseURLEnv, "is required")
+	}
+	if d := c.Postgres.Driver; d != db.DriverPQ && d != db.DriverPGX {
+		fail("postgres.driver", db.DriverEnv, "must be %q or %q, got %q", db.DriverPQ, db.DriverPGX, d)
+	}
+	if n := c.Postgres.MaxOpenConns; n < 1 {
+		fail("postgres.max_open_conns", db.MaxOpenConnsEnv, "must be at least 1, got %d", n)
+	}
+	if n := c.Postgres.MaxIdleConns; n < 0 {
+		fail("postgres.max_idle_conns", db.MaxIdleConnsEnv, "must not be negative, got %d", n)
+	}
+	atLeast("postgres.conn_max_lifetime", db.ConnMaxLifetimeEnv, c.Postgres.ConnMaxLifetime, 0)
+	atLeast("postgres.conn_max_idle_time", db.ConnMaxIdleTimeEnv, c.Postgres.ConnMaxIdleTime, 0)
+	atLeast("postgres.ping_timeout", db.PingTimeoutEnv, c.Postgres.PingTimeout, time.Millisecond)
+
+	switch {
+	case c.Redis.URL != "":
+		if err := checkRedisURL(c.Redis.URL); err != nil {
+			fail("redis.url", RedisURLEnv, "%v", err)
+		}
+	case c.Redis.Addr != "":
+		if _, _, err := net.SplitHostPort(c.Redis.Addr); err != nil {
+			fail("redis.addr", RedisAddrEnv, "must be host:port, got %q", c.Redis.Addr)
+		}
+	default:
+		errs = append(errs, fmt.Errorf("redis.url (%s) or redis.addr (%s): one is required", RedisURLEnv, RedisAddrEnv))
+	}
+
+	atLeast("cache.ttl", CacheTTLEnv, c.Cache.TTL, time.Millisecond)
+	atLeast("cache.timeout", CacheTimeoutEnv, c.Cache.Timeout, 0)
+	atLeast("cache.negative_ttl", CacheNegativeTTLEnv, c.Cache.NegativeTTL, 0)
+	atLeast("cache.refresh_ahead", CacheRefreshAheadEnv, c.Cache.RefreshAhead, 0)
+	if c.Cache.RefreshAhead >= c.Cache.TTL && c.Cache.TTL > 0 {
+		fail("cache.refresh_ahead", CacheRefreshAheadEnv, "must be less than cache.ttl (%s), got %s", c.Cache.TTL, c.Cache.RefreshAhead)
+	}
+	atLeast("cache.fence_ttl", CacheFenceTTLEnv, c.Cache.FenceTTL, 0)
+
+	if _, _, err := net.SplitHostPort(c.Server.Addr); err != nil {
+		fail("server.addr", AddrEnv, "must be host:port or :port, got %q", c.Server.Addr)
+	}
+	atLeast("server.shutdown_timeout", ShutdownTimeoutEnv, c.Server.ShutdownTimeout, time.Millisecond)
+	atLeast("server.read_header_timeout", ReadHeaderTimeoutEnv, c.Server.ReadHeaderTimeout, time.Millisecond)
+
+	return errors.Join(errs...)
+}
+
+// checkRedisURL checks the scheme of a Redis URL without echoing it, as
+// it may carry a password; the client checks the rest when it is created
+func checkRedisURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return errors.New("is not a valid URL")
+	}
+	scheme, mode, _ := strings.Cut(u.Scheme, "+")
+	if scheme != "redis" && scheme != "rediss" || mode != "" && mode != "cluster" && mode != "sentinel" {
+		return fmt.Errorf("must use scheme redis or rediss, optionally with +cluster or +sentinel, got %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return errors.New("must name a host")
+	}
+	return nil
+}
+
+// DBConfig returns the pool configuration for db.WithConfig
+func (p Postgres) DBConfig() db.Config {
+	return db.Config{
+		Driver:          p.Driver,
+		MaxOpenConns:    p.MaxOpenConns,
+		MaxIdleConns:    min(p.MaxIdleConns, p.MaxOpenConns),
+		ConnMaxLifetime: p.ConnMaxLifetime,
+		ConnMaxIdleTime: p.ConnMaxIdleTime,
+		PingTimeout:     p.PingTimeout,
+		PingInterval:    db.DefaultPingInterval,
+	}
+}
+
+// Connect opens the pool p describes, as db.Connect does, with opts
+// applied over p's settings
+func (p Postgres) Connect(ctx context.Context, opts ...db.Option) (*sql.DB, error) {
+	return db.Connect(ctx, p.URL, append([]db.Option{db.WithConfig(p.DBConfig())}, opts...)...)
+}
+
+// Redacted returns a copy of c with every password masked: in both URLs,
+// as db.Redact masks them, and Redis.Password, if set
+func (c Config) Redacted() Config {
+	c.Postgres = c.Postgres.redacted()
+	c.Redis = c.Redis.redacted()
+	return c
+}
+
+func (p Postgres) redacted() Postgres {
+	if p.URL != "" {
+		p.URL = db.Redact(p.URL)
+	}
+	return p
+}
+
+func (r Redis) redacted() Redis {
+	if r.URL != "" {
+		r.URL = db.Redact(r.URL)
+	}
+	if r.Password != "" {
+		r.Password = redacted
+	}
+	return r
+}
+
+// The plain types format like their namesakes without their methods, which
+// would otherwise recurse
+type (
+	plainConfig   Config
+	plainPostgres Postgres
+	plainRedis    Redis
+)
+
+// String formats c with its secrets masked
+func (c Config) String() string {
+	return fmt.Sprintf("%+v", plainConfig(c.Redacted()))
+}
+
+// GoString is String for %#v, which would otherwise print every field as is
+func (c Config) GoString() string {
+	return fmt.Sprintf("%#v", plainConfig(c.Redacted()))
+}
+
+// String formats p with its password masked
+func (p Postgres) String() string {
+	return fmt.Sprintf("%+v", plainPostgres(p.redacted()))
+}
+
+// GoString is String for %#v
+func (p Postgres) GoString() string {
+	return fmt.Sprintf("%#v", plainPostgres(p.redacted()))
+}
+
+// String formats r with its passwords masked
+func (r Redis) String() string {
+	return fmt.Sprintf("%+v", plainRedis(r.redacted()))
+}
+
+// GoString is String for %#v
+func (r Redis) GoString() string {
+	return fmt.Sprintf("%#v", plainRedis(r.redacted()))
+}
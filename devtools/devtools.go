@@ -0,0 +1,240 @@
+// Package devtools resets and seeds a local development database, in place
+// of hand-written psql sessions. Generated data is deterministic: the same
+// profile and seed always produce the same users.
+package devtools
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"time"
+
+	"testcontainers-demo/migrations"
+	"testcontainers-demo/models"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/lib/pq"
+)
+
+// Seed profiles
+const (
+	ProfileMinimal = "minimal" // the two users of migrations/seed.sql
+	ProfileDemo    = "demo"    // DemoUsers generated users with realistic names
+	ProfileLoad    = "load"    // LoadUsers generated users, copied in bulk
+)
+
+// Profiles lists every profile SeedDatabase accepts
+var Profiles = []string{ProfileMinimal, ProfileDemo, ProfileLoad}
+
+// Profile sizes
+const (
+	DemoUsers = 50
+	LoadUsers = 100_000
+)
+
+// DefaultSeed is the seed SeedDatabase generates users from without WithSeed
+const DefaultSeed = 1
+
+// generatedEpoch is the earliest created_at of a generated user; the rest
+// follow within a year of it
+var generatedEpoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// seedConfig holds SeedDatabase's options
+type seedConfig struct {
+	seed uint64
+}
+
+// SeedOption configures SeedDatabase
+type SeedOption func(*seedConfig)
+
+// WithSeed sets the seed generated users derive from
+func WithSeed(seed uint64) SeedOption {
+	return func(c *seedConfig) {
+		c.seed = seed
+	}
+}
+
+// SeedDatabase inserts the users of profile. It adds to what is there
+// already, so call Reset first for exactly the profile's contents: IDs then
+// start at 1, in GenerateUsers order.
+func SeedDatabase(ctx context.Context, db *sql.DB, profile string, opts ...SeedOption) error {
+	cfg := seedConfig{seed: DefaultSeed}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	switch profile {
+	case ProfileMinimal:
+		return migrations.Seed(ctx, db)
+	case ProfileDemo:
+		return copyUsers(ctx, db, GenerateUsers(cfg.seed, DemoUsers))
+	case ProfileLoad:
+		return copyUsers(ctx, db, generateLoadUsers(cfg.seed, LoadUsers))
+	default:
+		return fmt.Errorf("unknown seed profile %q, want one of %s", profile, strings.Join(Profiles, ", "))
+	}
+}
+
+// Reset empties the users table and the tables recording their history,
+// restarting their ID sequences, so the next user created gets ID 1.
+// Migrations stay applied.
+func Reset(ctx context.Context, db *sql.DB) error {
+	_, err := db.ExecContext(ctx, "TRUNCATE users, user_events, audit_log, archived_users, orders, webhook_deliveries RESTART IDENTITY CASCADE")
+	if err != nil {
+		return fmt.Errorf("failed to reset database: %w", err)
+	}
+	return nil
+}
+
+var (
+	firstNames = []string{
+		"Aarav", "Amelia", "Benjamin", "Chloe", "Daniel", "Dechen", "Elena", "Ethan",
+		"Fatima", "Gabriel", "Hana", "Isaac", "Jigme", "Karma", "Leila", "Lucas",
+		"Maya", "Mateo", "Nora", "Olivia", "Pema", "Priya", "Rafael", "Sakura",
+		"Sonam", "Tashi", "Tenzin", "Yuki", "Zara", "Zoe",
+	}
+	lastNames = []string{
+		"Andersen", "Bhandari", "Chen", "Dorji", "Garcia", "Gyeltshen", "Hansen", "Ito",
+		"Johnson", "Kim", "Lopez", "Martin", "Nakamura", "Novak", "Okafor", "Patel",
+		"Rossi", "Sato", "Schmidt", "Silva", "Smith", "Tshering", "Wangchuk", "Weber",
+		"Williams", "Yamamoto",
+	}
+)
+
+// GenerateUsers returns n users with realistic names, derived only from
+// seed: first.last<i>@example.com emails, about a tenth admins and a tenth
+// guests, UUIDs, and creation times within the year from 2024-01-01,
+// never decreasing. IDs are left zero.
+func GenerateUsers(seed uint64, n int) []models.User {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	users := make([]models.User, n)
+	createdAt := generatedEpoch
+	step := 365 * 24 * time.Hour / time.Duration(max(n, 1))
+
+	for i := range users {
+		first := firstNames[rng.IntN(len(firstNames))]
+		last := lastNames[rng.IntN(len(lastNames))]
+
+		role := models.RoleMember
+		switch rng.IntN(10) {
+		case 0:
+			role = models.RoleAdmin
+		case 1:
+			role = models.RoleGuest
+		}
+
+		createdAt = createdAt.Add(time.Duration(rng.Int64N(int64(step)))).Truncate(time.Second)
+		users[i] = models.User{
+			UUID:      generateUUID(rng),
+			Email:     fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), i+1),
+			Name:      first + " " + last,
+			Role:      role,
+			CreatedAt: createdAt,
+		}
+	}
+	return users
+}
+
+// generateLoadUsers is GenerateUsers with emails that can't collide with the
+// demo profile's, so both can be loaded into one database
+func generateLoadUsers(seed uint64, n int) []models.User {
+	users := GenerateUsers(seed, n)
+	for i := range users {
+		users[i].Email = fmt.Sprintf("load%d@example.com", i+1)
+	}
+	return users
+}
+
+// generateUUID returns a version 4 UUID from rng's output
+func generateUUID(rng *rand.Rand) uuid.UUID {
+	var id uuid.UUID
+	for i := 0; i < len(id); i += 8 {
+		v := rng.Uint64()
+		for j := 0; j < 8; j++ {
+			id[i+j] = byte(v >> (8 * j))
+		}
+	}
+	id[6] = id[6]&0x0f | 0x40 // version 4
+	id[8] = id[8]&0x3f | 0x80 // RFC 4122 variant
+	return id
+}
+
+// copyColumns are the users columns copyUsers fills
+var copyColumns = []string{"uuid", "email", "name", "role", "created_at"}
+
+// copyUsers inserts users with COPY, in order, through whichever driver db
+// was opened with. Rows copied in bulk record no user_events.
+func copyUsers(ctx context.Context, db *sql.DB, users []models.User) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	var isPgx bool
+	err = conn.Raw(func(driverConn any) error {
+		pgxConn, ok := driverConn.(*stdlib.Conn)
+		if !ok {
+			return nil
+		}
+		isPgx = true
+		// COPY sends binary values, and pgx only knows how to encode the
+		// user_role enum once the type is registered
+		roleType, err := pgxConn.Conn().LoadType(ctx, "user_role")
+		if err != nil {
+			return err
+		}
+		pgxConn.Conn().TypeMap().RegisterType(roleType)
+
+		rows := pgx.CopyFromSlice(len(users), func(i int) ([]any, error) {
+			u := users[i]
+			return []any{[16]byte(u.UUID), u.Email, u.Name, string(u.Role), u.CreatedAt}, nil
+		})
+		_, err = pgxConn.Conn().CopyFrom(ctx, pgx.Identifier{"users"}, copyColumns, rows)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to copy %d users: %w", len(users), err)
+	}
+	if isPgx {
+		return nil
+	}
+	return copyUsersPQ(ctx, conn, users)
+}
+
+// copyUsersPQ is copyUsers for github.com/lib/pq, whose COPY is a prepared
+// statement inside a transaction
+func copyUsersPQ(ctx context.Context, conn *sql.Conn, users []models.User) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("users", copyColumns...))
+	if err != nil {
+		return fmt.Errorf("failed to start copy: %w", err)
+	}
+	for _, u := range users {
+		if _, err := stmt.ExecContext(ctx, u.UUID.String(), u.Email, u.Name, string(u.Role), u.CreatedAt); err != nil {
+			stmt.Close()
+			return fmt.Errorf("failed to copy user %s: %w", u.Email, err)
+		}
+	}
+	// The final Exec without arguments flushes the buffered rows
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		stmt.Close()
+		return fmt.Errorf("failed to copy %d users: %w", len(users), err)
+	}
+	if err := stmt.Close(); err != nil {
+		return fmt.Errorf("failed to finish copy: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit copy: %w", err)
+	}
+	return nil
+}
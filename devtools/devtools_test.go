@@ -0,0 +1,226 @@
+package devtools_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"testcontainers-demo/devtools"
+	"testcontainers-demo/migrations"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/google/uuid"
+)
+
+// testContainer provides an empty database per test. It is nil when Docker
+// is unavailable, and only the integration tests need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// TestGenerateUsers tests that generated users depend on the seed alone
+func TestGenerateUsers(t *testing.T) {
+	users := devtools.GenerateUsers(devtools.DefaultSeed, devtools.DemoUsers)
+	if again := devtools.GenerateUsers(devtools.DefaultSeed, devtools.DemoUsers); !reflect.DeepEqual(users, again) {
+		t.Fatal("Expected the same users from the same seed")
+	}
+	if other := devtools.GenerateUsers(devtools.DefaultSeed+1, devtools.DemoUsers); reflect.DeepEqual(users, other) {
+		t.Fatal("Expected different users from a different seed")
+	}
+
+	// Pinned, so a change to the generator shows up here rather than in
+	// someone's local data
+	want := models.User{
+		UUID:      uuid.MustParse("8cb3b935-2300-48b3-8409-6bbc8b52328e"),
+		Email:     "mateo.chen1@example.com",
+		Name:      "Mateo Chen",
+		Role:      models.RoleMember,
+		CreatedAt: time.Date(2024, 1, 1, 4, 8, 53, 0, time.UTC),
+	}
+	if !reflect.DeepEqual(users[0], want) {
+		t.Errorf("Expected the first user to be %+v, got: %+v", want, users[0])
+	}
+
+	emails := map[string]bool{}
+	uuids := map[uuid.UUID]bool{}
+	roles := map[models.Role]int{}
+	for i, u := range users {
+		emails[u.Email], uuids[u.UUID] = true, true
+		roles[u.Role]++
+		if i > 0 && u.CreatedAt.Before(users[i-1].CreatedAt) {
+			t.Errorf("User %d was created before user %d", i, i-1)
+		}
+		if u.UUID.Version() != 4 {
+			t.Errorf("Expected a version 4 UUID, got: %s", u.UUID)
+		}
+	}
+	if len(emails) != len(users) || len(uuids) != len(users) {
+		t.Errorf("Expected unique emails and UUIDs, got %d and %d of %d", len(emails), len(uuids), len(users))
+	}
+	if roles[models.RoleAdmin] == 0 || roles[models.RoleGuest] == 0 {
+		t.Errorf("Expected some admins and guests, got: %v", roles)
+	}
+}
+
+// emptyDatabase returns a migrated database without seed users
+func emptyDatabase(ctx context.Context, t *testing.T) *sql.DB {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+	if err := migrations.RunMigrations(ctx, db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return db
+}
+
+// TestSeedDatabase tests every profile on a freshly reset database
+func TestSeedDatabase(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	// The same checks run on the database with whichever driver it was opened with
+	load := func(t *testing.T, db *sql.DB, profile string) *repository.UserRepository {
+		t.Helper()
+		if err := devtools.Reset(ctx, db); err != nil {
+			t.Fatalf("Failed to reset: %v", err)
+		}
+		if err := devtools.SeedDatabase(ctx, db, profile); err != nil {
+			t.Fatalf("Failed to seed %s: %v", profile, err)
+		}
+		repo := repository.NewUserRepository(db)
+		t.Cleanup(func() { repo.Close() })
+		return repo
+	}
+
+	t.Run("Minimal", func(t *testing.T) {
+		t.Parallel()
+		repo := load(t, emptyDatabase(ctx, t), devtools.ProfileMinimal)
+
+		users, err := repo.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		if len(users) != 2 || users[0].ID != 1 || users[0].Email != "alice@example.com" || users[1].Email != "bob@example.com" {
+			t.Errorf("Expected alice as user 1 and bob, got: %+v", users)
+		}
+	})
+
+	t.Run("Demo", func(t *testing.T) {
+		t.Parallel()
+		repo := load(t, emptyDatabase(ctx, t), devtools.ProfileDemo)
+
+		users, err := repo.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		want := devtools.GenerateUsers(devtools.DefaultSeed, devtools.DemoUsers)
+		if len(users) != len(want) {
+			t.Fatalf("Expected %d users, got: %d", len(want), len(users))
+		}
+		for i, u := range users {
+			w := want[i]
+			w.ID = i + 1
+			if !u.CreatedAt.Equal(w.CreatedAt) {
+				t.Errorf("User %d: expected created_at %s, got: %s", w.ID, w.CreatedAt, u.CreatedAt)
+			}
+			u.CreatedAt, w.CreatedAt = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(u, w) {
+				t.Errorf("Expected user %+v, got: %+v", w, u)
+			}
+		}
+
+		// A known row, by its lookup rather than its position
+		mateo, err := repo.GetByEmail(ctx, "mateo.chen1@example.com")
+		if err != nil || mateo.ID != 1 || mateo.UUID.String() != "8cb3b935-2300-48b3-8409-6bbc8b52328e" {
+			t.Errorf("Expected Mateo Chen as user 1, got: %+v, %v", mateo, err)
+		}
+	})
+
+	t.Run("Load", func(t *testing.T) {
+		t.Parallel()
+		if testing.Short() {
+			t.Skip("Copies 100k users")
+		}
+		repo := load(t, emptyDatabase(ctx, t), devtools.ProfileLoad)
+
+		count, err := repo.CountUsers(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		if count != devtools.LoadUsers {
+			t.Errorf("Expected %d users, got: %d", devtools.LoadUsers, count)
+		}
+		for _, tc := range []struct {
+			id    int
+			email string
+			name  string
+		}{
+			{12345, "load12345@example.com", "Gabriel Williams"},
+			{devtools.LoadUsers, "load100000@example.com", "Fatima Martin"},
+		} {
+			u, err := repo.GetByID(ctx, tc.id)
+			if err != nil {
+				t.Fatalf("Failed to get user %d: %v", tc.id, err)
+			}
+			if u.Email != tc.email || u.Name != tc.name {
+				t.Errorf("Expected user %d to be %s <%s>, got: %s <%s>", tc.id, tc.name, tc.email, u.Name, u.Email)
+			}
+		}
+	})
+
+	t.Run("Unknown Profile", func(t *testing.T) {
+		t.Parallel()
+		if err := devtools.SeedDatabase(ctx, emptyDatabase(ctx, t), "huge"); err == nil {
+			t.Error("Expected an unknown profile to be rejected")
+		}
+	})
+}
+
+// TestReset tests that Reset empties the tables and restarts the IDs
+func TestReset(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	db := emptyDatabase(ctx, t)
+	repo := repository.NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+
+	// Through the repository, so there are events to clear too
+	for _, email := range []string{"reset1@example.com", "reset2@example.com"} {
+		if _, err := repo.Create(ctx, email, "Reset User"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	if err := devtools.Reset(ctx, db); err != nil {
+		t.Fatalf("Failed to reset: %v", err)
+	}
+
+	for _, table := range []string{"users", "user_events", "audit_log"} {
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table).Scan(&n); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		if n != 0 {
+			t.Errorf("Expected %s to be empty, got %d rows", table, n)
+		}
+	}
+
+	user, err := repo.Create(ctx, "reset3@example.com", "Reset User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if user.ID != 1 {
+		t.Errorf("Expected the IDs to restart at 1, got: %d", user.ID)
+	}
+}
@@ -0,0 +1,138 @@
+// Package events streams user changes through NATS JetStream, a lighter
+// alternative to the Kafka outbox. Publisher plugs into
+// CachedUserRepository as its notifications.Publisher, and Subscriber
+// replays the USER_EVENTS stream to consumers.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"testcontainers-demo/notifications"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// StreamName is the JetStream stream user events are stored in
+const StreamName = "USER_EVENTS"
+
+// subjectPrefix starts the subject of every user event: users.<id>
+const subjectPrefix = "users."
+
+// DuplicateWindow is how long JetStream remembers event IDs, dropping a
+// retried publish of the same event within it
+const DuplicateWindow = 2 * time.Minute
+
+// DefaultAckWait is how long a Subscriber's consumer waits for an ack
+// before redelivering
+const DefaultAckWait = 30 * time.Second
+
+// Event is a user change as stored in the stream. Seq numbers each user's
+// events from 1 without gaps, in publish order, so a consumer can tell a
+// redelivery (Seq it has already applied) from the next change.
+type Event struct {
+	notifications.Event
+	Seq uint64 `json:"seq"`
+}
+
+// Subject returns the subject of user userID's events
+func Subject(userID int) string {
+	return subjectPrefix + strconv.Itoa(userID)
+}
+
+// CreateStream creates the USER_EVENTS stream, or updates an existing one
+// to the current configuration. Messages are kept until deleted: Publisher
+// reads the last one per user to number the next.
+func CreateStream(ctx context.Context, js jetstream.JetStream) (jetstream.Stream, error) {
+	stream, err := js.CreateOrUpdateStream(ctx, jetstream.StreamConfig{
+		Name:       StreamName,
+		Subjects:   []string{subjectPrefix + ">"},
+		Storage:    jetstream.FileStorage,
+		Duplicates: DuplicateWindow,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stream %s: %w", StreamName, err)
+	}
+	return stream, nil
+}
+
+// Publisher publishes events to the USER_EVENTS stream. It implements
+// notifications.Publisher.
+type Publisher struct {
+	js     jetstream.JetStream
+	stream jetstream.Stream
+}
+
+// NewPublisher creates the stream if needed and returns a publisher to it
+func NewPublisher(ctx context.Context, js jetstream.JetStream) (*Publisher, error) {
+	stream, err := CreateStream(ctx, js)
+	if err != nil {
+		return nil, err
+	}
+	return &Publisher{js: js, stream: stream}, nil
+}
+
+var _ notifications.Publisher = (*Publisher)(nil)
+
+// Publish appends event to its user's subject, numbered one after the
+// user's last event. The append only succeeds if that last event is still
+// the last, so concurrent publishers for one user retry rather than reuse a
+// number. The event ID is the message ID: publishing an event again is a
+// no-op.
+func (p *Publisher) Publish(ctx context.Context, event notifications.Event) error {
+	subject := Subject(event.UserID)
+	for {
+		last, lastSeq, err := p.last(ctx, subject)
+		if err != nil {
+			return err
+		}
+		if last != nil && last.ID == event.ID {
+			return nil
+		}
+
+		next := Event{Event: event, Seq: 1}
+		if last != nil {
+			next.Seq = last.Seq + 1
+		}
+		data, err := json.Marshal(next)
+		if err != nil {
+			return fmt.Errorf("failed to encode event %s: %w", event.ID, err)
+		}
+
+		_, err = p.js.Publish(ctx, subject, data,
+			jetstream.WithMsgID(event.ID.String()),
+			jetstream.WithExpectLastSequencePerSubject(lastSeq),
+		)
+		var apiErr *jetstream.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode == jetstream.JSErrCodeStreamWrongLastSequence {
+			// Another event for this user got in first; number after it
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to publish event %s: %w", event.ID, err)
+		}
+		return nil
+	}
+}
+
+// last returns the last event on subject and its stream sequence, or nil
+// and 0 if there is none
+func (p *Publisher) last(ctx context.Context, subject string) (*Event, uint64, error) {
+	msg, err := p.stream.GetLastMsgForSubject(ctx, subject)
+	if errors.Is(err, jetstream.ErrMsgNotFound) {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read last event on %s: %w", subject, err)
+	}
+
+	var event Event
+	if err := json.Unmarshal(msg.Data, &event); err != nil {
+		return nil, 0, fmt.Errorf("failed to decode event %d on %s: %w", msg.Sequence, subject, err)
+	}
+	return &event, msg.Sequence, nil
+}
@@ -0,0 +1,298 @@
+package events_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"testcontainers-demo/events"
+	"testcontainers-demo/migrations"
+	"testcontainers-demo/models"
+	"testcontainers-demo/notifications"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// testContainer provides a fresh database per test. It is nil when Docker is
+// unavailable, and then every test here is skipped.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// connect returns a JetStream client for a fresh NATS server
+func connect(ctx context.Context, t *testing.T) jetstream.JetStream {
+	t.Helper()
+
+	nc, err := nats.Connect(testhelpers.StartNATS(ctx, t))
+	if err != nil {
+		t.Fatalf("Failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(nc.Close)
+	js, err := jetstream.New(nc)
+	if err != nil {
+		t.Fatalf("Failed to create JetStream client: %v", err)
+	}
+	return js
+}
+
+// view is a materialized view of the users table built only from events.
+// Applying an event it has already applied changes nothing.
+type view struct {
+	mu      sync.Mutex
+	users   map[int]models.User
+	lastSeq map[int]uint64
+	applied map[int][]uint64 // per user, the Seq of every event applied, in order
+	total   int
+}
+
+func newView() *view {
+	return &view{users: map[int]models.User{}, lastSeq: map[int]uint64{}, applied: map[int][]uint64{}}
+}
+
+// apply updates the view from event, skipping redeliveries. An event
+// arriving ahead of its predecessor is an error.
+func (v *view) apply(event events.Event) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	id := event.UserID
+	if event.Seq <= v.lastSeq[id] {
+		return nil
+	}
+	if event.Seq != v.lastSeq[id]+1 {
+		return fmt.Errorf("user %d: got event %d after %d", id, event.Seq, v.lastSeq[id])
+	}
+
+	switch event.Type {
+	case models.EventUserCreated, models.EventUserUpdated:
+		v.users[id] = event.User
+	case models.EventUserDeleted:
+		delete(v.users, id)
+	}
+	v.lastSeq[id] = event.Seq
+	v.applied[id] = append(v.applied[id], event.Seq)
+	v.total++
+	return nil
+}
+
+// count returns how many distinct events the view has applied
+func (v *view) count() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.total
+}
+
+// TestEvents tests that a consumer rebuilds the users table from the stream
+// alone, seeing each user's events in order and applying a redelivered event
+// once
+func TestEvents(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	js := connect(ctx, t)
+
+	// An empty database rather than a clone: seed users have no events
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+	if err := migrations.RunMigrations(ctx, db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	publisher, err := events.NewPublisher(ctx, js)
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+	cachedRepo := repository.NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t), repository.WithPublisher(publisher))
+
+	// Each user's writes run in their own goroutine, interleaving with the others'
+	const users, updates = 5, 3
+	var wg sync.WaitGroup
+	errs := make(chan error, users)
+	for i := 0; i < users; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			email := fmt.Sprintf("events%d@example.com", i)
+			user, err := cachedRepo.CreateCached(ctx, email, "Events User")
+			if err != nil {
+				errs <- err
+				return
+			}
+			for n := 1; n <= updates; n++ {
+				if err := cachedRepo.UpdateCached(ctx, user.ID, email, fmt.Sprintf("Events User v%d", n)); err != nil {
+					errs <- err
+					return
+				}
+			}
+			// Every other user is deleted, so the view must drop them
+			if i%2 == 0 {
+				if err := cachedRepo.DeleteCached(ctx, user.ID); err != nil {
+					errs <- err
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Failed to write users: %v", err)
+	}
+	want := users*(1+updates) + (users+1)/2
+
+	// Fail the first delivery of one event after applying it, as a handler
+	// crashing between its write and its ack would
+	v := newView()
+	var mu sync.Mutex
+	deliveries := map[uuid.UUID]int{}
+	var nacked uuid.UUID
+	handle := func(ctx context.Context, event events.Event) error {
+		if err := v.apply(event); err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		deliveries[event.ID]++
+		if nacked == uuid.Nil && event.Type == models.EventUserUpdated {
+			nacked = event.ID
+			return errors.New("simulated failure after applying")
+		}
+		return nil
+	}
+
+	subscriber, err := events.NewSubscriber(ctx, js, "users-view", events.WithAckWait(5*time.Second))
+	if err != nil {
+		t.Fatalf("Failed to create subscriber: %v", err)
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan error, 1)
+	go func() { done <- subscriber.Run(runCtx, handle) }()
+
+	for deadline := time.Now().Add(30 * time.Second); v.count() < want && time.Now().Before(deadline); {
+		time.Sleep(100 * time.Millisecond)
+	}
+	// The redelivery comes after the nak, possibly after the last new event
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		mu.Lock()
+		redelivered := nacked != uuid.Nil && deliveries[nacked] > 1
+		mu.Unlock()
+		if redelivered {
+			break
+		}
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Subscriber failed: %v", err)
+	}
+
+	if got := v.count(); got != want {
+		t.Fatalf("Expected %d events applied, got: %d", want, got)
+	}
+	if nacked == uuid.Nil || deliveries[nacked] != 2 {
+		t.Errorf("Expected the nacked event to be delivered twice, got: %d", deliveries[nacked])
+	}
+	for id, n := range deliveries {
+		if id != nacked && n != 1 {
+			t.Errorf("Expected event %s to be delivered once, got: %d", id, n)
+		}
+	}
+
+	// Ordering: every user's events were applied as 1, 2, ... n
+	for id, seqs := range v.applied {
+		for i, seq := range seqs {
+			if seq != uint64(i+1) {
+				t.Errorf("User %d: expected events in order 1..%d, got: %v", id, len(seqs), seqs)
+				break
+			}
+		}
+	}
+
+	// The view matches the table
+	repo := repository.NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+	listed, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	table := map[int]models.User{}
+	for _, u := range listed {
+		table[u.ID] = u
+	}
+	if len(table) != len(v.users) {
+		t.Fatalf("Expected %d users in the view, got: %d", len(table), len(v.users))
+	}
+	for id, u := range table {
+		got, ok := v.users[id]
+		if !ok {
+			t.Errorf("Expected user %d in the view", id)
+			continue
+		}
+		got.CreatedAt, u.CreatedAt = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(got, u) {
+			t.Errorf("User %d: view has %+v, table has %+v", id, got, u)
+		}
+	}
+}
+
+// TestPublishTwice tests that publishing an event again neither stores it
+// twice nor takes a sequence number
+func TestPublishTwice(t *testing.T) {
+	ctx := context.Background()
+	js := connect(ctx, t)
+	publisher, err := events.NewPublisher(ctx, js)
+	if err != nil {
+		t.Fatalf("Failed to create publisher: %v", err)
+	}
+
+	user := models.User{ID: 42, Email: "twice@example.com", Name: "Twice"}
+	created := notifications.NewEvent(models.EventUserCreated, user)
+	updated := notifications.NewEvent(models.EventUserUpdated, user)
+	for _, event := range []notifications.Event{created, created, updated} {
+		if err := publisher.Publish(ctx, event); err != nil {
+			t.Fatalf("Failed to publish %s: %v", event.Type, err)
+		}
+	}
+
+	stream, err := js.Stream(ctx, events.StreamName)
+	if err != nil {
+		t.Fatalf("Failed to get stream: %v", err)
+	}
+	info, err := stream.Info(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get stream info: %v", err)
+	}
+	if info.State.Msgs != 2 {
+		t.Errorf("Expected 2 messages, got: %d", info.State.Msgs)
+	}
+
+	msg, err := stream.GetLastMsgForSubject(ctx, events.Subject(user.ID))
+	if err != nil {
+		t.Fatalf("Failed to get last event: %v", err)
+	}
+	var last events.Event
+	if err := json.Unmarshal(msg.Data, &last); err != nil {
+		t.Fatalf("Failed to decode last event: %v", err)
+	}
+	if last.ID != updated.ID || last.Seq != 2 {
+		t.Errorf("Expected the update as event 2, got %s as event %d", last.Type, last.Seq)
+	}
+}
@@ -0,0 +1,107 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// fetchWait is how long Run waits for a message before checking ctx again
+const fetchWait = time.Second
+
+// Handler processes one event. Returning an error naks the message, so it
+// is delivered again: a handler must be idempotent, which Event.Seq makes
+// straightforward.
+type Handler func(ctx context.Context, event Event) error
+
+// Subscriber reads the USER_EVENTS stream through a durable consumer, from
+// the first event on, resuming where the last Subscriber of the same name
+// acked. At most one message is in flight, so events arrive in stream
+// order, and each user's in Seq order, even across redeliveries.
+type Subscriber struct {
+	consumer jetstream.Consumer
+	ackWait  time.Duration
+}
+
+// SubscriberOption configures a Subscriber
+type SubscriberOption func(*Subscriber)
+
+// WithAckWait sets how long the consumer waits for a handler before
+// delivering its message again
+func WithAckWait(d time.Duration) SubscriberOption {
+	return func(s *Subscriber) {
+		s.ackWait = d
+	}
+}
+
+// NewSubscriber creates the stream if needed, and the durable consumer
+// named durable on it, returning a subscriber reading from that consumer
+func NewSubscriber(ctx context.Context, js jetstream.JetStream, durable string, opts ...SubscriberOption) (*Subscriber, error) {
+	s := &Subscriber{ackWait: DefaultAckWait}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	stream, err := CreateStream(ctx, js)
+	if err != nil {
+		return nil, err
+	}
+	s.consumer, err = stream.CreateOrUpdateConsumer(ctx, jetstream.ConsumerConfig{
+		Durable:       durable,
+		DeliverPolicy: jetstream.DeliverAllPolicy,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       s.ackWait,
+		MaxAckPending: 1,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create consumer %s: %w", durable, err)
+	}
+	return s, nil
+}
+
+// Run passes each event to handle until ctx is done, acking it on success
+// and naking it on error. A message that doesn't decode as an Event is
+// logged and terminated, since redelivering it can't help. It returns nil
+// once ctx is done.
+func (s *Subscriber) Run(ctx context.Context, handle Handler) error {
+	for ctx.Err() == nil {
+		batch, err := s.consumer.Fetch(1, jetstream.FetchMaxWait(fetchWait))
+		if err != nil {
+			return fmt.Errorf("failed to fetch events: %w", err)
+		}
+		for msg := range batch.Messages() {
+			s.process(ctx, msg, handle)
+		}
+		// An empty fetch is not an error: Error reports only real failures
+		if err := batch.Error(); err != nil {
+			return fmt.Errorf("failed to fetch events: %w", err)
+		}
+	}
+	return nil
+}
+
+// process hands msg to handle and acknowledges it accordingly
+func (s *Subscriber) process(ctx context.Context, msg jetstream.Msg, handle Handler) {
+	var event Event
+	if err := json.Unmarshal(msg.Data(), &event); err != nil {
+		log.Printf("events: dropping undecodable message on %s: %v", msg.Subject(), err)
+		if err := msg.Term(); err != nil {
+			log.Printf("events: failed to terminate message: %v", err)
+		}
+		return
+	}
+
+	if err := handle(ctx, event); err != nil {
+		if err := msg.Nak(); err != nil {
+			log.Printf("events: failed to nak event %s: %v", event.ID, err)
+		}
+		return
+	}
+	if err := msg.Ack(); err != nil {
+		log.Printf("events: failed to ack event %s: %v", event.ID, err)
+	}
+}
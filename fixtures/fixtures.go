@@ -0,0 +1,216 @@
+// Package fixtures builds and inserts test users so tests don't depend on the
+// rows in migrations/seed.sql
+package fixtures
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unicode"
+
+	"testcontainers-demo/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DB is the subset of *sql.DB and *sql.Tx the fixtures need
+type DB interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// sequence makes default emails unique within a test binary; runID keeps them
+// unique across runs against a long-lived database
+var (
+	sequence atomic.Int64
+	runID    = time.Now().UnixNano()
+)
+
+// UserBuilder describes a user to insert; zero fields get unique defaults
+type UserBuilder struct {
+	email     string
+	name      string
+	role      models.Role
+	createdAt time.Time
+}
+
+// NewUser starts a builder with a unique email and a generic name
+func NewUser() *UserBuilder {
+	n := sequence.Add(1)
+	return &UserBuilder{
+		email: fmt.Sprintf("fixture-%d-%d@example.com", runID, n),
+		name:  fmt.Sprintf("Fixture User %d", n),
+	}
+}
+
+// maxEmailPrefix bounds the test-name part of a GenerateEmail address
+const maxEmailPrefix = 40
+
+// GenerateEmail returns a lowercase email no other test, run, or call gets,
+// e.g. "testcreate-new-user-1712345678-7@example.com", so parallel tests
+// sharing a database never collide on the unique email index
+func GenerateEmail(t testing.TB) string {
+	n := sequence.Add(1)
+
+	prefix := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, t.Name())
+	if len(prefix) > maxEmailPrefix {
+		prefix = prefix[:maxEmailPrefix]
+	}
+	prefix = strings.Trim(prefix, "-")
+
+	return fmt.Sprintf("%s-%d-%d@example.com", prefix, runID, n)
+}
+
+// WithEmail sets the user's email
+func (b *UserBuilder) WithEmail(email string) *UserBuilder {
+	b.email = email
+	return b
+}
+
+// WithName sets the user's name
+func (b *UserBuilder) WithName(name string) *UserBuilder {
+	b.name = name
+	return b
+}
+
+// WithRole sets the user's role
+func (b *UserBuilder) WithRole(role models.Role) *UserBuilder {
+	b.role = role
+	return b
+}
+
+// WithCreatedAt back- or forward-dates the user's created_at
+func (b *UserBuilder) WithCreatedAt(createdAt time.Time) *UserBuilder {
+	b.createdAt = createdAt
+	return b
+}
+
+// Build returns the user the builder describes, without an ID
+func (b *UserBuilder) Build() models.User {
+	return models.User{
+		Email:     b.email,
+		Name:      b.name,
+		Role:      b.role,
+		CreatedAt: b.createdAt,
+	}
+}
+
+// SeedUsers inserts one user per builder, in order, and deletes them when the
+// test finishes. Users built without WithRole or WithCreatedAt get the
+// database defaults.
+func SeedUsers(t testing.TB, db DB, builders ...*UserBuilder) []models.User {
+	t.Helper()
+
+	users := make([]models.User, 0, len(builders))
+	for _, b := range builders {
+		users = append(users, insert(t, db, b.Build()))
+	}
+	return users
+}
+
+// insert writes one user and registers its cleanup
+func insert(t testing.TB, db DB, u models.User) models.User {
+	t.Helper()
+
+	var role, createdAt interface{}
+	if u.Role != "" {
+		role = u.Role
+	}
+	if !u.CreatedAt.IsZero() {
+		createdAt = u.CreatedAt
+	}
+
+	query := `
+		INSERT INTO users (email, name, role, created_at)
+		VALUES ($1, $2, COALESCE($3::user_role, 'member'), COALESCE($4, CURRENT_TIMESTAMP))
+		RETURNING id, uuid, email, name, role, created_at
+	`
+
+	var user models.User
+	err := db.QueryRow(query, u.Email, u.Name, role, createdAt).Scan(
+		&user.ID,
+		&user.UUID,
+		&user.Email,
+		&user.Name,
+		&user.Role,
+		&user.CreatedAt,
+	)
+	if err != nil {
+		t.Fatalf("Failed to seed user %s: %v", u.Email, err)
+	}
+	// As the repository scans it, whatever the session's TimeZone
+	user.CreatedAt = user.CreatedAt.UTC()
+
+	t.Cleanup(func() {
+		db.Exec("DELETE FROM users WHERE id = $1", user.ID)
+	})
+
+	return user
+}
+
+// yamlFile is the layout of a YAML fixtures file
+type yamlFile struct {
+	Users []struct {
+		Email     string      `yaml:"email"`
+		Name      string      `yaml:"name"`
+		Role      models.Role `yaml:"role"`
+		CreatedAt time.Time   `yaml:"created_at"`
+	} `yaml:"users"`
+}
+
+// LoadYAMLFixtures inserts the users listed in a YAML file and deletes them
+// when the test finishes:
+//
+//	users:
+//	  - email: ada@example.com
+//	    name: Ada Lovelace
+//	    role: admin # optional
+//	    created_at: 2024-01-02T15:04:05Z # optional
+func LoadYAMLFixtures(t testing.TB, db DB, path string) []models.User {
+	t.Helper()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read fixtures %s: %v", path, err)
+	}
+
+	builders, err := parseYAML(data)
+	if err != nil {
+		t.Fatalf("Failed to parse fixtures %s: %v", path, err)
+	}
+
+	return SeedUsers(t, db, builders...)
+}
+
+// parseYAML turns a fixtures file into builders
+func parseYAML(data []byte) ([]*UserBuilder, error) {
+	var file yamlFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	builders := make([]*UserBuilder, 0, len(file.Users))
+	for i, u := range file.Users {
+		if u.Email == "" || u.Name == "" {
+			return nil, fmt.Errorf("user %d: email and name are required", i)
+		}
+		builders = append(builders, NewUser().
+			WithEmail(u.Email).
+			WithName(u.Name).
+			WithRole(u.Role).
+			WithCreatedAt(u.CreatedAt))
+	}
+	return builders, nil
+}
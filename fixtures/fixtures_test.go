@@ -0,0 +1,184 @@
+package fixtures
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+)
+
+// TestUserBuilder tests builder defaults and overrides
+func TestUserBuilder(t *testing.T) {
+	t.Run("Defaults Are Unique", func(t *testing.T) {
+		a := NewUser().Build()
+		b := NewUser().Build()
+
+		if a.Email == "" || a.Name == "" {
+			t.Fatalf("Expected default email and name, got: %+v", a)
+		}
+		if a.Email == b.Email {
+			t.Errorf("Expected unique default emails, both were: %s", a.Email)
+		}
+		if !a.CreatedAt.IsZero() {
+			t.Errorf("Expected zero created_at so the database default applies, got: %s", a.CreatedAt)
+		}
+	})
+
+	t.Run("Overrides", func(t *testing.T) {
+		createdAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		u := NewUser().
+			WithEmail("ada@example.com").
+			WithName("Ada Lovelace").
+			WithCreatedAt(createdAt).
+			Build()
+
+		if u.Email != "ada@example.com" {
+			t.Errorf("Expected email 'ada@example.com', got: %s", u.Email)
+		}
+		if u.Name != "Ada Lovelace" {
+			t.Errorf("Expected name 'Ada Lovelace', got: %s", u.Name)
+		}
+		if !u.CreatedAt.Equal(createdAt) {
+			t.Errorf("Expected created_at %s, got: %s", createdAt, u.CreatedAt)
+		}
+	})
+}
+
+// TestParseYAML tests reading a fixtures file
+func TestParseYAML(t *testing.T) {
+	t.Run("Valid File", func(t *testing.T) {
+		data := []byte(`
+users:
+  - email: ada@example.com
+    name: Ada Lovelace
+    role: admin
+    created_at: 2024-01-02T03:04:05Z
+  - email: grace@example.com
+    name: Grace Hopper
+`)
+		builders, err := parseYAML(data)
+		if err != nil {
+			t.Fatalf("Failed to parse fixtures: %v", err)
+		}
+		if len(builders) != 2 {
+			t.Fatalf("Expected 2 users, got: %d", len(builders))
+		}
+
+		ada := builders[0].Build()
+		if ada.Email != "ada@example.com" || ada.Name != "Ada Lovelace" {
+			t.Errorf("Unexpected first user: %+v", ada)
+		}
+		if want := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC); !ada.CreatedAt.Equal(want) {
+			t.Errorf("Expected created_at %s, got: %s", want, ada.CreatedAt)
+		}
+		if ada.Role != models.RoleAdmin {
+			t.Errorf("Expected role admin, got: %q", ada.Role)
+		}
+
+		grace := builders[1].Build()
+		if !grace.CreatedAt.IsZero() {
+			t.Errorf("Expected zero created_at when omitted, got: %s", grace.CreatedAt)
+		}
+		if grace.Role != "" {
+			t.Errorf("Expected empty role when omitted so the database default applies, got: %q", grace.Role)
+		}
+	})
+
+	t.Run("Missing Field", func(t *testing.T) {
+		_, err := parseYAML([]byte("users:\n  - email: nameless@example.com\n"))
+		if err == nil {
+			t.Fatal("Expected error for user without a name")
+		}
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		_, err := parseYAML([]byte("users: [unclosed"))
+		if err == nil {
+			t.Fatal("Expected error for malformed YAML")
+		}
+	})
+}
+
+// TestGenerateEmail tests that generated emails are unique, lowercase, and name the test
+func TestGenerateEmail(t *testing.T) {
+	a := GenerateEmail(t)
+	b := GenerateEmail(t)
+
+	if a == b {
+		t.Errorf("Expected unique emails, both were: %s", a)
+	}
+	if !strings.HasPrefix(a, "testgenerateemail-") || !strings.HasSuffix(a, "@example.com") {
+		t.Errorf("Expected a testgenerateemail-...@example.com address, got: %s", a)
+	}
+
+	t.Run("Long Subtest Name With Spaces And CAPS", func(t *testing.T) {
+		email := GenerateEmail(t)
+		local := strings.TrimSuffix(email, "@example.com")
+		if local != strings.ToLower(local) || strings.ContainsAny(local, " /_") {
+			t.Errorf("Expected a lowercase local part without spaces or slashes, got: %s", email)
+		}
+		if len(local) > maxEmailPrefix+40 {
+			t.Errorf("Expected the test name to be truncated, got: %s", email)
+		}
+	})
+}
+
+// TestGenerateUsers tests that generated users depend only on the seed, are
+// accepted by validation unchanged, and cover every edge-case bucket
+func TestGenerateUsers(t *testing.T) {
+	const n = 500
+	users := GenerateUsers(42, n)
+	if len(users) != n {
+		t.Fatalf("Expected %d users, got: %d", n, len(users))
+	}
+	if again := GenerateUsers(42, n); !reflect.DeepEqual(users, again) {
+		t.Error("Expected the same users from the same seed")
+	}
+	if other := GenerateUsers(43, n); reflect.DeepEqual(users, other) {
+		t.Error("Expected different users from another seed")
+	}
+
+	seen := map[string]bool{}
+	for i, u := range users {
+		in := repository.CreateUserInput{Email: u.Email, Name: u.Name, Role: u.Role}
+		if err := in.Validate(); err != nil {
+			t.Errorf("User %d %q: %v", i, u.Email, err)
+		}
+		if in.Normalized() != in {
+			t.Errorf("User %d: expected %+v to be stored unchanged, got: %+v", i, in, in.Normalized())
+		}
+		if seen[u.Email] {
+			t.Errorf("User %d: duplicate email %s", i, u.Email)
+		}
+		seen[u.Email] = true
+	}
+
+	buckets := map[string]func(models.User) bool{
+		"255-character name": func(u models.User) bool { return utf8.RuneCountInString(u.Name) == MaxNameLength },
+		"emoji": func(u models.User) bool {
+			return strings.ContainsRune(u.Name, '🚀') || strings.ContainsRune(u.Name, '🦀')
+		},
+		"right-to-left": func(u models.User) bool {
+			return strings.ContainsFunc(u.Name, func(r rune) bool { return unicode.In(r, unicode.Arabic, unicode.Hebrew) })
+		},
+		"combining mark": func(u models.User) bool {
+			return strings.ContainsFunc(u.Name, func(r rune) bool { return unicode.Is(unicode.Mn, r) })
+		},
+		"plus address": func(u models.User) bool { return strings.Contains(u.Email, "+") },
+		"unicode email": func(u models.User) bool {
+			return strings.ContainsFunc(u.Email, func(r rune) bool { return r > unicode.MaxASCII })
+		},
+		"64-character local": func(u models.User) bool { return strings.Index(u.Email, "@") == 64 },
+	}
+	for name, match := range buckets {
+		if !slices.ContainsFunc(users, match) {
+			t.Errorf("Expected a user with a %s", name)
+		}
+	}
+}
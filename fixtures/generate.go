@@ -0,0 +1,168 @@
+package fixtures
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"testcontainers-demo/models"
+)
+
+// Repository is the subset of repository.UserStore InsertGenerated needs
+type Repository interface {
+	CreateWithRole(ctx context.Context, email, name string, role models.Role) (*models.User, error)
+	Delete(ctx context.Context, id int) error
+}
+
+// MaxNameLength is the longest name GenerateUser produces, the users.name
+// limit in characters
+const MaxNameLength = 255
+
+// Name pools for GenerateUser, one per edge case it covers
+var (
+	plainFirst = []string{"Ada", "Alan", "Grace", "Karma", "Linus", "Margaret", "Pema", "Tenzin"}
+	plainLast  = []string{"Dorji", "Hamilton", "Hopper", "Lovelace", "Torvalds", "Turing", "Wangmo"}
+
+	accentedNames = []string{
+		"José Müller", "Zoë Ångström", "Łukasz Øberg", "François Lefèvre", "Ångel Ñúñez",
+		"Ame\u0301lie Poulain", // e + combining acute, not precomposed
+		"Đặng Thị Hương",
+	}
+	punctuatedNames = []string{
+		"O'Brien", `"Pat" D'Arcy-Smith`, "Jean-Luc Picard, Jr.", "Dr. Jane  Doe",
+		`Back\Slash`, "100% Real_Name", "Ann (Annie) Lee",
+	}
+	emojiNames = []string{
+		"Maya 🚀 Chen", "👩‍💻 Dev Person", "Flag 🇧🇹 Fan", "Wave 👋🏽", "🦀", "Zero\u200bWidth Space",
+	}
+	rtlNames = []string{
+		"ليلى حداد", "נועה כהן", "Omar عمر", "مریم رضایی", "Ari אריאל 42",
+	}
+	otherScripts = []string{
+		"山田 太郎", "김민준", "Дмитрий Иванов", "ཀུན་བཟང་ རྡོ་རྗེ", "Αλέξανδρος", "สมชาย ใจดี",
+	}
+
+	// longNameRunes fill 255-character names with runes of 1 to 4 bytes
+	longNameRunes = []rune("aé山🙂ع ")
+
+	// specialLocals are local parts with every character net/mail accepts
+	// unquoted. Quoted local parts like "john doe"@example.com are left
+	// out: net/mail unquotes them, so Validate rejects them.
+	specialLocals = []string{
+		"o'brien", "a!b#c$d", "%percent%", "under_score", "{braces}|pipe~", "a=b?c^d`e", "x-y/z", "&amp*",
+	}
+	unicodeLocals = []string{"josé", "ünïcödé", "山田", "ليلى", "δοκιμή"}
+
+	domains = []string{"example.com", "example.org", "mail.example.net", "exämple.com", "sub.domain.example.co"}
+)
+
+// GenerateUser returns a user Create accepts unchanged: its email is
+// lowercase and its name trimmed, so they round-trip byte for byte. Each
+// call draws the name and the email from one of several buckets, some
+// plain and some edge cases: diacritics and combining marks, quotes and
+// LIKE wildcards, emoji, right-to-left and other scripts, 255-character
+// names, plus-addressed, punctuated, unicode, and 64-character local parts.
+// ID, UUID, and CreatedAt are left zero.
+func GenerateUser(r *rand.Rand) models.User {
+	return models.User{
+		Email: generateEmail(r),
+		Name:  generateName(r),
+		Role:  models.Roles[r.IntN(len(models.Roles))],
+	}
+}
+
+// GenerateUsers returns n users from GenerateUser, derived only from seed,
+// with distinct emails
+func GenerateUsers(seed int64, n int) []models.User {
+	r := rand.New(rand.NewPCG(uint64(seed), 0))
+	users := make([]models.User, 0, n)
+	seen := make(map[string]bool, n)
+	for len(users) < n {
+		u := GenerateUser(r)
+		if seen[u.Email] {
+			continue
+		}
+		seen[u.Email] = true
+		users = append(users, u)
+	}
+	return users
+}
+
+// InsertGenerated creates GenerateUsers(seed, n) through repo and deletes
+// them when the test finishes. It returns the created users, in order.
+func InsertGenerated(t testing.TB, repo Repository, seed int64, n int) []models.User {
+	t.Helper()
+	ctx := context.Background()
+
+	created := make([]models.User, 0, n)
+	for i, u := range GenerateUsers(seed, n) {
+		user, err := repo.CreateWithRole(ctx, u.Email, u.Name, u.Role)
+		if err != nil {
+			t.Fatalf("Failed to create generated user %d (seed %d) %q: %v", i, seed, u.Email, err)
+		}
+		t.Cleanup(func() { repo.Delete(ctx, user.ID) })
+		created = append(created, *user)
+	}
+	return created
+}
+
+// pick returns a random element of pool
+func pick(r *rand.Rand, pool []string) string {
+	return pool[r.IntN(len(pool))]
+}
+
+// generateName draws a name from a random bucket
+func generateName(r *rand.Rand) string {
+	switch r.IntN(8) {
+	case 0:
+		return pick(r, accentedNames)
+	case 1:
+		return pick(r, punctuatedNames)
+	case 2:
+		return pick(r, emojiNames)
+	case 3:
+		return pick(r, rtlNames)
+	case 4:
+		return pick(r, otherScripts)
+	case 5:
+		return longName(r)
+	default:
+		return pick(r, plainFirst) + " " + pick(r, plainLast)
+	}
+}
+
+// longName returns a name of exactly MaxNameLength characters, not starting
+// or ending with a space
+func longName(r *rand.Rand) string {
+	runes := make([]rune, MaxNameLength)
+	for i := range runes {
+		runes[i] = longNameRunes[r.IntN(len(longNameRunes))]
+	}
+	runes[0], runes[len(runes)-1] = 'L', 'g'
+	return string(runes)
+}
+
+// generateEmail draws a lowercase email from a random bucket. The random
+// number keeps collisions rare; GenerateUsers skips the ones that happen.
+func generateEmail(r *rand.Rand) string {
+	n := r.IntN(1_000_000)
+	var local string
+	switch r.IntN(6) {
+	case 0:
+		local = fmt.Sprintf("%s+%s%d", strings.ToLower(pick(r, plainFirst)), pick(r, []string{"news", "test", "a+b"}), n)
+	case 1:
+		local = fmt.Sprintf("%s%d", pick(r, specialLocals), n)
+	case 2:
+		local = fmt.Sprintf("%s%d", pick(r, unicodeLocals), n)
+	case 3:
+		// The longest local part RFC 5321 allows
+		local = fmt.Sprintf("%d.", n)
+		local += strings.Repeat("l", 64-utf8.RuneCountInString(local))
+	default:
+		local = fmt.Sprintf("%s.%s%d", strings.ToLower(pick(r, plainFirst)), strings.ToLower(pick(r, plainLast)), n)
+	}
+	return local + "@" + pick(r, domains)
+}
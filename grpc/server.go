@@ -0,0 +1,196 @@
+// Package grpc exposes the user repository as a gRPC UserService
+package grpc
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"testcontainers-demo/grpc/userpb"
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/repository"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultPageSize is used when a request doesn't set page_size
+	defaultPageSize = 20
+
+	// maxPageSize caps page_size so one request can't load the whole table
+	maxPageSize = 100
+)
+
+// Server implements userpb.UserServiceServer on top of UserRepository
+type Server struct {
+	userpb.UnimplementedUserServiceServer
+	repo *repository.UserRepository
+}
+
+// NewServer creates a new gRPC user service backed by the given repository
+func NewServer(repo *repository.UserRepository) *Server {
+	return &Server{repo: repo}
+}
+
+// GetUser retrieves a user by ID
+func (s *Server) GetUser(ctx context.Context, req *userpb.GetUserRequest) (*userpb.User, error) {
+	if req.GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "id must be positive")
+	}
+
+	user, err := s.repo.GetByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(user), nil
+}
+
+// CreateUser inserts a new user
+func (s *Server) CreateUser(ctx context.Context, req *userpb.CreateUserRequest) (*userpb.User, error) {
+	if err := validate(req.GetEmail(), req.GetName()); err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.Create(ctx, req.GetEmail(), req.GetName())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(user), nil
+}
+
+// UpdateUser modifies an existing user and returns the updated row
+func (s *Server) UpdateUser(ctx context.Context, req *userpb.UpdateUserRequest) (*userpb.User, error) {
+	if req.GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "id must be positive")
+	}
+	if err := validate(req.GetEmail(), req.GetName()); err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.Update(ctx, int(req.GetId()), req.GetEmail(), req.GetName()); err != nil {
+		return nil, toStatus(err)
+	}
+
+	user, err := s.repo.GetByID(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return toProto(user), nil
+}
+
+// DeleteUser removes a user
+func (s *Server) DeleteUser(ctx context.Context, req *userpb.DeleteUserRequest) (*userpb.DeleteUserResponse, error) {
+	if req.GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "id must be positive")
+	}
+
+	if err := s.repo.Delete(ctx, int(req.GetId())); err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &userpb.DeleteUserResponse{}, nil
+}
+
+// ListUsers returns one page of users ordered by ID. Page tokens are the
+// repository's cursors.
+func (s *Server) ListUsers(ctx context.Context, req *userpb.ListUsersRequest) (*userpb.ListUsersResponse, error) {
+	page, err := s.repo.ListPaginated(ctx, req.GetPageToken(), pageSize(req.GetPageSize()))
+	if errors.Is(err, pagination.ErrInvalidCursor) {
+		return nil, status.Error(codes.InvalidArgument, "invalid page_token")
+	}
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &userpb.ListUsersResponse{NextPageToken: page.NextCursor}
+	for i := range page.Items {
+		resp.Users = append(resp.Users, toProto(&page.Items[i]))
+	}
+
+	return resp, nil
+}
+
+// StreamUsers streams every user ordered by ID, one page of rows at a time
+func (s *Server) StreamUsers(req *userpb.StreamUsersRequest, stream userpb.UserService_StreamUsersServer) error {
+	ctx := stream.Context()
+	pageSize := pageSize(req.GetPageSize())
+
+	cursor := ""
+	for {
+		if err := ctx.Err(); err != nil {
+			return status.FromContextError(err).Err()
+		}
+
+		page, err := s.repo.ListPaginated(ctx, cursor, pageSize)
+		if err != nil {
+			return toStatus(err)
+		}
+
+		for i := range page.Items {
+			if err := stream.Send(toProto(&page.Items[i])); err != nil {
+				return err
+			}
+		}
+
+		if !page.HasMore {
+			return nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// pageSize applies the default and maximum to a requested page size
+func pageSize(requested int32) int {
+	switch {
+	case requested <= 0:
+		return defaultPageSize
+	case requested > maxPageSize:
+		return maxPageSize
+	default:
+		return int(requested)
+	}
+}
+
+// validate checks create/update input before it reaches the repository
+func validate(email, name string) error {
+	if strings.TrimSpace(email) == "" {
+		return status.Error(codes.InvalidArgument, "email is required")
+	}
+	if strings.TrimSpace(name) == "" {
+		return status.Error(codes.InvalidArgument, "name is required")
+	}
+	return nil
+}
+
+// toStatus maps repository errors to gRPC status codes
+func toStatus(err error) error {
+	var validationErr *repository.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		return status.Error(codes.InvalidArgument, validationErr.Error())
+	case errors.Is(err, repository.ErrUserNotFound):
+		return status.Error(codes.NotFound, repository.ErrUserNotFound.Error())
+	case errors.Is(err, repository.ErrDuplicateEmail):
+		return status.Error(codes.AlreadyExists, repository.ErrDuplicateEmail.Error())
+	default:
+		return status.Error(codes.Internal, err.Error())
+	}
+}
+
+// toProto converts a models.User to its protobuf message
+func toProto(user *models.User) *userpb.User {
+	return &userpb.User{
+		Id:        int64(user.ID),
+		Email:     user.Email,
+		Name:      user.Name,
+		CreatedAt: timestamppb.New(user.CreatedAt),
+	}
+}
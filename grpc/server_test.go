@@ -0,0 +1,244 @@
+package grpc
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"testing"
+
+	"testcontainers-demo/grpc/userpb"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+
+	gogrpc "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Global test database connection, nil when Docker is unavailable
+var testDB *sql.DB
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testDB = c.DB
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testDB == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// newTestClient serves the UserService on an in-memory bufconn listener
+// and returns a client connected to it
+func newTestClient(t *testing.T) userpb.UserServiceClient {
+	t.Helper()
+
+	lis := bufconn.Listen(1024 * 1024)
+	srv := gogrpc.NewServer()
+	userpb.RegisterUserServiceServer(srv, NewServer(repository.NewUserRepository(testDB)))
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := gogrpc.NewClient("passthrough:///bufnet",
+		gogrpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		gogrpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to dial bufconn: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return userpb.NewUserServiceClient(conn)
+}
+
+// expectCode fails the test if err doesn't carry the given gRPC status code
+func expectCode(t *testing.T, err error, want codes.Code) {
+	t.Helper()
+	if got := status.Code(err); got != want {
+		t.Fatalf("Expected code %s, got: %s (%v)", want, got, err)
+	}
+}
+
+// TestUserServiceCRUD exercises the unary RPCs end to end
+func TestUserServiceCRUD(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	client := newTestClient(t)
+
+	created, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Email: "grpc@example.com", Name: "GRPC User"})
+	if err != nil {
+		t.Fatalf("CreateUser failed: %v", err)
+	}
+	if created.GetId() == 0 {
+		t.Fatal("Expected non-zero ID for created user")
+	}
+	if !created.GetCreatedAt().IsValid() || created.GetCreatedAt().AsTime().IsZero() {
+		t.Error("Expected created_at timestamp")
+	}
+
+	t.Run("GetUser", func(t *testing.T) {
+		user, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: created.GetId()})
+		if err != nil {
+			t.Fatalf("GetUser failed: %v", err)
+		}
+		if user.GetEmail() != "grpc@example.com" {
+			t.Errorf("Expected email 'grpc@example.com', got: %s", user.GetEmail())
+		}
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		user, err := client.UpdateUser(ctx, &userpb.UpdateUserRequest{
+			Id:    created.GetId(),
+			Email: "grpc.updated@example.com",
+			Name:  "GRPC Updated",
+		})
+		if err != nil {
+			t.Fatalf("UpdateUser failed: %v", err)
+		}
+		if user.GetEmail() != "grpc.updated@example.com" || user.GetName() != "GRPC Updated" {
+			t.Errorf("Expected updated user, got: %v", user)
+		}
+	})
+
+	t.Run("CreateUser Duplicate Email", func(t *testing.T) {
+		_, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Email: "alice@example.com", Name: "Another Alice"})
+		expectCode(t, err, codes.AlreadyExists)
+	})
+
+	t.Run("CreateUser Invalid Argument", func(t *testing.T) {
+		_, err := client.CreateUser(ctx, &userpb.CreateUserRequest{Email: "", Name: "No Email"})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		if _, err := client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: created.GetId()}); err != nil {
+			t.Fatalf("DeleteUser failed: %v", err)
+		}
+
+		_, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: created.GetId()})
+		expectCode(t, err, codes.NotFound)
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := client.GetUser(ctx, &userpb.GetUserRequest{Id: 9999})
+		expectCode(t, err, codes.NotFound)
+
+		_, err = client.UpdateUser(ctx, &userpb.UpdateUserRequest{Id: 9999, Email: "nobody@example.com", Name: "Nobody"})
+		expectCode(t, err, codes.NotFound)
+
+		_, err = client.DeleteUser(ctx, &userpb.DeleteUserRequest{Id: 9999})
+		expectCode(t, err, codes.NotFound)
+	})
+}
+
+// seedUsers inserts n users and removes them when the test finishes
+func seedUsers(t *testing.T, n int) map[int64]bool {
+	ctx := context.Background()
+	t.Helper()
+	repo := repository.NewUserRepository(testDB)
+
+	ids := make(map[int64]bool, n)
+	for i := 0; i < n; i++ {
+		user, err := repo.Create(ctx, fmt.Sprintf("paged%02d@example.com", i), fmt.Sprintf("Paged User %02d", i))
+		if err != nil {
+			t.Fatalf("Failed to seed user %d: %v", i, err)
+		}
+		ids[int64(user.ID)] = true
+		t.Cleanup(func() { repo.Delete(ctx, user.ID) })
+	}
+	return ids
+}
+
+// TestListUsers pages through 50 seeded users
+func TestListUsers(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	client := newTestClient(t)
+	seeded := seedUsers(t, 50)
+
+	t.Run("Pagination", func(t *testing.T) {
+		seen := map[int64]bool{}
+		var lastID int64
+		token := ""
+		pages := 0
+		for {
+			resp, err := client.ListUsers(ctx, &userpb.ListUsersRequest{PageSize: 15, PageToken: token})
+			if err != nil {
+				t.Fatalf("ListUsers failed: %v", err)
+			}
+			pages++
+			if len(resp.GetUsers()) > 15 {
+				t.Fatalf("Expected at most 15 users per page, got: %d", len(resp.GetUsers()))
+			}
+			for _, u := range resp.GetUsers() {
+				if u.GetId() <= lastID {
+					t.Fatalf("Expected ascending IDs, got %d after %d", u.GetId(), lastID)
+				}
+				lastID = u.GetId()
+				seen[u.GetId()] = true
+			}
+			if resp.GetNextPageToken() == "" {
+				break
+			}
+			token = resp.GetNextPageToken()
+		}
+
+		for id := range seeded {
+			if !seen[id] {
+				t.Errorf("Expected seeded user %d in paged results", id)
+			}
+		}
+		// 50 seeded + 2 from the seed data
+		if pages < 4 {
+			t.Errorf("Expected at least 4 pages of 15, got: %d", pages)
+		}
+	})
+
+	t.Run("Invalid Page Token", func(t *testing.T) {
+		_, err := client.ListUsers(ctx, &userpb.ListUsersRequest{PageToken: "not-a-token"})
+		expectCode(t, err, codes.InvalidArgument)
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		stream, err := client.StreamUsers(ctx, &userpb.StreamUsersRequest{PageSize: 7})
+		if err != nil {
+			t.Fatalf("StreamUsers failed: %v", err)
+		}
+
+		seen := map[int64]bool{}
+		var lastID int64
+		for {
+			u, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Recv failed: %v", err)
+			}
+			if u.GetId() <= lastID {
+				t.Fatalf("Expected ascending IDs, got %d after %d", u.GetId(), lastID)
+			}
+			lastID = u.GetId()
+			seen[u.GetId()] = true
+		}
+
+		for id := range seeded {
+			if !seen[id] {
+				t.Errorf("Expected seeded user %d in stream", id)
+			}
+		}
+	})
+}
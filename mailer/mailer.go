@@ -0,0 +1,123 @@
+// Package mailer sends the emails users get from the application over SMTP
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"testcontainers-demo/models"
+)
+
+// welcomeSubject is the subject of the email SendWelcome sends
+const welcomeSubject = "Welcome aboard"
+
+// welcomeTemplate is the body of the email SendWelcome sends, executed with the new user
+var welcomeTemplate = template.Must(template.New("welcome").Parse(`Hi {{.Name}},
+
+Welcome aboard! Your account for {{.Email}} is ready.
+
+You can sign in with this address at any time.
+`))
+
+// SMTPMailer sends plain-text email through one SMTP server, without
+// authentication or TLS, like a local relay or Mailhog
+type SMTPMailer struct {
+	host string
+	port int
+	from string
+}
+
+// NewSMTPMailer returns a mailer that sends as from through host:port
+func NewSMTPMailer(host string, port int, from string) *SMTPMailer {
+	return &SMTPMailer{host: host, port: port, from: from}
+}
+
+// SendWelcome sends user the welcome email
+func (m *SMTPMailer) SendWelcome(ctx context.Context, user models.User) error {
+	var body bytes.Buffer
+	if err := welcomeTemplate.Execute(&body, user); err != nil {
+		return fmt.Errorf("failed to render welcome email: %w", err)
+	}
+	return m.Send(ctx, user.Email, welcomeSubject, body.String())
+}
+
+// Send sends a plain-text email to the address to. Cancelling ctx, or its
+// deadline passing, aborts the SMTP conversation.
+func (m *SMTPMailer) Send(ctx context.Context, to, subject, body string) (err error) {
+	rcpt, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient %q: %w", to, err)
+	}
+	msg, err := m.message(rcpt, subject, body)
+	if err != nil {
+		return err
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(m.host, strconv.Itoa(m.port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %w", err)
+	}
+	// net/smtp takes no context: closing the connection unblocks it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer func() {
+		if !stop() && err != nil {
+			err = fmt.Errorf("%w: %v", ctx.Err(), err)
+		}
+	}()
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.Mail(m.from); err != nil {
+		return fmt.Errorf("SMTP server rejected sender %s: %w", m.from, err)
+	}
+	if err := client.Rcpt(rcpt.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient %s: %w", rcpt.Address, err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to start message: %w", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		w.Close()
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+	return client.Quit()
+}
+
+// message formats the headers and body of an email to rcpt
+func (m *SMTPMailer) message(rcpt *mail.Address, subject, body string) ([]byte, error) {
+	if strings.ContainsAny(subject, "\r\n") {
+		return nil, errors.New("subject must be a single line")
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", rcpt.String())
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	msg.WriteString(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
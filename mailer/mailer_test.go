@@ -0,0 +1,209 @@
+package mailer_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/mailer"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable, and only the integration tests need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// mailhogMessage is the part of a message in Mailhog's API the tests read
+type mailhogMessage struct {
+	Raw struct {
+		From string   `json:"From"`
+		To   []string `json:"To"`
+	} `json:"Raw"`
+	Content struct {
+		Headers map[string][]string `json:"Headers"`
+		Body    string              `json:"Body"`
+	} `json:"Content"`
+}
+
+// messagesTo returns the messages Mailhog received for the address to
+func messagesTo(ctx context.Context, t *testing.T, mh *testhelpers.Mailhog, to string) []mailhogMessage {
+	t.Helper()
+
+	query := url.Values{"kind": {"to"}, "query": {to}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mh.APIURL+"/api/v2/search?"+query.Encode(), nil)
+	if err != nil {
+		t.Fatalf("Failed to build request: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to query Mailhog: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 from Mailhog, got: %s", resp.Status)
+	}
+
+	var result struct {
+		Items []mailhogMessage `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		t.Fatalf("Failed to decode Mailhog response: %v", err)
+	}
+	return result.Items
+}
+
+// closedPort returns a local port nothing listens on
+func closedPort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+	return port
+}
+
+// TestCreateAndNotify tests that creating a user sends them exactly one
+// welcome email, and that a failed send keeps the user
+func TestCreateAndNotify(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+
+	t.Run("Sends Welcome", func(t *testing.T) {
+		mh := testhelpers.StartMailhog(ctx, t)
+		repo := repository.NewUserRepository(db, repository.WithMailer(mailer.NewSMTPMailer(mh.SMTPHost, mh.SMTPPort, "noreply@example.com")))
+		t.Cleanup(func() { repo.Close() })
+
+		email := fixtures.GenerateEmail(t)
+		user, err := repo.CreateAndNotify(ctx, email, "Welcome Wendy")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		// SMTP has accepted the message, but Mailhog may not list it yet
+		var messages []mailhogMessage
+		for deadline := time.Now().Add(10 * time.Second); len(messages) == 0 && time.Now().Before(deadline); {
+			time.Sleep(100 * time.Millisecond)
+			messages = messagesTo(ctx, t, mh, user.Email)
+		}
+		if len(messages) != 1 {
+			t.Fatalf("Expected 1 message to %s, got: %d", user.Email, len(messages))
+		}
+
+		msg := messages[0]
+		if len(msg.Raw.To) != 1 || msg.Raw.To[0] != user.Email {
+			t.Errorf("Expected recipient %s, got: %v", user.Email, msg.Raw.To)
+		}
+		if msg.Raw.From != "noreply@example.com" {
+			t.Errorf("Expected sender noreply@example.com, got: %s", msg.Raw.From)
+		}
+		if !strings.Contains(msg.Content.Body, "Hi Welcome Wendy,") {
+			t.Errorf("Expected the body to greet the user by name, got: %q", msg.Content.Body)
+		}
+		if subject := msg.Content.Headers["Subject"]; len(subject) != 1 || subject[0] != "Welcome aboard" {
+			t.Errorf("Expected subject 'Welcome aboard', got: %v", subject)
+		}
+	})
+
+	t.Run("Send Failure Keeps User", func(t *testing.T) {
+		repo := repository.NewUserRepository(db, repository.WithMailer(mailer.NewSMTPMailer("127.0.0.1", closedPort(t), "noreply@example.com")))
+		t.Cleanup(func() { repo.Close() })
+
+		user, err := repo.CreateAndNotify(ctx, fixtures.GenerateEmail(t), "Unwelcome User")
+		if !errors.Is(err, repository.ErrNotificationFailed) {
+			t.Fatalf("Expected ErrNotificationFailed, got: %v", err)
+		}
+		var opErr *net.OpError
+		if !errors.As(err, &opErr) {
+			t.Errorf("Expected the dial error as the cause, got: %v", err)
+		}
+		if user == nil {
+			t.Fatal("Expected the created user alongside the error")
+		}
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Errorf("Expected the insert to stay, got: %v", err)
+		}
+	})
+
+	t.Run("Without Mailer", func(t *testing.T) {
+		repo := repository.NewUserRepository(db)
+		t.Cleanup(func() { repo.Close() })
+
+		email := fixtures.GenerateEmail(t)
+		if _, err := repo.CreateAndNotify(ctx, email, "Nobody"); err == nil {
+			t.Fatal("Expected an error without a mailer")
+		}
+		if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("Expected no user to be created, got: %v", err)
+		}
+	})
+}
+
+// TestSend tests the failures Send reports without reaching a server
+func TestSend(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Invalid Recipient", func(t *testing.T) {
+		m := mailer.NewSMTPMailer("127.0.0.1", closedPort(t), "noreply@example.com")
+		if err := m.Send(ctx, "not an address", "Hello", "Body"); err == nil {
+			t.Error("Expected an invalid recipient to be rejected")
+		}
+	})
+
+	t.Run("Header Injection", func(t *testing.T) {
+		m := mailer.NewSMTPMailer("127.0.0.1", closedPort(t), "noreply@example.com")
+		if err := m.Send(ctx, "a@example.com", "Hello\r\nBcc: b@example.com", "Body"); err == nil {
+			t.Error("Expected a multi-line subject to be rejected")
+		}
+	})
+
+	t.Run("Context Canceled", func(t *testing.T) {
+		// A server that accepts connections but never greets, so only ctx ends the wait
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Failed to listen: %v", err)
+		}
+		t.Cleanup(func() { l.Close() })
+
+		ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		go func() {
+			if conn, err := l.Accept(); err == nil {
+				<-ctx.Done()
+				conn.Close()
+			}
+		}()
+		m := mailer.NewSMTPMailer("127.0.0.1", l.Addr().(*net.TCPAddr).Port, "noreply@example.com")
+		err = m.SendWelcome(ctx, models.User{Email: "slow@example.com", Name: "Slow"})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected context.DeadlineExceeded, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,113 @@
+// Package metrics defines the Prometheus collectors for repository
+// operations. The repository package records into them through its
+// WithMetrics options; this package doesn't depend on it.
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Outcome labels
+const (
+	OutcomeOK       = "ok"
+	OutcomeNotFound = "not_found"
+	OutcomeConflict = "conflict"
+	OutcomeError    = "error"
+)
+
+// Cache result labels
+const (
+	CacheHit    = "hit"
+	CacheMiss   = "miss"
+	CacheBypass = "bypass" // the operation doesn't read the cache
+)
+
+// Metrics counts and times the operations of one repository type
+type Metrics struct {
+	operations *prometheus.CounterVec
+	errors     *prometheus.CounterVec
+	duration   *prometheus.HistogramVec
+	cached     bool
+}
+
+// NewUserRepository registers the UserRepository collectors on reg:
+// user_repository_operations_total, user_repository_errors_total, and
+// user_repository_operation_duration_seconds, labelled by method and outcome
+func NewUserRepository(reg prometheus.Registerer) (*Metrics, error) {
+	return newMetrics(reg, "user_repository", []string{"method", "outcome"}, false)
+}
+
+// NewCachedUserRepository registers the CachedUserRepository collectors on
+// reg, prefixed cached_user_repository_ and labelled by method, outcome, and
+// cache_result
+func NewCachedUserRepository(reg prometheus.Registerer) (*Metrics, error) {
+	return newMetrics(reg, "cached_user_repository", []string{"method", "outcome", "cache_result"}, true)
+}
+
+func newMetrics(reg prometheus.Registerer, subsystem string, labels []string, cached bool) (*Metrics, error) {
+	operations := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "operations_total",
+		Help:      "Repository operations, by method and outcome.",
+	}, labels)
+	errs := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Subsystem: subsystem,
+		Name:      "errors_total",
+		Help:      "Repository operations that did not succeed, by method and outcome.",
+	}, labels)
+	duration := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Subsystem: subsystem,
+		Name:      "operation_duration_seconds",
+		Help:      "Repository operation latency, by method and outcome.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14), // 0.5ms to ~4s
+	}, labels)
+
+	m := &Metrics{cached: cached}
+	var err error
+	if m.operations, err = register(reg, operations); err != nil {
+		return nil, err
+	}
+	if m.errors, err = register(reg, errs); err != nil {
+		return nil, err
+	}
+	if m.duration, err = register(reg, duration); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// register registers c, or returns the collector already registered under
+// the same name so several repositories can share a registry
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) (C, error) {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing, nil
+			}
+		}
+		return c, err
+	}
+	return c, nil
+}
+
+// Observe records one operation. cacheResult is ignored for UserRepository
+// metrics and defaults to CacheBypass for cached ones.
+func (m *Metrics) Observe(method, outcome, cacheResult string, duration time.Duration) {
+	labels := []string{method, outcome}
+	if m.cached {
+		if cacheResult == "" {
+			cacheResult = CacheBypass
+		}
+		labels = append(labels, cacheResult)
+	}
+
+	m.operations.WithLabelValues(labels...).Inc()
+	if outcome != OutcomeOK {
+		m.errors.WithLabelValues(labels...).Inc()
+	}
+	m.duration.WithLabelValues(labels...).Observe(duration.Seconds())
+}
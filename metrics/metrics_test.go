@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestObserve tests the labels recorded for each repository type
+func TestObserve(t *testing.T) {
+	reg := prometheus.NewRegistry()
+
+	m, err := NewUserRepository(reg)
+	if err != nil {
+		t.Fatalf("Failed to register metrics: %v", err)
+	}
+	m.Observe("GetByID", OutcomeOK, CacheHit, time.Millisecond)
+	m.Observe("GetByID", OutcomeNotFound, "", time.Millisecond)
+
+	cached, err := NewCachedUserRepository(reg)
+	if err != nil {
+		t.Fatalf("Failed to register cached metrics: %v", err)
+	}
+	cached.Observe("GetByIDCached", OutcomeOK, CacheHit, time.Millisecond)
+	cached.Observe("CreateCached", OutcomeConflict, "", time.Millisecond)
+
+	expected := `
+# HELP user_repository_errors_total Repository operations that did not succeed, by method and outcome.
+# TYPE user_repository_errors_total counter
+user_repository_errors_total{method="GetByID",outcome="not_found"} 1
+# HELP cached_user_repository_operations_total Repository operations, by method and outcome.
+# TYPE cached_user_repository_operations_total counter
+cached_user_repository_operations_total{cache_result="bypass",method="CreateCached",outcome="conflict"} 1
+cached_user_repository_operations_total{cache_result="hit",method="GetByIDCached",outcome="ok"} 1
+`
+	if err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"user_repository_errors_total", "cached_user_repository_operations_total"); err != nil {
+		t.Errorf("Unexpected metrics:\n%v", err)
+	}
+
+	t.Run("Registering Twice Reuses Collectors", func(t *testing.T) {
+		again, err := NewUserRepository(reg)
+		if err != nil {
+			t.Fatalf("Expected registering twice to succeed, got: %v", err)
+		}
+		if again.operations != m.operations {
+			t.Error("Expected the already registered collector to be reused")
+		}
+	})
+}
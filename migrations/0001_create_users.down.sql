@@ -0,0 +1,2 @@
+-- migrations/0001_create_users.down.sql
+DROP TABLE IF EXISTS users;
@@ -1,12 +1,8 @@
--- migrations/init.sql
+-- migrations/0001_create_users.up.sql
+-- IF NOT EXISTS so databases created by the old init.sql adopt this version cleanly
 CREATE TABLE IF NOT EXISTS users (
     id SERIAL PRIMARY KEY,
     email VARCHAR(255) UNIQUE NOT NULL,
     name VARCHAR(255) NOT NULL,
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
 );
-
--- Insert some test data
-INSERT INTO users (email, name) VALUES
-    ('alice@example.com', 'Alice Smith'),
-    ('bob@example.com', 'Bob Johnson');
\ No newline at end of file
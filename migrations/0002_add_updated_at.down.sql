@@ -0,0 +1,4 @@
+-- migrations/0002_add_updated_at.down.sql
+DROP TRIGGER IF EXISTS users_set_updated_at ON users;
+DROP FUNCTION IF EXISTS set_updated_at();
+ALTER TABLE users DROP COLUMN IF EXISTS updated_at;
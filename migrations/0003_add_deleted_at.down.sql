@@ -0,0 +1,2 @@
+-- migrations/0003_add_deleted_at.down.sql
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,3 @@
+-- migrations/0003_add_deleted_at.up.sql
+-- NULL while the user is active; set instead of deleting the row for soft deletes
+ALTER TABLE users ADD COLUMN deleted_at TIMESTAMP;
@@ -0,0 +1,2 @@
+-- migrations/0004_unique_lower_email.down.sql
+DROP INDEX IF EXISTS users_email_lower_key;
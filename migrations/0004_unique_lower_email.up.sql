@@ -0,0 +1,4 @@
+-- migrations/0004_unique_lower_email.up.sql
+-- Emails are stored and looked up lowercased; this makes the constraint agree,
+-- so rows written before normalization can't collide by case either
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (lower(email));
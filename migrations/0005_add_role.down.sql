@@ -0,0 +1,4 @@
+-- migrations/0005_add_role.down.sql
+ALTER TABLE users DROP COLUMN IF EXISTS role;
+
+DROP TYPE IF EXISTS user_role;
@@ -0,0 +1,4 @@
+-- migrations/0005_add_role.up.sql
+CREATE TYPE user_role AS ENUM ('admin', 'member', 'guest');
+
+ALTER TABLE users ADD COLUMN role user_role NOT NULL DEFAULT 'member';
@@ -0,0 +1,2 @@
+-- migrations/0006_add_uuid.down.sql
+ALTER TABLE users DROP COLUMN IF EXISTS uuid;
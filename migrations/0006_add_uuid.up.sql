@@ -0,0 +1,6 @@
+-- migrations/0006_add_uuid.up.sql
+-- Public identifier alongside the integer id during the move to UUID keys;
+-- existing rows get one from the default
+ALTER TABLE users ADD COLUMN uuid UUID NOT NULL DEFAULT gen_random_uuid();
+
+ALTER TABLE users ADD CONSTRAINT users_uuid_key UNIQUE (uuid);
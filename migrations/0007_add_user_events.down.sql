@@ -0,0 +1,2 @@
+-- migrations/0007_add_user_events.down.sql
+DROP TABLE IF EXISTS user_events;
@@ -0,0 +1,15 @@
+-- migrations/0007_add_user_events.up.sql
+-- Transactional outbox: every user write inserts its event in the same
+-- statement, and outbox.Publisher sends pending rows to Kafka. user_id has no
+-- foreign key so user.deleted events outlive the row they describe.
+CREATE TABLE user_events (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    event_type TEXT NOT NULL CHECK (event_type IN ('user.created', 'user.updated', 'user.deleted')),
+    payload JSONB NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    dispatched_at TIMESTAMP
+);
+
+-- The publisher only ever scans undispatched rows, oldest first
+CREATE INDEX user_events_pending_idx ON user_events (id) WHERE dispatched_at IS NULL;
@@ -0,0 +1,2 @@
+-- migrations/0008_add_password_hash.down.sql
+ALTER TABLE users DROP COLUMN IF EXISTS password_hash;
@@ -0,0 +1,3 @@
+-- migrations/0008_add_password_hash.up.sql
+-- bcrypt hash of the user's password; NULL for users who can't log in with one
+ALTER TABLE users ADD COLUMN password_hash TEXT;
@@ -0,0 +1,2 @@
+-- migrations/0009_add_audit_log.down.sql
+DROP TABLE IF EXISTS audit_log;
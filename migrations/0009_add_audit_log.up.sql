@@ -0,0 +1,16 @@
+-- migrations/0009_add_audit_log.up.sql
+-- Who changed which user, and how: the row before and after every write made
+-- through audit.Repository, in the write's transaction. user_id has no
+-- foreign key so the trail outlives deleted users.
+CREATE TABLE audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+    old_row JSONB,
+    new_row JSONB,
+    actor TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- GetAuditTrail reads one user's entries, newest first
+CREATE INDEX audit_log_user_idx ON audit_log (user_id, id);
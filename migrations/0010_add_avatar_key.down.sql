@@ -0,0 +1,2 @@
+-- migrations/0010_add_avatar_key.down.sql
+ALTER TABLE users DROP COLUMN IF EXISTS avatar_key;
@@ -0,0 +1,3 @@
+-- migrations/0010_add_avatar_key.up.sql
+-- Object key of the user's avatar in the avatar bucket; NULL when they have none
+ALTER TABLE users ADD COLUMN avatar_key TEXT;
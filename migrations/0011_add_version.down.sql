@@ -0,0 +1,4 @@
+-- migrations/0011_add_version.down.sql
+DROP TRIGGER IF EXISTS users_bump_version ON users;
+DROP FUNCTION IF EXISTS bump_version();
+ALTER TABLE users DROP COLUMN IF EXISTS version;
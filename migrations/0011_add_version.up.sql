@@ -0,0 +1,16 @@
+-- migrations/0011_add_version.up.sql
+-- Row version for optimistic concurrency: UpdateWithVersion only writes a
+-- row still at the version the caller read. Every UPDATE bumps it, so plain
+-- updates invalidate an earlier read too.
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+
+CREATE OR REPLACE FUNCTION bump_version() RETURNS trigger AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_bump_version
+    BEFORE UPDATE ON users
+    FOR EACH ROW EXECUTE FUNCTION bump_version();
@@ -0,0 +1,2 @@
+-- migrations/0012_add_archived_users.down.sql
+DROP TABLE IF EXISTS archived_users;
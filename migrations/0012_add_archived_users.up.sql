@@ -0,0 +1,17 @@
+-- migrations/0012_add_archived_users.up.sql
+-- Users moved out of users by ArchiveInactiveUsers, with every column they
+-- had. email isn't unique: an archived user's email can be taken again.
+CREATE TABLE archived_users (
+    id INTEGER PRIMARY KEY,
+    uuid UUID NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    role user_role NOT NULL,
+    password_hash TEXT,
+    avatar_key TEXT,
+    version INTEGER NOT NULL,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    deleted_at TIMESTAMP,
+    archived_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,3 @@
+-- migrations/0013_add_erased_at.down.sql
+ALTER TABLE archived_users DROP COLUMN IF EXISTS erased_at;
+ALTER TABLE users DROP COLUMN IF EXISTS erased_at;
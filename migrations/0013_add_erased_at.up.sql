@@ -0,0 +1,5 @@
+-- migrations/0013_add_erased_at.up.sql
+-- When EraseUser anonymized the user; NULL for everyone else. The row stays
+-- so references to the ID remain valid.
+ALTER TABLE users ADD COLUMN erased_at TIMESTAMP;
+ALTER TABLE archived_users ADD COLUMN erased_at TIMESTAMP;
@@ -0,0 +1,2 @@
+-- migrations/0014_add_orders.down.sql
+DROP TABLE IF EXISTS orders;
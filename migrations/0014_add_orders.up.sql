@@ -0,0 +1,13 @@
+-- migrations/0014_add_orders.up.sql
+-- Orders placed by users, in cents. The foreign key blocks deleting a user
+-- who has orders (repository.ErrHasOrders) rather than cascading, so no
+-- delete silently takes a user's order history with it.
+CREATE TABLE orders (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE RESTRICT,
+    amount BIGINT NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- ListByUser and the per-user aggregates look orders up by user
+CREATE INDEX orders_user_idx ON orders (user_id, id);
@@ -0,0 +1,2 @@
+-- migrations/0015_add_webhook_deliveries.down.sql
+DROP TABLE IF EXISTS webhook_deliveries;
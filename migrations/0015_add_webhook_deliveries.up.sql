@@ -0,0 +1,18 @@
+-- migrations/0015_add_webhook_deliveries.up.sql
+-- One row per event per webhook endpoint, updated after every attempt. There
+-- is no foreign key to users: the row of a user.deleted event outlives the user.
+CREATE TABLE webhook_deliveries (
+    id SERIAL PRIMARY KEY,
+    event_id UUID NOT NULL,
+    event_type TEXT NOT NULL,
+    user_id INTEGER NOT NULL,
+    endpoint_url TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX webhook_deliveries_event_idx ON webhook_deliveries (event_id);
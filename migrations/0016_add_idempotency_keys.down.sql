@@ -0,0 +1,2 @@
+-- migrations/0016_add_idempotency_keys.down.sql
+DROP TABLE IF EXISTS idempotency_keys;
@@ -0,0 +1,12 @@
+-- migrations/0016_add_idempotency_keys.up.sql
+-- Idempotency keys of CreateIdempotent: the hash of the request a key was
+-- first used with and the user it created. Deleting the user forgets the key.
+CREATE TABLE idempotency_keys (
+    key TEXT PRIMARY KEY,
+    request_hash TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- PurgeIdempotencyKeys deletes by age
+CREATE INDEX idempotency_keys_created_idx ON idempotency_keys (created_at);
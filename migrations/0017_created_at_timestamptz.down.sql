@@ -0,0 +1,3 @@
+-- migrations/0017_created_at_timestamptz.down.sql
+ALTER TABLE archived_users ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE users ALTER COLUMN created_at TYPE TIMESTAMP USING created_at AT TIME ZONE 'UTC';
@@ -0,0 +1,7 @@
+-- migrations/0017_created_at_timestamptz.up.sql
+-- created_at as an instant rather than a wall-clock time, so it means the
+-- same thing whatever the session's TimeZone is. Every value written so far
+-- was UTC. archived_users keeps the type users has, so archiving copies the
+-- instant unchanged.
+ALTER TABLE users ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
+ALTER TABLE archived_users ALTER COLUMN created_at TYPE TIMESTAMPTZ USING created_at AT TIME ZONE 'UTC';
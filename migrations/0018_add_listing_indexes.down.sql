@@ -0,0 +1,3 @@
+-- migrations/0018_add_listing_indexes.down.sql
+DROP INDEX IF EXISTS users_name_idx;
+DROP INDEX IF EXISTS users_created_at_idx;
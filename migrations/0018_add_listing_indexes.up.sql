@@ -0,0 +1,7 @@
+-- migrations/0018_add_listing_indexes.up.sql
+-- Indexes for the sorted listings. Each ends in id, the tiebreaker
+-- ListOrdered, ListFiltered, and GetRecentUsers' keyset sort by, so a page
+-- is read off the index in order instead of sorting the whole table.
+-- Lookups by email already use users_email_lower_key from 0004.
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (created_at, id);
+CREATE INDEX IF NOT EXISTS users_name_idx ON users (name, id);
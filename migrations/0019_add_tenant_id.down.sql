@@ -0,0 +1,19 @@
+-- migrations/0019_add_tenant_id.down.sql
+-- Fails while two tenants share an email, exactly or ignoring case, or an
+-- idempotency key
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (key);
+ALTER TABLE idempotency_keys DROP COLUMN tenant_id;
+
+ALTER TABLE archived_users DROP COLUMN tenant_id;
+
+DROP INDEX IF EXISTS users_name_idx;
+DROP INDEX IF EXISTS users_created_at_idx;
+CREATE INDEX users_created_at_idx ON users (created_at, id);
+CREATE INDEX users_name_idx ON users (name, id);
+
+DROP INDEX IF EXISTS users_tenant_email_lower_key;
+CREATE UNIQUE INDEX users_email_lower_key ON users (lower(email));
+ALTER TABLE users ADD CONSTRAINT users_email_key UNIQUE (email);
+
+ALTER TABLE users DROP COLUMN tenant_id;
@@ -0,0 +1,25 @@
+-- migrations/0019_add_tenant_id.up.sql
+-- Every user belongs to a tenant. Rows from before tenancy, and inserts that
+-- don't name one, belong to 'default'. Emails are unique per tenant, and the
+-- listing indexes lead with tenant_id, since every repository query is
+-- scoped to one. IDs stay global: two tenants never share one.
+ALTER TABLE users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default'
+    CHECK (tenant_id ~ '^[a-z][a-z0-9-]{0,62}$');
+
+-- Both global email constraints go: 0001's column UNIQUE and 0004's index
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+DROP INDEX IF EXISTS users_email_lower_key;
+CREATE UNIQUE INDEX users_tenant_email_lower_key ON users (tenant_id, lower(email));
+
+DROP INDEX IF EXISTS users_created_at_idx;
+DROP INDEX IF EXISTS users_name_idx;
+CREATE INDEX users_created_at_idx ON users (tenant_id, created_at, id);
+CREATE INDEX users_name_idx ON users (tenant_id, name, id);
+
+-- An archived user keeps their tenant, so ArchiveInactiveUsers stays within one
+ALTER TABLE archived_users ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+
+-- Idempotency keys are the client's, and two tenants' clients may pick the same one
+ALTER TABLE idempotency_keys ADD COLUMN tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, key);
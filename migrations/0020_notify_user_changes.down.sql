@@ -0,0 +1,3 @@
+-- migrations/0020_notify_user_changes.down.sql
+DROP TRIGGER IF EXISTS users_notify_change ON users;
+DROP FUNCTION IF EXISTS notify_user_change();
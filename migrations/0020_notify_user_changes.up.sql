@@ -0,0 +1,34 @@
+-- migrations/0020_notify_user_changes.up.sql
+-- Every write to a user NOTIFYs the user_changes channel, for
+-- changefeed.Listener. The payload names the change and the user's ID,
+-- tenant, and version, not the row: NOTIFY caps it at 8000 bytes, and a
+-- listener reads what it needs. Notifications are sent on commit, in commit
+-- order, and never for a rolled-back write.
+CREATE OR REPLACE FUNCTION notify_user_change() RETURNS trigger AS $$
+DECLARE
+    changed users;
+    event_type TEXT;
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        changed := OLD;
+        event_type := 'user.deleted';
+    ELSIF TG_OP = 'INSERT' THEN
+        changed := NEW;
+        event_type := 'user.created';
+    ELSE
+        changed := NEW;
+        event_type := 'user.updated';
+    END IF;
+    PERFORM pg_notify('user_changes', json_build_object(
+        'type', event_type,
+        'user_id', changed.id,
+        'tenant_id', changed.tenant_id,
+        'version', changed.version
+    )::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER users_notify_change
+    AFTER INSERT OR UPDATE OR DELETE ON users
+    FOR EACH ROW EXECUTE FUNCTION notify_user_change();
@@ -0,0 +1,379 @@
+// Package migrations applies the versioned schema in this directory.
+//
+// Each migration is a pair of files, <version>_<name>.up.sql and
+// <version>_<name>.down.sql. Applied versions are recorded in the
+// schema_migrations table, so running the migrations again only applies the
+// new ones.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// seedFile is the test data loaded by Seed; it is not a migration
+const seedFile = "seed.sql"
+
+// lockID is the advisory lock that keeps concurrent runners (e.g. test
+// packages sharing one database) from applying the same migration twice
+const lockID = 5432_0001
+
+// migrationFile matches "<version>_<name>.up.sql" and "<version>_<name>.down.sql"
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ErrEmptyScript is returned for an embedded SQL file with nothing but
+// whitespace in it, or no migrations embedded at all, as when the files were
+// truncated or the embed pattern stopped matching; running it would
+// otherwise "succeed" and leave a schema the tests fail on much later
+var ErrEmptyScript = errors.New("empty SQL script")
+
+// ErrDirty is returned when an earlier run failed partway through a
+// migration. The failed version stays marked dirty until Force is called.
+var ErrDirty = errors.New("schema_migrations is dirty")
+
+// Migration is one versioned schema change and its inverse
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// RunMigrations applies the embedded migrations that db hasn't seen yet.
+// They are compiled into the binary, so the result doesn't depend on the
+// working directory.
+func RunMigrations(ctx context.Context, db *sql.DB) error {
+	migrations, err := Load(files)
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return fmt.Errorf("%w: no migrations embedded", ErrEmptyScript)
+	}
+	return Run(ctx, db, files)
+}
+
+// MigrateTo applies or rolls back the embedded migrations until version is
+// the latest one applied; version 0 rolls everything back
+func MigrateTo(ctx context.Context, db *sql.DB, version uint) error {
+	migrations, err := Load(files)
+	if err != nil {
+		return err
+	}
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		return migrateTo(ctx, conn, migrations, int(version))
+	})
+}
+
+// Rollback reverts the last steps applied migrations, newest first
+func Rollback(ctx context.Context, db *sql.DB, steps int) error {
+	if steps < 1 {
+		return fmt.Errorf("rollback steps must be positive, got %d", steps)
+	}
+
+	migrations, err := Load(files)
+	if err != nil {
+		return err
+	}
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if steps > len(applied) {
+			return fmt.Errorf("cannot roll back %d steps, only %d applied", steps, len(applied))
+		}
+
+		versions := sortedVersions(applied)
+		target := 0
+		if steps < len(versions) {
+			target = versions[len(versions)-steps-1]
+		}
+		return migrateTo(ctx, conn, migrations, target)
+	})
+}
+
+// Run applies the migrations found in fsys, in version order, skipping the
+// ones already recorded in schema_migrations. Each migration runs in its own
+// transaction; the first failure stops the run and names its version.
+func Run(ctx context.Context, db *sql.DB, fsys fs.FS) error {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return err
+	}
+	if len(migrations) == 0 {
+		return nil
+	}
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		return migrateTo(ctx, conn, migrations, migrations[len(migrations)-1].Version)
+	})
+}
+
+// Force clears the dirty flag after a failed run has been repaired by hand,
+// recording version as the latest applied migration. Versions above it are
+// forgotten without running their down files.
+func Force(ctx context.Context, db *sql.DB, version uint) error {
+	return withLock(ctx, db, func(conn *sql.Conn) error {
+		if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version > $1", version); err != nil {
+			return fmt.Errorf("failed to force version %d: %w", version, err)
+		}
+		if _, err := conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = false WHERE dirty"); err != nil {
+			return fmt.Errorf("failed to force version %d: %w", version, err)
+		}
+		return nil
+	})
+}
+
+// Load reads and sorts the migrations in fsys; other files are ignored
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version in %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("duplicate migration version %d: %s and %s", version, m.Name, match[2])
+		}
+
+		body, err := readScript(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = body
+		} else {
+			m.Down = body
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has a down file but no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Seed loads the embedded test data; it is safe to run more than once
+func Seed(ctx context.Context, db *sql.DB) error {
+	body, err := readScript(files, seedFile)
+	if err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, body); err != nil {
+		return fmt.Errorf("failed to load seed data: %w", err)
+	}
+	return nil
+}
+
+// readScript reads the SQL file name from fsys, returning ErrEmptyScript
+// if it holds only whitespace
+func readScript(fsys fs.FS, name string) (string, error) {
+	body, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if strings.TrimSpace(string(body)) == "" {
+		return "", fmt.Errorf("%w: %s", ErrEmptyScript, name)
+	}
+	return string(body), nil
+}
+
+// withLock runs fn on one connection holding the migrations advisory lock,
+// after making sure schema_migrations exists
+func withLock(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn) error) error {
+	// Advisory locks belong to a session, so hold one connection throughout
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("failed to lock migrations: %w", err)
+	}
+	defer conn.ExecContext(context.WithoutCancel(ctx), "SELECT pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT PRIMARY KEY,
+			name TEXT NOT NULL,
+			dirty BOOLEAN NOT NULL DEFAULT false,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// migrateTo applies the missing ups at or below target, then the downs of
+// applied versions above it, newest first
+func migrateTo(ctx context.Context, conn *sql.Conn, migrations []Migration, target int) error {
+	if target != 0 && !knownVersion(migrations, target) {
+		return fmt.Errorf("unknown migration version %d", target)
+	}
+
+	var dirty int
+	err := conn.QueryRowContext(ctx, "SELECT version FROM schema_migrations WHERE dirty").Scan(&dirty)
+	if err == nil {
+		return fmt.Errorf("%w: migration %d failed partway; repair it and call Force", ErrDirty, dirty)
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("failed to check dirty flag: %w", err)
+	}
+
+	applied, err := appliedVersions(ctx, conn)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if m.Version > target || applied[m.Version] {
+			continue
+		}
+		if err := up(ctx, conn, m); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version <= target || !applied[m.Version] {
+			continue
+		}
+		if err := down(ctx, conn, m); err != nil {
+			return fmt.Errorf("rollback of migration %d (%s) failed: %w", m.Version, m.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// up marks the migration dirty, then applies it and clears the flag in one
+// transaction, so a failure leaves the version recorded as dirty
+func up(ctx context.Context, conn *sql.Conn, m Migration) error {
+	if _, err := conn.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, dirty) VALUES ($1, $2, true)",
+		m.Version, m.Name,
+	); err != nil {
+		return err
+	}
+
+	return inTx(ctx, conn, m.Up,
+		"UPDATE schema_migrations SET dirty = false, applied_at = CURRENT_TIMESTAMP WHERE version = $1",
+		m.Version,
+	)
+}
+
+// down marks the migration dirty, then reverts it and removes its row in one
+// transaction
+func down(ctx context.Context, conn *sql.Conn, m Migration) error {
+	if m.Down == "" {
+		return fmt.Errorf("no down migration")
+	}
+	if _, err := conn.ExecContext(ctx, "UPDATE schema_migrations SET dirty = true WHERE version = $1", m.Version); err != nil {
+		return err
+	}
+
+	return inTx(ctx, conn, m.Down, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+}
+
+// inTx runs a migration body and its bookkeeping statement in one transaction
+func inTx(ctx context.Context, conn *sql.Conn, body, bookkeeping string, version int) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, body); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, bookkeeping, version); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// appliedVersions returns the versions recorded in schema_migrations
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]bool, error) {
+	rows, err := conn.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		applied[version] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating versions: %w", err)
+	}
+
+	return applied, nil
+}
+
+// sortedVersions returns the keys of applied in ascending order
+func sortedVersions(applied map[int]bool) []int {
+	versions := make([]int, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// knownVersion reports whether version is one of migrations
+func knownVersion(migrations []Migration, version int) bool {
+	for _, m := range migrations {
+		if m.Version == version {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,541 @@
+package migrations_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"testcontainers-demo/migrations"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+)
+
+// testContainer provides the empty databases the migrations run against.
+// It is nil when Docker is unavailable, and only the integration tests
+// need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// appliedVersions lists the versions recorded in schema_migrations, in order
+func appliedVersions(t *testing.T, db *sql.DB) []int {
+	t.Helper()
+
+	rows, err := db.Query("SELECT version FROM schema_migrations ORDER BY version")
+	if err != nil {
+		t.Fatalf("Failed to read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("Failed to scan version: %v", err)
+		}
+		versions = append(versions, v)
+	}
+	return versions
+}
+
+// TestRunMigrations tests applying the embedded migrations to an empty database
+func TestRunMigrations(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+
+	embedded, err := migrations.Load(os.DirFS("."))
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	t.Run("From Scratch", func(t *testing.T) {
+		if err := migrations.RunMigrations(ctx, db); err != nil {
+			t.Fatalf("Failed to run migrations: %v", err)
+		}
+
+		if got := appliedVersions(t, db); len(got) != len(embedded) {
+			t.Fatalf("Expected %d applied versions, got: %v", len(embedded), got)
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+			t.Fatalf("Expected users table to exist: %v", err)
+		}
+		if count != 0 {
+			t.Errorf("Expected migrations not to load seed data, got %d users", count)
+		}
+	})
+
+	t.Run("Re-applying Is A No-op", func(t *testing.T) {
+		var before string
+		if err := db.QueryRow("SELECT MAX(applied_at)::text FROM schema_migrations").Scan(&before); err != nil {
+			t.Fatalf("Failed to read applied_at: %v", err)
+		}
+
+		if err := migrations.RunMigrations(ctx, db); err != nil {
+			t.Fatalf("Failed to re-run migrations: %v", err)
+		}
+
+		if got := appliedVersions(t, db); len(got) != len(embedded) {
+			t.Errorf("Expected %d applied versions, got: %v", len(embedded), got)
+		}
+
+		var after string
+		if err := db.QueryRow("SELECT MAX(applied_at)::text FROM schema_migrations").Scan(&after); err != nil {
+			t.Fatalf("Failed to read applied_at: %v", err)
+		}
+		if before != after {
+			t.Errorf("Expected no migration to be re-applied, applied_at moved from %s to %s", before, after)
+		}
+	})
+}
+
+// TestRunFromOtherDirectories tests that the migrations and seed data are
+// the embedded ones, whatever the working directory: from the repository
+// root or an unrelated directory, where no .sql file is within reach, an
+// empty database still gets the full schema and the seed users
+func TestRunFromOtherDirectories(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	embedded, err := migrations.Load(os.DirFS("."))
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	for name, dir := range map[string]string{
+		"Repository Root": "..",
+		"Temp Dir":        t.TempDir(),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db := testContainer.CreateEmptyDatabase(ctx, t)
+			t.Chdir(dir)
+
+			if err := migrations.RunMigrations(ctx, db); err != nil {
+				t.Fatalf("Failed to run migrations: %v", err)
+			}
+			if got := appliedVersions(t, db); len(got) != len(embedded) {
+				t.Errorf("Expected %d applied versions, got: %v", len(embedded), got)
+			}
+			if err := migrations.Seed(ctx, db); err != nil {
+				t.Fatalf("Failed to seed: %v", err)
+			}
+			var count int
+			if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&count); err != nil || count == 0 {
+				t.Errorf("Expected the seed users, got %d: %v", count, err)
+			}
+		})
+	}
+}
+
+// TestRunBrokenMigration tests that a failing migration names its version,
+// leaves the earlier ones applied, and blocks further runs until forced
+func TestRunBrokenMigration(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+
+	fsys := fstest.MapFS{
+		"0001_create_widgets.up.sql": {Data: []byte("CREATE TABLE widgets (id SERIAL PRIMARY KEY);")},
+		"0002_broken.up.sql":         {Data: []byte("ALTER TABLE no_such_table ADD COLUMN name TEXT;")},
+		"0003_never_reached.up.sql":  {Data: []byte("CREATE TABLE gadgets (id SERIAL PRIMARY KEY);")},
+	}
+
+	err := migrations.Run(ctx, db, fsys)
+	if err == nil {
+		t.Fatal("Expected error from broken migration")
+	}
+	if !strings.Contains(err.Error(), "migration 2 (broken)") {
+		t.Errorf("Expected error to name migration 2, got: %v", err)
+	}
+
+	t.Run("Failed Version Is Dirty", func(t *testing.T) {
+		var dirty []int
+		rows, err := db.Query("SELECT version FROM schema_migrations WHERE dirty")
+		if err != nil {
+			t.Fatalf("Failed to read schema_migrations: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var v int
+			rows.Scan(&v)
+			dirty = append(dirty, v)
+		}
+		if len(dirty) != 1 || dirty[0] != 2 {
+			t.Errorf("Expected only version 2 dirty, got: %v", dirty)
+		}
+	})
+
+	t.Run("Dirty Blocks Further Runs", func(t *testing.T) {
+		err := migrations.Run(ctx, db, fsys)
+		if !errors.Is(err, migrations.ErrDirty) {
+			t.Fatalf("Expected ErrDirty, got: %v", err)
+		}
+		if !strings.Contains(err.Error(), "migration 2") {
+			t.Errorf("Expected error to name migration 2, got: %v", err)
+		}
+	})
+
+	t.Run("Force Then Fixed Migration", func(t *testing.T) {
+		if err := migrations.Force(ctx, db, 1); err != nil {
+			t.Fatalf("Failed to force version 1: %v", err)
+		}
+
+		fsys["0002_broken.up.sql"] = &fstest.MapFile{Data: []byte("ALTER TABLE widgets ADD COLUMN name TEXT;")}
+		if err := migrations.Run(ctx, db, fsys); err != nil {
+			t.Fatalf("Failed to run fixed migrations: %v", err)
+		}
+
+		if got := appliedVersions(t, db); len(got) != 3 {
+			t.Errorf("Expected versions 1-3 applied, got: %v", got)
+		}
+	})
+}
+
+// columnExists reports whether users has the given column
+func columnExists(t *testing.T, db *sql.DB, column string) bool {
+	t.Helper()
+
+	var exists bool
+	err := db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_name = 'users' AND column_name = $1
+		)
+	`, column).Scan(&exists)
+	if err != nil {
+		t.Fatalf("Failed to query information_schema: %v", err)
+	}
+	return exists
+}
+
+// TestMigrateToAndRollback migrates up, rolls back to version 1, migrates up
+// again, and checks the repository still works on the result
+func TestMigrateToAndRollback(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+
+	if err := migrations.RunMigrations(ctx, db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	for _, column := range []string{"updated_at", "deleted_at"} {
+		if !columnExists(t, db, column) {
+			t.Fatalf("Expected column %s after migrating up", column)
+		}
+	}
+
+	embedded, err := migrations.Load(os.DirFS("."))
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+
+	t.Run("Rollback To Version 1", func(t *testing.T) {
+		if err := migrations.Rollback(ctx, db, len(embedded)-1); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+
+		for _, column := range []string{"updated_at", "deleted_at", "role", "uuid", "password_hash", "tenant_id"} {
+			if columnExists(t, db, column) {
+				t.Errorf("Expected column %s to be dropped", column)
+			}
+		}
+		if !columnExists(t, db, "email") {
+			t.Error("Expected users table to survive the rollback")
+		}
+		for _, table := range []string{"user_events", "audit_log"} {
+			var regclass sql.NullString
+			if err := db.QueryRow("SELECT to_regclass($1)::text", table).Scan(&regclass); err != nil || regclass.Valid {
+				t.Errorf("Expected %s to be dropped, got: %v %v", table, regclass.String, err)
+			}
+		}
+		if got := appliedVersions(t, db); len(got) != 1 || got[0] != 1 {
+			t.Errorf("Expected only version 1 applied, got: %v", got)
+		}
+	})
+
+	t.Run("Rollback Too Far", func(t *testing.T) {
+		if err := migrations.Rollback(ctx, db, 5); err == nil {
+			t.Fatal("Expected error rolling back more steps than applied")
+		}
+	})
+
+	t.Run("MigrateTo", func(t *testing.T) {
+		if err := migrations.MigrateTo(ctx, db, 2); err != nil {
+			t.Fatalf("Failed to migrate to version 2: %v", err)
+		}
+		if !columnExists(t, db, "updated_at") || columnExists(t, db, "deleted_at") {
+			t.Error("Expected updated_at but not deleted_at at version 2")
+		}
+
+		if err := migrations.MigrateTo(ctx, db, 99); err == nil {
+			t.Error("Expected error for unknown version")
+		}
+	})
+
+	t.Run("Repository CRUD After Migrating Up Again", func(t *testing.T) {
+		if err := migrations.RunMigrations(ctx, db); err != nil {
+			t.Fatalf("Failed to re-run migrations: %v", err)
+		}
+		repo := repository.NewUserRepository(db)
+
+		user, err := repo.Create(ctx, "migrated@example.com", "Migrated User")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		if err := repo.Update(ctx, user.ID, "migrated@example.com", "Migrated Again"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.Name != "Migrated Again" {
+			t.Errorf("Expected name 'Migrated Again', got: %s", got.Name)
+		}
+
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, repository.ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound after delete, got: %v", err)
+		}
+	})
+
+	t.Run("Rollback Everything", func(t *testing.T) {
+		if err := migrations.MigrateTo(ctx, db, 0); err != nil {
+			t.Fatalf("Failed to roll back everything: %v", err)
+		}
+		if columnExists(t, db, "email") {
+			t.Error("Expected users table to be dropped")
+		}
+	})
+}
+
+// userIndexes returns the definition of every index on users, by name
+func userIndexes(t *testing.T, db *sql.DB) map[string]string {
+	t.Helper()
+
+	rows, err := db.Query("SELECT indexname, indexdef FROM pg_indexes WHERE tablename = 'users'")
+	if err != nil {
+		t.Fatalf("Failed to read pg_indexes: %v", err)
+	}
+	defer rows.Close()
+
+	indexes := make(map[string]string)
+	for rows.Next() {
+		var name, def string
+		if err := rows.Scan(&name, &def); err != nil {
+			t.Fatalf("Failed to scan index: %v", err)
+		}
+		indexes[name] = def
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Failed to read pg_indexes: %v", err)
+	}
+	return indexes
+}
+
+// listingIndexes are the indexes the lookups and sorted listings rely on,
+// with the part of their definition that makes them useful
+var listingIndexes = map[string]string{
+	"users_tenant_email_lower_key": "UNIQUE INDEX users_tenant_email_lower_key ON public.users USING btree (tenant_id, lower(email))",
+	"users_created_at_idx":         "USING btree (tenant_id, created_at, id)",
+	"users_name_idx":               "USING btree (tenant_id, name, id)",
+}
+
+// TestListingIndexes tests that the migrations leave the indexes behind
+// the email lookup and the sorted listings, so a migration that drops or
+// changes one fails here rather than as a slow query in production
+func TestListingIndexes(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+	if err := migrations.RunMigrations(ctx, db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	indexes := userIndexes(t, db)
+	for name, want := range listingIndexes {
+		def, ok := indexes[name]
+		if !ok {
+			t.Errorf("Expected index %s, got: %v", name, indexes)
+			continue
+		}
+		if !strings.Contains(def, want) {
+			t.Errorf("Expected index %s to contain %q, got: %s", name, want, def)
+		}
+	}
+
+	// 0019 drops the global email constraint, so tenants can share an email
+	if _, ok := indexes["users_email_key"]; ok {
+		t.Errorf("Expected users_email_key to be dropped, got: %v", indexes)
+	}
+
+	t.Run("Dropped By Rolling Back", func(t *testing.T) {
+		// 0019 puts back the indexes without tenant_id, then 0018 drops them
+		if err := migrations.MigrateTo(ctx, db, 18); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		indexes := userIndexes(t, db)
+		for name, want := range map[string]string{
+			"users_email_key":       "UNIQUE INDEX users_email_key ON public.users USING btree (email)",
+			"users_email_lower_key": "USING btree (lower(email))",
+			"users_created_at_idx":  "USING btree (created_at, id)",
+		} {
+			if !strings.Contains(indexes[name], want) {
+				t.Errorf("Expected index %s to contain %q, got: %v", name, want, indexes)
+			}
+		}
+
+		if err := migrations.MigrateTo(ctx, db, 17); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		indexes = userIndexes(t, db)
+		for _, name := range []string{"users_created_at_idx", "users_name_idx"} {
+			if _, ok := indexes[name]; ok {
+				t.Errorf("Expected index %s to be dropped", name)
+			}
+		}
+	})
+}
+
+// TestIndexUsage checks, with EXPLAIN, that the planner uses the indexes
+// once users has a few thousand rows. Plans depend on the server's
+// statistics and cost settings, so this is a best-effort check: it is
+// skipped in -short mode.
+func TestIndexUsage(t *testing.T) {
+	requirePostgres(t)
+	if testing.Short() {
+		t.Skip("Compares query plans; skipped in -short mode")
+	}
+	ctx := context.Background()
+	db := testContainer.CreateEmptyDatabase(ctx, t)
+	if err := migrations.RunMigrations(ctx, db); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO users (email, name)
+		SELECT 'indexed' || i || '@example.com', 'Indexed User ' || i FROM generate_series(1, 5000) AS i
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "ANALYZE users"); err != nil {
+		t.Fatalf("Failed to analyze users: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name, query, index string
+	}{
+		{"Email Lookup", "SELECT id FROM users WHERE lower(email) = 'indexed4242@example.com' AND tenant_id = 'default'", "users_tenant_email_lower_key"},
+		{"Newest First", "SELECT id FROM users WHERE tenant_id = 'default' ORDER BY created_at DESC, id DESC LIMIT 20", "users_created_at_idx"},
+		{"By Name", "SELECT id FROM users WHERE tenant_id = 'default' ORDER BY name, id LIMIT 20", "users_name_idx"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rows, err := db.QueryContext(ctx, "EXPLAIN "+tc.query)
+			if err != nil {
+				t.Fatalf("Failed to explain: %v", err)
+			}
+			defer rows.Close()
+			var plan []string
+			for rows.Next() {
+				var line string
+				if err := rows.Scan(&line); err != nil {
+					t.Fatalf("Failed to scan plan: %v", err)
+				}
+				plan = append(plan, line)
+			}
+			text := strings.Join(plan, "\n")
+			if strings.Contains(text, "Seq Scan on users") || !strings.Contains(text, tc.index) {
+				t.Errorf("Expected a scan of %s, got plan:\n%s", tc.index, text)
+			}
+		})
+	}
+}
+
+// TestLoad tests discovering and ordering migration files
+func TestLoad(t *testing.T) {
+	t.Run("Sorted By Version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0010_later.up.sql":   {Data: []byte("SELECT 10;")},
+			"0002_earlier.up.sql": {Data: []byte("SELECT 2;")},
+			"seed.sql":            {Data: []byte("SELECT 'not a migration';")},
+			"README.md":           {Data: []byte("notes")},
+		}
+
+		got, err := migrations.Load(fsys)
+		if err != nil {
+			t.Fatalf("Failed to load migrations: %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("Expected 2 migrations, got: %d", len(got))
+		}
+		if got[0].Version != 2 || got[0].Name != "earlier" || got[1].Version != 10 {
+			t.Errorf("Expected versions 2 then 10, got: %+v", got)
+		}
+	})
+
+	t.Run("Pairs Up And Down", func(t *testing.T) {
+		got, err := migrations.Load(os.DirFS("."))
+		if err != nil {
+			t.Fatalf("Failed to load migrations: %v", err)
+		}
+		for _, m := range got {
+			if m.Down == "" {
+				t.Errorf("Expected a down file for migration %d (%s)", m.Version, m.Name)
+			}
+		}
+	})
+
+	t.Run("Down Without Up", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_orphan.down.sql": {Data: []byte("SELECT 1;")},
+		}
+
+		if _, err := migrations.Load(fsys); err == nil {
+			t.Fatal("Expected error for down file without up file")
+		}
+	})
+
+	t.Run("Empty File", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_one.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_one.down.sql": {Data: []byte(" \n\t\n")},
+		}
+
+		_, err := migrations.Load(fsys)
+		if !errors.Is(err, migrations.ErrEmptyScript) || !strings.Contains(err.Error(), "0001_one.down.sql") {
+			t.Fatalf("Expected ErrEmptyScript naming the file, got: %v", err)
+		}
+	})
+
+	t.Run("Duplicate Version", func(t *testing.T) {
+		fsys := fstest.MapFS{
+			"0001_one.up.sql":     {Data: []byte("SELECT 1;")},
+			"0001_another.up.sql": {Data: []byte("SELECT 1;")},
+		}
+
+		if _, err := migrations.Load(fsys); err == nil {
+			t.Fatal("Expected error for duplicate version")
+		}
+	})
+}
@@ -0,0 +1,6 @@
+-- migrations/seed.sql
+-- Test data, loaded after the migrations (safe to re-run against an existing database)
+INSERT INTO users (email, name) VALUES
+    ('alice@example.com', 'Alice Smith'),
+    ('bob@example.com', 'Bob Johnson')
+ON CONFLICT (tenant_id, lower(email)) DO NOTHING;
@@ -0,0 +1,24 @@
+// models/event.go
+package models
+
+import "time"
+
+// EventType is the kind of change a UserEvent records
+type EventType string
+
+const (
+	EventUserCreated EventType = "user.created"
+	EventUserUpdated EventType = "user.updated"
+	EventUserDeleted EventType = "user.deleted"
+)
+
+// UserEvent is one row of the user_events outbox, published as JSON. User is
+// the row after the write, or before it for user.deleted. Delivery is at
+// least once, so consumers should deduplicate on ID.
+type UserEvent struct {
+	ID         int64     `json:"id"`
+	Type       EventType `json:"type"`
+	UserID     int       `json:"user_id"`
+	User       User      `json:"user"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// Order is an order placed by a user. Amount is in cents, always positive.
+type Order struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Amount    int64     `json:"amount"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -1,12 +1,90 @@
 // models/user.go
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Role is a user's authorization level
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member" // the default for new users
+	RoleGuest  Role = "guest"
+)
+
+// Roles lists every valid role
+var Roles = []Role{RoleAdmin, RoleMember, RoleGuest}
+
+// Valid reports whether r is one of Roles
+func (r Role) Valid() bool {
+	for _, role := range Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
 
 // User represents a user in our system
 type User struct {
 	ID        int       `json:"id"`
+	UUID      uuid.UUID `json:"uuid"` // stable public ID; ID remains the primary key for now
 	Email     string    `json:"email"`
 	Name      string    `json:"name"`
+	Role      Role      `json:"role"`
 	CreatedAt time.Time `json:"created_at"`
-}
\ No newline at end of file
+
+	// AvatarKey is the object key of the user's avatar, empty without one.
+	// Only the single-user lookups (GetByID, GetByUUID, GetByEmail, and the
+	// cached GetByIDCached) read it.
+	AvatarKey string `json:"avatar_key,omitempty"`
+
+	// Version counts the writes to the row, starting at 1; every update
+	// increments it. Like AvatarKey, only the single-user lookups read it,
+	// and it is 0 elsewhere. Pass a user read that way to UpdateWithVersion.
+	Version int `json:"version,omitempty"`
+
+	// Erased is set once the user has been anonymized by EraseUser; the row
+	// stays, under placeholder contact details. Like AvatarKey, only the
+	// single-user lookups read it.
+	Erased bool `json:"erased,omitempty"`
+
+	// PasswordHash is the bcrypt hash, set only by the password methods. It
+	// is never marshaled, so it can't leak into the cache or API responses.
+	PasswordHash string `json:"-"`
+}
+
+// UnmarshalJSON reads a user from the current encoding, and also from
+// payloads written with Go field names as keys ("CreatedAt", "AvatarKey"),
+// so entries cached that way read back rather than missing those fields.
+// Where both spellings are present the current one wins. The other
+// fields match their Go names anyway, json keys being matched ignoring case.
+// CreatedAt is read back in UTC, as the repositories scan it, so a copy
+// written with another offset still equals a fresh read.
+// A type embedding User inherits this method and decodes only User's fields.
+func (u *User) UnmarshalJSON(data []byte) error {
+	type user User // without this method
+	v := struct {
+		user
+		LegacyCreatedAt *time.Time `json:"CreatedAt"`
+		LegacyAvatarKey *string    `json:"AvatarKey"`
+	}{user: user(*u)}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+
+	*u = User(v.user)
+	if v.LegacyCreatedAt != nil && u.CreatedAt.IsZero() {
+		u.CreatedAt = *v.LegacyCreatedAt
+	}
+	if v.LegacyAvatarKey != nil && u.AvatarKey == "" {
+		u.AvatarKey = *v.LegacyAvatarKey
+	}
+	u.CreatedAt = u.CreatedAt.UTC()
+	return nil
+}
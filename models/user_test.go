@@ -0,0 +1,228 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// update rewrites the golden files from the current encoding:
+//
+//	go test ./models -run TestUserJSONGolden -update
+var update = flag.Bool("update", false, "rewrite testdata/*.golden.json")
+
+// goldenUsers are the users whose JSON, the Redis cache payload, is pinned
+// in testdata/<name>.golden.json
+var goldenUsers = []struct {
+	name string
+	user User
+}{
+	{"member", User{
+		ID:           1,
+		UUID:         uuid.MustParse("0b5f1b8e-6f0a-4c4e-9a43-2f4f3c7f2a11"),
+		Email:        "alice@example.com",
+		Name:         "Alice Smith",
+		Role:         RoleMember,
+		CreatedAt:    time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		PasswordHash: "$2a$10$abcdefghijklmnopqrstuuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	}},
+	{"zero_time", User{
+		ID:    2,
+		Email: "zero@example.com",
+		Name:  "Zero Time",
+		Role:  RoleGuest,
+	}},
+	{"non_utc", User{
+		ID:        3,
+		UUID:      uuid.MustParse("5e3c9c1d-8b7a-4f62-b0d4-7a1e9f6c3b22"),
+		Email:     "kathmandu@example.com",
+		Name:      "Non UTC",
+		Role:      RoleAdmin,
+		CreatedAt: time.Date(2024, 6, 30, 23, 59, 59, 123456789, time.FixedZone("NPT", 5*3600+45*60)),
+	}},
+	{"with_avatar", User{
+		ID:        5,
+		UUID:      uuid.MustParse("c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e66"),
+		Email:     "avatar@example.com",
+		Name:      "Avatar User",
+		Role:      RoleMember,
+		CreatedAt: time.Date(2025, 3, 1, 9, 30, 0, 0, time.UTC),
+		AvatarKey: "avatars/5",
+	}},
+	{"unicode", User{
+		ID:        4,
+		UUID:      uuid.MustParse("9a7d2e4f-1c3b-4d5e-8f6a-0b1c2d3e4f55"),
+		Email:     "zoe@example.com",
+		Name:      "Zoë Ångström 山田 <&> 🚀",
+		Role:      RoleMember,
+		CreatedAt: time.Date(2025, 2, 28, 12, 0, 0, 0, time.UTC),
+	}},
+}
+
+// TestUserJSONGolden tests that users marshal exactly as recorded and read
+// back unchanged, apart from the password hash, which is never marshaled
+func TestUserJSONGolden(t *testing.T) {
+	for _, tc := range goldenUsers {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := json.Marshal(tc.user)
+			if err != nil {
+				t.Fatalf("Failed to marshal user: %v", err)
+			}
+
+			path := filepath.Join("testdata", tc.name+".golden.json")
+			if *update {
+				if err := os.WriteFile(path, append(got, '\n'), 0o644); err != nil {
+					t.Fatalf("Failed to update %s: %v", path, err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read %s (run with -update to create it): %v", path, err)
+			}
+			if want = bytes.TrimSuffix(want, []byte("\n")); !bytes.Equal(got, want) {
+				t.Errorf("Encoding changed; cached entries written by other versions may no longer read back.\ngot:  %s\nwant: %s", got, want)
+			}
+
+			var back User
+			if err := json.Unmarshal(want, &back); err != nil {
+				t.Fatalf("Failed to unmarshal %s: %v", path, err)
+			}
+			expected := tc.user
+			expected.PasswordHash = ""
+			if !back.CreatedAt.Equal(expected.CreatedAt) {
+				t.Errorf("Expected created_at %s, got: %s", expected.CreatedAt, back.CreatedAt)
+			}
+			back.CreatedAt, expected.CreatedAt = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(back, expected) {
+				t.Errorf("Expected %+v, got: %+v", expected, back)
+			}
+		})
+	}
+}
+
+// TestUserJSONCompatibility tests that cache payloads recorded from earlier
+// releases, in testdata/compat, read into the current User without losing a
+// field: marshaling the result again must reproduce every recorded value
+func TestUserJSONCompatibility(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "compat", "*.json"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("Expected recorded payloads in testdata/compat, got: %v %v", paths, err)
+	}
+
+	for _, path := range paths {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			recorded, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("Failed to read payload: %v", err)
+			}
+
+			var user User
+			if err := json.Unmarshal(recorded, &user); err != nil {
+				t.Fatalf("Failed to unmarshal payload: %v", err)
+			}
+			again, err := json.Marshal(user)
+			if err != nil {
+				t.Fatalf("Failed to marshal user: %v", err)
+			}
+
+			var before, after map[string]interface{}
+			if err := json.Unmarshal(recorded, &before); err != nil {
+				t.Fatalf("Failed to decode payload: %v", err)
+			}
+			if err := json.Unmarshal(again, &after); err != nil {
+				t.Fatalf("Failed to decode re-marshaled user: %v", err)
+			}
+			for field, value := range before {
+				if field == "created_at" {
+					// Re-marshaled in UTC: the same instant, not the same text
+					value, after[field] = parseTime(t, value), parseTime(t, after[field])
+				}
+				if got, ok := after[field]; !ok || !reflect.DeepEqual(got, value) {
+					t.Errorf("Field %q: recorded %v, got: %v", field, value, got)
+				}
+			}
+		})
+	}
+}
+
+// parseTime parses v, a decoded RFC 3339 string, as a time in UTC
+func parseTime(t *testing.T, v interface{}) time.Time {
+	t.Helper()
+	s, _ := v.(string)
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		t.Fatalf("Failed to parse time %v: %v", v, err)
+	}
+	return parsed.UTC()
+}
+
+// TestUserJSONGoCasedKeys tests that a payload keyed by Go field names, as
+// encoding/json writes a struct without tags, reads into the same user as
+// the current encoding, and never sets the password hash
+func TestUserJSONGoCasedKeys(t *testing.T) {
+	legacy := `{"ID":1,"UUID":"0b5f1b8e-6f0a-4c4e-9a43-2f4f3c7f2a11","Email":"alice@example.com",` +
+		`"Name":"Alice Smith","Role":"member","CreatedAt":"2024-01-02T03:04:05Z","AvatarKey":"avatars/1",` +
+		`"Version":3,"PasswordHash":"$2a$10$leaked"}`
+	want := User{
+		ID:        1,
+		UUID:      uuid.MustParse("0b5f1b8e-6f0a-4c4e-9a43-2f4f3c7f2a11"),
+		Email:     "alice@example.com",
+		Name:      "Alice Smith",
+		Role:      RoleMember,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		AvatarKey: "avatars/1",
+		Version:   3,
+	}
+
+	var got User
+	if err := json.Unmarshal([]byte(legacy), &got); err != nil {
+		t.Fatalf("Failed to unmarshal payload: %v", err)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("Expected created_at %s, got: %s", want.CreatedAt, got.CreatedAt)
+	}
+	got.CreatedAt, want.CreatedAt = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %+v, got: %+v", want, got)
+	}
+
+	t.Run("Current Spelling Wins", func(t *testing.T) {
+		var u User
+		payload := `{"created_at":"2025-01-01T00:00:00Z","CreatedAt":"2020-01-01T00:00:00Z","avatar_key":"new","AvatarKey":"old"}`
+		if err := json.Unmarshal([]byte(payload), &u); err != nil {
+			t.Fatalf("Failed to unmarshal payload: %v", err)
+		}
+		if u.CreatedAt.Year() != 2025 || u.AvatarKey != "new" {
+			t.Errorf("Expected the snake_case values, got: %s, %s", u.CreatedAt, u.AvatarKey)
+		}
+	})
+}
+
+// TestUserJSONCreatedAtUTC tests that created_at written with an offset
+// reads back in UTC, the same value as the repositories scan
+func TestUserJSONCreatedAtUTC(t *testing.T) {
+	chatham := time.FixedZone("+1345", 13*3600+45*60)
+	written := User{ID: 1, CreatedAt: time.Date(2024, 6, 30, 13, 45, 0, 123000000, chatham)}
+	data, err := json.Marshal(written)
+	if err != nil {
+		t.Fatalf("Failed to marshal user: %v", err)
+	}
+	if !bytes.Contains(data, []byte(`"created_at":"2024-06-30T13:45:00.123+13:45"`)) {
+		t.Errorf("Expected created_at in RFC 3339 with its offset, got: %s", data)
+	}
+
+	var back User
+	if err := json.Unmarshal(data, &back); err != nil {
+		t.Fatalf("Failed to unmarshal user: %v", err)
+	}
+	if want := time.Date(2024, 6, 30, 0, 0, 0, 123000000, time.UTC); back.CreatedAt != want {
+		t.Errorf("Expected %s, got: %s", want, back.CreatedAt)
+	}
+}
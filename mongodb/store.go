@@ -0,0 +1,424 @@
+// Package mongodb stores users in MongoDB behind the same
+// repository.UserStore interface as the Postgres repository, so callers and
+// the storetest conformance suite can't tell the two apart.
+package mongodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/repository"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/v2/bson"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// Collection names in the database passed to New
+const (
+	usersCollection    = "users"
+	countersCollection = "counters"
+)
+
+// userDoc is a document of the users collection. _id is an int taken from
+// the counters collection rather than an ObjectID, so models.User.ID means
+// the same as in Postgres; the UUID is stored in its string form.
+type userDoc struct {
+	ID        int       `bson:"_id"`
+	UUID      string    `bson:"uuid"`
+	Email     string    `bson:"email"`
+	Name      string    `bson:"name"`
+	Role      string    `bson:"role"`
+	CreatedAt time.Time `bson:"created_at"`
+}
+
+// user converts d to the model
+func (d userDoc) user() (models.User, error) {
+	id, err := uuid.Parse(d.UUID)
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid uuid %q: %w", d.UUID, err)
+	}
+	return models.User{
+		ID:        d.ID,
+		UUID:      id,
+		Email:     d.Email,
+		Name:      d.Name,
+		Role:      models.Role(d.Role),
+		CreatedAt: d.CreatedAt,
+	}, nil
+}
+
+// UserStore implements repository.UserStore on a MongoDB database
+type UserStore struct {
+	users    *mongo.Collection
+	counters *mongo.Collection
+}
+
+var _ repository.UserStore = (*UserStore)(nil)
+
+// New returns a UserStore on db, creating its indexes if they don't exist:
+// a unique index on the normalized email, the counterpart of Postgres'
+// lower(email) index, and indexes for the uuid, role, and created_at lookups
+func New(ctx context.Context, db *mongo.Database) (*UserStore, error) {
+	s := &UserStore{users: db.Collection(usersCollection), counters: db.Collection(countersCollection)}
+
+	_, err := s.users.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "email", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "uuid", Value: 1}}, Options: options.Index().SetUnique(true)},
+		{Keys: bson.D{{Key: "role", Value: 1}}},
+		{Keys: bson.D{{Key: "created_at", Value: -1}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create indexes: %w", err)
+	}
+	return s, nil
+}
+
+// nextID allocates the next user ID. Like a Postgres sequence, IDs of failed
+// inserts are not reused.
+func (s *UserStore) nextID(ctx context.Context) (int, error) {
+	var counter struct {
+		Seq int `bson:"seq"`
+	}
+	err := s.counters.FindOneAndUpdate(ctx,
+		bson.D{{Key: "_id", Value: usersCollection}},
+		bson.D{{Key: "$inc", Value: bson.D{{Key: "seq", Value: 1}}}},
+		options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After),
+	).Decode(&counter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate id: %w", err)
+	}
+	return counter.Seq, nil
+}
+
+// GetByID retrieves a user by their ID
+func (s *UserStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	return s.findOne(ctx, "mongodb.UserStore.GetByID", fmt.Sprintf("id=%d", id), bson.D{{Key: "_id", Value: id}})
+}
+
+// GetByUUID retrieves a user by their public UUID
+func (s *UserStore) GetByUUID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	return s.findOne(ctx, "mongodb.UserStore.GetByUUID", "uuid="+id.String(), bson.D{{Key: "uuid", Value: id.String()}})
+}
+
+// GetByEmail retrieves a user by their email, ignoring case
+func (s *UserStore) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	email = repository.NormalizeEmail(email)
+	return s.findOne(ctx, "mongodb.UserStore.GetByEmail", "email="+email, bson.D{{Key: "email", Value: email}})
+}
+
+// findOne retrieves the user matching filter, reporting errors under op and key
+func (s *UserStore) findOne(ctx context.Context, op, key string, filter bson.D) (*models.User, error) {
+	var doc userDoc
+	err := s.users.FindOne(ctx, filter).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, newRepoError(op, key, repository.ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to get user: %w", err))
+	}
+
+	user, err := doc.user()
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	return &user, nil
+}
+
+// Create inserts a new user with the default role (member)
+func (s *UserStore) Create(ctx context.Context, email, name string) (*models.User, error) {
+	return s.create(ctx, "mongodb.UserStore.Create", repository.CreateUserInput{Email: email, Name: name})
+}
+
+// CreateWithRole inserts a new user with the given role
+func (s *UserStore) CreateWithRole(ctx context.Context, email, name string, role models.Role) (*models.User, error) {
+	return s.create(ctx, "mongodb.UserStore.CreateWithRole", repository.CreateUserInput{Email: email, Name: name, Role: role})
+}
+
+// create validates and inserts in, reporting errors under op
+func (s *UserStore) create(ctx context.Context, op string, in repository.CreateUserInput) (*models.User, error) {
+	key := "email=" + in.Email
+	in = in.Normalized()
+	if err := in.Validate(); err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+
+	id, err := s.nextID(ctx)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	// MongoDB keeps milliseconds; truncating here returns what a later read sees
+	doc := userDoc{
+		ID:        id,
+		UUID:      uuid.NewString(),
+		Email:     in.Email,
+		Name:      in.Name,
+		Role:      string(in.Role),
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	_, err = s.users.InsertOne(ctx, doc)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, newRepoError(op, key, repository.ErrDuplicateEmail)
+	}
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to create user: %w", err))
+	}
+
+	user, err := doc.user()
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	return &user, nil
+}
+
+// Update modifies an existing user's email and name, keeping their role
+func (s *UserStore) Update(ctx context.Context, id int, email, name string) error {
+	return s.update(ctx, "mongodb.UserStore.Update", id, repository.CreateUserInput{Email: email, Name: name}, false)
+}
+
+// UpdateWithRole modifies an existing user's email, name, and role
+func (s *UserStore) UpdateWithRole(ctx context.Context, id int, email, name string, role models.Role) error {
+	return s.update(ctx, "mongodb.UserStore.UpdateWithRole", id, repository.CreateUserInput{Email: email, Name: name, Role: role}, true)
+}
+
+// update validates in and writes it to user id, including the role if setRole
+func (s *UserStore) update(ctx context.Context, op string, id int, in repository.CreateUserInput, setRole bool) error {
+	key := fmt.Sprintf("id=%d", id)
+	in = in.Normalized()
+	if err := in.Validate(); err != nil {
+		return newRepoError(op, key, err)
+	}
+
+	set := bson.D{{Key: "email", Value: in.Email}, {Key: "name", Value: in.Name}}
+	if setRole {
+		set = append(set, bson.E{Key: "role", Value: string(in.Role)})
+	}
+	result, err := s.users.UpdateOne(ctx, bson.D{{Key: "_id", Value: id}}, bson.D{{Key: "$set", Value: set}})
+	if mongo.IsDuplicateKeyError(err) {
+		return newRepoError(op, key, repository.ErrDuplicateEmail)
+	}
+	if err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to update user: %w", err))
+	}
+	if result.MatchedCount == 0 {
+		return newRepoError(op, key, repository.ErrUserNotFound)
+	}
+	return nil
+}
+
+// Delete removes a user
+func (s *UserStore) Delete(ctx context.Context, id int) error {
+	const op = "mongodb.UserStore.Delete"
+	key := fmt.Sprintf("id=%d", id)
+
+	result, err := s.users.DeleteOne(ctx, bson.D{{Key: "_id", Value: id}})
+	if err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to delete user: %w", err))
+	}
+	if result.DeletedCount == 0 {
+		return newRepoError(op, key, repository.ErrUserNotFound)
+	}
+	return nil
+}
+
+// List retrieves all users, ordered by ID
+func (s *UserStore) List(ctx context.Context) ([]models.User, error) {
+	return s.find(ctx, "mongodb.UserStore.List", "", bson.D{}, byID())
+}
+
+// ListPaginated retrieves a page of up to limit users ordered by ID, after
+// cursor; see repository.UserRepository.ListPaginated
+func (s *UserStore) ListPaginated(ctx context.Context, cursor string, limit int) (repository.UserPage, error) {
+	const op = "mongodb.UserStore.ListPaginated"
+	key := fmt.Sprintf("cursor=%s limit=%d", cursor, limit)
+	afterID, err := pagination.DecodeID(cursor)
+	if err != nil {
+		return repository.UserPage{}, newRepoError(op, key, err)
+	}
+	return s.findPage(ctx, op, key, bson.D{}, afterIDFilter(afterID), byID(), limit, repository.IDCursor)
+}
+
+// FindByNamePattern finds users whose name matches a pattern, ignoring case.
+// As with ILIKE, % matches any run of characters, _ any one character, and
+// a backslash escapes the next.
+func (s *UserStore) FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error) {
+	filter := bson.D{{Key: "name", Value: bson.Regex{Pattern: likeToRegex(pattern), Options: "i"}}}
+	return s.find(ctx, "mongodb.UserStore.FindByNamePattern", "pattern="+pattern, filter, byID())
+}
+
+// likeToRegex translates a LIKE pattern into an unanchored regular
+// expression, the equivalent of matching '%' || pattern || '%'
+func likeToRegex(pattern string) string {
+	var b strings.Builder
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// CountUsers returns total number of users
+func (s *UserStore) CountUsers(ctx context.Context) (int, error) {
+	count, err := s.users.CountDocuments(ctx, bson.D{})
+	if err != nil {
+		return 0, newRepoError("mongodb.UserStore.CountUsers", "", fmt.Errorf("failed to count users: %w", err))
+	}
+	return int(count), nil
+}
+
+// ListByRole retrieves a page of up to limit users with the given role,
+// ordered by ID, after cursor
+func (s *UserStore) ListByRole(ctx context.Context, role models.Role, cursor string, limit int) (repository.UserPage, error) {
+	const op = "mongodb.UserStore.ListByRole"
+	key := fmt.Sprintf("role=%s cursor=%s limit=%d", role, cursor, limit)
+	afterID, err := pagination.DecodeID(cursor)
+	if err != nil {
+		return repository.UserPage{}, newRepoError(op, key, err)
+	}
+	if err := repository.ValidateRole(role); err != nil {
+		return repository.UserPage{}, newRepoError(op, key, err)
+	}
+	filter := bson.D{{Key: "role", Value: string(role)}}
+	return s.findPage(ctx, op, key, filter, afterIDFilter(afterID), byID(), limit, repository.IDCursor)
+}
+
+// CountByRole returns the number of users per role; every role is present,
+// with 0 if nobody has it
+func (s *UserStore) CountByRole(ctx context.Context) (map[models.Role]int, error) {
+	const op = "mongodb.UserStore.CountByRole"
+	cursor, err := s.users.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{{Key: "_id", Value: "$role"}, {Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+	})
+	if err != nil {
+		return nil, newRepoError(op, "", fmt.Errorf("failed to count users by role: %w", err))
+	}
+	var groups []struct {
+		Role  string `bson:"_id"`
+		Count int    `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, newRepoError(op, "", fmt.Errorf("error iterating role counts: %w", err))
+	}
+
+	counts := make(map[models.Role]int, len(models.Roles))
+	for _, role := range models.Roles {
+		counts[role] = 0
+	}
+	for _, g := range groups {
+		counts[models.Role(g.Role)] = g.Count
+	}
+	return counts, nil
+}
+
+// GetRecentUsers retrieves a page of up to limit users created in the last
+// N days, newest first, after cursor, with opts as for the Postgres store.
+// Documents are never soft-deleted, so IncludeDeleted changes nothing.
+func (s *UserStore) GetRecentUsers(ctx context.Context, days int, cursor string, limit int, opts ...repository.QueryOption) (repository.UserPage, error) {
+	const op = "mongodb.UserStore.GetRecentUsers"
+	q := repository.Query{Page: repository.PageOpts{Direction: repository.Descending, Limit: limit}}.With(opts...)
+	limit = q.Page.Limit
+	key := fmt.Sprintf("days=%d cursor=%s limit=%d order=%s domain=%s", days, cursor, limit, q.Page.Direction, q.Filter.EmailDomain)
+	afterCreated, afterID, err := repository.ParseRecentCursor(cursor)
+	if err != nil {
+		return repository.UserPage{}, newRepoError(op, key, err)
+	}
+	since := time.Now().Add(-time.Duration(days) * 24 * time.Hour)
+	filter := bson.D{{Key: "created_at", Value: bson.D{{Key: "$gte", Value: since}}}}
+	if q.Filter.EmailDomain != "" {
+		domain := bson.Regex{Pattern: "@" + regexp.QuoteMeta(q.Filter.EmailDomain) + "$", Options: "i"}
+		filter = append(filter, bson.E{Key: "email", Value: domain})
+	}
+	// After (afterCreated, afterID) in the order below
+	keyset, order := "$lt", -1
+	if q.Page.Direction == repository.Ascending {
+		keyset, order = "$gt", 1
+	}
+	var after bson.D
+	if cursor != "" {
+		after = bson.D{{Key: "$or", Value: bson.A{
+			bson.D{{Key: "created_at", Value: bson.D{{Key: keyset, Value: afterCreated}}}},
+			bson.D{{Key: "created_at", Value: afterCreated}, {Key: "_id", Value: bson.D{{Key: keyset, Value: afterID}}}},
+		}}}
+	}
+	findOpts := options.Find().SetSort(bson.D{{Key: "created_at", Value: order}, {Key: "_id", Value: order}})
+	return s.findPage(ctx, op, key, filter, after, findOpts, limit, repository.RecentCursor)
+}
+
+// byID sorts by ascending ID
+func byID() *options.FindOptionsBuilder {
+	return options.Find().SetSort(bson.D{{Key: "_id", Value: 1}})
+}
+
+// find retrieves the users matching filter, reporting errors under op and key
+func (s *UserStore) find(ctx context.Context, op, key string, filter bson.D, opts *options.FindOptionsBuilder) ([]models.User, error) {
+	cursor, err := s.users.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to list users: %w", err))
+	}
+	var docs []userDoc
+	if err := cursor.All(ctx, &docs); err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("error iterating users: %w", err))
+	}
+
+	users := make([]models.User, 0, len(docs))
+	for _, doc := range docs {
+		user, err := doc.user()
+		if err != nil {
+			return nil, newRepoError(op, key, err)
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
+
+// afterIDFilter matches the users after afterID in ID order
+func afterIDFilter(afterID int) bson.D {
+	return bson.D{{Key: "_id", Value: bson.D{{Key: "$gt", Value: afterID}}}}
+}
+
+// findPage retrieves the page of up to limit users matching filter and
+// after, sorted by opts, with the count of those matching filter alone as
+// its total. after is the cursor's position, nil for the first page.
+func (s *UserStore) findPage(ctx context.Context, op, key string, filter, after bson.D, opts *options.FindOptionsBuilder, limit int, cursor func(models.User) pagination.Cursor) (repository.UserPage, error) {
+	if err := repository.ValidateLimit(limit); err != nil {
+		return repository.UserPage{}, newRepoError(op, key, err)
+	}
+	pageFilter := filter
+	if after != nil {
+		pageFilter = bson.D{{Key: "$and", Value: bson.A{filter, after}}}
+	}
+	users, err := s.find(ctx, op, key, pageFilter, opts.SetLimit(int64(pagination.FetchLimit(limit))))
+	if err != nil {
+		return repository.UserPage{}, err
+	}
+	total, err := s.users.CountDocuments(ctx, filter)
+	if err != nil {
+		return repository.UserPage{}, newRepoError(op, key, fmt.Errorf("failed to count users: %w", err))
+	}
+	return pagination.New(users, limit, total, cursor), nil
+}
+
+// newRepoError wraps err in a repository.RepoError
+func newRepoError(op, key string, err error) error {
+	return &repository.RepoError{Op: op, Key: key, Err: err}
+}
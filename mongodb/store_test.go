@@ -0,0 +1,37 @@
+package mongodb
+
+import (
+	"regexp"
+	"testing"
+)
+
+// TestLikeToRegex tests that LIKE wildcards translate and everything else
+// matches literally
+func TestLikeToRegex(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"Marlowe", "Quentin Marlowe", true},
+		{"qu%we", "Quentin Marlowe", true},
+		{"Qu_lla", "Quilla Marlowe", true},
+		{"Qu_lla", "Quiilla Marlowe", false},
+		{"A.B", "A.B (C)", true},
+		{"A.B", "AxB", false},
+		{"(C)", "A.B (C)", true},
+		{"", "Anyone", true},
+		{`100\%`, "100% Cotton", true},
+		{`100\%`, "1000 Cotton", false},
+		{`a\_b`, "a_b", true},
+		{`a\_b`, "axb", false},
+		{`back\\slash`, `back\slash`, true},
+	}
+
+	for _, tc := range tests {
+		re := regexp.MustCompile("(?i)" + likeToRegex(tc.pattern))
+		if got := re.MatchString(tc.name); got != tc.match {
+			t.Errorf("Pattern %q against %q: expected match %t, got: %t", tc.pattern, tc.name, tc.match, got)
+		}
+	}
+}
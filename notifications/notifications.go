@@ -0,0 +1,117 @@
+// Package notifications tells other systems about user changes as they
+// happen. CachedUserRepository publishes an Event after each of its writes
+// through a Publisher: Noop by default, or SNSPublisher in production.
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"testcontainers-demo/backoff"
+	"testcontainers-demo/models"
+
+	"github.com/google/uuid"
+)
+
+// Event is the JSON message published for one write. User is the row after
+// the write, or before it for user.deleted. ID is unique per write, so
+// consumers can drop a message a retried publish delivered twice.
+type Event struct {
+	ID         uuid.UUID        `json:"id"`
+	Type       models.EventType `json:"type"`
+	UserID     int              `json:"user_id"`
+	User       models.User      `json:"user"`
+	OccurredAt time.Time        `json:"occurred_at"`
+}
+
+// NewEvent returns the event of type eventType for user, with a new ID
+func NewEvent(eventType models.EventType, user models.User) Event {
+	return Event{
+		ID:         uuid.New(),
+		Type:       eventType,
+		UserID:     user.ID,
+		User:       user,
+		OccurredAt: time.Now().UTC(),
+	}
+}
+
+// Publisher sends events somewhere
+type Publisher interface {
+	Publish(ctx context.Context, event Event) error
+}
+
+// Noop discards every event; it is the default Publisher
+type Noop struct{}
+
+// Publish does nothing
+func (Noop) Publish(context.Context, Event) error { return nil }
+
+// retrying is the Publisher WithRetry returns
+type retrying struct {
+	Publisher
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// maxDelay caps the wait between publish attempts
+const maxDelay = 30 * time.Second
+
+// WithRetry wraps p so a publish that fails with a transient error is
+// attempted up to maxAttempts times in all, waiting baseDelay before the
+// first retry and doubling it, with jitter, before each further one, up to
+// 30 seconds
+func WithRetry(p Publisher, maxAttempts int, baseDelay time.Duration) Publisher {
+	return &retrying{Publisher: p, maxAttempts: maxAttempts, baseDelay: baseDelay}
+}
+
+// Publish publishes event, retrying transient failures
+func (r *retrying) Publish(ctx context.Context, event Event) error {
+	for attempt := 1; ; attempt++ {
+		err := r.Publisher.Publish(ctx, event)
+		if err == nil || !IsTransient(err) || attempt >= r.maxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff.Delay(attempt, r.baseDelay, maxDelay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w after %d attempts: %v", ctx.Err(), attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// throttlingCodes are the AWS error codes for a request rejected by rate limiting
+var throttlingCodes = map[string]bool{
+	"Throttling":               true,
+	"ThrottlingException":      true,
+	"ThrottledException":       true,
+	"RequestLimitExceeded":     true,
+	"TooManyRequestsException": true,
+}
+
+// IsTransient reports whether a publish that failed with err may succeed if
+// tried again: network errors, throttling, and server-side (5xx) failures.
+// Errors about the request itself, like a missing topic, are not.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var coded interface{ ErrorCode() string }
+	if errors.As(err, &coded) && throttlingCodes[coded.ErrorCode()] {
+		return true
+	}
+	var status interface{ HTTPStatusCode() int }
+	if errors.As(err, &status) {
+		code := status.HTTPStatusCode()
+		return code >= 500 || code == 429
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,146 @@
+package notifications_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/notifications"
+	"testcontainers-demo/testhelpers"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable, and only the integration tests need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// statusError is an error carrying an HTTP status, like the AWS SDK's
+type statusError int
+
+func (e statusError) Error() string       { return fmt.Sprintf("status %d", int(e)) }
+func (e statusError) HTTPStatusCode() int { return int(e) }
+
+// flakyPublisher fails with errs in turn, then succeeds
+type flakyPublisher struct {
+	errs  []error
+	calls int
+}
+
+func (p *flakyPublisher) Publish(context.Context, notifications.Event) error {
+	p.calls++
+	if p.calls <= len(p.errs) {
+		return p.errs[p.calls-1]
+	}
+	return nil
+}
+
+// TestWithRetry tests which publish failures are retried, and how often
+func TestWithRetry(t *testing.T) {
+	ctx := context.Background()
+	event := notifications.NewEvent(models.EventUserCreated, models.User{ID: 1})
+
+	t.Run("Transient Then Success", func(t *testing.T) {
+		p := &flakyPublisher{errs: []error{statusError(503), statusError(429)}}
+		if err := notifications.WithRetry(p, 3, time.Millisecond).Publish(ctx, event); err != nil {
+			t.Fatalf("Expected success on the third attempt, got: %v", err)
+		}
+		if p.calls != 3 {
+			t.Errorf("Expected 3 attempts, got: %d", p.calls)
+		}
+	})
+
+	t.Run("Gives Up", func(t *testing.T) {
+		p := &flakyPublisher{errs: []error{statusError(500), statusError(500), statusError(500)}}
+		err := notifications.WithRetry(p, 2, time.Millisecond).Publish(ctx, event)
+		if !errors.Is(err, statusError(500)) {
+			t.Errorf("Expected the last error, got: %v", err)
+		}
+		if p.calls != 2 {
+			t.Errorf("Expected 2 attempts, got: %d", p.calls)
+		}
+	})
+
+	t.Run("Permanent Not Retried", func(t *testing.T) {
+		p := &flakyPublisher{errs: []error{statusError(404)}}
+		if err := notifications.WithRetry(p, 3, time.Millisecond).Publish(ctx, event); err == nil {
+			t.Fatal("Expected the 404 to be returned")
+		}
+		if p.calls != 1 {
+			t.Errorf("Expected 1 attempt, got: %d", p.calls)
+		}
+	})
+
+	t.Run("Context Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		p := &flakyPublisher{errs: []error{statusError(503), statusError(503)}}
+		err := notifications.WithRetry(p, 3, time.Hour).Publish(ctx, event)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+	})
+}
+
+// TestNewEvent tests that every event gets its own ID
+func TestNewEvent(t *testing.T) {
+	user := models.User{ID: 7, Email: "event@example.com"}
+	a := notifications.NewEvent(models.EventUserUpdated, user)
+	b := notifications.NewEvent(models.EventUserUpdated, user)
+
+	if a.ID == b.ID {
+		t.Errorf("Expected distinct IDs, got %s twice", a.ID)
+	}
+	if a.UserID != 7 || a.User.Email != user.Email || a.Type != models.EventUserUpdated {
+		t.Errorf("Expected the event to describe the user, got: %+v", a)
+	}
+}
+
+// codeError is an error carrying an AWS error code
+type codeError string
+
+func (e codeError) Error() string     { return string(e) }
+func (e codeError) ErrorCode() string { return string(e) }
+
+// TestIsTransient tests which errors are worth retrying
+func TestIsTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"server error", statusError(502), true},
+		{"too many requests", statusError(429), true},
+		{"not found", statusError(404), false},
+		{"throttled", codeError("ThrottlingException"), true},
+		{"missing topic", codeError("NotFound"), false},
+		{"network", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"canceled", fmt.Errorf("publish: %w", context.Canceled), false},
+		{"plain", errors.New("boom"), false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := notifications.IsTransient(tc.err); got != tc.want {
+				t.Errorf("IsTransient(%v) = %v, want %v", tc.err, got, tc.want)
+			}
+		})
+	}
+}
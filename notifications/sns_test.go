@@ -0,0 +1,162 @@
+package notifications_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/notifications"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// subscribeQueue creates a topic and a queue subscribed to it, both with
+// names unique to the test, returning the topic ARN and queue URL. Raw
+// delivery puts the published JSON in the message body unwrapped.
+func subscribeQueue(ctx context.Context, t *testing.T, snsClient *sns.Client, sqsClient *sqs.Client) (string, string) {
+	t.Helper()
+	name := fmt.Sprintf("user-events-%d", time.Now().UnixNano())
+
+	topic, err := snsClient.CreateTopic(ctx, &sns.CreateTopicInput{Name: aws.String(name)})
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	queue, err := sqsClient.CreateQueue(ctx, &sqs.CreateQueueInput{QueueName: aws.String(name)})
+	if err != nil {
+		t.Fatalf("Failed to create queue: %v", err)
+	}
+	attrs, err := sqsClient.GetQueueAttributes(ctx, &sqs.GetQueueAttributesInput{
+		QueueUrl:       queue.QueueUrl,
+		AttributeNames: []sqstypes.QueueAttributeName{sqstypes.QueueAttributeNameQueueArn},
+	})
+	if err != nil {
+		t.Fatalf("Failed to get queue ARN: %v", err)
+	}
+
+	_, err = snsClient.Subscribe(ctx, &sns.SubscribeInput{
+		TopicArn:   topic.TopicArn,
+		Protocol:   aws.String("sqs"),
+		Endpoint:   aws.String(attrs.Attributes[string(sqstypes.QueueAttributeNameQueueArn)]),
+		Attributes: map[string]string{"RawMessageDelivery": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Failed to subscribe queue: %v", err)
+	}
+	return *topic.TopicArn, *queue.QueueUrl
+}
+
+// receiveEvents reads and deletes messages from queueURL until want events
+// arrived or timeout passed
+func receiveEvents(ctx context.Context, t *testing.T, sqsClient *sqs.Client, queueURL string, want int, timeout time.Duration) []notifications.Event {
+	t.Helper()
+
+	var events []notifications.Event
+	for deadline := time.Now().Add(timeout); len(events) < want && time.Now().Before(deadline); {
+		out, err := sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     1,
+		})
+		if err != nil {
+			t.Fatalf("Failed to receive messages: %v", err)
+		}
+
+		for _, msg := range out.Messages {
+			var event notifications.Event
+			if err := json.Unmarshal([]byte(aws.ToString(msg.Body)), &event); err != nil {
+				t.Fatalf("Failed to decode message %q: %v", aws.ToString(msg.Body), err)
+			}
+			events = append(events, event)
+
+			_, err := sqsClient.DeleteMessage(ctx, &sqs.DeleteMessageInput{QueueUrl: aws.String(queueURL), ReceiptHandle: msg.ReceiptHandle})
+			if err != nil {
+				t.Fatalf("Failed to delete message: %v", err)
+			}
+		}
+	}
+	return events
+}
+
+// TestSNSNotifications tests that each cached write publishes exactly one
+// event to SNS, delivered to a subscribed SQS queue
+func TestSNSNotifications(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	cfg := testhelpers.StartLocalStack(ctx, t)
+	snsClient := sns.NewFromConfig(cfg)
+	sqsClient := sqs.NewFromConfig(cfg)
+	topicARN, queueURL := subscribeQueue(ctx, t, snsClient, sqsClient)
+
+	publisher := notifications.WithRetry(notifications.NewSNSPublisher(snsClient, topicARN), 3, 50*time.Millisecond)
+	cachedRepo := repository.NewCachedUserRepository(
+		testContainer.CreateTestDatabase(ctx, t),
+		testhelpers.StartRedis(ctx, t),
+		repository.WithPublisher(publisher),
+	)
+
+	user, err := cachedRepo.CreateCached(ctx, "notified@example.com", "Notified User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := cachedRepo.UpdateCached(ctx, user.ID, "notified@example.com", "Renamed User"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	if err := cachedRepo.DeleteCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	events := receiveEvents(ctx, t, sqsClient, queueURL, 3, 30*time.Second)
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %d: %+v", len(events), events)
+	}
+
+	// A standard queue doesn't keep order, so match events by type
+	byType := map[models.EventType]notifications.Event{}
+	ids := map[string]bool{}
+	for _, event := range events {
+		if _, dup := byType[event.Type]; dup {
+			t.Errorf("Expected one %s event, got another: %+v", event.Type, event)
+		}
+		byType[event.Type] = event
+		ids[event.ID.String()] = true
+
+		if event.UserID != user.ID || event.User.ID != user.ID {
+			t.Errorf("Expected %s event for user %d, got: %+v", event.Type, user.ID, event)
+		}
+		if event.OccurredAt.IsZero() {
+			t.Errorf("Expected %s event to have occurred_at", event.Type)
+		}
+	}
+	if len(ids) != 3 {
+		t.Errorf("Expected 3 distinct event IDs, got: %v", ids)
+	}
+
+	wantNames := map[models.EventType]string{
+		models.EventUserCreated: "Notified User",
+		models.EventUserUpdated: "Renamed User",
+		models.EventUserDeleted: "Renamed User",
+	}
+	for eventType, name := range wantNames {
+		event, ok := byType[eventType]
+		if !ok {
+			t.Errorf("Expected a %s event", eventType)
+			continue
+		}
+		if event.User.Name != name || event.User.Email != "notified@example.com" {
+			t.Errorf("Expected %s event for %s <notified@example.com>, got: %+v", eventType, name, event.User)
+		}
+	}
+
+	// Nothing else arrives: no write was published twice
+	if extra := receiveEvents(ctx, t, sqsClient, queueURL, 1, 3*time.Second); len(extra) != 0 {
+		t.Errorf("Expected no further events, got: %+v", extra)
+	}
+}
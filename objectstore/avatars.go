@@ -0,0 +1,151 @@
+// Package objectstore keeps user avatars in S3-compatible object storage,
+// under avatars/{id} in one bucket, with the key recorded on the user's row
+// so a lookup tells whether they have one.
+package objectstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"time"
+
+	"testcontainers-demo/repository"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// DefaultMaxAvatarSize is the largest avatar UploadAvatar accepts unless
+// WithMaxAvatarSize says otherwise
+const DefaultMaxAvatarSize = 5 << 20 // 5 MiB
+
+// ErrNoAvatar is returned by GetAvatarURL for a user without an avatar
+var ErrNoAvatar = errors.New("objectstore: user has no avatar")
+
+// TooLargeError is returned by UploadAvatar for an avatar over the size limit
+type TooLargeError struct {
+	Limit int64 // bytes
+}
+
+// Error formats as "avatar exceeds 5242880 bytes"
+func (e *TooLargeError) Error() string {
+	return fmt.Sprintf("avatar exceeds %d bytes", e.Limit)
+}
+
+// Avatars stores avatars in a bucket and their keys through a UserRepository
+type Avatars struct {
+	client  *minio.Client
+	bucket  string
+	users   *repository.UserRepository
+	maxSize int64
+}
+
+// Option configures Avatars
+type Option func(*Avatars)
+
+// WithMaxAvatarSize sets the largest avatar UploadAvatar accepts, in bytes
+func WithMaxAvatarSize(bytes int64) Option {
+	return func(a *Avatars) {
+		a.maxSize = bytes
+	}
+}
+
+// NewAvatars stores avatars in bucket, creating it if it doesn't exist, and
+// their keys through users
+func NewAvatars(ctx context.Context, client *minio.Client, bucket string, users *repository.UserRepository, opts ...Option) (*Avatars, error) {
+	a := &Avatars{client: client, bucket: bucket, users: users, maxSize: DefaultMaxAvatarSize}
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	exists, err := client.BucketExists(ctx, bucket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check bucket %s: %w", bucket, err)
+	}
+	if !exists {
+		if err := client.MakeBucket(ctx, bucket, minio.MakeBucketOptions{}); err != nil {
+			return nil, fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+		}
+	}
+	return a, nil
+}
+
+// avatarKey is the object key of user id's avatar
+func avatarKey(id int) string {
+	return "avatars/" + strconv.Itoa(id)
+}
+
+// UploadAvatar stores r as user id's avatar, replacing any previous one. An
+// avatar over the size limit returns a *TooLargeError without storing
+// anything; an unknown user returns repository.ErrUserNotFound.
+func (a *Avatars) UploadAvatar(ctx context.Context, userID int, r io.Reader, contentType string) error {
+	// Read one byte past the limit to tell "exactly the limit" from "over it"
+	data, err := io.ReadAll(io.LimitReader(r, a.maxSize+1))
+	if err != nil {
+		return fmt.Errorf("failed to read avatar of user %d: %w", userID, err)
+	}
+	if int64(len(data)) > a.maxSize {
+		return &TooLargeError{Limit: a.maxSize}
+	}
+
+	key := avatarKey(userID)
+	_, err = a.client.PutObject(ctx, a.bucket, key, bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return fmt.Errorf("failed to upload avatar of user %d: %w", userID, err)
+	}
+
+	if err := a.users.SetAvatarKey(ctx, userID, key); err != nil {
+		// Don't leave an object behind for a user that doesn't exist
+		a.client.RemoveObject(ctx, a.bucket, key, minio.RemoveObjectOptions{})
+		return err
+	}
+	return nil
+}
+
+// GetAvatarURL returns a presigned URL that downloads user id's avatar until
+// expiry passes, or ErrNoAvatar if they have none
+func (a *Avatars) GetAvatarURL(ctx context.Context, userID int, expiry time.Duration) (*url.URL, error) {
+	user, err := a.users.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.AvatarKey == "" {
+		return nil, ErrNoAvatar
+	}
+
+	u, err := a.client.PresignedGetObject(ctx, a.bucket, user.AvatarKey, expiry, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign avatar of user %d: %w", userID, err)
+	}
+	return u, nil
+}
+
+// DeleteAvatar removes user id's avatar and clears its key. Removing an
+// avatar that doesn't exist is not an error.
+func (a *Avatars) DeleteAvatar(ctx context.Context, userID int) error {
+	if err := a.removeObject(ctx, userID); err != nil {
+		return err
+	}
+	return a.users.SetAvatarKey(ctx, userID, "")
+}
+
+// DeleteUser deletes user id and then their avatar, so deleted users leave
+// no objects behind
+func (a *Avatars) DeleteUser(ctx context.Context, userID int) error {
+	if err := a.users.Delete(ctx, userID); err != nil {
+		return err
+	}
+	return a.removeObject(ctx, userID)
+}
+
+// removeObject deletes the object of user id's avatar, if there is one
+func (a *Avatars) removeObject(ctx context.Context, userID int) error {
+	if err := a.client.RemoveObject(ctx, a.bucket, avatarKey(userID), minio.RemoveObjectOptions{}); err != nil {
+		return fmt.Errorf("failed to delete avatar of user %d: %w", userID, err)
+	}
+	return nil
+}
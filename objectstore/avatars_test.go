@@ -0,0 +1,168 @@
+package objectstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/objectstore"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// maxSize is the avatar size limit in these tests
+const maxSize = 1024
+
+// TestAvatars tests uploading, downloading, and deleting avatars against MinIO
+func TestAvatars(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	client := testhelpers.StartMinIO(ctx, t)
+	db := testContainer.CreateTestDatabase(ctx, t)
+	users := repository.NewUserRepository(db)
+	t.Cleanup(func() { users.Close() })
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("Failed to generate bucket name: %v", err)
+	}
+	bucket := "avatars-" + hex.EncodeToString(suffix)
+	avatars, err := objectstore.NewAvatars(ctx, client, bucket, users, objectstore.WithMaxAvatarSize(maxSize))
+	if err != nil {
+		t.Fatalf("Failed to create avatar store: %v", err)
+	}
+
+	// objectExists reports whether user id's avatar object is in the bucket
+	objectExists := func(t *testing.T, id int) bool {
+		t.Helper()
+		_, err := client.StatObject(ctx, bucket, "avatars/"+strconv.Itoa(id), minio.StatObjectOptions{})
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return false
+		}
+		if err != nil {
+			t.Fatalf("Failed to stat avatar: %v", err)
+		}
+		return true
+	}
+
+	avatar := bytes.Repeat([]byte{0x89, 'P', 'N', 'G'}, maxSize/4)
+
+	t.Run("Upload And Download", func(t *testing.T) {
+		user := fixtures.SeedUsers(t, db, fixtures.NewUser())[0]
+		if err := avatars.UploadAvatar(ctx, user.ID, bytes.NewReader(avatar), "image/png"); err != nil {
+			t.Fatalf("Failed to upload avatar: %v", err)
+		}
+
+		u, err := avatars.GetAvatarURL(ctx, user.ID, time.Minute)
+		if err != nil {
+			t.Fatalf("Failed to get avatar URL: %v", err)
+		}
+		res, err := http.Get(u.String())
+		if err != nil {
+			t.Fatalf("Failed to download avatar: %v", err)
+		}
+		defer res.Body.Close()
+		got, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatalf("Failed to read avatar: %v", err)
+		}
+
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got: %s %s", res.Status, got)
+		}
+		if !bytes.Equal(got, avatar) {
+			t.Errorf("Expected the uploaded %d bytes back, got %d different bytes", len(avatar), len(got))
+		}
+		if ct := res.Header.Get("Content-Type"); ct != "image/png" {
+			t.Errorf("Expected Content-Type image/png, got: %s", ct)
+		}
+	})
+
+	t.Run("Deleting User Deletes Avatar", func(t *testing.T) {
+		user := fixtures.SeedUsers(t, db, fixtures.NewUser())[0]
+		if err := avatars.UploadAvatar(ctx, user.ID, bytes.NewReader(avatar), "image/png"); err != nil {
+			t.Fatalf("Failed to upload avatar: %v", err)
+		}
+		if !objectExists(t, user.ID) {
+			t.Fatal("Expected the avatar object after upload")
+		}
+
+		if err := avatars.DeleteUser(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if objectExists(t, user.ID) {
+			t.Error("Expected the avatar object to be deleted with the user")
+		}
+	})
+
+	t.Run("Delete Avatar", func(t *testing.T) {
+		user := fixtures.SeedUsers(t, db, fixtures.NewUser())[0]
+		if err := avatars.UploadAvatar(ctx, user.ID, bytes.NewReader(avatar), "image/png"); err != nil {
+			t.Fatalf("Failed to upload avatar: %v", err)
+		}
+		if err := avatars.DeleteAvatar(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete avatar: %v", err)
+		}
+
+		if objectExists(t, user.ID) {
+			t.Error("Expected the avatar object to be deleted")
+		}
+		if _, err := avatars.GetAvatarURL(ctx, user.ID, time.Minute); !errors.Is(err, objectstore.ErrNoAvatar) {
+			t.Errorf("Expected ErrNoAvatar, got: %v", err)
+		}
+	})
+
+	t.Run("Too Large", func(t *testing.T) {
+		user := fixtures.SeedUsers(t, db, fixtures.NewUser())[0]
+		err := avatars.UploadAvatar(ctx, user.ID, bytes.NewReader(append(avatar, 0)), "image/png")
+
+		var tooLarge *objectstore.TooLargeError
+		if !errors.As(err, &tooLarge) || tooLarge.Limit != maxSize {
+			t.Fatalf("Expected a TooLargeError with limit %d, got: %v", maxSize, err)
+		}
+		if objectExists(t, user.ID) {
+			t.Error("Expected nothing to be stored for a rejected avatar")
+		}
+	})
+
+	t.Run("Unknown User", func(t *testing.T) {
+		const missingID = 1 << 30
+		err := avatars.UploadAvatar(ctx, missingID, bytes.NewReader(avatar), "image/png")
+		if !errors.Is(err, repository.ErrUserNotFound) {
+			t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if objectExists(t, missingID) {
+			t.Error("Expected no object for a user that doesn't exist")
+		}
+	})
+}
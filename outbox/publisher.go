@@ -0,0 +1,188 @@
+// Package outbox publishes the user events the repository records in the
+// user_events table to Kafka
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"testcontainers-demo/models"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// Defaults for NewPublisher's options
+const (
+	DefaultBatchSize    = 100
+	DefaultPollInterval = time.Second
+)
+
+// MessageWriter is the part of *kafka.Writer the Publisher uses; the writer
+// decides the topic
+type MessageWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+}
+
+// Publisher moves pending rows of the user_events outbox to Kafka. A batch is
+// claimed, published, and marked dispatched in one transaction, so a failed
+// publish leaves its rows pending for the next poll. A crash after Kafka
+// accepted a batch but before the commit publishes it again: delivery is at
+// least once, and consumers deduplicate on the event ID.
+type Publisher struct {
+	db        *sql.DB
+	writer    MessageWriter
+	batchSize int
+	interval  time.Duration
+}
+
+// Option configures a Publisher
+type Option func(*Publisher)
+
+// WithBatchSize sets how many events one publish claims at most
+func WithBatchSize(n int) Option {
+	return func(p *Publisher) {
+		p.batchSize = n
+	}
+}
+
+// WithPollInterval sets how long Run waits after finding no pending events
+func WithPollInterval(d time.Duration) Option {
+	return func(p *Publisher) {
+		p.interval = d
+	}
+}
+
+// NewPublisher creates a publisher of db's outbox to writer
+func NewPublisher(db *sql.DB, writer MessageWriter, opts ...Option) *Publisher {
+	p := &Publisher{
+		db:        db,
+		writer:    writer,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultPollInterval,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+// Run publishes pending events until ctx is done, draining the outbox
+// batch by batch and then polling every interval. A failed publish is
+// logged and retried on the next poll. It returns nil once ctx is done.
+func (p *Publisher) Run(ctx context.Context) error {
+	for {
+		n, err := p.PublishPending(ctx)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			log.Printf("outbox: %v", err)
+		}
+		// A full batch means there are probably more waiting
+		if err == nil && n == p.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(p.interval):
+		}
+	}
+}
+
+// PublishPending publishes one batch of the oldest pending events and marks
+// them dispatched, returning how many were published. Rows another publisher
+// has claimed are skipped, so several can run against one outbox.
+func (p *Publisher) PublishPending(ctx context.Context) (int, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// The row locks hold until commit; if publishing fails the rollback
+	// undoes dispatched_at and releases them
+	rows, err := tx.QueryContext(ctx, `
+		UPDATE user_events SET dispatched_at = CURRENT_TIMESTAMP
+		WHERE id IN (
+			SELECT id FROM user_events
+			WHERE dispatched_at IS NULL
+			ORDER BY id
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, event_type, user_id, payload, created_at
+	`, p.batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("failed to claim events: %w", err)
+	}
+	events, err := scanEvents(rows)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	msgs := make([]kafka.Message, len(events))
+	for i, event := range events {
+		if msgs[i], err = Message(event); err != nil {
+			return 0, err
+		}
+	}
+	if err := p.writer.WriteMessages(ctx, msgs...); err != nil {
+		return 0, fmt.Errorf("failed to publish %d events: %w", len(events), err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to mark %d events dispatched: %w", len(events), err)
+	}
+	return len(events), nil
+}
+
+// scanEvents reads claimed user_events rows, oldest first; RETURNING doesn't keep the subquery's order
+func scanEvents(rows *sql.Rows) ([]models.UserEvent, error) {
+	defer rows.Close()
+
+	var events []models.UserEvent
+	for rows.Next() {
+		var event models.UserEvent
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.UserID, &payload, &event.OccurredAt); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %w", err)
+		}
+		if err := json.Unmarshal(payload, &event.User); err != nil {
+			return nil, fmt.Errorf("failed to decode payload of event %d: %w", event.ID, err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %w", err)
+	}
+
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+// Message encodes event as the Kafka message the Publisher sends: keyed by
+// user ID so one user's events stay in order on a partition, with the event
+// type in a header and the event as the JSON value
+func Message(event models.UserEvent) (kafka.Message, error) {
+	value, err := json.Marshal(event)
+	if err != nil {
+		return kafka.Message{}, fmt.Errorf("failed to encode event %d: %w", event.ID, err)
+	}
+	return kafka.Message{
+		Key:     []byte(strconv.Itoa(event.UserID)),
+		Value:   value,
+		Headers: []kafka.Header{{Key: "event_type", Value: []byte(event.Type)}},
+		Time:    event.OccurredAt,
+	}, nil
+}
@@ -0,0 +1,319 @@
+package outbox_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/outbox"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/segmentio/kafka-go"
+)
+
+// testContainer provides a fresh database, and so an empty outbox, per
+// test. It is nil when Docker is unavailable, and only the integration
+// tests need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// createTopic creates a single-partition topic with a unique name, so every
+// message on it is in publish order
+func createTopic(t *testing.T, brokers []string) string {
+	t.Helper()
+	topic := fmt.Sprintf("user-events-%d", time.Now().UnixNano())
+
+	conn, err := kafka.Dial("tcp", brokers[0])
+	if err != nil {
+		t.Fatalf("Failed to dial Kafka: %v", err)
+	}
+	defer conn.Close()
+	controller, err := conn.Controller()
+	if err != nil {
+		t.Fatalf("Failed to find the controller: %v", err)
+	}
+	controllerConn, err := kafka.Dial("tcp", net.JoinHostPort(controller.Host, strconv.Itoa(controller.Port)))
+	if err != nil {
+		t.Fatalf("Failed to dial the controller: %v", err)
+	}
+	defer controllerConn.Close()
+
+	err = controllerConn.CreateTopics(kafka.TopicConfig{Topic: topic, NumPartitions: 1, ReplicationFactor: 1})
+	if err != nil {
+		t.Fatalf("Failed to create topic: %v", err)
+	}
+	return topic
+}
+
+// newWriter returns a writer to topic that waits for the brokers to acknowledge every batch
+func newWriter(t *testing.T, brokers []string, topic string) *kafka.Writer {
+	t.Helper()
+	w := &kafka.Writer{
+		Addr:         kafka.TCP(brokers...),
+		Topic:        topic,
+		RequiredAcks: kafka.RequireAll,
+		BatchTimeout: 10 * time.Millisecond,
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// readTopic returns every message on topic so far
+func readTopic(ctx context.Context, t *testing.T, brokers []string, topic string) []kafka.Message {
+	t.Helper()
+
+	conn, err := kafka.DialLeader(ctx, "tcp", brokers[0], topic, 0)
+	if err != nil {
+		t.Fatalf("Failed to dial the partition leader: %v", err)
+	}
+	last, err := conn.ReadLastOffset()
+	conn.Close()
+	if err != nil {
+		t.Fatalf("Failed to read the last offset: %v", err)
+	}
+
+	r := kafka.NewReader(kafka.ReaderConfig{Brokers: brokers, Topic: topic, MaxWait: 100 * time.Millisecond})
+	defer r.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	var msgs []kafka.Message
+	for int64(len(msgs)) < last {
+		msg, err := r.ReadMessage(ctx)
+		if err != nil {
+			t.Fatalf("Failed to read message %d of %d: %v", len(msgs)+1, last, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+// decodeEvent unmarshals the UserEvent a message carries
+func decodeEvent(t *testing.T, msg kafka.Message) models.UserEvent {
+	t.Helper()
+	var event models.UserEvent
+	if err := json.Unmarshal(msg.Value, &event); err != nil {
+		t.Fatalf("Failed to decode event %s: %v", msg.Value, err)
+	}
+	return event
+}
+
+// pendingEvents counts the outbox rows not yet dispatched
+func pendingEvents(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow("SELECT count(*) FROM user_events WHERE dispatched_at IS NULL").Scan(&n); err != nil {
+		t.Fatalf("Failed to count pending events: %v", err)
+	}
+	return n
+}
+
+// TestPublisher tests that user writes reach Kafka exactly once, in order
+func TestPublisher(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	brokers := testhelpers.StartKafka(ctx, t)
+	topic := createTopic(t, brokers)
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := repository.NewUserRepository(db)
+	defer repo.Close()
+	publisher := outbox.NewPublisher(db, newWriter(t, brokers, topic))
+
+	user, err := repo.Create(ctx, "outbox@example.com", "Outbox User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Run("Created Event", func(t *testing.T) {
+		n, err := publisher.PublishPending(ctx)
+		if err != nil {
+			t.Fatalf("Failed to publish: %v", err)
+		}
+		if n != 1 {
+			t.Fatalf("Expected 1 event published, got: %d", n)
+		}
+
+		msgs := readTopic(ctx, t, brokers, topic)
+		if len(msgs) != 1 {
+			t.Fatalf("Expected exactly one message, got: %d", len(msgs))
+		}
+		if string(msgs[0].Key) != strconv.Itoa(user.ID) {
+			t.Errorf("Expected key %d, got: %s", user.ID, msgs[0].Key)
+		}
+
+		event := decodeEvent(t, msgs[0])
+		if event.Type != models.EventUserCreated || event.UserID != user.ID || event.ID == 0 {
+			t.Errorf("Expected a user.created event for user %d, got: %+v", user.ID, event)
+		}
+		got := event.User
+		if got.ID != user.ID || got.UUID != user.UUID || got.Email != user.Email ||
+			got.Name != user.Name || got.Role != user.Role || !got.CreatedAt.Equal(user.CreatedAt) {
+			t.Errorf("Expected payload %+v, got: %+v", *user, got)
+		}
+	})
+
+	t.Run("Updated And Deleted Events", func(t *testing.T) {
+		if err := repo.Update(ctx, user.ID, user.Email, "Renamed Outbox User"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if n, err := publisher.PublishPending(ctx); err != nil || n != 2 {
+			t.Fatalf("Expected 2 events published, got %d and: %v", n, err)
+		}
+
+		msgs := readTopic(ctx, t, brokers, topic)
+		if len(msgs) != 3 {
+			t.Fatalf("Expected 3 messages, got: %d", len(msgs))
+		}
+		updated, deleted := decodeEvent(t, msgs[1]), decodeEvent(t, msgs[2])
+		if updated.Type != models.EventUserUpdated || updated.User.Name != "Renamed Outbox User" {
+			t.Errorf("Expected user.updated with the new name, got: %+v", updated)
+		}
+		if deleted.Type != models.EventUserDeleted || deleted.User.ID != user.ID {
+			t.Errorf("Expected user.deleted with the last state, got: %+v", deleted)
+		}
+	})
+
+	t.Run("Nothing Pending", func(t *testing.T) {
+		if n, err := publisher.PublishPending(ctx); err != nil || n != 0 {
+			t.Errorf("Expected nothing to publish, got %d and: %v", n, err)
+		}
+	})
+}
+
+// failingWriter is a Kafka outage: every write fails before anything is sent
+type failingWriter struct{ calls int }
+
+func (w *failingWriter) WriteMessages(context.Context, ...kafka.Message) error {
+	w.calls++
+	return errors.New("kafka: broker not available")
+}
+
+// TestPublisherCrashRecovery tests that unpublished events are retried and
+// published ones are never sent again
+func TestPublisherCrashRecovery(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	brokers := testhelpers.StartKafka(ctx, t)
+	topic := createTopic(t, brokers)
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := repository.NewUserRepository(db)
+	defer repo.Close()
+
+	for i := 1; i <= 5; i++ {
+		if _, err := repo.Create(ctx, fmt.Sprintf("recover%d@example.com", i), fmt.Sprintf("Recover %d", i)); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+
+	t.Run("Failed Publish Stays Pending", func(t *testing.T) {
+		down := &failingWriter{}
+		if _, err := outbox.NewPublisher(db, down).PublishPending(ctx); err == nil {
+			t.Fatal("Expected the publish to fail")
+		}
+		if down.calls != 1 {
+			t.Errorf("Expected one write attempt, got: %d", down.calls)
+		}
+		if got := pendingEvents(t, db); got != 5 {
+			t.Errorf("Expected all 5 events still pending, got: %d", got)
+		}
+	})
+
+	t.Run("Retried After Restart", func(t *testing.T) {
+		// A new publisher, as after a restart, drains the outbox in batches of 2
+		publisher := outbox.NewPublisher(db, newWriter(t, brokers, topic), outbox.WithBatchSize(2), outbox.WithPollInterval(50*time.Millisecond))
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+		go func() { done <- publisher.Run(runCtx) }()
+
+		deadline := time.Now().Add(10 * time.Second)
+		for pendingEvents(t, db) > 0 && time.Now().Before(deadline) {
+			time.Sleep(50 * time.Millisecond)
+		}
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Expected Run to return nil on cancel, got: %v", err)
+		}
+		if got := pendingEvents(t, db); got != 0 {
+			t.Fatalf("Expected the outbox to be drained, got %d pending", got)
+		}
+	})
+
+	t.Run("Published Once", func(t *testing.T) {
+		// A second run finds nothing to send
+		if n, err := outbox.NewPublisher(db, newWriter(t, brokers, topic)).PublishPending(ctx); err != nil || n != 0 {
+			t.Fatalf("Expected nothing to republish, got %d and: %v", n, err)
+		}
+
+		msgs := readTopic(ctx, t, brokers, topic)
+		if len(msgs) != 5 {
+			t.Fatalf("Expected exactly 5 messages, got: %d", len(msgs))
+		}
+		seen := map[int64]bool{}
+		for i, msg := range msgs {
+			event := decodeEvent(t, msg)
+			if seen[event.ID] {
+				t.Errorf("Event %d published twice", event.ID)
+			}
+			seen[event.ID] = true
+			if want := fmt.Sprintf("recover%d@example.com", i+1); event.User.Email != want {
+				t.Errorf("Expected message %d for %s, got: %s", i, want, event.User.Email)
+			}
+		}
+	})
+}
+
+// TestMessage tests the Kafka encoding of an event
+func TestMessage(t *testing.T) {
+	event := models.UserEvent{
+		ID:         7,
+		Type:       models.EventUserUpdated,
+		UserID:     42,
+		User:       models.User{ID: 42, Email: "encoded@example.com", Name: "Encoded", Role: models.RoleMember},
+		OccurredAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+
+	msg, err := outbox.Message(event)
+	if err != nil {
+		t.Fatalf("Failed to encode: %v", err)
+	}
+	if string(msg.Key) != "42" {
+		t.Errorf("Expected key 42, got: %s", msg.Key)
+	}
+	if len(msg.Headers) != 1 || msg.Headers[0].Key != "event_type" || string(msg.Headers[0].Value) != "user.updated" {
+		t.Errorf("Expected an event_type header, got: %+v", msg.Headers)
+	}
+	if !msg.Time.Equal(event.OccurredAt) {
+		t.Errorf("Expected message time %s, got: %s", event.OccurredAt, msg.Time)
+	}
+
+	got := decodeEvent(t, msg)
+	if got.ID != event.ID || got.Type != event.Type || got.User != event.User || !got.OccurredAt.Equal(event.OccurredAt) {
+		t.Errorf("Expected %+v after a round trip, got: %+v", event, got)
+	}
+}
@@ -0,0 +1,124 @@
+// Package pagination is the page shape shared by every list-style method,
+// from the repositories through the gRPC and HTTP layers. A list returns a
+// Page of at most the limit asked for, with an opaque cursor for the page
+// after it; passing the cursor back continues the list where it stopped,
+// by keyset, so rows written meanwhile neither shift nor repeat a page.
+//
+// Cursors are not signed. Decode rejects any cursor not in the form Encode
+// writes, but an edited one that still is just names another position,
+// which is no more than the client could ask for by walking the pages.
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Page is one page of a list
+type Page[T any] struct {
+	Items []T `json:"items"`
+	// TotalCount is how many items the whole list has, across every page.
+	// It is counted separately from the page, so a concurrent write can
+	// make the two disagree by that write.
+	TotalCount int64 `json:"total_count"`
+	// NextCursor continues the list after Items; empty on the last page
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// ErrInvalidCursor is returned, wrapped, for a cursor that isn't one a list
+// handed out: garbage, altered, or from a list sorted differently
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor is the position after the last item of a page: the item's ID, and
+// its sort key when the list is sorted by something before the ID
+type Cursor struct {
+	ID  int
+	Key string // empty for lists sorted by ID alone
+}
+
+// Encode returns c as an opaque cursor string: base64 of the ID, followed
+// by a colon and the sort key if there is one
+func (c Cursor) Encode() string {
+	s := strconv.Itoa(c.ID)
+	if c.Key != "" {
+		s += ":" + c.Key
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(s))
+}
+
+// Decode parses a cursor from Encode. The empty string is the start of the
+// list, the zero Cursor. Anything else Encode can't have produced, such as
+// an ID that isn't a positive integer written plainly, returns an error
+// wrapping ErrInvalidCursor.
+func Decode(s string) (Cursor, error) {
+	if s == "" {
+		return Cursor{}, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: not base64", ErrInvalidCursor)
+	}
+	idPart, key, hasKey := strings.Cut(string(raw), ":")
+	id, err := strconv.Atoi(idPart)
+	if err != nil || id <= 0 || strconv.Itoa(id) != idPart {
+		return Cursor{}, fmt.Errorf("%w: bad id %q", ErrInvalidCursor, idPart)
+	}
+	if hasKey && key == "" {
+		return Cursor{}, fmt.Errorf("%w: empty sort key", ErrInvalidCursor)
+	}
+	return Cursor{ID: id, Key: key}, nil
+}
+
+// DecodeID decodes a cursor of a list sorted by ID alone, returning the ID
+// to continue after, or 0 for the empty cursor
+func DecodeID(s string) (int, error) {
+	c, err := Decode(s)
+	if err != nil {
+		return 0, err
+	}
+	if c.Key != "" {
+		return 0, fmt.Errorf("%w: unexpected sort key", ErrInvalidCursor)
+	}
+	return c.ID, nil
+}
+
+// New returns the page of items for limit. The list's query fetches one
+// item more than limit, so New can tell whether another page follows
+// without counting; it drops that item and points NextCursor at the last
+// one it keeps, using cursor. A limit of 0 means no limit, one page of
+// every item.
+func New[T any](items []T, limit int, total int64, cursor func(T) Cursor) Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	page := Page[T]{Items: items, TotalCount: total}
+	if limit > 0 && len(items) > limit {
+		page.Items = items[:limit]
+		page.HasMore = true
+		page.NextCursor = cursor(page.Items[limit-1]).Encode()
+	}
+	return page
+}
+
+// FetchLimit is the number of items a list's query should fetch for a page
+// of limit items, for New; 0, no limit, stays 0
+func FetchLimit(limit int) int {
+	if limit <= 0 {
+		return 0
+	}
+	return limit + 1
+}
+
+// Map converts the items of p with f, keeping its position, e.g. to
+// response types
+func Map[T, U any](p Page[T], f func(T) U) Page[U] {
+	items := make([]U, len(p.Items))
+	for i, item := range p.Items {
+		items[i] = f(item)
+	}
+	return Page[U]{Items: items, TotalCount: p.TotalCount, NextCursor: p.NextCursor, HasMore: p.HasMore}
+}
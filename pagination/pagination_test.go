@@ -0,0 +1,112 @@
+package pagination
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// TestCursorRoundTrip tests that Decode reads back what Encode wrote
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []Cursor{
+		{ID: 1},
+		{ID: 42, Key: "2024-06-30T18:14:59.123456Z"},
+		{ID: 7, Key: "a:b"}, // keys may hold the separator
+	} {
+		got, err := Decode(c.Encode())
+		if err != nil {
+			t.Fatalf("Failed to decode %+v: %v", c, err)
+		}
+		if got != c {
+			t.Errorf("Expected %+v, got: %+v", c, got)
+		}
+	}
+
+	if got, err := Decode(""); err != nil || got != (Cursor{}) {
+		t.Errorf("Expected the empty cursor to start the list, got: %+v, %v", got, err)
+	}
+}
+
+// TestInvalidCursors tests that garbage and tampered cursors return
+// ErrInvalidCursor
+func TestInvalidCursors(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+	valid := Cursor{ID: 42}.Encode()
+
+	for name, cursor := range map[string]string{
+		"Garbage":        "!!not a cursor!!",
+		"Padded Base64":  base64.URLEncoding.EncodeToString([]byte("42")),
+		"Not A Number":   encode("abc"),
+		"Zero ID":        encode("0"),
+		"Negative ID":    encode("-5"),
+		"Signed ID":      encode("+5"),
+		"Leading Zeros":  encode("042"),
+		"Overflowing ID": encode("99999999999999999999"),
+		"Empty ID":       encode(":2024-01-01T00:00:00Z"),
+		"Empty Sort Key": encode("42:"),
+		"Spaces":         encode(" 42"),
+		"Appended Bytes": valid + "%",
+	} {
+		t.Run(name, func(t *testing.T) {
+			if c, err := Decode(cursor); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("Expected ErrInvalidCursor, got: %+v, %v", c, err)
+			}
+		})
+	}
+
+	t.Run("Sort Key On ID Cursor", func(t *testing.T) {
+		if _, err := DecodeID(Cursor{ID: 42, Key: "x"}.Encode()); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("Expected ErrInvalidCursor, got: %v", err)
+		}
+		if id, err := DecodeID(valid); err != nil || id != 42 {
+			t.Errorf("Expected ID 42, got: %d, %v", id, err)
+		}
+	})
+}
+
+// TestNew tests trimming the extra item and the cursor it leaves
+func TestNew(t *testing.T) {
+	byID := func(id int) Cursor { return Cursor{ID: id} }
+
+	t.Run("More To Come", func(t *testing.T) {
+		page := New([]int{1, 2, 3}, 2, 10, byID)
+		if len(page.Items) != 2 || !page.HasMore || page.TotalCount != 10 {
+			t.Fatalf("Expected 2 items and more, got: %+v", page)
+		}
+		if id, err := DecodeID(page.NextCursor); err != nil || id != 2 {
+			t.Errorf("Expected a cursor after 2, got: %d, %v", id, err)
+		}
+	})
+
+	t.Run("Last Page", func(t *testing.T) {
+		page := New([]int{1, 2}, 2, 2, byID)
+		if len(page.Items) != 2 || page.HasMore || page.NextCursor != "" {
+			t.Errorf("Expected the last page, got: %+v", page)
+		}
+	})
+
+	t.Run("No Limit", func(t *testing.T) {
+		page := New([]int{1, 2, 3}, 0, 3, byID)
+		if len(page.Items) != 3 || page.HasMore {
+			t.Errorf("Expected every item, got: %+v", page)
+		}
+		if FetchLimit(0) != 0 || FetchLimit(5) != 6 {
+			t.Errorf("Expected fetch limits 0 and 6, got: %d and %d", FetchLimit(0), FetchLimit(5))
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if page := New[int](nil, 5, 0, byID); page.Items == nil || page.HasMore {
+			t.Errorf("Expected an empty, non-nil page, got: %#v", page)
+		}
+	})
+
+	t.Run("Map", func(t *testing.T) {
+		page := Map(New([]int{1, 2, 3}, 2, 3, byID), func(i int) string { return string(rune('a' + i)) })
+		if len(page.Items) != 2 || page.Items[1] != "c" || !page.HasMore || page.NextCursor == "" {
+			t.Errorf("Expected mapped items with the position kept, got: %+v", page)
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"testcontainers-demo/models"
+
+	"github.com/lib/pq"
+)
+
+// archivedColumns are the users columns archived_users keeps
+const archivedColumns = "id, uuid, tenant_id, email, name, role, password_hash, avatar_key, version, created_at, updated_at, deleted_at, erased_at"
+
+// The statements of one ArchiveInactiveUsers batch, scoped to the tenant in
+// their last argument. The batch's rows are locked first, so the copy and
+// the delete see the same rows, and rows locked by another archiver are
+// left to it.
+const (
+	selectInactiveUsers = `
+		SELECT id FROM users u WHERE created_at < $1 AND tenant_id = $3
+			AND NOT EXISTS (SELECT 1 FROM orders o WHERE o.user_id = u.id)
+		ORDER BY id LIMIT $2
+		FOR UPDATE SKIP LOCKED
+	`
+
+	// An ID archived before, e.g. re-imported since, keeps only its latest row
+	copyToArchive = `
+		INSERT INTO archived_users (` + archivedColumns + `)
+		SELECT ` + archivedColumns + ` FROM users WHERE id = ANY($1) AND tenant_id = $2
+		ON CONFLICT (id) DO UPDATE SET
+			uuid = EXCLUDED.uuid, tenant_id = EXCLUDED.tenant_id, email = EXCLUDED.email, name = EXCLUDED.name,
+			role = EXCLUDED.role, password_hash = EXCLUDED.password_hash,
+			avatar_key = EXCLUDED.avatar_key, version = EXCLUDED.version,
+			created_at = EXCLUDED.created_at, updated_at = EXCLUDED.updated_at,
+			deleted_at = EXCLUDED.deleted_at, erased_at = EXCLUDED.erased_at,
+			archived_at = CURRENT_TIMESTAMP
+	`
+)
+
+// deleteArchivedUsers deletes a batch copied to archived_users, with a
+// user.deleted event for each, as for DeleteCached
+var deleteArchivedUsers = `
+	WITH u AS (
+		DELETE FROM users WHERE id = ANY($1) AND tenant_id = $2
+		RETURNING ` + userColumns + `
+	), e AS (` + insertUserEvent(models.EventUserDeleted) + `)
+	SELECT id, email FROM u
+`
+
+// ArchiveInactiveUsers moves the tenant's users created before olderThan
+// into the archived_users table, batchSize at a time, and returns how many it moved.
+// A user with orders is active however old, and stays.
+//
+// Each batch copies its users and deletes them in one transaction, with a
+// user.deleted event each; their cache entries are invalidated once it
+// commits. A failure stops the job with the batches before it committed, so
+// running it again carries on where it stopped without archiving anyone
+// twice. Invalidation failures don't stop it: they are returned, joined,
+// along with the full count.
+func (r *CachedUserRepository) ArchiveInactiveUsers(ctx context.Context, olderThan time.Time, batchSize int) (_ int, err error) {
+	const op = "CachedUserRepository.ArchiveInactiveUsers"
+	olderThan = olderThan.UTC()
+	key := fmt.Sprintf("olderThan=%s batchSize=%d", olderThan.Format(time.RFC3339), batchSize)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectInactiveUsers}, olderThan, batchSize)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if batchSize <= 0 {
+		return 0, newRepoError(op, key, errors.New("batch size must be positive"))
+	}
+
+	moved := 0
+	var invalidateErrs []error
+	for {
+		archived, err := r.archiveBatch(ctx, olderThan, batchSize)
+		if err != nil {
+			return moved, newRepoError(op, key, err)
+		}
+		if len(archived) == 0 {
+			break
+		}
+		moved += len(archived)
+
+		ids := make([]int, len(archived))
+		keys := make([]string, 0, 2*len(archived))
+		for i, u := range archived {
+			ids[i] = u.ID
+			keys = append(keys, userCacheKey(r.tenant, u.ID), emailCacheKey(r.tenant, u.Email))
+		}
+		if err := r.evict(ctx, ids, keys...); err != nil {
+			invalidateErrs = append(invalidateErrs, fmt.Errorf("failed to invalidate cache: %w", err))
+		}
+		if len(archived) < batchSize {
+			break
+		}
+	}
+
+	if len(invalidateErrs) > 0 {
+		return moved, newRepoError(op, key, errors.Join(invalidateErrs...))
+	}
+	return moved, nil
+}
+
+// archiveBatch is UserRepository.archiveBatch reported to the hooks as
+// "db.ArchiveBatch"
+func (r *CachedUserRepository) archiveBatch(ctx context.Context, olderThan time.Time, batchSize int) (_ []models.User, err error) {
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "db.ArchiveBatch", Statement: deleteArchivedUsers}, olderThan, batchSize)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	return r.users.archiveBatch(ctx, olderThan, batchSize)
+}
+
+// archiveBatch moves up to batchSize users created before olderThan into
+// archived_users in one transaction, and returns the IDs and emails of
+// those it moved
+func (r *UserRepository) archiveBatch(ctx context.Context, olderThan time.Time, batchSize int) (_ []models.User, err error) {
+	tx, err := beginTx(ctx, r.pool)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx, &err)
+
+	ids, err := queryIDs(ctx, tx, selectInactiveUsers, olderThan, batchSize, r.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select inactive users: %w", err)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if _, err := tx.ExecContext(ctx, copyToArchive, pq.Array(ids), r.tenant); err != nil {
+		return nil, fmt.Errorf("failed to copy users to the archive: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, deleteArchivedUsers, pq.Array(ids), r.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete archived users: %w", err)
+	}
+	defer closeRows(rows, &err)
+	archived := make([]models.User, 0, len(ids))
+	for rows.Next() {
+		var u models.User
+		if err := rows.Scan(&u.ID, &u.Email); err != nil {
+			return nil, fmt.Errorf("failed to scan archived user: %w", err)
+		}
+		archived = append(archived, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating archived users: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return archived, nil
+}
+
+// queryIDs runs query in tx and returns the IDs it selects
+func queryIDs(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (_ []int, err error) {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer closeRows(rows, &err)
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/lib/pq"
+)
+
+// batchHook counts archive batches, cancelling the context of batch cancelAt
+type batchHook struct {
+	NopHook
+	batches  int
+	cancelAt int // 0 never cancels
+}
+
+func (h *batchHook) Before(ctx context.Context, op Op, _ []interface{}) context.Context {
+	if op.Name != "db.ArchiveBatch" {
+		return ctx
+	}
+	h.batches++
+	if h.batches == h.cancelAt {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		return ctx
+	}
+	return ctx
+}
+
+// TestArchiveInactiveUsers tests that old users move to archived_users in
+// batches, leave the cache, and that a run stopped midway can be resumed
+func TestArchiveInactiveUsers(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// A database of its own, so the tables' counts are this test's
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	hook := &batchHook{}
+	cachedRepo := NewCachedUserRepository(db, redisClient, WithCachedHooks(hook))
+	repo := NewUserRepository(db)
+
+	cutoff := time.Now().AddDate(-1, 0, 0)
+	// seedOld seeds n users created before cutoff and returns their IDs
+	seedOld := func(t *testing.T, n int) []int {
+		t.Helper()
+		ids := make([]int, n)
+		for i := range ids {
+			ids[i] = fixtures.SeedUsers(t, db, fixtures.NewUser().WithCreatedAt(cutoff.AddDate(0, 0, -1-i)))[0].ID
+		}
+		return ids
+	}
+	// count returns how many of ids are in table
+	count := func(t *testing.T, table string, ids []int) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE id = ANY($1)", pq.Array(ids)).Scan(&n); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		return n
+	}
+
+	t.Run("Moves Old Users In Batches", func(t *testing.T) {
+		old := seedOld(t, 5)
+		recent := fixtures.SeedUsers(t, db, fixtures.NewUser().WithCreatedAt(cutoff.Add(time.Hour)))[0]
+		if _, err := cachedRepo.GetByIDCached(ctx, old[0]); err != nil {
+			t.Fatalf("Failed to cache user: %v", err)
+		}
+
+		hook.batches = 0
+		moved, err := cachedRepo.ArchiveInactiveUsers(ctx, cutoff, 2)
+		if err != nil {
+			t.Fatalf("Failed to archive users: %v", err)
+		}
+		if moved != 5 || hook.batches != 3 {
+			t.Errorf("Expected 5 users moved in 3 batches, got %d in %d", moved, hook.batches)
+		}
+		if n := count(t, "users", old); n != 0 {
+			t.Errorf("Expected no old users left, got: %d", n)
+		}
+		if n := count(t, "archived_users", old); n != 5 {
+			t.Errorf("Expected 5 archived users, got: %d", n)
+		}
+		if n := count(t, "users", []int{recent.ID}); n != 1 {
+			t.Errorf("Expected the recent user kept")
+		}
+
+		if _, err := repo.GetByID(ctx, old[1]); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound for an archived user, got: %v", err)
+		}
+		// Cached before archiving, so only found missing if invalidated
+		if _, err := cachedRepo.GetByIDCached(ctx, old[0]); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound for an archived user, got: %v", err)
+		}
+
+		var events int
+		if err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM user_events WHERE event_type = $1 AND user_id = ANY($2)",
+			models.EventUserDeleted, pq.Array(old),
+		).Scan(&events); err != nil || events != 5 {
+			t.Errorf("Expected 5 user.deleted events, got: %d, %v", events, err)
+		}
+
+		if moved, err := cachedRepo.ArchiveInactiveUsers(ctx, cutoff, 2); err != nil || moved != 0 {
+			t.Errorf("Expected nothing left to archive, got: %d, %v", moved, err)
+		}
+	})
+
+	t.Run("Resumes After A Failed Batch", func(t *testing.T) {
+		old := seedOld(t, 4)
+
+		hook.batches, hook.cancelAt = 0, 2
+		moved, err := cachedRepo.ArchiveInactiveUsers(ctx, cutoff, 2)
+		hook.cancelAt = 0
+		if !errors.Is(err, context.Canceled) || moved != 2 {
+			t.Fatalf("Expected the second batch cancelled after 2 users, got: %d, %v", moved, err)
+		}
+		if n := count(t, "archived_users", old); n != 2 {
+			t.Errorf("Expected only the first batch archived, got: %d", n)
+		}
+
+		moved, err = cachedRepo.ArchiveInactiveUsers(ctx, cutoff, 2)
+		if err != nil || moved != 2 {
+			t.Fatalf("Expected the remaining 2 users archived, got: %d, %v", moved, err)
+		}
+		if users, archived := count(t, "users", old), count(t, "archived_users", old); users != 0 || archived != 4 {
+			t.Errorf("Expected all 4 users archived once, got %d left and %d archived", users, archived)
+		}
+	})
+
+	t.Run("Rejects Empty Batches", func(t *testing.T) {
+		if _, err := cachedRepo.ArchiveInactiveUsers(ctx, cutoff, 0); err == nil {
+			t.Error("Expected an error for a batch size of 0")
+		}
+	})
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"testcontainers-demo/models"
+)
+
+// updateAvatarKey sets or clears avatar_key, recording a user.updated event;
+// RowsAffected counts the event
+var updateAvatarKey = "WITH u AS (UPDATE users SET avatar_key = $1 WHERE id = $2 AND tenant_id = $3 " +
+	"RETURNING " + userColumns + ") " + insertUserEvent(models.EventUserUpdated)
+
+// SetAvatarKey records the object key of user id's avatar; an empty key
+// clears it. Entries cached by CachedUserRepository keep the old key until
+// they expire or are invalidated.
+func (r *UserRepository) SetAvatarKey(ctx context.Context, id int, avatarKey string) (err error) {
+	const op = "UserRepository.SetAvatarKey"
+	key := fmt.Sprintf("id=%d", id)
+	o := &Op{Name: op, Statement: updateAvatarKey, UserID: id}
+	ctx, finish := observe(ctx, r.hooks, o, id, avatarKey)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	var rowsAffected int64
+	err = r.withRetry(ctx, func() error {
+		result, err := r.db.ExecContext(ctx, updateAvatarKey, nullString(avatarKey), id, r.tenant)
+		if err != nil {
+			return err
+		}
+		rowsAffected, err = result.RowsAffected()
+		return err
+	})
+	if err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to set avatar key: %w", err))
+	}
+	o.Rows = rowsAffected
+	if rowsAffected == 0 {
+		return newRepoError(op, key, ErrUserNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestSetAvatarKey tests setting and clearing the avatar key read back by the lookups
+func TestSetAvatarKey(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	user := newUser(t)
+
+	t.Run("Set", func(t *testing.T) {
+		if err := repo.SetAvatarKey(ctx, user.ID, "avatars/1"); err != nil {
+			t.Fatalf("Failed to set avatar key: %v", err)
+		}
+		got, err := repo.GetByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.AvatarKey != "avatars/1" {
+			t.Errorf("Expected avatar key avatars/1, got: %q", got.AvatarKey)
+		}
+	})
+
+	t.Run("Clear", func(t *testing.T) {
+		if err := repo.SetAvatarKey(ctx, user.ID, ""); err != nil {
+			t.Fatalf("Failed to clear avatar key: %v", err)
+		}
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.AvatarKey != "" {
+			t.Errorf("Expected no avatar key, got: %q", got.AvatarKey)
+		}
+	})
+
+	t.Run("User Not Found", func(t *testing.T) {
+		err := repo.SetAvatarKey(ctx, missingID, "avatars/missing")
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
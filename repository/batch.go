@@ -0,0 +1,263 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"testcontainers-demo/models"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// selectUsersByIDs is the query behind GetByIDsCached's cache misses; $2 is
+// the tenant
+const selectUsersByIDs = "SELECT " + userDetailColumns + " FROM users WHERE id = ANY($1) AND tenant_id = $2"
+
+// GetByIDsCached retrieves the users with ids, in the order of ids, leaving
+// out IDs with no user and repeats. Cached users are read with one
+// pipelined GET per key; the rest come from one query and are written back
+// to the cache in one pipeline, along with WithNegativeCaching entries for
+// the IDs that weren't found. A cache write that fails doesn't lose the
+// users read from Postgres: they are returned along with an error joining
+// every failed write.
+func (r *CachedUserRepository) GetByIDsCached(ctx context.Context, ids []int) (_ []models.User, err error) {
+	const op = "CachedUserRepository.GetByIDsCached"
+	key := fmt.Sprintf("ids=%d", len(ids))
+	outer := &Op{Name: op, Statement: selectUsersByIDs}
+	ctx, finish := observe(ctx, r.hooks, outer, len(ids))
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	ids = uniqueIDs(ids)
+	found := r.lookupMany(ctx, ids)
+
+	var missing []int
+	for _, id := range ids {
+		if _, ok := found[id]; !ok {
+			missing = append(missing, id)
+		}
+	}
+	outer.Cache = CacheHit
+	var backfillErr error
+	if len(missing) > 0 {
+		outer.Cache = CacheMiss
+		f := r.beginFill(ctx, missing...)
+		loaded, err := r.getManyFromDB(ctx, missing)
+		if err != nil {
+			return nil, newRepoError(op, key, err)
+		}
+		for i := range loaded {
+			found[loaded[i].ID] = &loaded[i]
+		}
+		_, backfillErr = r.storeMany(ctx, loaded, notFound(missing, loaded))
+		if errors.Is(backfillErr, ErrCircuitOpen) {
+			// Dropped, like every cache write while the breaker is open
+			backfillErr = nil
+		}
+		backfillErr = errors.Join(backfillErr, r.endFill(ctx, f))
+	}
+
+	users := make([]models.User, 0, len(found))
+	for _, id := range ids {
+		if user := found[id]; user != nil {
+			users = append(users, *user)
+		}
+	}
+	if backfillErr != nil {
+		return users, newRepoError(op, key, backfillErr)
+	}
+	return users, nil
+}
+
+// uniqueIDs returns ids without repeats, in order of first appearance
+func uniqueIDs(ids []int) []int {
+	seen := make(map[int]bool, len(ids))
+	unique := make([]int, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// notFound returns the IDs among ids that no user in users has
+func notFound(ids []int, users []models.User) []int {
+	loaded := make(map[int]bool, len(users))
+	for _, u := range users {
+		loaded[u.ID] = true
+	}
+	var missing []int
+	for _, id := range ids {
+		if !loaded[id] {
+			missing = append(missing, id)
+		}
+	}
+	return missing
+}
+
+// lookupMany returns the cached users among ids, reading them with one
+// pipelined GET per key, reported to the hooks as "cache.GetMany". Like
+// lookup, it treats Redis errors and undecodable entries as misses, and
+// maps an ID with a WithNegativeCaching entry to nil.
+func (r *CachedUserRepository) lookupMany(ctx context.Context, ids []int) map[int]*models.User {
+	found := make(map[int]*models.User, len(ids))
+	if len(ids) == 0 {
+		return found
+	}
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "cache.GetMany"}, len(ids))
+
+	cmds := make([]*redis.StringCmd, len(ids))
+	err := r.cacheDo(ctx, func(ctx context.Context) error {
+		pipe := r.cache.Pipeline()
+		for i, id := range ids {
+			cmds[i] = pipe.Get(ctx, userCacheKey(r.tenant, id))
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, errCommandAbandoned) {
+		finish(err)
+		return found
+	}
+
+	// Exec reports only the first failed command; each has its own result
+	var cmdErr error
+	for i, cmd := range cmds {
+		data, err := cmd.Bytes()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && cmdErr == nil {
+				cmdErr = err
+			}
+			continue
+		}
+		if string(data) == missingUserEntry {
+			found[ids[i]] = nil
+			continue
+		}
+		var user models.User
+		if err := json.Unmarshal(data, &user); err != nil {
+			continue
+		}
+		if user.Role == "" {
+			// Cached before users had roles
+			user.Role = models.RoleMember
+		}
+		found[ids[i]] = &user
+	}
+	finish(cmdErr)
+	return found
+}
+
+// getManyFromDB queries the users with ids, in no particular order
+func (r *CachedUserRepository) getManyFromDB(ctx context.Context, ids []int) ([]models.User, error) {
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "db.GetByIDs", Statement: selectUsersByIDs}, len(ids))
+	users, err := r.users.getByIDs(ctx, ids)
+	finish(err)
+	return users, err
+}
+
+// getByIDs returns the tenant's users with ids, in no particular order
+func (r *UserRepository) getByIDs(ctx context.Context, ids []int) (_ []models.User, err error) {
+	rows, err := r.db.QueryContext(ctx, selectUsersByIDs, pq.Array(ids), r.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get users: %w", err)
+	}
+	defer closeRows(rows, &err)
+
+	users := make([]models.User, 0, len(ids))
+	for rows.Next() {
+		user, err := scanUserDetail(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+	return users, nil
+}
+
+// storeMany caches users, and a WithNegativeCaching entry for each of
+// missing if enabled, with one pipelined SET per key, reported to the hooks
+// as "cache.SetMany". It returns the IDs whose SET failed or whose user
+// couldn't be encoded, and the errors of every one joined; ErrCircuitOpen if
+// the breaker skipped them all.
+func (r *CachedUserRepository) storeMany(ctx context.Context, users []models.User, missing []int) (_ []int, err error) {
+	if r.negativeTTL <= 0 {
+		missing = nil
+	}
+	if len(users)+len(missing) == 0 {
+		return nil, nil
+	}
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "cache.SetMany"}, len(users)+len(missing))
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	// Encoded up front, so a user that can't be fails alone
+	var failed []int
+	var errs []error
+	encoded := make([][]byte, len(users))
+	for i := range users {
+		if encoded[i], err = json.Marshal(users[i]); err != nil {
+			failed = append(failed, users[i].ID)
+			errs = append(errs, fmt.Errorf("failed to encode %s: %w", userCacheKey(r.tenant, users[i].ID), err))
+		}
+	}
+
+	ids := make([]int, 0, len(users)+len(missing))
+	cmds := make([]*redis.StatusCmd, 0, len(users)+len(missing))
+	err = r.cacheDo(ctx, func(ctx context.Context) error {
+		pipe := r.cache.Pipeline()
+		for i := range users {
+			if encoded[i] == nil {
+				continue
+			}
+			ids = append(ids, users[i].ID)
+			cmds = append(cmds, pipe.Set(ctx, userCacheKey(r.tenant, users[i].ID), encoded[i], r.ttl))
+		}
+		for _, id := range missing {
+			ids = append(ids, id)
+			cmds = append(cmds, pipe.Set(ctx, userCacheKey(r.tenant, id), missingUserEntry, r.negativeTTL))
+		}
+		if len(cmds) == 0 {
+			return nil
+		}
+		_, err := pipe.Exec(ctx)
+		return err
+	})
+	if err == nil {
+		return failed, errors.Join(errs...)
+	}
+	if errors.Is(err, ErrCircuitOpen) || errors.Is(err, errCommandAbandoned) {
+		// Skipped by the breaker, so nothing was written, or abandoned
+		// without knowing what was
+		skipped := make([]int, 0, len(users)+len(missing))
+		for _, u := range users {
+			skipped = append(skipped, u.ID)
+		}
+		return append(skipped, missing...), errors.Join(append(errs, err)...)
+	}
+
+	// Exec reports only the first failed SET; collect them all
+	for i, cmd := range cmds {
+		if cmd.Err() != nil {
+			failed = append(failed, ids[i])
+			errs = append(errs, fmt.Errorf("failed to cache %s: %w", userCacheKey(r.tenant, ids[i]), cmd.Err()))
+		}
+	}
+	if len(errs) == 0 {
+		return nil, err
+	}
+	return failed, errors.Join(errs...)
+}
+
+// InvalidateMany removes the users with ids from the cache.
+//
+// Deprecated: InvalidateCache takes any number of IDs.
+func (r *CachedUserRepository) InvalidateMany(ctx context.Context, ids ...int) error {
+	return r.InvalidateCache(ctx, ids...)
+}
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// roundTripCounter is a go-redis hook counting round trips to Redis: one
+// per command, or one per pipeline however many commands it holds. With
+// failSets set, pipelines of SETs fail without reaching Redis.
+type roundTripCounter struct {
+	mu         sync.Mutex
+	roundTrips int
+	commands   int
+	failSets   error
+}
+
+func (c *roundTripCounter) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *roundTripCounter) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.count(1)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *roundTripCounter) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.count(len(cmds))
+		if err := c.setsFail(); err != nil && cmds[0].Name() == "set" {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func (c *roundTripCounter) count(commands int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.roundTrips++
+	c.commands += commands
+}
+
+func (c *roundTripCounter) setsFail() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failSets
+}
+
+// reset zeroes the counts and returns the old ones
+func (c *roundTripCounter) reset() (roundTrips, commands int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	roundTrips, commands = c.roundTrips, c.commands
+	c.roundTrips, c.commands = 0, 0
+	return roundTrips, commands
+}
+
+// TestGetByIDsCached tests warming the cache with 500 users in a constant
+// number of round trips, reading them back, and invalidating them together
+func TestGetByIDsCached(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	counter := &roundTripCounter{}
+	redisClient.AddHook(counter)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+
+	const n = 500
+	builders := make([]*fixtures.UserBuilder, n)
+	for i := range builders {
+		builders[i] = fixtures.NewUser()
+	}
+	seeded := fixtures.SeedUsers(t, testDB, builders...)
+	ids := make([]int, 0, n+2)
+	for _, u := range seeded {
+		ids = append(ids, u.ID)
+	}
+	// A missing user and a repeat are left out of the result
+	ids = append(ids, missingID, seeded[0].ID)
+
+	// expectSeeded fails the test unless users are the seeded users, in order
+	expectSeeded := func(t *testing.T, users []models.User) {
+		t.Helper()
+		if len(users) != n {
+			t.Fatalf("Expected %d users, got: %d", n, len(users))
+		}
+		for i, s := range seeded {
+			if users[i].ID != s.ID || users[i].Email != s.Email || users[i].Name != s.Name {
+				t.Fatalf("Expected user %d to be %d %s, got: %d %s", i, s.ID, s.Email, users[i].ID, users[i].Email)
+			}
+		}
+	}
+
+	t.Run("Cold Cache", func(t *testing.T) {
+		counter.reset()
+		users, err := cachedRepo.GetByIDsCached(ctx, ids)
+		if err != nil {
+			t.Fatalf("Failed to get users: %v", err)
+		}
+		expectSeeded(t, users)
+
+		// One pipeline of GETs, one of SETs
+		if roundTrips, commands := counter.reset(); roundTrips != 2 || commands != 2*n+1 {
+			t.Errorf("Expected 2 round trips for %d commands, got %d for %d", 2*n+1, roundTrips, commands)
+		}
+
+		keys := make([]string, n)
+		for i, s := range seeded {
+			keys[i] = userCacheKey(DefaultTenant, s.ID)
+		}
+		if cached, err := redisClient.Exists(ctx, keys...).Result(); err != nil || cached != n {
+			t.Errorf("Expected all %d users cached, got: %d, %v", n, cached, err)
+		}
+	})
+
+	t.Run("Warm Cache", func(t *testing.T) {
+		counter.reset()
+		users, err := cachedRepo.GetByIDsCached(ctx, ids)
+		if err != nil {
+			t.Fatalf("Failed to get users: %v", err)
+		}
+		expectSeeded(t, users)
+		// The missing user is looked up in Postgres again, but not cached
+		if roundTrips, _ := counter.reset(); roundTrips != 1 {
+			t.Errorf("Expected 1 round trip on a warm cache, got: %d", roundTrips)
+		}
+	})
+
+	t.Run("Invalidate Many", func(t *testing.T) {
+		counter.reset()
+		if err := cachedRepo.InvalidateMany(ctx, ids...); err != nil {
+			t.Fatalf("Failed to invalidate users: %v", err)
+		}
+		if roundTrips, _ := counter.reset(); roundTrips != 1 {
+			t.Errorf("Expected 1 round trip, got: %d", roundTrips)
+		}
+		for _, s := range seeded {
+			if n, _ := redisClient.Exists(ctx, userCacheKey(DefaultTenant, s.ID)).Result(); n != 0 {
+				t.Fatalf("Expected user %d invalidated", s.ID)
+			}
+		}
+	})
+
+	t.Run("Backfill Failure Keeps Postgres Results", func(t *testing.T) {
+		errInjected := errors.New("injected SET failure")
+		counter.mu.Lock()
+		counter.failSets = errInjected
+		counter.mu.Unlock()
+		t.Cleanup(func() {
+			counter.mu.Lock()
+			counter.failSets = nil
+			counter.mu.Unlock()
+		})
+
+		users, err := cachedRepo.GetByIDsCached(ctx, ids)
+		if !errors.Is(err, errInjected) {
+			t.Errorf("Expected the SET failures reported, got: %v", err)
+		}
+		expectSeeded(t, users)
+	})
+}
+
+// TestInvalidateAll tests that InvalidateAll removes every user and email
+// entry across many SCAN pages, with SCAN rather than KEYS, and leaves other
+// keys alone
+func TestInvalidateAll(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	commands := &commandRecorder{}
+	redisClient.AddHook(commands)
+	cachedRepo := NewCachedUserRepository(db, redisClient)
+
+	rows, err := db.QueryContext(ctx, `
+		INSERT INTO users (email, name)
+		SELECT 'bulk-' || i || '@invalidate.test', 'Bulk ' || i FROM generate_series(1, 1000) i
+		RETURNING id`)
+	if err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("Failed to scan id: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Failed to read ids: %v", err)
+	}
+	if err := cachedRepo.WarmCache(ctx, ids); err != nil {
+		t.Fatalf("Failed to cache users: %v", err)
+	}
+	for _, email := range []string{"bulk-1@invalidate.test", "bulk-2@invalidate.test"} {
+		if _, err := cachedRepo.IsEmailAvailableCached(ctx, email); err != nil {
+			t.Fatalf("Failed to index email: %v", err)
+		}
+	}
+	// Neither starts with the repository's prefix
+	sentinels := []string{"sentinel", "unrelated:user:1"}
+	for _, key := range sentinels {
+		if err := redisClient.Set(ctx, key, "keep", 0).Err(); err != nil {
+			t.Fatalf("Failed to set %s: %v", key, err)
+		}
+	}
+
+	commands.take()
+	removed, err := cachedRepo.InvalidateAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to invalidate cache: %v", err)
+	}
+	names := commands.take()
+	if removed != len(ids)+2 {
+		t.Errorf("Expected %d keys removed, got: %d", len(ids)+2, removed)
+	}
+
+	if left, err := redisClient.Keys(ctx, tenantKeyPrefix(DefaultTenant)+"*").Result(); err != nil || len(left) != 0 {
+		t.Errorf("Expected no user keys left, got %d: %v", len(left), err)
+	}
+	for _, key := range sentinels {
+		if n, err := redisClient.Exists(ctx, key).Result(); err != nil || n != 1 {
+			t.Errorf("Expected %s kept, got: %d, %v", key, n, err)
+		}
+	}
+	scans := 0
+	for _, name := range names {
+		switch name {
+		case "scan":
+			scans++
+		case "del":
+		default:
+			t.Errorf("Expected only SCAN and DEL, got: %s", name)
+		}
+	}
+	if scans < 2 {
+		t.Errorf("Expected the keys scanned over several pages, got %d scans", scans)
+	}
+
+	t.Run("Empty Cache", func(t *testing.T) {
+		if removed, err := cachedRepo.InvalidateAll(ctx); err != nil || removed != 0 {
+			t.Errorf("Expected nothing to remove, got: %d, %v", removed, err)
+		}
+	})
+}
+
+// commandRecorder is a go-redis hook recording the name of every command sent
+type commandRecorder struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (c *commandRecorder) DialHook(next redis.DialHook) redis.DialHook { return next }
+
+func (c *commandRecorder) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		c.record(cmd)
+		return next(ctx, cmd)
+	}
+}
+
+func (c *commandRecorder) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		c.record(cmds...)
+		return next(ctx, cmds)
+	}
+}
+
+func (c *commandRecorder) record(cmds ...redis.Cmder) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cmd := range cmds {
+		c.names = append(c.names, cmd.Name())
+	}
+}
+
+// take returns the recorded names and starts over
+func (c *commandRecorder) take() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := c.names
+	c.names = nil
+	return names
+}
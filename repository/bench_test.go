@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// The benchmarks run against the container TestMain starts, like the tests,
+// and are skipped with them when Docker is unavailable:
+//
+//	go test ./repository -run '^$' -bench . -benchmem
+
+// listUsers is how many users BenchmarkList reads on each call
+const listUsers = 10000
+
+// BenchmarkGetByIDCached measures cache hits and misses, then logs how much
+// slower a miss is so a regression in either path stands out
+func BenchmarkGetByIDCached(b *testing.B) {
+	requirePostgres(b)
+	ctx := context.Background()
+	user := fixtures.SeedUsers(b, testDB, fixtures.NewUser())[0]
+	cachedRepo := NewCachedUserRepository(testDB, testhelpers.StartRedis(ctx, b))
+
+	// Each sub-benchmark runs several times with growing b.N; the last run counts
+	var hit, miss time.Duration
+
+	b.Run("Hit", func(b *testing.B) {
+		b.ReportAllocs()
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			b.Fatalf("Failed to populate cache: %v", err)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+				b.Fatalf("Failed to get user: %v", err)
+			}
+		}
+		hit = b.Elapsed() / time.Duration(b.N)
+	})
+
+	b.Run("Miss", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			// Only the lookup is timed, not emptying the cache for it
+			b.StopTimer()
+			if err := cachedRepo.InvalidateCache(ctx, user.ID); err != nil {
+				b.Fatalf("Failed to invalidate cache: %v", err)
+			}
+			b.StartTimer()
+
+			if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+				b.Fatalf("Failed to get user: %v", err)
+			}
+		}
+		miss = b.Elapsed() / time.Duration(b.N)
+	})
+
+	if hit > 0 && miss > 0 {
+		b.Logf("GetByIDCached: hit %s, miss %s, miss/hit ratio %.1fx", hit, miss, float64(miss)/float64(hit))
+	}
+}
+
+// BenchmarkList measures loading listUsers users at once
+func BenchmarkList(b *testing.B) {
+	requirePostgres(b)
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, b)
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO users (email, name)
+		SELECT 'list' || n || '@example.com', 'List User ' || n
+		FROM generate_series(1, $1) AS n`, listUsers)
+	if err != nil {
+		b.Fatalf("Failed to seed users: %v", err)
+	}
+	repo := NewUserRepository(db)
+	b.Cleanup(func() { repo.Close() })
+
+	b.Run("10k", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			users, err := repo.List(ctx)
+			if err != nil {
+				b.Fatalf("Failed to list users: %v", err)
+			}
+			if len(users) < listUsers {
+				b.Fatalf("Expected at least %d users, got: %d", listUsers, len(users))
+			}
+		}
+	})
+}
+
+// BenchmarkCreateBatch measures CreateBatch at several sizes, reporting the
+// cost per user alongside the cost per call
+func BenchmarkCreateBatch(b *testing.B) {
+	requirePostgres(b)
+	ctx := context.Background()
+	// The inserted rows stay until the benchmark ends, so keep them out of testDB
+	db := testContainer.CreateTestDatabase(ctx, b)
+	repo := NewUserRepository(db)
+	b.Cleanup(func() { repo.Close() })
+
+	var seq int
+	for _, size := range []int{10, 100, MaxBatchSize} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			b.ReportAllocs()
+			inputs := make([]CreateUserInput, size)
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := range inputs {
+					seq++
+					inputs[j] = CreateUserInput{Email: fmt.Sprintf("batch%d@example.com", seq), Name: "Batch User"}
+				}
+				b.StartTimer()
+
+				if _, err := repo.CreateBatch(ctx, inputs); err != nil {
+					b.Fatalf("Failed to create users: %v", err)
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*size), "ns/user")
+		})
+	}
+}
@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Defaults for the circuit breaker and per-command timeout around Redis
+const (
+	defaultCacheTimeout     = 50 * time.Millisecond
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+)
+
+// ErrCircuitOpen is returned for a Redis command the circuit breaker skipped
+// because Redis has been failing
+var ErrCircuitOpen = errors.New("cache circuit breaker is open")
+
+// BreakerState is the state of a CachedUserRepository's circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed sends every command to Redis
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen skips Redis until the cooldown has passed
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen lets a single command through to test Redis; its
+	// outcome closes or reopens the breaker
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// CacheStats is a snapshot of a CachedUserRepository's cache health
+type CacheStats struct {
+	Breaker             BreakerState
+	ConsecutiveFailures int
+	Opened              int64 // times the breaker has opened
+	Skipped             int64 // commands skipped while it was open
+}
+
+// WithCircuitBreaker stops sending commands to Redis after threshold
+// consecutive failures. For cooldown afterwards reads go straight to the
+// database and cache writes, invalidations included, are dropped; then one
+// command is let through, and the breaker closes if it succeeds or opens
+// again if it fails. A threshold of 0 or less disables the breaker. The
+// default is 5 failures and a 10 second cooldown.
+func WithCircuitBreaker(threshold int, cooldown time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.breaker.threshold = threshold
+		r.breaker.cooldown = cooldown
+	}
+}
+
+// breaker is a circuit breaker counting consecutive Redis failures
+type breaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probing  bool // a half-open trial command is in flight
+	opened   int64
+	skipped  int64
+}
+
+// newBreaker returns a closed breaker with the default threshold and cooldown
+func newBreaker() *breaker {
+	return &breaker{
+		threshold: defaultBreakerThreshold,
+		cooldown:  defaultBreakerCooldown,
+		now:       time.Now,
+		state:     BreakerClosed,
+	}
+}
+
+// allow reports whether a command may go to Redis. Every true must be
+// followed by done.
+func (b *breaker) allow() bool {
+	if b.threshold <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cooldown {
+			b.skipped++
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			b.skipped++
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// done records the outcome of an allowed command. A command that failed
+// only because its caller gave up says nothing about Redis, so pass
+// counted false for it.
+func (b *breaker) done(err error, counted bool) {
+	if b.threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	wasProbe := b.state == BreakerHalfOpen && b.probing
+	if wasProbe {
+		b.probing = false
+	}
+	if !counted {
+		return
+	}
+	if err == nil || errors.Is(err, redis.Nil) {
+		if b.state != BreakerOpen {
+			// A late success from before the breaker opened doesn't close it
+			b.state = BreakerClosed
+			b.failures = 0
+		}
+		return
+	}
+	b.failures++
+	if wasProbe || b.state == BreakerClosed && b.failures >= b.threshold {
+		b.state = BreakerOpen
+		b.openedAt = b.now()
+		b.opened++
+	}
+}
+
+// stats returns the breaker's part of a CacheStats
+func (b *breaker) stats() CacheStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.state
+	if state == BreakerOpen && b.now().Sub(b.openedAt) >= b.cooldown {
+		// The next command will be the trial
+		state = BreakerHalfOpen
+	}
+	return CacheStats{
+		Breaker:             state,
+		ConsecutiveFailures: b.failures,
+		Opened:              b.opened,
+		Skipped:             b.skipped,
+	}
+}
+
+// Stats returns a snapshot of the repository's cache health
+func (r *CachedUserRepository) Stats() CacheStats {
+	return r.breaker.stats()
+}
+
+// cacheDo runs fn, one Redis command, bounded by the WithCacheTimeout
+// timeout and guarded by the circuit breaker. It returns ErrCircuitOpen
+// without calling fn while the breaker is open. Once ctx is done the
+// command is abandoned, as untilDone describes, or not sent at all.
+func (r *CachedUserRepository) cacheDo(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("%w: %w", errCommandAbandoned, err)
+	}
+	if !r.breaker.allow() {
+		return ErrCircuitOpen
+	}
+	cmdCtx, cancel := r.cacheCtx(ctx)
+	err := untilDone(ctx, func() error { return fn(cmdCtx) })
+	cancel()
+	r.breaker.done(err, ctx.Err() == nil)
+	return err
+}
+
+// errCommandAbandoned is returned, wrapping the context's error, for a Redis
+// command given up on because its context was cancelled
+var errCommandAbandoned = errors.New("redis command abandoned")
+
+// untilDone runs fn and returns its error or, if ctx is done first, an
+// error wrapping errCommandAbandoned and ctx.Err(). go-redis only stops a
+// command at its context's deadline, so a cancellation alone would wait out
+// the client's read timeout against a Redis that has stopped answering, and
+// the deadline comes back as an i/o timeout, which contextErr wraps. An
+// abandoned fn runs on in the background until Redis or the timeout ends
+// it, so nothing it writes may be read after one.
+func untilDone(ctx context.Context, fn func() error) error {
+	if ctx.Done() == nil {
+		return fn()
+	}
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return contextErr(ctx, err)
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %w", errCommandAbandoned, ctx.Err())
+	}
+}
+
+// cacheWrite is cacheDo for a write the breaker may drop: a write skipped
+// while the breaker is open returns nil
+func (r *CachedUserRepository) cacheWrite(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := r.cacheDo(ctx, fn); !errors.Is(err, ErrCircuitOpen) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TestBreaker walks the circuit breaker through its states on a fake clock
+func TestBreaker(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	errRedis := errors.New("i/o timeout")
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	b := newBreaker()
+	b.threshold, b.cooldown = 3, 10*time.Second
+	b.now = func() time.Time { return now }
+
+	// run sends one command through the breaker, reporting whether it was allowed
+	run := func(err error) bool {
+		if !b.allow() {
+			return false
+		}
+		b.done(err, true)
+		return true
+	}
+	expectState := func(t *testing.T, want BreakerState) {
+		t.Helper()
+		if got := b.stats().Breaker; got != want {
+			t.Fatalf("Expected the breaker %s, got: %s", want, got)
+		}
+	}
+
+	t.Run("Success Resets Failures", func(t *testing.T) {
+		run(errRedis)
+		run(errRedis)
+		run(redis.Nil) // a miss is a healthy answer
+		if got := b.stats().ConsecutiveFailures; got != 0 {
+			t.Errorf("Expected no consecutive failures, got: %d", got)
+		}
+		expectState(t, BreakerClosed)
+	})
+
+	t.Run("Opens At Threshold", func(t *testing.T) {
+		for range 3 {
+			if !run(errRedis) {
+				t.Fatal("Expected commands through while closed")
+			}
+		}
+		expectState(t, BreakerOpen)
+		if run(nil) {
+			t.Error("Expected commands skipped while open")
+		}
+		if stats := b.stats(); stats.Opened != 1 || stats.Skipped != 1 {
+			t.Errorf("Expected 1 open and 1 skip, got: %+v", stats)
+		}
+	})
+
+	t.Run("Failed Trial Reopens", func(t *testing.T) {
+		now = now.Add(10 * time.Second)
+		expectState(t, BreakerHalfOpen)
+		if !b.allow() {
+			t.Fatal("Expected a trial command after the cooldown")
+		}
+		if b.allow() {
+			t.Error("Expected only one trial command at a time")
+		}
+		b.done(errRedis, true)
+		expectState(t, BreakerOpen)
+		if got := b.stats().Opened; got != 2 {
+			t.Errorf("Expected the breaker opened twice, got: %d", got)
+		}
+	})
+
+	t.Run("Abandoned Trial Is Retried", func(t *testing.T) {
+		now = now.Add(10 * time.Second)
+		if !b.allow() {
+			t.Fatal("Expected a trial command after the cooldown")
+		}
+		// The caller gave up, which says nothing about Redis
+		b.done(errRedis, false)
+		expectState(t, BreakerHalfOpen)
+		if !b.allow() {
+			t.Fatal("Expected another trial command")
+		}
+		b.done(nil, true)
+		expectState(t, BreakerClosed)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		off := newBreaker()
+		off.threshold = 0
+		for range 10 {
+			if !off.allow() {
+				t.Fatal("Expected a disabled breaker to allow every command")
+			}
+			off.done(errRedis, true)
+		}
+		if got := off.stats().Breaker; got != BreakerClosed {
+			t.Errorf("Expected a disabled breaker to stay closed, got: %s", got)
+		}
+	})
+}
@@ -0,0 +1,182 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync/atomic"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewCachedUserRepositoryFromURL creates a cached user repository with a
+// Redis client for dsn, which the repository owns: Close closes it. The
+// scheme picks the deployment:
+//
+//	redis://host:6379/0                                  a single server
+//	redis+cluster://host:7000?addr=host:7001             Redis Cluster, seeded from every address
+//	redis+sentinel://host:26379/0?master_name=mymaster   the master Sentinel names
+//
+// with rediss, rediss+cluster, and rediss+sentinel for TLS. Query parameters
+// are go-redis options, as for redis.ParseURL. Every client is created with
+// ContextTimeoutEnabled, so WithCacheTimeout applies.
+func NewCachedUserRepositoryFromURL(db *sql.DB, dsn string, opts ...CachedOption) (*CachedUserRepository, error) {
+	client, err := newRedisClient(dsn)
+	if err != nil {
+		return nil, err
+	}
+	r := NewCachedUserRepository(db, client, opts...)
+	r.closeCache = client.Close
+	return r, nil
+}
+
+// newRedisClient returns a client for dsn, a URL in one of the schemes
+// NewCachedUserRepositoryFromURL accepts
+func newRedisClient(dsn string) (redis.UniversalClient, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	scheme, mode, _ := strings.Cut(u.Scheme, "+")
+	if scheme != "redis" && scheme != "rediss" {
+		return nil, fmt.Errorf("invalid Redis URL scheme %q", u.Scheme)
+	}
+	u.Scheme = scheme
+	plain := u.String()
+
+	switch mode {
+	case "":
+		opts, err := redis.ParseURL(plain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: %w", err)
+		}
+		opts.ContextTimeoutEnabled = true
+		return redis.NewClient(opts), nil
+	case "cluster":
+		opts, err := redis.ParseClusterURL(plain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis Cluster URL: %w", err)
+		}
+		opts.ContextTimeoutEnabled = true
+		return redis.NewClusterClient(opts), nil
+	case "sentinel":
+		opts, err := redis.ParseFailoverURL(plain)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis Sentinel URL: %w", err)
+		}
+		if opts.MasterName == "" {
+			return nil, fmt.Errorf("invalid Redis Sentinel URL: master_name is required")
+		}
+		opts.ContextTimeoutEnabled = true
+		return redis.NewFailoverClient(opts), nil
+	default:
+		return nil, fmt.Errorf("invalid Redis URL scheme %q", u.Scheme)
+	}
+}
+
+// Close closes the Redis client if NewCachedUserRepositoryFromURL created
+// it; a client passed to NewCachedUserRepository is left to its owner
+func (r *CachedUserRepository) Close() error {
+	if r.closeCache == nil {
+		return nil
+	}
+	return r.closeCache()
+}
+
+// delKeys deletes keys in one DEL, or on Redis Cluster with one DEL per key
+// in a single pipeline: a multi-key DEL fails with CROSSSLOT there when the
+// keys hash to different slots, and a cluster client splits the pipeline by
+// node instead.
+func (r *CachedUserRepository) delKeys(ctx context.Context, keys ...string) error {
+	_, cluster := r.cache.(*redis.ClusterClient)
+	_, err := delCount(ctx, r.cache, cluster, keys)
+	return err
+}
+
+// delCount deletes keys from c as delKeys does, one DEL per key when split,
+// and returns how many existed
+func delCount(ctx context.Context, c redis.Cmdable, split bool, keys []string) (int64, error) {
+	if len(keys) == 1 || !split {
+		return c.Del(ctx, keys...).Result()
+	}
+	pipe := c.Pipeline()
+	dels := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		dels[i] = pipe.Del(ctx, key)
+	}
+	_, err := pipe.Exec(ctx)
+	var n int64
+	for _, del := range dels {
+		n += del.Val()
+	}
+	return n, err
+}
+
+// tenantKeyPrefix starts every key the repository writes for tenant:
+// userCacheKey and emailCacheKey
+func tenantKeyPrefix(tenant string) string {
+	return "user:" + tenant + ":"
+}
+
+// invalidateScanCount is the COUNT hint of each SCAN in InvalidateAll, and so
+// roughly how many keys each DEL removes
+const invalidateScanCount = 500
+
+// InvalidateAll removes every user and email entry of the repository's
+// tenant from the cache, e.g. after a bulk import, and returns how many keys
+// it removed. It walks the keyspace with SCAN MATCH, deleting each page as
+// it goes, so Redis is never blocked the way KEYS or FLUSHDB would block
+// it, and other tenants' entries and keys other code keeps in the same
+// database survive. On Redis Cluster every master is scanned. Entries
+// written while it runs may survive.
+func (r *CachedUserRepository) InvalidateAll(ctx context.Context) (_ int, err error) {
+	const op = "CachedUserRepository.InvalidateAll"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	prefix := tenantKeyPrefix(r.tenant)
+	requestCacheFrom(ctx).clear(prefix)
+	var removed atomic.Int64
+	if cluster, ok := r.cache.(*redis.ClusterClient); ok {
+		err = cluster.ForEachMaster(ctx, func(ctx context.Context, node *redis.Client) error {
+			return r.scanDelete(ctx, node, prefix, true, &removed)
+		})
+	} else {
+		err = r.scanDelete(ctx, r.cache, prefix, false, &removed)
+	}
+	if err != nil {
+		return int(removed.Load()), newRepoError(op, prefix+"*", err)
+	}
+	return int(removed.Load()), nil
+}
+
+// scanDelete deletes the keys starting with prefix from c a SCAN page at a
+// time, adding how many it removed to removed
+func (r *CachedUserRepository) scanDelete(ctx context.Context, c redis.Cmdable, prefix string, split bool, removed *atomic.Int64) error {
+	var cursor uint64
+	for {
+		var keys []string
+		err := r.cacheDo(ctx, func(ctx context.Context) (err error) {
+			keys, cursor, err = c.Scan(ctx, cursor, prefix+"*", invalidateScanCount).Result()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to scan keys: %w", err)
+		}
+		if len(keys) > 0 {
+			err = r.cacheDo(ctx, func(ctx context.Context) error {
+				n, err := delCount(ctx, c, split, keys)
+				removed.Add(n)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("failed to delete keys: %w", err)
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,364 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// cancelBound is how long any method may take to give up once its context
+// is done; every statement it could be waiting on would run for far longer
+const cancelBound = 2 * time.Second
+
+// sleepHook is a Hook that runs pg_sleep on db, under the operation's
+// context, before each repository method, so the method is mid-query when
+// a test cancels it. Only a method that hands its context down returns
+// early: one that ran its own statement on a fresh context would wait for
+// the sleep to be cancelled and then run it regardless.
+type sleepHook struct {
+	NopHook
+	db *sql.DB
+}
+
+func (h sleepHook) Before(ctx context.Context, op Op, _ []interface{}) context.Context {
+	if strings.Contains(op.Name, "Repository.") {
+		_, _ = h.db.ExecContext(ctx, "SELECT pg_sleep(30) /* cancellation test */")
+	}
+	return ctx
+}
+
+// nopMailer is a Mailer that sends nothing
+type nopMailer struct{}
+
+func (nopMailer) SendWelcome(context.Context, models.User) error { return nil }
+
+// cancelMode is one way for a context to end while a method uses it
+type cancelMode struct {
+	name string
+	want error
+	ctx  func() (context.Context, context.CancelFunc)
+}
+
+// cancelModes are the contexts each method is run under: cancelled before
+// the call, cancelled mid-query, and past a deadline mid-query
+var cancelModes = []cancelMode{
+	{"Already Cancelled", context.Canceled, func() (context.Context, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		return ctx, cancel
+	}},
+	{"Cancelled Mid-Query", context.Canceled, func() (context.Context, context.CancelFunc) {
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(100*time.Millisecond, cancel)
+		return ctx, cancel
+	}},
+	{"Deadline Shorter Than The Query", context.DeadlineExceeded, func() (context.Context, context.CancelFunc) {
+		return context.WithTimeout(context.Background(), 100*time.Millisecond)
+	}},
+}
+
+// checkCancelled runs call under mode's context and fails t unless it
+// returns mode's context error within cancelBound
+func checkCancelled(t *testing.T, mode cancelMode, call func(ctx context.Context) error) {
+	t.Helper()
+	ctx, cancel := mode.ctx()
+	defer cancel()
+
+	start := time.Now()
+	err := call(ctx)
+	elapsed := time.Since(start)
+	if !errors.Is(err, mode.want) {
+		t.Errorf("Expected %v, got: %v", mode.want, err)
+	}
+	if elapsed > cancelBound {
+		t.Errorf("Expected to give up within %v, took %v", cancelBound, elapsed)
+	}
+}
+
+// waitIdle fails t unless every session on db but the one asking is idle
+// within a few seconds; cancel requests reach the server asynchronously
+func waitIdle(t *testing.T, db *sql.DB) {
+	t.Helper()
+	ctx := context.Background()
+	var active []string
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		active = nil
+		rows, err := db.QueryContext(ctx, `
+			SELECT query FROM pg_stat_activity
+			WHERE datname = current_database() AND backend_type = 'client backend'
+				AND state = 'active' AND pid <> pg_backend_pid()`)
+		if err != nil {
+			t.Fatalf("Failed to query pg_stat_activity: %v", err)
+		}
+		for rows.Next() {
+			var query string
+			if err := rows.Scan(&query); err != nil {
+				t.Fatalf("Failed to scan pg_stat_activity: %v", err)
+			}
+			active = append(active, query)
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatalf("Failed to read pg_stat_activity: %v", err)
+		}
+		if len(active) == 0 {
+			return
+		}
+	}
+	t.Errorf("Expected no statement left running, found: %q", active)
+}
+
+// TestContextErr tests which errors of a done context gain its error
+func TestContextErr(t *testing.T) {
+	t.Parallel()
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	ioTimeout := &net.OpError{Op: "read", Err: os.ErrDeadlineExceeded}
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		err  error
+		want bool // whether the result is context.Canceled
+	}{
+		{"Query Canceled", cancelled, &pq.Error{Code: "57014"}, true},
+		{"Connection Error", cancelled, ioTimeout, true},
+		{"Already The Context's", cancelled, fmt.Errorf("wrapped: %w", context.Canceled), true},
+		{"Other Error", cancelled, ErrUserNotFound, false},
+		{"Context Not Done", context.Background(), &pq.Error{Code: "57014"}, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := contextErr(tc.ctx, tc.err)
+			if !errors.Is(err, tc.err) {
+				t.Errorf("Expected the original error kept, got: %v", err)
+			}
+			if got := errors.Is(err, context.Canceled); got != tc.want {
+				t.Errorf("Expected errors.Is(context.Canceled) = %v, got: %v", tc.want, err)
+			}
+		})
+	}
+	if err := contextErr(cancelled, nil); err != nil {
+		t.Errorf("Expected nil to stay nil, got: %v", err)
+	}
+}
+
+// TestCancellation runs every UserRepository method under each cancelMode
+// and checks it returns the context's error promptly, with no statement
+// left running on the server
+func TestCancellation(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// A database of its own, so only this test's sessions are counted
+	db := testContainer.CreateTestDatabase(ctx, t)
+
+	user, err := NewUserRepository(db).Create(ctx, fixtures.GenerateEmail(t), "Cancel Me")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	repo := NewUserRepository(db, WithHooks(sleepHook{db: db}), WithMailer(nopMailer{}), WithBcryptCost(bcrypt.MinCost))
+	t.Cleanup(func() { repo.Close() })
+
+	const email = "cancelled@example.com"
+	importRow := fmt.Sprintf(`{"id":%d,"uuid":"8a3f0c55-1a7e-4c9b-9a51-0d1c3b1b2f10","email":%q,"name":"Imported","role":"member","created_at":"2024-01-02T03:04:05Z"}`, user.ID+1000, email)
+
+	methods := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"GetByID", func(ctx context.Context) error { _, err := repo.GetByID(ctx, user.ID); return err }},
+		{"GetByUUID", func(ctx context.Context) error { _, err := repo.GetByUUID(ctx, user.UUID); return err }},
+		{"GetByEmail", func(ctx context.Context) error { _, err := repo.GetByEmail(ctx, user.Email); return err }},
+		{"Create", func(ctx context.Context) error { _, err := repo.Create(ctx, email, "Cancelled"); return err }},
+		{"CreateWithRole", func(ctx context.Context) error {
+			_, err := repo.CreateWithRole(ctx, email, "Cancelled", models.RoleAdmin)
+			return err
+		}},
+		{"CreateBatch", func(ctx context.Context) error {
+			_, err := repo.CreateBatch(ctx, []CreateUserInput{{Email: email, Name: "Cancelled"}})
+			return err
+		}},
+		{"CreateIdempotent", func(ctx context.Context) error {
+			_, err := repo.CreateIdempotent(ctx, "cancelled-key", email, "Cancelled")
+			return err
+		}},
+		{"CreateWithPassword", func(ctx context.Context) error {
+			_, err := repo.CreateWithPassword(ctx, email, "Cancelled", "correct horse battery")
+			return err
+		}},
+		{"CreateAndNotify", func(ctx context.Context) error { _, err := repo.CreateAndNotify(ctx, email, "Cancelled"); return err }},
+		{"Update", func(ctx context.Context) error { return repo.Update(ctx, user.ID, user.Email, "Renamed") }},
+		{"UpdateWithRole", func(ctx context.Context) error {
+			return repo.UpdateWithRole(ctx, user.ID, user.Email, "Renamed", models.RoleAdmin)
+		}},
+		{"UpdateWithVersion", func(ctx context.Context) error { u := *user; return repo.UpdateWithVersion(ctx, &u) }},
+		{"SetAvatarKey", func(ctx context.Context) error { return repo.SetAvatarKey(ctx, user.ID, "avatars/cancelled") }},
+		{"ChangePassword", func(ctx context.Context) error {
+			return repo.ChangePassword(ctx, user.ID, "old password", "new password")
+		}},
+		{"Authenticate", func(ctx context.Context) error { _, err := repo.Authenticate(ctx, user.Email, "password"); return err }},
+		{"Delete", func(ctx context.Context) error { return repo.Delete(ctx, user.ID) }},
+		{"List", func(ctx context.Context) error { _, err := repo.List(ctx); return err }},
+		{"ListOrdered", func(ctx context.Context) error {
+			_, err := repo.ListOrdered(ctx, OrderByEmail, Ascending)
+			return err
+		}},
+		{"ListEach", func(ctx context.Context) error { return repo.ListEach(ctx, func(models.User) error { return nil }) }},
+		{"ListChan", func(ctx context.Context) error {
+			users, errc := repo.ListChan(ctx, 0)
+			for range users {
+			}
+			return <-errc
+		}},
+		{"ListPaginated", func(ctx context.Context) error { _, err := repo.ListPaginated(ctx, "", 10); return err }},
+		{"ListByRole", func(ctx context.Context) error { _, err := repo.ListByRole(ctx, models.RoleMember, "", 10); return err }},
+		{"ListFiltered", func(ctx context.Context) error {
+			_, err := repo.ListFiltered(ctx, Filter{}, PageOpts{Limit: 10})
+			return err
+		}},
+		{"GetRecentUsers", func(ctx context.Context) error { _, err := repo.GetRecentUsers(ctx, 7, "", 10); return err }},
+		{"FindByNamePattern", func(ctx context.Context) error { _, err := repo.FindByNamePattern(ctx, "Cancel%"); return err }},
+		{"CountUsers", func(ctx context.Context) error { _, err := repo.CountUsers(ctx); return err }},
+		{"CountByRole", func(ctx context.Context) error { _, err := repo.CountByRole(ctx); return err }},
+		{"IsEmailAvailable", func(ctx context.Context) error { _, err := repo.IsEmailAvailable(ctx, email); return err }},
+		{"GetUserStats", func(ctx context.Context) error {
+			_, err := repo.GetUserStats(ctx, time.Now().AddDate(0, 0, -7), time.Now())
+			return err
+		}},
+		{"GetUsersWithOrderCounts", func(ctx context.Context) error { _, err := repo.GetUsersWithOrderCounts(ctx); return err }},
+		{"GetTopSpenders", func(ctx context.Context) error { _, err := repo.GetTopSpenders(ctx, 5); return err }},
+		{"PurgeIdempotencyKeys", func(ctx context.Context) error { _, err := repo.PurgeIdempotencyKeys(ctx); return err }},
+		{"ExportUsers", func(ctx context.Context) error { return repo.ExportUsers(ctx, io.Discard, FormatJSONL) }},
+		{"ImportUsers", func(ctx context.Context) error {
+			_, err := repo.ImportUsers(ctx, strings.NewReader(importRow+"\n"), FormatJSONL, ImportOptions{})
+			return err
+		}},
+		{"GetByIDForUpdate", func(ctx context.Context) error {
+			tx, err := db.BeginTx(context.Background(), nil)
+			if err != nil {
+				return fmt.Errorf("failed to begin transaction: %w", err)
+			}
+			defer tx.Rollback()
+			_, err = repo.GetByIDForUpdate(ctx, tx, user.ID)
+			return err
+		}},
+		{"Healthcheck", func(ctx context.Context) error { return repo.Healthcheck(ctx) }},
+	}
+
+	for _, mode := range cancelModes {
+		t.Run(mode.name, func(t *testing.T) {
+			for _, m := range methods {
+				t.Run(m.name, func(t *testing.T) {
+					checkCancelled(t, mode, m.call)
+					waitIdle(t, db)
+				})
+			}
+		})
+	}
+
+	// Nothing above may have been written
+	got, err := NewUserRepository(db).GetByID(ctx, user.ID)
+	if err != nil || got.Name != user.Name || got.Version != user.Version {
+		t.Errorf("Expected user %d unchanged, got: %+v, %v", user.ID, got, err)
+	}
+
+	t.Run("Blocked On A Row Lock", func(t *testing.T) {
+		// lib/pq reports a statement cancelled on the server as Postgres's
+		// query_canceled, which must still read as the context's error
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "SELECT 1 FROM users WHERE id = $1 FOR UPDATE", user.ID); err != nil {
+			t.Fatalf("Failed to lock user: %v", err)
+		}
+
+		plain := NewUserRepository(db)
+		t.Cleanup(func() { plain.Close() })
+		for _, mode := range cancelModes[1:] {
+			t.Run(mode.name, func(t *testing.T) {
+				checkCancelled(t, mode, func(ctx context.Context) error {
+					return plain.Update(ctx, user.ID, user.Email, "Waited For The Lock")
+				})
+			})
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Failed to release the lock: %v", err)
+		}
+		waitIdle(t, db)
+	})
+}
+
+// TestCacheCancellation runs the cached reads, and the methods that go to
+// Redis alone, against a Redis that has stopped answering, under each
+// cancelMode. go-redis only honours a deadline, so a cancellation must not
+// wait out the client's read timeout.
+func TestCacheCancellation(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	addr, proxy := testhelpers.StartRedisWithProxy(ctx, t)
+
+	client := redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true, ReadTimeout: 10 * time.Second})
+	t.Cleanup(func() { client.Close() })
+	cachedRepo := NewCachedUserRepository(db, client)
+
+	user, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Cached Cancel Me")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// Holds every connection open without an answer
+	if err := proxy.AddTimeout(ctx, 0); err != nil {
+		t.Fatalf("Failed to block Redis: %v", err)
+	}
+
+	methods := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{"GetByIDCached", func(ctx context.Context) error { _, err := cachedRepo.GetByIDCached(ctx, user.ID); return err }},
+		{"GetByIDsCached", func(ctx context.Context) error {
+			_, err := cachedRepo.GetByIDsCached(ctx, []int{user.ID})
+			return err
+		}},
+		{"GetByEmailCached", func(ctx context.Context) error {
+			_, err := cachedRepo.GetByEmailCached(ctx, user.Email)
+			return err
+		}},
+		{"IsEmailAvailableCached", func(ctx context.Context) error {
+			_, err := cachedRepo.IsEmailAvailableCached(ctx, user.Email)
+			return err
+		}},
+		{"InvalidateCache", func(ctx context.Context) error { return cachedRepo.InvalidateCache(ctx, user.ID) }},
+		{"InvalidateAll", func(ctx context.Context) error { _, err := cachedRepo.InvalidateAll(ctx); return err }},
+		{"Healthcheck", func(ctx context.Context) error { return cachedRepo.Healthcheck(ctx) }},
+	}
+
+	for _, mode := range cancelModes {
+		t.Run(mode.name, func(t *testing.T) {
+			for _, m := range methods {
+				t.Run(m.name, func(t *testing.T) {
+					checkCancelled(t, mode, m.call)
+					waitIdle(t, db)
+				})
+			}
+		})
+	}
+}
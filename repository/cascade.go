@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"testcontainers-demo/models"
+)
+
+// DeleteSummary is what DeleteUserCascade removed along with the user
+type DeleteSummary struct {
+	Orders    int `json:"orders"`     // orders deleted
+	AuditRows int `json:"audit_rows"` // audit_log rows anonymized: those about the user plus those naming them as actor
+	Sessions  int `json:"sessions"`   // sessions ended; always 0 without WithSessions
+}
+
+// deleteUserOrders is DeleteUserCascade's first statement after it locks
+// the user: the orders whose foreign key would block the delete
+const deleteUserOrders = "DELETE FROM orders WHERE user_id = $1"
+
+// DeleteUserCascade deletes user id along with everything that depends on
+// them, where Delete returns ErrHasOrders. In one transaction it deletes
+// the user's orders, anonymizes their audit_log rows as EraseUser does
+// (the trail outlives the user, but not their details), and deletes the
+// user with a user.deleted event. Once that commits it deletes the user's
+// cache entry and email index key, ends their sessions with WithSessions,
+// and publishes the event.
+//
+// A failure after the commit is returned with the summary, as the user is
+// already gone; a failure before it leaves everything as it was.
+func (r *CachedUserRepository) DeleteUserCascade(ctx context.Context, id int) (_ *DeleteSummary, err error) {
+	const op = "CachedUserRepository.DeleteUserCascade"
+	key := fmt.Sprintf("id=%d", id)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: deleteUserReturning, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	user, summary, err := r.users.deleteCascade(ctx, id)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+
+	if err := r.invalidate(ctx, id, user.Email); err != nil {
+		return summary, newRepoError(op, key, err)
+	}
+	if r.sessions != nil {
+		if summary.Sessions, err = r.sessions.DestroyAllForUser(ctx, id); err != nil {
+			return summary, newRepoError(op, key, err)
+		}
+	}
+	if err := r.publish(ctx, models.EventUserDeleted, *user); err != nil {
+		return summary, newRepoError(op, key, err)
+	}
+	return summary, nil
+}
+
+// deleteCascade is DeleteUserCascade's transaction. It returns the deleted
+// user and what went with them, or ErrUserNotFound bare.
+func (r *UserRepository) deleteCascade(ctx context.Context, id int) (_ *models.User, _ *DeleteSummary, err error) {
+	tx, err := beginTx(ctx, r.pool)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx, &err)
+
+	var email string
+	err = tx.QueryRowContext(ctx, selectUserForErase, id, r.tenant).Scan(&email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	summary := &DeleteSummary{}
+	if summary.Orders, err = execCount(ctx, tx, deleteUserOrders, id); err != nil {
+		return nil, nil, fmt.Errorf("failed to delete orders: %w", err)
+	}
+	if summary.AuditRows, err = execCount(ctx, tx, scrubAuditRows, id, ErasedEmail(id), erasedName); err != nil {
+		return nil, nil, fmt.Errorf("failed to anonymize audit rows: %w", err)
+	}
+	actorRows, err := execCount(ctx, tx, scrubAuditActor, ErasedEmail(id), NormalizeEmail(email), r.tenant)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to anonymize audit actor: %w", err)
+	}
+	summary.AuditRows += actorRows
+
+	user, err := scanUser(tx.QueryRowContext(ctx, deleteUserReturning, id, r.tenant))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return user, summary, nil
+}
+
+// execCount runs query in tx and returns how many rows it affected
+func execCount(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
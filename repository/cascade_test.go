@@ -0,0 +1,139 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestDeleteUserCascade tests that a user with orders, audit rows, sessions,
+// and cache entries is deleted with all of them, and another user keeps
+// theirs
+func TestDeleteUserCascade(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	spy := &sessionsSpy{}
+	cachedRepo := NewCachedUserRepository(db, redisClient, WithSessions(spy))
+	repo := NewUserRepository(db)
+	orders := NewOrderRepository(db)
+
+	// setUp creates a user with two orders, an audit row, and cache entries
+	setUp := func(t *testing.T) *models.User {
+		t.Helper()
+		email := fixtures.GenerateEmail(t)
+		user, err := cachedRepo.CreateCached(ctx, email, "Cascade User")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		for _, amount := range []int64{1500, 3000} {
+			if _, err := orders.Create(ctx, user.ID, amount); err != nil {
+				t.Fatalf("Failed to create order: %v", err)
+			}
+		}
+		if _, err := db.ExecContext(ctx,
+			`INSERT INTO audit_log (user_id, action, new_row, actor)
+			VALUES ($1, 'create', jsonb_build_object('email', $2::text, 'name', 'Cascade User'), $2)`,
+			user.ID, email,
+		); err != nil {
+			t.Fatalf("Failed to write audit row: %v", err)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to cache user: %v", err)
+		}
+		if _, err := cachedRepo.IsEmailAvailableCached(ctx, email); err != nil {
+			t.Fatalf("Failed to index email: %v", err)
+		}
+		return user
+	}
+	// count returns how many rows of table match where
+	count := func(t *testing.T, table, where string, args ...interface{}) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE "+where, args...).Scan(&n); err != nil {
+			t.Fatalf("Failed to count %s: %v", table, err)
+		}
+		return n
+	}
+	// cached reports whether any of keys is in Redis
+	cached := func(t *testing.T, keys ...string) bool {
+		t.Helper()
+		n, err := redisClient.Exists(ctx, keys...).Result()
+		if err != nil {
+			t.Fatalf("Failed to check keys: %v", err)
+		}
+		return n > 0
+	}
+
+	victim, bystander := setUp(t), setUp(t)
+	if err := repo.Delete(ctx, victim.ID); !errors.Is(err, ErrHasOrders) {
+		t.Fatalf("Expected a plain delete blocked with ErrHasOrders, got: %v", err)
+	}
+
+	summary, err := cachedRepo.DeleteUserCascade(ctx, victim.ID)
+	if err != nil {
+		t.Fatalf("Failed to cascade-delete user: %v", err)
+	}
+
+	t.Run("Summary", func(t *testing.T) {
+		// The audit row is about the victim and names them as actor
+		want := DeleteSummary{Orders: 2, AuditRows: 2, Sessions: 1}
+		if *summary != want {
+			t.Errorf("Expected %+v, got: %+v", want, *summary)
+		}
+	})
+
+	t.Run("Tables Are Clean", func(t *testing.T) {
+		if n := count(t, "users", "id = $1", victim.ID); n != 0 {
+			t.Errorf("Expected the user deleted, found %d rows", n)
+		}
+		if n := count(t, "orders", "user_id = $1", victim.ID); n != 0 {
+			t.Errorf("Expected the orders deleted, found %d", n)
+		}
+		if n := count(t, "audit_log", "new_row::text ILIKE $1 OR actor ILIKE $1", "%"+victim.Email+"%"); n != 0 {
+			t.Errorf("Expected the audit rows anonymized, found %d naming the email", n)
+		}
+		if n := count(t, "audit_log", "user_id = $1", victim.ID); n != 1 {
+			t.Errorf("Expected the audit row kept, found %d", n)
+		}
+		if n := count(t, "user_events", "user_id = $1 AND event_type = $2", victim.ID, models.EventUserDeleted); n != 1 {
+			t.Errorf("Expected a user.deleted event, found %d", n)
+		}
+	})
+
+	t.Run("Cache Is Clean", func(t *testing.T) {
+		if cached(t, userCacheKey(DefaultTenant, victim.ID), emailCacheKey(DefaultTenant, victim.Email)) {
+			t.Error("Expected the user's cache keys deleted")
+		}
+		if len(spy.destroyed) != 1 || spy.destroyed[0] != victim.ID {
+			t.Errorf("Expected only user %d's sessions destroyed, got: %v", victim.ID, spy.destroyed)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, victim.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Bystander Untouched", func(t *testing.T) {
+		if got, err := orders.ListByUser(ctx, bystander.ID); err != nil || len(got) != 2 {
+			t.Errorf("Expected the bystander's 2 orders, got: %v, %v", got, err)
+		}
+		if n := count(t, "audit_log", "actor = $1", bystander.Email); n != 1 {
+			t.Errorf("Expected the bystander's audit row untouched, found %d", n)
+		}
+		if !cached(t, userCacheKey(DefaultTenant, bystander.ID)) || !cached(t, emailCacheKey(DefaultTenant, bystander.Email)) {
+			t.Error("Expected the bystander's cache keys kept")
+		}
+	})
+
+	t.Run("Missing User", func(t *testing.T) {
+		if _, err := cachedRepo.DeleteUserCascade(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
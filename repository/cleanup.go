@@ -0,0 +1,26 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// rollback is deferred right after BeginTx with the address of the
+// function's error. It rolls tx back unless it was committed, and joins a
+// failed rollback into *err, so a caller whose operation failed sees both
+// errors, and one that returned without committing still learns that the
+// rollback failed.
+func rollback(tx *sql.Tx, err *error) {
+	if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+		*err = errors.Join(*err, fmt.Errorf("failed to roll back transaction: %w", rbErr))
+	}
+}
+
+// closeRows is deferred right after a query with the address of the
+// function's error; it closes rows and joins a failed Close into *err
+func closeRows(rows *sql.Rows, err *error) {
+	if closeErr := rows.Close(); closeErr != nil {
+		*err = errors.Join(*err, fmt.Errorf("failed to close rows: %w", closeErr))
+	}
+}
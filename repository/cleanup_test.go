@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// takeOpErr takes hook's recorded operations, returning them with the error
+// of the last one named name
+func takeOpErr(hook *recordingHook, name string) ([]string, error) {
+	ops, errs := hook.take()
+	var err error
+	for i, op := range ops {
+		if op == name {
+			err = errs[i]
+		}
+	}
+	return ops, err
+}
+
+// TestCleanupErrors injects failures into the paths that used to drop
+// errors and checks the caller, or the hooks, get to see them
+func TestCleanupErrors(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("Rollback Of A Dead Transaction", func(t *testing.T) {
+		tx, err := testDB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		// Kills the connection under the transaction, so ROLLBACK can't be sent
+		if _, err := tx.ExecContext(ctx, "SELECT pg_terminate_backend(pg_backend_pid())"); err == nil {
+			t.Fatal("Expected the terminated session to fail its statement")
+		}
+
+		opErr := errors.New("operation failed")
+		err = opErr
+		rollback(tx, &err)
+		if !errors.Is(err, opErr) || !strings.Contains(err.Error(), "failed to roll back transaction") {
+			t.Errorf("Expected the operation's and the rollback's errors joined, got: %v", err)
+		}
+	})
+
+	t.Run("Rollback After Commit", func(t *testing.T) {
+		tx, err := testDB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		rollback(tx, &err)
+		if err != nil {
+			t.Errorf("Expected no error rolling back a committed transaction, got: %v", err)
+		}
+	})
+
+	t.Run("Unencodable User", func(t *testing.T) {
+		// A database of its own: no other test should list this user
+		db := testContainer.CreateTestDatabase(ctx, t)
+		hook := &recordingHook{}
+		cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t), WithCachedHooks(hook))
+
+		// Postgres takes years past 9999, which time.Time won't marshal
+		var id int
+		if err := db.QueryRowContext(ctx,
+			"INSERT INTO users (email, name, created_at) VALUES ($1, 'Far Future', '10000-01-01') RETURNING id",
+			fixtures.GenerateEmail(t),
+		).Scan(&id); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+
+		user, err := cachedRepo.GetByIDCached(ctx, id)
+		if err != nil || user.ID != id {
+			t.Fatalf("Expected the user despite the cache, got: %+v, %v", user, err)
+		}
+		ops, setErr := takeOpErr(hook, "cache.Set")
+		if setErr == nil || !strings.Contains(setErr.Error(), "failed to encode") {
+			t.Errorf("Expected cache.Set to report the encoding failure, got ops %v, error: %v", ops, setErr)
+		}
+
+		err = cachedRepo.WarmCache(ctx, []int{id})
+		var warmErr *WarmError
+		if !errors.As(err, &warmErr) || len(warmErr.Failed) != 1 || warmErr.Failed[0] != id {
+			t.Errorf("Expected WarmCache to report user %d, got: %v", id, err)
+		}
+	})
+
+	t.Run("Read-Only Redis", func(t *testing.T) {
+		redisContainer := testhelpers.StartRedisContainer(ctx, t)
+		if redisContainer.RedisContainer == nil {
+			t.Skip("Needs a Redis of its own to make read only")
+		}
+		var logs bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelInfo}))
+		hook := &recordingHook{}
+		cachedRepo := NewCachedUserRepository(testDB, redisContainer.Client, WithCachedHooks(hook), WithCachedLogger(logger))
+
+		// Seeded behind the cache's back, so the read below misses
+		user := fixtures.SeedUsers(t, testDB, fixtures.NewUser())[0]
+
+		// A replica of a master that isn't there: reads work, writes fail
+		if err := redisContainer.Client.Do(ctx, "REPLICAOF", "127.0.0.1", "1").Err(); err != nil {
+			t.Fatalf("Failed to make Redis read only: %v", err)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Fatalf("Expected the user despite the cache, got: %v", err)
+		}
+		ops, setErr := takeOpErr(hook, "cache.Set")
+		if setErr == nil || !strings.Contains(setErr.Error(), "READONLY") {
+			t.Errorf("Expected cache.Set to report READONLY, got ops %v, error: %v", ops, setErr)
+		}
+		if !strings.Contains(logs.String(), "level=WARN") || !strings.Contains(logs.String(), "op=cache.Set") {
+			t.Errorf("Expected the failed cache.Set logged at warn level, got:\n%s", logs.String())
+		}
+	})
+}
@@ -0,0 +1,34 @@
+package repository
+
+import "time"
+
+// Clock tells the repositories the current time, wherever they compute it
+// rather than leave it to Postgres or Redis: GetRecentUsers' and
+// WarmCacheRecent's cutoffs, the refresh-ahead threshold, the circuit
+// breaker's cooldown, and WithUpdateLimit's windows. testhelpers.FakeClock
+// implements it for tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock, time.Now
+type systemClock struct{}
+
+// Now returns time.Now()
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// WithClock sets the Clock a UserRepository reads the time from
+func WithClock(c Clock) Option {
+	return func(r *UserRepository) {
+		r.clock = c
+	}
+}
+
+// WithCachedClock sets the Clock a CachedUserRepository reads the time from
+func WithCachedClock(c Clock) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.clock = c
+	}
+}
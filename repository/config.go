@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"database/sql"
+
+	"testcontainers-demo/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewCachedUserRepositoryFromConfig creates a cached user repository with
+// the cache settings of cfg and a Redis client for cfg.Redis, which the
+// repository owns: Close closes it. cfg.Redis.URL is read as by
+// NewCachedUserRepositoryFromURL; without one the client connects to Addr
+// with Password. opts apply after cfg's settings, so they override them.
+func NewCachedUserRepositoryFromConfig(db *sql.DB, cfg config.Config, opts ...CachedOption) (*CachedUserRepository, error) {
+	var client redis.UniversalClient
+	if cfg.Redis.URL != "" {
+		var err error
+		if client, err = newRedisClient(cfg.Redis.URL); err != nil {
+			return nil, err
+		}
+	} else {
+		client = redis.NewClient(&redis.Options{
+			Addr:                  cfg.Redis.Addr,
+			Password:              cfg.Redis.Password,
+			ContextTimeoutEnabled: true,
+		})
+	}
+
+	c := cfg.Cache
+	opts = append([]CachedOption{
+		WithCacheTTL(c.TTL),
+		WithCacheTimeout(c.Timeout),
+		WithNegativeCaching(c.NegativeTTL),
+		WithRefreshAhead(c.RefreshAhead),
+		WithInvalidationFence(c.FenceTTL),
+	}, opts...)
+	r := NewCachedUserRepository(db, client, opts...)
+	r.closeCache = client.Close
+	return r, nil
+}
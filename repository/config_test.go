@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"testcontainers-demo/config"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TestCachedUserRepositoryFromConfig tests that the repository takes its
+// cache settings and its Redis client from the config, that options still
+// override them, and that it owns the client
+func TestCachedUserRepositoryFromConfig(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+
+	cfg := config.Default()
+	cfg.Redis = config.Redis{Addr: "localhost:6379", Password: "s3cret"}
+	cfg.Cache = config.Cache{
+		TTL:          time.Minute,
+		Timeout:      20 * time.Millisecond,
+		NegativeTTL:  5 * time.Second,
+		RefreshAhead: 10 * time.Second,
+		FenceTTL:     0,
+	}
+
+	r, err := NewCachedUserRepositoryFromConfig(testDB, cfg, WithCacheTimeout(30*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	if r.ttl != time.Minute || r.negativeTTL != 5*time.Second || r.refreshAhead != 10*time.Second || r.fenceTTL != 0 {
+		t.Errorf("Expected the config's cache settings, got ttl %s, negative %s, refresh %s, fence %s",
+			r.ttl, r.negativeTTL, r.refreshAhead, r.fenceTTL)
+	}
+	if r.cmdTimeout != 30*time.Millisecond {
+		t.Errorf("Expected the option to override the config's timeout, got: %s", r.cmdTimeout)
+	}
+	client := r.cache.(*redis.Client)
+	if opts := client.Options(); opts.Addr != "localhost:6379" || opts.Password != "s3cret" || !opts.ContextTimeoutEnabled {
+		t.Errorf("Expected a client for the config's address, got: %s", opts.Addr)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Failed to close repository: %v", err)
+	}
+	if err := client.Close(); !errors.Is(err, redis.ErrClosed) {
+		t.Errorf("Expected the owned client closed, got: %v", err)
+	}
+
+	t.Run("URL", func(t *testing.T) {
+		cfg.Redis = config.Redis{URL: "redis+cluster://localhost:7000", Addr: "ignored:6379"}
+		r, err := NewCachedUserRepositoryFromConfig(testDB, cfg)
+		if err != nil {
+			t.Fatalf("Failed to create repository: %v", err)
+		}
+		defer r.Close()
+		if _, ok := r.cache.(*redis.ClusterClient); !ok {
+			t.Errorf("Expected the URL to pick a cluster client, got: %T", r.cache)
+		}
+
+		cfg.Redis.URL = "memcached://localhost"
+		if _, err := NewCachedUserRepositoryFromConfig(testDB, cfg); err == nil {
+			t.Error("Expected an error for an invalid URL")
+		}
+	})
+}
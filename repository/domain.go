@@ -0,0 +1,242 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"testcontainers-demo/models"
+
+	"github.com/lib/pq"
+)
+
+// DomainChangeOptions configures ChangeEmailDomain
+type DomainChangeOptions struct {
+	// DryRun reports what would change without writing anything
+	DryRun bool
+	// Pause is how long to wait between batches, so a large migration
+	// leaves room for the traffic the database and cache are already serving
+	Pause time.Duration
+}
+
+// EmailChange is one user's move from one email to another
+type EmailChange struct {
+	UserID int
+	From   string
+	To     string
+}
+
+// DomainChangeReport is what ChangeEmailDomain did, or would do in a dry run
+type DomainChangeReport struct {
+	Changed   []EmailChange
+	Conflicts []EmailChange // left as they were: another user already has To
+}
+
+// selectDomainBatch selects up to $5 users of tenant $3, with IDs above $4,
+// whose email is at domain $1, with the email each would have at domain $2
+// and whether another user of the tenant has it already. The users are
+// ordered by ID, so the last one's ID is where the next batch starts.
+const selectDomainBatch = `
+	SELECT u.id, u.email, left(u.email, -length($1::text)) || $2::text,
+		EXISTS (
+			SELECT 1 FROM users t
+			WHERE lower(t.email) = lower(left(u.email, -length($1::text)) || $2::text) AND t.tenant_id = $3
+		)
+	FROM users u
+	WHERE u.tenant_id = $3 AND u.id > $4 AND right(lower(u.email), length($1::text) + 1) = '@' || $1::text
+	ORDER BY u.id LIMIT $5
+`
+
+// changeDomain moves users $3 of tenant $4 from domain $1 to domain $2,
+// with a user.updated event each
+var changeDomain = `
+	WITH u AS (
+		UPDATE users SET email = left(email, -length($1::text)) || $2::text
+		WHERE id = ANY($3) AND tenant_id = $4
+		RETURNING ` + userColumns + `
+	) ` + insertUserEvent(models.EventUserUpdated)
+
+// ChangeEmailDomain moves every user of the tenant whose email is at
+// fromDomain to the same address at toDomain, batchSize at a time, and
+// reports who moved. A user whose new address another user already has is
+// left alone and reported as a conflict instead.
+//
+// Each batch locks its users, checks for conflicts, and updates the rest
+// with a user.updated event each, in one transaction; their ID and email
+// index keys, old and new, are invalidated once it commits. A failure stops
+// the job with the batches before it committed, and running it again moves
+// the users left. A signup taking a new address between the check and the
+// update fails its batch with ErrDuplicateEmail; running it again reports
+// that user as a conflict. Invalidation failures don't stop the job: they
+// are returned, joined, along with the full report.
+//
+// The updates don't count towards WithUpdateLimit: with opts.Pause the job
+// throttles itself instead.
+func (r *CachedUserRepository) ChangeEmailDomain(ctx context.Context, fromDomain, toDomain string, batchSize int, opts DomainChangeOptions) (_ *DomainChangeReport, err error) {
+	const op = "CachedUserRepository.ChangeEmailDomain"
+	fromDomain, toDomain = normalizeDomain(fromDomain), normalizeDomain(toDomain)
+	key := fmt.Sprintf("from=%s to=%s batchSize=%d dryRun=%t", fromDomain, toDomain, batchSize, opts.DryRun)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: changeDomain}, fromDomain, toDomain, batchSize)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := validateDomains(fromDomain, toDomain); err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	if batchSize <= 0 {
+		return nil, newRepoError(op, key, errors.New("batch size must be positive"))
+	}
+
+	report := &DomainChangeReport{}
+	var invalidateErrs []error
+	for afterID := 0; ; {
+		if afterID > 0 && opts.Pause > 0 {
+			if err := pause(ctx, opts.Pause); err != nil {
+				return report, newRepoError(op, key, err)
+			}
+		}
+		changed, conflicts, lastID, err := r.changeDomainBatch(ctx, fromDomain, toDomain, afterID, batchSize, opts.DryRun)
+		if err != nil {
+			return report, newRepoError(op, key, err)
+		}
+		report.Changed = append(report.Changed, changed...)
+		report.Conflicts = append(report.Conflicts, conflicts...)
+
+		if !opts.DryRun && len(changed) > 0 {
+			ids := make([]int, len(changed))
+			keys := make([]string, 0, 3*len(changed))
+			for i, c := range changed {
+				ids[i] = c.UserID
+				// The new address's key may hold a WithNegativeCaching entry
+				keys = append(keys, userCacheKey(r.tenant, c.UserID),
+					emailCacheKey(r.tenant, c.From), emailCacheKey(r.tenant, NormalizeEmail(c.To)))
+			}
+			if err := r.evict(ctx, ids, keys...); err != nil {
+				invalidateErrs = append(invalidateErrs, fmt.Errorf("failed to invalidate cache: %w", err))
+			}
+		}
+		if len(changed)+len(conflicts) < batchSize {
+			break
+		}
+		afterID = lastID
+	}
+
+	if len(invalidateErrs) > 0 {
+		return report, newRepoError(op, key, errors.Join(invalidateErrs...))
+	}
+	return report, nil
+}
+
+// changeDomainBatch is UserRepository.changeDomainBatch reported to the
+// hooks as "db.ChangeDomainBatch"
+func (r *CachedUserRepository) changeDomainBatch(ctx context.Context, from, to string, afterID, batchSize int, dryRun bool) (_, _ []EmailChange, _ int, err error) {
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "db.ChangeDomainBatch", Statement: selectDomainBatch}, from, to, afterID, batchSize)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	return r.users.changeDomainBatch(ctx, from, to, afterID, batchSize, dryRun)
+}
+
+// changeDomainBatch moves the batch of users after afterID from domain
+// from to domain to in one transaction, and returns those it moved, those
+// it couldn't, and the last ID of the batch. In a dry run nothing is locked
+// or written.
+func (r *UserRepository) changeDomainBatch(ctx context.Context, from, to string, afterID, batchSize int, dryRun bool) (changed, conflicts []EmailChange, lastID int, err error) {
+	if dryRun {
+		return r.scanDomainBatch(ctx, r.db, selectDomainBatch, from, to, afterID, batchSize)
+	}
+
+	tx, err := beginTx(ctx, r.pool)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx, &err)
+
+	changed, conflicts, lastID, err = r.scanDomainBatch(ctx, tx, selectDomainBatch+" FOR UPDATE OF u", from, to, afterID, batchSize)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if len(changed) > 0 {
+		ids := make([]int, len(changed))
+		for i, c := range changed {
+			ids[i] = c.UserID
+		}
+		if _, err := tx.ExecContext(ctx, changeDomain, from, to, pq.Array(ids), r.tenant); err != nil {
+			if isUniqueViolation(err) {
+				return nil, nil, 0, ErrDuplicateEmail
+			}
+			return nil, nil, 0, fmt.Errorf("failed to change emails: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return changed, conflicts, lastID, nil
+}
+
+// scanDomainBatch runs query, selectDomainBatch with or without a lock,
+// on q and splits the batch into the users that can move and those whose
+// new address is taken
+func (r *UserRepository) scanDomainBatch(ctx context.Context, q DBTX, query, from, to string, afterID, batchSize int) (changed, conflicts []EmailChange, lastID int, err error) {
+	rows, err := q.QueryContext(ctx, query, from, to, r.tenant, afterID, batchSize)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("failed to select users: %w", err)
+	}
+	defer closeRows(rows, &err)
+
+	for rows.Next() {
+		var c EmailChange
+		var taken bool
+		if err := rows.Scan(&c.UserID, &c.From, &c.To, &taken); err != nil {
+			return nil, nil, 0, fmt.Errorf("failed to scan user: %w", err)
+		}
+		if taken {
+			conflicts = append(conflicts, c)
+		} else {
+			changed = append(changed, c)
+		}
+		lastID = c.UserID
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, 0, fmt.Errorf("error iterating users: %w", err)
+	}
+	return changed, conflicts, lastID, nil
+}
+
+// normalizeDomain lower-cases domain and trims any spaces and leading "@"
+func normalizeDomain(domain string) string {
+	return strings.TrimPrefix(strings.ToLower(strings.TrimSpace(domain)), "@")
+}
+
+// validateDomains checks that the normalized from and to are different
+// domains an email can be at
+func validateDomains(from, to string) error {
+	var fields []FieldError
+	for _, d := range []struct{ field, domain string }{{"fromDomain", from}, {"toDomain", to}} {
+		if d.domain == "" {
+			fields = append(fields, FieldError{Field: d.field, Message: "is required"})
+		} else if validateEmail("user@"+d.domain) != "" {
+			fields = append(fields, FieldError{Field: d.field, Message: "must be a valid domain"})
+		}
+	}
+	if len(fields) == 0 && from == to {
+		fields = append(fields, FieldError{Field: "toDomain", Message: "must differ from fromDomain"})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// pause waits for d, or until ctx is done
+func pause(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
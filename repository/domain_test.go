@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"reflect"
+	"slices"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/lib/pq"
+)
+
+// TestChangeEmailDomain tests that a dry run reports the moves without
+// making them, that the real run makes them in batches around the users
+// whose new address is taken, and that no other domain is touched
+func TestChangeEmailDomain(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// A database of its own, so every user at a domain is this test's
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	hook := &recordingHook{}
+	cachedRepo := NewCachedUserRepository(db, redisClient, WithNegativeCaching(time.Hour), WithCachedHooks(hook))
+	repo := NewUserRepository(db)
+
+	seeded := fixtures.SeedUsers(t, db,
+		fixtures.NewUser().WithEmail("alice@old.example"),
+		fixtures.NewUser().WithEmail("bob@old.example"),
+		fixtures.NewUser().WithEmail("carol@old.example"),
+		fixtures.NewUser().WithEmail("carol@new.example"), // conflicts with carol@old.example
+		fixtures.NewUser().WithEmail("dave@old.example"),
+		fixtures.NewUser().WithEmail("erin@other.example"),
+		fixtures.NewUser().WithEmail("frank@notold.example"),
+		fixtures.NewUser().WithEmail("gina@sub.old.example"),
+	)
+	alice, bob, carol, dave := seeded[0], seeded[1], seeded[2], seeded[4]
+	want := &DomainChangeReport{
+		Changed: []EmailChange{
+			{alice.ID, "alice@old.example", "alice@new.example"},
+			{bob.ID, "bob@old.example", "bob@new.example"},
+			{dave.ID, "dave@old.example", "dave@new.example"},
+		},
+		Conflicts: []EmailChange{{carol.ID, "carol@old.example", "carol@new.example"}},
+	}
+	// emails returns every user's email by ID
+	emails := func(t *testing.T) map[int]string {
+		t.Helper()
+		users, err := repo.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		byID := make(map[int]string, len(users))
+		for _, u := range users {
+			byID[u.ID] = u.Email
+		}
+		return byID
+	}
+	before := emails(t)
+
+	// Cached before the move: alice by ID, and her new address as missing
+	if _, err := cachedRepo.GetByIDCached(ctx, alice.ID); err != nil {
+		t.Fatalf("Failed to cache user: %v", err)
+	}
+	if _, err := cachedRepo.GetByEmailCached(ctx, "alice@new.example"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Expected ErrUserNotFound for the new address, got: %v", err)
+	}
+
+	t.Run("Dry Run", func(t *testing.T) {
+		report, err := cachedRepo.ChangeEmailDomain(ctx, "Old.Example", "@new.example", 2, DomainChangeOptions{DryRun: true})
+		if err != nil {
+			t.Fatalf("Failed to dry-run the change: %v", err)
+		}
+		if !reflect.DeepEqual(report, want) {
+			t.Errorf("Expected %+v, got: %+v", want, report)
+		}
+		if after := emails(t); !reflect.DeepEqual(after, before) {
+			t.Errorf("Expected no email changed, got: %v", after)
+		}
+	})
+
+	t.Run("Change", func(t *testing.T) {
+		hook.take()
+		report, err := cachedRepo.ChangeEmailDomain(ctx, "old.example", "new.example", 2, DomainChangeOptions{Pause: time.Millisecond})
+		if err != nil {
+			t.Fatalf("Failed to change the domain: %v", err)
+		}
+		if !reflect.DeepEqual(report, want) {
+			t.Errorf("Expected %+v, got: %+v", want, report)
+		}
+		// Two full batches, then an empty one
+		ops, _ := hook.take()
+		if n := countOps(ops, "db.ChangeDomainBatch"); n != 3 {
+			t.Errorf("Expected 3 batches, got: %d", n)
+		}
+
+		wantEmails := maps.Clone(before)
+		wantEmails[alice.ID], wantEmails[bob.ID], wantEmails[dave.ID] = "alice@new.example", "bob@new.example", "dave@new.example"
+		if after := emails(t); !reflect.DeepEqual(after, wantEmails) {
+			t.Errorf("Expected %v, got: %v", wantEmails, after)
+		}
+
+		var events int
+		if err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM user_events WHERE event_type = $1 AND user_id = ANY($2)",
+			models.EventUserUpdated, pq.Array([]int{alice.ID, bob.ID, carol.ID, dave.ID}),
+		).Scan(&events); err != nil || events != 3 {
+			t.Errorf("Expected 3 user.updated events, got: %d, %v", events, err)
+		}
+
+		// Both entries cached before the move are gone
+		if user, err := cachedRepo.GetByIDCached(ctx, alice.ID); err != nil || user.Email != "alice@new.example" {
+			t.Errorf("Expected alice's new email, got: %+v, %v", user, err)
+		}
+		if user, err := cachedRepo.GetByEmailCached(ctx, "alice@new.example"); err != nil || user.ID != alice.ID {
+			t.Errorf("Expected alice by her new email, got: %+v, %v", user, err)
+		}
+
+		if report, err := cachedRepo.ChangeEmailDomain(ctx, "old.example", "new.example", 2, DomainChangeOptions{}); err != nil ||
+			len(report.Changed) != 0 || len(report.Conflicts) != 1 {
+			t.Errorf("Expected only the conflict left, got: %+v, %v", report, err)
+		}
+	})
+
+	t.Run("Rejects Bad Input", func(t *testing.T) {
+		for _, tt := range []struct {
+			from, to  string
+			batchSize int
+		}{
+			{"old.example", "old.example", 10},
+			{"", "new.example", 10},
+			{"old.example", "not a domain", 10},
+			{"old.example", "new.example", 0},
+		} {
+			if _, err := cachedRepo.ChangeEmailDomain(ctx, tt.from, tt.to, tt.batchSize, DomainChangeOptions{}); err == nil {
+				t.Errorf("Expected an error for %q to %q in batches of %d", tt.from, tt.to, tt.batchSize)
+			}
+		}
+	})
+}
+
+// countOps returns how many of ops are named name
+func countOps(ops []string, name string) int {
+	return len(slices.DeleteFunc(slices.Clone(ops), func(op string) bool { return op != name }))
+}
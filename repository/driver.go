@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// pgError is a Postgres server error, whichever database/sql driver returned
+// it: lib/pq ("postgres") or pgx's stdlib ("pgx"). The repository only looks
+// at errors through it, so NewUserRepository behaves the same on either.
+type pgError struct {
+	Code    string // SQLSTATE, e.g. "23505"
+	Message string
+}
+
+// class returns the SQLSTATE class, the first two characters of the code
+func (e pgError) class() string {
+	if len(e.Code) < 2 {
+		return ""
+	}
+	return e.Code[:2]
+}
+
+// asPgError finds a *pq.Error or *pgconn.PgError in err's chain
+func asPgError(err error) (pgError, bool) {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pgError{Code: string(pqErr.Code), Message: pqErr.Message}, true
+	}
+	var pgxErr *pgconn.PgError
+	if errors.As(err, &pgxErr) {
+		return pgError{Code: pgxErr.Code, Message: pgxErr.Message}, true
+	}
+	return pgError{}, false
+}
@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/db"
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+)
+
+// drivers are the database/sql drivers the repository must behave the same on
+var drivers = []string{db.DriverPQ, db.DriverPGX}
+
+// TestDrivers runs the driver-dependent parts of the repository against the
+// test database once per driver. The whole suite can also run on pgx with
+// DB_DRIVER=pgx.
+func TestDrivers(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+
+	for _, driver := range drivers {
+		t.Run(driver, func(t *testing.T) {
+			conn, err := db.Connect(ctx, testContainer.ConnStr, db.WithDriver(driver))
+			if err != nil {
+				t.Fatalf("Failed to connect: %v", err)
+			}
+			t.Cleanup(func() { conn.Close() })
+
+			testDriver(t, conn, driver)
+		})
+	}
+}
+
+// testDriver exercises the repository on conn, a pool opened with driver
+func testDriver(t *testing.T, conn *sql.DB, driver string) {
+	ctx := context.Background()
+	repo := NewUserRepository(conn)
+	t.Cleanup(func() { repo.Close() })
+	email := fixtures.GenerateEmail(t)
+
+	created, err := repo.CreateWithRole(ctx, email, "Driver User", models.RoleGuest)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// Runs after conn is closed, so clean up through testDB
+	deleteOnCleanup(t, created.ID)
+
+	t.Run("Get", func(t *testing.T) {
+		for name, get := range map[string]func() (*models.User, error){
+			"GetByID":    func() (*models.User, error) { return repo.GetByID(ctx, created.ID) },
+			"GetByUUID":  func() (*models.User, error) { return repo.GetByUUID(ctx, created.UUID) },
+			"GetByEmail": func() (*models.User, error) { return repo.GetByEmail(ctx, email) },
+		} {
+			user, err := get()
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if user.ID != created.ID || user.UUID != created.UUID || user.Email != email ||
+				user.Role != models.RoleGuest || !user.CreatedAt.Equal(created.CreatedAt) {
+				t.Errorf("%s: expected %+v, got: %+v", name, *created, *user)
+			}
+		}
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		_, err := repo.Create(ctx, email, "Driver Duplicate")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+
+		_, err = conn.ExecContext(ctx, "INSERT INTO users (email, name) VALUES ($1, $2)", email, "Raw Duplicate")
+		if pgErr, ok := asPgError(err); !ok || pgErr.Code != uniqueViolation {
+			t.Errorf("Expected a unique violation from the driver, got: %T %v", err, err)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		if _, err := repo.GetByID(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if err := repo.Delete(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound from Delete, got: %v", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		if err := repo.UpdateWithRole(ctx, created.ID, email, "Driver Admin", models.RoleAdmin); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		user, err := repo.GetByID(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if user.Name != "Driver Admin" || user.Role != models.RoleAdmin {
+			t.Errorf("Expected the updated name and role, got: %q %q", user.Name, user.Role)
+		}
+
+		admins, err := repo.ListByRole(ctx, models.RoleAdmin, "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list admins: %v", err)
+		}
+		found := false
+		for _, admin := range admins.Items {
+			found = found || admin.ID == created.ID
+		}
+		if !found {
+			t.Errorf("Expected user %d among the admins, got: %v", created.ID, admins.Items)
+		}
+	})
+
+	t.Run("Transaction Rollback", func(t *testing.T) {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		user, err := repo.WithTx(tx).Create(ctx, fixtures.GenerateEmail(t), "Rolled Back")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected the rolled back user to be gone, got: %v", err)
+		}
+	})
+
+	t.Run("Stream", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var seen int
+		err := repo.ListEach(ctx, func(models.User) error {
+			seen++
+			return errStop
+		})
+		if !errors.Is(err, errStop) || seen != 1 {
+			t.Errorf("Expected to stop after one user, got %d users and: %v", seen, err)
+		}
+		if inUse := conn.Stats().InUse; inUse != 0 {
+			t.Errorf("Expected no connections in use, got: %d", inUse)
+		}
+	})
+
+	t.Run("Terminated Session Is Retryable", func(t *testing.T) {
+		session, err := conn.Conn(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get connection: %v", err)
+		}
+		defer session.Close()
+
+		var pid int
+		if err := session.QueryRowContext(ctx, "SELECT pg_backend_pid()").Scan(&pid); err != nil {
+			t.Fatalf("Failed to get backend pid: %v", err)
+		}
+		if _, err := testDB.ExecContext(ctx, "SELECT pg_terminate_backend($1, 5000)", pid); err != nil {
+			t.Fatalf("Failed to terminate backend: %v", err)
+		}
+
+		_, err = session.ExecContext(ctx, "SELECT 1")
+		if err == nil {
+			t.Fatal("Expected the terminated session to fail")
+		}
+		if !isRetryable(err) {
+			t.Errorf("Expected the %s error to be retryable, got: %T %v", driver, err, err)
+		}
+	})
+}
@@ -0,0 +1,192 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+
+	"testcontainers-demo/models"
+)
+
+// selectEmailTaken is the query behind IsEmailAvailable; $2 is the tenant.
+// It uses the (tenant_id, lower(email)) unique index, like the constraint
+// Create runs into.
+const selectEmailTaken = "SELECT id FROM users WHERE lower(email) = $1 AND tenant_id = $2"
+
+// selectUserByEmail is the query behind GetByEmail and GetByEmailCached; $2
+// is the tenant
+const selectUserByEmail = "SELECT " + userDetailColumns + " FROM users WHERE lower(email) = $1 AND tenant_id = $2"
+
+// IsEmailAvailable reports whether no user of the repository's tenant has
+// email, compared the way the unique index compares it. The answer is
+// advisory only: another signup can take the email between this check and
+// Create, so Create can still return ErrDuplicateEmail and callers must
+// handle it.
+func (r *UserRepository) IsEmailAvailable(ctx context.Context, email string) (_ bool, err error) {
+	const op = "UserRepository.IsEmailAvailable"
+	email = NormalizeEmail(email)
+	key := "email=" + email
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectEmailTaken}, email)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := validateEmailInput(email); err != nil {
+		return false, newRepoError(op, key, err)
+	}
+	_, taken, err := r.emailOwner(ctx, email)
+	if err != nil {
+		return false, newRepoError(op, key, err)
+	}
+	return !taken, nil
+}
+
+// IsEmailAvailableCached is IsEmailAvailable consulting the email index key
+// first. A cached owner means taken; taken emails found in the database are
+// indexed for the cache TTL. Available is never cached, since the next
+// signup can change it. Like IsEmailAvailable it is advisory only, and the
+// index can lag an email being freed by up to the TTL.
+func (r *CachedUserRepository) IsEmailAvailableCached(ctx context.Context, email string) (_ bool, err error) {
+	const op = "CachedUserRepository.IsEmailAvailableCached"
+	email = NormalizeEmail(email)
+	key := "email=" + email
+	outer := &Op{Name: op, Statement: selectEmailTaken}
+	ctx, finish := observe(ctx, r.hooks, outer, email)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := validateEmailInput(email); err != nil {
+		return false, newRepoError(op, key, err)
+	}
+
+	// A WithNegativeCaching entry from GetByEmailCached is only checked again
+	if entry, ok := r.lookupEmail(ctx, emailCacheKey(r.tenant, email)); ok && entry != missingUserEntry {
+		outer.Cache = CacheHit
+		return false, nil
+	}
+	outer.Cache = CacheMiss
+
+	id, taken, err := r.users.emailOwner(ctx, email)
+	if err != nil {
+		return false, newRepoError(op, key, err)
+	}
+	if taken {
+		r.indexEmail(ctx, email, id)
+	}
+	return !taken, nil
+}
+
+// GetByEmailCached is GetByEmail consulting the email index key first: an
+// indexed owner is read with GetByIDCached, and a user found in the database
+// is indexed for the cache TTL. An index entry whose user is gone or has
+// since changed email is ignored. With WithNegativeCaching, an email no user
+// has is remembered for the negative TTL; CreateCached and UpdateCached
+// replace or delete that entry, so a new owner is found straight away.
+func (r *CachedUserRepository) GetByEmailCached(ctx context.Context, email string) (_ *models.User, err error) {
+	const op = "CachedUserRepository.GetByEmailCached"
+	email = NormalizeEmail(email)
+	key := "email=" + email
+	outer := &Op{Name: op, Statement: selectUserByEmail}
+	ctx, finish := observe(ctx, r.hooks, outer, email)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	cacheKey := emailCacheKey(r.tenant, email)
+	if entry, ok := r.lookupEmail(ctx, cacheKey); ok {
+		if entry == missingUserEntry {
+			outer.Cache = CacheHit
+			return nil, newRepoError(op, key, ErrUserNotFound)
+		}
+		if id, err := strconv.Atoi(entry); err == nil {
+			user, err := r.GetByIDCached(ctx, id)
+			if err == nil && NormalizeEmail(user.Email) == email {
+				outer.Cache = CacheHit
+				return user, nil
+			}
+			if err != nil && !errors.Is(err, ErrUserNotFound) {
+				return nil, newRepoError(op, key, err)
+			}
+		}
+	}
+	outer.Cache = CacheMiss
+
+	dbCtx, finishDB := observe(ctx, r.hooks, &Op{Name: "db.GetByEmail", Statement: selectUserByEmail}, email)
+	user, err := r.users.getByEmail(dbCtx, email)
+	if errors.Is(err, ErrUserNotFound) {
+		finishDB(nil)
+		if r.negativeTTL > 0 {
+			setCtx, finish := observe(ctx, r.hooks, &Op{Name: "cache.Set"}, cacheKey)
+			finish(r.cacheWrite(setCtx, func(ctx context.Context) error {
+				return r.cache.Set(ctx, cacheKey, missingUserEntry, r.negativeTTL).Err()
+			}))
+		}
+		return nil, newRepoError(op, key, ErrUserNotFound)
+	}
+	finishDB(err)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	r.indexEmail(ctx, email, user.ID)
+	return user, nil
+}
+
+// lookupEmail returns the email index entry at cacheKey, an owner's ID or
+// missingUserEntry, reporting the lookup to the hooks as "cache.Get". Redis
+// errors and an open circuit breaker count as misses.
+func (r *CachedUserRepository) lookupEmail(ctx context.Context, cacheKey string) (string, bool) {
+	op := &Op{Name: "cache.Get", Cache: CacheMiss}
+	ctx, finish := observe(ctx, r.hooks, op, cacheKey)
+
+	var entry string
+	err := r.cacheDo(ctx, func(ctx context.Context) (err error) {
+		entry, err = r.cache.Get(ctx, cacheKey).Result()
+		return err
+	})
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			err = nil
+		}
+		finish(err)
+		return "", false
+	}
+	op.Cache = CacheHit
+	finish(nil)
+	return entry, true
+}
+
+// validateEmailInput returns a *ValidationError if email is invalid, or nil
+func validateEmailInput(email string) error {
+	if msg := validateEmail(email); msg != "" {
+		return &ValidationError{Fields: []FieldError{{Field: "email", Message: msg}}}
+	}
+	return nil
+}
+
+// emailOwner returns the ID of the tenant's user with normalized email, if any
+func (r *UserRepository) emailOwner(ctx context.Context, email string) (int, bool, error) {
+	var id int
+	err := r.db.QueryRowContext(ctx, selectEmailTaken, email, r.tenant).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to check email: %w", err)
+	}
+	return id, true, nil
+}
+
+// emailCacheKey is the Redis key mapping a normalized email to the ID of
+// its owner in tenant
+func emailCacheKey(tenant, email string) string {
+	return tenantKeyPrefix(tenant) + "email:" + email
+}
+
+// indexEmail records that user id owns email, reporting the write to the
+// hooks as "cache.Set"; failures only cost a later database check
+func (r *CachedUserRepository) indexEmail(ctx context.Context, email string, id int) {
+	cacheKey := emailCacheKey(r.tenant, email)
+	setCtx, finish := observe(ctx, r.hooks, &Op{Name: "cache.Set", UserID: id}, cacheKey)
+	finish(r.cacheWrite(setCtx, func(ctx context.Context) error {
+		return r.cache.Set(ctx, cacheKey, strconv.Itoa(id), r.ttl).Err()
+	}))
+}
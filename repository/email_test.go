@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestIsEmailAvailable tests the advisory signup check
+func TestIsEmailAvailable(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+	taken := newUser(t).Email
+
+	tests := []struct {
+		email string
+		want  bool
+	}{
+		{taken, false},
+		{" " + strings.ToUpper(taken) + " ", false},
+		{fixtures.GenerateEmail(t), true},
+	}
+	for _, tc := range tests {
+		got, err := repo.IsEmailAvailable(ctx, tc.email)
+		if err != nil {
+			t.Fatalf("Failed to check %q: %v", tc.email, err)
+		}
+		if got != tc.want {
+			t.Errorf("Expected available=%v for %q, got: %v", tc.want, tc.email, got)
+		}
+	}
+
+	var validationErr *ValidationError
+	if _, err := repo.IsEmailAvailable(ctx, "not-an-email"); !errors.As(err, &validationErr) {
+		t.Errorf("Expected a ValidationError, got: %v", err)
+	}
+}
+
+// TestIsEmailAvailableRace tests that the check is advisory: two signups
+// that both see the email as available race to Create, and the loser gets
+// ErrDuplicateEmail
+func TestIsEmailAvailableRace(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+
+	const signups = 2
+	email := fixtures.GenerateEmail(t)
+	t.Cleanup(func() { testDB.Exec("DELETE FROM users WHERE email = $1", email) })
+	var checked, wg sync.WaitGroup
+	checked.Add(signups)
+	errs := make([]error, signups)
+	for i := 0; i < signups; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			available, err := repo.IsEmailAvailable(ctx, email)
+			checked.Done()
+			if err != nil || !available {
+				errs[i] = fmt.Errorf("expected the email to be available, got %v and: %v", available, err)
+				return
+			}
+
+			// Both checks pass before either signup creates the user
+			checked.Wait()
+			_, errs[i] = repo.Create(ctx, email, fmt.Sprintf("Racer %d", i))
+		}(i)
+	}
+	wg.Wait()
+
+	var won, lost int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			won++
+		case errors.Is(err, ErrDuplicateEmail):
+			lost++
+		default:
+			t.Errorf("Expected success or ErrDuplicateEmail, got: %v", err)
+		}
+	}
+	if won != 1 || lost != 1 {
+		t.Errorf("Expected one signup to win and one to get ErrDuplicateEmail, got %d won and %d lost", won, lost)
+	}
+
+	if available, err := repo.IsEmailAvailable(ctx, email); err != nil || available {
+		t.Errorf("Expected the email to be taken after the race, got %v and: %v", available, err)
+	}
+}
+
+// TestIsEmailAvailableCached tests that the cached check consults the email index first
+func TestIsEmailAvailableCached(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+	existing := newUser(t)
+	fresh := fixtures.GenerateEmail(t)
+
+	t.Run("Indexed From Database", func(t *testing.T) {
+		available, err := cachedRepo.IsEmailAvailableCached(ctx, strings.ToUpper(existing.Email))
+		if err != nil || available {
+			t.Fatalf("Expected %s to be taken, got %v and: %v", existing.Email, available, err)
+		}
+		if id, err := redisClient.Get(ctx, emailCacheKey(DefaultTenant, existing.Email)).Result(); err != nil || id != fmt.Sprint(existing.ID) {
+			t.Errorf("Expected the email to be indexed to user %d, got %q and: %v", existing.ID, id, err)
+		}
+	})
+
+	t.Run("Index Consulted First", func(t *testing.T) {
+		// An index entry the database doesn't back is still believed
+		if err := redisClient.Set(ctx, emailCacheKey(DefaultTenant, "indexed@example.com"), "42", 0).Err(); err != nil {
+			t.Fatalf("Failed to seed the index: %v", err)
+		}
+		available, err := cachedRepo.IsEmailAvailableCached(ctx, "indexed@example.com")
+		if err != nil || available {
+			t.Errorf("Expected the indexed email to be reported taken, got %v and: %v", available, err)
+		}
+	})
+
+	t.Run("Available Not Cached", func(t *testing.T) {
+		available, err := cachedRepo.IsEmailAvailableCached(ctx, fresh)
+		if err != nil || !available {
+			t.Fatalf("Expected the email to be available, got %v and: %v", available, err)
+		}
+		if n, _ := redisClient.Exists(ctx, emailCacheKey(DefaultTenant, fresh)).Result(); n != 0 {
+			t.Error("Expected an available email not to be indexed")
+		}
+	})
+
+	t.Run("Indexed On Create", func(t *testing.T) {
+		user, err := cachedRepo.CreateCached(ctx, fresh, "Fresh User")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		deleteOnCleanup(t, user.ID)
+		if id, _ := redisClient.Get(ctx, emailCacheKey(DefaultTenant, fresh)).Result(); id != fmt.Sprint(user.ID) {
+			t.Errorf("Expected the new email to be indexed to user %d, got: %q", user.ID, id)
+		}
+		available, err := cachedRepo.IsEmailAvailableCached(ctx, fresh)
+		if err != nil || available {
+			t.Errorf("Expected the new email to be taken, got %v and: %v", available, err)
+		}
+	})
+}
+
+// TestGetByEmailCached tests the cached lookup by email, and that a
+// remembered miss doesn't outlive the user's creation
+func TestGetByEmailCached(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient, WithNegativeCaching(time.Hour))
+
+	t.Run("Indexed From Database", func(t *testing.T) {
+		existing := newUser(t)
+		user, err := cachedRepo.GetByEmailCached(ctx, strings.ToUpper(existing.Email))
+		if err != nil || user.ID != existing.ID {
+			t.Fatalf("Expected user %d, got: %+v, %v", existing.ID, user, err)
+		}
+		if id, _ := redisClient.Get(ctx, emailCacheKey(DefaultTenant, existing.Email)).Result(); id != fmt.Sprint(existing.ID) {
+			t.Errorf("Expected the email to be indexed to user %d, got: %q", existing.ID, id)
+		}
+	})
+
+	t.Run("Stale Index Ignored", func(t *testing.T) {
+		existing := newUser(t)
+		email := fixtures.GenerateEmail(t)
+		// Indexed to a user whose email is something else
+		if err := redisClient.Set(ctx, emailCacheKey(DefaultTenant, email), fmt.Sprint(existing.ID), 0).Err(); err != nil {
+			t.Fatalf("Failed to seed the index: %v", err)
+		}
+		if _, err := cachedRepo.GetByEmailCached(ctx, email); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Miss Then Create", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		if _, err := cachedRepo.GetByEmailCached(ctx, email); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if entry, _ := redisClient.Get(ctx, emailCacheKey(DefaultTenant, email)).Result(); entry != missingUserEntry {
+			t.Fatalf("Expected the miss to be remembered, got: %q", entry)
+		}
+		if available, err := cachedRepo.IsEmailAvailableCached(ctx, email); err != nil || !available {
+			t.Errorf("Expected the remembered miss to leave the email available, got %v and: %v", available, err)
+		}
+
+		created, err := cachedRepo.CreateCached(ctx, email, "Created After Miss")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		deleteOnCleanup(t, created.ID)
+		// Well within the hour the miss was remembered for
+		user, err := cachedRepo.GetByEmailCached(ctx, email)
+		if err != nil || user.ID != created.ID {
+			t.Errorf("Expected the new user %d, got: %+v, %v", created.ID, user, err)
+		}
+	})
+
+	t.Run("Miss Then Update", func(t *testing.T) {
+		existing := newUser(t)
+		email := fixtures.GenerateEmail(t)
+		if _, err := cachedRepo.GetByEmailCached(ctx, email); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if err := cachedRepo.UpdateCached(ctx, existing.ID, email, existing.Name); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		user, err := cachedRepo.GetByEmailCached(ctx, email)
+		if err != nil || user.ID != existing.ID {
+			t.Errorf("Expected user %d under the new email, got: %+v, %v", existing.ID, user, err)
+		}
+	})
+}
+
+// TestCreateCachedDuplicate tests that the cached path reports a taken
+// email as Create does, and leaves the owner's index entry alone
+func TestCreateCachedDuplicate(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+	existing := newUser(t)
+
+	_, err := cachedRepo.CreateCached(ctx, strings.ToUpper(existing.Email), "Duplicate User")
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+	}
+	user, err := cachedRepo.GetByEmailCached(ctx, existing.Email)
+	if err != nil || user.ID != existing.ID {
+		t.Errorf("Expected the original user %d, got: %+v, %v", existing.ID, user, err)
+	}
+}
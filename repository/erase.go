@@ -0,0 +1,149 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"testcontainers-demo/models"
+)
+
+// erasedName replaces an erased user's name
+const erasedName = "Deleted User"
+
+// ErasedEmail is the placeholder that replaces user id's email when it is
+// erased; the .invalid TLD can never receive mail
+func ErasedEmail(id int) string {
+	return fmt.Sprintf("deleted-%d@redacted.invalid", id)
+}
+
+// SessionDestroyer ends every session of a user. *sessions.Store implements
+// it, even one created with a nil UserGetter, which breaks the cycle of a
+// store that reads its users through this repository.
+type SessionDestroyer interface {
+	DestroyAllForUser(ctx context.Context, userID int) (int, error)
+}
+
+// WithSessions makes EraseUser and DeleteUserCascade end the user's
+// sessions in s
+func WithSessions(s SessionDestroyer) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.sessions = s
+	}
+}
+
+// The statements of EraseUser after it locks the user. Unless noted, $1 is
+// the user ID, $2 and $3 the placeholder email and name.
+const (
+	// The audit package's rows are plain JSON copies of the user; avatar_key
+	// is dropped rather than replaced, as models.User omits it when empty
+	scrubAuditRows = `
+		UPDATE audit_log SET
+			old_row = (old_row - 'avatar_key') || jsonb_build_object('email', $2::text, 'name', $3::text),
+			new_row = (new_row - 'avatar_key') || jsonb_build_object('email', $2::text, 'name', $3::text)
+		WHERE user_id = $1
+	`
+	// An actor is free text, often the email of whoever made the change; $1
+	// is the placeholder email, $2 the old one lowercased. Another tenant's
+	// user may have the same email, so only rows about users of the tenant,
+	// $3, are touched; rows about its deleted users keep the actor.
+	scrubAuditActor = `
+		UPDATE audit_log SET actor = $1
+		WHERE lower(actor) = $2 AND user_id IN (SELECT id FROM users WHERE tenant_id = $3)
+	`
+	scrubUserEvents = `
+		UPDATE user_events SET payload = payload || jsonb_build_object('email', $2::text, 'name', $3::text)
+		WHERE user_id = $1
+	`
+)
+
+// selectUserForErase locks user $1 of tenant $2 for EraseUser and
+// DeleteUserCascade, returning their email
+const selectUserForErase = "SELECT email FROM users WHERE id = $1 AND tenant_id = $2 FOR UPDATE"
+
+// eraseUser anonymizes the locked user, recording a user.updated event
+// carrying only the placeholders
+var eraseUser = `
+	WITH u AS (
+		UPDATE users SET email = $2, name = $3, avatar_key = NULL, password_hash = NULL,
+			erased_at = COALESCE(erased_at, CURRENT_TIMESTAMP)
+		WHERE id = $1
+		RETURNING ` + userColumns + `
+	), e AS (` + insertUserEvent(models.EventUserUpdated) + `)
+	SELECT ` + userColumns + ` FROM u
+`
+
+// EraseUser anonymizes user id for a right-to-erasure request. In one
+// transaction it replaces the email with ErasedEmail(id) and the name with
+// a placeholder, clears the password and avatar key, marks the row erased,
+// and rewrites the same details in the user's audit_log rows and outbox
+// events. Afterwards it deletes the user's cache entries and, with
+// WithSessions, ends their sessions, then publishes a user.updated event.
+//
+// The row stays, so references to the ID remain valid: GetByID returns the
+// anonymized user with Erased set, while GetByEmail no longer finds the old
+// email. Events already dispatched and the avatar object itself are out of
+// reach; delete the object with objectstore.Avatars.DeleteAvatar first.
+// Erasing an erased user again is harmless.
+func (r *CachedUserRepository) EraseUser(ctx context.Context, id int) (err error) {
+	const op = "CachedUserRepository.EraseUser"
+	key := fmt.Sprintf("id=%d", id)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: eraseUser, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	user, oldEmail, err := r.users.erase(ctx, id)
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
+
+	if err := r.invalidate(ctx, id, oldEmail); err != nil {
+		return newRepoError(op, key, err)
+	}
+	if r.sessions != nil {
+		if _, err := r.sessions.DestroyAllForUser(ctx, id); err != nil {
+			return newRepoError(op, key, err)
+		}
+	}
+	if err := r.publish(ctx, models.EventUserUpdated, *user); err != nil {
+		return newRepoError(op, key, err)
+	}
+	return nil
+}
+
+// erase is EraseUser's transaction. It returns the anonymized user and the
+// email they had, or ErrUserNotFound bare.
+func (r *UserRepository) erase(ctx context.Context, id int) (_ *models.User, oldEmail string, err error) {
+	email, name := ErasedEmail(id), erasedName
+	tx, err := beginTx(ctx, r.pool)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer rollback(tx, &err)
+
+	err = tx.QueryRowContext(ctx, selectUserForErase, id, r.tenant).Scan(&oldEmail)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrUserNotFound
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to lock user: %w", err)
+	}
+
+	user, err := scanUser(tx.QueryRowContext(ctx, eraseUser, id, email, name))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to erase user: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, scrubAuditRows, id, email, name); err != nil {
+		return nil, "", fmt.Errorf("failed to erase audit rows: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, scrubAuditActor, email, NormalizeEmail(oldEmail), r.tenant); err != nil {
+		return nil, "", fmt.Errorf("failed to erase audit actor: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, scrubUserEvents, id, email, name); err != nil {
+		return nil, "", fmt.Errorf("failed to erase user events: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, "", fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return user, oldEmail, nil
+}
@@ -0,0 +1,150 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// sessionsSpy records the users whose sessions were destroyed
+type sessionsSpy struct {
+	destroyed []int
+}
+
+func (s *sessionsSpy) DestroyAllForUser(_ context.Context, userID int) (int, error) {
+	s.destroyed = append(s.destroyed, userID)
+	return 1, nil
+}
+
+// TestEraseUser tests that erasure leaves the user's row anonymized, and no
+// trace of their email in users, audit_log, user_events, or Redis
+func TestEraseUser(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	spy := &sessionsSpy{}
+	cachedRepo := NewCachedUserRepository(db, redisClient, WithSessions(spy))
+	repo := NewUserRepository(db)
+
+	email := fixtures.GenerateEmail(t)
+	user, err := cachedRepo.CreateCached(ctx, email, "Erin Erasable")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := repo.SetAvatarKey(ctx, user.ID, "avatars/erin"); err != nil {
+		t.Fatalf("Failed to set avatar: %v", err)
+	}
+	if err := cachedRepo.UpdateCached(ctx, user.ID, email, "Erin Renamed"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	// Audit rows as the audit package writes them, one made by the user
+	row, _ := json.Marshal(user)
+	if _, err := db.ExecContext(ctx,
+		`INSERT INTO audit_log (user_id, action, old_row, new_row, actor)
+		VALUES ($1, 'create', NULL, $2, 'admin@example.com'), ($1, 'update', $2, $2, $3)`,
+		user.ID, string(row), strings.ToUpper(email),
+	); err != nil {
+		t.Fatalf("Failed to write audit rows: %v", err)
+	}
+
+	// Fill every cache key that can hold the email
+	if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to cache user: %v", err)
+	}
+	if available, err := cachedRepo.IsEmailAvailableCached(ctx, email); err != nil || available {
+		t.Fatalf("Expected the email indexed as taken, got: %v, %v", available, err)
+	}
+
+	if err := cachedRepo.EraseUser(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to erase user: %v", err)
+	}
+
+	t.Run("Row Is Anonymized", func(t *testing.T) {
+		for name, get := range map[string]func() (*models.User, error){
+			"GetByID":       func() (*models.User, error) { return repo.GetByID(ctx, user.ID) },
+			"GetByIDCached": func() (*models.User, error) { return cachedRepo.GetByIDCached(ctx, user.ID) },
+		} {
+			got, err := get()
+			if err != nil {
+				t.Fatalf("%s: Expected the anonymized user, got: %v", name, err)
+			}
+			if !got.Erased || got.Email != ErasedEmail(user.ID) || got.Name != erasedName || got.AvatarKey != "" || got.UUID != user.UUID {
+				t.Errorf("%s: Expected an erased user keeping its ID and UUID, got: %+v", name, got)
+			}
+		}
+		if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected the old email to miss, got: %v", err)
+		}
+		if available, err := cachedRepo.IsEmailAvailableCached(ctx, email); err != nil || !available {
+			t.Errorf("Expected the old email available, got: %v, %v", available, err)
+		}
+	})
+
+	t.Run("No Trace In Postgres", func(t *testing.T) {
+		// $1 matches the email anywhere, and the user's rows are checked for
+		// their name and the avatar key too
+		pattern := "%" + email + "%"
+		for table, query := range map[string]string{
+			"users": `SELECT COUNT(*) FROM users WHERE email ILIKE $1
+				OR id = $2 AND (name LIKE '%Erin%' OR avatar_key IS NOT NULL OR password_hash IS NOT NULL)`,
+			"audit_log": `SELECT COUNT(*) FROM audit_log WHERE old_row::text ILIKE $1 OR new_row::text ILIKE $1 OR actor ILIKE $1
+				OR user_id = $2 AND concat(old_row::text, new_row::text) LIKE '%Erin%'`,
+			"user_events": `SELECT COUNT(*) FROM user_events WHERE payload::text ILIKE $1
+				OR user_id = $2 AND payload::text LIKE '%Erin%'`,
+		} {
+			var n int
+			if err := db.QueryRowContext(ctx, query, pattern, user.ID).Scan(&n); err != nil {
+				t.Fatalf("Failed to search %s: %v", table, err)
+			}
+			if n != 0 {
+				t.Errorf("Expected no trace of the user in %s, found %d rows", table, n)
+			}
+		}
+	})
+
+	t.Run("No Trace In Redis", func(t *testing.T) {
+		iter := redisClient.Scan(ctx, 0, "*", 0).Iterator()
+		for iter.Next(ctx) {
+			key := iter.Val()
+			if strings.Contains(key, email) {
+				t.Errorf("Expected no key naming the email, found %s", key)
+			}
+			value, err := redisClient.Get(ctx, key).Result()
+			if errors.Is(err, redis.Nil) || err != nil && strings.Contains(err.Error(), "WRONGTYPE") {
+				continue
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", key, err)
+			}
+			if strings.Contains(value, email) || strings.Contains(value, "Erin") {
+				t.Errorf("Expected no trace of the user in %s, got: %s", key, value)
+			}
+		}
+		if err := iter.Err(); err != nil {
+			t.Fatalf("Failed to scan Redis: %v", err)
+		}
+	})
+
+	t.Run("Sessions Ended", func(t *testing.T) {
+		if len(spy.destroyed) != 1 || spy.destroyed[0] != user.ID {
+			t.Errorf("Expected user %d's sessions destroyed, got: %v", user.ID, spy.destroyed)
+		}
+	})
+
+	t.Run("Missing User", func(t *testing.T) {
+		if err := cachedRepo.EraseUser(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
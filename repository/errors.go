@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrUserNotFound is returned when no user matches the lookup
+	ErrUserNotFound = errors.New("user not found")
+
+	// ErrDuplicateEmail is returned when the email is already taken by another user
+	ErrDuplicateEmail = errors.New("email already exists")
+
+	// ErrVersionConflict is returned when a versioned update finds the user
+	// already changed since the version it was given
+	ErrVersionConflict = errors.New("user was modified concurrently")
+
+	// ErrRowLocked is returned by GetByIDForUpdateNoWait when another
+	// transaction holds the user's row lock
+	ErrRowLocked = errors.New("user row is locked")
+
+	// ErrInvalidOrder is returned by ListOrdered for a field or direction
+	// outside its allowlist
+	ErrInvalidOrder = errors.New("invalid sort order")
+
+	// ErrDuplicateUser is returned when an imported user's ID, UUID, or
+	// email is taken by another user
+	ErrDuplicateUser = errors.New("user already exists")
+
+	// ErrInvalidCredentials is returned when an email and password don't
+	// match a user; it deliberately doesn't say which of the two was wrong
+	ErrInvalidCredentials = errors.New("invalid email or password")
+
+	// ErrNotificationFailed is returned when a write succeeded but telling
+	// the user about it, like sending the welcome email, did not
+	ErrNotificationFailed = errors.New("notification failed")
+
+	// ErrHasOrders is returned when deleting or archiving a user who still
+	// has orders; the orders table's foreign key blocks it
+	ErrHasOrders = errors.New("user has orders")
+
+	// ErrIdempotencyConflict is returned by CreateIdempotent when the key
+	// was already used with a different request
+	ErrIdempotencyConflict = errors.New("idempotency key reused with a different request")
+
+	// ErrTooManyUpdates is returned, as a *TooManyUpdatesError, by
+	// UpdateCached for a user updated more often than WithUpdateLimit allows
+	ErrTooManyUpdates = errors.New("too many updates")
+
+	// ErrInvalidTenant is returned by ForTenant for a malformed tenant ID
+	ErrInvalidTenant = errors.New("invalid tenant")
+)
+
+// uniqueViolation is the Postgres SQLSTATE for unique constraint violations
+const uniqueViolation = "23505"
+
+// isUniqueViolation reports whether err is a Postgres or SQLite unique constraint violation
+func isUniqueViolation(err error) bool {
+	pgErr, ok := asPgError(err)
+	return ok && pgErr.Code == uniqueViolation || isSQLiteUniqueViolation(err)
+}
+
+// queryCanceled is the Postgres SQLSTATE of a statement cancelled by a
+// cancel request, as lib/pq sends one when the context ends mid-query
+const queryCanceled = "57014"
+
+// contextErr returns err, wrapping ctx.Err() as well when the context ended
+// the operation: lib/pq reports a cancelled statement as Postgres's
+// "canceling statement due to user request", and a connection it gave up
+// on as a connection error, neither of which errors.Is matches with
+// context.Canceled or context.DeadlineExceeded; nor does the i/o timeout of
+// a Redis command cut off at the deadline. pgx's errors already wrap them.
+func contextErr(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil || errors.Is(err, ctx.Err()) {
+		return err
+	}
+	if pgErr, ok := asPgError(err); ok && pgErr.Code == queryCanceled || isConnError(err) {
+		return fmt.Errorf("%w (%w)", err, ctx.Err())
+	}
+	return err
+}
+
+// foreignKeyViolation is the Postgres SQLSTATE for foreign key violations
+const foreignKeyViolation = "23503"
+
+// isForeignKeyViolation reports whether err is a Postgres foreign key
+// violation, e.g. deleting a user who has orders
+func isForeignKeyViolation(err error) bool {
+	pgErr, ok := asPgError(err)
+	return ok && pgErr.Code == foreignKeyViolation
+}
+
+// RepoError records which repository operation failed and for which key
+// (an ID, email, or query parameters), wrapping the underlying cause so
+// errors.Is and errors.As still see ErrUserNotFound, sql.ErrNoRows, *pq.Error or *pgconn.PgError, etc.
+type RepoError struct {
+	Op  string // e.g. "UserRepository.GetByID"
+	Key string // e.g. "id=42"; empty when the operation has no key
+	Err error
+}
+
+// Error formats as "Op Key: cause"
+func (e *RepoError) Error() string {
+	if e.Key == "" {
+		return e.Op + ": " + e.Err.Error()
+	}
+	return e.Op + " " + e.Key + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying cause
+func (e *RepoError) Unwrap() error {
+	return e.Err
+}
+
+// newRepoError wraps err in a RepoError
+func newRepoError(op, key string, err error) error {
+	return &RepoError{Op: op, Key: key, Err: err}
+}
+
+// InvalidUUIDError is returned for a user UUID that doesn't parse, before
+// any SQL runs
+type InvalidUUIDError struct {
+	Input string
+	Err   error
+}
+
+func (e *InvalidUUIDError) Error() string {
+	return "invalid user uuid " + strconv.Quote(e.Input) + ": " + e.Err.Error()
+}
+
+func (e *InvalidUUIDError) Unwrap() error {
+	return e.Err
+}
+
+// TooManyUpdatesError is returned by UpdateCached over WithUpdateLimit. It
+// unwraps to ErrTooManyUpdates.
+type TooManyUpdatesError struct {
+	RetryAfter time.Duration // until the current window ends
+}
+
+func (e *TooManyUpdatesError) Error() string {
+	return ErrTooManyUpdates.Error() + ", retry after " + e.RetryAfter.String()
+}
+
+func (e *TooManyUpdatesError) Unwrap() error {
+	return ErrTooManyUpdates
+}
+
+// ParseUUID parses a user UUID, e.g. from a URL, returning an
+// *InvalidUUIDError if it isn't one
+func ParseUUID(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, &InvalidUUIDError{Input: s, Err: err}
+	}
+	return id, nil
+}
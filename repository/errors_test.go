@@ -0,0 +1,113 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// TestRepoError tests that repository errors name the operation and key
+// while still matching the underlying cause
+func TestRepoError(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	existing := newUser(t)
+	missingKey := fmt.Sprintf("id=%d", missingID)
+
+	cases := []struct {
+		name  string
+		call  func() error
+		op    string
+		key   string
+		cause error
+	}{
+		{
+			name:  "GetByID Not Found",
+			call:  func() error { _, err := repo.GetByID(ctx, missingID); return err },
+			op:    "UserRepository.GetByID",
+			key:   missingKey,
+			cause: ErrUserNotFound,
+		},
+		{
+			name:  "GetByEmail Not Found",
+			call:  func() error { _, err := repo.GetByEmail(ctx, "nobody@example.com"); return err },
+			op:    "UserRepository.GetByEmail",
+			key:   "email=nobody@example.com",
+			cause: ErrUserNotFound,
+		},
+		{
+			name:  "Create Duplicate Email",
+			call:  func() error { _, err := repo.Create(ctx, existing.Email, "Another User"); return err },
+			op:    "UserRepository.Create",
+			key:   "email=" + existing.Email,
+			cause: ErrDuplicateEmail,
+		},
+		{
+			name:  "Update Not Found",
+			call:  func() error { return repo.Update(ctx, missingID, "nobody@example.com", "Nobody") },
+			op:    "UserRepository.Update",
+			key:   missingKey,
+			cause: ErrUserNotFound,
+		},
+		{
+			name:  "Delete Not Found",
+			call:  func() error { return repo.Delete(ctx, missingID) },
+			op:    "UserRepository.Delete",
+			key:   missingKey,
+			cause: ErrUserNotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.call()
+			if err == nil {
+				t.Fatal("Expected error, got nil")
+			}
+
+			msg := err.Error()
+			if !strings.Contains(msg, tc.op) || !strings.Contains(msg, tc.key) {
+				t.Errorf("Expected message to contain %q and %q, got: %s", tc.op, tc.key, msg)
+			}
+			if !errors.Is(err, tc.cause) {
+				t.Errorf("Expected errors.Is(err, %v), got: %v", tc.cause, err)
+			}
+
+			var repoErr *RepoError
+			if !errors.As(err, &repoErr) {
+				t.Fatalf("Expected *RepoError, got: %T", err)
+			}
+			if repoErr.Op != tc.op || repoErr.Key != tc.key {
+				t.Errorf("Expected Op %q and Key %q, got: %q and %q", tc.op, tc.key, repoErr.Op, repoErr.Key)
+			}
+		})
+	}
+
+	t.Run("SQL Errors Unwrap", func(t *testing.T) {
+		tx, err := testDB.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		tx.Rollback()
+
+		_, err = repo.WithTx(tx).GetByID(ctx, 1)
+		if !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("Expected errors.Is(err, sql.ErrTxDone), got: %v", err)
+		}
+		if !strings.Contains(err.Error(), "UserRepository.GetByID id=1") {
+			t.Errorf("Expected message to name the operation and key, got: %s", err)
+		}
+	})
+
+	t.Run("Format Without Key", func(t *testing.T) {
+		err := &RepoError{Op: "UserRepository.List", Err: errors.New("boom")}
+		if got := err.Error(); got != "UserRepository.List: boom" {
+			t.Errorf("Expected 'UserRepository.List: boom', got: %s", got)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrExplainDisabled is returned by Explain on a repository created without
+// WithExplain
+var ErrExplainDisabled = errors.New("explain is disabled; create the repository WithExplain")
+
+// WithExplain enables Explain. EXPLAIN ANALYZE runs the query it plans, and
+// Explain takes its arguments untyped, so it is for tests and debugging
+// sessions only; a production repository should never be created with it.
+func WithExplain() Option {
+	return func(r *UserRepository) {
+		r.explain = true
+	}
+}
+
+// explainQuery builds the query and arguments of one operation Explain
+// knows from the arguments passed to Explain
+type explainQuery func(r *UserRepository, args []any) (string, []any, error)
+
+// explainQueries are the operations Explain knows, each run as the
+// repository method it is named after runs it
+var explainQueries = map[string]explainQuery{
+	// getByID(id int)
+	"getByID": func(r *UserRepository, args []any) (string, []any, error) {
+		id, err := explainArg[int](args, 0, 1)
+		return selectUserByID, []any{id, r.tenant}, err
+	},
+	// getByEmail(email string)
+	"getByEmail": func(r *UserRepository, args []any) (string, []any, error) {
+		email, err := explainArg[string](args, 0, 1)
+		return selectUserByEmail, []any{NormalizeEmail(email), r.tenant}, err
+	},
+	// list()
+	"list": func(r *UserRepository, args []any) (string, []any, error) {
+		if len(args) != 0 {
+			return "", nil, fmt.Errorf("takes no arguments, got %d", len(args))
+		}
+		return selectAllUsers, []any{r.tenant}, nil
+	},
+	// findByNamePattern(pattern string)
+	"findByNamePattern": func(r *UserRepository, args []any) (string, []any, error) {
+		pattern, err := explainArg[string](args, 0, 1)
+		return selectUsersByNamePattern, []any{"%" + pattern + "%", r.tenant}, err
+	},
+	// recent(days, limit int): GetRecentUsers' first page, newest first
+	"recent": func(r *UserRepository, args []any) (string, []any, error) {
+		days, err := explainArg[int](args, 0, 2)
+		if err != nil {
+			return "", nil, err
+		}
+		limit, err := explainArg[int](args, 1, 2)
+		if err != nil {
+			return "", nil, err
+		}
+		query, queryArgs, _, _, err := r.recentQueries(days, "", Query{Page: PageOpts{Direction: Descending, Limit: limit}})
+		return query, queryArgs, err
+	},
+}
+
+// explainArg returns args[i] as a T, checking there are want arguments
+func explainArg[T any](args []any, i, want int) (T, error) {
+	var zero T
+	if len(args) != want {
+		return zero, fmt.Errorf("takes %d arguments, got %d", want, len(args))
+	}
+	v, ok := args[i].(T)
+	if !ok {
+		return zero, fmt.Errorf("argument %d must be a %T, got %T", i+1, zero, args[i])
+	}
+	return v, nil
+}
+
+// Explain runs EXPLAIN (ANALYZE, FORMAT JSON) on the query behind op, one
+// of getByID, getByEmail, list, findByNamePattern, and recent, with args as
+// that method takes them, and returns the plan as Postgres prints it. The
+// query is executed, on the connection the method would use, so the plan
+// carries actual row counts and timings. It returns ErrExplainDisabled
+// unless the repository was created WithExplain.
+func (r *UserRepository) Explain(ctx context.Context, op string, args ...any) (_ string, err error) {
+	const name = "UserRepository.Explain"
+	key := "op=" + op
+	if !r.explain {
+		return "", newRepoError(name, key, ErrExplainDisabled)
+	}
+	if r.dialect != DialectPostgres {
+		return "", newRepoError(name, key, errors.New("explain needs Postgres"))
+	}
+	build, ok := explainQueries[op]
+	if !ok {
+		return "", newRepoError(name, key, fmt.Errorf("unknown operation %q", op))
+	}
+	query, queryArgs, err := build(r, args)
+	if err != nil {
+		return "", newRepoError(name, key, fmt.Errorf("%s: %w", op, err))
+	}
+
+	statement := "EXPLAIN (ANALYZE, FORMAT JSON) " + query
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: name, Statement: statement}, args...)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	var plan string
+	if err := r.reads().QueryRowContext(ctx, statement, queryArgs...).Scan(&plan); err != nil {
+		return "", newRepoError(name, key, fmt.Errorf("failed to explain: %w", err))
+	}
+	return plan, nil
+}
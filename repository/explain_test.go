@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/testhelpers"
+)
+
+// TestExplain tests that Explain plans each known operation against the
+// container, and that the email lookup uses its index when it must
+func TestExplain(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB, WithExplain())
+	user := newUser(t)
+
+	t.Run("Disabled By Default", func(t *testing.T) {
+		if _, err := NewUserRepository(testDB).Explain(ctx, "getByID", user.ID); !errors.Is(err, ErrExplainDisabled) {
+			t.Errorf("Expected ErrExplainDisabled, got: %v", err)
+		}
+	})
+
+	t.Run("Email Lookup", func(t *testing.T) {
+		plan, err := repo.Explain(ctx, "getByEmail", user.Email)
+		if err != nil {
+			t.Fatalf("Failed to explain: %v", err)
+		}
+		testhelpers.ParsePlan(t, plan)
+
+		// A table this small may well be scanned, so rule that out
+		tx, err := beginTx(ctx, testDB)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, "SET LOCAL enable_seqscan = off"); err != nil {
+			t.Fatalf("Failed to disable sequential scans: %v", err)
+		}
+		plan, err = repo.WithTx(tx).Explain(ctx, "getByEmail", user.Email)
+		if err != nil {
+			t.Fatalf("Failed to explain: %v", err)
+		}
+		testhelpers.AssertIndexScan(t, plan, "users_tenant_email_lower_key")
+	})
+
+	t.Run("Every Operation", func(t *testing.T) {
+		for op, args := range map[string][]any{
+			"getByID":           {user.ID},
+			"list":              nil,
+			"findByNamePattern": {"Test"},
+			"recent":            {7, 20},
+		} {
+			plan, err := repo.Explain(ctx, op, args...)
+			if err != nil {
+				t.Errorf("Failed to explain %s: %v", op, err)
+				continue
+			}
+			testhelpers.ParsePlan(t, plan)
+		}
+	})
+
+	t.Run("Bad Calls", func(t *testing.T) {
+		for name, call := range map[string][]any{
+			"unknown":    {"deleteEverything"},
+			"wrong type": {"getByID", "1"},
+			"too many":   {"getByEmail", user.Email, user.Email},
+			"too few":    {"recent", 7},
+		} {
+			if _, err := repo.Explain(ctx, call[0].(string), call[1:]...); err == nil {
+				t.Errorf("Expected an error for the %s call", name)
+			}
+		}
+	})
+}
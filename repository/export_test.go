@@ -0,0 +1,9 @@
+package repository
+
+import "testcontainers-demo/testhelpers"
+
+// Container exposes the container TestMain starts to the external
+// repository_test package, which can't import mongodb from inside this one
+func Container() *testhelpers.PostgresContainer {
+	return testContainer
+}
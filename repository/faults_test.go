@@ -0,0 +1,211 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"testcontainers-demo/db"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TestSlowRedisFallsBackToPostgres tests that a cache hit slowed down by
+// network latency is abandoned after the cache timeout and served from Postgres
+func TestSlowRedisFallsBackToPostgres(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	existing := newUser(t)
+	addr, proxy := testhelpers.StartRedisWithProxy(ctx, t)
+
+	client := redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true})
+	t.Cleanup(func() { client.Close() })
+
+	const cacheTimeout = 100 * time.Millisecond
+	cachedRepo := NewCachedUserRepository(testDB, client, WithCacheTimeout(cacheTimeout))
+
+	// Warm the cache so the slow read below would otherwise be a hit
+	if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+		t.Fatalf("Failed to warm cache: %v", err)
+	}
+	if err := proxy.AddLatency(ctx, 2*time.Second); err != nil {
+		t.Fatalf("Failed to add latency: %v", err)
+	}
+
+	// The Get, the Set after the database read, and the fence reads and DEL
+	// around them each give up after cacheTimeout
+	const budget = time.Second
+	start := time.Now()
+	user, err := cachedRepo.GetByIDCached(ctx, existing.ID)
+	elapsed := time.Since(start)
+
+	if err != nil {
+		t.Fatalf("Expected a fallback to Postgres, got: %v", err)
+	}
+	if user.ID != existing.ID || user.Email != existing.Email {
+		t.Errorf("Expected user %d from Postgres, got: %+v", existing.ID, user)
+	}
+	if elapsed > budget {
+		t.Errorf("Expected the fallback within %v, took %v", budget, elapsed)
+	}
+
+	t.Run("Cache Used Again Once Healthy", func(t *testing.T) {
+		if err := proxy.RemoveToxic(ctx, "latency"); err != nil {
+			t.Fatalf("Failed to remove latency: %v", err)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if err := client.Get(ctx, fmt.Sprintf("user:default:%d", existing.ID)).Err(); err != nil {
+			t.Errorf("Expected user %d to be cached, got: %v", existing.ID, err)
+		}
+	})
+}
+
+// TestRedisCircuitBreaker tests that a hanging Redis opens the breaker after
+// the failure threshold, that reads then skip Redis entirely and writes are
+// dropped, and that the breaker closes again once Redis recovers
+func TestRedisCircuitBreaker(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	existing := newUser(t)
+	addr, proxy := testhelpers.StartRedisWithProxy(ctx, t)
+
+	client := redis.NewClient(&redis.Options{Addr: addr, ContextTimeoutEnabled: true})
+	t.Cleanup(func() { client.Close() })
+
+	const (
+		cacheTimeout = 50 * time.Millisecond
+		threshold    = 3
+		cooldown     = time.Second
+	)
+	cachedRepo := NewCachedUserRepository(testDB, client,
+		WithCacheTimeout(cacheTimeout), WithCircuitBreaker(threshold, cooldown))
+
+	if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+		t.Fatalf("Failed to warm cache: %v", err)
+	}
+	if err := proxy.AddLatency(ctx, 2*time.Second); err != nil {
+		t.Fatalf("Failed to add latency: %v", err)
+	}
+
+	// Each read costs a timed-out Get, fence read, and Set until the breaker opens
+	for i := 0; i < threshold && cachedRepo.Stats().Breaker != BreakerOpen; i++ {
+		if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+			t.Fatalf("Expected a fallback to Postgres, got: %v", err)
+		}
+	}
+	stats := cachedRepo.Stats()
+	if stats.Breaker != BreakerOpen || stats.Opened != 1 || stats.ConsecutiveFailures != threshold {
+		t.Fatalf("Expected the breaker open after %d failures, got: %+v", threshold, stats)
+	}
+
+	t.Run("Reads Skip Redis While Open", func(t *testing.T) {
+		start := time.Now()
+		user, err := cachedRepo.GetByIDCached(ctx, existing.ID)
+		elapsed := time.Since(start)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if user.ID != existing.ID {
+			t.Errorf("Expected user %d, got: %d", existing.ID, user.ID)
+		}
+		if elapsed >= cacheTimeout {
+			t.Errorf("Expected the read to skip Redis, took %v", elapsed)
+		}
+		if skipped := cachedRepo.Stats().Skipped; skipped < 2 {
+			t.Errorf("Expected the Get and Set skipped, got %d skips", skipped)
+		}
+	})
+
+	t.Run("Writes Dropped While Open", func(t *testing.T) {
+		if err := cachedRepo.UpdateRoleCached(ctx, existing.ID, existing.Role); err != nil {
+			t.Errorf("Expected the invalidation dropped without error, got: %v", err)
+		}
+		if err := cachedRepo.InvalidateCache(ctx, existing.ID); !errors.Is(err, ErrCircuitOpen) {
+			t.Errorf("Expected ErrCircuitOpen from InvalidateCache, got: %v", err)
+		}
+	})
+
+	t.Run("Recovers After Cooldown", func(t *testing.T) {
+		if err := proxy.RemoveToxic(ctx, "latency"); err != nil {
+			t.Fatalf("Failed to remove latency: %v", err)
+		}
+		time.Sleep(cooldown)
+		if got := cachedRepo.Stats().Breaker; got != BreakerHalfOpen {
+			t.Errorf("Expected the breaker half-open after the cooldown, got: %s", got)
+		}
+
+		if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if stats := cachedRepo.Stats(); stats.Breaker != BreakerClosed || stats.ConsecutiveFailures != 0 {
+			t.Errorf("Expected the breaker closed after a successful trial, got: %+v", stats)
+		}
+		if err := client.Get(ctx, fmt.Sprintf("user:default:%d", existing.ID)).Err(); err != nil {
+			t.Errorf("Expected user %d to be cached, got: %v", existing.ID, err)
+		}
+	})
+}
+
+// TestPostgresCutMidList tests that losing the connection while List is
+// reading rows returns an error instead of hanging
+func TestPostgresCutMidList(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	dsn, proxy := testhelpers.StartPostgresWithProxy(ctx, t)
+
+	conn, err := db.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect through proxy: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	repo := NewUserRepository(conn)
+	t.Cleanup(func() { repo.Close() })
+
+	// Enough rows that, throttled, the result takes far longer to arrive than the cut below
+	_, err = conn.ExecContext(ctx, `
+		INSERT INTO users (email, name)
+		SELECT 'bulk' || i || '@example.com', 'Bulk User ' || i FROM generate_series(1, 20000) AS i
+	`)
+	if err != nil {
+		t.Fatalf("Failed to insert users: %v", err)
+	}
+	if err := proxy.AddBandwidth(ctx, 16); err != nil {
+		t.Fatalf("Failed to limit bandwidth: %v", err)
+	}
+
+	cut := time.AfterFunc(500*time.Millisecond, func() {
+		if err := proxy.Disable(context.Background()); err != nil {
+			t.Errorf("Failed to disable proxy: %v", err)
+		}
+	})
+	defer cut.Stop()
+
+	// Only a guard against a hang; the cut should end List well before it
+	listCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	start := time.Now()
+	users, err := repo.List(listCtx)
+	elapsed := time.Since(start)
+
+	if err == nil {
+		t.Fatalf("Expected an error after the connection was cut, got %d users", len(users))
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected List to fail on the cut, but it hung until the deadline: %v", err)
+	}
+	var repoErr *RepoError
+	if !errors.As(err, &repoErr) || repoErr.Op != "UserRepository.List" {
+		t.Errorf("Expected a RepoError from UserRepository.List, got: %v", err)
+	}
+	if elapsed > 10*time.Second {
+		t.Errorf("Expected List to fail promptly after the cut, took %v", elapsed)
+	}
+}
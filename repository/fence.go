@@ -0,0 +1,168 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultFenceTTL is how long an invalidation fence lasts, and so the
+// longest a cache fill may take from its database read to its SET
+const defaultFenceTTL = 10 * time.Second
+
+// WithInvalidationFence sets how long the fence a write leaves behind lasts;
+// the default is 10 seconds, and 0 turns fencing off.
+//
+// Without a fence, a reader that misses the cache and reads a user just
+// before a write commits can SET that old row after the writer's DEL, where
+// it lives for the whole TTL. So every write that invalidates a user first
+// sets a fence key to a fresh token, and every fill reads the token before
+// its database read and again after its SET, deleting the entry it just
+// wrote if the two differ. A stale entry is then visible only between the
+// fill's SET and that DEL. A fill that takes longer than ttl can't tell a
+// fence that came and went, so it deletes its entry too; fills are cheap to
+// repeat, stale entries aren't.
+func WithInvalidationFence(ttl time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.fenceTTL = ttl
+	}
+}
+
+// fenceKey is the Redis key holding the token of the last write to
+// tenant's user id. It is outside the "user:" prefix so InvalidateAll
+// doesn't lift fences mid-write.
+func fenceKey(tenant string, id int) string {
+	return fmt.Sprintf("user_fence:%s:%d", tenant, id)
+}
+
+// fence sets a fresh token in the fences of ids, in one pipeline. Writers
+// call it after their commit and before deleting the users' entries.
+func (r *CachedUserRepository) fence(ctx context.Context, ids ...int) error {
+	if r.fenceTTL <= 0 || len(ids) == 0 {
+		return nil
+	}
+	token := uuid.NewString()
+	return r.cacheWrite(ctx, func(ctx context.Context) error {
+		_, err := r.cache.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, id := range ids {
+				pipe.Set(ctx, fenceKey(r.tenant, id), token, r.fenceTTL)
+			}
+			return nil
+		})
+		return err
+	})
+}
+
+// evict fences ids, then deletes keys, their entries and any others, such
+// as email index keys, with delKeys. The DEL is sent even if the fence
+// failed.
+func (r *CachedUserRepository) evict(ctx context.Context, ids []int, keys ...string) error {
+	fenceErr := r.fence(ctx, ids...)
+	delErr := r.cacheWrite(ctx, func(ctx context.Context) error {
+		return r.delKeys(ctx, keys...)
+	})
+	return errors.Join(fenceErr, delErr)
+}
+
+// fill is a cache fill in progress: the fence tokens of its users as they
+// were before its database read
+type fill struct {
+	ids     []int
+	start   time.Time
+	tokens  []string // "" for no fence
+	unknown bool     // the tokens couldn't be read; any fence counts as a write
+}
+
+// beginFill reads the fences of ids ahead of a database read whose rows
+// will be cached; nil when fencing is off
+func (r *CachedUserRepository) beginFill(ctx context.Context, ids ...int) *fill {
+	if r.fenceTTL <= 0 {
+		return nil
+	}
+	// Measured on the monotonic clock, not the Clock: fences expire in
+	// Redis's real time
+	f := &fill{ids: ids, start: time.Now()}
+	var err error
+	if f.tokens, err = r.fenceTokens(ctx, ids); err != nil {
+		f.unknown = true
+	}
+	return f
+}
+
+// beginFillAfter is beginFill for rows already read, whose IDs weren't
+// known in advance: every fence found after the SET counts as a write
+func (r *CachedUserRepository) beginFillAfter(ids []int, readStart time.Time) *fill {
+	if r.fenceTTL <= 0 {
+		return nil
+	}
+	return &fill{ids: ids, start: readStart, unknown: true}
+}
+
+// endFill deletes the entries of f's users whose fence changed since
+// beginFill, or every one of them if the fill outlived a fence. Call it
+// after the SETs, whether or not they succeeded.
+func (r *CachedUserRepository) endFill(ctx context.Context, f *fill) error {
+	if f == nil || len(f.ids) == 0 {
+		return nil
+	}
+	raced := f.ids
+	if time.Since(f.start) < r.fenceTTL {
+		tokens, err := r.fenceTokens(ctx, f.ids)
+		if err == nil {
+			raced = nil
+			for i, token := range tokens {
+				if f.unknown && token != "" || !f.unknown && token != f.tokens[i] {
+					raced = append(raced, f.ids[i])
+				}
+			}
+		}
+	}
+	if len(raced) == 0 {
+		return nil
+	}
+	keys := make([]string, len(raced))
+	for i, id := range raced {
+		keys[i] = userCacheKey(r.tenant, id)
+	}
+	err := r.cacheWrite(ctx, func(ctx context.Context) error {
+		return r.delKeys(ctx, keys...)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to drop entries raced by a write: %w", err)
+	}
+	return nil
+}
+
+// fenceTokens returns the fence tokens of ids, "" for those without one,
+// in one pipeline
+func (r *CachedUserRepository) fenceTokens(ctx context.Context, ids []int) ([]string, error) {
+	gets := make([]*redis.StringCmd, len(ids))
+	err := r.cacheDo(ctx, func(ctx context.Context) error {
+		_, err := r.cache.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, id := range ids {
+				gets[i] = pipe.Get(ctx, fenceKey(r.tenant, id))
+			}
+			return nil
+		})
+		// A pipeline returns its first command's error; a missing fence is none
+		if err == redis.Nil {
+			err = nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	tokens := make([]string, len(ids))
+	for i, get := range gets {
+		if err := get.Err(); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		tokens[i] = get.Val()
+	}
+	return tokens, nil
+}
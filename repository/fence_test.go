@@ -0,0 +1,222 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// pauseHook holds the first op named name in Before until release is
+// closed, closing paused once it is held: for a cache write, that is a fill
+// stopped between its database read and its SET
+type pauseHook struct {
+	NopHook
+	name    string
+	once    sync.Once
+	paused  chan struct{}
+	release chan struct{}
+}
+
+func newPauseHook(name string) *pauseHook {
+	return &pauseHook{name: name, paused: make(chan struct{}), release: make(chan struct{})}
+}
+
+func (h *pauseHook) Before(ctx context.Context, op Op, _ []interface{}) context.Context {
+	if op.Name == h.name {
+		h.once.Do(func() {
+			close(h.paused)
+			<-h.release
+		})
+	}
+	return ctx
+}
+
+// cachedEntry returns the user cached under id, or nil if there is none
+func cachedEntry(ctx context.Context, t *testing.T, client *redis.Client, id int) *models.User {
+	t.Helper()
+	data, err := client.Get(ctx, userCacheKey(DefaultTenant, id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("Failed to read cache entry: %v", err)
+	}
+	var user models.User
+	if err := json.Unmarshal(data, &user); err != nil {
+		t.Fatalf("Failed to decode cache entry: %v", err)
+	}
+	return &user
+}
+
+// TestInvalidationFence holds each kind of cache fill between its database
+// read and its SET while another repository updates the user, and checks
+// the fill doesn't leave the old row cached; without the fence it does
+func TestInvalidationFence(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// WarmCacheRecent caches every recent user, so the users need a database of their own
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	plain := NewUserRepository(db)
+
+	fills := []struct {
+		name string
+		op   string // the cache write the fill is held at
+		fill func(r *CachedUserRepository, id int) error
+	}{
+		{"GetByIDCached", "cache.Set", func(r *CachedUserRepository, id int) error {
+			_, err := r.GetByIDCached(ctx, id)
+			return err
+		}},
+		{"GetByIDsCached", "cache.SetMany", func(r *CachedUserRepository, id int) error {
+			_, err := r.GetByIDsCached(ctx, []int{id})
+			return err
+		}},
+		{"WarmCache", "cache.SetMany", func(r *CachedUserRepository, id int) error {
+			return r.WarmCache(ctx, []int{id})
+		}},
+		{"WarmCacheRecent", "cache.SetMany", func(r *CachedUserRepository, id int) error {
+			return r.WarmCacheRecent(ctx, 1, 1)
+		}},
+	}
+	for _, fenced := range []bool{true, false} {
+		for _, f := range fills {
+			name := f.name
+			if !fenced {
+				name += " Without Fence"
+			}
+			t.Run(name, func(t *testing.T) {
+				fenceTTL := defaultFenceTTL
+				if !fenced {
+					fenceTTL = 0
+				}
+				user, err := plain.Create(ctx, fixtures.GenerateEmail(t), "Before Update")
+				if err != nil {
+					t.Fatalf("Failed to create user: %v", err)
+				}
+				hook := newPauseHook(f.op)
+				reader := NewCachedUserRepository(db, redisClient, WithCachedHooks(hook), WithInvalidationFence(fenceTTL))
+				writer := NewCachedUserRepository(db, redisClient, WithInvalidationFence(fenceTTL))
+
+				done := make(chan error, 1)
+				go func() { done <- f.fill(reader, user.ID) }()
+				<-hook.paused
+				if err := writer.UpdateCached(ctx, user.ID, user.Email, "After Update"); err != nil {
+					t.Fatalf("Failed to update user: %v", err)
+				}
+				close(hook.release)
+				if err := <-done; err != nil {
+					t.Fatalf("Failed to fill the cache: %v", err)
+				}
+
+				entry := cachedEntry(ctx, t, redisClient, user.ID)
+				stale := entry != nil && entry.Name == "Before Update"
+				if fenced && stale {
+					t.Errorf("Expected the raced fill dropped, got the old row cached: %+v", entry)
+				}
+				if !fenced && !stale {
+					t.Errorf("Expected the race to leave the old row cached without a fence, got: %+v", entry)
+				}
+			})
+		}
+	}
+
+	t.Run("Fresh Fill Kept", func(t *testing.T) {
+		user, err := plain.Create(ctx, fixtures.GenerateEmail(t), "Fresh")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		cachedRepo := NewCachedUserRepository(db, redisClient)
+		// The fence outlives the update, but the read after it is current
+		if err := cachedRepo.UpdateCached(ctx, user.ID, user.Email, "Fresh Again"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if entry := cachedEntry(ctx, t, redisClient, user.ID); entry == nil || entry.Name != "Fresh Again" {
+			t.Errorf("Expected the current row cached, got: %+v", entry)
+		}
+	})
+}
+
+// Shape of TestFenceStress: rounds of one update racing several readers
+const (
+	fenceStressRounds  = 300
+	fenceStressReaders = 8
+)
+
+// TestFenceStress races hundreds of UpdateCached calls on one user against
+// concurrent GetByIDCached calls, and checks after every round, once all of
+// them have returned, that the cache holds no row older than the
+// database's. -short skips it.
+func TestFenceStress(t *testing.T) {
+	requirePostgres(t)
+	if testing.Short() {
+		t.Skip("Skipping stress test in short mode")
+	}
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+	plain := NewUserRepository(testDB)
+	user := newUser(t)
+
+	stale := 0
+	var first string
+	for round := 1; round <= fenceStressRounds; round++ {
+		var wg sync.WaitGroup
+		for range fenceStressReaders {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for range 2 {
+					if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+						t.Errorf("Failed to get user: %v", err)
+					}
+				}
+			}()
+		}
+		if err := cachedRepo.UpdateCached(ctx, user.ID, user.Email, fmt.Sprintf("Round %d", round)); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		wg.Wait()
+
+		current, err := plain.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if entry := cachedEntry(ctx, t, redisClient, user.ID); entry != nil && entry.Version < current.Version {
+			stale++
+			if first == "" {
+				first = fmt.Sprintf("round %d cached version %d (%s) behind %d (%s)",
+					round, entry.Version, entry.Name, current.Version, current.Name)
+			}
+		}
+	}
+	if stale > 0 {
+		t.Errorf("Expected no stale entry after any round, got %d of %d; first: %s", stale, fenceStressRounds, first)
+	}
+}
+
+// TestFenceKey pins the fence key format, outside InvalidateAll's prefix
+func TestFenceKey(t *testing.T) {
+	t.Parallel()
+	if got := fenceKey("acme", 42); got != "user_fence:acme:42" {
+		t.Errorf("Expected user_fence:acme:42, got: %s", got)
+	}
+	if prefix := tenantKeyPrefix("acme"); strings.HasPrefix(fenceKey("acme", 42), prefix) {
+		t.Errorf("Expected the fence key outside %s", prefix)
+	}
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"testcontainers-demo/models"
+)
+
+// Filter narrows ListFiltered to the users matching every field that is
+// set; the zero value of a field means no constraint on it, but for
+// IncludeDeleted, whose zero value leaves soft-deleted users out
+type Filter struct {
+	// NamePattern is a LIKE pattern the name must contain, ignoring case,
+	// as for FindByNamePattern
+	NamePattern string
+	// EmailDomain is the part of the email after the @, ignoring case
+	EmailDomain string
+	// CreatedAfter and CreatedBefore bound created_at, both exclusive
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	Role          *models.Role
+	// IncludeDeleted lists users with a deleted_at as well
+	IncludeDeleted bool
+}
+
+// PageOpts orders and pages ListFiltered. The zero value lists every user
+// by ID.
+type PageOpts struct {
+	OrderBy   OrderField // OrderByID when empty
+	Direction Direction  // Ascending when empty
+	Limit     int        // no limit when 0
+	Offset    int
+}
+
+// Query is the Filter and PageOpts a ListFiltered or GetRecentUsers call
+// runs with, once its QueryOptions have adjusted them
+type Query struct {
+	Filter Filter
+	Page   PageOpts
+}
+
+// QueryOption adjusts a Query; ListFiltered and GetRecentUsers take the
+// same options, applied in order, so a later one wins
+type QueryOption func(*Query)
+
+// WithLimit sets the query's limit, overriding the one passed to the call
+func WithLimit(limit int) QueryOption {
+	return func(q *Query) { q.Page.Limit = limit }
+}
+
+// WithAscending orders the query oldest or lowest first, where
+// GetRecentUsers' default is newest first
+func WithAscending() QueryOption {
+	return func(q *Query) { q.Page.Direction = Ascending }
+}
+
+// WithDomain narrows the query to emails at domain, ignoring case
+func WithDomain(domain string) QueryOption {
+	return func(q *Query) { q.Filter.EmailDomain = domain }
+}
+
+// IncludeDeleted lists soft-deleted users too
+func IncludeDeleted() QueryOption {
+	return func(q *Query) { q.Filter.IncludeDeleted = true }
+}
+
+// With returns q adjusted by opts. Stores outside this package resolve
+// their options with it, starting from the Query their arguments describe.
+func (q Query) With(opts ...QueryOption) Query {
+	for _, opt := range opts {
+		opt(&q)
+	}
+	return q
+}
+
+// whereClause collects the conditions of a WHERE clause and their
+// arguments. Conditions are fixed SQL naming their argument as $%d; values
+// only ever travel as arguments.
+type whereClause struct {
+	conds []string
+	args  []interface{}
+}
+
+// add appends cond, with each $%d replaced by the position of the matching
+// arg
+func (w *whereClause) add(cond string, args ...interface{}) {
+	positions := make([]interface{}, len(args))
+	for i, arg := range args {
+		w.args = append(w.args, arg)
+		positions[i] = len(w.args)
+	}
+	w.conds = append(w.conds, fmt.Sprintf(cond, positions...))
+}
+
+// String returns the clause, with its leading WHERE, or "" without conditions
+func (w *whereClause) String() string {
+	if len(w.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(w.conds, " AND ")
+}
+
+// where builds the WHERE clause for f
+func (f Filter) where() *whereClause {
+	w := &whereClause{}
+	if f.NamePattern != "" {
+		w.add("name ILIKE $%d", "%"+f.NamePattern+"%")
+	}
+	if f.EmailDomain != "" {
+		// LIKE rather than split_part, which SQLite lacks
+		w.add(`lower(email) LIKE $%d ESCAPE '\'`, "%@"+EscapeLike(strings.ToLower(f.EmailDomain)))
+	}
+	if f.CreatedAfter != nil {
+		w.add("created_at > $%d", f.CreatedAfter.UTC())
+	}
+	if f.CreatedBefore != nil {
+		w.add("created_at < $%d", f.CreatedBefore.UTC())
+	}
+	if f.Role != nil {
+		w.add("role = $%d", *f.Role)
+	}
+	if !f.IncludeDeleted {
+		w.add("deleted_at IS NULL")
+	}
+	return w
+}
+
+// unmatchable reports whether f's pattern or domain can match nobody. As in
+// FindByNamePattern, Postgres would reject either as an invalid UTF-8 byte
+// sequence rather than find no match.
+func (f Filter) unmatchable() bool {
+	for _, s := range []string{f.NamePattern, f.EmailDomain} {
+		if !utf8.ValidString(s) || strings.ContainsRune(s, 0) {
+			return true
+		}
+	}
+	return false
+}
+
+// ListFiltered retrieves the users matching every constraint of f, ordered
+// and paged by page, as opts adjust them; ties are broken by ID as in
+// ListOrdered. It returns a ValidationError for an unknown role or a
+// negative limit or offset, and ErrInvalidOrder for an order ListOrdered
+// would reject.
+func (r *UserRepository) ListFiltered(ctx context.Context, f Filter, page PageOpts, opts ...QueryOption) (_ []models.User, err error) {
+	const op = "UserRepository.ListFiltered"
+	q := Query{Filter: f, Page: page}.With(opts...)
+	f, page = q.Filter, q.Page
+	if page.OrderBy == "" {
+		page.OrderBy = OrderByID
+	}
+	if page.Direction == "" {
+		page.Direction = Ascending
+	}
+	key := fmt.Sprintf("order=%s %s limit=%d offset=%d", page.OrderBy, page.Direction, page.Limit, page.Offset)
+
+	orderClause, err := buildOrderClause(page.OrderBy, page.Direction)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	var fields []FieldError
+	if f.Role != nil && !f.Role.Valid() {
+		fields = append(fields, roleFieldError)
+	}
+	if page.Limit < 0 {
+		fields = append(fields, FieldError{Field: "limit", Message: "must not be negative"})
+	}
+	if page.Offset < 0 {
+		fields = append(fields, FieldError{Field: "offset", Message: "must not be negative"})
+	}
+	if len(fields) > 0 {
+		return nil, newRepoError(op, key, &ValidationError{Fields: fields})
+	}
+
+	where := f.where()
+	where.add("tenant_id = $%d", r.tenant)
+	query := "SELECT " + userColumns + " FROM users" + where.String() + " ORDER BY " + orderClause
+	args := where.args
+	if page.Limit > 0 {
+		args = append(args, page.Limit)
+		query += " LIMIT $" + strconv.Itoa(len(args))
+	}
+	if page.Offset > 0 {
+		args = append(args, page.Offset)
+		query += " OFFSET $" + strconv.Itoa(len(args))
+	}
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, args...)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if f.unmatchable() {
+		return []models.User{}, nil
+	}
+
+	rows, err := r.reads().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to list users: %w", err))
+	}
+	users, err := scanUsers(rows)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+
+	return users, nil
+}
@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+)
+
+// statementHook records the statement of every operation
+type statementHook struct {
+	NopHook
+	statements []string
+}
+
+func (h *statementHook) Before(ctx context.Context, op Op, _ []interface{}) context.Context {
+	h.statements = append(h.statements, op.Statement)
+	return ctx
+}
+
+// TestListFiltered tests each filter alone and combined, paging, and that
+// filter values are passed as parameters, in a database of its own so the
+// matches are exact
+func TestListFiltered(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	hook := &statementHook{}
+	repo := NewUserRepository(db, WithHooks(hook))
+
+	// June 2021; the names and domains below are this test's alone
+	day := func(d int) time.Time { return time.Date(2021, time.June, d, 12, 0, 0, 0, time.UTC) }
+	user := func(email, name string, role models.Role, d int) *fixtures.UserBuilder {
+		return fixtures.NewUser().WithEmail(email).WithName(name).WithRole(role).WithCreatedAt(day(d))
+	}
+	seeded := fixtures.SeedUsers(t, db,
+		user("ann@filter.test", "Ann Filterby", models.RoleAdmin, 1),
+		user("bert@filter.test", "Bert Filterby", models.RoleMember, 2),
+		user("cleo@other.test", "Cleo Filterby", models.RoleAdmin, 3),
+		user("dirk@FILTER.test", "Dirk Elsewhere", models.RoleAdmin, 4),
+		user("eve@filter.test", "Eve Filterby", models.RoleGuest, 5),
+	)
+	ann, bert, cleo, dirk, eve := seeded[0].ID, seeded[1].ID, seeded[2].ID, seeded[3].ID, seeded[4].ID
+	admin := models.RoleAdmin
+	after, before := day(1), day(5)
+
+	// ids lists f paged by page, failing the test on an error
+	ids := func(t *testing.T, f Filter, page PageOpts) []int {
+		t.Helper()
+		users, err := repo.ListFiltered(ctx, f, page)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		ids := make([]int, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		return ids
+	}
+
+	for _, tt := range []struct {
+		name   string
+		filter Filter
+		want   []int
+	}{
+		{"Name Pattern", Filter{NamePattern: "filterBY"}, []int{ann, bert, cleo, eve}},
+		{"Email Domain", Filter{EmailDomain: "Filter.test"}, []int{ann, bert, dirk, eve}},
+		{"Created After", Filter{CreatedAfter: &after, EmailDomain: "filter.test"}, []int{bert, dirk, eve}},
+		{"Created Before", Filter{CreatedBefore: &before, NamePattern: "Filterby"}, []int{ann, bert, cleo}},
+		{"Role", Filter{Role: &admin, NamePattern: "Filterby"}, []int{ann, cleo}},
+		{"All Combined", Filter{
+			NamePattern:   "Filterby",
+			EmailDomain:   "filter.test",
+			CreatedAfter:  &after,
+			CreatedBefore: &before,
+			Role:          &admin,
+		}, nil},
+		{"Combined Without Role", Filter{
+			NamePattern:   "Filterby",
+			EmailDomain:   "filter.test",
+			CreatedAfter:  &after,
+			CreatedBefore: &before,
+		}, []int{bert}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ids(t, tt.filter, PageOpts{}); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected users %v, got: %v", tt.want, got)
+			}
+		})
+	}
+
+	t.Run("Zero Filter Lists Everyone", func(t *testing.T) {
+		total, err := repo.CountUsers(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		if got := ids(t, Filter{}, PageOpts{}); len(got) != total {
+			t.Errorf("Expected all %d users, got: %d", total, len(got))
+		}
+	})
+
+	t.Run("Order And Pages", func(t *testing.T) {
+		f := Filter{NamePattern: "Filterby"}
+		page := PageOpts{OrderBy: OrderByCreatedAt, Direction: Descending, Limit: 2}
+		if got, want := ids(t, f, page), []int{eve, cleo}; !slices.Equal(got, want) {
+			t.Errorf("Expected the first page %v, got: %v", want, got)
+		}
+		page.Offset = 2
+		if got, want := ids(t, f, page), []int{bert, ann}; !slices.Equal(got, want) {
+			t.Errorf("Expected the second page %v, got: %v", want, got)
+		}
+		page.Offset = 4
+		if got := ids(t, f, page); len(got) != 0 {
+			t.Errorf("Expected no third page, got: %v", got)
+		}
+	})
+
+	t.Run("Rejects Bad Options", func(t *testing.T) {
+		unknown := models.Role("superuser")
+		var verr *ValidationError
+		if _, err := repo.ListFiltered(ctx, Filter{Role: &unknown}, PageOpts{Limit: -1}); !errors.As(err, &verr) || len(verr.Fields) != 2 {
+			t.Errorf("Expected a ValidationError for the role and limit, got: %v", err)
+		}
+		if _, err := repo.ListFiltered(ctx, Filter{}, PageOpts{OrderBy: "password_hash"}); !errors.Is(err, ErrInvalidOrder) {
+			t.Errorf("Expected ErrInvalidOrder, got: %v", err)
+		}
+	})
+
+	t.Run("Values Are Parameters", func(t *testing.T) {
+		hook.statements = nil
+		for _, f := range []Filter{
+			{NamePattern: "'; DROP TABLE users;--"},
+			{EmailDomain: "filter.test' OR '1'='1"},
+		} {
+			if got := ids(t, f, PageOpts{}); len(got) != 0 {
+				t.Errorf("Expected %+v to match nobody, got: %v", f, got)
+			}
+		}
+		for _, statement := range hook.statements {
+			if strings.Contains(statement, "DROP") || strings.Contains(statement, "'1'") {
+				t.Errorf("Expected the values out of the SQL, got: %s", statement)
+			}
+		}
+		if _, err := repo.GetByID(ctx, ann); err != nil {
+			t.Errorf("Expected the users table intact, got: %v", err)
+		}
+	})
+}
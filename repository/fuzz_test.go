@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"testcontainers-demo/fixtures"
+)
+
+// The fuzz targets run against the container TestMain starts. Fuzzing runs
+// each worker as its own test process, so share one container between them:
+//
+//	TESTCONTAINERS_REUSE=1 go test -run '^$' -fuzz FuzzFindByNamePattern ./repository
+//
+// Without -fuzz, plain go test runs just the seed corpus in testdata/fuzz.
+
+// containsFold reports whether s contains substr under Unicode case folding,
+// a little more forgiving than Postgres' lower() on both sides
+func containsFold(s, substr string) bool {
+	n := utf8.RuneCountInString(substr)
+	runes := []rune(s)
+	for i := 0; i+n <= len(runes); i++ {
+		if strings.EqualFold(string(runes[i:i+n]), substr) {
+			return true
+		}
+	}
+	return false
+}
+
+// FuzzFindByNamePattern tests that any text, escaped with EscapeLike, is
+// searched for literally: the query never fails, and every user it finds
+// has the text in their name
+func FuzzFindByNamePattern(f *testing.F) {
+	requirePostgres(f)
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, f)
+	repo := NewUserRepository(db)
+	f.Cleanup(func() { repo.Close() })
+	// Names full of wildcards, backslashes, and non-ASCII text to match against
+	fixtures.InsertGenerated(f, repo, 594, 200)
+
+	for _, seed := range []string{"", "a", "%", "_", `\`, "100%", "O'Brien", "ع"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, text string) {
+		users, err := repo.FindByNamePattern(ctx, EscapeLike(text))
+		if err != nil {
+			t.Fatalf("Failed to find %q: %v", text, err)
+		}
+		for _, u := range users {
+			if !containsFold(u.Name, text) {
+				t.Errorf("Searching for %q found user %d named %q", text, u.ID, u.Name)
+			}
+		}
+	})
+}
+
+// FuzzNormalizeEmail tests that normalizing twice changes nothing and never
+// adds characters
+func FuzzNormalizeEmail(f *testing.F) {
+	for _, seed := range []string{"", "Alice@Example.COM", "  bob@example.com\t", "ÉMILE@example.com", "Ⱥ@example.com"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, email string) {
+		once := NormalizeEmail(email)
+		if twice := NormalizeEmail(once); twice != once {
+			t.Errorf("Expected %q to normalize to itself, got: %q", once, twice)
+		}
+		// Lowercasing can take more bytes, like Ⱥ to ⱥ, but never more runes
+		if got, limit := utf8.RuneCountInString(once), utf8.RuneCountInString(email); got > limit {
+			t.Errorf("Normalizing %q added characters: %q", email, once)
+		}
+	})
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Dependency names reported by health checks
+const (
+	DependencyPostgres = "postgres"
+	DependencyRedis    = "redis"
+)
+
+// HealthError reports a dependency that failed its health check
+type HealthError struct {
+	Dependency string
+	Err        error
+}
+
+func (e *HealthError) Error() string {
+	return e.Dependency + ": " + e.Err.Error()
+}
+
+func (e *HealthError) Unwrap() error {
+	return e.Err
+}
+
+// pinger is implemented by *sql.DB and preparedDB; transactions have no ping
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// Healthcheck pings the database and runs SELECT 1, returning a
+// *HealthError for postgres on failure
+func (r *UserRepository) Healthcheck(ctx context.Context) error {
+	if err := checkPostgres(ctx, r.db); err != nil {
+		return &HealthError{Dependency: DependencyPostgres, Err: err}
+	}
+	return nil
+}
+
+// Healthcheck checks the database like UserRepository.Healthcheck and PINGs
+// Redis. Both are always checked; the result joins a *HealthError for each
+// failing dependency.
+func (r *CachedUserRepository) Healthcheck(ctx context.Context) error {
+	var errs []error
+	if err := r.users.Healthcheck(ctx); err != nil {
+		errs = append(errs, err)
+	}
+	if err := untilDone(ctx, func() error { return r.cache.Ping(ctx).Err() }); err != nil {
+		errs = append(errs, &HealthError{Dependency: DependencyRedis, Err: err})
+	}
+	return errors.Join(errs...)
+}
+
+// checkPostgres pings db when it can and runs a trivial query, which also
+// catches a server that accepts connections but can't execute statements
+func checkPostgres(ctx context.Context, db DBTX) error {
+	if p, ok := db.(pinger); ok {
+		if err := p.PingContext(ctx); err != nil {
+			return contextErr(ctx, err)
+		}
+	}
+
+	var one int
+	if err := db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return contextErr(ctx, fmt.Errorf("SELECT 1 failed: %w", err))
+	}
+	return nil
+}
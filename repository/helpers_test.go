@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// WithRollbackTx runs fn against a repository bound to a fresh transaction
+// and always rolls it back when the test finishes, so the body can mutate
+// users freely without cleaning up after itself.
+//
+// A failed statement aborts the whole transaction in Postgres, so give each
+// subtest that expects an error its own WithRollbackTx.
+func WithRollbackTx(t *testing.T, db *sql.DB, fn func(repo *UserRepository)) {
+	t.Helper()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+			t.Errorf("Failed to roll back transaction: %v", err)
+		}
+	})
+
+	fn(NewUserRepository(db).WithTx(tx))
+}
+
+// TestWithRollbackTx tests that writes made through the helper never reach the outer connection
+func TestWithRollbackTx(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// Counts cover the whole table, so they need a database of their own
+	db := testContainer.CreateTestDatabase(ctx, t)
+	outer := NewUserRepository(db)
+
+	countBefore, err := outer.CountUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+
+	// Run the body in a subtest so its cleanup (the rollback) has run when t.Run returns
+	t.Run("Insert Ten Users", func(t *testing.T) {
+		WithRollbackTx(t, db, func(repo *UserRepository) {
+			for i := 0; i < 10; i++ {
+				if _, err := repo.Create(ctx, fmt.Sprintf("rollback%d@example.com", i), "Rollback User"); err != nil {
+					t.Fatalf("Failed to create user %d: %v", i, err)
+				}
+			}
+
+			// The transaction sees its own writes
+			count, err := repo.CountUsers(ctx)
+			if err != nil {
+				t.Fatalf("Failed to count users in transaction: %v", err)
+			}
+			if count != countBefore+10 {
+				t.Errorf("Expected %d users inside transaction, got: %d", countBefore+10, count)
+			}
+		})
+	})
+
+	countAfter, err := outer.CountUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if countAfter != countBefore {
+		t.Errorf("Expected count to be unchanged at %d after rollback, got: %d", countBefore, countAfter)
+	}
+}
@@ -0,0 +1,126 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// CacheResult says whether a cache lookup found the key
+type CacheResult string
+
+const (
+	CacheHit  CacheResult = "hit"
+	CacheMiss CacheResult = "miss"
+)
+
+// Op identifies the operation a hook is called for
+type Op struct {
+	// Name is a repository method ("UserRepository.GetByID") or a step
+	// inside one ("cache.Get", "db.GetByID", "cache.Set")
+	Name string
+
+	// Cache is set for cached reads and cache lookups once the result is
+	// known, so it is only populated in After
+	Cache CacheResult
+
+	// Statement is the SQL the operation runs, if it runs one
+	Statement string
+
+	// UserID is the user the operation reads or writes, or 0
+	UserID int
+
+	// Rows is how many rows a write affected, for writes that check it; like
+	// Cache it is only populated in After
+	Rows int64
+}
+
+// String formats the op as "Name" or "Name (hit)"
+func (o Op) String() string {
+	if o.Cache == "" {
+		return o.Name
+	}
+	return o.Name + " (" + string(o.Cache) + ")"
+}
+
+// Hook observes repository operations, e.g. for logging or tracing. Before
+// runs first and may return a derived context, which the operation and the
+// matching After use; After receives the elapsed time and the final error.
+type Hook interface {
+	Before(ctx context.Context, op Op, args []interface{}) context.Context
+	After(ctx context.Context, op Op, duration time.Duration, err error)
+}
+
+// NopHook does nothing; embed it to implement only one of the methods
+type NopHook struct{}
+
+// Before returns ctx unchanged
+func (NopHook) Before(ctx context.Context, _ Op, _ []interface{}) context.Context { return ctx }
+
+// After does nothing
+func (NopHook) After(context.Context, Op, time.Duration, error) {}
+
+// WithHooks installs hooks on a UserRepository; they run in order in Before
+// and in reverse order in After
+func WithHooks(hooks ...Hook) Option {
+	return func(r *UserRepository) {
+		r.hooks = append(r.hooks, hooks...)
+	}
+}
+
+// WithCachedHooks installs hooks on a CachedUserRepository, which also
+// reports its cache lookups and writes
+func WithCachedHooks(hooks ...Hook) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.hooks = append(r.hooks, hooks...)
+	}
+}
+
+// observe runs the Before hooks for op and returns their context plus a
+// function that runs the After hooks. op is read again when the function is
+// called, so callers can fill in op.Cache in between.
+func observe(ctx context.Context, hooks []Hook, op *Op, args ...interface{}) (context.Context, func(error)) {
+	if len(hooks) == 0 {
+		return ctx, func(error) {}
+	}
+
+	for _, h := range hooks {
+		ctx = h.Before(ctx, *op, args)
+	}
+	start := time.Now()
+
+	return ctx, func(err error) {
+		elapsed := time.Since(start)
+		for i := len(hooks) - 1; i >= 0; i-- {
+			hooks[i].After(ctx, *op, elapsed, err)
+		}
+	}
+}
+
+// testingLogHook writes one line per operation to the test log
+type testingLogHook struct {
+	t testing.TB
+}
+
+// argsKey carries an operation's arguments from Before to After
+type argsKey struct{}
+
+// TestingLogHook returns a hook that logs every operation with its
+// arguments, duration, and error via t.Logf
+func TestingLogHook(t testing.TB) Hook {
+	return testingLogHook{t: t}
+}
+
+func (h testingLogHook) Before(ctx context.Context, _ Op, args []interface{}) context.Context {
+	return context.WithValue(ctx, argsKey{}, args)
+}
+
+func (h testingLogHook) After(ctx context.Context, op Op, duration time.Duration, err error) {
+	h.t.Helper()
+	args, _ := ctx.Value(argsKey{}).([]interface{})
+	if err != nil {
+		h.t.Logf("%s %v took %s: %v", op, args, duration, err)
+		return
+	}
+	h.t.Logf("%s %v took %s", op, args, duration)
+}
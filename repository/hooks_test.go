@@ -0,0 +1,198 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// recordingHook records each finished operation as Op.String()
+type recordingHook struct {
+	mu   sync.Mutex
+	ops  []string
+	errs []error
+}
+
+func (h *recordingHook) Before(ctx context.Context, _ Op, _ []interface{}) context.Context {
+	return ctx
+}
+
+func (h *recordingHook) After(_ context.Context, op Op, _ time.Duration, err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ops = append(h.ops, op.String())
+	h.errs = append(h.errs, err)
+}
+
+// take returns the recorded operations and errors and starts over
+func (h *recordingHook) take() ([]string, []error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	ops, errs := h.ops, h.errs
+	h.ops, h.errs = nil, nil
+	return ops, errs
+}
+
+// ctxKey marks contexts returned by ctxHook
+type ctxKey struct{}
+
+// ctxHook tags the context in Before and records whether After saw the tag
+type ctxHook struct {
+	NopHook
+	seen []bool
+}
+
+func (h *ctxHook) Before(ctx context.Context, _ Op, _ []interface{}) context.Context {
+	return context.WithValue(ctx, ctxKey{}, true)
+}
+
+func (h *ctxHook) After(ctx context.Context, _ Op, _ time.Duration, _ error) {
+	tagged, _ := ctx.Value(ctxKey{}).(bool)
+	h.seen = append(h.seen, tagged)
+}
+
+// TestHooks tests that hooks observe repository operations in order
+func TestHooks(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	existing := newUser(t)
+
+	t.Run("UserRepository Operations", func(t *testing.T) {
+		hook := &recordingHook{}
+		repo := NewUserRepository(testDB, WithHooks(hook))
+		defer repo.Close()
+
+		if _, err := repo.GetByID(ctx, existing.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if _, err := repo.CountUsers(ctx); err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+
+		ops, errs := hook.take()
+		want := []string{"UserRepository.GetByID", "UserRepository.GetByID", "UserRepository.CountUsers"}
+		if !reflect.DeepEqual(ops, want) {
+			t.Fatalf("Expected ops %v, got: %v", want, ops)
+		}
+		if errs[0] != nil || !errors.Is(errs[1], ErrUserNotFound) || errs[2] != nil {
+			t.Errorf("Expected only the second op to fail with ErrUserNotFound, got: %v", errs)
+		}
+	})
+
+	t.Run("Copied Into Transactions", func(t *testing.T) {
+		hook := &recordingHook{}
+		repo := NewUserRepository(testDB, WithHooks(hook))
+		defer repo.Close()
+
+		tx, err := testDB.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		if _, err := repo.WithTx(tx).Create(ctx, fixtures.GenerateEmail(t), "Hooks Tx"); err != nil {
+			t.Fatalf("Failed to create user in transaction: %v", err)
+		}
+		if ops, _ := hook.take(); !reflect.DeepEqual(ops, []string{"UserRepository.Create"}) {
+			t.Errorf("Expected [UserRepository.Create], got: %v", ops)
+		}
+	})
+
+	t.Run("Context From Before Reaches After", func(t *testing.T) {
+		hook := &ctxHook{}
+		repo := NewUserRepository(testDB, WithHooks(hook))
+		defer repo.Close()
+
+		if _, err := repo.List(ctx); err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		if len(hook.seen) != 1 || !hook.seen[0] {
+			t.Errorf("Expected After to see the context returned by Before, got: %v", hook.seen)
+		}
+	})
+
+	t.Run("Testing Log Hook", func(t *testing.T) {
+		repo := NewUserRepository(testDB, WithHooks(TestingLogHook(t)))
+		defer repo.Close()
+
+		if _, err := repo.GetByEmail(ctx, existing.Email); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+	})
+}
+
+// TestCachedHooks tests the operation sequence of a cache miss followed by a hit
+func TestCachedHooks(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	existing := newUser(t)
+
+	hook := &recordingHook{}
+	cachedRepo := NewCachedUserRepository(testDB, redisClient, WithCachedHooks(hook))
+
+	if err := cachedRepo.InvalidateCache(ctx, existing.ID); err != nil {
+		t.Fatalf("Failed to invalidate cache: %v", err)
+	}
+	hook.take()
+
+	t.Run("Miss", func(t *testing.T) {
+		if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		ops, errs := hook.take()
+		want := []string{
+			"cache.Get (miss)",
+			"db.GetByID",
+			"cache.Set",
+			"CachedUserRepository.GetByIDCached (miss)",
+		}
+		if !reflect.DeepEqual(ops, want) {
+			t.Fatalf("Expected ops %v, got: %v", want, ops)
+		}
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("Expected %s to succeed, got: %v", ops[i], err)
+			}
+		}
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		if _, err := cachedRepo.GetByIDCached(ctx, existing.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		ops, _ := hook.take()
+		want := []string{"cache.Get (hit)", "CachedUserRepository.GetByIDCached (hit)"}
+		if !reflect.DeepEqual(ops, want) {
+			t.Errorf("Expected ops %v, got: %v", want, ops)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		if _, err := cachedRepo.GetByIDCached(ctx, missingID); err == nil {
+			t.Fatal("Expected error for non-existent user")
+		}
+
+		ops, errs := hook.take()
+		want := []string{"cache.Get (miss)", "db.GetByID", "CachedUserRepository.GetByIDCached (miss)"}
+		if !reflect.DeepEqual(ops, want) {
+			t.Fatalf("Expected ops %v, got: %v", want, ops)
+		}
+		if errs[1] == nil || errs[2] == nil {
+			t.Errorf("Expected the database read and the call to fail, got: %v", errs)
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package repository
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"testcontainers-demo/models"
+)
+
+// DefaultIdempotencyTTL is how long PurgeIdempotencyKeys keeps a key unless
+// WithIdempotencyTTL says otherwise
+const DefaultIdempotencyTTL = 24 * time.Hour
+
+// WithIdempotencyTTL sets how long PurgeIdempotencyKeys keeps an
+// idempotency key after its first use
+func WithIdempotencyTTL(ttl time.Duration) Option {
+	return func(r *UserRepository) {
+		r.idempotencyTTL = ttl
+	}
+}
+
+// insertUserWithKey creates a user, its event, and its idempotency key in
+// one statement, so a failure leaves none of them behind. The key's
+// created_at is $7, the repository's clock, which PurgeIdempotencyKeys
+// measures against.
+var insertUserWithKey = `
+	WITH u AS (
+		INSERT INTO users (email, name, role, tenant_id)
+		VALUES ($1, $2, $3, $6)
+		RETURNING ` + userColumns + `
+	), e AS (` + insertUserEvent(models.EventUserCreated) + `
+	), k AS (
+		INSERT INTO idempotency_keys (key, request_hash, user_id, tenant_id, created_at)
+		SELECT $4, $5, id, $6, $7 FROM u
+	)
+	SELECT ` + userColumns + ` FROM u
+`
+
+// selectIdempotencyKey is the lookup of a key CreateIdempotent has seen for
+// tenant $2
+const selectIdempotencyKey = "SELECT request_hash, user_id FROM idempotency_keys WHERE key = $1 AND tenant_id = $2"
+
+// CreateIdempotent creates a user like Create, once per idempotencyKey, so
+// a client can safely retry a create that timed out. A replay with the
+// same key and the same email and name returns the user the first call
+// created, as it is now; a replay with a different request returns
+// ErrIdempotencyConflict. Concurrent first calls with one key create one
+// user: the others wait for it and replay it. Keys are the tenant's own,
+// so two tenants' clients may use the same one. Keys are honored until
+// PurgeIdempotencyKeys removes them, or the user is deleted. Postgres only.
+func (r *UserRepository) CreateIdempotent(ctx context.Context, idempotencyKey, email, name string) (_ *models.User, err error) {
+	const op = "UserRepository.CreateIdempotent"
+	key := "idempotency_key=" + idempotencyKey
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: insertUserWithKey}, idempotencyKey, email, name)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if idempotencyKey == "" {
+		return nil, newRepoError(op, key, &ValidationError{Fields: []FieldError{{Field: "idempotency_key", Message: "is required"}}})
+	}
+	in, err := validated(CreateUserInput{Email: email, Name: name})
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	hash := requestHash(in)
+
+	// A retry usually finds its key
+	if user, found, err := r.replay(ctx, idempotencyKey, hash); found || err != nil {
+		if err != nil {
+			return nil, newRepoError(op, key, err)
+		}
+		return user, nil
+	}
+
+	var user *models.User
+	err = r.withRetry(ctx, func() (err error) {
+		user, err = scanUser(r.db.QueryRowContext(ctx, insertUserWithKey, in.Email, in.Name, in.Role, idempotencyKey, hash, r.tenant, r.clock.Now().UTC()))
+		return err
+	})
+	if isUniqueViolation(err) {
+		// Either the email is taken or a concurrent call with the key got
+		// there first; the insert waited for it to commit, so its key is
+		// visible now
+		user, found, err := r.replay(ctx, idempotencyKey, hash)
+		if err != nil {
+			return nil, newRepoError(op, key, err)
+		}
+		if !found {
+			return nil, newRepoError(op, key, ErrDuplicateEmail)
+		}
+		return user, nil
+	}
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to create user: %w", err))
+	}
+	return user, nil
+}
+
+// replay returns the user idempotencyKey created, reporting whether the key
+// is known; ErrIdempotencyConflict if it was used with a request other than
+// hash's
+func (r *UserRepository) replay(ctx context.Context, idempotencyKey, hash string) (*models.User, bool, error) {
+	var storedHash string
+	var userID int
+	err := r.db.QueryRowContext(ctx, selectIdempotencyKey, idempotencyKey, r.tenant).Scan(&storedHash, &userID)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to look up idempotency key: %w", err)
+	}
+	if storedHash != hash {
+		return nil, true, ErrIdempotencyConflict
+	}
+
+	user, err := scanUser(r.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND tenant_id = $2", userID, r.tenant))
+	if err == sql.ErrNoRows {
+		return nil, true, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to get user: %w", err)
+	}
+	return user, true, nil
+}
+
+// requestHash identifies the normalized request in, so a replay differing
+// only in email case or surrounding spaces still matches
+func requestHash(in CreateUserInput) string {
+	sum := sha256.Sum256([]byte(in.Email + "\x00" + in.Name + "\x00" + string(in.Role)))
+	return hex.EncodeToString(sum[:])
+}
+
+// PurgeIdempotencyKeys deletes the tenant's idempotency keys first used
+// longer ago than the WithIdempotencyTTL window, by the repository's Clock,
+// which also dated them, and returns how many it deleted. Run it
+// periodically; a purged key creates a new user when it is used again.
+func (r *UserRepository) PurgeIdempotencyKeys(ctx context.Context) (_ int, err error) {
+	const op = "UserRepository.PurgeIdempotencyKeys"
+	query := "DELETE FROM idempotency_keys WHERE created_at < $1 AND tenant_id = $2"
+	cutoff := r.clock.Now().UTC().Add(-r.idempotencyTTL)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, cutoff)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	result, err := r.db.ExecContext(ctx, query, cutoff, r.tenant)
+	if err != nil {
+		return 0, newRepoError(op, "cutoff="+cutoff.Format(time.RFC3339), fmt.Errorf("failed to purge idempotency keys: %w", err))
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, newRepoError(op, "cutoff="+cutoff.Format(time.RFC3339), fmt.Errorf("failed to get rows affected: %w", err))
+	}
+	return int(n), nil
+}
@@ -0,0 +1,155 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestCreateIdempotent tests replays, conflicting replays, concurrent first
+// calls, and purging expired keys, in a database of its own so user counts
+// are exact
+func TestCreateIdempotent(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	clock := testhelpers.NewFakeClock(time.Now())
+	repo := NewUserRepository(db, WithClock(clock), WithIdempotencyTTL(time.Hour))
+
+	// usersWith counts the users with email
+	usersWith := func(t *testing.T, email string) int {
+		t.Helper()
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE lower(email) = lower($1)", email).Scan(&n); err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		return n
+	}
+
+	t.Run("Replay", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		first, err := repo.CreateIdempotent(ctx, "key-replay", email, "Idem User")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		// A retry may differ in email case and spacing
+		again, err := repo.CreateIdempotent(ctx, "key-replay", " "+email+" ", "Idem User")
+		if err != nil {
+			t.Fatalf("Failed to replay create: %v", err)
+		}
+		if again.ID != first.ID || again.UUID != first.UUID {
+			t.Errorf("Expected the original user %d, got: %+v", first.ID, again)
+		}
+		if n := usersWith(t, email); n != 1 {
+			t.Errorf("Expected 1 user, got: %d", n)
+		}
+	})
+
+	t.Run("Conflicting Replay", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		if _, err := repo.CreateIdempotent(ctx, "key-conflict", email, "Idem User"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		for name, tc := range map[string]struct{ email, name string }{
+			"Other Name":  {email, "Someone Else"},
+			"Other Email": {"other-" + email, "Idem User"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				if _, err := repo.CreateIdempotent(ctx, "key-conflict", tc.email, tc.name); !errors.Is(err, ErrIdempotencyConflict) {
+					t.Errorf("Expected ErrIdempotencyConflict, got: %v", err)
+				}
+			})
+		}
+		if n := usersWith(t, "other-"+email); n != 0 {
+			t.Errorf("Expected no user created by the conflicting call, got: %d", n)
+		}
+	})
+
+	t.Run("Taken Email Without Key", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		if _, err := repo.Create(ctx, email, "Plain User"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := repo.CreateIdempotent(ctx, "key-taken", email, "Plain User"); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		var verr *ValidationError
+		if _, err := repo.CreateIdempotent(ctx, "", fixtures.GenerateEmail(t), "No Key"); !errors.As(err, &verr) {
+			t.Errorf("Expected a ValidationError for an empty key, got: %v", err)
+		}
+	})
+
+	t.Run("Concurrent First Calls", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		ids := make([]int, 2)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := range ids {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				user, err := repo.CreateIdempotent(ctx, "key-concurrent", email, "Racing User")
+				if err != nil {
+					t.Errorf("Failed to create user: %v", err)
+					return
+				}
+				ids[i] = user.ID
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		if ids[0] == 0 || ids[0] != ids[1] {
+			t.Errorf("Expected both calls to return the same user, got: %v", ids)
+		}
+		if n := usersWith(t, email); n != 1 {
+			t.Errorf("Expected exactly 1 user, got: %d", n)
+		}
+	})
+
+	t.Run("Purge Expired Keys", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		if _, err := repo.CreateIdempotent(ctx, "key-old", email, "Old Key"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		// Every key so far is fresh; the clock moves past the window for them
+		if n, err := repo.PurgeIdempotencyKeys(ctx); err != nil || n != 0 {
+			t.Fatalf("Expected nothing purged yet, got: %d, %v", n, err)
+		}
+		clock.Advance(2 * time.Hour)
+		n, err := repo.PurgeIdempotencyKeys(ctx)
+		if err != nil {
+			t.Fatalf("Failed to purge keys: %v", err)
+		}
+		if n < 1 {
+			t.Errorf("Expected the expired keys purged, got: %d", n)
+		}
+
+		// A purged key is new again: the same request now runs into the
+		// user it created before
+		if _, err := repo.CreateIdempotent(ctx, "key-old", email, "Old Key"); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail for a purged key, got: %v", err)
+		}
+	})
+
+	t.Run("Purge Dates Keys By The Clock", func(t *testing.T) {
+		// Years behind the database's own clock: a key dated by the
+		// database would never fall out of this clock's window
+		past := testhelpers.NewFakeClock(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+		pastRepo := NewUserRepository(db, WithClock(past), WithIdempotencyTTL(time.Hour))
+		if _, err := pastRepo.CreateIdempotent(ctx, "key-past", fixtures.GenerateEmail(t), "Past Key"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		past.Advance(2 * time.Hour)
+		if n, err := pastRepo.PurgeIdempotencyKeys(ctx); err != nil || n != 1 {
+			t.Errorf("Expected the key purged, got: %d, %v", n, err)
+		}
+	})
+}
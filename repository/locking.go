@@ -0,0 +1,55 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"testcontainers-demo/models"
+)
+
+// lockNotAvailable is the Postgres SQLSTATE for a NOWAIT lock that is held elsewhere
+const lockNotAvailable = "55P03"
+
+// GetByIDForUpdate reads a user inside tx and locks its row until tx ends,
+// for read-compute-write flows: other transactions' updates, deletes, and
+// FOR UPDATE reads of the user wait for tx to commit or roll back. If
+// another transaction holds the lock, it waits for it too; cancelling ctx
+// stops the wait with an error wrapping ctx.Err(), after which Postgres
+// has aborted tx and it can only be rolled back. Postgres only.
+func (r *UserRepository) GetByIDForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.User, error) {
+	return r.getForUpdate(ctx, "UserRepository.GetByIDForUpdate", tx, id, "FOR UPDATE")
+}
+
+// GetByIDForUpdateNoWait is GetByIDForUpdate without the wait: when another
+// transaction holds the user's lock it returns ErrRowLocked at once. That
+// error aborts tx as well.
+func (r *UserRepository) GetByIDForUpdateNoWait(ctx context.Context, tx *sql.Tx, id int) (*models.User, error) {
+	return r.getForUpdate(ctx, "UserRepository.GetByIDForUpdateNoWait", tx, id, "FOR UPDATE NOWAIT")
+}
+
+// getForUpdate is GetByID inside tx with lock appended to the query
+func (r *UserRepository) getForUpdate(ctx context.Context, op string, tx *sql.Tx, id int, lock string) (_ *models.User, err error) {
+	key := fmt.Sprintf("id=%d", id)
+	query := "SELECT " + userDetailColumns + " FROM users WHERE id = $1 AND tenant_id = $2 " + lock
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	user, err := scanUserDetail(r.WithTx(tx).db.QueryRowContext(ctx, query, id, r.tenant))
+	if err == sql.ErrNoRows {
+		return nil, newRepoError(op, key, ErrUserNotFound)
+	}
+	if pgErr, ok := asPgError(err); ok && pgErr.Code == lockNotAvailable {
+		return nil, newRepoError(op, key, ErrRowLocked)
+	}
+	// lib/pq reports a cancelled wait as Postgres' "canceling statement due
+	// to user request", which doesn't say why
+	if err != nil && ctx.Err() != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("%w while waiting for the lock: %v", ctx.Err(), err))
+	}
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to lock user: %w", err))
+	}
+
+	return user, nil
+}
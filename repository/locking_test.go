@@ -0,0 +1,133 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestGetByIDForUpdate tests row locks between two transactions: NOWAIT
+// fails at once while the first holds user 1, a waiting lock gets it once
+// the first commits, and a cancelled wait gives up without harm
+func TestGetByIDForUpdate(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+
+	// begin starts a transaction that is rolled back if the test leaves it open
+	begin := func(t *testing.T) *sql.Tx {
+		t.Helper()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		t.Cleanup(func() { tx.Rollback() })
+		return tx
+	}
+
+	t.Run("NoWait Then Wait", func(t *testing.T) {
+		first := begin(t)
+		locked, err := repo.GetByIDForUpdate(ctx, first, 1)
+		if err != nil {
+			t.Fatalf("Failed to lock user: %v", err)
+		}
+
+		second := begin(t)
+		start := time.Now()
+		_, err = repo.GetByIDForUpdateNoWait(ctx, second, 1)
+		if !errors.Is(err, ErrRowLocked) {
+			t.Fatalf("Expected ErrRowLocked, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected NOWAIT to fail at once, took %s", elapsed)
+		}
+		// The failed lock aborted the second transaction
+		second.Rollback()
+
+		// A waiting lock gets the row once the first transaction commits
+		third := begin(t)
+		got := make(chan error, 1)
+		go func() {
+			_, err := repo.GetByIDForUpdate(ctx, third, 1)
+			got <- err
+		}()
+		select {
+		case err := <-got:
+			t.Fatalf("Expected to wait for the lock, got: %v", err)
+		case <-time.After(200 * time.Millisecond):
+		}
+
+		if _, err := first.ExecContext(ctx, "UPDATE users SET name = $1 WHERE id = $2", "Locked Update", locked.ID); err != nil {
+			t.Fatalf("Failed to update locked user: %v", err)
+		}
+		if err := first.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		select {
+		case err := <-got:
+			if err != nil {
+				t.Fatalf("Failed to lock user after commit: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Still waiting for the lock after the first transaction committed")
+		}
+
+		user, err := repo.WithTx(third).GetByID(ctx, 1)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if user.Name != "Locked Update" {
+			t.Errorf("Expected the committed name, got: %s", user.Name)
+		}
+		if err := third.Commit(); err != nil {
+			t.Errorf("Failed to commit: %v", err)
+		}
+	})
+
+	t.Run("Cancelled Wait", func(t *testing.T) {
+		holder := begin(t)
+		if _, err := repo.GetByIDForUpdate(ctx, holder, 1); err != nil {
+			t.Fatalf("Failed to lock user: %v", err)
+		}
+
+		waiter := begin(t)
+		waitCtx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		_, err := repo.GetByIDForUpdate(waitCtx, waiter, 1)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Expected the wait to stop at the deadline, took %s", elapsed)
+		}
+		waiter.Rollback()
+
+		// The server stopped waiting too: no backend is left blocked on the lock
+		var waiting int
+		err = db.QueryRowContext(ctx, "SELECT count(*) FROM pg_stat_activity WHERE datname = current_database() AND wait_event_type = 'Lock'").Scan(&waiting)
+		if err != nil {
+			t.Fatalf("Failed to read pg_stat_activity: %v", err)
+		}
+		if waiting != 0 {
+			t.Errorf("Expected no backend waiting on a lock, got: %d", waiting)
+		}
+
+		// And the holder's transaction is unaffected
+		if err := holder.Commit(); err != nil {
+			t.Errorf("Failed to commit the holder: %v", err)
+		}
+	})
+
+	t.Run("Missing User", func(t *testing.T) {
+		tx := begin(t)
+		if _, err := repo.GetByIDForUpdateNoWait(ctx, tx, -1); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
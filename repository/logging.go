@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// LogOption configures WithLogger and WithCachedLogger
+type LogOption func(*logConfig)
+
+type logConfig struct {
+	params bool
+}
+
+// LogParameters includes statement arguments and full Redis commands in the
+// log. They hold emails, names, and password hashes, so leave it to tests and
+// local debugging.
+func LogParameters() LogOption {
+	return func(c *logConfig) {
+		c.params = true
+	}
+}
+
+// WithLogger logs every UserRepository operation to l at debug level, with
+// its statement, duration, rows affected, and error. Arguments are left out
+// unless LogParameters is passed.
+func WithLogger(l *slog.Logger, opts ...LogOption) Option {
+	return WithHooks(newLogHook(l, opts))
+}
+
+// WithCachedLogger logs every CachedUserRepository operation, including the
+// cache lookups and writes, to l as WithLogger does. A failed lookup or
+// write is logged at warn level instead: the operation carries on without
+// the cache, so the error never reaches the caller, and a Redis that is
+// full or read only would otherwise stop caching without a sign. It also
+// adds a hook to the Redis client, if it takes one, logging each command it
+// sends, so one log shows a read as cache miss, select, and set. The client
+// hook stays on the client, which may be shared with other code.
+func WithCachedLogger(l *slog.Logger, opts ...LogOption) CachedOption {
+	hook := newLogHook(l, opts)
+	return func(r *CachedUserRepository) {
+		r.hooks = append(r.hooks, hook)
+		if c, ok := r.cache.(interface{ AddHook(redis.Hook) }); ok {
+			c.AddHook(redisLogHook{logHook: hook})
+		}
+	}
+}
+
+// logHook is a Hook that logs each operation once it finishes
+type logHook struct {
+	logger *slog.Logger
+	params bool
+}
+
+func newLogHook(l *slog.Logger, opts []LogOption) logHook {
+	var c logConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return logHook{logger: l, params: c.params}
+}
+
+// Before keeps the arguments for After when they are logged
+func (h logHook) Before(ctx context.Context, _ Op, args []interface{}) context.Context {
+	if !h.params {
+		return ctx
+	}
+	return context.WithValue(ctx, argsKey{}, args)
+}
+
+// After logs the operation, at warn level for a failed cache step
+func (h logHook) After(ctx context.Context, op Op, duration time.Duration, err error) {
+	level := slog.LevelDebug
+	if err != nil && strings.HasPrefix(op.Name, "cache.") {
+		level = slog.LevelWarn
+	}
+	if !h.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs := []slog.Attr{slog.String("op", op.Name)}
+	if op.Statement != "" {
+		attrs = append(attrs, slog.String("statement", sanitizeStatement(op.Statement)))
+	}
+	if args, ok := ctx.Value(argsKey{}).([]interface{}); ok {
+		attrs = append(attrs, slog.Any("args", args))
+	}
+	if op.UserID != 0 {
+		attrs = append(attrs, slog.Int("user_id", op.UserID))
+	}
+	if op.Cache != "" {
+		attrs = append(attrs, slog.String("cache", string(op.Cache)))
+	}
+	if op.Rows != 0 {
+		attrs = append(attrs, slog.Int64("rows", op.Rows))
+	}
+	attrs = append(attrs, slog.Duration("duration", duration))
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	h.logger.LogAttrs(ctx, level, "repository operation", attrs...)
+}
+
+// redisLogHook is a redis.Hook logging each command through a logHook's
+// logger. Keys can hold emails, so only the command name is logged unless
+// parameters are.
+type redisLogHook struct {
+	logHook
+}
+
+func (h redisLogHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return next(ctx, network, addr)
+	}
+}
+
+func (h redisLogHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmd)
+		h.log(ctx, cmd, time.Since(start))
+		return err
+	}
+}
+
+func (h redisLogHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		start := time.Now()
+		err := next(ctx, cmds)
+		elapsed := time.Since(start)
+		for _, cmd := range cmds {
+			h.log(ctx, cmd, elapsed)
+		}
+		return err
+	}
+}
+
+// log logs cmd; a pipelined command gets the whole pipeline's duration
+func (h redisLogHook) log(ctx context.Context, cmd redis.Cmder, duration time.Duration) {
+	if !h.logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	attrs := []slog.Attr{slog.String("command", cmd.Name())}
+	if h.params {
+		attrs = append(attrs, slog.Any("args", cmd.Args()))
+	}
+	attrs = append(attrs, slog.Duration("duration", duration))
+	// A missing key is a result, not a failure
+	if err := cmd.Err(); err != nil && err != redis.Nil {
+		attrs = append(attrs, slog.Any("error", err))
+	}
+	h.logger.LogAttrs(ctx, slog.LevelDebug, "redis command", attrs...)
+}
+
+// TestingLogger returns a logger writing debug-level text records to t.Log,
+// so WithLogger's output shows up with a failing test's other output
+func TestingLogger(t testing.TB) *slog.Logger {
+	return slog.New(slog.NewTextHandler(testingWriter{t: t}, &slog.HandlerOptions{Level: slog.LevelDebug}))
+}
+
+// testingWriter writes each record the text handler emits as one t.Log line
+type testingWriter struct {
+	t testing.TB
+}
+
+func (w testingWriter) Write(p []byte) (int, error) {
+	w.t.Helper()
+	w.t.Log(string(bytes.TrimRight(p, "\n")))
+	return len(p), nil
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// recordingHandler is a slog.Handler keeping every record it is given
+type recordingHandler struct {
+	mu      sync.Mutex
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+
+func (h *recordingHandler) Handle(_ context.Context, r slog.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler { return h }
+
+func (h *recordingHandler) WithGroup(string) slog.Handler { return h }
+
+// take returns the recorded entries, each as its attributes by key, and
+// starts over
+func (h *recordingHandler) take() []map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := make([]map[string]string, len(h.records))
+	for i, r := range h.records {
+		entry := map[string]string{"msg": r.Message, "level": r.Level.String()}
+		r.Attrs(func(a slog.Attr) bool {
+			entry[a.Key] = a.Value.String()
+			return true
+		})
+		entries[i] = entry
+	}
+	h.records = nil
+	return entries
+}
+
+// summarize reduces entries to the op or Redis command they log, with the
+// cache result, as in "redis get" or "cache.Get (miss)"
+func summarize(entries []map[string]string) []string {
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		switch {
+		case e["command"] != "":
+			lines[i] = "redis " + e["command"]
+		case e["cache"] != "":
+			lines[i] = e["op"] + " (" + e["cache"] + ")"
+		default:
+			lines[i] = e["op"]
+		}
+	}
+	return lines
+}
+
+// TestLogging tests the log of a cache-miss read, that arguments stay out of
+// it by default, and that writes log the rows they affected
+func TestLogging(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	handler := &recordingHandler{}
+	cachedRepo := NewCachedUserRepository(testDB, redisClient, WithCachedLogger(slog.New(handler)))
+
+	email := fixtures.GenerateEmail(t)
+	user, err := cachedRepo.CreateCached(ctx, email, "Logged User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, user.ID)
+
+	t.Run("Cache Miss", func(t *testing.T) {
+		if err := cachedRepo.InvalidateCache(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to invalidate cache: %v", err)
+		}
+		handler.take()
+
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		entries := handler.take()
+		// The fence is read before the database read and again after the SET
+		want := []string{
+			"redis get",
+			"cache.Get (miss)",
+			"redis get",
+			"db.GetByID",
+			"redis set",
+			"redis get",
+			"cache.Set",
+			"CachedUserRepository.GetByIDCached (miss)",
+		}
+		if got := summarize(entries); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Expected log %v, got: %v", want, got)
+		}
+		for _, e := range entries {
+			if e["level"] != "DEBUG" || e["duration"] == "" || e["error"] != "" {
+				t.Errorf("Expected a successful debug entry with a duration, got: %v", e)
+			}
+		}
+		if statement := entries[3]["statement"]; statement != sanitizeStatement(selectUserByID) {
+			t.Errorf("Expected the select statement, got: %q", statement)
+		}
+	})
+
+	t.Run("Values Redacted", func(t *testing.T) {
+		// The email is both an argument and part of the Redis key
+		if _, err := cachedRepo.IsEmailAvailableCached(ctx, email); err != nil {
+			t.Fatalf("Failed to check email: %v", err)
+		}
+		for _, e := range handler.take() {
+			if _, ok := e["args"]; ok {
+				t.Errorf("Expected no arguments logged, got: %v", e)
+			}
+			for _, v := range e {
+				if strings.Contains(v, email) {
+					t.Errorf("Expected the email kept out of the log, got: %v", e)
+				}
+			}
+		}
+	})
+
+	t.Run("Parameters Opt In", func(t *testing.T) {
+		handler := &recordingHandler{}
+		repo := NewUserRepository(testDB, WithLogger(slog.New(handler), LogParameters()))
+		if err := repo.Update(ctx, user.ID, email, "Logged Again"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		entries := handler.take()
+		if len(entries) != 1 {
+			t.Fatalf("Expected one entry, got: %v", entries)
+		}
+		if e := entries[0]; !strings.Contains(e["args"], email) || e["rows"] != "1" {
+			t.Errorf("Expected the arguments and 1 row affected, got: %v", e)
+		}
+	})
+}
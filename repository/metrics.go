@@ -0,0 +1,64 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"testcontainers-demo/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// WithMetrics counts and times every UserRepository method on reg. It panics
+// if the collectors can't be registered, like prometheus.MustRegister;
+// repositories sharing a registry share the collectors.
+func WithMetrics(reg prometheus.Registerer) Option {
+	m, err := metrics.NewUserRepository(reg)
+	if err != nil {
+		panic(err)
+	}
+	return WithHooks(metricsHook{metrics: m, prefix: "UserRepository."})
+}
+
+// WithCachedMetrics counts and times every CachedUserRepository method on
+// reg, with a cache_result label: hit or miss for cached reads, bypass for
+// methods that don't read the cache
+func WithCachedMetrics(reg prometheus.Registerer) CachedOption {
+	m, err := metrics.NewCachedUserRepository(reg)
+	if err != nil {
+		panic(err)
+	}
+	return WithCachedHooks(metricsHook{metrics: m, prefix: "CachedUserRepository."})
+}
+
+// metricsHook records the repository methods named prefix + method; steps
+// inside a method (cache.Get, db.GetByID, ...) aren't counted
+type metricsHook struct {
+	NopHook
+	metrics *metrics.Metrics
+	prefix  string
+}
+
+func (h metricsHook) After(_ context.Context, op Op, duration time.Duration, err error) {
+	method, ok := strings.CutPrefix(op.Name, h.prefix)
+	if !ok {
+		return
+	}
+	h.metrics.Observe(method, outcome(err), string(op.Cache), duration)
+}
+
+// outcome maps an operation's error to its metrics label
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return metrics.OutcomeOK
+	case errors.Is(err, ErrUserNotFound):
+		return metrics.OutcomeNotFound
+	case errors.Is(err, ErrDuplicateEmail):
+		return metrics.OutcomeConflict
+	default:
+		return metrics.OutcomeError
+	}
+}
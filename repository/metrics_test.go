@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/testhelpers"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// TestMetrics tests the counters recorded for a known sequence of calls
+func TestMetrics(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	existing := newUser(t)
+
+	reg := prometheus.NewRegistry()
+	repo := NewUserRepository(testDB, WithMetrics(reg))
+	t.Cleanup(func() { repo.Close() })
+	cachedRepo := NewCachedUserRepository(testDB, redisClient, WithCachedMetrics(reg))
+
+	repo.GetByID(ctx, existing.ID)
+	repo.GetByID(ctx, existing.ID)
+	repo.GetByID(ctx, missingID)
+	repo.Create(ctx, existing.Email, "Another User")
+	repo.CountUsers(ctx)
+
+	cachedRepo.InvalidateCache(ctx, existing.ID)
+	cachedRepo.GetByIDCached(ctx, existing.ID)
+	cachedRepo.GetByIDCached(ctx, existing.ID)
+	cachedRepo.GetByIDCached(ctx, missingID)
+
+	expected := `
+# HELP user_repository_operations_total Repository operations, by method and outcome.
+# TYPE user_repository_operations_total counter
+user_repository_operations_total{method="CountUsers",outcome="ok"} 1
+user_repository_operations_total{method="Create",outcome="conflict"} 1
+user_repository_operations_total{method="GetByID",outcome="not_found"} 1
+user_repository_operations_total{method="GetByID",outcome="ok"} 2
+# HELP user_repository_errors_total Repository operations that did not succeed, by method and outcome.
+# TYPE user_repository_errors_total counter
+user_repository_errors_total{method="Create",outcome="conflict"} 1
+user_repository_errors_total{method="GetByID",outcome="not_found"} 1
+# HELP cached_user_repository_operations_total Repository operations, by method and outcome.
+# TYPE cached_user_repository_operations_total counter
+cached_user_repository_operations_total{cache_result="bypass",method="InvalidateCache",outcome="ok"} 1
+cached_user_repository_operations_total{cache_result="hit",method="GetByIDCached",outcome="ok"} 1
+cached_user_repository_operations_total{cache_result="miss",method="GetByIDCached",outcome="not_found"} 1
+cached_user_repository_operations_total{cache_result="miss",method="GetByIDCached",outcome="ok"} 1
+# HELP cached_user_repository_errors_total Repository operations that did not succeed, by method and outcome.
+# TYPE cached_user_repository_errors_total counter
+cached_user_repository_errors_total{cache_result="miss",method="GetByIDCached",outcome="not_found"} 1
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"user_repository_operations_total",
+		"user_repository_errors_total",
+		"cached_user_repository_operations_total",
+		"cached_user_repository_errors_total",
+	)
+	if err != nil {
+		t.Errorf("Unexpected metrics:\n%v", err)
+	}
+
+	// One histogram series per (method, outcome[, cache_result]) above
+	for name, want := range map[string]int{
+		"user_repository_operation_duration_seconds":        4,
+		"cached_user_repository_operation_duration_seconds": 4,
+	} {
+		if got, err := testutil.GatherAndCount(reg, name); err != nil || got != want {
+			t.Errorf("Expected %d %s series, got: %d (%v)", want, name, got, err)
+		}
+	}
+
+	t.Run("Shared Registry", func(t *testing.T) {
+		other := NewUserRepository(testDB, WithMetrics(reg))
+		defer other.Close()
+		other.CountUsers(ctx)
+
+		expected := `
+# HELP user_repository_operations_total Repository operations, by method and outcome.
+# TYPE user_repository_operations_total counter
+user_repository_operations_total{method="CountUsers",outcome="ok"} 2
+user_repository_operations_total{method="Create",outcome="conflict"} 1
+user_repository_operations_total{method="GetByID",outcome="not_found"} 1
+user_repository_operations_total{method="GetByID",outcome="ok"} 2
+`
+		if err := testutil.GatherAndCompare(reg, strings.NewReader(expected), "user_repository_operations_total"); err != nil {
+			t.Errorf("Expected both repositories to count into the same series:\n%v", err)
+		}
+	})
+}
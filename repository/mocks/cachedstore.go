@@ -0,0 +1,219 @@
+package mocks
+
+import (
+	"context"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+)
+
+// CachedUserStore is a repository.CachedUserStore whose methods run the
+// matching Func field. Set only the fields a test expects to be called.
+type CachedUserStore struct {
+	recorder
+
+	GetByIDCachedFunc           func(ctx context.Context, id int) (*models.User, error)
+	GetByIDsCachedFunc          func(ctx context.Context, ids []int) ([]models.User, error)
+	GetByEmailCachedFunc        func(ctx context.Context, email string) (*models.User, error)
+	IsEmailAvailableCachedFunc  func(ctx context.Context, email string) (bool, error)
+	CreateCachedFunc            func(ctx context.Context, email, name string) (*models.User, error)
+	UpdateCachedFunc            func(ctx context.Context, id int, email, name string) error
+	UpdateRoleCachedFunc        func(ctx context.Context, id int, role models.Role) error
+	UpdateWithVersionCachedFunc func(ctx context.Context, user *models.User) error
+	ChangePasswordCachedFunc    func(ctx context.Context, id int, oldPassword, newPassword string) error
+	DeleteCachedFunc            func(ctx context.Context, id int) error
+	DeleteUserCascadeFunc       func(ctx context.Context, id int) (*repository.DeleteSummary, error)
+	EraseUserFunc               func(ctx context.Context, id int) error
+	ArchiveInactiveUsersFunc    func(ctx context.Context, olderThan time.Time, batchSize int) (int, error)
+	ChangeEmailDomainFunc       func(ctx context.Context, fromDomain, toDomain string, batchSize int, opts repository.DomainChangeOptions) (*repository.DomainChangeReport, error)
+	InvalidateCacheFunc         func(ctx context.Context, ids ...int) error
+	InvalidateManyFunc          func(ctx context.Context, ids ...int) error
+	InvalidateAllFunc           func(ctx context.Context) (int, error)
+	WarmCacheFunc               func(ctx context.Context, ids []int) error
+	WarmCacheRecentFunc         func(ctx context.Context, days, limit int) error
+	StatsFunc                   func() repository.CacheStats
+	HealthcheckFunc             func(ctx context.Context) error
+	CloseFunc                   func() error
+}
+
+var _ repository.CachedUserStore = (*CachedUserStore)(nil)
+
+// Each method below records its call, then runs its Func field or, if
+// that is nil, returns the notConfigured error
+
+func (m *CachedUserStore) GetByIDCached(ctx context.Context, id int) (*models.User, error) {
+	m.record("GetByIDCached", id)
+	if m.GetByIDCachedFunc == nil {
+		return nil, notConfigured("GetByIDCached")
+	}
+	return m.GetByIDCachedFunc(ctx, id)
+}
+
+func (m *CachedUserStore) GetByIDsCached(ctx context.Context, ids []int) ([]models.User, error) {
+	m.record("GetByIDsCached", ids)
+	if m.GetByIDsCachedFunc == nil {
+		return nil, notConfigured("GetByIDsCached")
+	}
+	return m.GetByIDsCachedFunc(ctx, ids)
+}
+
+func (m *CachedUserStore) GetByEmailCached(ctx context.Context, email string) (*models.User, error) {
+	m.record("GetByEmailCached", email)
+	if m.GetByEmailCachedFunc == nil {
+		return nil, notConfigured("GetByEmailCached")
+	}
+	return m.GetByEmailCachedFunc(ctx, email)
+}
+
+func (m *CachedUserStore) IsEmailAvailableCached(ctx context.Context, email string) (bool, error) {
+	m.record("IsEmailAvailableCached", email)
+	if m.IsEmailAvailableCachedFunc == nil {
+		return false, notConfigured("IsEmailAvailableCached")
+	}
+	return m.IsEmailAvailableCachedFunc(ctx, email)
+}
+
+func (m *CachedUserStore) CreateCached(ctx context.Context, email, name string) (*models.User, error) {
+	m.record("CreateCached", email, name)
+	if m.CreateCachedFunc == nil {
+		return nil, notConfigured("CreateCached")
+	}
+	return m.CreateCachedFunc(ctx, email, name)
+}
+
+func (m *CachedUserStore) UpdateCached(ctx context.Context, id int, email, name string) error {
+	m.record("UpdateCached", id, email, name)
+	if m.UpdateCachedFunc == nil {
+		return notConfigured("UpdateCached")
+	}
+	return m.UpdateCachedFunc(ctx, id, email, name)
+}
+
+func (m *CachedUserStore) UpdateRoleCached(ctx context.Context, id int, role models.Role) error {
+	m.record("UpdateRoleCached", id, role)
+	if m.UpdateRoleCachedFunc == nil {
+		return notConfigured("UpdateRoleCached")
+	}
+	return m.UpdateRoleCachedFunc(ctx, id, role)
+}
+
+func (m *CachedUserStore) UpdateWithVersionCached(ctx context.Context, user *models.User) error {
+	m.record("UpdateWithVersionCached", user)
+	if m.UpdateWithVersionCachedFunc == nil {
+		return notConfigured("UpdateWithVersionCached")
+	}
+	return m.UpdateWithVersionCachedFunc(ctx, user)
+}
+
+func (m *CachedUserStore) ChangePasswordCached(ctx context.Context, id int, oldPassword, newPassword string) error {
+	m.record("ChangePasswordCached", id, oldPassword, newPassword)
+	if m.ChangePasswordCachedFunc == nil {
+		return notConfigured("ChangePasswordCached")
+	}
+	return m.ChangePasswordCachedFunc(ctx, id, oldPassword, newPassword)
+}
+
+func (m *CachedUserStore) DeleteCached(ctx context.Context, id int) error {
+	m.record("DeleteCached", id)
+	if m.DeleteCachedFunc == nil {
+		return notConfigured("DeleteCached")
+	}
+	return m.DeleteCachedFunc(ctx, id)
+}
+
+func (m *CachedUserStore) DeleteUserCascade(ctx context.Context, id int) (*repository.DeleteSummary, error) {
+	m.record("DeleteUserCascade", id)
+	if m.DeleteUserCascadeFunc == nil {
+		return nil, notConfigured("DeleteUserCascade")
+	}
+	return m.DeleteUserCascadeFunc(ctx, id)
+}
+
+func (m *CachedUserStore) EraseUser(ctx context.Context, id int) error {
+	m.record("EraseUser", id)
+	if m.EraseUserFunc == nil {
+		return notConfigured("EraseUser")
+	}
+	return m.EraseUserFunc(ctx, id)
+}
+
+func (m *CachedUserStore) ArchiveInactiveUsers(ctx context.Context, olderThan time.Time, batchSize int) (int, error) {
+	m.record("ArchiveInactiveUsers", olderThan, batchSize)
+	if m.ArchiveInactiveUsersFunc == nil {
+		return 0, notConfigured("ArchiveInactiveUsers")
+	}
+	return m.ArchiveInactiveUsersFunc(ctx, olderThan, batchSize)
+}
+
+func (m *CachedUserStore) ChangeEmailDomain(ctx context.Context, fromDomain, toDomain string, batchSize int, opts repository.DomainChangeOptions) (*repository.DomainChangeReport, error) {
+	m.record("ChangeEmailDomain", fromDomain, toDomain, batchSize, opts)
+	if m.ChangeEmailDomainFunc == nil {
+		return nil, notConfigured("ChangeEmailDomain")
+	}
+	return m.ChangeEmailDomainFunc(ctx, fromDomain, toDomain, batchSize, opts)
+}
+
+func (m *CachedUserStore) InvalidateCache(ctx context.Context, ids ...int) error {
+	m.record("InvalidateCache", ids)
+	if m.InvalidateCacheFunc == nil {
+		return notConfigured("InvalidateCache")
+	}
+	return m.InvalidateCacheFunc(ctx, ids...)
+}
+
+func (m *CachedUserStore) InvalidateMany(ctx context.Context, ids ...int) error {
+	m.record("InvalidateMany", ids)
+	if m.InvalidateManyFunc == nil {
+		return notConfigured("InvalidateMany")
+	}
+	return m.InvalidateManyFunc(ctx, ids...)
+}
+
+func (m *CachedUserStore) InvalidateAll(ctx context.Context) (int, error) {
+	m.record("InvalidateAll")
+	if m.InvalidateAllFunc == nil {
+		return 0, notConfigured("InvalidateAll")
+	}
+	return m.InvalidateAllFunc(ctx)
+}
+
+func (m *CachedUserStore) WarmCache(ctx context.Context, ids []int) error {
+	m.record("WarmCache", ids)
+	if m.WarmCacheFunc == nil {
+		return notConfigured("WarmCache")
+	}
+	return m.WarmCacheFunc(ctx, ids)
+}
+
+func (m *CachedUserStore) WarmCacheRecent(ctx context.Context, days, limit int) error {
+	m.record("WarmCacheRecent", days, limit)
+	if m.WarmCacheRecentFunc == nil {
+		return notConfigured("WarmCacheRecent")
+	}
+	return m.WarmCacheRecentFunc(ctx, days, limit)
+}
+
+func (m *CachedUserStore) Stats() repository.CacheStats {
+	m.record("Stats")
+	if m.StatsFunc == nil {
+		return repository.CacheStats{}
+	}
+	return m.StatsFunc()
+}
+
+func (m *CachedUserStore) Healthcheck(ctx context.Context) error {
+	m.record("Healthcheck")
+	if m.HealthcheckFunc == nil {
+		return notConfigured("Healthcheck")
+	}
+	return m.HealthcheckFunc(ctx)
+}
+
+func (m *CachedUserStore) Close() error {
+	m.record("Close")
+	if m.CloseFunc == nil {
+		return notConfigured("Close")
+	}
+	return m.CloseFunc()
+}
@@ -0,0 +1,91 @@
+// Package mocks has hand-written test doubles of the repository interfaces,
+// for unit tests of code built on them that shouldn't need Postgres or Redis:
+//
+//	store := &mocks.UserStore{
+//		GetByIDFunc: func(ctx context.Context, id int) (*models.User, error) {
+//			return nil, repository.ErrUserNotFound
+//		},
+//	}
+//	handler := api.NewServer(store)
+//	...
+//	if calls := store.CallsTo("GetByID"); len(calls) != 1 || calls[0].Args[0] != 42 { ... }
+//
+// Each method records its call, then runs the matching Func field. A method
+// whose field is nil returns zero values and an error wrapping
+// ErrNotConfigured that names it, so an unexpected call fails the test
+// rather than passing quietly. The mocks are safe for concurrent use once
+// their fields are set.
+package mocks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"testcontainers-demo/models"
+)
+
+// ErrNotConfigured is returned, wrapped, by a mock method whose Func field
+// is nil
+var ErrNotConfigured = errors.New("mock method not configured")
+
+// Call is one recorded call: the method and its arguments after the context
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// recorder records the calls of a mock
+type recorder struct {
+	mu    sync.Mutex
+	calls []Call
+}
+
+func (r *recorder) record(method string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
+
+// Calls returns every call so far, oldest first
+func (r *recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Call(nil), r.calls...)
+}
+
+// CallsTo returns the calls so far of method, oldest first
+func (r *recorder) CallsTo(method string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var calls []Call
+	for _, c := range r.calls {
+		if c.Method == method {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// Reset forgets the calls so far
+func (r *recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = nil
+}
+
+// notConfigured is the error of a call to method with a nil Func field
+func notConfigured(method string) error {
+	return fmt.Errorf("%w: %s", ErrNotConfigured, method)
+}
+
+// notConfiguredChan is what ListChan returns with a nil ListChanFunc: no
+// users, then the notConfigured error
+func notConfiguredChan(method string) (<-chan models.User, <-chan error) {
+	users := make(chan models.User)
+	close(users)
+	errs := make(chan error, 1)
+	errs <- notConfigured(method)
+	close(errs)
+	return users, errs
+}
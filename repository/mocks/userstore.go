@@ -0,0 +1,368 @@
+package mocks
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+
+	"github.com/google/uuid"
+)
+
+// UserStore is a repository.PostgresUserStore, and so a
+// repository.UserStore, whose methods run the matching Func field. Set only
+// the fields a test expects to be called.
+type UserStore struct {
+	recorder
+
+	GetByIDFunc                 func(ctx context.Context, id int) (*models.User, error)
+	GetByUUIDFunc               func(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByEmailFunc              func(ctx context.Context, email string) (*models.User, error)
+	CreateFunc                  func(ctx context.Context, email, name string) (*models.User, error)
+	CreateWithRoleFunc          func(ctx context.Context, email, name string, role models.Role) (*models.User, error)
+	UpdateFunc                  func(ctx context.Context, id int, email, name string) error
+	UpdateWithRoleFunc          func(ctx context.Context, id int, email, name string, role models.Role) error
+	DeleteFunc                  func(ctx context.Context, id int) error
+	ListFunc                    func(ctx context.Context) ([]models.User, error)
+	ListPaginatedFunc           func(ctx context.Context, cursor string, limit int) (repository.UserPage, error)
+	FindByNamePatternFunc       func(ctx context.Context, pattern string) ([]models.User, error)
+	CountUsersFunc              func(ctx context.Context) (int, error)
+	ListByRoleFunc              func(ctx context.Context, role models.Role, cursor string, limit int) (repository.UserPage, error)
+	CountByRoleFunc             func(ctx context.Context) (map[models.Role]int, error)
+	GetRecentUsersFunc          func(ctx context.Context, days int, cursor string, limit int, opts ...repository.QueryOption) (repository.UserPage, error)
+	CreateBatchFunc             func(ctx context.Context, users []repository.CreateUserInput) ([]models.User, error)
+	UpdateWithVersionFunc       func(ctx context.Context, user *models.User) error
+	ListOrderedFunc             func(ctx context.Context, orderBy repository.OrderField, direction repository.Direction) ([]models.User, error)
+	ListEachFunc                func(ctx context.Context, fn func(models.User) error) error
+	ListChanFunc                func(ctx context.Context, buf int) (<-chan models.User, <-chan error)
+	CreateAndNotifyFunc         func(ctx context.Context, email, name string) (*models.User, error)
+	CloseFunc                   func() error
+	ListFilteredFunc            func(ctx context.Context, f repository.Filter, page repository.PageOpts, opts ...repository.QueryOption) ([]models.User, error)
+	IsEmailAvailableFunc        func(ctx context.Context, email string) (bool, error)
+	HealthcheckFunc             func(ctx context.Context) error
+	SetAvatarKeyFunc            func(ctx context.Context, id int, avatarKey string) error
+	CreateIdempotentFunc        func(ctx context.Context, idempotencyKey, email, name string) (*models.User, error)
+	PurgeIdempotencyKeysFunc    func(ctx context.Context) (int, error)
+	GetByIDForUpdateFunc        func(ctx context.Context, tx *sql.Tx, id int) (*models.User, error)
+	GetByIDForUpdateNoWaitFunc  func(ctx context.Context, tx *sql.Tx, id int) (*models.User, error)
+	GetUsersWithOrderCountsFunc func(ctx context.Context) ([]repository.UserWithStats, error)
+	GetTopSpendersFunc          func(ctx context.Context, limit int) ([]repository.UserWithStats, error)
+	CreateWithPasswordFunc      func(ctx context.Context, email, name, password string) (*models.User, error)
+	AuthenticateFunc            func(ctx context.Context, email, password string) (*models.User, error)
+	ChangePasswordFunc          func(ctx context.Context, id int, oldPassword, newPassword string) error
+	GetUserStatsFunc            func(ctx context.Context, from, to time.Time) (*repository.UserStats, error)
+	ExportUsersFunc             func(ctx context.Context, w io.Writer, format repository.Format) error
+	ImportUsersFunc             func(ctx context.Context, rd io.Reader, format repository.Format, opts repository.ImportOptions) (repository.ImportSummary, error)
+}
+
+var _ repository.PostgresUserStore = (*UserStore)(nil)
+
+// Each method below records its call, then runs its Func field or, if
+// that is nil, returns the notConfigured error
+
+func (m *UserStore) GetByID(ctx context.Context, id int) (*models.User, error) {
+	m.record("GetByID", id)
+	if m.GetByIDFunc == nil {
+		return nil, notConfigured("GetByID")
+	}
+	return m.GetByIDFunc(ctx, id)
+}
+
+func (m *UserStore) GetByUUID(ctx context.Context, id uuid.UUID) (*models.User, error) {
+	m.record("GetByUUID", id)
+	if m.GetByUUIDFunc == nil {
+		return nil, notConfigured("GetByUUID")
+	}
+	return m.GetByUUIDFunc(ctx, id)
+}
+
+func (m *UserStore) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	m.record("GetByEmail", email)
+	if m.GetByEmailFunc == nil {
+		return nil, notConfigured("GetByEmail")
+	}
+	return m.GetByEmailFunc(ctx, email)
+}
+
+func (m *UserStore) Create(ctx context.Context, email, name string) (*models.User, error) {
+	m.record("Create", email, name)
+	if m.CreateFunc == nil {
+		return nil, notConfigured("Create")
+	}
+	return m.CreateFunc(ctx, email, name)
+}
+
+func (m *UserStore) CreateWithRole(ctx context.Context, email, name string, role models.Role) (*models.User, error) {
+	m.record("CreateWithRole", email, name, role)
+	if m.CreateWithRoleFunc == nil {
+		return nil, notConfigured("CreateWithRole")
+	}
+	return m.CreateWithRoleFunc(ctx, email, name, role)
+}
+
+func (m *UserStore) Update(ctx context.Context, id int, email, name string) error {
+	m.record("Update", id, email, name)
+	if m.UpdateFunc == nil {
+		return notConfigured("Update")
+	}
+	return m.UpdateFunc(ctx, id, email, name)
+}
+
+func (m *UserStore) UpdateWithRole(ctx context.Context, id int, email, name string, role models.Role) error {
+	m.record("UpdateWithRole", id, email, name, role)
+	if m.UpdateWithRoleFunc == nil {
+		return notConfigured("UpdateWithRole")
+	}
+	return m.UpdateWithRoleFunc(ctx, id, email, name, role)
+}
+
+func (m *UserStore) Delete(ctx context.Context, id int) error {
+	m.record("Delete", id)
+	if m.DeleteFunc == nil {
+		return notConfigured("Delete")
+	}
+	return m.DeleteFunc(ctx, id)
+}
+
+func (m *UserStore) List(ctx context.Context) ([]models.User, error) {
+	m.record("List")
+	if m.ListFunc == nil {
+		return nil, notConfigured("List")
+	}
+	return m.ListFunc(ctx)
+}
+
+func (m *UserStore) ListPaginated(ctx context.Context, cursor string, limit int) (repository.UserPage, error) {
+	m.record("ListPaginated", cursor, limit)
+	if m.ListPaginatedFunc == nil {
+		return repository.UserPage{}, notConfigured("ListPaginated")
+	}
+	return m.ListPaginatedFunc(ctx, cursor, limit)
+}
+
+func (m *UserStore) FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error) {
+	m.record("FindByNamePattern", pattern)
+	if m.FindByNamePatternFunc == nil {
+		return nil, notConfigured("FindByNamePattern")
+	}
+	return m.FindByNamePatternFunc(ctx, pattern)
+}
+
+func (m *UserStore) CountUsers(ctx context.Context) (int, error) {
+	m.record("CountUsers")
+	if m.CountUsersFunc == nil {
+		return 0, notConfigured("CountUsers")
+	}
+	return m.CountUsersFunc(ctx)
+}
+
+func (m *UserStore) ListByRole(ctx context.Context, role models.Role, cursor string, limit int) (repository.UserPage, error) {
+	m.record("ListByRole", role, cursor, limit)
+	if m.ListByRoleFunc == nil {
+		return repository.UserPage{}, notConfigured("ListByRole")
+	}
+	return m.ListByRoleFunc(ctx, role, cursor, limit)
+}
+
+func (m *UserStore) CountByRole(ctx context.Context) (map[models.Role]int, error) {
+	m.record("CountByRole")
+	if m.CountByRoleFunc == nil {
+		return nil, notConfigured("CountByRole")
+	}
+	return m.CountByRoleFunc(ctx)
+}
+
+func (m *UserStore) GetRecentUsers(ctx context.Context, days int, cursor string, limit int, opts ...repository.QueryOption) (repository.UserPage, error) {
+	m.record("GetRecentUsers", days, cursor, limit, opts)
+	if m.GetRecentUsersFunc == nil {
+		return repository.UserPage{}, notConfigured("GetRecentUsers")
+	}
+	return m.GetRecentUsersFunc(ctx, days, cursor, limit, opts...)
+}
+
+func (m *UserStore) CreateBatch(ctx context.Context, users []repository.CreateUserInput) ([]models.User, error) {
+	m.record("CreateBatch", users)
+	if m.CreateBatchFunc == nil {
+		return nil, notConfigured("CreateBatch")
+	}
+	return m.CreateBatchFunc(ctx, users)
+}
+
+func (m *UserStore) UpdateWithVersion(ctx context.Context, user *models.User) error {
+	m.record("UpdateWithVersion", user)
+	if m.UpdateWithVersionFunc == nil {
+		return notConfigured("UpdateWithVersion")
+	}
+	return m.UpdateWithVersionFunc(ctx, user)
+}
+
+func (m *UserStore) ListOrdered(ctx context.Context, orderBy repository.OrderField, direction repository.Direction) ([]models.User, error) {
+	m.record("ListOrdered", orderBy, direction)
+	if m.ListOrderedFunc == nil {
+		return nil, notConfigured("ListOrdered")
+	}
+	return m.ListOrderedFunc(ctx, orderBy, direction)
+}
+
+func (m *UserStore) ListEach(ctx context.Context, fn func(models.User) error) error {
+	m.record("ListEach", fn)
+	if m.ListEachFunc == nil {
+		return notConfigured("ListEach")
+	}
+	return m.ListEachFunc(ctx, fn)
+}
+
+func (m *UserStore) ListChan(ctx context.Context, buf int) (<-chan models.User, <-chan error) {
+	m.record("ListChan", buf)
+	if m.ListChanFunc == nil {
+		return notConfiguredChan("ListChan")
+	}
+	return m.ListChanFunc(ctx, buf)
+}
+
+func (m *UserStore) CreateAndNotify(ctx context.Context, email, name string) (*models.User, error) {
+	m.record("CreateAndNotify", email, name)
+	if m.CreateAndNotifyFunc == nil {
+		return nil, notConfigured("CreateAndNotify")
+	}
+	return m.CreateAndNotifyFunc(ctx, email, name)
+}
+
+func (m *UserStore) Close() error {
+	m.record("Close")
+	if m.CloseFunc == nil {
+		return notConfigured("Close")
+	}
+	return m.CloseFunc()
+}
+
+func (m *UserStore) ListFiltered(ctx context.Context, f repository.Filter, page repository.PageOpts, opts ...repository.QueryOption) ([]models.User, error) {
+	m.record("ListFiltered", f, page, opts)
+	if m.ListFilteredFunc == nil {
+		return nil, notConfigured("ListFiltered")
+	}
+	return m.ListFilteredFunc(ctx, f, page, opts...)
+}
+
+func (m *UserStore) IsEmailAvailable(ctx context.Context, email string) (bool, error) {
+	m.record("IsEmailAvailable", email)
+	if m.IsEmailAvailableFunc == nil {
+		return false, notConfigured("IsEmailAvailable")
+	}
+	return m.IsEmailAvailableFunc(ctx, email)
+}
+
+func (m *UserStore) Healthcheck(ctx context.Context) error {
+	m.record("Healthcheck")
+	if m.HealthcheckFunc == nil {
+		return notConfigured("Healthcheck")
+	}
+	return m.HealthcheckFunc(ctx)
+}
+
+func (m *UserStore) SetAvatarKey(ctx context.Context, id int, avatarKey string) error {
+	m.record("SetAvatarKey", id, avatarKey)
+	if m.SetAvatarKeyFunc == nil {
+		return notConfigured("SetAvatarKey")
+	}
+	return m.SetAvatarKeyFunc(ctx, id, avatarKey)
+}
+
+func (m *UserStore) CreateIdempotent(ctx context.Context, idempotencyKey, email, name string) (*models.User, error) {
+	m.record("CreateIdempotent", idempotencyKey, email, name)
+	if m.CreateIdempotentFunc == nil {
+		return nil, notConfigured("CreateIdempotent")
+	}
+	return m.CreateIdempotentFunc(ctx, idempotencyKey, email, name)
+}
+
+func (m *UserStore) PurgeIdempotencyKeys(ctx context.Context) (int, error) {
+	m.record("PurgeIdempotencyKeys")
+	if m.PurgeIdempotencyKeysFunc == nil {
+		return 0, notConfigured("PurgeIdempotencyKeys")
+	}
+	return m.PurgeIdempotencyKeysFunc(ctx)
+}
+
+func (m *UserStore) GetByIDForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.User, error) {
+	m.record("GetByIDForUpdate", tx, id)
+	if m.GetByIDForUpdateFunc == nil {
+		return nil, notConfigured("GetByIDForUpdate")
+	}
+	return m.GetByIDForUpdateFunc(ctx, tx, id)
+}
+
+func (m *UserStore) GetByIDForUpdateNoWait(ctx context.Context, tx *sql.Tx, id int) (*models.User, error) {
+	m.record("GetByIDForUpdateNoWait", tx, id)
+	if m.GetByIDForUpdateNoWaitFunc == nil {
+		return nil, notConfigured("GetByIDForUpdateNoWait")
+	}
+	return m.GetByIDForUpdateNoWaitFunc(ctx, tx, id)
+}
+
+func (m *UserStore) GetUsersWithOrderCounts(ctx context.Context) ([]repository.UserWithStats, error) {
+	m.record("GetUsersWithOrderCounts")
+	if m.GetUsersWithOrderCountsFunc == nil {
+		return nil, notConfigured("GetUsersWithOrderCounts")
+	}
+	return m.GetUsersWithOrderCountsFunc(ctx)
+}
+
+func (m *UserStore) GetTopSpenders(ctx context.Context, limit int) ([]repository.UserWithStats, error) {
+	m.record("GetTopSpenders", limit)
+	if m.GetTopSpendersFunc == nil {
+		return nil, notConfigured("GetTopSpenders")
+	}
+	return m.GetTopSpendersFunc(ctx, limit)
+}
+
+func (m *UserStore) CreateWithPassword(ctx context.Context, email, name, password string) (*models.User, error) {
+	m.record("CreateWithPassword", email, name, password)
+	if m.CreateWithPasswordFunc == nil {
+		return nil, notConfigured("CreateWithPassword")
+	}
+	return m.CreateWithPasswordFunc(ctx, email, name, password)
+}
+
+func (m *UserStore) Authenticate(ctx context.Context, email, password string) (*models.User, error) {
+	m.record("Authenticate", email, password)
+	if m.AuthenticateFunc == nil {
+		return nil, notConfigured("Authenticate")
+	}
+	return m.AuthenticateFunc(ctx, email, password)
+}
+
+func (m *UserStore) ChangePassword(ctx context.Context, id int, oldPassword, newPassword string) error {
+	m.record("ChangePassword", id, oldPassword, newPassword)
+	if m.ChangePasswordFunc == nil {
+		return notConfigured("ChangePassword")
+	}
+	return m.ChangePasswordFunc(ctx, id, oldPassword, newPassword)
+}
+
+func (m *UserStore) GetUserStats(ctx context.Context, from, to time.Time) (*repository.UserStats, error) {
+	m.record("GetUserStats", from, to)
+	if m.GetUserStatsFunc == nil {
+		return nil, notConfigured("GetUserStats")
+	}
+	return m.GetUserStatsFunc(ctx, from, to)
+}
+
+func (m *UserStore) ExportUsers(ctx context.Context, w io.Writer, format repository.Format) error {
+	m.record("ExportUsers", w, format)
+	if m.ExportUsersFunc == nil {
+		return notConfigured("ExportUsers")
+	}
+	return m.ExportUsersFunc(ctx, w, format)
+}
+
+func (m *UserStore) ImportUsers(ctx context.Context, rd io.Reader, format repository.Format, opts repository.ImportOptions) (repository.ImportSummary, error) {
+	m.record("ImportUsers", rd, format, opts)
+	if m.ImportUsersFunc == nil {
+		return repository.ImportSummary{}, notConfigured("ImportUsers")
+	}
+	return m.ImportUsersFunc(ctx, rd, format, opts)
+}
@@ -0,0 +1,153 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"testcontainers-demo/models"
+)
+
+// OrderRepository handles database operations for orders. Orders belong to
+// users, and a user with orders can't be deleted (ErrHasOrders).
+type OrderRepository struct {
+	db DBTX
+}
+
+// NewOrderRepository creates a new order repository
+func NewOrderRepository(db DBTX) *OrderRepository {
+	return &OrderRepository{db: db}
+}
+
+// orderRowColumns are the orders columns, in models.Order's field order
+const orderRowColumns = "id, user_id, amount, created_at"
+
+// Create records an order of amount cents for user userID. It returns a
+// ValidationError for an amount that isn't positive, and ErrUserNotFound
+// for a user that doesn't exist.
+func (r *OrderRepository) Create(ctx context.Context, userID int, amount int64) (*models.Order, error) {
+	const op = "OrderRepository.Create"
+	key := fmt.Sprintf("userID=%d", userID)
+	if amount <= 0 {
+		return nil, newRepoError(op, key, &ValidationError{Fields: []FieldError{
+			{Field: "amount", Message: "must be positive"},
+		}})
+	}
+
+	query := "INSERT INTO orders (user_id, amount) VALUES ($1, $2) RETURNING " + orderRowColumns
+	var order models.Order
+	err := r.db.QueryRowContext(ctx, query, userID, amount).Scan(&order.ID, &order.UserID, &order.Amount, &order.CreatedAt)
+	if isForeignKeyViolation(err) {
+		return nil, newRepoError(op, key, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to create order: %w", err))
+	}
+	return &order, nil
+}
+
+// ListByUser returns user userID's orders, oldest first; none for a user
+// without orders or one that doesn't exist
+func (r *OrderRepository) ListByUser(ctx context.Context, userID int) (_ []models.Order, err error) {
+	const op = "OrderRepository.ListByUser"
+	key := fmt.Sprintf("userID=%d", userID)
+	query := "SELECT " + orderRowColumns + " FROM orders WHERE user_id = $1 ORDER BY id"
+
+	rows, err := r.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to list orders: %w", err))
+	}
+	defer closeRows(rows, &err)
+
+	orders := []models.Order{}
+	for rows.Next() {
+		var order models.Order
+		if err := rows.Scan(&order.ID, &order.UserID, &order.Amount, &order.CreatedAt); err != nil {
+			return nil, newRepoError(op, key, fmt.Errorf("failed to scan order: %w", err))
+		}
+		orders = append(orders, order)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("error iterating orders: %w", err))
+	}
+	return orders, nil
+}
+
+// UserWithStats is a user with the totals of their orders
+type UserWithStats struct {
+	models.User
+	OrderCount int   `json:"order_count"`
+	TotalSpent int64 `json:"total_spent"` // in cents
+}
+
+// selectUsersWithOrderStats aggregates the orders of each user of tenant
+// $1. The LEFT JOIN keeps users without orders, with a count and total of 0.
+var selectUsersWithOrderStats = `
+	SELECT ` + prefixedUserColumns("u") + `, COUNT(o.id), COALESCE(SUM(o.amount), 0)
+	FROM users u
+	LEFT JOIN orders o ON o.user_id = u.id
+	WHERE u.tenant_id = $1
+	GROUP BY u.id
+`
+
+// GetUsersWithOrderCounts returns every user with their order count and
+// total spent, by ID; users without orders have zeros
+func (r *UserRepository) GetUsersWithOrderCounts(ctx context.Context) (_ []UserWithStats, err error) {
+	const op = "UserRepository.GetUsersWithOrderCounts"
+	query := selectUsersWithOrderStats + " ORDER BY u.id"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	users, err := r.queryUsersWithStats(ctx, query, r.tenant)
+	if err != nil {
+		return nil, newRepoError(op, "", err)
+	}
+	return users, nil
+}
+
+// GetTopSpenders returns up to limit users who have orders, by total spent,
+// most first, then by ID
+func (r *UserRepository) GetTopSpenders(ctx context.Context, limit int) (_ []UserWithStats, err error) {
+	const op = "UserRepository.GetTopSpenders"
+	key := fmt.Sprintf("limit=%d", limit)
+	query := selectUsersWithOrderStats + `
+		HAVING COUNT(o.id) > 0
+		ORDER BY SUM(o.amount) DESC, u.id
+		LIMIT $2
+	`
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, limit)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if limit <= 0 {
+		return nil, newRepoError(op, key, &ValidationError{Fields: []FieldError{
+			{Field: "limit", Message: "must be positive"},
+		}})
+	}
+	users, err := r.queryUsersWithStats(ctx, query, r.tenant, limit)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	return users, nil
+}
+
+// queryUsersWithStats runs a selectUsersWithOrderStats query on the reads
+// connection and scans its rows
+func (r *UserRepository) queryUsersWithStats(ctx context.Context, query string, args ...interface{}) (_ []UserWithStats, err error) {
+	rows, err := r.reads().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query order stats: %w", err)
+	}
+	defer closeRows(rows, &err)
+
+	users := []UserWithStats{}
+	for rows.Next() {
+		var u UserWithStats
+		if err := rows.Scan(append(userFields(&u.User), &u.OrderCount, &u.TotalSpent)...); err != nil {
+			return nil, fmt.Errorf("failed to scan user stats: %w", err)
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user stats: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,174 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestOrders tests orders and the per-user aggregates over a small graph of
+// users and orders, in a database of its own so the totals are exact
+func TestOrders(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+	orders := NewOrderRepository(db)
+
+	seeded := fixtures.SeedUsers(t, db,
+		fixtures.NewUser().WithEmail("alice@orders.test"),
+		fixtures.NewUser().WithEmail("bob@orders.test"),
+		fixtures.NewUser().WithEmail("carol@orders.test"), // no orders
+		fixtures.NewUser().WithEmail("dave@orders.test"),
+	)
+	alice, bob, carol, dave := seeded[0], seeded[1], seeded[2], seeded[3]
+	for _, o := range []struct {
+		userID int
+		amount int64
+	}{
+		{alice.ID, 1000}, {alice.ID, 2500}, {alice.ID, 500},
+		{bob.ID, 9000},
+		{dave.ID, 2000}, {dave.ID, 2000},
+	} {
+		if _, err := orders.Create(ctx, o.userID, o.amount); err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+	}
+
+	t.Run("List By User", func(t *testing.T) {
+		got, err := orders.ListByUser(ctx, alice.ID)
+		if err != nil {
+			t.Fatalf("Failed to list orders: %v", err)
+		}
+		var amounts []int64
+		for _, o := range got {
+			if o.UserID != alice.ID || o.CreatedAt.IsZero() {
+				t.Errorf("Expected an order of user %d, got: %+v", alice.ID, o)
+			}
+			amounts = append(amounts, o.Amount)
+		}
+		if want := []int64{1000, 2500, 500}; !slices.Equal(amounts, want) {
+			t.Errorf("Expected amounts %v oldest first, got: %v", want, amounts)
+		}
+
+		if got, err := orders.ListByUser(ctx, carol.ID); err != nil || len(got) != 0 {
+			t.Errorf("Expected no orders for carol, got: %v, %v", got, err)
+		}
+	})
+
+	t.Run("Create Rejects Bad Orders", func(t *testing.T) {
+		var verr *ValidationError
+		if _, err := orders.Create(ctx, alice.ID, 0); !errors.As(err, &verr) {
+			t.Errorf("Expected a ValidationError for a zero amount, got: %v", err)
+		}
+		if _, err := orders.Create(ctx, missingID, 100); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound for a missing user, got: %v", err)
+		}
+	})
+
+	t.Run("Order Counts Include Users Without Orders", func(t *testing.T) {
+		stats, err := repo.GetUsersWithOrderCounts(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get order counts: %v", err)
+		}
+		total, err := repo.CountUsers(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		if len(stats) != total {
+			t.Errorf("Expected all %d users, got: %d", total, len(stats))
+		}
+
+		want := map[int][2]int64{alice.ID: {3, 4000}, bob.ID: {1, 9000}, carol.ID: {0, 0}, dave.ID: {2, 4000}}
+		for _, s := range stats {
+			w, ok := want[s.ID]
+			if !ok {
+				// A seed user, without orders
+				if s.OrderCount != 0 || s.TotalSpent != 0 {
+					t.Errorf("Expected no orders for user %d, got: %+v", s.ID, s)
+				}
+				continue
+			}
+			if int64(s.OrderCount) != w[0] || s.TotalSpent != w[1] || s.Email == "" {
+				t.Errorf("Expected user %d with %d orders totalling %d, got: %+v", s.ID, w[0], w[1], s)
+			}
+			delete(want, s.ID)
+		}
+		if len(want) != 0 {
+			t.Errorf("Expected every seeded user, missing: %v", want)
+		}
+	})
+
+	t.Run("Top Spenders", func(t *testing.T) {
+		top, err := repo.GetTopSpenders(ctx, 10)
+		if err != nil {
+			t.Fatalf("Failed to get top spenders: %v", err)
+		}
+		var ids []int
+		for _, s := range top {
+			ids = append(ids, s.ID)
+		}
+		// alice and dave tie on 4000, so the lower ID comes first
+		if want := []int{bob.ID, alice.ID, dave.ID}; !slices.Equal(ids, want) {
+			t.Errorf("Expected top spenders %v, got: %v", want, ids)
+		}
+		if len(top) > 0 && (top[0].TotalSpent != 9000 || top[0].OrderCount != 1) {
+			t.Errorf("Expected bob first with 9000 in 1 order, got: %+v", top[0])
+		}
+
+		if top, err := repo.GetTopSpenders(ctx, 1); err != nil || len(top) != 1 || top[0].ID != bob.ID {
+			t.Errorf("Expected only bob, got: %v, %v", top, err)
+		}
+		var verr *ValidationError
+		if _, err := repo.GetTopSpenders(ctx, 0); !errors.As(err, &verr) {
+			t.Errorf("Expected a ValidationError for a limit of 0, got: %v", err)
+		}
+	})
+
+	t.Run("Delete Blocked By Orders", func(t *testing.T) {
+		cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t))
+		if err := repo.Delete(ctx, bob.ID); !errors.Is(err, ErrHasOrders) {
+			t.Errorf("Expected ErrHasOrders from Delete, got: %v", err)
+		}
+		if err := cachedRepo.DeleteCached(ctx, bob.ID); !errors.Is(err, ErrHasOrders) {
+			t.Errorf("Expected ErrHasOrders from DeleteCached, got: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, bob.ID); err != nil {
+			t.Errorf("Expected bob kept, got: %v", err)
+		}
+		if got, err := orders.ListByUser(ctx, bob.ID); err != nil || len(got) != 1 {
+			t.Errorf("Expected bob's order kept, got: %v, %v", got, err)
+		}
+
+		if err := repo.Delete(ctx, carol.ID); err != nil {
+			t.Errorf("Expected carol, without orders, deleted, got: %v", err)
+		}
+	})
+
+	t.Run("Archive Skips Users With Orders", func(t *testing.T) {
+		cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t))
+		old := fixtures.SeedUsers(t, db,
+			fixtures.NewUser().WithCreatedAt(time.Now().AddDate(-3, 0, 0)),
+			fixtures.NewUser().WithCreatedAt(time.Now().AddDate(-3, 0, 0)),
+		)
+		if _, err := orders.Create(ctx, old[0].ID, 100); err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+
+		if _, err := cachedRepo.ArchiveInactiveUsers(ctx, time.Now().AddDate(-2, 0, 0), 10); err != nil {
+			t.Fatalf("Failed to archive users: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, old[0].ID); err != nil {
+			t.Errorf("Expected the user with orders kept, got: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, old[1].ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected the user without orders archived, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,19 @@
+package repository
+
+import "testcontainers-demo/models"
+
+// userEventPayload renders a users row as models.User marshals it, for the
+// user field of a models.UserEvent, with created_at in UTC as the scans
+// normalize it
+const userEventPayload = `jsonb_build_object(
+	'id', id, 'uuid', uuid, 'email', email, 'name', name, 'role', role,
+	'created_at', to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS.US"Z"'))`
+
+// insertUserEvent is an INSERT into the user_events outbox recording
+// eventType for every row of u, a CTE over a users write ending in RETURNING
+// id, uuid, email, name, role, created_at. Both run as one statement, so the
+// event is committed exactly when the write is, with or without WithTx.
+func insertUserEvent(eventType models.EventType) string {
+	return `INSERT INTO user_events (user_id, event_type, payload)
+		SELECT id, '` + string(eventType) + `', ` + userEventPayload + ` FROM u`
+}
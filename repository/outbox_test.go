@@ -0,0 +1,151 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// outboxEvents returns the events recorded for userID, oldest first
+func outboxEvents(t *testing.T, userID int) []models.UserEvent {
+	t.Helper()
+
+	rows, err := testDB.Query("SELECT id, event_type, user_id, payload FROM user_events WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		t.Fatalf("Failed to read user_events: %v", err)
+	}
+	defer rows.Close()
+
+	var events []models.UserEvent
+	for rows.Next() {
+		var event models.UserEvent
+		var payload []byte
+		if err := rows.Scan(&event.ID, &event.Type, &event.UserID, &payload); err != nil {
+			t.Fatalf("Failed to scan event: %v", err)
+		}
+		if err := json.Unmarshal(payload, &event.User); err != nil {
+			t.Fatalf("Failed to decode payload %s: %v", payload, err)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Failed to read user_events: %v", err)
+	}
+	return events
+}
+
+// eventTypes lists the types of events, in order
+func eventTypes(events []models.UserEvent) []models.EventType {
+	var types []models.EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+// TestOutbox tests that writes record their events atomically, and failed writes record none
+func TestOutbox(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+
+	user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Events User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, user.ID)
+
+	t.Run("Create Update Delete", func(t *testing.T) {
+		if err := repo.UpdateWithRole(ctx, user.ID, user.Email, "Events Admin", models.RoleAdmin); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		events := outboxEvents(t, user.ID)
+		want := []models.EventType{models.EventUserCreated, models.EventUserUpdated, models.EventUserDeleted}
+		if got := eventTypes(events); len(got) != 3 || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+			t.Fatalf("Expected events %v, got: %v", want, got)
+		}
+
+		created := events[0].User
+		if created.ID != user.ID || created.UUID != user.UUID || created.Email != user.Email ||
+			created.Role != models.RoleMember || !created.CreatedAt.Equal(user.CreatedAt) {
+			t.Errorf("Expected the created user %+v, got: %+v", *user, created)
+		}
+		if updated := events[1].User; updated.Name != "Events Admin" || updated.Role != models.RoleAdmin {
+			t.Errorf("Expected the updated user, got: %+v", updated)
+		}
+		if deleted := events[2].User; deleted.Name != "Events Admin" {
+			t.Errorf("Expected the last state of the deleted user, got: %+v", deleted)
+		}
+	})
+
+	t.Run("Failed Writes Record Nothing", func(t *testing.T) {
+		// Inserted directly, so they have no events of their own
+		a, b := newUser(t), newUser(t)
+
+		if _, err := repo.Create(ctx, a.Email, "Duplicate User"); !errors.Is(err, ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		if err := repo.Update(ctx, b.ID, a.Email, b.Name); !errors.Is(err, ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		if err := repo.Delete(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+		}
+
+		for _, id := range []int{a.ID, b.ID, missingID} {
+			if events := outboxEvents(t, id); len(events) != 0 {
+				t.Errorf("Expected no events for user %d, got: %v", id, eventTypes(events))
+			}
+		}
+	})
+
+	t.Run("Rolled Back With Transaction", func(t *testing.T) {
+		tx, err := testDB.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		rolledBack, err := repo.WithTx(tx).Create(ctx, fixtures.GenerateEmail(t), "Rolled Back")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+
+		if events := outboxEvents(t, rolledBack.ID); len(events) != 0 {
+			t.Errorf("Expected the event to roll back with the user, got: %v", eventTypes(events))
+		}
+	})
+
+	t.Run("Cached Writes", func(t *testing.T) {
+		cachedRepo := NewCachedUserRepository(testDB, testhelpers.StartRedis(ctx, t))
+
+		created, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Cached Events")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		deleteOnCleanup(t, created.ID)
+		if err := cachedRepo.UpdateRoleCached(ctx, created.ID, models.RoleGuest); err != nil {
+			t.Fatalf("Failed to update role: %v", err)
+		}
+
+		events := outboxEvents(t, created.ID)
+		if got := eventTypes(events); len(got) != 2 || got[0] != models.EventUserCreated || got[1] != models.EventUserUpdated {
+			t.Fatalf("Expected created and updated events, got: %v", got)
+		}
+		if events[1].User.Role != models.RoleGuest {
+			t.Errorf("Expected the new role in the payload, got: %q", events[1].User.Role)
+		}
+	})
+}
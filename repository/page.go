@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+)
+
+// UserPage is a page of users, what the UserStore list methods return
+type UserPage = pagination.Page[models.User]
+
+// limitFieldError reports a negative page size
+var limitFieldError = FieldError{Field: "limit", Message: "must not be negative"}
+
+// ValidateLimit returns a *ValidationError for a negative page size, or
+// nil; 0 means no limit
+func ValidateLimit(limit int) error {
+	if limit < 0 {
+		return &ValidationError{Fields: []FieldError{limitFieldError}}
+	}
+	return nil
+}
+
+// IDCursor is the cursor after u in a list ordered by ID
+func IDCursor(u models.User) pagination.Cursor {
+	return pagination.Cursor{ID: u.ID}
+}
+
+// RecentCursor is the cursor after u in GetRecentUsers, which orders by
+// creation time, newest first, then by ID
+func RecentCursor(u models.User) pagination.Cursor {
+	return pagination.Cursor{ID: u.ID, Key: u.CreatedAt.UTC().Format(time.RFC3339Nano)}
+}
+
+// ParseRecentCursor decodes a RecentCursor, returning the creation time and
+// ID to continue after; both are zero for the empty cursor
+func ParseRecentCursor(s string) (time.Time, int, error) {
+	c, err := pagination.Decode(s)
+	if err != nil || s == "" {
+		return time.Time{}, 0, err
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, c.Key)
+	if err != nil {
+		return time.Time{}, 0, fmt.Errorf("%w: bad creation time %q", pagination.ErrInvalidCursor, c.Key)
+	}
+	return createdAt, c.ID, nil
+}
+
+// withLimit appends the LIMIT for a page of limit users to query, whose
+// other arguments are args: pagination.FetchLimit rows, or none for 0
+func withLimit(query string, args []interface{}, limit int) (string, []interface{}) {
+	if limit == 0 {
+		return query, args
+	}
+	args = append(args, pagination.FetchLimit(limit))
+	return query + " LIMIT $" + strconv.Itoa(len(args)), args
+}
+
+// countTotal runs query, a COUNT for a page's TotalCount
+func (r *UserRepository) countTotal(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	var total int64
+	if err := r.reads().QueryRowContext(ctx, query, args...).Scan(&total); err != nil {
+		return 0, fmt.Errorf("failed to count users: %w", err)
+	}
+	return total, nil
+}
@@ -0,0 +1,208 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	"testcontainers-demo/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// WithBcryptCost sets the bcrypt cost of new password hashes. Existing hashes
+// keep the cost they were made with. Costs below bcrypt.MinCost mean
+// bcrypt.DefaultCost.
+func WithBcryptCost(cost int) Option {
+	return func(r *UserRepository) {
+		r.bcryptCost = cost
+	}
+}
+
+// WithCachedBcryptCost sets the bcrypt cost of hashes made by ChangePasswordCached
+func WithCachedBcryptCost(cost int) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.bcryptCost = cost
+	}
+}
+
+// CreateWithPassword inserts a new member who can log in with password
+func (r *UserRepository) CreateWithPassword(ctx context.Context, email, name, password string) (*models.User, error) {
+	const op = "UserRepository.CreateWithPassword"
+	in := CreateUserInput{Email: email, Name: name}
+
+	// Validate first: hashing is deliberately slow
+	if err := validateWithPassword(in, password); err != nil {
+		return nil, newRepoError(op, "email="+in.Normalized().Email, err)
+	}
+	hash, err := hashPassword(password, r.bcryptCost)
+	if err != nil {
+		return nil, newRepoError(op, "email="+in.Normalized().Email, err)
+	}
+	return r.create(ctx, op, in, hash)
+}
+
+// Authenticate returns the user with email if password matches their hash.
+// An unknown email, a user without a password, and a wrong password all
+// return ErrInvalidCredentials and take about as long, so callers can't
+// tell which emails are registered.
+func (r *UserRepository) Authenticate(ctx context.Context, email, password string) (_ *models.User, err error) {
+	const op = "UserRepository.Authenticate"
+	email = NormalizeEmail(email)
+	key := "email=" + email
+	query := "SELECT " + userColumns + ", password_hash FROM users WHERE lower(email) = $1 AND tenant_id = $2"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, email)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	var user models.User
+	var hash sql.NullString
+	err = r.db.QueryRowContext(ctx, query, email, r.tenant).Scan(append(userFields(&user), &hash)...)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to get user: %w", err))
+	}
+
+	if err == sql.ErrNoRows || !hash.Valid {
+		bcrypt.CompareHashAndPassword(dummyHash(r.bcryptCost), []byte(password))
+		return nil, newRepoError(op, key, ErrInvalidCredentials)
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(password)); err != nil {
+		return nil, newRepoError(op, key, ErrInvalidCredentials)
+	}
+
+	user.PasswordHash = hash.String
+	return &user, nil
+}
+
+// ChangePassword replaces user id's password after checking oldPassword,
+// returning ErrInvalidCredentials if it doesn't match or the password
+// changed concurrently
+func (r *UserRepository) ChangePassword(ctx context.Context, id int, oldPassword, newPassword string) (err error) {
+	const op = "UserRepository.ChangePassword"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: updatePasswordHash, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := r.changePassword(ctx, r.bcryptCost, id, oldPassword, newPassword); err != nil {
+		return newRepoError(op, fmt.Sprintf("id=%d", id), err)
+	}
+	return nil
+}
+
+// ChangePasswordCached is ChangePassword followed by invalidating the
+// user's cached entry
+func (r *CachedUserRepository) ChangePasswordCached(ctx context.Context, id int, oldPassword, newPassword string) (err error) {
+	const op = "CachedUserRepository.ChangePasswordCached"
+	key := fmt.Sprintf("id=%d", id)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: updatePasswordHash, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := r.users.changePassword(ctx, r.bcryptCost, id, oldPassword, newPassword); err != nil {
+		return newRepoError(op, key, err)
+	}
+	cacheKey := userCacheKey(r.tenant, id)
+	requestCacheFrom(ctx).forget(cacheKey)
+	if err := r.evict(ctx, []int{id}, cacheKey); err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to invalidate cache: %w", err))
+	}
+	return nil
+}
+
+// selectPasswordHash is the query behind changePassword's old-password
+// check; $2 is the tenant
+const selectPasswordHash = "SELECT password_hash FROM users WHERE id = $1 AND tenant_id = $2"
+
+// updatePasswordHash swaps the hash only if it is still the one that was
+// checked, recording a user.updated event; RowsAffected counts the event
+var updatePasswordHash = "WITH u AS (UPDATE users SET password_hash = $1 WHERE id = $2 AND password_hash = $3 AND tenant_id = $4 " +
+	"RETURNING " + userColumns + ") " + insertUserEvent(models.EventUserUpdated)
+
+// changePassword checks oldPassword against the hash of user id and
+// replaces it with a hash of newPassword at cost
+func (r *UserRepository) changePassword(ctx context.Context, cost, id int, oldPassword, newPassword string) error {
+	var hash sql.NullString
+	err := r.db.QueryRowContext(ctx, selectPasswordHash, id, r.tenant).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get password hash: %w", err)
+	}
+	if !hash.Valid || bcrypt.CompareHashAndPassword([]byte(hash.String), []byte(oldPassword)) != nil {
+		return ErrInvalidCredentials
+	}
+
+	if msg := validatePassword(newPassword); msg != "" {
+		return &ValidationError{Fields: []FieldError{{Field: "password", Message: msg}}}
+	}
+	newHash, err := hashPassword(newPassword, cost)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.db.ExecContext(ctx, updatePasswordHash, newHash, id, hash.String, r.tenant)
+	if err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rowsAffected == 0 {
+		// Deleted, or the password changed since it was checked
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// validateWithPassword validates in and password together, so one
+// ValidationError lists every failing field
+func validateWithPassword(in CreateUserInput, password string) error {
+	err := in.Validate()
+	msg := validatePassword(password)
+	if msg == "" {
+		return err
+	}
+
+	var fields []FieldError
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		fields = validationErr.Fields
+	}
+	return &ValidationError{Fields: append(fields, FieldError{Field: "password", Message: msg})}
+}
+
+// hashPassword returns the bcrypt hash of password at cost
+func hashPassword(password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+var (
+	dummyHashesMu sync.Mutex
+	dummyHashes   = map[int][]byte{}
+)
+
+// dummyHash returns a hash at cost that no password is checked against for
+// real, so a login for an unknown email costs the same as a wrong password
+func dummyHash(cost int) []byte {
+	dummyHashesMu.Lock()
+	defer dummyHashesMu.Unlock()
+	if hash, ok := dummyHashes[cost]; ok {
+		return hash
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), cost)
+	if err != nil {
+		hash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
+	}
+	dummyHashes[cost] = hash
+	return hash
+}
+
+// nullString is s as a nullable column value, NULL when empty
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
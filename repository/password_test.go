@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TestPasswords tests creating users with passwords and logging in
+func TestPasswords(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB, WithBcryptCost(bcrypt.MinCost))
+	t.Cleanup(func() { repo.Close() })
+
+	email := fixtures.GenerateEmail(t)
+	created, err := repo.CreateWithPassword(ctx, strings.ToUpper(email), "Login User", "correct horse")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, created.ID)
+
+	t.Run("Correct Password", func(t *testing.T) {
+		user, err := repo.Authenticate(ctx, email+" ", "correct horse")
+		if err != nil {
+			t.Fatalf("Failed to authenticate: %v", err)
+		}
+		if user.ID != created.ID || user.Email != email {
+			t.Errorf("Expected user %d, got: %+v", created.ID, user)
+		}
+	})
+
+	t.Run("Wrong Password And Unknown Email", func(t *testing.T) {
+		_, wrongPassword := repo.Authenticate(ctx, email, "wrong horse")
+		_, unknownEmail := repo.Authenticate(ctx, "nobody@example.com", "correct horse")
+		_, noPassword := repo.Authenticate(ctx, newUser(t).Email, "correct horse")
+		for _, err := range []error{wrongPassword, unknownEmail, noPassword} {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Expected ErrInvalidCredentials, got: %v", err)
+			}
+			if errors.Is(err, ErrUserNotFound) {
+				t.Errorf("Expected the error not to reveal whether the email exists, got: %v", err)
+			}
+		}
+	})
+
+	t.Run("Cost Honored", func(t *testing.T) {
+		cost, err := bcrypt.Cost([]byte(created.PasswordHash))
+		if err != nil {
+			t.Fatalf("Failed to read hash cost: %v", err)
+		}
+		if cost != bcrypt.MinCost {
+			t.Errorf("Expected cost %d, got: %d", bcrypt.MinCost, cost)
+		}
+
+		costly := NewUserRepository(testDB, WithBcryptCost(bcrypt.MinCost+2))
+		t.Cleanup(func() { costly.Close() })
+		costlyEmail := fixtures.GenerateEmail(t)
+		user, err := costly.CreateWithPassword(ctx, costlyEmail, "Costly User", "correct horse")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		deleteOnCleanup(t, user.ID)
+		if cost, _ := bcrypt.Cost([]byte(user.PasswordHash)); cost != bcrypt.MinCost+2 {
+			t.Errorf("Expected cost %d, got: %d", bcrypt.MinCost+2, cost)
+		}
+		// Hashes keep their own cost whatever the verifying repository uses
+		if _, err := repo.Authenticate(ctx, costlyEmail, "correct horse"); err != nil {
+			t.Errorf("Failed to authenticate: %v", err)
+		}
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := repo.CreateWithPassword(ctx, "not-an-email", "Short", "short")
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("Expected a ValidationError, got: %v", err)
+		}
+		if len(validationErr.Fields) != 2 || validationErr.Fields[1].Field != "password" {
+			t.Errorf("Expected email and password errors, got: %v", validationErr.Fields)
+		}
+		if _, err := repo.CreateWithPassword(ctx, "long@example.com", "Long", strings.Repeat("x", 73)); !errors.As(err, &validationErr) {
+			t.Errorf("Expected a ValidationError for a 73-byte password, got: %v", err)
+		}
+	})
+
+	t.Run("Never Cached", func(t *testing.T) {
+		client := testhelpers.StartRedis(ctx, t)
+		cachedRepo := NewCachedUserRepository(testDB, client)
+		if _, err := cachedRepo.GetByIDCached(ctx, created.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		cached, err := client.Get(ctx, fmt.Sprintf("user:default:%d", created.ID)).Result()
+		if err != nil {
+			t.Fatalf("Failed to read cached user: %v", err)
+		}
+		if strings.Contains(cached, created.PasswordHash) || strings.Contains(cached, "$2a$") ||
+			strings.Contains(strings.ToLower(cached), "password") {
+			t.Errorf("Expected no password hash in the cached user, got: %s", cached)
+		}
+	})
+}
+
+// TestChangePassword tests replacing a password after checking the old one
+func TestChangePassword(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	client := testhelpers.StartRedis(ctx, t)
+	repo := NewUserRepository(testDB, WithBcryptCost(bcrypt.MinCost))
+	t.Cleanup(func() { repo.Close() })
+	cachedRepo := NewCachedUserRepository(testDB, client, WithCachedBcryptCost(bcrypt.MinCost))
+
+	user, err := repo.CreateWithPassword(ctx, fixtures.GenerateEmail(t), "Change User", "first password")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, user.ID)
+
+	t.Run("Wrong Old Password", func(t *testing.T) {
+		err := repo.ChangePassword(ctx, user.ID, "not the password", "second password")
+		if !errors.Is(err, ErrInvalidCredentials) {
+			t.Fatalf("Expected ErrInvalidCredentials, got: %v", err)
+		}
+		if _, err := repo.Authenticate(ctx, user.Email, "first password"); err != nil {
+			t.Errorf("Expected the old password to still work, got: %v", err)
+		}
+	})
+
+	t.Run("Changed", func(t *testing.T) {
+		if err := repo.ChangePassword(ctx, user.ID, "first password", "second password"); err != nil {
+			t.Fatalf("Failed to change password: %v", err)
+		}
+		if _, err := repo.Authenticate(ctx, user.Email, "first password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Expected the old password to be rejected, got: %v", err)
+		}
+		if _, err := repo.Authenticate(ctx, user.Email, "second password"); err != nil {
+			t.Errorf("Expected the new password to work, got: %v", err)
+		}
+		if events := outboxEvents(t, user.ID); len(events) != 2 || events[1].Type != models.EventUserUpdated {
+			t.Errorf("Expected a user.updated event, got: %v", eventTypes(events))
+		}
+	})
+
+	t.Run("Unknown User", func(t *testing.T) {
+		if err := repo.ChangePassword(ctx, missingID, "first password", "second password"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Invalidates Cache", func(t *testing.T) {
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if err := cachedRepo.ChangePasswordCached(ctx, user.ID, "second password", "third password"); err != nil {
+			t.Fatalf("Failed to change password: %v", err)
+		}
+		if n, _ := client.Exists(ctx, fmt.Sprintf("user:default:%d", user.ID)).Result(); n != 0 {
+			t.Error("Expected the cached user to be invalidated")
+		}
+		if _, err := repo.Authenticate(ctx, user.Email, "third password"); err != nil {
+			t.Errorf("Expected the new password to work, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,147 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"syscall"
+)
+
+// isConnError reports whether err means the connection, not the statement,
+// failed: it was closed under the query, as every pooled connection is when
+// Postgres restarts, or a new one couldn't be made. database/sql already
+// retries driver.ErrBadConn outside transactions; the rest, such as a
+// "broken pipe", reach the caller.
+func isConnError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if pgErr, ok := asPgError(err); ok {
+		// Class 08 is connection_exception; 57P01 to 57P03 end the session
+		// on shutdown, crash recovery, and startup
+		switch pgErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pgErr.class() == "08"
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// reconnectDB is the DBTX of a repository on a pool. A call that fails with
+// a connection error runs once more, unprepared, after refresh has found a
+// live connection for it, if it can't have taken effect the first time:
+// see rerunnable. Transactions never go through it: a statement of one
+// can't move to another connection, so a dead connection fails the whole
+// transaction.
+type reconnectDB struct {
+	DBTX
+	pool *sql.DB
+}
+
+// PingContext pings the pool
+func (d *reconnectDB) PingContext(ctx context.Context) error {
+	return d.pool.PingContext(ctx)
+}
+
+// ExecContext runs query, and again on a live connection after a
+// connection error that rerunnable allows
+func (d *reconnectDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := d.DBTX.ExecContext(ctx, query, args...)
+	if rerunnable(query, err) && refresh(ctx, d.pool) == nil {
+		return d.pool.ExecContext(ctx, query, args...)
+	}
+	return result, err
+}
+
+// QueryContext runs query, and again on a live connection after a
+// connection error that rerunnable allows
+func (d *reconnectDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := d.DBTX.QueryContext(ctx, query, args...)
+	if rerunnable(query, err) && refresh(ctx, d.pool) == nil {
+		return d.pool.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// QueryRowContext runs query, and again on a live connection when Row.Err
+// reports a connection error that rerunnable allows
+func (d *reconnectDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	row := d.DBTX.QueryRowContext(ctx, query, args...)
+	if rerunnable(query, row.Err()) && refresh(ctx, d.pool) == nil {
+		return d.pool.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
+
+// rerunnable reports whether query, failed with err, may run again: err is
+// a connection error, and either query only reads, or err shows it didn't
+// run, because the server answered it with an error or it was never sent.
+// A write whose connection died after it went out may have committed, so
+// it is left to the caller.
+func rerunnable(query string, err error) bool {
+	if !isConnError(err) {
+		return false
+	}
+	if _, ok := asPgError(err); ok {
+		return true
+	}
+	return isUnsentError(err) || isReadOnly(query)
+}
+
+// isReadOnly reports whether query is a SELECT. A statement starting WITH
+// counts as a write, since its CTEs may modify data.
+func isReadOnly(query string) bool {
+	query = strings.TrimSpace(query)
+	return len(query) >= len("SELECT") && strings.EqualFold(query[:len("SELECT")], "SELECT")
+}
+
+// refresh takes connections from pool until one answers a ping, and returns
+// that one to the pool. Dead connections fail the ping and are discarded, and
+// once none is left idle the pool dials a new one; database/sql hands out the
+// most recently returned idle connection first, so the caller's next query
+// gets the live one. The error is the last ping's, or the dial's, as when
+// Postgres isn't back yet.
+func refresh(ctx context.Context, pool *sql.DB) error {
+	var err error
+	for range pool.Stats().OpenConnections + 1 {
+		var conn *sql.Conn
+		if conn, err = pool.Conn(ctx); err != nil {
+			return err
+		}
+		err = conn.PingContext(ctx)
+		// Returns a live connection to the pool, and closes a dead one
+		_ = conn.Close()
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// beginTx begins a transaction on pool. Nothing has run in it yet, so a
+// BeginTx that fails with a connection error is tried once more on a live
+// connection.
+func beginTx(ctx context.Context, pool *sql.DB) (*sql.Tx, error) {
+	tx, err := pool.BeginTx(ctx, nil)
+	if isConnError(err) && refresh(ctx, pool) == nil {
+		return pool.BeginTx(ctx, nil)
+	}
+	return tx, err
+}
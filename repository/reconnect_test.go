@@ -0,0 +1,123 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestRestartPostgres restarts a Postgres container of its own under a
+// repository, and checks the same *sql.DB serves the CRUD operations again
+// without a failed call, including concurrent reads that draw the pool's
+// dead connections, and a transaction begun after the restart
+func TestRestartPostgres(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// Both would hand over a database other tests are using
+	if os.Getenv("TEST_DATABASE_URL") != "" || os.Getenv("TESTCONTAINERS_REUSE") == "1" {
+		t.Skip("Needs a container of its own to restart")
+	}
+	container, err := testhelpers.StartPostgres(ctx, testhelpers.WithImage(testhelpers.PostgresImage()))
+	if err != nil {
+		t.Fatalf("Failed to start Postgres: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(context.Background()) })
+	pool := container.DB
+	repo := NewUserRepository(pool)
+	t.Cleanup(func() { repo.Close() })
+
+	before, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Before Restart")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// Leaves more idle connections than a ping discards, all dead after the restart
+	const conns = 6
+	pool.SetMaxIdleConns(conns)
+	held := make([]*sql.Conn, conns)
+	for i := range held {
+		if held[i], err = pool.Conn(ctx); err != nil {
+			t.Fatalf("Failed to open connection %d: %v", i+1, err)
+		}
+	}
+	for _, conn := range held {
+		conn.Close()
+	}
+
+	container.RestartPostgres(ctx, t)
+
+	t.Run("Concurrent Reads", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for range conns {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.GetByID(ctx, before.ID); err != nil {
+					t.Errorf("Failed to get user after the restart: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+	})
+
+	t.Run("CRUD", func(t *testing.T) {
+		user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "After Restart")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := repo.Update(ctx, user.ID, user.Email, "Renamed After Restart"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		got, err := repo.GetByEmail(ctx, user.Email)
+		if err != nil || got.Name != "Renamed After Restart" {
+			t.Fatalf("Expected the renamed user, got: %+v, %v", got, err)
+		}
+		users, err := repo.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		found := 0
+		for _, u := range users {
+			if u.ID == before.ID || u.ID == user.ID {
+				found++
+			}
+		}
+		if found != 2 {
+			t.Errorf("Expected both users listed, found %d", found)
+		}
+		if err := repo.Delete(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Transaction After Another Restart", func(t *testing.T) {
+		container.RestartPostgres(ctx, t)
+
+		// Nothing can be retried inside the transaction, so it must begin
+		// on a live connection
+		tx, err := beginTx(ctx, pool)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		user, err := repo.WithTx(tx).Create(ctx, fixtures.GenerateEmail(t), "In Transaction")
+		if err != nil {
+			tx.Rollback()
+			t.Fatalf("Failed to create user in transaction: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Errorf("Expected the committed user, got: %v", err)
+		}
+	})
+}
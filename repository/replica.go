@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+)
+
+// NewUserRepositoryWithReplica creates a user repository that reads from
+// replica and writes to primary. The Get*, List*, Count*, and Find*
+// methods, and ExportUsers, query the replica, and run again on the
+// primary if it fails; a lookup the replica answers with ErrUserNotFound is
+// not retried, so a user created a moment ago may not be found yet.
+// Everything else, including Authenticate, goes to the primary, and so does
+// every query of a WithTx copy, which therefore reads its own writes. Close
+// releases the statements prepared on both.
+func NewUserRepositoryWithReplica(primary, replica *sql.DB, opts ...Option) *UserRepository {
+	r := NewUserRepository(primary, opts...)
+	var reads DBTX = replica
+	if !r.unprepared {
+		r.replicaStmts = newPreparedDB(replica)
+		reads = r.replicaStmts
+	}
+	r.replica = &fallbackDB{replica: &reconnectDB{DBTX: reads, pool: replica}, primary: r.db}
+	return r
+}
+
+// reads is where the read-only methods send their queries: the replica when
+// there is one, otherwise the same place as writes
+func (r *UserRepository) reads() DBTX {
+	if r.replica == nil {
+		return r.db
+	}
+	return r.replica
+}
+
+// fallbackDB queries replica, and primary when replica returns an error. A
+// cancelled context isn't the replica's fault, so it is returned as is.
+type fallbackDB struct {
+	replica DBTX
+	primary DBTX
+}
+
+// ExecContext always runs on the primary; nothing routed here writes, but
+// fallbackDB must still be a DBTX
+func (f *fallbackDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return f.primary.ExecContext(ctx, query, args...)
+}
+
+func (f *fallbackDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	rows, err := f.replica.QueryContext(ctx, query, args...)
+	if err != nil && ctx.Err() == nil {
+		return f.primary.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// QueryRowContext checks Row.Err, the execution error, so a replica that
+// fails is retried before the caller scans; sql.ErrNoRows only shows up in
+// Scan and is an answer, not a failure
+func (f *fallbackDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	row := f.replica.QueryRowContext(ctx, query, args...)
+	if row.Err() != nil && ctx.Err() == nil {
+		return f.primary.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+)
+
+// TestReplicaRouting tests which database each method reads, using two
+// independent databases seeded differently in place of a replicated pair:
+// a user found only in one of them shows where the query went
+func TestReplicaRouting(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	primary := testContainer.CreateTestDatabase(ctx, t)
+	replica := testContainer.CreateTestDatabase(ctx, t)
+
+	// Both get ID 3 after the two seed users, under different names
+	insert := func(db *sql.DB, email, name string) {
+		t.Helper()
+		if _, err := NewUserRepository(db, WithoutPreparedStatements()).CreateWithRole(ctx, email, name, models.RoleGuest); err != nil {
+			t.Fatalf("Failed to create %s: %v", email, err)
+		}
+	}
+	insert(primary, "on-primary@example.com", "Routing Primary")
+	insert(replica, "on-replica@example.com", "Routing Replica")
+
+	repo := NewUserRepositoryWithReplica(primary, replica)
+	t.Cleanup(func() { repo.Close() })
+
+	// onReplica reports whether users came from the replica, failing the
+	// test if they came from neither database
+	onReplica := func(t *testing.T, users []models.User) bool {
+		t.Helper()
+		var sawPrimary, sawReplica bool
+		for _, u := range users {
+			sawPrimary = sawPrimary || u.Email == "on-primary@example.com"
+			sawReplica = sawReplica || u.Email == "on-replica@example.com"
+		}
+		if sawPrimary == sawReplica {
+			t.Fatalf("Expected exactly one database's user, got: %+v", users)
+		}
+		return sawReplica
+	}
+	one := func(u *models.User, err error) ([]models.User, error) {
+		if err != nil {
+			return nil, err
+		}
+		return []models.User{*u}, nil
+	}
+	// items adapts a page
+	items := func(page UserPage, err error) ([]models.User, error) {
+		return page.Items, err
+	}
+
+	reads := map[string]func(repo *UserRepository) ([]models.User, error){
+		"GetByID": func(repo *UserRepository) ([]models.User, error) { return one(repo.GetByID(ctx, 3)) },
+		"GetByEmail": func(repo *UserRepository) ([]models.User, error) {
+			return one(repo.GetByEmail(ctx, "on-replica@example.com"))
+		},
+		"List": func(repo *UserRepository) ([]models.User, error) { return repo.List(ctx) },
+		"ListPaginated": func(repo *UserRepository) ([]models.User, error) {
+			return items(repo.ListPaginated(ctx, pagination.Cursor{ID: 2}.Encode(), 10))
+		},
+		"ListByRole": func(repo *UserRepository) ([]models.User, error) {
+			return items(repo.ListByRole(ctx, models.RoleGuest, "", 0))
+		},
+		"FindByNamePattern": func(repo *UserRepository) ([]models.User, error) {
+			return repo.FindByNamePattern(ctx, "Routing")
+		},
+		"GetRecentUsers": func(repo *UserRepository) ([]models.User, error) { return items(repo.GetRecentUsers(ctx, 1, "", 0)) },
+		"ListEach": func(repo *UserRepository) ([]models.User, error) {
+			var users []models.User
+			err := repo.ListEach(ctx, func(u models.User) error {
+				users = append(users, u)
+				return nil
+			})
+			return users, err
+		},
+	}
+
+	t.Run("Reads Go To The Replica", func(t *testing.T) {
+		for name, read := range reads {
+			users, err := read(repo)
+			if err != nil {
+				t.Errorf("%s: %v", name, err)
+				continue
+			}
+			if !onReplica(t, users) {
+				t.Errorf("%s: expected to read the replica", name)
+			}
+		}
+
+		// The replica's ID 3 is the only guest in either database
+		counts, err := repo.CountByRole(ctx)
+		if err != nil || counts[models.RoleGuest] != 1 {
+			t.Errorf("Expected one guest, got: %v, %v", counts, err)
+		}
+		if _, err := repo.GetByEmail(ctx, "on-primary@example.com"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected the replica not to know the primary's user, got: %v", err)
+		}
+	})
+
+	t.Run("Writes Go To The Primary", func(t *testing.T) {
+		if err := repo.Update(ctx, 3, "on-primary@example.com", "Routing Primary Renamed"); err != nil {
+			t.Fatalf("Failed to update: %v", err)
+		}
+		var name string
+		if err := primary.QueryRowContext(ctx, "SELECT name FROM users WHERE id = 3").Scan(&name); err != nil {
+			t.Fatalf("Failed to read primary: %v", err)
+		}
+		if name != "Routing Primary Renamed" {
+			t.Errorf("Expected the primary to be updated, got: %q", name)
+		}
+		if user, err := repo.GetByID(ctx, 3); err != nil || user.Name != "Routing Replica" {
+			t.Errorf("Expected the replica's user 3 unchanged, got: %+v, %v", user, err)
+		}
+	})
+
+	t.Run("Transactions Read The Primary", func(t *testing.T) {
+		tx, err := primary.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		txRepo := repo.WithTx(tx)
+		for name, read := range reads {
+			users, err := read(txRepo)
+			if name == "GetByEmail" {
+				// The replica's user doesn't exist here, which is the point
+				if !errors.Is(err, ErrUserNotFound) {
+					t.Errorf("GetByEmail: expected ErrUserNotFound, got: %v", err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if onReplica(t, users) {
+				t.Errorf("%s: expected to read the primary inside the transaction", name)
+			}
+		}
+	})
+
+	t.Run("Falls Back To The Primary", func(t *testing.T) {
+		// A replica that's gone: every query to it fails
+		broken := testContainer.CreateTestDatabase(ctx, t)
+		repo := NewUserRepositoryWithReplica(primary, broken)
+		t.Cleanup(func() { repo.Close() })
+		if err := broken.Close(); err != nil {
+			t.Fatalf("Failed to close replica: %v", err)
+		}
+
+		for name, read := range reads {
+			if name == "GetByEmail" {
+				continue
+			}
+			users, err := read(repo)
+			if err != nil {
+				t.Errorf("%s: expected the primary to answer, got: %v", name, err)
+				continue
+			}
+			if onReplica(t, users) {
+				t.Errorf("%s: expected to read the primary", name)
+			}
+		}
+		if count, err := repo.CountUsers(ctx); err != nil || count != 3 {
+			t.Errorf("Expected the primary's 3 users, got: %d, %v", count, err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"testcontainers-demo/models"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// requestCacheKey carries a *requestCache in a context
+type requestCacheKey struct{}
+
+// WithRequestCache returns a context under which GetByIDCached reads each
+// user at most once: later calls with the context, or one derived from it,
+// get the first call's *models.User without going to Redis. Derive it from
+// an HTTP request's context so the entries die with the request; there is
+// no TTL. Goroutines may share the context. Cached writes and invalidations
+// made with it drop the users they touch, so a request reads its own writes;
+// writes under other contexts don't, as the request is expected to be short.
+func WithRequestCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, requestCacheKey{}, &requestCache{users: make(map[string]*models.User)})
+}
+
+// requestCache memoizes users for one context, by their userCacheKey, so
+// repositories bound to different tenants can share the context. Concurrent
+// reads of the same user share one load.
+type requestCache struct {
+	mu    sync.Mutex
+	users map[string]*models.User
+	group singleflight.Group
+}
+
+// requestCacheFrom returns ctx's request cache, or nil without one
+func requestCacheFrom(ctx context.Context) *requestCache {
+	rc, _ := ctx.Value(requestCacheKey{}).(*requestCache)
+	return rc
+}
+
+// user returns the user at cacheKey, calling load the first time only;
+// errors are not memoized, so a failed read is retried by the next call
+func (c *requestCache) user(cacheKey string, load func() (*models.User, error)) (*models.User, error) {
+	c.mu.Lock()
+	user, ok := c.users[cacheKey]
+	c.mu.Unlock()
+	if ok {
+		return user, nil
+	}
+
+	v, err, _ := c.group.Do(cacheKey, func() (interface{}, error) {
+		user, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		c.users[cacheKey] = user
+		c.mu.Unlock()
+		return user, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*models.User), nil
+}
+
+// forget drops the users at cacheKeys; a nil cache has nothing to drop
+func (c *requestCache) forget(cacheKeys ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, cacheKey := range cacheKeys {
+		delete(c.users, cacheKey)
+	}
+}
+
+// clear drops every user whose key starts with prefix
+func (c *requestCache) clear(prefix string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cacheKey := range c.users {
+		if strings.HasPrefix(cacheKey, prefix) {
+			delete(c.users, cacheKey)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestRequestCache tests that a request-scoped context reads a user from
+// Redis once, shares the result between goroutines, and drops it on writes
+func TestRequestCache(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	commands := &commandRecorder{}
+	redisClient.AddHook(commands)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+
+	email := fixtures.GenerateEmail(t)
+	user, err := cachedRepo.CreateCached(ctx, email, "Request Cached")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, user.ID)
+	// Cache the user so every read below is a Redis GET
+	if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to cache user: %v", err)
+	}
+
+	// gets counts the GETs sent since the last call
+	gets := func() int {
+		n := 0
+		for _, name := range commands.take() {
+			if name == "get" {
+				n++
+			}
+		}
+		return n
+	}
+
+	t.Run("One GET Per Request", func(t *testing.T) {
+		reqCtx := WithRequestCache(ctx)
+		gets()
+		var first *models.User
+		for i := 0; i < 5; i++ {
+			got, err := cachedRepo.GetByIDCached(reqCtx, user.ID)
+			if err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+			if first == nil {
+				first = got
+			}
+			if got != first || got.Email != email {
+				t.Errorf("Expected call %d to return the first user %p, got: %p %+v", i, first, got, got)
+			}
+		}
+		if n := gets(); n != 1 {
+			t.Errorf("Expected 1 GET, got: %d", n)
+		}
+	})
+
+	t.Run("Without Request Cache", func(t *testing.T) {
+		gets()
+		for i := 0; i < 5; i++ {
+			if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+		}
+		if n := gets(); n != 5 {
+			t.Errorf("Expected 5 GETs, got: %d", n)
+		}
+	})
+
+	t.Run("Shared Between Goroutines", func(t *testing.T) {
+		reqCtx := WithRequestCache(ctx)
+		gets()
+		results := make([]*models.User, 20)
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := cachedRepo.GetByIDCached(reqCtx, user.ID)
+				if err != nil {
+					t.Errorf("Failed to get user: %v", err)
+				}
+				results[i] = got
+			}()
+		}
+		wg.Wait()
+		for i, got := range results {
+			if got != results[0] {
+				t.Errorf("Expected goroutine %d to get the shared user %p, got: %p", i, results[0], got)
+			}
+		}
+		if n := gets(); n != 1 {
+			t.Errorf("Expected 1 GET, got: %d", n)
+		}
+	})
+
+	t.Run("Writes Are Read Back", func(t *testing.T) {
+		reqCtx := WithRequestCache(ctx)
+		if _, err := cachedRepo.GetByIDCached(reqCtx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if err := cachedRepo.UpdateCached(reqCtx, user.ID, email, "Request Renamed"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		got, err := cachedRepo.GetByIDCached(reqCtx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.Name != "Request Renamed" {
+			t.Errorf("Expected the new name, got: %s", got.Name)
+		}
+	})
+
+	t.Run("Errors Are Not Memoized", func(t *testing.T) {
+		reqCtx := WithRequestCache(ctx)
+		for i := 0; i < 2; i++ {
+			if _, err := cachedRepo.GetByIDCached(reqCtx, missingID); err == nil {
+				t.Fatal("Expected error for non-existent user")
+			}
+		}
+		if got := len(requestCacheFrom(reqCtx).users); got != 0 {
+			t.Errorf("Expected nothing cached for the request, got %d users", got)
+		}
+	})
+}
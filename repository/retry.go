@@ -0,0 +1,86 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"testcontainers-demo/backoff"
+)
+
+// retryPolicy bounds how write methods retry transient errors
+type retryPolicy struct {
+	maxAttempts int
+	baseDelay   time.Duration
+}
+
+// maxRetryDelay caps the wait between retries however large WithRetry's
+// baseDelay or attempt count
+const maxRetryDelay = 5 * time.Second
+
+// defaultRetryPolicy covers the hiccups seen right after a container comes up
+// and under concurrent tests without noticeably slowing a real failure
+var defaultRetryPolicy = retryPolicy{maxAttempts: 3, baseDelay: 20 * time.Millisecond}
+
+// retryableCodes are the SQLSTATEs worth trying again: the statement failed
+// because of other sessions or the server's state, not because of its input
+var retryableCodes = map[string]bool{
+	"40001": true, // serialization_failure
+	"40P01": true, // deadlock_detected
+	"53300": true, // too_many_connections, while other clients' sessions end
+	"57P01": true, // admin_shutdown
+	"57P02": true, // crash_shutdown
+	"57P03": true, // cannot_connect_now
+}
+
+// withRetry runs fn until it succeeds, fails with an error that isn't
+// transient, runs out of attempts, or ctx is done
+func (r *UserRepository) withRetry(ctx context.Context, fn func() error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn()
+		if err == nil || !isRetryable(err) || attempt >= r.retry.maxAttempts {
+			return err
+		}
+
+		timer := time.NewTimer(backoff.Delay(attempt, r.retry.baseDelay, maxRetryDelay))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w after %d attempts: %v", ctx.Err(), attempt, err)
+		case <-timer.C:
+		}
+	}
+}
+
+// isRetryable reports whether err is a transient error the write it failed
+// can't have committed through: a Postgres error, which the server only
+// sends for a statement it didn't carry out, or a connection error from
+// before the statement was sent. Once it has been sent, an EOF or reset
+// connection leaves unknown whether the write committed, so it is never
+// retried. Constraint violations (class 23) are never retried either.
+func isRetryable(err error) bool {
+	if pgErr, ok := asPgError(err); ok {
+		// Class 08 is connection_exception
+		return retryableCodes[pgErr.Code] || pgErr.class() == "08"
+	}
+	return isUnsentError(err)
+}
+
+// isUnsentError reports whether err stopped a statement before it reached
+// the server: driver.ErrBadConn, which drivers return only when nothing was
+// sent, or a connection that couldn't be dialed
+func isUnsentError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var opErr *net.OpError
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.As(err, &opErr) && opErr.Op == "dial"
+}
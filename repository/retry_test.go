@@ -0,0 +1,369 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/lib/pq"
+)
+
+// faultInjector fails the next statements containing match with faults, in order
+type faultInjector struct {
+	mu     sync.Mutex
+	match  string
+	faults []error
+	seen   int // matching statements, including the failed ones
+}
+
+// next returns the error to fail a statement with, or nil to run it
+func (f *faultInjector) next(query string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if !strings.Contains(query, f.match) {
+		return nil
+	}
+	f.seen++
+	if len(f.faults) == 0 {
+		return nil
+	}
+	err := f.faults[0]
+	f.faults = f.faults[1:]
+	return err
+}
+
+// attempts returns how many matching statements reached the driver
+func (f *faultInjector) attempts() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.seen
+}
+
+// faultConnector wraps the pq connector so statements can fail on demand
+type faultConnector struct {
+	driver.Connector
+	faults *faultInjector
+}
+
+func (c *faultConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &faultConn{Conn: conn, faults: c.faults}, nil
+}
+
+// faultConn consults the injector before each statement
+type faultConn struct {
+	driver.Conn
+	faults *faultInjector
+}
+
+func (c *faultConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if err := c.faults.next(query); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+func (c *faultConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if err := c.faults.next(query); err != nil {
+		return nil, err
+	}
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+// openFaultyDB connects to the test database through a faultInjector.
+// Repositories on it need WithoutPreparedStatements: prepared statements run on
+// pq's own statement type and would bypass faultConn.
+func openFaultyDB(t *testing.T, faults *faultInjector) *sql.DB {
+	t.Helper()
+
+	connector, err := pq.NewConnector(testContainer.ConnStr)
+	if err != nil {
+		t.Fatalf("Failed to create connector: %v", err)
+	}
+	db := sql.OpenDB(&faultConnector{Connector: connector, faults: faults})
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// serializationFailure is the error Postgres returns for SQLSTATE 40001
+func serializationFailure() error {
+	return &pq.Error{Code: "40001", Message: "could not serialize access due to concurrent update"}
+}
+
+// TestWithRetry tests retrying writes on injected transient errors
+func TestWithRetry(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("Serialization Failure Is Retried", func(t *testing.T) {
+		faults := &faultInjector{match: "INSERT INTO users", faults: []error{serializationFailure()}}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(3, time.Millisecond), WithoutPreparedStatements())
+
+		user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Retry User")
+		if err != nil {
+			t.Fatalf("Expected create to succeed after retry, got: %v", err)
+		}
+		deleteOnCleanup(t, user.ID)
+		if user.ID == 0 {
+			t.Error("Expected non-zero ID for created user")
+		}
+		if got := faults.attempts(); got != 2 {
+			t.Errorf("Expected 2 attempts, got: %d", got)
+		}
+	})
+
+	t.Run("Deadlock On Update Is Retried", func(t *testing.T) {
+		deadlock := &pq.Error{Code: "40P01", Message: "deadlock detected"}
+		faults := &faultInjector{match: "UPDATE users", faults: []error{deadlock}}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(3, time.Millisecond), WithoutPreparedStatements())
+
+		user := newUser(t)
+		if err := repo.Update(ctx, user.ID, user.Email, "Retried User"); err != nil {
+			t.Fatalf("Expected update to succeed after retry, got: %v", err)
+		}
+		if got := faults.attempts(); got != 2 {
+			t.Errorf("Expected 2 attempts, got: %d", got)
+		}
+	})
+
+	t.Run("Unique Violation Is Not Retried", func(t *testing.T) {
+		faults := &faultInjector{match: "INSERT INTO users"}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(3, time.Millisecond), WithoutPreparedStatements())
+
+		_, err := repo.Create(ctx, newUser(t).Email, "Duplicate User")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		if got := faults.attempts(); got != 1 {
+			t.Errorf("Expected 1 attempt, got: %d", got)
+		}
+	})
+
+	t.Run("Unsent Write Is Retried", func(t *testing.T) {
+		refused := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		faults := &faultInjector{match: "UPDATE users", faults: []error{refused}}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(3, time.Millisecond), WithoutPreparedStatements())
+
+		user := newUser(t)
+		if err := repo.Update(ctx, user.ID, user.Email, "Retried User"); err != nil {
+			t.Fatalf("Expected update to succeed after retry, got: %v", err)
+		}
+		if got := faults.attempts(); got != 2 {
+			t.Errorf("Expected 2 attempts, got: %d", got)
+		}
+	})
+
+	t.Run("Sent Write Is Not Retried", func(t *testing.T) {
+		// The connection died after the INSERT went out: it may have committed
+		faults := &faultInjector{match: "INSERT INTO users", faults: []error{io.ErrUnexpectedEOF}}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(3, time.Millisecond), WithoutPreparedStatements())
+
+		if _, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Maybe Committed"); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("Expected io.ErrUnexpectedEOF, got: %v", err)
+		}
+		if got := faults.attempts(); got != 1 {
+			t.Errorf("Expected 1 attempt, got: %d", got)
+		}
+	})
+
+	t.Run("Read Is Rerun After It Was Sent", func(t *testing.T) {
+		faults := &faultInjector{match: "FROM users WHERE id", faults: []error{io.ErrUnexpectedEOF}}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithoutPreparedStatements())
+
+		user := newUser(t)
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("Expected the read to succeed when rerun, got: %v", err)
+		}
+		if got := faults.attempts(); got != 2 {
+			t.Errorf("Expected 2 attempts, got: %d", got)
+		}
+	})
+
+	t.Run("Gives Up After Max Attempts", func(t *testing.T) {
+		faults := &faultInjector{match: "DELETE FROM users", faults: []error{
+			serializationFailure(), serializationFailure(), serializationFailure(), serializationFailure(),
+		}}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(3, time.Millisecond), WithoutPreparedStatements())
+
+		err := repo.Delete(ctx, newUser(t).ID)
+		var pqErr *pq.Error
+		if !errors.As(err, &pqErr) || pqErr.Code != "40001" {
+			t.Fatalf("Expected the last 40001 error, got: %v", err)
+		}
+		if got := faults.attempts(); got != 3 {
+			t.Errorf("Expected 3 attempts, got: %d", got)
+		}
+	})
+
+	t.Run("Bounded By Context", func(t *testing.T) {
+		faults := &faultInjector{match: "DELETE FROM users"}
+		for i := 0; i < 100; i++ {
+			faults.faults = append(faults.faults, serializationFailure())
+		}
+		repo := NewUserRepository(openFaultyDB(t, faults), WithRetry(100, 50*time.Millisecond), WithoutPreparedStatements())
+
+		ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+
+		id := newUser(t).ID
+		start := time.Now()
+		err := repo.Delete(ctx, id)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected context.DeadlineExceeded, got: %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected retries to stop at the deadline, took: %s", elapsed)
+		}
+		if got := faults.attempts(); got >= 100 {
+			t.Errorf("Expected the deadline to cut retries short, got %d attempts", got)
+		}
+	})
+
+	t.Run("Not Retried Inside A Transaction", func(t *testing.T) {
+		faults := &faultInjector{match: "INSERT INTO users", faults: []error{serializationFailure()}}
+		db := openFaultyDB(t, faults)
+
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		_, err = NewUserRepository(db, WithRetry(3, time.Millisecond), WithoutPreparedStatements()).WithTx(tx).Create(ctx, fixtures.GenerateEmail(t), "Tx Retry")
+		if err == nil {
+			t.Fatal("Expected the injected error inside a transaction")
+		}
+		if got := faults.attempts(); got != 1 {
+			t.Errorf("Expected 1 attempt, got: %d", got)
+		}
+	})
+}
+
+// timeoutError is a net.Error, like the ones returned for a reset connection
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var _ net.Error = timeoutError{}
+
+// TestIsRetryable tests which errors count as transient
+func TestIsRetryable(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{serializationFailure(), true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "57P03"}, true},
+		{&pq.Error{Code: "53300"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{&pq.Error{Code: "23503"}, false},
+		{&pq.Error{Code: "42P01"}, false},
+		{&pgconn.PgError{Code: "40001"}, true},
+		{&pgconn.PgError{Code: "08006"}, true},
+		{&pgconn.PgError{Code: "23505"}, false},
+		{fmt.Errorf("wrapped: %w", &pgconn.PgError{Code: "40P01"}), true},
+		{fmt.Errorf("wrapped: %w", serializationFailure()), true},
+		{driver.ErrBadConn, true},
+		{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{fmt.Errorf("wrapped: %w", syscall.ECONNREFUSED), true},
+		// Sent, so the write may have committed
+		{timeoutError{}, false},
+		{io.EOF, false},
+		{io.ErrUnexpectedEOF, false},
+		{&net.OpError{Op: "write", Err: syscall.EPIPE}, false},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{sql.ErrNoRows, false},
+		{ErrDuplicateEmail, false},
+	}
+
+	for _, tc := range cases {
+		if got := isRetryable(tc.err); got != tc.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestIsConnError tests that only errors of the connection, not of the
+// statement or the session's other work, count as connection errors
+func TestIsConnError(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		err  error
+		want bool
+	}{
+		{&pq.Error{Code: "57P01"}, true},
+		{&pgconn.PgError{Code: "57P01"}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "40001"}, false},
+		{&pq.Error{Code: "53300"}, false},
+		{&pq.Error{Code: "23505"}, false},
+		{&net.OpError{Op: "write", Err: syscall.EPIPE}, true},
+		{fmt.Errorf("wrapped: %w", syscall.ECONNRESET), true},
+		{driver.ErrBadConn, true},
+		{io.EOF, true},
+		{nil, false},
+		{context.Canceled, false},
+		{sql.ErrNoRows, false},
+		{ErrUserNotFound, false},
+	}
+
+	for _, tc := range cases {
+		if got := isConnError(tc.err); got != tc.want {
+			t.Errorf("isConnError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+// TestRerunnable tests that reconnectDB reruns a statement after a
+// connection error only if the statement can't have taken effect
+func TestRerunnable(t *testing.T) {
+	t.Parallel()
+	const read, write = "  select id FROM users", "UPDATE users SET name = $1"
+	cases := []struct {
+		query string
+		err   error
+		want  bool
+	}{
+		{read, io.ErrUnexpectedEOF, true},
+		{read, &net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{write, io.ErrUnexpectedEOF, false},
+		{write, &net.OpError{Op: "read", Err: syscall.ECONNRESET}, false},
+		{"WITH u AS (DELETE FROM users RETURNING id) SELECT id FROM u", io.EOF, false},
+		{write, driver.ErrBadConn, true},
+		{write, &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}, true},
+		{write, &pq.Error{Code: "57P01"}, true},
+		{write, serializationFailure(), false},
+		{read, sql.ErrNoRows, false},
+		{read, nil, false},
+	}
+
+	for _, tc := range cases {
+		if got := rerunnable(tc.query, tc.err); got != tc.want {
+			t.Errorf("rerunnable(%q, %v) = %v, want %v", tc.query, tc.err, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestRoles tests creating, updating, and filtering users by role
+func TestRoles(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// Role lists and counts cover the whole table, so they need a database
+	// of their own
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+
+	// Seeded alice and bob are members
+	seeded := fixtures.SeedUsers(t, db,
+		fixtures.NewUser().WithRole(models.RoleAdmin),
+		fixtures.NewUser().WithRole(models.RoleAdmin),
+		fixtures.NewUser().WithRole(models.RoleGuest),
+		fixtures.NewUser(),
+	)
+
+	t.Run("Default Role", func(t *testing.T) {
+		if seeded[3].Role != models.RoleMember {
+			t.Errorf("Expected the database default role member, got: %q", seeded[3].Role)
+		}
+		user, err := repo.GetByEmail(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if user.Role != models.RoleMember {
+			t.Errorf("Expected existing users to be members, got: %q", user.Role)
+		}
+	})
+
+	t.Run("List By Role", func(t *testing.T) {
+		admins, err := repo.ListByRole(ctx, models.RoleAdmin, "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list admins: %v", err)
+		}
+		var ids []int
+		for _, u := range admins.Items {
+			if u.Role != models.RoleAdmin {
+				t.Errorf("Expected only admins, got %s with role %q", u.Email, u.Role)
+			}
+			ids = append(ids, u.ID)
+		}
+		if want := []int{seeded[0].ID, seeded[1].ID}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("Expected admin IDs %v, got: %v", want, ids)
+		}
+
+		guests, err := repo.ListByRole(ctx, models.RoleGuest, "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list guests: %v", err)
+		}
+		if len(guests.Items) != 1 || guests.Items[0].ID != seeded[2].ID {
+			t.Errorf("Expected only the seeded guest, got: %+v", guests)
+		}
+	})
+
+	t.Run("Count By Role", func(t *testing.T) {
+		counts, err := repo.CountByRole(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count by role: %v", err)
+		}
+		want := map[models.Role]int{models.RoleAdmin: 2, models.RoleMember: 3, models.RoleGuest: 1}
+		if !reflect.DeepEqual(counts, want) {
+			t.Errorf("Expected %v, got: %v", want, counts)
+		}
+	})
+
+	t.Run("Create And Update With Role", func(t *testing.T) {
+		user, err := repo.CreateWithRole(ctx, fixtures.GenerateEmail(t), "Guest Role", models.RoleGuest)
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		t.Cleanup(func() { repo.Delete(ctx, user.ID) })
+		if user.Role != models.RoleGuest {
+			t.Errorf("Expected role guest, got: %q", user.Role)
+		}
+
+		if err := repo.UpdateWithRole(ctx, user.ID, user.Email, user.Name, models.RoleAdmin); err != nil {
+			t.Fatalf("Failed to update role: %v", err)
+		}
+		// Update without a role keeps the current one
+		if err := repo.Update(ctx, user.ID, user.Email, "Renamed Admin"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+
+		got, err := repo.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.Role != models.RoleAdmin || got.Name != "Renamed Admin" {
+			t.Errorf("Expected admin 'Renamed Admin', got: %q %q", got.Role, got.Name)
+		}
+	})
+
+	t.Run("Invalid Role Rejected", func(t *testing.T) {
+		var validationErr *ValidationError
+
+		_, err := repo.CreateWithRole(ctx, "superuser@example.com", "Superuser", "superuser")
+		if !errors.As(err, &validationErr) || validationErr.Fields[0].Field != "role" {
+			t.Errorf("Expected a role ValidationError from CreateWithRole, got: %v", err)
+		}
+		member := seeded[3]
+		if err := repo.UpdateWithRole(ctx, member.ID, member.Email, member.Name, "Admin"); !errors.As(err, &validationErr) {
+			t.Errorf("Expected ValidationError from UpdateWithRole, got: %v", err)
+		}
+		if _, err := repo.ListByRole(ctx, "root", "", 0); !errors.As(err, &validationErr) {
+			t.Errorf("Expected ValidationError from ListByRole, got: %v", err)
+		}
+	})
+}
+
+// TestCachedRoles tests that roles survive the cache and role changes invalidate it
+func TestCachedRoles(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+
+	t.Run("Entry Without Role", func(t *testing.T) {
+		existing := newUser(t)
+
+		// The payload cached before roles existed
+		legacy := fmt.Sprintf(`{"id":%d,"email":%q,"name":%q,"created_at":"2024-01-02T03:04:05Z"}`,
+			existing.ID, existing.Email, existing.Name)
+		if err := redisClient.Set(ctx, fmt.Sprintf("user:default:%d", existing.ID), legacy, 0).Err(); err != nil {
+			t.Fatalf("Failed to write legacy entry: %v", err)
+		}
+
+		user, err := cachedRepo.GetByIDCached(ctx, existing.ID)
+		if err != nil {
+			t.Fatalf("Failed to read legacy entry: %v", err)
+		}
+		if user.Role != models.RoleMember {
+			t.Errorf("Expected role to default to member, got: %q", user.Role)
+		}
+		if user.CreatedAt.Year() != 2024 {
+			t.Errorf("Expected the cached entry to be served, got created_at: %s", user.CreatedAt)
+		}
+	})
+
+	t.Run("Role Change Invalidates", func(t *testing.T) {
+		id := newUser(t).ID
+		if _, err := cachedRepo.GetByIDCached(ctx, id); err != nil {
+			t.Fatalf("Failed to populate cache: %v", err)
+		}
+
+		if err := cachedRepo.UpdateRoleCached(ctx, id, models.RoleAdmin); err != nil {
+			t.Fatalf("Failed to update role: %v", err)
+		}
+		user, err := cachedRepo.GetByIDCached(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if user.Role != models.RoleAdmin {
+			t.Errorf("Expected the new role after invalidation, got: %q", user.Role)
+		}
+
+		cached, err := redisClient.Get(ctx, fmt.Sprintf("user:default:%d", id)).Result()
+		if err != nil {
+			t.Fatalf("Expected user to be cached again: %v", err)
+		}
+		var payload models.User
+		if err := json.Unmarshal([]byte(cached), &payload); err != nil || payload.Role != models.RoleAdmin {
+			t.Errorf("Expected role in the cached payload, got: %s", cached)
+		}
+	})
+
+	t.Run("Invalid Role", func(t *testing.T) {
+		var validationErr *ValidationError
+		if err := cachedRepo.UpdateRoleCached(ctx, newUser(t).ID, "owner"); !errors.As(err, &validationErr) {
+			t.Errorf("Expected ValidationError, got: %v", err)
+		}
+		if err := cachedRepo.UpdateRoleCached(ctx, missingID, models.RoleGuest); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// roundTripSeed fixes the generated users; change it to explore others
+const roundTripSeed = 20240101
+
+// assertRoundTrip fails unless got has want's fields byte for byte, and
+// the same creation instant
+func assertRoundTrip(t *testing.T, via string, got *models.User, want models.User) {
+	t.Helper()
+	if got.ID != want.ID || got.UUID != want.UUID || got.Email != want.Email || got.Name != want.Name || got.Role != want.Role {
+		t.Errorf("%s: expected %+v, got: %+v", via, want, *got)
+	}
+	if !got.CreatedAt.Equal(want.CreatedAt) {
+		t.Errorf("%s: user %d: expected created_at %s, got: %s", via, want.ID, want.CreatedAt, got.CreatedAt)
+	}
+}
+
+// TestGeneratedRoundTrip tests that 500 generated users, edge cases
+// included, come back unchanged from Postgres and from the Redis cache
+func TestGeneratedRoundTrip(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+	cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t))
+
+	created := fixtures.InsertGenerated(t, repo, roundTripSeed, 500)
+	generated := fixtures.GenerateUsers(roundTripSeed, 500)
+
+	for i, want := range created {
+		if want.Email != generated[i].Email || want.Name != generated[i].Name {
+			t.Fatalf("Create changed user %d: expected %+v, got: %+v", i, generated[i], want)
+		}
+
+		got, err := repo.GetByEmail(ctx, want.Email)
+		if err != nil {
+			t.Fatalf("Failed to get %q: %v", want.Email, err)
+		}
+		assertRoundTrip(t, "Postgres", got, want)
+
+		// The first read fills the cache, the second is served from it
+		for _, via := range []string{"cache miss", "cache hit"} {
+			got, err := cachedRepo.GetByIDCached(ctx, want.ID)
+			if err != nil {
+				t.Fatalf("Failed to get user %d (%s): %v", want.ID, via, err)
+			}
+			assertRoundTrip(t, via, got, want)
+		}
+	}
+}
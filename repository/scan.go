@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"testcontainers-demo/models"
+)
+
+// userColumns are the users columns every query returning users selects, in
+// the order userFields scans them. Add a column here and to userFields
+// together; TestUserColumns fails when they disagree.
+const userColumns = "id, uuid, email, name, role, created_at"
+
+// userDetailColumns are userColumns plus what only the single-user lookups
+// read, in the order userDetailFields scans them
+const userDetailColumns = userColumns + ", COALESCE(avatar_key, ''), version, erased_at IS NOT NULL"
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// userFields returns the destinations for userColumns in user
+func userFields(user *models.User) []interface{} {
+	return []interface{}{&user.ID, &user.UUID, &user.Email, nullAsEmpty{&user.Name}, &user.Role, utcTime{&user.CreatedAt}}
+}
+
+// utcTime scans a timestamp column into a time.Time in UTC. lib/pq returns
+// a timestamptz in the session's TimeZone, so without it the same user
+// would read back with a different Location depending on the connection,
+// and compare unequal to its cached copy. NULL reads as the zero time.
+type utcTime struct {
+	dst *time.Time
+}
+
+// Scan implements sql.Scanner
+func (u utcTime) Scan(src interface{}) error {
+	var t sql.NullTime
+	if err := t.Scan(src); err != nil {
+		return err
+	}
+	*u.dst = t.Time.UTC()
+	return nil
+}
+
+// nullAsEmpty scans a nullable text column into a string, reading NULL as
+// the empty string. Users imported from legacy systems, into tables created
+// before the name column was NOT NULL, can have a NULL name; they read back
+// with Name "", and writes still require a name.
+type nullAsEmpty struct {
+	dst *string
+}
+
+// Scan implements sql.Scanner
+func (n nullAsEmpty) Scan(src interface{}) error {
+	var s sql.NullString
+	if err := s.Scan(src); err != nil {
+		return err
+	}
+	*n.dst = s.String
+	return nil
+}
+
+// userDetailFields returns the destinations for userDetailColumns in user
+func userDetailFields(user *models.User) []interface{} {
+	return append(userFields(user), &user.AvatarKey, &user.Version, &user.Erased)
+}
+
+// prefixedUserColumns is userColumns qualified with alias, for queries
+// joining users to something else
+func prefixedUserColumns(alias string) string {
+	columns := strings.Split(userColumns, ", ")
+	for i, c := range columns {
+		columns[i] = alias + "." + c
+	}
+	return strings.Join(columns, ", ")
+}
+
+// scanUser scans a row of userColumns. sql.ErrNoRows is returned unwrapped.
+func scanUser(row rowScanner) (*models.User, error) {
+	var user models.User
+	if err := row.Scan(userFields(&user)...); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// scanUserDetail is scanUser for a row of userDetailColumns
+func scanUserDetail(row rowScanner) (*models.User, error) {
+	var user models.User
+	if err := row.Scan(userDetailFields(&user)...); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// scanUsers scans every remaining row of userColumns and closes rows. With
+// no rows it returns an empty, non-nil slice.
+func scanUsers(rows *sql.Rows) (_ []models.User, err error) {
+	defer closeRows(rows, &err)
+
+	users := []models.User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+	return users, nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+// countColumns counts the columns of a SELECT list, skipping the commas
+// inside calls like COALESCE(a, b)
+func countColumns(columns string) int {
+	n, depth := 1, 0
+	for _, c := range columns {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+// columnsMismatch reports whether columns, a SELECT list, has as many
+// columns as fields has destinations, naming fields' function when it doesn't
+func columnsMismatch(columns string, fields []interface{}, fieldsFunc string) error {
+	if n := countColumns(columns); n != len(fields) {
+		return fmt.Errorf("%d columns but %s scans %d; update %s with the column list", n, fieldsFunc, len(fields), fieldsFunc)
+	}
+	return nil
+}
+
+// TestUserColumns tests that each column list and the helper scanning it
+// agree, and that a column added to only one of them is caught there
+func TestUserColumns(t *testing.T) {
+	t.Parallel()
+	var user models.User
+
+	if err := columnsMismatch(userColumns, userFields(&user), "userFields"); err != nil {
+		t.Errorf("userColumns: %v", err)
+	}
+	if err := columnsMismatch(userDetailColumns, userDetailFields(&user), "userDetailFields"); err != nil {
+		t.Errorf("userDetailColumns: %v", err)
+	}
+	if got, want := prefixedUserColumns("u"), "u.id, u.uuid, u.email, u.name, u.role, u.created_at"; got != want {
+		t.Errorf("Expected %q, got: %q", want, got)
+	}
+
+	t.Run("Added Column", func(t *testing.T) {
+		// A column added to the constant alone fails here, naming the helper...
+		err := columnsMismatch(userColumns+", deleted_at", userFields(&user), "userFields")
+		if err == nil || !strings.Contains(err.Error(), "update userFields") {
+			t.Errorf("Expected the mismatch to point at userFields, got: %v", err)
+		}
+
+		// ...and every query built from it fails in the one scan helper
+		requirePostgres(t)
+		ctx := context.Background()
+		_, err = scanUser(testDB.QueryRowContext(ctx, "SELECT "+userColumns+", deleted_at FROM users LIMIT 1"))
+		if err == nil || !strings.Contains(err.Error(), "destination arguments") {
+			t.Errorf("Expected scanUser to reject the extra column, got: %v", err)
+		}
+	})
+}
+
+// TestNullName tests that a legacy row with a NULL name, inserted behind the
+// repository's back, reads with an empty Name on every read path
+func TestNullName(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// A database of its own, whose users table predates the NOT NULL name
+	db := testContainer.CreateTestDatabase(ctx, t)
+	if _, err := db.ExecContext(ctx, "ALTER TABLE users ALTER COLUMN name DROP NOT NULL"); err != nil {
+		t.Fatalf("Failed to drop NOT NULL: %v", err)
+	}
+	repo := NewUserRepository(db)
+	cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t))
+
+	email := fixtures.GenerateEmail(t)
+	var id int
+	if err := db.QueryRowContext(ctx,
+		"INSERT INTO users (email, name) VALUES ($1, NULL) RETURNING id", email,
+	).Scan(&id); err != nil {
+		t.Fatalf("Failed to insert legacy user: %v", err)
+	}
+
+	// expectLegacy fails unless user is the legacy row, read with an empty name
+	expectLegacy := func(t *testing.T, user *models.User) {
+		t.Helper()
+		if user.ID != id || user.Email != email || user.Name != "" {
+			t.Errorf("Expected user %d with an empty name, got: %+v", id, user)
+		}
+	}
+
+	t.Run("GetByID", func(t *testing.T) {
+		user, err := repo.GetByID(ctx, id)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		expectLegacy(t, user)
+	})
+
+	t.Run("GetByEmail", func(t *testing.T) {
+		user, err := repo.GetByEmail(ctx, email)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		expectLegacy(t, user)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		users, err := repo.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		if len(users) != 1 {
+			t.Fatalf("Expected 1 user, got: %d", len(users))
+		}
+		expectLegacy(t, &users[0])
+
+		page, err := repo.ListPaginated(ctx, "", 10)
+		if err != nil {
+			t.Fatalf("Failed to list page: %v", err)
+		}
+		if len(page.Items) != 1 {
+			t.Fatalf("Expected 1 user, got: %d", len(page.Items))
+		}
+		expectLegacy(t, &page.Items[0])
+	})
+
+	t.Run("GetByIDCached", func(t *testing.T) {
+		// The first read fills the cache, the second reads it back
+		for _, read := range []string{"miss", "hit"} {
+			user, err := cachedRepo.GetByIDCached(ctx, id)
+			if err != nil {
+				t.Fatalf("Failed to get user on cache %s: %v", read, err)
+			}
+			expectLegacy(t, user)
+		}
+	})
+
+	t.Run("Writes Still Require A Name", func(t *testing.T) {
+		err := repo.Update(ctx, id, email, "")
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("Expected a ValidationError, got: %v", err)
+		}
+	})
+}
@@ -0,0 +1,90 @@
+package repository
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+)
+
+// Dialect is the SQL flavour a UserRepository writes its queries in
+type Dialect int
+
+const (
+	// DialectPostgres is the default, what NewUserRepository uses
+	DialectPostgres Dialect = iota
+	// DialectSQLite targets SQLite 3.35 or later (for RETURNING), e.g. an
+	// in-memory modernc.org/sqlite database in tests without Docker. Create
+	// the schema with CreateSQLiteSchema.
+	DialectSQLite
+)
+
+// NewUserRepositoryForDialect creates a user repository whose queries are
+// written for dialect. On SQLite the UserStore methods, ListOrdered,
+// ListEach, ListChan, ExportUsers, UpdateWithVersion, CreateWithPassword,
+// and Authenticate work; the rest still use Postgres-only SQL and fail
+// there.
+//
+// Known differences on SQLite:
+//   - lower() folds only ASCII, so the unique email index and
+//     FindByNamePattern treat "É" and "é" as different letters
+//   - created_at has millisecond precision instead of microsecond
+//   - writes are never retried: SQLite reports no transient errors the
+//     retry policy recognizes
+func NewUserRepositoryForDialect(db DBTX, dialect Dialect, opts ...Option) *UserRepository {
+	r := NewUserRepository(db, opts...)
+	r.dialect = dialect
+	return r
+}
+
+//go:embed sqlite_schema.sql
+var sqliteSchema string
+
+// CreateSQLiteSchema creates the users and user_events tables, their indexes,
+// and the triggers that fill the outbox on SQLite. Tables that already exist
+// are left alone.
+func CreateSQLiteSchema(ctx context.Context, db DBTX) error {
+	if _, err := db.ExecContext(ctx, sqliteSchema); err != nil {
+		return fmt.Errorf("failed to create SQLite schema: %w", err)
+	}
+	return nil
+}
+
+// sqliteTimeLayout is how the SQLite schema stores timestamps, UTC with a
+// fixed width so they compare correctly as text
+const sqliteTimeLayout = "2006-01-02 15:04:05.000"
+
+// Queries whose Postgres form SQLite can't run. There are no data-modifying
+// CTEs, so writes are plain statements and the schema's triggers insert the
+// outbox events; there is no gen_random_uuid(), so create passes a UUID.
+const (
+	sqliteCreateUser = `INSERT INTO users (email, name, role, password_hash, tenant_id, uuid)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING ` + userColumns
+	sqliteUpdateUser         = "UPDATE users SET email = $1, name = $2 WHERE id = $3 AND tenant_id = $4"
+	sqliteUpdateUserWithRole = "UPDATE users SET email = $1, name = $2, role = $5 WHERE id = $3 AND tenant_id = $4"
+	sqliteDeleteUser         = "DELETE FROM users WHERE id = $1 AND tenant_id = $2"
+	// The users_updated trigger increments version
+	sqliteUpdateUserWithVersion = "UPDATE users SET email = $1, name = $2, role = $3 WHERE id = $4 AND version = $5 AND tenant_id = $6"
+	// No ILIKE; lower() on both sides keeps LIKE case-insensitive even under
+	// PRAGMA case_sensitive_like, though still only for ASCII. SQLite has no
+	// default escape character; Postgres' is the backslash.
+	sqliteFindByNamePattern = "SELECT " + userColumns + ` FROM users WHERE lower(name) LIKE lower($1) ESCAPE '\' AND tenant_id = $2 ORDER BY id`
+)
+
+// sqliteConstraintUnique is SQLite's extended result code for a unique
+// constraint violation
+const sqliteConstraintUnique = 2067
+
+// sqliteError matches modernc.org/sqlite's *Error by its method, so the
+// repository doesn't link SQLite into every binary that imports it
+type sqliteError interface {
+	error
+	Code() int
+}
+
+// isSQLiteUniqueViolation reports whether err is a SQLite unique constraint violation
+func isSQLiteUniqueViolation(err error) bool {
+	var sqliteErr sqliteError
+	return errors.As(err, &sqliteErr) && sqliteErr.Code() == sqliteConstraintUnique
+}
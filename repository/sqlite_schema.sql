@@ -0,0 +1,59 @@
+-- repository/sqlite_schema.sql
+-- The users table and user_events outbox as the Postgres migrations leave
+-- them, for NewUserRepositoryForDialect(db, DialectSQLite). SQLite has no
+-- data-modifying CTEs, so triggers record the events instead.
+CREATE TABLE IF NOT EXISTS users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    uuid TEXT NOT NULL UNIQUE,
+    tenant_id TEXT NOT NULL DEFAULT 'default',
+    email TEXT NOT NULL,
+    name TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'member' CHECK (role IN ('admin', 'member', 'guest')),
+    password_hash TEXT,
+    avatar_key TEXT,
+    version INTEGER NOT NULL DEFAULT 1,
+    -- Fixed-width UTC text, so comparing timestamps as strings orders them
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    updated_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    deleted_at TIMESTAMP,
+    erased_at TIMESTAMP
+);
+
+-- SQLite's lower() only folds ASCII, unlike Postgres'
+CREATE UNIQUE INDEX IF NOT EXISTS users_tenant_email_lower_key ON users (tenant_id, lower(email));
+CREATE INDEX IF NOT EXISTS users_created_at_idx ON users (tenant_id, created_at, id);
+CREATE INDEX IF NOT EXISTS users_name_idx ON users (tenant_id, name, id);
+
+CREATE TABLE IF NOT EXISTS user_events (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id INTEGER NOT NULL,
+    event_type TEXT NOT NULL CHECK (event_type IN ('user.created', 'user.updated', 'user.deleted')),
+    payload TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT (strftime('%Y-%m-%d %H:%M:%f', 'now')),
+    dispatched_at TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS users_created AFTER INSERT ON users
+BEGIN
+    INSERT INTO user_events (user_id, event_type, payload)
+    VALUES (NEW.id, 'user.created', json_object(
+        'id', NEW.id, 'uuid', NEW.uuid, 'email', NEW.email, 'name', NEW.name, 'role', NEW.role,
+        'created_at', strftime('%Y-%m-%dT%H:%M:%fZ', NEW.created_at)));
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_updated AFTER UPDATE OF email, name, role ON users
+BEGIN
+    UPDATE users SET updated_at = strftime('%Y-%m-%d %H:%M:%f', 'now'), version = version + 1 WHERE id = NEW.id;
+    INSERT INTO user_events (user_id, event_type, payload)
+    VALUES (NEW.id, 'user.updated', json_object(
+        'id', NEW.id, 'uuid', NEW.uuid, 'email', NEW.email, 'name', NEW.name, 'role', NEW.role,
+        'created_at', strftime('%Y-%m-%dT%H:%M:%fZ', NEW.created_at)));
+END;
+
+CREATE TRIGGER IF NOT EXISTS users_deleted AFTER DELETE ON users
+BEGIN
+    INSERT INTO user_events (user_id, event_type, payload)
+    VALUES (OLD.id, 'user.deleted', json_object(
+        'id', OLD.id, 'uuid', OLD.uuid, 'email', OLD.email, 'name', OLD.name, 'role', OLD.role,
+        'created_at', strftime('%Y-%m-%dT%H:%M:%fZ', OLD.created_at)));
+END;
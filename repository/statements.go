@@ -0,0 +1,171 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// errStmtClosed is database/sql's message for a statement used after Close;
+// it can happen when another goroutine evicts the statement mid-call
+const errStmtClosed = "sql: statement is closed"
+
+// preparedDB is a DBTX that prepares each distinct query once on the pool and
+// reuses the statement afterwards, saving the driver a parse round trip per call.
+// database/sql re-prepares a statement on each pooled connection as needed.
+type preparedDB struct {
+	db    *sql.DB
+	stmts sync.Map // query -> *sql.Stmt
+}
+
+// newPreparedDB wraps db with an empty statement cache
+func newPreparedDB(db *sql.DB) *preparedDB {
+	return &preparedDB{db: db}
+}
+
+// stmt returns the cached statement for query, preparing it on first use
+func (p *preparedDB) stmt(ctx context.Context, query string) (*sql.Stmt, error) {
+	if v, ok := p.stmts.Load(query); ok {
+		return v.(*sql.Stmt), nil
+	}
+
+	stmt, err := p.db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	// Another goroutine may have prepared the same query meanwhile
+	if v, loaded := p.stmts.LoadOrStore(query, stmt); loaded {
+		_ = stmt.Close() // never used, so nothing depends on its Close
+		return v.(*sql.Stmt), nil
+	}
+	return stmt, nil
+}
+
+// evict drops stmt from the cache so the next call re-prepares query; the
+// call that found it stale runs unprepared instead
+func (p *preparedDB) evict(query string, stmt *sql.Stmt) {
+	if p.stmts.CompareAndDelete(query, stmt) {
+		_ = stmt.Close() // already failing; the caller reports that error
+	}
+}
+
+// close closes every cached statement
+func (p *preparedDB) close() error {
+	var errs []error
+	p.stmts.Range(func(key, v interface{}) bool {
+		p.stmts.Delete(key)
+		if err := v.(*sql.Stmt).Close(); err != nil {
+			errs = append(errs, err)
+		}
+		return true
+	})
+	return errors.Join(errs...)
+}
+
+// PingContext pings the underlying pool
+func (p *preparedDB) PingContext(ctx context.Context) error {
+	return p.db.PingContext(ctx)
+}
+
+// ExecContext runs query as a prepared statement
+func (p *preparedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	stmt, err := p.stmt(ctx, query)
+	if err != nil {
+		// Unprepared, so the caller sees the same error it would without the cache
+		return p.db.ExecContext(ctx, query, args...)
+	}
+	result, err := stmt.ExecContext(ctx, args...)
+	if isStaleStmt(err) {
+		p.evict(query, stmt)
+		return p.db.ExecContext(ctx, query, args...)
+	}
+	return result, err
+}
+
+// QueryContext runs query as a prepared statement
+func (p *preparedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	stmt, err := p.stmt(ctx, query)
+	if err != nil {
+		return p.db.QueryContext(ctx, query, args...)
+	}
+	rows, err := stmt.QueryContext(ctx, args...)
+	if isStaleStmt(err) {
+		p.evict(query, stmt)
+		return p.db.QueryContext(ctx, query, args...)
+	}
+	return rows, err
+}
+
+// QueryRowContext runs query as a prepared statement; Row.Err exposes the
+// execution error before the caller scans
+func (p *preparedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	stmt, err := p.stmt(ctx, query)
+	if err != nil {
+		return p.db.QueryRowContext(ctx, query, args...)
+	}
+	row := stmt.QueryRowContext(ctx, args...)
+	if isStaleStmt(row.Err()) {
+		p.evict(query, stmt)
+		return p.db.QueryRowContext(ctx, query, args...)
+	}
+	return row
+}
+
+// preparedTx runs queries inside a transaction, reusing statements the pool
+// has already prepared. It never prepares on the pool itself, which would
+// need a second connection while the transaction holds one, and it doesn't
+// retry stale statements: Postgres aborts the transaction on the first error.
+type preparedTx struct {
+	tx    *sql.Tx
+	cache *preparedDB
+}
+
+// cached returns the transaction-specific copy of query's pool statement,
+// closed when the transaction ends, or nil if the pool hasn't prepared it
+func (p *preparedTx) cached(ctx context.Context, query string) *sql.Stmt {
+	v, ok := p.cache.stmts.Load(query)
+	if !ok {
+		return nil
+	}
+	return p.tx.StmtContext(ctx, v.(*sql.Stmt))
+}
+
+// ExecContext runs query inside the transaction
+func (p *preparedTx) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if stmt := p.cached(ctx, query); stmt != nil {
+		return stmt.ExecContext(ctx, args...)
+	}
+	return p.tx.ExecContext(ctx, query, args...)
+}
+
+// QueryContext runs query inside the transaction
+func (p *preparedTx) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if stmt := p.cached(ctx, query); stmt != nil {
+		return stmt.QueryContext(ctx, args...)
+	}
+	return p.tx.QueryContext(ctx, query, args...)
+}
+
+// QueryRowContext runs query inside the transaction
+func (p *preparedTx) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	if stmt := p.cached(ctx, query); stmt != nil {
+		return stmt.QueryRowContext(ctx, args...)
+	}
+	return p.tx.QueryRowContext(ctx, query, args...)
+}
+
+// isStaleStmt reports whether a prepared statement must be re-prepared: its
+// plan was invalidated by a schema change (Postgres "cached plan must not
+// change result type"), or it was evicted and closed by another goroutine
+func isStaleStmt(err error) bool {
+	if err == nil {
+		return false
+	}
+	if pgErr, ok := asPgError(err); ok {
+		return pgErr.Code == "0A000" && strings.Contains(pgErr.Message, "cached plan must not change result type")
+	}
+	return err.Error() == errStmtClosed
+}
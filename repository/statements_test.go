@@ -0,0 +1,200 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+)
+
+// cachedStatements counts the statements a repository has prepared
+func cachedStatements(repo *UserRepository) int {
+	n := 0
+	repo.stmts.stmts.Range(func(_, _ interface{}) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// TestPreparedStatements tests the lazily prepared statement cache
+func TestPreparedStatements(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	user := newUser(t)
+
+	t.Run("Prepared Once And Reused", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		defer repo.Close()
+
+		for i := 0; i < 3; i++ {
+			if _, err := repo.GetByID(ctx, user.ID); err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+		}
+		if _, err := repo.GetByEmail(ctx, user.Email); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		if got := cachedStatements(repo); got != 2 {
+			t.Errorf("Expected 2 cached statements, got: %d", got)
+		}
+	})
+
+	t.Run("Concurrent Use", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		defer repo.Close()
+
+		ids := []int{user.ID, newUser(t).ID}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 50)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := repo.GetByID(ctx, ids[i%2]); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			t.Errorf("Concurrent GetByID failed: %v", err)
+		}
+		if got := cachedStatements(repo); got != 1 {
+			t.Errorf("Expected 1 cached statement, got: %d", got)
+		}
+	})
+
+	t.Run("Close", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		if err := repo.Close(); err != nil {
+			t.Fatalf("Failed to close repository: %v", err)
+		}
+		if got := cachedStatements(repo); got != 0 {
+			t.Errorf("Expected no cached statements after Close, got: %d", got)
+		}
+
+		// Still usable; statements are prepared again on demand
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Errorf("Expected GetByID to work after Close, got: %v", err)
+		}
+		repo.Close()
+	})
+
+	t.Run("Reused Inside Transaction", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		defer repo.Close()
+
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		tx, err := testDB.Begin()
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+
+		txRepo := repo.WithTx(tx)
+		created, err := txRepo.Create(ctx, fixtures.GenerateEmail(t), "Prepared Tx")
+		if err != nil {
+			t.Fatalf("Failed to create user in transaction: %v", err)
+		}
+
+		// The cached GetByID statement must see the transaction's own write
+		if _, err := txRepo.GetByID(ctx, created.ID); err != nil {
+			t.Errorf("Expected to read own write inside transaction, got: %v", err)
+		}
+		if err := txRepo.Close(); err != nil {
+			t.Errorf("Expected Close on a transaction copy to be a no-op, got: %v", err)
+		}
+		if got := cachedStatements(repo); got != 1 {
+			t.Errorf("Expected transaction not to add pool statements, got: %d", got)
+		}
+	})
+
+	t.Run("Re-prepared After Schema Change", func(t *testing.T) {
+		db := testContainer.CreateTestDatabase(ctx, t)
+		// One connection, so the second call hits the connection holding the old plan
+		db.SetMaxOpenConns(1)
+
+		repo := NewUserRepository(db)
+		defer repo.Close()
+
+		if _, err := repo.GetByID(ctx, 1); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		// Changing a selected column's type invalidates the cached plan's result type
+		if _, err := db.Exec("ALTER TABLE users ALTER COLUMN name TYPE TEXT"); err != nil {
+			t.Fatalf("Failed to alter table: %v", err)
+		}
+
+		for i := 0; i < 2; i++ {
+			user, err := repo.GetByID(ctx, 1)
+			if err != nil {
+				t.Fatalf("Call %d after schema change failed: %v", i+1, err)
+			}
+			if user.Email != "alice@example.com" {
+				t.Errorf("Expected alice@example.com, got: %s", user.Email)
+			}
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		repo := NewUserRepository(testDB, WithoutPreparedStatements())
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if repo.stmts != nil {
+			t.Error("Expected no statement cache with WithoutPreparedStatements")
+		}
+		if err := repo.Close(); err != nil {
+			t.Errorf("Expected Close to be a no-op, got: %v", err)
+		}
+	})
+}
+
+// BenchmarkGetByID compares prepared and plain-text GetByID in a tight loop:
+//
+//	go test ./repository -run '^$' -bench GetByID
+func BenchmarkGetByID(b *testing.B) {
+	requirePostgres(b)
+	ctx := context.Background()
+
+	for _, bc := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Unprepared", []Option{WithoutPreparedStatements()}},
+		{"Prepared", nil},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			repo := NewUserRepository(testDB, bc.opts...)
+			defer repo.Close()
+
+			// Warm up the pool (and the statement cache)
+			if _, err := repo.GetByID(ctx, 1); err != nil {
+				b.Fatalf("Failed to get user: %v", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := repo.GetByID(ctx, 1); err != nil {
+					b.Fatalf("Failed to get user: %v", err)
+				}
+			}
+		})
+	}
+}
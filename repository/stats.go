@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Bounds on the range of GetUserStats, which returns one bucket per day
+const (
+	maxStatsDays     = 3660
+	defaultStatsDays = 30 // ParseStatsRange's range without a from
+)
+
+// UserStats summarizes the users created in [From, To), in UTC
+type UserStats struct {
+	From    time.Time     `json:"from"`
+	To      time.Time     `json:"to"`
+	Total   int           `json:"total"`
+	Days    []DayCount    `json:"days"`    // every day the range touches, oldest first, including days without signups
+	Busiest *DayCount     `json:"busiest"` // the day with the most signups, the earliest on a tie; nil without signups
+	Domains []DomainCount `json:"domains"` // most users first, then by domain
+}
+
+// DayCount is how many users signed up on Day, midnight UTC
+type DayCount struct {
+	Day     time.Time `json:"day"`
+	Signups int       `json:"signups"`
+}
+
+// DomainCount is how many users have an email at Domain
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Users  int    `json:"users"`
+}
+
+// selectUserStats returns a ("day", YYYY-MM-DD, signups) row per day from
+// $1 to $2 and a ("domain", domain, users) row per email domain of tenant
+// $3's users, in one query so both see the same snapshot. Days are cut in UTC, whatever the
+// session's TimeZone.
+const selectUserStats = `
+	WITH in_range AS (
+		SELECT created_at, email FROM users
+		WHERE created_at >= $1::timestamptz AND created_at < $2::timestamptz AND tenant_id = $3
+	), days AS (
+		SELECT generate_series(
+			date_trunc('day', $1::timestamptz AT TIME ZONE 'UTC'),
+			date_trunc('day', ($2::timestamptz - INTERVAL '1 microsecond') AT TIME ZONE 'UTC'),
+			INTERVAL '1 day'
+		) AS day
+	)
+	SELECT 'day', to_char(d.day, 'YYYY-MM-DD'), COUNT(r.created_at)
+	FROM days d LEFT JOIN in_range r ON date_trunc('day', r.created_at AT TIME ZONE 'UTC') = d.day
+	GROUP BY d.day
+	UNION ALL
+	SELECT 'domain', lower(split_part(email, '@', 2)), COUNT(*)
+	FROM in_range
+	GROUP BY 2
+`
+
+// GetUserStats reports the signups per day and per email domain of the
+// users created from from up to but excluding to. Days are UTC, and a day
+// without signups gets a zero bucket, so a chart of Days has no gaps. A
+// range with to not after from returns zeroed stats; one longer than about
+// ten years fails with a *ValidationError.
+func (r *UserRepository) GetUserStats(ctx context.Context, from, to time.Time) (_ *UserStats, err error) {
+	const op = "UserRepository.GetUserStats"
+	from, to = from.UTC(), to.UTC()
+	key := fmt.Sprintf("from=%s to=%s", from.Format(time.RFC3339), to.Format(time.RFC3339))
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectUserStats}, from, to)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	stats := &UserStats{From: from, To: to, Days: []DayCount{}, Domains: []DomainCount{}}
+	if !to.After(from) {
+		return stats, nil
+	}
+	if to.Sub(from) > maxStatsDays*24*time.Hour {
+		return nil, newRepoError(op, key, &ValidationError{Fields: []FieldError{
+			{Field: "to", Message: fmt.Sprintf("must be within %d days of from", maxStatsDays)},
+		}})
+	}
+
+	rows, err := r.reads().QueryContext(ctx, selectUserStats, from, to, r.tenant)
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to get user stats: %w", err))
+	}
+	defer closeRows(rows, &err)
+
+	for rows.Next() {
+		var kind, label string
+		var count int
+		if err := rows.Scan(&kind, &label, &count); err != nil {
+			return nil, newRepoError(op, key, fmt.Errorf("failed to scan user stats: %w", err))
+		}
+		if kind == "domain" {
+			stats.Domains = append(stats.Domains, DomainCount{Domain: label, Users: count})
+			continue
+		}
+		day, err := time.Parse(time.DateOnly, label)
+		if err != nil {
+			return nil, newRepoError(op, key, fmt.Errorf("failed to parse day %q: %w", label, err))
+		}
+		stats.Days = append(stats.Days, DayCount{Day: day, Signups: count})
+		stats.Total += count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("error iterating user stats: %w", err))
+	}
+
+	slices.SortFunc(stats.Days, func(a, b DayCount) int { return a.Day.Compare(b.Day) })
+	for _, d := range stats.Days {
+		if d.Signups > 0 && (stats.Busiest == nil || d.Signups > stats.Busiest.Signups) {
+			stats.Busiest = &d
+		}
+	}
+	slices.SortFunc(stats.Domains, func(a, b DomainCount) int {
+		return cmp.Or(cmp.Compare(b.Users, a.Users), cmp.Compare(a.Domain, b.Domain))
+	})
+	return stats, nil
+}
+
+// ParseStatsRange parses the days from and to, YYYY-MM-DD in UTC, into the
+// range GetUserStats takes, including all of to. An empty to is the day of
+// now, and an empty from the 30 days ending with to. A malformed day is
+// reported as a *ValidationError.
+func ParseStatsRange(from, to string, now time.Time) (start, end time.Time, err error) {
+	var fields []FieldError
+	last := now.UTC().Truncate(24 * time.Hour)
+	if to != "" {
+		if last, err = time.Parse(time.DateOnly, to); err != nil {
+			fields = append(fields, FieldError{Field: "to", Message: "must be a day as YYYY-MM-DD"})
+		}
+	}
+	start = last.AddDate(0, 0, 1-defaultStatsDays)
+	if from != "" {
+		if start, err = time.Parse(time.DateOnly, from); err != nil {
+			fields = append(fields, FieldError{Field: "from", Message: "must be a day as YYYY-MM-DD"})
+		}
+	}
+	if len(fields) > 0 {
+		return time.Time{}, time.Time{}, &ValidationError{Fields: fields}
+	}
+	return start, last.AddDate(0, 0, 1), nil
+}
@@ -0,0 +1,160 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+)
+
+// TestGetUserStats tests the buckets, busiest day, and domains of a range
+// seeded with known created_at values, in a database of its own so no other
+// test's users land in it
+func TestGetUserStats(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+
+	// March 2020, long before the seed rows
+	day := func(d, hour int) time.Time { return time.Date(2020, time.March, d, hour, 0, 0, 0, time.UTC) }
+	user := func(email string, createdAt time.Time) *fixtures.UserBuilder {
+		return fixtures.NewUser().WithEmail(email).WithCreatedAt(createdAt)
+	}
+	fixtures.SeedUsers(t, db,
+		user("a1@alpha.test", day(1, 0)), // the first instant of the range
+		user("a2@alpha.test", day(3, 9)),
+		user("b1@beta.test", day(3, 23)),
+		user("a3@alpha.test", day(4, 12)),
+		user("b2@beta.test", day(4, 13)),
+		user("g1@gamma.test", day(5, 0)),  // the first instant after the range
+		user("g2@gamma.test", day(0, 23)), // the last day of February
+	)
+
+	t.Run("Buckets Every Day", func(t *testing.T) {
+		stats, err := repo.GetUserStats(ctx, day(1, 0), day(5, 0))
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+
+		wantDays := []DayCount{{day(1, 0), 1}, {day(2, 0), 0}, {day(3, 0), 2}, {day(4, 0), 2}}
+		if !slices.EqualFunc(stats.Days, wantDays, func(a, b DayCount) bool {
+			return a.Day.Equal(b.Day) && a.Signups == b.Signups
+		}) {
+			t.Errorf("Expected days %v, got: %v", wantDays, stats.Days)
+		}
+		if stats.Total != 5 {
+			t.Errorf("Expected 5 users, got: %d", stats.Total)
+		}
+		// Days 3 and 4 tie, so the earlier wins
+		if stats.Busiest == nil || !stats.Busiest.Day.Equal(day(3, 0)) || stats.Busiest.Signups != 2 {
+			t.Errorf("Expected March 3 busiest with 2 signups, got: %v", stats.Busiest)
+		}
+		wantDomains := []DomainCount{{"alpha.test", 3}, {"beta.test", 2}}
+		if !slices.Equal(stats.Domains, wantDomains) {
+			t.Errorf("Expected domains %v, got: %v", wantDomains, stats.Domains)
+		}
+	})
+
+	t.Run("Partial Days", func(t *testing.T) {
+		// From 10:00 on the 3rd to 12:30 on the 4th: both days get a bucket,
+		// holding only the users inside the range
+		stats, err := repo.GetUserStats(ctx, day(3, 10), day(4, 12).Add(30*time.Minute))
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if len(stats.Days) != 2 || stats.Days[0].Signups != 1 || stats.Days[1].Signups != 1 {
+			t.Errorf("Expected one signup on each of 2 days, got: %v", stats.Days)
+		}
+	})
+
+	t.Run("Other Time Zones", func(t *testing.T) {
+		// Midnight March 1st in UTC+2 is 22:00 on February 29th UTC
+		zone := time.FixedZone("UTC+2", 2*60*60)
+		stats, err := repo.GetUserStats(ctx, time.Date(2020, time.March, 1, 0, 0, 0, 0, zone), day(2, 0))
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if stats.Total != 2 || len(stats.Days) != 2 || !stats.Days[0].Day.Equal(day(0, 0)) {
+			t.Errorf("Expected 2 users over February 29th and March 1st, got: %+v", stats)
+		}
+	})
+
+	t.Run("No Signups", func(t *testing.T) {
+		stats, err := repo.GetUserStats(ctx, day(10, 0), day(13, 0))
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if stats.Total != 0 || len(stats.Days) != 3 || stats.Busiest != nil || len(stats.Domains) != 0 {
+			t.Errorf("Expected 3 zero buckets, got: %+v", stats)
+		}
+		for _, d := range stats.Days {
+			if d.Signups != 0 {
+				t.Errorf("Expected no signups on %s, got: %d", d.Day, d.Signups)
+			}
+		}
+	})
+
+	t.Run("Empty Range", func(t *testing.T) {
+		for _, to := range []time.Time{day(1, 0), day(0, 0)} {
+			stats, err := repo.GetUserStats(ctx, day(1, 0), to)
+			if err != nil {
+				t.Fatalf("Expected zeroed stats, got: %v", err)
+			}
+			if stats.Total != 0 || stats.Days == nil || len(stats.Days) != 0 || stats.Domains == nil || stats.Busiest != nil {
+				t.Errorf("Expected zeroed stats with empty lists, got: %+v", stats)
+			}
+		}
+	})
+
+	t.Run("Range Too Long", func(t *testing.T) {
+		_, err := repo.GetUserStats(ctx, day(1, 0), day(1, 0).AddDate(20, 0, 0))
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Errorf("Expected a *ValidationError, got: %v", err)
+		}
+	})
+}
+
+// TestParseStatsRange tests the defaults and the inclusive last day
+func TestParseStatsRange(t *testing.T) {
+	t.Parallel()
+	now := time.Date(2026, time.October, 15, 18, 30, 0, 0, time.FixedZone("UTC-7", -7*60*60))
+	date := func(month time.Month, d int) time.Time { return time.Date(2026, month, d, 0, 0, 0, 0, time.UTC) }
+
+	tests := []struct {
+		from, to   string
+		start, end time.Time
+		wantErr    string
+	}{
+		{start: date(time.September, 17), end: date(time.October, 17)}, // now is the 16th in UTC
+		{to: "2026-01-31", start: date(time.January, 2), end: date(time.February, 1)},
+		{from: "2026-01-01", to: "2026-01-01", start: date(time.January, 1), end: date(time.January, 2)},
+		{from: "2026-02-01", to: "2026-01-01", start: date(time.February, 1), end: date(time.January, 2)},
+		{from: "01/01/2026", wantErr: "from: must be a day"},
+		{from: "yesterday", to: "2026-13-01", wantErr: "to: must be a day as YYYY-MM-DD; from: must be a day"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.from+".."+tt.to, func(t *testing.T) {
+			start, end, err := ParseStatsRange(tt.from, tt.to, now)
+			if tt.wantErr != "" {
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected a *ValidationError containing %q, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to parse range: %v", err)
+			}
+			if !start.Equal(tt.start) || !end.Equal(tt.end) {
+				t.Errorf("Expected %s to %s, got: %s to %s", tt.start, tt.end, start, end)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"time"
+
+	"testcontainers-demo/models"
+
+	"github.com/google/uuid"
+)
+
+// UserStore is the user CRUD and query API independent of the database
+// behind it. *UserRepository implements it on Postgres and mongodb.UserStore
+// on MongoDB; storetest.Run checks that every implementation behaves the
+// same. Postgres-only features, such as transactions, passwords, batches,
+// and streaming, stay on UserRepository.
+//
+// IDs are ints in every implementation, assigned in increasing order on
+// create, so the cursors of ListPaginated, ListByRole, and GetRecentUsers
+// work the same everywhere; build them with IDCursor and RecentCursor. An
+// implementation reports failures with the errors of this package:
+// ErrUserNotFound, ErrDuplicateEmail, and *ValidationError, wrapped in a
+// *RepoError.
+type UserStore interface {
+	GetByID(ctx context.Context, id int) (*models.User, error)
+	GetByUUID(ctx context.Context, id uuid.UUID) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+
+	Create(ctx context.Context, email, name string) (*models.User, error)
+	CreateWithRole(ctx context.Context, email, name string, role models.Role) (*models.User, error)
+	Update(ctx context.Context, id int, email, name string) error
+	UpdateWithRole(ctx context.Context, id int, email, name string, role models.Role) error
+	Delete(ctx context.Context, id int) error
+
+	List(ctx context.Context) ([]models.User, error)
+	ListPaginated(ctx context.Context, cursor string, limit int) (UserPage, error)
+	FindByNamePattern(ctx context.Context, pattern string) ([]models.User, error)
+	CountUsers(ctx context.Context) (int, error)
+	ListByRole(ctx context.Context, role models.Role, cursor string, limit int) (UserPage, error)
+	CountByRole(ctx context.Context) (map[models.Role]int, error)
+	GetRecentUsers(ctx context.Context, days int, cursor string, limit int, opts ...QueryOption) (UserPage, error)
+}
+
+// PostgresUserStore is every method of *UserRepository: UserStore plus the
+// Postgres-only features. WithTx is left out, as it returns the struct, and
+// so is Explain, which is for debugging only.
+// Code that takes a PostgresUserStore rather than the struct, as api.Server
+// does, can be unit tested with a mocks.UserStore and no database.
+type PostgresUserStore interface {
+	UserStore
+
+	CreateBatch(ctx context.Context, users []CreateUserInput) ([]models.User, error)
+	CreateAndNotify(ctx context.Context, email, name string) (*models.User, error)
+	CreateIdempotent(ctx context.Context, idempotencyKey, email, name string) (*models.User, error)
+	PurgeIdempotencyKeys(ctx context.Context) (int, error)
+	UpdateWithVersion(ctx context.Context, user *models.User) error
+	SetAvatarKey(ctx context.Context, id int, avatarKey string) error
+	GetByIDForUpdate(ctx context.Context, tx *sql.Tx, id int) (*models.User, error)
+	GetByIDForUpdateNoWait(ctx context.Context, tx *sql.Tx, id int) (*models.User, error)
+
+	ListOrdered(ctx context.Context, orderBy OrderField, direction Direction) ([]models.User, error)
+	ListFiltered(ctx context.Context, f Filter, page PageOpts, opts ...QueryOption) ([]models.User, error)
+	ListEach(ctx context.Context, fn func(models.User) error) error
+	ListChan(ctx context.Context, buf int) (<-chan models.User, <-chan error)
+	IsEmailAvailable(ctx context.Context, email string) (bool, error)
+	GetUsersWithOrderCounts(ctx context.Context) ([]UserWithStats, error)
+	GetTopSpenders(ctx context.Context, limit int) ([]UserWithStats, error)
+	GetUserStats(ctx context.Context, from, to time.Time) (*UserStats, error)
+
+	CreateWithPassword(ctx context.Context, email, name, password string) (*models.User, error)
+	Authenticate(ctx context.Context, email, password string) (*models.User, error)
+	ChangePassword(ctx context.Context, id int, oldPassword, newPassword string) error
+
+	ExportUsers(ctx context.Context, w io.Writer, format Format) error
+	ImportUsers(ctx context.Context, rd io.Reader, format Format, opts ImportOptions) (ImportSummary, error)
+
+	Healthcheck(ctx context.Context) error
+	Close() error
+}
+
+// CachedUserStore is every method of *CachedUserRepository, the cached
+// reads and the writes that keep the cache in step; mocks.CachedUserStore
+// implements it for unit tests
+type CachedUserStore interface {
+	GetByIDCached(ctx context.Context, id int) (*models.User, error)
+	GetByIDsCached(ctx context.Context, ids []int) ([]models.User, error)
+	GetByEmailCached(ctx context.Context, email string) (*models.User, error)
+	IsEmailAvailableCached(ctx context.Context, email string) (bool, error)
+
+	CreateCached(ctx context.Context, email, name string) (*models.User, error)
+	UpdateCached(ctx context.Context, id int, email, name string) error
+	UpdateRoleCached(ctx context.Context, id int, role models.Role) error
+	UpdateWithVersionCached(ctx context.Context, user *models.User) error
+	ChangePasswordCached(ctx context.Context, id int, oldPassword, newPassword string) error
+	DeleteCached(ctx context.Context, id int) error
+	DeleteUserCascade(ctx context.Context, id int) (*DeleteSummary, error)
+	EraseUser(ctx context.Context, id int) error
+	ArchiveInactiveUsers(ctx context.Context, olderThan time.Time, batchSize int) (int, error)
+	ChangeEmailDomain(ctx context.Context, fromDomain, toDomain string, batchSize int, opts DomainChangeOptions) (*DomainChangeReport, error)
+
+	InvalidateCache(ctx context.Context, ids ...int) error
+	InvalidateMany(ctx context.Context, ids ...int) error
+	InvalidateAll(ctx context.Context) (int, error)
+	WarmCache(ctx context.Context, ids []int) error
+	WarmCacheRecent(ctx context.Context, days, limit int) error
+	Stats() CacheStats
+
+	Healthcheck(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ PostgresUserStore = (*UserRepository)(nil)
+	_ CachedUserStore   = (*CachedUserRepository)(nil)
+)
@@ -0,0 +1,52 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+
+	"testcontainers-demo/migrations"
+	"testcontainers-demo/mongodb"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/repository/storetest"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestUserStore runs the conformance suite against every UserStore
+func TestUserStore(t *testing.T) {
+	t.Parallel()
+
+	stores := []struct {
+		name     string
+		newStore func(t *testing.T) repository.UserStore
+	}{
+		{"Postgres", func(t *testing.T) repository.UserStore {
+			if repository.Container() == nil {
+				t.Skip("Postgres is unavailable without Docker")
+			}
+			ctx := context.Background()
+			// An empty database rather than a clone, so there are no seed users
+			db := repository.Container().CreateEmptyDatabase(ctx, t)
+			if err := migrations.RunMigrations(ctx, db); err != nil {
+				t.Fatalf("Failed to run migrations: %v", err)
+			}
+			repo := repository.NewUserRepository(db)
+			t.Cleanup(func() { repo.Close() })
+			return repo
+		}},
+		{"MongoDB", func(t *testing.T) repository.UserStore {
+			ctx := context.Background()
+			store, err := mongodb.New(ctx, testhelpers.StartMongo(ctx, t))
+			if err != nil {
+				t.Fatalf("Failed to create MongoDB store: %v", err)
+			}
+			return store
+		}},
+	}
+
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			t.Parallel()
+			storetest.Run(t, s.newStore)
+		})
+	}
+}
@@ -0,0 +1,285 @@
+package storetest_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/repository/storetest"
+
+	_ "modernc.org/sqlite"
+)
+
+// newSQLite returns an in-memory SQLite database with the repository schema,
+// closed when the test finishes. It needs no Docker, so these tests always run.
+func newSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open SQLite: %v", err)
+	}
+	// Every connection to :memory: is a database of its own
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if err := repository.CreateSQLiteSchema(context.Background(), db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// newSQLiteRepository returns a SQLite-dialect repository on a new database
+func newSQLiteRepository(t *testing.T) *repository.UserRepository {
+	t.Helper()
+	repo := repository.NewUserRepositoryForDialect(newSQLite(t), repository.DialectSQLite)
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+// TestSQLite runs the conformance suite against the SQLite dialect
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) repository.UserStore {
+		return newSQLiteRepository(t)
+	})
+}
+
+// TestSQLiteOutbox tests that the schema's triggers record an event for every
+// write, as the Postgres statements do
+func TestSQLiteOutbox(t *testing.T) {
+	ctx := context.Background()
+	db := newSQLite(t)
+	repo := repository.NewUserRepositoryForDialect(db, repository.DialectSQLite)
+
+	user, err := repo.Create(ctx, "outbox@example.com", "Outbox User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := repo.Update(ctx, user.ID, "outbox@example.com", "Renamed"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	if err := repo.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, "SELECT event_type, json_extract(payload, '$.name') FROM user_events WHERE user_id = $1 ORDER BY id", user.ID)
+	if err != nil {
+		t.Fatalf("Failed to read events: %v", err)
+	}
+	defer rows.Close()
+
+	var got []string
+	for rows.Next() {
+		var eventType, name string
+		if err := rows.Scan(&eventType, &name); err != nil {
+			t.Fatalf("Failed to scan event: %v", err)
+		}
+		got = append(got, eventType+" "+name)
+	}
+	want := []string{
+		string(models.EventUserCreated) + " Outbox User",
+		string(models.EventUserUpdated) + " Renamed",
+		string(models.EventUserDeleted) + " Renamed",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected events %v, got: %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Event %d: expected %q, got: %q", i, want[i], got[i])
+		}
+	}
+}
+
+// TestSQLiteDifferences pins where SQLite knowingly behaves differently from
+// Postgres, so a change in either direction is noticed
+func TestSQLiteDifferences(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Email Index Folds Only ASCII", func(t *testing.T) {
+		db := newSQLite(t)
+		repo := repository.NewUserRepositoryForDialect(db, repository.DialectSQLite)
+
+		// The repository lowercases emails itself, so it still rejects these...
+		if _, err := repo.Create(ctx, "émile@example.com", "Émile"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := repo.Create(ctx, "ÉMILE@example.com", "Émile"); !errors.Is(err, repository.ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+
+		// ...but the index alone doesn't: Postgres' lower(email) index would
+		// reject this row, SQLite's lower() leaves É alone
+		_, err := db.ExecContext(ctx, "INSERT INTO users (uuid, email, name) VALUES ('raw', 'ÉMILE@example.com', 'Raw')")
+		if err != nil {
+			t.Errorf("Expected SQLite to accept a non-ASCII case variant, got: %v", err)
+		}
+	})
+
+	t.Run("Name Pattern Folds Only ASCII", func(t *testing.T) {
+		repo := newSQLiteRepository(t)
+		if _, err := repo.Create(ctx, "zoe@example.com", "Zoë Ångström"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+
+		users, err := repo.FindByNamePattern(ctx, "ångström")
+		if err != nil {
+			t.Fatalf("Failed to find users: %v", err)
+		}
+		// Postgres' ILIKE finds her
+		if len(users) != 0 {
+			t.Errorf("Expected SQLite not to fold Å, got: %v", users)
+		}
+	})
+
+	t.Run("Millisecond Timestamps", func(t *testing.T) {
+		repo := newSQLiteRepository(t)
+		user, err := repo.Create(ctx, "clock@example.com", "Clock")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if !user.CreatedAt.Equal(user.CreatedAt.Truncate(time.Millisecond)) {
+			t.Errorf("Expected created_at in whole milliseconds, got: %s", user.CreatedAt)
+		}
+	})
+}
+
+// TestSQLitePasswords tests that password logins, outside UserStore, work on SQLite too
+func TestSQLitePasswords(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepository(t)
+
+	if _, err := repo.CreateWithPassword(ctx, "login@example.com", "Login User", "correct horse"); err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if _, err := repo.Authenticate(ctx, "LOGIN@example.com", "correct horse"); err != nil {
+		t.Errorf("Expected to authenticate, got: %v", err)
+	}
+	if _, err := repo.Authenticate(ctx, "login@example.com", "wrong horse"); !errors.Is(err, repository.ErrInvalidCredentials) {
+		t.Errorf("Expected ErrInvalidCredentials, got: %v", err)
+	}
+}
+
+// TestSQLiteVersion tests that the schema's trigger bumps the version on
+// every update, so UpdateWithVersion refuses a stale copy on SQLite too
+func TestSQLiteVersion(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepository(t)
+
+	created, err := repo.Create(ctx, "version@example.com", "Version User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	a, err := repo.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if a.Version != 1 {
+		t.Fatalf("Expected version 1, got: %d", a.Version)
+	}
+	b := *a
+
+	a.Name = "Writer A"
+	if err := repo.UpdateWithVersion(ctx, a); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	b.Name = "Writer B"
+	if err := repo.UpdateWithVersion(ctx, &b); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("Expected ErrVersionConflict, got: %v", err)
+	}
+
+	got, err := repo.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if got.Name != "Writer A" || got.Version != 2 || a.Version != 2 {
+		t.Errorf("Expected Writer A at version 2, got: %s at %d (caller's copy at %d)", got.Name, got.Version, a.Version)
+	}
+
+	got.ID = created.ID + 1
+	if err := repo.UpdateWithVersion(ctx, got); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound, got: %v", err)
+	}
+}
+
+// TestSQLiteGeneratedRoundTrip tests that generated users, edge cases
+// included, come back from SQLite unchanged
+func TestSQLiteGeneratedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepository(t)
+
+	for _, want := range fixtures.InsertGenerated(t, repo, 1, 500) {
+		got, err := repo.GetByEmail(ctx, want.Email)
+		if err != nil {
+			t.Fatalf("Failed to get %q: %v", want.Email, err)
+		}
+		if got.ID != want.ID || got.Email != want.Email || got.Name != want.Name || got.Role != want.Role {
+			t.Errorf("Expected %+v, got: %+v", want, *got)
+		}
+	}
+}
+
+// TestSQLiteTenants tests that two tenants on SQLite can share an email and
+// never see or change each other's users
+func TestSQLiteTenants(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepository(t)
+	acme, err := repo.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := repo.ForTenant("globex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ForTenant("Acme:Corp"); !errors.Is(err, repository.ErrInvalidTenant) {
+		t.Errorf("Expected ErrInvalidTenant, got: %v", err)
+	}
+
+	acmeUser, err := acme.Create(ctx, "shared@example.com", "Acme User")
+	if err != nil {
+		t.Fatalf("Failed to create user in acme: %v", err)
+	}
+	globexUser, err := globex.Create(ctx, "shared@example.com", "Globex User")
+	if err != nil {
+		t.Fatalf("Expected the email free in globex, got: %v", err)
+	}
+	if _, err := globex.Create(ctx, "SHARED@example.com", "Globex Again"); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Errorf("Expected ErrDuplicateEmail within a tenant, got: %v", err)
+	}
+
+	if _, err := globex.GetByID(ctx, acmeUser.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("GetByID: expected ErrUserNotFound, got: %v", err)
+	}
+	if got, err := globex.GetByEmail(ctx, "shared@example.com"); err != nil || got.ID != globexUser.ID {
+		t.Errorf("Expected globex's user, got: %+v, %v", got, err)
+	}
+	if err := globex.Update(ctx, acmeUser.ID, "taken@example.com", "Hijacked"); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Update: expected ErrUserNotFound, got: %v", err)
+	}
+	if err := globex.Delete(ctx, acmeUser.ID); !errors.Is(err, repository.ErrUserNotFound) {
+		t.Errorf("Delete: expected ErrUserNotFound, got: %v", err)
+	}
+	if users, err := globex.FindByNamePattern(ctx, "acme"); err != nil || len(users) != 0 {
+		t.Errorf("FindByNamePattern: expected no acme users in globex, got: %+v, %v", users, err)
+	}
+	for _, r := range []*repository.UserRepository{acme, globex} {
+		if n, err := r.CountUsers(ctx); err != nil || n != 1 {
+			t.Errorf("CountUsers in %s: expected 1, got: %d, %v", r.Tenant(), n, err)
+		}
+		if users, err := r.List(ctx); err != nil || len(users) != 1 {
+			t.Errorf("List in %s: expected 1 user, got: %d, %v", r.Tenant(), len(users), err)
+		}
+	}
+	if n, err := repo.CountUsers(ctx); err != nil || n != 0 {
+		t.Errorf("Expected no users in the default tenant, got: %d, %v", n, err)
+	}
+	if got, err := acme.GetByID(ctx, acmeUser.ID); err != nil || got.Name != "Acme User" {
+		t.Errorf("Expected acme's user untouched, got: %+v, %v", got, err)
+	}
+}
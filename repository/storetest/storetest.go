@@ -0,0 +1,597 @@
+// Package storetest is the conformance suite for repository.UserStore: every
+// implementation runs the same tests, so a caller can switch databases
+// without a change in behaviour.
+package storetest
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"slices"
+	"testing"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/repository"
+
+	"github.com/google/uuid"
+)
+
+// Run runs the suite against the stores newStore returns. Each call must
+// return a store with no users that no other test writes to; subtests run
+// in parallel, each on its own store.
+func Run(t *testing.T, newStore func(t *testing.T) repository.UserStore) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, ctx context.Context, store repository.UserStore)
+	}{
+		{"Get", testGet},
+		{"Create", testCreate},
+		{"Update", testUpdate},
+		{"Delete", testDelete},
+		{"List", testList},
+		{"ListPaginated", testListPaginated},
+		{"Pagination", testPagination},
+		{"FindByNamePattern", testFindByNamePattern},
+		{"Count", testCount},
+		{"Roles", testRoles},
+		{"GetRecentUsers", testGetRecentUsers},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			tc.fn(t, context.Background(), newStore(t))
+		})
+	}
+}
+
+// mustCreate creates a member, failing the test on error
+func mustCreate(t *testing.T, ctx context.Context, store repository.UserStore, email, name string) *models.User {
+	t.Helper()
+	user, err := store.Create(ctx, email, name)
+	if err != nil {
+		t.Fatalf("Failed to create %s: %v", email, err)
+	}
+	return user
+}
+
+// expectError fails the test unless err is target
+func expectError(t *testing.T, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Errorf("Expected %v, got: %v", target, err)
+	}
+}
+
+// expectValidationError fails the test unless err is a *ValidationError
+func expectValidationError(t *testing.T, err error) {
+	t.Helper()
+	var verr *repository.ValidationError
+	if !errors.As(err, &verr) {
+		t.Errorf("Expected a validation error, got: %v", err)
+	}
+}
+
+// ids returns the IDs of users in order
+func ids(users []models.User) []int {
+	out := make([]int, len(users))
+	for i, u := range users {
+		out[i] = u.ID
+	}
+	return out
+}
+
+// expectIDs fails the test unless users have exactly the IDs want, in order
+func expectIDs(t *testing.T, users []models.User, want ...int) {
+	t.Helper()
+	got := ids(users)
+	if len(got) != len(want) {
+		t.Errorf("Expected IDs %v, got: %v", want, got)
+		return
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Expected IDs %v, got: %v", want, got)
+			return
+		}
+	}
+}
+
+func testGet(t *testing.T, ctx context.Context, store repository.UserStore) {
+	created := mustCreate(t, ctx, store, "Get.Me@Example.com", "Get Me")
+
+	t.Run("By ID", func(t *testing.T) {
+		user, err := store.GetByID(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if user.Email != created.Email || user.Name != created.Name || user.UUID != created.UUID {
+			t.Errorf("Expected %+v, got: %+v", created, user)
+		}
+	})
+
+	t.Run("By UUID", func(t *testing.T) {
+		user, err := store.GetByUUID(ctx, created.UUID)
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if user.ID != created.ID {
+			t.Errorf("Expected ID %d, got: %d", created.ID, user.ID)
+		}
+	})
+
+	t.Run("By Email Ignoring Case", func(t *testing.T) {
+		user, err := store.GetByEmail(ctx, "GET.ME@example.COM")
+		if err != nil {
+			t.Fatalf("Expected no error, got: %v", err)
+		}
+		if user.ID != created.ID {
+			t.Errorf("Expected ID %d, got: %d", created.ID, user.ID)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		_, err := store.GetByID(ctx, created.ID+1000)
+		expectError(t, err, repository.ErrUserNotFound)
+
+		_, err = store.GetByUUID(ctx, uuid.New())
+		expectError(t, err, repository.ErrUserNotFound)
+
+		_, err = store.GetByEmail(ctx, "nobody@example.com")
+		expectError(t, err, repository.ErrUserNotFound)
+	})
+}
+
+func testCreate(t *testing.T, ctx context.Context, store repository.UserStore) {
+	t.Run("Normalizes Input", func(t *testing.T) {
+		user := mustCreate(t, ctx, store, "  New.User@Example.COM ", "  New User ")
+		if user.Email != "new.user@example.com" {
+			t.Errorf("Expected normalized email, got: %q", user.Email)
+		}
+		if user.Name != "New User" {
+			t.Errorf("Expected trimmed name, got: %q", user.Name)
+		}
+		if user.Role != models.RoleMember {
+			t.Errorf("Expected role %q, got: %q", models.RoleMember, user.Role)
+		}
+		if user.ID == 0 || user.UUID == uuid.Nil || user.CreatedAt.IsZero() {
+			t.Errorf("Expected ID, UUID, and created_at to be set, got: %+v", user)
+		}
+	})
+
+	t.Run("IDs Increase", func(t *testing.T) {
+		first := mustCreate(t, ctx, store, "first@example.com", "First")
+		second := mustCreate(t, ctx, store, "second@example.com", "Second")
+		if second.ID <= first.ID {
+			t.Errorf("Expected ID > %d, got: %d", first.ID, second.ID)
+		}
+	})
+
+	t.Run("With Role", func(t *testing.T) {
+		user, err := store.CreateWithRole(ctx, "admin@example.com", "Admin", models.RoleAdmin)
+		if err != nil {
+			t.Fatalf("Failed to create admin: %v", err)
+		}
+		if user.Role != models.RoleAdmin {
+			t.Errorf("Expected role %q, got: %q", models.RoleAdmin, user.Role)
+		}
+	})
+
+	t.Run("Duplicate Email Ignoring Case", func(t *testing.T) {
+		mustCreate(t, ctx, store, "dup@example.com", "Original")
+		_, err := store.Create(ctx, "DUP@example.com", "Copy")
+		expectError(t, err, repository.ErrDuplicateEmail)
+	})
+
+	t.Run("Invalid Input", func(t *testing.T) {
+		_, err := store.Create(ctx, "not-an-email", "Name")
+		expectValidationError(t, err)
+
+		_, err = store.Create(ctx, "valid@example.com", "   ")
+		expectValidationError(t, err)
+
+		_, err = store.CreateWithRole(ctx, "valid@example.com", "Name", models.Role("owner"))
+		expectValidationError(t, err)
+	})
+}
+
+func testUpdate(t *testing.T, ctx context.Context, store repository.UserStore) {
+	user, err := store.CreateWithRole(ctx, "before@example.com", "Before", models.RoleAdmin)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	t.Run("Keeps Role", func(t *testing.T) {
+		if err := store.Update(ctx, user.ID, "After@Example.com", "After"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		got, err := store.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.Email != "after@example.com" || got.Name != "After" || got.Role != models.RoleAdmin {
+			t.Errorf("Expected after@example.com, After, admin; got: %s, %s, %s", got.Email, got.Name, got.Role)
+		}
+	})
+
+	t.Run("With Role", func(t *testing.T) {
+		if err := store.UpdateWithRole(ctx, user.ID, "after@example.com", "After", models.RoleGuest); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		got, err := store.GetByID(ctx, user.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if got.Role != models.RoleGuest {
+			t.Errorf("Expected role %q, got: %q", models.RoleGuest, got.Role)
+		}
+	})
+
+	t.Run("Not Found", func(t *testing.T) {
+		err := store.Update(ctx, user.ID+1000, "ghost@example.com", "Ghost")
+		expectError(t, err, repository.ErrUserNotFound)
+	})
+
+	t.Run("Duplicate Email", func(t *testing.T) {
+		other := mustCreate(t, ctx, store, "other@example.com", "Other")
+		err := store.Update(ctx, other.ID, "AFTER@example.com", "Other")
+		expectError(t, err, repository.ErrDuplicateEmail)
+	})
+
+	t.Run("Invalid Input", func(t *testing.T) {
+		expectValidationError(t, store.Update(ctx, user.ID, "bad", "After"))
+	})
+}
+
+func testDelete(t *testing.T, ctx context.Context, store repository.UserStore) {
+	user := mustCreate(t, ctx, store, "delete@example.com", "Delete Me")
+
+	if err := store.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	_, err := store.GetByID(ctx, user.ID)
+	expectError(t, err, repository.ErrUserNotFound)
+
+	// Deleting twice reports the user as gone
+	expectError(t, store.Delete(ctx, user.ID), repository.ErrUserNotFound)
+
+	// The email is free again
+	mustCreate(t, ctx, store, "delete@example.com", "Delete Me Again")
+}
+
+func testList(t *testing.T, ctx context.Context, store repository.UserStore) {
+	users, err := store.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	expectIDs(t, users)
+
+	a := mustCreate(t, ctx, store, "a@example.com", "A")
+	b := mustCreate(t, ctx, store, "b@example.com", "B")
+	c := mustCreate(t, ctx, store, "c@example.com", "C")
+
+	users, err = store.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	expectIDs(t, users, a.ID, b.ID, c.ID)
+}
+
+func testListPaginated(t *testing.T, ctx context.Context, store repository.UserStore) {
+	a := mustCreate(t, ctx, store, "a@example.com", "A")
+	b := mustCreate(t, ctx, store, "b@example.com", "B")
+	c := mustCreate(t, ctx, store, "c@example.com", "C")
+
+	page, err := store.ListPaginated(ctx, "", 2)
+	if err != nil {
+		t.Fatalf("Failed to list first page: %v", err)
+	}
+	expectIDs(t, page.Items, a.ID, b.ID)
+	if !page.HasMore || page.TotalCount != 3 {
+		t.Errorf("Expected more of 3 users, got: %+v", page)
+	}
+
+	page, err = store.ListPaginated(ctx, page.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("Failed to list second page: %v", err)
+	}
+	expectIDs(t, page.Items, c.ID)
+	if page.HasMore || page.NextCursor != "" {
+		t.Errorf("Expected the last page, got: %+v", page)
+	}
+
+	// A cursor after the last user, as from a page that ended on it
+	page, err = store.ListPaginated(ctx, repository.IDCursor(*c).Encode(), 2)
+	if err != nil {
+		t.Fatalf("Failed to list past the end: %v", err)
+	}
+	if page.Items == nil || len(page.Items) != 0 || page.HasMore {
+		t.Errorf("Expected an empty page with an empty non-nil slice, got: %+v", page)
+	}
+}
+
+// pagedList is a list method of a UserStore
+type pagedList func(ctx context.Context, store repository.UserStore, cursor string, limit int) (repository.UserPage, error)
+
+// pagedLists are the UserStore methods returning pages
+var pagedLists = map[string]pagedList{
+	"ListPaginated": func(ctx context.Context, store repository.UserStore, cursor string, limit int) (repository.UserPage, error) {
+		return store.ListPaginated(ctx, cursor, limit)
+	},
+	"ListByRole": func(ctx context.Context, store repository.UserStore, cursor string, limit int) (repository.UserPage, error) {
+		return store.ListByRole(ctx, models.RoleMember, cursor, limit)
+	},
+	"GetRecentUsers": func(ctx context.Context, store repository.UserStore, cursor string, limit int) (repository.UserPage, error) {
+		return store.GetRecentUsers(ctx, 1, cursor, limit)
+	},
+	"GetRecentUsers Ascending": func(ctx context.Context, store repository.UserStore, cursor string, limit int) (repository.UserPage, error) {
+		return store.GetRecentUsers(ctx, 1, cursor, limit, repository.WithAscending())
+	},
+	"GetRecentUsers WithLimit": func(ctx context.Context, store repository.UserStore, cursor string, limit int) (repository.UserPage, error) {
+		return store.GetRecentUsers(ctx, 1, cursor, 100, repository.WithDomain("EXAMPLE.com"), repository.WithLimit(limit))
+	},
+}
+
+// testPagination walks every paged list a page at a time and checks the
+// pages add up to the whole list, in its order
+func testPagination(t *testing.T, ctx context.Context, store repository.UserStore) {
+	for i, email := range []string{"p1@example.com", "p2@example.com", "p3@example.com", "p4@example.com", "p5@example.com"} {
+		role := models.RoleMember
+		if i == 2 {
+			role = models.RoleAdmin
+		}
+		if _, err := store.CreateWithRole(ctx, email, "Paged", role); err != nil {
+			t.Fatalf("Failed to create %s: %v", email, err)
+		}
+	}
+
+	for name, list := range pagedLists {
+		t.Run(name, func(t *testing.T) {
+			all, err := list(ctx, store, "", 0)
+			if err != nil {
+				t.Fatalf("Failed to list everything: %v", err)
+			}
+			if len(all.Items) < 4 || all.HasMore || all.NextCursor != "" || all.TotalCount != int64(len(all.Items)) {
+				t.Fatalf("Expected one page of every user, got: %+v", all)
+			}
+
+			for _, limit := range []int{1, 2, 3, len(all.Items)} {
+				var walked []models.User
+				cursor := ""
+				for {
+					page, err := list(ctx, store, cursor, limit)
+					if err != nil {
+						t.Fatalf("Limit %d: failed to list page %d: %v", limit, len(walked)/limit+1, err)
+					}
+					if len(page.Items) > limit || page.TotalCount != all.TotalCount {
+						t.Fatalf("Limit %d: expected up to %d of %d users, got: %+v", limit, limit, all.TotalCount, page)
+					}
+					walked = append(walked, page.Items...)
+					if !page.HasMore {
+						if page.NextCursor != "" {
+							t.Errorf("Limit %d: expected no cursor on the last page, got: %q", limit, page.NextCursor)
+						}
+						break
+					}
+					if page.NextCursor == "" || len(walked) > len(all.Items) {
+						t.Fatalf("Limit %d: expected the pages to end, walked: %v", limit, ids(walked))
+					}
+					cursor = page.NextCursor
+				}
+				if !slices.Equal(ids(walked), ids(all.Items)) {
+					t.Errorf("Limit %d: expected pages to walk %v, got: %v", limit, ids(all.Items), ids(walked))
+				}
+			}
+
+			for cursorName, cursor := range map[string]string{
+				"Garbage":     "%%%",
+				"Tampered":    base64.RawURLEncoding.EncodeToString([]byte("007")),
+				"Wrong Shape": pagination.Cursor{ID: 1, Key: "nope"}.Encode(),
+			} {
+				if _, err := list(ctx, store, cursor, 2); !errors.Is(err, pagination.ErrInvalidCursor) {
+					t.Errorf("%s cursor: expected ErrInvalidCursor, got: %v", cursorName, err)
+				}
+			}
+			_, err = list(ctx, store, "", -1)
+			expectValidationError(t, err)
+		})
+	}
+}
+
+func testFindByNamePattern(t *testing.T, ctx context.Context, store repository.UserStore) {
+	quentin := mustCreate(t, ctx, store, "quentin@example.com", "Quentin Marlowe")
+	quilla := mustCreate(t, ctx, store, "quilla@example.com", "Quilla Marlowe")
+	mustCreate(t, ctx, store, "rosalind@example.com", "Rosalind Oakhurst")
+	dotted := mustCreate(t, ctx, store, "dotted@example.com", "A.B (C)")
+	percent := mustCreate(t, ctx, store, "percent@example.com", `100% Pure_Cotton\Wool`)
+
+	tests := []struct {
+		pattern string
+		want    []int
+	}{
+		{"Marlowe", []int{quentin.ID, quilla.ID}},
+		{"marlowe", []int{quentin.ID, quilla.ID}},
+		{"qu%marlowe", []int{quentin.ID, quilla.ID}},
+		{"Qu_lla", []int{quilla.ID}},
+		{"A.B (", []int{dotted.ID}},
+		{repository.EscapeLike("0% p"), []int{percent.ID}},
+		{repository.EscapeLike(`e_cotton\w`), []int{percent.ID}},
+		{repository.EscapeLike("%"), []int{percent.ID}},
+		{"Nobody", nil},
+	}
+
+	for _, tc := range tests {
+		users, err := store.FindByNamePattern(ctx, tc.pattern)
+		if err != nil {
+			t.Fatalf("Failed to find %q: %v", tc.pattern, err)
+		}
+		// Results come back in no particular order
+		found := make(map[int]bool, len(users))
+		for _, u := range users {
+			found[u.ID] = true
+		}
+		if len(found) != len(tc.want) {
+			t.Errorf("Pattern %q: expected IDs %v, got: %v", tc.pattern, tc.want, ids(users))
+			continue
+		}
+		for _, id := range tc.want {
+			if !found[id] {
+				t.Errorf("Pattern %q: expected IDs %v, got: %v", tc.pattern, tc.want, ids(users))
+				break
+			}
+		}
+	}
+}
+
+func testCount(t *testing.T, ctx context.Context, store repository.UserStore) {
+	count, err := store.CountUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != 0 {
+		t.Errorf("Expected 0 users in a new store, got: %d", count)
+	}
+
+	mustCreate(t, ctx, store, "one@example.com", "One")
+	user := mustCreate(t, ctx, store, "two@example.com", "Two")
+	if err := store.Delete(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+
+	if count, err = store.CountUsers(ctx); err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected 1 user, got: %d", count)
+	}
+}
+
+func testRoles(t *testing.T, ctx context.Context, store repository.UserStore) {
+	admin, err := store.CreateWithRole(ctx, "admin@example.com", "Admin", models.RoleAdmin)
+	if err != nil {
+		t.Fatalf("Failed to create admin: %v", err)
+	}
+	first := mustCreate(t, ctx, store, "first@example.com", "First")
+	second := mustCreate(t, ctx, store, "second@example.com", "Second")
+
+	t.Run("List By Role", func(t *testing.T) {
+		members, err := store.ListByRole(ctx, models.RoleMember, "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list members: %v", err)
+		}
+		expectIDs(t, members.Items, first.ID, second.ID)
+
+		admins, err := store.ListByRole(ctx, models.RoleAdmin, "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list admins: %v", err)
+		}
+		expectIDs(t, admins.Items, admin.ID)
+
+		guests, err := store.ListByRole(ctx, models.RoleGuest, "", 0)
+		if err != nil {
+			t.Fatalf("Failed to list guests: %v", err)
+		}
+		expectIDs(t, guests.Items)
+	})
+
+	t.Run("Invalid Role", func(t *testing.T) {
+		_, err := store.ListByRole(ctx, models.Role("owner"), "", 0)
+		expectValidationError(t, err)
+	})
+
+	t.Run("Count By Role", func(t *testing.T) {
+		counts, err := store.CountByRole(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count by role: %v", err)
+		}
+		want := map[models.Role]int{models.RoleAdmin: 1, models.RoleMember: 2, models.RoleGuest: 0}
+		for role, n := range want {
+			if got, ok := counts[role]; !ok || got != n {
+				t.Errorf("Expected %d %s users, got: %d (present: %t)", n, role, got, ok)
+			}
+		}
+	})
+}
+
+func testGetRecentUsers(t *testing.T, ctx context.Context, store repository.UserStore) {
+	first := mustCreate(t, ctx, store, "first@example.com", "First")
+	second := mustCreate(t, ctx, store, "second@example.com", "Second")
+
+	page, err := store.GetRecentUsers(ctx, 1, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to get recent users: %v", err)
+	}
+	users := page.Items
+	if len(users) != 2 {
+		t.Fatalf("Expected 2 recent users, got: %v", ids(users))
+	}
+	// Newest first; users created in the same instant may come in either order
+	if !users[0].CreatedAt.After(users[1].CreatedAt) && !users[0].CreatedAt.Equal(users[1].CreatedAt) {
+		t.Errorf("Expected newest first, got: %v then %v", users[0].CreatedAt, users[1].CreatedAt)
+	}
+	for _, u := range users {
+		if u.ID != first.ID && u.ID != second.ID {
+			t.Errorf("Unexpected user %d in results", u.ID)
+		}
+	}
+
+	page, err = store.GetRecentUsers(ctx, 0, "", 0)
+	if err != nil {
+		t.Fatalf("Failed to get recent users: %v", err)
+	}
+	if page.Items == nil {
+		t.Error("Expected non-nil slice")
+	}
+
+	t.Run("Options", func(t *testing.T) {
+		other := mustCreate(t, ctx, store, "third@other.example", "Third")
+		// recent returns the IDs of the last day's users with opts
+		recent := func(t *testing.T, opts ...repository.QueryOption) []int {
+			t.Helper()
+			page, err := store.GetRecentUsers(ctx, 1, "", 0, opts...)
+			if err != nil {
+				t.Fatalf("Failed to get recent users: %v", err)
+			}
+			return ids(page.Items)
+		}
+
+		newest := recent(t)
+		if len(newest) != 3 {
+			t.Fatalf("Expected 3 recent users, got: %v", newest)
+		}
+		oldest := slices.Clone(newest)
+		slices.Reverse(oldest)
+		// Ties on created_at are broken by ID both ways, so one order is
+		// exactly the other reversed
+		for _, tc := range []struct {
+			name string
+			opts []repository.QueryOption
+			want []int
+		}{
+			{"Ascending", []repository.QueryOption{repository.WithAscending()}, oldest},
+			{"Domain", []repository.QueryOption{repository.WithDomain("Example.COM")}, without(newest, other.ID)},
+			{"Domain Ascending", []repository.QueryOption{repository.WithDomain("other.example"), repository.WithAscending()}, []int{other.ID}},
+			{"Unknown Domain", []repository.QueryOption{repository.WithDomain("example")}, []int{}},
+			{"Limit", []repository.QueryOption{repository.WithLimit(2)}, newest[:2]},
+			{"Limit Ascending", []repository.QueryOption{repository.WithAscending(), repository.WithLimit(1)}, oldest[:1]},
+			{"Include Deleted", []repository.QueryOption{repository.IncludeDeleted()}, newest},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				if got := recent(t, tc.opts...); !slices.Equal(got, tc.want) {
+					t.Errorf("Expected users %v, got: %v", tc.want, got)
+				}
+			})
+		}
+
+		t.Run("Negative Limit", func(t *testing.T) {
+			_, err := store.GetRecentUsers(ctx, 1, "", 10, repository.WithLimit(-1))
+			expectValidationError(t, err)
+		})
+	})
+}
+
+// without returns ids less id
+func without(ids []int, id int) []int {
+	return slices.DeleteFunc(slices.Clone(ids), func(i int) bool { return i == id })
+}
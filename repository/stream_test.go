@@ -0,0 +1,175 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"runtime"
+	"testing"
+	"time"
+
+	"testcontainers-demo/models"
+)
+
+// streamUsers is how many users TestListEach and TestListChan seed on top of the seed data
+const streamUsers = 3000
+
+// seedStreamUsers inserts streamUsers users into db in one statement
+func seedStreamUsers(ctx context.Context, t *testing.T, db *sql.DB) {
+	t.Helper()
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO users (email, name)
+		SELECT 'stream' || n || '@example.com', 'Stream User ' || n
+		FROM generate_series(1, $1) AS n`, streamUsers)
+	if err != nil {
+		t.Fatalf("Failed to seed users: %v", err)
+	}
+}
+
+// assertNoBusyConns fails if a connection is still checked out of db's pool
+// or a session on its database is still running a query
+func assertNoBusyConns(ctx context.Context, t *testing.T, db *sql.DB) {
+	t.Helper()
+	if inUse := db.Stats().InUse; inUse != 0 {
+		t.Errorf("Expected no connections in use, got: %d", inUse)
+	}
+
+	var busy int
+	err := db.QueryRowContext(ctx, `
+		SELECT count(*) FROM pg_stat_activity
+		WHERE datname = current_database() AND state <> 'idle' AND pid <> pg_backend_pid()`).Scan(&busy)
+	if err != nil {
+		t.Fatalf("Failed to query pg_stat_activity: %v", err)
+	}
+	if busy != 0 {
+		t.Errorf("Expected no busy sessions, got: %d", busy)
+	}
+}
+
+// assertNoGoroutineLeak fails if the goroutine count doesn't settle back to before
+func assertNoGoroutineLeak(t *testing.T, before int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Expected at most %d goroutines, got: %d", before, after)
+	}
+}
+
+// TestListEach tests streaming every user and stopping early
+func TestListEach(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	seedStreamUsers(ctx, t, db)
+	repo := NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+
+	t.Run("All Users In Order", func(t *testing.T) {
+		var count, lastID int
+		err := repo.ListEach(ctx, func(u models.User) error {
+			if u.ID <= lastID {
+				t.Fatalf("Expected ascending IDs, got %d after %d", u.ID, lastID)
+			}
+			lastID = u.ID
+			count++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Failed to stream users: %v", err)
+		}
+		if want := streamUsers + 2; count != want {
+			t.Errorf("Expected %d users, got: %d", want, count)
+		}
+	})
+
+	t.Run("Stop After Ten", func(t *testing.T) {
+		errStop := errors.New("stop")
+		var count int
+		err := repo.ListEach(ctx, func(models.User) error {
+			count++
+			if count == 10 {
+				return errStop
+			}
+			return nil
+		})
+		if !errors.Is(err, errStop) {
+			t.Errorf("Expected the callback's error, got: %v", err)
+		}
+		if count != 10 {
+			t.Errorf("Expected 10 callbacks, got: %d", count)
+		}
+		assertNoBusyConns(ctx, t, db)
+	})
+
+	t.Run("Context Cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		var count int
+		err := repo.ListEach(ctx, func(models.User) error {
+			count++
+			if count == 10 {
+				cancel()
+			}
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+		if count != 10 {
+			t.Errorf("Expected no callbacks after cancel, got: %d", count)
+		}
+		assertNoBusyConns(context.Background(), t, db)
+	})
+}
+
+// TestListChan tests streaming users over a channel and abandoning the stream
+func TestListChan(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	seedStreamUsers(ctx, t, db)
+	repo := NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+
+	t.Run("All Users", func(t *testing.T) {
+		users, errc := repo.ListChan(ctx, 16)
+		var count int
+		for range users {
+			count++
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("Failed to stream users: %v", err)
+		}
+		if want := streamUsers + 2; count != want {
+			t.Errorf("Expected %d users, got: %d", want, count)
+		}
+	})
+
+	t.Run("Abandon After Ten", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		ctx, cancel := context.WithCancel(ctx)
+
+		users, errc := repo.ListChan(ctx, 4)
+		for i := 0; i < 10; i++ {
+			if _, ok := <-users; !ok {
+				t.Fatalf("Stream ended after %d users", i)
+			}
+		}
+		cancel()
+
+		// Drain whatever was buffered; the channel must close
+		for range users {
+		}
+		if err := <-errc; !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got: %v", err)
+		}
+		assertNoGoroutineLeak(t, before)
+		assertNoBusyConns(context.Background(), t, db)
+	})
+}
@@ -0,0 +1,137 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"testcontainers-demo/pagination"
+)
+
+// Shape of TestConcurrentStress: enough goroutines to queue on the pool, a
+// small email space so creates and updates collide, and a small ID range so
+// gets, updates, and deletes race on the same rows
+const (
+	stressWorkers  = 200
+	stressDuration = 3 * time.Second
+	stressEmails   = 50
+)
+
+// TestConcurrentStress runs mixed creates, gets, updates, deletes, and lists
+// from many goroutines at once, and checks that only the expected errors
+// come back and the pool never opens more connections than its limit. Run it
+// with -race; -short skips it.
+func TestConcurrentStress(t *testing.T) {
+	requirePostgres(t)
+	if testing.Short() {
+		t.Skip("Skipping stress test in short mode")
+	}
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+
+	limit := db.Stats().MaxOpenConnections
+	if limit <= 0 {
+		t.Fatalf("Expected a limited pool, got MaxOpenConnections %d", limit)
+	}
+	first, err := repo.Create(ctx, "stress-first@stress.test", "Stress First")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// IDs in [first.ID, first.ID+idRange) are ones creates are likely to hand out
+	idRange := 4 * stressEmails
+
+	var (
+		ops, expected atomic.Int64
+		mu            sync.Mutex
+		unexpected    []error
+	)
+	deadline := time.Now().Add(stressDuration)
+
+	// Sample the pool while the workers run
+	var peak atomic.Int64
+	done := make(chan struct{})
+	var sampler sync.WaitGroup
+	sampler.Add(1)
+	go func() {
+		defer sampler.Done()
+		ticker := time.NewTicker(5 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if open := int64(db.Stats().OpenConnections); open > peak.Load() {
+					peak.Store(open)
+				}
+			}
+		}
+	}()
+
+	// Every worker finishes before the test returns and its cleanup closes
+	// the pool; a worker outliving it would see "sql: database is closed"
+	var workers sync.WaitGroup
+	for w := 0; w < stressWorkers; w++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for time.Now().Before(deadline) {
+				email := fmt.Sprintf("stress-%d@stress.test", rand.N(stressEmails))
+				id := first.ID + rand.N(idRange)
+
+				var err error
+				switch rand.N(5) {
+				case 0:
+					_, err = repo.Create(ctx, email, "Stress User")
+				case 1:
+					_, err = repo.GetByID(ctx, id)
+				case 2:
+					err = repo.Update(ctx, id, email, "Stress Updated")
+				case 3:
+					err = repo.Delete(ctx, id)
+				case 4:
+					_, err = repo.ListPaginated(ctx, pagination.Cursor{ID: 1 + rand.N(first.ID+idRange)}.Encode(), 20)
+				}
+
+				ops.Add(1)
+				switch {
+				case err == nil:
+				case errors.Is(err, ErrUserNotFound), errors.Is(err, ErrDuplicateEmail):
+					expected.Add(1)
+				default:
+					mu.Lock()
+					unexpected = append(unexpected, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	workers.Wait()
+	close(done)
+	sampler.Wait()
+
+	t.Logf("%d operations, %d expected errors, peak %d of %d connections, %d waits",
+		ops.Load(), expected.Load(), peak.Load(), limit, db.Stats().WaitCount)
+	if len(unexpected) > 0 {
+		for _, err := range unexpected[:min(len(unexpected), 10)] {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		t.Fatalf("Expected only ErrUserNotFound and ErrDuplicateEmail, got %d other errors", len(unexpected))
+	}
+	if peak.Load() > int64(limit) {
+		t.Errorf("Expected at most %d open connections, peaked at %d", limit, peak.Load())
+	}
+	if stats := db.Stats(); stats.InUse != 0 {
+		t.Errorf("Expected every connection returned to the pool, %d still in use", stats.InUse)
+	}
+	if ops.Load() == expected.Load() {
+		t.Error("Expected some operations to succeed")
+	}
+}
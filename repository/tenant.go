@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DefaultTenant is the tenant NewUserRepository and NewCachedUserRepository
+// bind to, and the one users created before tenancy belong to
+const DefaultTenant = "default"
+
+// tenantPattern matches a tenant ID: a lowercase letter, then up to 62
+// lowercase letters, digits, and hyphens, as the users table's CHECK
+// constraint requires. Without a colon in it, one tenant's cache keys can't
+// spell another's.
+var tenantPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{0,62}$`)
+
+// ValidateTenant returns an error wrapping ErrInvalidTenant unless tenant is
+// a valid tenant ID
+func ValidateTenant(tenant string) error {
+	if !tenantPattern.MatchString(tenant) {
+		return fmt.Errorf("%w: %q", ErrInvalidTenant, tenant)
+	}
+	return nil
+}
+
+// ForTenant returns a copy of the repository bound to tenant. Every query
+// of the copy, and of copies WithTx makes of it, is scoped to the tenant's
+// users: another tenant's user is ErrUserNotFound even by ID, and emails
+// are unique within a tenant only. The copy shares the parent's connections
+// and prepared statements, so Close on either closes them for both. It
+// returns an error wrapping ErrInvalidTenant for an invalid tenant ID.
+func (r *UserRepository) ForTenant(tenant string) (*UserRepository, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+	bound := *r
+	bound.tenant = tenant
+	return &bound, nil
+}
+
+// Tenant returns the tenant the repository is bound to
+func (r *UserRepository) Tenant() string {
+	return r.tenant
+}
+
+// ForTenant returns a copy of the repository bound to tenant, as
+// UserRepository.ForTenant does. Its cache keys are the tenant's own, so
+// invalidating, InvalidateAll included, never touches another tenant's
+// entries. The copy shares the parent's Redis client, circuit breaker, and
+// options; the client stays the parent's to close.
+func (r *CachedUserRepository) ForTenant(tenant string) (*CachedUserRepository, error) {
+	if err := ValidateTenant(tenant); err != nil {
+		return nil, err
+	}
+	bound := *r
+	bound.tenant = tenant
+	bound.users, _ = r.users.ForTenant(tenant)
+	bound.closeCache = nil
+	return &bound, nil
+}
+
+// Tenant returns the tenant the repository is bound to
+func (r *CachedUserRepository) Tenant() string {
+	return r.tenant
+}
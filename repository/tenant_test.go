@@ -0,0 +1,329 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/testhelpers"
+)
+
+func TestForTenant(t *testing.T) {
+	t.Parallel()
+	repo := NewUserRepository(nil)
+	for _, tenant := range []string{"", "Acme", "1acme", "acme:corp", "acme corp", "-acme", strings.Repeat("a", 64)} {
+		if _, err := repo.ForTenant(tenant); !errors.Is(err, ErrInvalidTenant) {
+			t.Errorf("ForTenant(%q): expected ErrInvalidTenant, got: %v", tenant, err)
+		}
+	}
+	for _, tenant := range []string{"acme", "acme-2", "a", strings.Repeat("a", 63)} {
+		bound, err := repo.ForTenant(tenant)
+		if err != nil {
+			t.Errorf("ForTenant(%q): %v", tenant, err)
+			continue
+		}
+		if bound.Tenant() != tenant {
+			t.Errorf("Expected tenant %q, got %q", tenant, bound.Tenant())
+		}
+	}
+	if repo.Tenant() != DefaultTenant {
+		t.Errorf("Expected the parent to stay on %q, got %q", DefaultTenant, repo.Tenant())
+	}
+}
+
+// tenantPair binds repo to two tenants, seeding each with the same two
+// emails the default tenant's seed rows have; the tenants have their own
+// database, so counts are exact
+type tenantPair struct {
+	acme, globex           *UserRepository
+	acmeAlice, globexAlice *models.User
+	acmeBob, globexBob     *models.User
+	acmeCarol              *models.User
+}
+
+func newTenantPair(ctx context.Context, t *testing.T, repo *UserRepository) tenantPair {
+	t.Helper()
+	var p tenantPair
+	var err error
+	if p.acme, err = repo.ForTenant("acme"); err != nil {
+		t.Fatal(err)
+	}
+	if p.globex, err = repo.ForTenant("globex"); err != nil {
+		t.Fatal(err)
+	}
+	create := func(r *UserRepository, email, name string) *models.User {
+		user, err := r.Create(ctx, email, name)
+		if err != nil {
+			t.Fatalf("Failed to create %s in %s: %v", email, r.Tenant(), err)
+		}
+		return user
+	}
+	p.acmeAlice = create(p.acme, "alice@example.com", "Alice Acme")
+	p.acmeBob = create(p.acme, "bob@example.com", "Bob Acme")
+	p.acmeCarol = create(p.acme, "carol@example.com", "Carol Acme")
+	p.globexAlice = create(p.globex, "alice@example.com", "Alice Globex")
+	p.globexBob = create(p.globex, "bob@example.com", "Bob Globex")
+	return p
+}
+
+func TestTenantIsolation(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(db)
+	p := newTenantPair(ctx, t, repo)
+
+	t.Run("Emails Unique Per Tenant", func(t *testing.T) {
+		if _, err := p.globex.Create(ctx, "ALICE@example.com", "Alice Again"); !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail within a tenant, got: %v", err)
+		}
+		if ok, err := p.globex.IsEmailAvailable(ctx, "carol@example.com"); err != nil || !ok {
+			t.Errorf("Expected acme's email available in globex, got: %v, %v", ok, err)
+		}
+		if ok, err := p.acme.IsEmailAvailable(ctx, "carol@example.com"); err != nil || ok {
+			t.Errorf("Expected acme's email taken in acme, got: %v, %v", ok, err)
+		}
+	})
+
+	t.Run("Reads", func(t *testing.T) {
+		if _, err := p.globex.GetByID(ctx, p.acmeCarol.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByID: expected ErrUserNotFound, got: %v", err)
+		}
+		if _, err := p.globex.GetByUUID(ctx, p.acmeCarol.UUID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByUUID: expected ErrUserNotFound, got: %v", err)
+		}
+		if _, err := p.globex.GetByEmail(ctx, "carol@example.com"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByEmail: expected ErrUserNotFound, got: %v", err)
+		}
+		if got, err := p.globex.GetByEmail(ctx, "alice@example.com"); err != nil || got.ID != p.globexAlice.ID {
+			t.Errorf("Expected globex's alice, got: %+v, %v", got, err)
+		}
+		if _, err := repo.GetByID(ctx, p.acmeAlice.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected acme's user hidden from the default tenant, got: %v", err)
+		}
+	})
+
+	t.Run("Lists And Counts", func(t *testing.T) {
+		for _, c := range []struct {
+			repo *UserRepository
+			want int
+		}{{p.acme, 3}, {p.globex, 2}} {
+			if n, err := c.repo.CountUsers(ctx); err != nil || n != c.want {
+				t.Errorf("CountUsers in %s: expected %d, got: %d, %v", c.repo.Tenant(), c.want, n, err)
+			}
+			users, err := c.repo.List(ctx)
+			if err != nil || len(users) != c.want {
+				t.Errorf("List in %s: expected %d users, got: %d, %v", c.repo.Tenant(), c.want, len(users), err)
+			}
+			page, err := c.repo.ListPaginated(ctx, "", 10)
+			if err != nil || page.TotalCount != int64(c.want) {
+				t.Errorf("ListPaginated in %s: expected total %d, got: %d, %v", c.repo.Tenant(), c.want, page.TotalCount, err)
+			}
+			recent, err := c.repo.GetRecentUsers(ctx, 1, "", 10)
+			if err != nil || len(recent.Items) != c.want {
+				t.Errorf("GetRecentUsers in %s: expected %d users, got: %d, %v", c.repo.Tenant(), c.want, len(recent.Items), err)
+			}
+			counts, err := c.repo.CountByRole(ctx)
+			if err != nil || counts[models.RoleMember] != c.want {
+				t.Errorf("CountByRole in %s: expected %d users, got: %v, %v", c.repo.Tenant(), c.want, counts, err)
+			}
+		}
+		// The default tenant holds just the seed rows
+		if n, err := repo.CountUsers(ctx); err != nil || n != 2 {
+			t.Errorf("Expected the 2 seed users in the default tenant, got: %d, %v", n, err)
+		}
+	})
+
+	t.Run("Searches", func(t *testing.T) {
+		users, err := p.globex.FindByNamePattern(ctx, "acme")
+		if err != nil || len(users) != 0 {
+			t.Errorf("FindByNamePattern: expected no acme users in globex, got: %+v, %v", users, err)
+		}
+		users, err = p.globex.ListFiltered(ctx, Filter{EmailDomain: "example.com"}, PageOpts{})
+		if err != nil || len(users) != 2 {
+			t.Errorf("ListFiltered: expected globex's 2 users, got: %d, %v", len(users), err)
+		}
+		for _, u := range users {
+			if u.ID != p.globexAlice.ID && u.ID != p.globexBob.ID {
+				t.Errorf("ListFiltered: got another tenant's user %d", u.ID)
+			}
+		}
+	})
+
+	t.Run("Writes", func(t *testing.T) {
+		if err := p.globex.Update(ctx, p.acmeBob.ID, "bob@globex.example", "Hijacked"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Update: expected ErrUserNotFound, got: %v", err)
+		}
+		stale := *p.acmeBob
+		stale.Name = "Hijacked"
+		if err := p.globex.UpdateWithVersion(ctx, &stale); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("UpdateWithVersion: expected ErrUserNotFound, got: %v", err)
+		}
+		if err := p.globex.SetAvatarKey(ctx, p.acmeBob.ID, "avatars/hijacked"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("SetAvatarKey: expected ErrUserNotFound, got: %v", err)
+		}
+		if err := p.globex.Delete(ctx, p.acmeBob.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Delete: expected ErrUserNotFound, got: %v", err)
+		}
+		got, err := p.acme.GetByID(ctx, p.acmeBob.ID)
+		if err != nil || got.Name != "Bob Acme" || got.Email != "bob@example.com" || got.AvatarKey != "" {
+			t.Errorf("Expected acme's bob untouched, got: %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			t.Fatalf("Failed to begin transaction: %v", err)
+		}
+		defer tx.Rollback()
+		if _, err := p.globex.WithTx(tx).GetByID(ctx, p.acmeAlice.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound in a transaction, got: %v", err)
+		}
+		if _, err := p.globex.GetByIDForUpdate(ctx, tx, p.acmeAlice.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByIDForUpdate: expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Passwords", func(t *testing.T) {
+		acmeDan, err := p.acme.CreateWithPassword(ctx, "dan@example.com", "Dan Acme", "acme-secret")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		globexDan, err := p.globex.CreateWithPassword(ctx, "dan@example.com", "Dan Globex", "globex-secret")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if got, err := p.acme.Authenticate(ctx, "dan@example.com", "acme-secret"); err != nil || got.ID != acmeDan.ID {
+			t.Errorf("Expected acme's dan, got: %+v, %v", got, err)
+		}
+		if _, err := p.acme.Authenticate(ctx, "dan@example.com", "globex-secret"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Expected globex's password rejected in acme, got: %v", err)
+		}
+		if err := p.acme.ChangePassword(ctx, globexDan.ID, "globex-secret", "stolen"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("ChangePassword: expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Idempotency Keys", func(t *testing.T) {
+		const key = "tenant-isolation-key"
+		acmeUser, err := p.acme.CreateIdempotent(ctx, key, "erin@example.com", "Erin")
+		if err != nil {
+			t.Fatalf("Failed to create user in acme: %v", err)
+		}
+		globexUser, err := p.globex.CreateIdempotent(ctx, key, "erin@example.com", "Erin")
+		if err != nil {
+			t.Fatalf("Failed to create user in globex: %v", err)
+		}
+		if acmeUser.ID == globexUser.ID {
+			t.Errorf("Expected the key to create a user in each tenant, both got %d", acmeUser.ID)
+		}
+		replayed, err := p.globex.CreateIdempotent(ctx, key, "erin@example.com", "Erin")
+		if err != nil || replayed.ID != globexUser.ID {
+			t.Errorf("Expected globex's user replayed, got: %+v, %v", replayed, err)
+		}
+	})
+}
+
+func TestCachedTenantIsolation(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	p := newTenantPair(ctx, t, NewUserRepository(db))
+	cached := NewCachedUserRepository(db, redisClient)
+	acme, err := cached.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	globex, err := cached.ForTenant("globex")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Keys", func(t *testing.T) {
+		if _, err := acme.GetByIDCached(ctx, p.acmeAlice.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		id := strconv.Itoa(p.acmeAlice.ID)
+		if n, err := redisClient.Exists(ctx, "user:acme:"+id).Result(); err != nil || n != 1 {
+			t.Errorf("Expected user:acme:%s cached, got: %d, %v", id, n, err)
+		}
+		if n, err := redisClient.Exists(ctx, "user:globex:"+id, "user:default:"+id).Result(); err != nil || n != 0 {
+			t.Errorf("Expected no other tenant's key for %s, got: %d, %v", id, n, err)
+		}
+	})
+
+	t.Run("Reads", func(t *testing.T) {
+		// acme's user is cached by now; globex must still miss it
+		if _, err := acme.GetByIDCached(ctx, p.acmeCarol.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if _, err := globex.GetByIDCached(ctx, p.acmeCarol.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("GetByIDCached: expected ErrUserNotFound, got: %v", err)
+		}
+		rctx := WithRequestCache(ctx)
+		if _, err := acme.GetByIDCached(rctx, p.acmeBob.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if _, err := globex.GetByIDCached(rctx, p.acmeBob.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected the request cache to miss another tenant's user, got: %v", err)
+		}
+		for _, c := range []struct {
+			repo *CachedUserRepository
+			want int
+		}{{acme, p.acmeAlice.ID}, {globex, p.globexAlice.ID}} {
+			if got, err := c.repo.GetByEmailCached(ctx, "alice@example.com"); err != nil || got.ID != c.want {
+				t.Errorf("GetByEmailCached in %s: expected user %d, got: %+v, %v", c.repo.Tenant(), c.want, got, err)
+			}
+		}
+		users, err := globex.GetByIDsCached(ctx, []int{p.acmeAlice.ID, p.globexAlice.ID})
+		if err != nil || len(users) != 1 || users[0].ID != p.globexAlice.ID {
+			t.Errorf("GetByIDsCached: expected only globex's alice, got: %+v, %v", users, err)
+		}
+	})
+
+	t.Run("Writes", func(t *testing.T) {
+		if err := globex.UpdateCached(ctx, p.acmeAlice.ID, "alice@globex.example", "Hijacked"); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("UpdateCached: expected ErrUserNotFound, got: %v", err)
+		}
+		if err := globex.DeleteCached(ctx, p.acmeAlice.ID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("DeleteCached: expected ErrUserNotFound, got: %v", err)
+		}
+		if got, err := acme.GetByIDCached(ctx, p.acmeAlice.ID); err != nil || got.Name != "Alice Acme" {
+			t.Errorf("Expected acme's alice untouched, got: %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Invalidation", func(t *testing.T) {
+		if _, err := acme.GetByIDCached(ctx, p.acmeAlice.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if _, err := globex.GetByIDCached(ctx, p.globexAlice.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		acmeKey := userCacheKey("acme", p.acmeAlice.ID)
+		if err := globex.InvalidateCache(ctx, p.acmeAlice.ID); err != nil {
+			t.Fatalf("Failed to invalidate: %v", err)
+		}
+		if n, err := redisClient.Exists(ctx, acmeKey).Result(); err != nil || n != 1 {
+			t.Errorf("Expected globex's invalidation to leave %s, got: %d, %v", acmeKey, n, err)
+		}
+		removed, err := globex.InvalidateAll(ctx)
+		if err != nil || removed == 0 {
+			t.Fatalf("Expected globex's keys removed, got: %d, %v", removed, err)
+		}
+		if n, err := redisClient.Exists(ctx, acmeKey).Result(); err != nil || n != 1 {
+			t.Errorf("Expected globex's InvalidateAll to leave %s, got: %d, %v", acmeKey, n, err)
+		}
+		keys, err := redisClient.Keys(ctx, tenantKeyPrefix("globex")+"*").Result()
+		if err != nil || len(keys) != 0 {
+			t.Errorf("Expected no globex keys left, got: %v, %v", keys, err)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// WithUpdateLimit allows at most limit UpdateCached calls per user in each
+// window; further calls return a *TooManyUpdatesError until the window
+// ends. Windows are fixed, aligned to multiples of window on the Clock, and
+// counted in Redis, so the limit holds across every repository sharing it.
+// Calls rejected by the limit count towards it too. A limit of 0 or less,
+// the default, disables the guard.
+//
+// The guard is soft: if Redis is failing, updates go through unthrottled
+// rather than fail.
+func WithUpdateLimit(limit int, window time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.updateLimit = limit
+		r.updateWindow = window
+	}
+}
+
+// updateRateKey is the Redis key counting the updates of tenant's user id
+// in the window starting at start. It is outside the "user:" prefix so
+// InvalidateAll doesn't reset the count.
+func updateRateKey(tenant string, id int, start time.Time) string {
+	return fmt.Sprintf("update_rate:%s:%d:%d", tenant, id, start.UnixMilli())
+}
+
+// allowUpdate counts an update of user id against WithUpdateLimit,
+// returning a *TooManyUpdatesError if it is over the limit
+func (r *CachedUserRepository) allowUpdate(ctx context.Context, id int) error {
+	if r.updateLimit <= 0 || r.updateWindow <= 0 {
+		return nil
+	}
+	now := r.clock.Now()
+	start := now.Truncate(r.updateWindow)
+	cacheKey := updateRateKey(r.tenant, id, start)
+
+	// The key expires a window after its last update, by which time its
+	// window is over whatever the clock says
+	var incr *redis.IntCmd
+	incrCtx, finish := observe(ctx, r.hooks, &Op{Name: "cache.Incr", UserID: id}, cacheKey)
+	err := r.cacheDo(incrCtx, func(ctx context.Context) error {
+		_, err := r.cache.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.Incr(ctx, cacheKey)
+			pipe.Expire(ctx, cacheKey, r.updateWindow)
+			return nil
+		})
+		return err
+	})
+	finish(err)
+	if err != nil {
+		return nil
+	}
+
+	if incr.Val() > int64(r.updateLimit) {
+		return &TooManyUpdatesError{RetryAfter: start.Add(r.updateWindow).Sub(now)}
+	}
+	return nil
+}
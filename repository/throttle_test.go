@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// TestUpdateLimit drives UpdateCached across a window boundary with a fake
+// clock and checks that only the allowed updates reached Postgres
+func TestUpdateLimit(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+	// 10s into a minute, so the first window has 50s left
+	clock := testhelpers.NewFakeClock(time.Date(2030, 1, 1, 12, 0, 10, 0, time.UTC))
+	cachedRepo := NewCachedUserRepository(testDB, redisClient,
+		WithUpdateLimit(3, time.Minute),
+		WithCachedClock(clock),
+	)
+
+	email := fixtures.GenerateEmail(t)
+	user, err := cachedRepo.CreateCached(ctx, email, "Throttled User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, user.ID)
+
+	calls := 0
+	// update renames the user, returning UpdateCached's error
+	update := func() error {
+		calls++
+		return cachedRepo.UpdateCached(ctx, user.ID, email, fmt.Sprintf("Throttled %d", calls))
+	}
+	// expectAllowed fails unless the next n updates succeed
+	expectAllowed := func(t *testing.T, n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if err := update(); err != nil {
+				t.Fatalf("Expected update %d allowed, got: %v", calls, err)
+			}
+		}
+	}
+	// expectRejected fails unless the next update is rejected with retryAfter
+	expectRejected := func(t *testing.T, retryAfter time.Duration) {
+		t.Helper()
+		err := update()
+		var tooMany *TooManyUpdatesError
+		if !errors.As(err, &tooMany) || !errors.Is(err, ErrTooManyUpdates) {
+			t.Fatalf("Expected ErrTooManyUpdates for update %d, got: %v", calls, err)
+		}
+		if tooMany.RetryAfter != retryAfter {
+			t.Errorf("Expected retry after %s, got: %s", retryAfter, tooMany.RetryAfter)
+		}
+	}
+
+	t.Run("First Window", func(t *testing.T) {
+		expectAllowed(t, 3)
+		expectRejected(t, 50*time.Second)
+		clock.Advance(49 * time.Second)
+		expectRejected(t, time.Second)
+	})
+
+	t.Run("Next Window", func(t *testing.T) {
+		clock.Advance(time.Second)
+		expectAllowed(t, 3)
+		expectRejected(t, time.Minute)
+	})
+
+	t.Run("Other Users Unaffected", func(t *testing.T) {
+		other, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Other User")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		deleteOnCleanup(t, other.ID)
+		if err := cachedRepo.UpdateCached(ctx, other.ID, other.Email, "Other Renamed"); err != nil {
+			t.Errorf("Expected another user's update allowed, got: %v", err)
+		}
+	})
+
+	t.Run("Only Allowed Updates Written", func(t *testing.T) {
+		var version int
+		var name string
+		if err := testDB.QueryRowContext(ctx, "SELECT version, name FROM users WHERE id = $1", user.ID).Scan(&version, &name); err != nil {
+			t.Fatalf("Failed to read user: %v", err)
+		}
+		// Every UPDATE bumps the version from 1
+		if version != 1+6 {
+			t.Errorf("Expected 6 updates written, got: %d", version-1)
+		}
+		// Calls 4 and 5 were rejected, so call 8 was the last written
+		if name != "Throttled 8" {
+			t.Errorf("Expected name 'Throttled 8', got: %s", name)
+		}
+	})
+
+	t.Run("Disabled By Default", func(t *testing.T) {
+		unlimited := NewCachedUserRepository(testDB, redisClient, WithCachedClock(clock))
+		for i := 0; i < 5; i++ {
+			if err := unlimited.UpdateCached(ctx, user.ID, email, "Unlimited"); err != nil {
+				t.Fatalf("Expected update allowed, got: %v", err)
+			}
+		}
+	})
+}
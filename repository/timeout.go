@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// WithStatementTimeout gives every operation at most d, counted from its
+// start, by deriving a context deadline for it. Both drivers cancel the
+// running statement on the server when the deadline passes, so a hung query
+// fails with an error naming the operation instead of running into the test
+// binary's timeout. pgx's error wraps context.DeadlineExceeded; lib/pq
+// returns Postgres's "canceling statement due to user request". A shorter
+// deadline already on the caller's context still wins.
+func WithStatementTimeout(d time.Duration) Option {
+	return WithHooks(timeoutHook{timeout: d})
+}
+
+// timeoutHook is a Hook that bounds each operation by a deadline
+type timeoutHook struct {
+	timeout time.Duration
+}
+
+// cancelKey carries the deadline's cancel function from Before to After
+type cancelKey struct{}
+
+func (h timeoutHook) Before(ctx context.Context, _ Op, _ []interface{}) context.Context {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	return context.WithValue(ctx, cancelKey{}, cancel)
+}
+
+// After releases the deadline's timer; the operation is over by now
+func (h timeoutHook) After(ctx context.Context, _ Op, _ time.Duration, _ error) {
+	if cancel, ok := ctx.Value(cancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+// Logger is where WithSlowQueryThreshold reports slow operations;
+// *log.Logger implements it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// WithSlowQueryThreshold logs every operation that takes threshold or
+// longer, with its name, duration, and SQL, to logger, or to the standard
+// logger when logger is nil. Statements only hold $n placeholders, so no
+// argument values are logged.
+func WithSlowQueryThreshold(threshold time.Duration, logger Logger) Option {
+	if logger == nil {
+		logger = log.Default()
+	}
+	return WithHooks(slowQueryHook{threshold: threshold, logger: logger})
+}
+
+// slowQueryHook is a Hook that logs operations slower than threshold
+type slowQueryHook struct {
+	NopHook
+	threshold time.Duration
+	logger    Logger
+}
+
+func (h slowQueryHook) After(_ context.Context, op Op, duration time.Duration, err error) {
+	if duration < h.threshold {
+		return
+	}
+	msg := "repository: slow operation %s took %s"
+	args := []interface{}{op, duration.Round(time.Millisecond)}
+	if op.Statement != "" {
+		msg += ": %s"
+		args = append(args, sanitizeStatement(op.Statement))
+	}
+	if err != nil {
+		msg += " (error: %v)"
+		args = append(args, err)
+	}
+	h.logger.Printf(msg, args...)
+}
@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// rawQuery runs query as an operation of r, so hooks see it like any
+// method's statement; with pg_sleep it is as slow as a test needs
+func (r *UserRepository) rawQuery(ctx context.Context, query string) (err error) {
+	const op = "UserRepository.rawQuery"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if _, err := r.db.ExecContext(ctx, query); err != nil {
+		return newRepoError(op, "", err)
+	}
+	return nil
+}
+
+// bufferLogger is a Logger that keeps every line
+type bufferLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *bufferLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, fmt.Sprintf(format, v...))
+}
+
+func (l *bufferLogger) take() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := l.lines
+	l.lines = nil
+	return lines
+}
+
+// TestStatementTimeout tests that a hung statement fails at the deadline,
+// naming its operation, and is cancelled on the server too
+func TestStatementTimeout(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB, WithStatementTimeout(200*time.Millisecond))
+	defer repo.Close()
+
+	start := time.Now()
+	err := repo.rawQuery(ctx, "SELECT pg_sleep(30) /* statement timeout test */")
+	elapsed := time.Since(start)
+	if err == nil {
+		t.Fatal("Expected the sleep to be cancelled")
+	}
+	if !strings.Contains(err.Error(), "UserRepository.rawQuery") {
+		t.Errorf("Expected the error to name the operation, got: %v", err)
+	}
+	if elapsed > 5*time.Second {
+		t.Errorf("Expected to give up after about 200ms, took: %s", elapsed)
+	}
+
+	// The cancel request is asynchronous, so give the server a moment
+	var running int
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		err := testDB.QueryRowContext(ctx, `
+			SELECT count(*) FROM pg_stat_activity
+			WHERE query LIKE '%statement timeout test%' AND state = 'active' AND pid <> pg_backend_pid()`).Scan(&running)
+		if err != nil {
+			t.Fatalf("Failed to query pg_stat_activity: %v", err)
+		}
+		if running == 0 {
+			break
+		}
+	}
+	if running != 0 {
+		t.Error("Expected the statement to be cancelled on the server")
+	}
+
+	// Fast operations are unaffected
+	if _, err := repo.CountUsers(ctx); err != nil {
+		t.Errorf("Failed to count users: %v", err)
+	}
+}
+
+// TestSlowQueryThreshold tests that only operations over the threshold are logged
+func TestSlowQueryThreshold(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	logger := &bufferLogger{}
+	repo := NewUserRepository(testDB, WithSlowQueryThreshold(100*time.Millisecond, logger))
+	defer repo.Close()
+
+	if _, err := repo.CountUsers(ctx); err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if lines := logger.take(); len(lines) != 0 {
+		t.Errorf("Expected nothing logged for a fast count, got: %q", lines)
+	}
+
+	if err := repo.rawQuery(ctx, "SELECT pg_sleep(0.2)"); err != nil {
+		t.Fatalf("Failed to sleep: %v", err)
+	}
+	lines := logger.take()
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line logged, got: %q", lines)
+	}
+	for _, want := range []string{"UserRepository.rawQuery", "SELECT pg_sleep(0.2)"} {
+		if !strings.Contains(lines[0], want) {
+			t.Errorf("Expected the line to mention %q, got: %q", want, lines[0])
+		}
+	}
+
+	t.Run("With Timeout", func(t *testing.T) {
+		// Installed together, the timed-out operation is logged with its error
+		repo := NewUserRepository(testDB,
+			WithStatementTimeout(300*time.Millisecond),
+			WithSlowQueryThreshold(100*time.Millisecond, logger))
+		defer repo.Close()
+
+		if err := repo.rawQuery(ctx, "SELECT pg_sleep(30)"); err == nil {
+			t.Fatal("Expected the sleep to be cancelled")
+		}
+		lines := logger.take()
+		if len(lines) != 1 || !strings.Contains(lines[0], "error:") {
+			t.Errorf("Expected the timed-out operation logged with its error, got: %q", lines)
+		}
+	})
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+)
+
+// exoticTimeZone is +12:45 or +13:45, so a wall-clock time read in it is
+// neither UTC nor on the same day
+const exoticTimeZone = "Pacific/Chatham"
+
+// TestCreatedAtTimeZone tests that with the database's sessions in an exotic
+// time zone, a user's created_at reads back as the same UTC value from
+// Postgres, from the cache, and through export and import
+func TestCreatedAtTimeZone(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabaseInTimeZone(ctx, t, exoticTimeZone)
+	var zone string
+	if err := db.QueryRowContext(ctx, "SHOW TimeZone").Scan(&zone); err != nil || zone != exoticTimeZone {
+		t.Fatalf("Expected sessions in %s, got: %q, %v", exoticTimeZone, zone, err)
+	}
+	repo := NewUserRepository(db)
+	t.Cleanup(func() { repo.Close() })
+	cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t))
+
+	created, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Chatham User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// expectSame fails unless got is created's created_at: the same instant,
+	// in UTC, so == holds and not only Equal
+	expectSame := func(t *testing.T, got time.Time) {
+		t.Helper()
+		if got != created.CreatedAt {
+			t.Errorf("Expected created_at %s, got: %s", created.CreatedAt, got)
+		}
+	}
+	if created.CreatedAt.Location() != time.UTC {
+		t.Fatalf("Expected created_at in UTC, got: %s", created.CreatedAt)
+	}
+
+	t.Run("DB Read", func(t *testing.T) {
+		user, err := repo.GetByID(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		expectSame(t, user.CreatedAt)
+	})
+
+	t.Run("Cached Read", func(t *testing.T) {
+		// The first read fills the cache, the second reads it back
+		for _, read := range []string{"miss", "hit"} {
+			user, err := cachedRepo.GetByIDCached(ctx, created.ID)
+			if err != nil {
+				t.Fatalf("Failed to get user on cache %s: %v", read, err)
+			}
+			expectSame(t, user.CreatedAt)
+		}
+	})
+
+	t.Run("Export Import", func(t *testing.T) {
+		for _, format := range []Format{FormatCSV, FormatJSONL} {
+			var exported bytes.Buffer
+			if err := repo.ExportUsers(ctx, &exported, format); err != nil {
+				t.Fatalf("Failed to export %s: %v", format, err)
+			}
+			// Into a database whose sessions are in UTC
+			otherDB := testContainer.CreateTestDatabase(ctx, t)
+			wipeUsers(ctx, t, otherDB)
+			other := NewUserRepository(otherDB)
+			t.Cleanup(func() { other.Close() })
+			if _, err := other.ImportUsers(ctx, bytes.NewReader(exported.Bytes()), format, ImportOptions{}); err != nil {
+				t.Fatalf("Failed to import %s: %v", format, err)
+			}
+			user, err := other.GetByID(ctx, created.ID)
+			if err != nil {
+				t.Fatalf("Failed to get imported user: %v", err)
+			}
+			expectSame(t, user.CreatedAt)
+
+			var again bytes.Buffer
+			if err := other.ExportUsers(ctx, &again, format); err != nil {
+				t.Fatalf("Failed to export %s again: %v", format, err)
+			}
+			if !bytes.Equal(again.Bytes(), exported.Bytes()) {
+				t.Errorf("Expected the %s export identical across time zones, got:\n%s\nthen:\n%s", format, exported.String(), again.String())
+			}
+		}
+	})
+
+	t.Run("Stats Days In UTC", func(t *testing.T) {
+		// 23:30 UTC is 12:15 or 13:15 the next day in Chatham
+		late := time.Date(2024, 6, 30, 23, 30, 0, 0, time.UTC)
+		fixtures.SeedUsers(t, db, fixtures.NewUser().WithCreatedAt(late))
+		stats, err := repo.GetUserStats(ctx, time.Date(2024, 6, 30, 0, 0, 0, 0, time.UTC), time.Date(2024, 7, 2, 0, 0, 0, 0, time.UTC))
+		if err != nil {
+			t.Fatalf("Failed to get stats: %v", err)
+		}
+		if len(stats.Days) != 2 || stats.Days[0].Signups != 1 || stats.Days[1].Signups != 0 {
+			t.Errorf("Expected the signup on 2024-06-30, got: %+v", stats.Days)
+		}
+	})
+}
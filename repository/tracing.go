@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName is the instrumentation scope of repository spans
+const tracerName = "testcontainers-demo/repository"
+
+// WithTracerProvider records a span per UserRepository operation. A nil
+// provider uses the no-op tracer; without the option no hook is installed.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return WithHooks(newTracingHook(tp))
+}
+
+// WithCachedTracerProvider records a span per CachedUserRepository operation,
+// with child spans for the cache lookup, the database read, and the cache write
+func WithCachedTracerProvider(tp trace.TracerProvider) CachedOption {
+	return WithCachedHooks(newTracingHook(tp))
+}
+
+// tracingHook is a Hook that turns repository operations into spans
+type tracingHook struct {
+	tracer trace.Tracer
+}
+
+func newTracingHook(tp trace.TracerProvider) tracingHook {
+	if tp == nil {
+		tp = noop.NewTracerProvider()
+	}
+	return tracingHook{tracer: tp.Tracer(tracerName)}
+}
+
+// Before starts the span; the returned context makes nested operations its children
+func (h tracingHook) Before(ctx context.Context, op Op, _ []interface{}) context.Context {
+	var attrs []attribute.KeyValue
+	if op.Statement != "" {
+		attrs = append(attrs, attribute.String("db.statement", sanitizeStatement(op.Statement)))
+	}
+	if op.UserID != 0 {
+		attrs = append(attrs, attribute.Int("user.id", op.UserID))
+	}
+
+	ctx, _ = h.tracer.Start(ctx, op.Name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attrs...),
+	)
+	return ctx
+}
+
+// After records the cache result and error, then ends the span
+func (h tracingHook) After(ctx context.Context, op Op, _ time.Duration, err error) {
+	span := trace.SpanFromContext(ctx)
+	if op.Cache != "" {
+		span.SetAttributes(attribute.Bool("cache.hit", op.Cache == CacheHit))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// sanitizeStatement collapses a query onto one line. Queries only ever
+// reference $n placeholders, so argument values never reach the span.
+func sanitizeStatement(query string) string {
+	return strings.Join(strings.Fields(query), " ")
+}
@@ -0,0 +1,181 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"testcontainers-demo/testhelpers"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestTracer returns a tracer provider that exports spans synchronously to memory
+func newTestTracer(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(context.Background()) })
+	return tp, exporter
+}
+
+// spanAttr returns a span attribute by key
+func spanAttr(span tracetest.SpanStub, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+// spansNamed picks the spans with the given name
+func spansNamed(spans tracetest.SpanStubs, name string) []tracetest.SpanStub {
+	var out []tracetest.SpanStub
+	for _, s := range spans {
+		if s.Name == name {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// TestTracing tests the spans recorded for UserRepository operations
+func TestTracing(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	user := newUser(t)
+
+	tp, exporter := newTestTracer(t)
+	repo := NewUserRepository(testDB, WithTracerProvider(tp))
+	t.Cleanup(func() { repo.Close() })
+
+	t.Run("Attributes", func(t *testing.T) {
+		exporter.Reset()
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 || spans[0].Name != "UserRepository.GetByID" {
+			t.Fatalf("Expected one UserRepository.GetByID span, got: %v", spans)
+		}
+		span := spans[0]
+		if v, ok := spanAttr(span, "user.id"); !ok || v.AsInt64() != int64(user.ID) {
+			t.Errorf("Expected user.id=%d, got: %v", user.ID, v.Emit())
+		}
+		want := "SELECT id, uuid, email, name, role, created_at, COALESCE(avatar_key, ''), version FROM users WHERE id = $1"
+		if v, _ := spanAttr(span, "db.statement"); v.AsString() != want {
+			t.Errorf("Expected db.statement %q, got: %q", want, v.AsString())
+		}
+		if span.Status.Code != codes.Unset {
+			t.Errorf("Expected unset status, got: %v", span.Status)
+		}
+	})
+
+	t.Run("Statement Is Sanitized", func(t *testing.T) {
+		exporter.Reset()
+		if _, err := repo.GetRecentUsers(ctx, 7, "", 0); err != nil {
+			t.Fatalf("Failed to get recent users: %v", err)
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("Expected one span, got: %d", len(spans))
+		}
+		v, _ := spanAttr(spans[0], "db.statement")
+		if got := v.AsString(); got == "" || got != sanitizeStatement(got) {
+			t.Errorf("Expected a single-line statement, got: %q", got)
+		}
+	})
+
+	t.Run("Error Status", func(t *testing.T) {
+		exporter.Reset()
+		if _, err := repo.GetByID(ctx, missingID); err == nil {
+			t.Fatal("Expected error for non-existent user")
+		}
+
+		spans := exporter.GetSpans()
+		if len(spans) != 1 {
+			t.Fatalf("Expected one span, got: %d", len(spans))
+		}
+		if spans[0].Status.Code != codes.Error {
+			t.Errorf("Expected error status, got: %v", spans[0].Status)
+		}
+		if len(spans[0].Events) == 0 || spans[0].Events[0].Name != "exception" {
+			t.Errorf("Expected the error to be recorded as an event, got: %v", spans[0].Events)
+		}
+	})
+
+	t.Run("Nil Provider", func(t *testing.T) {
+		repo := NewUserRepository(testDB, WithTracerProvider(nil))
+		defer repo.Close()
+
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Fatalf("Failed to get user with the no-op tracer: %v", err)
+		}
+	})
+}
+
+// TestCachedTracing tests that GetByIDCached only has a nested db span on a cache miss
+func TestCachedTracing(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	user := newUser(t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+
+	tp, exporter := newTestTracer(t)
+	cachedRepo := NewCachedUserRepository(testDB, redisClient, WithCachedTracerProvider(tp))
+
+	if err := cachedRepo.InvalidateCache(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to invalidate cache: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		hit     bool
+		dbSpans int
+	}{
+		{"Miss", false, 1},
+		{"Hit", true, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+			if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+				t.Fatalf("Failed to get user: %v", err)
+			}
+
+			spans := exporter.GetSpans()
+			parents := spansNamed(spans, "CachedUserRepository.GetByIDCached")
+			if len(parents) != 1 {
+				t.Fatalf("Expected one parent span, got: %v", spans)
+			}
+			parent := parents[0]
+			if v, ok := spanAttr(parent, "cache.hit"); !ok || v.AsBool() != tc.hit {
+				t.Errorf("Expected cache.hit=%v, got: %v", tc.hit, v.Emit())
+			}
+
+			dbSpans := spansNamed(spans, "db.GetByID")
+			if len(dbSpans) != tc.dbSpans {
+				t.Fatalf("Expected %d db spans, got: %d", tc.dbSpans, len(dbSpans))
+			}
+			for _, s := range dbSpans {
+				if s.Parent.SpanID() != parent.SpanContext.SpanID() {
+					t.Errorf("Expected db span to be a child of the GetByIDCached span")
+				}
+				if _, ok := spanAttr(s, "db.statement"); !ok {
+					t.Error("Expected db span to carry db.statement")
+				}
+			}
+
+			for _, s := range spans {
+				if s.Name != parent.Name && s.SpanContext.TraceID() != parent.SpanContext.TraceID() {
+					t.Errorf("Expected %s to be in the GetByIDCached trace", s.Name)
+				}
+			}
+		})
+	}
+}
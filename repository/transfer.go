@@ -0,0 +1,368 @@
+package repository
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"testcontainers-demo/models"
+
+	"github.com/google/uuid"
+)
+
+// Format is a file format ExportUsers writes and ImportUsers reads
+type Format string
+
+const (
+	// FormatCSV is a header row of csvHeader, then one user per row, with
+	// created_at in UTC as RFC 3339 with fractional seconds
+	FormatCSV Format = "csv"
+	// FormatJSONL is one user per line, as models.User marshals
+	FormatJSONL Format = "jsonl"
+)
+
+// csvHeader is the first row of a CSV export, naming its columns
+var csvHeader = []string{"id", "uuid", "email", "name", "role", "created_at"}
+
+// ImportMode decides what ImportUsers does with a row that can't be inserted
+type ImportMode int
+
+const (
+	// ImportSkipDuplicates skips a user whose ID, UUID, or email is taken
+	// and records any other bad row, then carries on
+	ImportSkipDuplicates ImportMode = iota
+	// ImportFailFast stops at the first row that can't be inserted, duplicates included
+	ImportFailFast
+	// ImportUpsert overwrites the email, name, role, and created_at of the
+	// user with the row's UUID, keeping its ID, and records any other bad
+	// row, including one whose UUID another tenant's user has
+	ImportUpsert
+)
+
+// ImportOptions configures ImportUsers
+type ImportOptions struct {
+	Mode ImportMode
+}
+
+// ImportSummary counts what ImportUsers did with each row
+type ImportSummary struct {
+	Inserted int
+	Updated  int // only with ImportUpsert
+	Skipped  int // only with ImportSkipDuplicates
+	Failed   []RowError
+}
+
+// RowError is why one row of an import failed. Row is the line of the input
+// the record starts on, so a CSV header is row 1.
+type RowError struct {
+	Row int
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return "row " + strconv.Itoa(e.Row) + ": " + e.Err.Error()
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// ExportUsers writes every user, ordered by ID, to w in format. Rows are
+// streamed as they are read, so the table is never held in memory.
+// Passwords and avatars are not exported.
+func (r *UserRepository) ExportUsers(ctx context.Context, w io.Writer, format Format) error {
+	const op = "UserRepository.ExportUsers"
+	key := "format=" + string(format)
+
+	var write func(models.User) error
+	var flush func() error
+	switch format {
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return newRepoError(op, key, fmt.Errorf("failed to write header: %w", err))
+		}
+		write = func(u models.User) error {
+			return cw.Write([]string{
+				strconv.Itoa(u.ID), u.UUID.String(), u.Email, u.Name, string(u.Role),
+				u.CreatedAt.UTC().Format(time.RFC3339Nano),
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case FormatJSONL:
+		bw := bufio.NewWriter(w)
+		enc := json.NewEncoder(bw)
+		write = func(u models.User) error {
+			u.CreatedAt = u.CreatedAt.UTC()
+			return enc.Encode(u)
+		}
+		flush = bw.Flush
+	default:
+		return newRepoError(op, key, fmt.Errorf("unknown format %q", format))
+	}
+
+	err := r.ListEach(ctx, func(u models.User) error {
+		if err := write(u); err != nil {
+			return newRepoError(op, key, fmt.Errorf("failed to write user %d: %w", u.ID, err))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to write users: %w", err))
+	}
+	return nil
+}
+
+// ImportUsers reads users in format from r, as ExportUsers writes them, and
+// inserts them into the repository's tenant with their IDs, UUIDs, and
+// creation times, recording a user.created event for each (user.updated for
+// an upsert). IDs and UUIDs are unique across tenants, emails only within
+// one. Afterwards the ID sequence continues past the highest ID.
+//
+// A row that doesn't parse or validate, or collides with an existing user,
+// is handled as opts.Mode says and counted in the summary. ImportFailFast
+// returns a *RowError for the first such row; rows before it stay imported
+// unless the repository is bound to a transaction with WithTx. Any other
+// error, like a lost connection, ends the import at once. Postgres only.
+func (r *UserRepository) ImportUsers(ctx context.Context, rd io.Reader, format Format, opts ImportOptions) (_ ImportSummary, err error) {
+	const op = "UserRepository.ImportUsers"
+	key := "format=" + string(format)
+	query := importUserQuery(opts.Mode)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, format, opts.Mode)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	var summary ImportSummary
+	var next func() (models.User, int, error)
+	switch format {
+	case FormatCSV:
+		next, err = csvRows(rd)
+	case FormatJSONL:
+		next = jsonlRows(rd)
+	default:
+		err = fmt.Errorf("unknown format %q", format)
+	}
+	if err != nil {
+		return summary, newRepoError(op, key, err)
+	}
+
+	for {
+		user, row, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err == nil {
+			err = r.importUser(ctx, query, opts.Mode, user, &summary)
+			if isUniqueViolation(err) || errors.Is(err, ErrDuplicateUser) {
+				err = &RowError{Row: row, Err: ErrDuplicateUser}
+			}
+		}
+		var rowErr *RowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return summary, newRepoError(op, key, fmt.Errorf("failed to import row %d: %w", row, err))
+		}
+		if rowErr == nil {
+			continue
+		}
+
+		summary.Failed = append(summary.Failed, *rowErr)
+		if opts.Mode == ImportFailFast {
+			return summary, newRepoError(op, key, rowErr)
+		}
+	}
+
+	if summary.Inserted > 0 {
+		if err := r.advanceUserIDs(ctx); err != nil {
+			return summary, newRepoError(op, key, err)
+		}
+	}
+	return summary, nil
+}
+
+// importUserQuery is the INSERT of one imported user for mode, into tenant
+// $7. It returns whether the row was inserted, and no row at all when it
+// was skipped, or for an upsert, when the UUID is another tenant's.
+func importUserQuery(mode ImportMode) string {
+	var conflict string
+	switch mode {
+	case ImportSkipDuplicates:
+		conflict = "ON CONFLICT DO NOTHING"
+	case ImportUpsert:
+		conflict = `ON CONFLICT (uuid) DO UPDATE SET
+			email = EXCLUDED.email, name = EXCLUDED.name, role = EXCLUDED.role, created_at = EXCLUDED.created_at
+			WHERE users.tenant_id = EXCLUDED.tenant_id`
+	}
+	// xmax is 0 only in a row version that no update has replaced, which
+	// tells an upsert's insert from its update
+	return `
+		WITH u AS (
+			INSERT INTO users (id, uuid, email, name, role, created_at, tenant_id)
+			VALUES ($1, $2, $3, $4, $5, $6, $7)
+			` + conflict + `
+			RETURNING id, uuid, email, name, role, created_at, xmax = 0 AS inserted
+		), e AS (
+			INSERT INTO user_events (user_id, event_type, payload)
+			SELECT id,
+				CASE WHEN inserted THEN '` + string(models.EventUserCreated) + `' ELSE '` + string(models.EventUserUpdated) + `' END,
+				` + userEventPayload + ` FROM u
+		)
+		SELECT inserted FROM u
+	`
+}
+
+// importUser runs query, importUserQuery(mode), for user and counts the
+// outcome in summary
+func (r *UserRepository) importUser(ctx context.Context, query string, mode ImportMode, user models.User, summary *ImportSummary) error {
+	var inserted bool
+	err := r.withRetry(ctx, func() error {
+		return r.db.QueryRowContext(ctx, query,
+			user.ID, user.UUID, user.Email, user.Name, user.Role, user.CreatedAt.UTC(), r.tenant,
+		).Scan(&inserted)
+	})
+	switch {
+	case errors.Is(err, sql.ErrNoRows) && mode == ImportUpsert:
+		return ErrDuplicateUser
+	case errors.Is(err, sql.ErrNoRows):
+		summary.Skipped++
+	case err != nil:
+		return err
+	case inserted:
+		summary.Inserted++
+	default:
+		summary.Updated++
+	}
+	return nil
+}
+
+// advanceUserIDs moves the users ID sequence past the highest ID, so users
+// created after an import don't collide with imported ones. It never moves
+// the sequence back.
+func (r *UserRepository) advanceUserIDs(ctx context.Context) error {
+	_, err := r.db.ExecContext(ctx, `
+		SELECT setval('users_id_seq', MAX(id)) FROM users
+		HAVING MAX(id) >= (SELECT last_value FROM users_id_seq)`)
+	if err != nil {
+		return fmt.Errorf("failed to advance user IDs: %w", err)
+	}
+	return nil
+}
+
+// csvRows reads the header of a CSV import and returns a function yielding
+// its users one at a time, with the line each starts on. A row that can't be
+// used comes back as a *RowError, and io.EOF ends the input.
+func csvRows(rd io.Reader) (func() (models.User, int, error), error) {
+	cr := csv.NewReader(rd)
+	header, err := cr.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("empty CSV, expected a header row")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+	if !slices.Equal(header, csvHeader) {
+		return nil, fmt.Errorf("unexpected CSV header %q, want %q", strings.Join(header, ","), strings.Join(csvHeader, ","))
+	}
+
+	return func() (models.User, int, error) {
+		record, err := cr.Read()
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return models.User{}, parseErr.StartLine, &RowError{Row: parseErr.StartLine, Err: parseErr.Err}
+		}
+		if err != nil {
+			return models.User{}, 0, err
+		}
+		row, _ := cr.FieldPos(0)
+
+		user, err := parseCSVUser(record)
+		if err != nil {
+			return models.User{}, row, &RowError{Row: row, Err: err}
+		}
+		return user, row, nil
+	}, nil
+}
+
+// parseCSVUser converts a record in csvHeader's column order to a validated user
+func parseCSVUser(record []string) (models.User, error) {
+	id, err := strconv.Atoi(record[0])
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid id %q", record[0])
+	}
+	userUUID, err := uuid.Parse(record[1])
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid uuid %q: %w", record[1], err)
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, record[5])
+	if err != nil {
+		return models.User{}, fmt.Errorf("invalid created_at %q: %w", record[5], err)
+	}
+	return validImport(models.User{
+		ID: id, UUID: userUUID, Email: record[2], Name: record[3], Role: models.Role(record[4]), CreatedAt: createdAt,
+	})
+}
+
+// maxImportLine is the longest JSON Lines record ImportUsers accepts
+const maxImportLine = 1 << 20
+
+// jsonlRows is csvRows for JSON Lines, skipping blank lines
+func jsonlRows(rd io.Reader) func() (models.User, int, error) {
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxImportLine)
+	line := 0
+
+	return func() (models.User, int, error) {
+		for scanner.Scan() {
+			line++
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			var user models.User
+			if err := json.Unmarshal(scanner.Bytes(), &user); err != nil {
+				return models.User{}, line, &RowError{Row: line, Err: fmt.Errorf("invalid JSON: %w", err)}
+			}
+			user, err := validImport(user)
+			if err != nil {
+				return models.User{}, line, &RowError{Row: line, Err: err}
+			}
+			return user, line, nil
+		}
+		if err := scanner.Err(); err != nil {
+			return models.User{}, line + 1, err
+		}
+		return models.User{}, 0, io.EOF
+	}
+}
+
+// validImport returns user normalized, or why it can't be imported: the
+// fields Create validates, plus the ID, UUID, and creation time an export
+// always carries
+func validImport(user models.User) (models.User, error) {
+	switch {
+	case user.ID <= 0:
+		return models.User{}, fmt.Errorf("invalid id %d", user.ID)
+	case user.UUID == uuid.Nil:
+		return models.User{}, errors.New("missing uuid")
+	case user.CreatedAt.IsZero():
+		return models.User{}, errors.New("missing created_at")
+	}
+	in, err := validated(CreateUserInput{Email: user.Email, Name: user.Name, Role: user.Role})
+	if err != nil {
+		return models.User{}, err
+	}
+	user.Email, user.Name, user.Role = in.Email, in.Name, in.Role
+	return user, nil
+}
@@ -0,0 +1,246 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"testcontainers-demo/models"
+)
+
+// wipeUsers empties the users table and its outbox, restarting the IDs
+func wipeUsers(ctx context.Context, t *testing.T, db *sql.DB) {
+	t.Helper()
+	if _, err := db.ExecContext(ctx, "TRUNCATE users, user_events RESTART IDENTITY"); err != nil {
+		t.Fatalf("Failed to wipe users: %v", err)
+	}
+}
+
+// assertSameUsers fails unless got and want hold the same users, comparing
+// creation times as instants
+func assertSameUsers(t *testing.T, got, want []models.User) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d users, got: %d", len(want), len(got))
+	}
+	for i := range want {
+		g, w := got[i], want[i]
+		if !g.CreatedAt.Equal(w.CreatedAt) {
+			t.Errorf("User %d: expected created_at %s, got: %s", w.ID, w.CreatedAt, g.CreatedAt)
+		}
+		g.CreatedAt, w.CreatedAt = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(g, w) {
+			t.Errorf("Expected user %+v, got: %+v", w, g)
+		}
+	}
+}
+
+// TestExportImport tests that exporting, wiping the table, and importing
+// gives back the same users in either format, and what each mode does with
+// users that are already there
+func TestExportImport(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+
+	for _, format := range []Format{FormatCSV, FormatJSONL} {
+		t.Run(string(format), func(t *testing.T) {
+			t.Parallel()
+			db := testContainer.CreateTestDatabase(ctx, t)
+			repo := NewUserRepository(db)
+			t.Cleanup(func() { repo.Close() })
+
+			// Next to the seed users, a name CSV has to quote
+			if _, err := repo.CreateWithRole(ctx, "quoted@example.com", "O'Brien, \"Pat\"\nThe Second", models.RoleGuest); err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
+			want, err := repo.List(ctx)
+			if err != nil {
+				t.Fatalf("Failed to list users: %v", err)
+			}
+
+			var exported bytes.Buffer
+			if err := repo.ExportUsers(ctx, &exported, format); err != nil {
+				t.Fatalf("Failed to export: %v", err)
+			}
+			wipeUsers(ctx, t, db)
+
+			summary, err := repo.ImportUsers(ctx, bytes.NewReader(exported.Bytes()), format, ImportOptions{})
+			if err != nil {
+				t.Fatalf("Failed to import: %v", err)
+			}
+			if summary.Inserted != len(want) || summary.Skipped != 0 || len(summary.Failed) != 0 {
+				t.Errorf("Expected %d users inserted, got: %+v", len(want), summary)
+			}
+			got, err := repo.List(ctx)
+			if err != nil {
+				t.Fatalf("Failed to list users: %v", err)
+			}
+			assertSameUsers(t, got, want)
+
+			// The sequence continues after the imported IDs
+			created, err := repo.Create(ctx, "after-import@example.com", "After Import")
+			if err != nil {
+				t.Fatalf("Failed to create user after import: %v", err)
+			}
+			if last := want[len(want)-1].ID; created.ID != last+1 {
+				t.Errorf("Expected the next ID to be %d, got: %d", last+1, created.ID)
+			}
+
+			t.Run("Skip Duplicates", func(t *testing.T) {
+				summary, err := repo.ImportUsers(ctx, bytes.NewReader(exported.Bytes()), format, ImportOptions{Mode: ImportSkipDuplicates})
+				if err != nil {
+					t.Fatalf("Failed to import: %v", err)
+				}
+				if summary.Inserted != 0 || summary.Skipped != len(want) || len(summary.Failed) != 0 {
+					t.Errorf("Expected all %d users skipped, got: %+v", len(want), summary)
+				}
+			})
+
+			t.Run("Fail Fast", func(t *testing.T) {
+				summary, err := repo.ImportUsers(ctx, bytes.NewReader(exported.Bytes()), format, ImportOptions{Mode: ImportFailFast})
+				if !errors.Is(err, ErrDuplicateUser) {
+					t.Fatalf("Expected ErrDuplicateUser, got: %v", err)
+				}
+				// The first user is on line 2 of a CSV, after the header
+				row := 1
+				if format == FormatCSV {
+					row = 2
+				}
+				if summary.Inserted != 0 || len(summary.Failed) != 1 || summary.Failed[0].Row != row {
+					t.Errorf("Expected to stop at row %d, got: %+v", row, summary)
+				}
+			})
+
+			t.Run("Upsert", func(t *testing.T) {
+				if err := repo.Update(ctx, want[0].ID, want[0].Email, "Renamed Before Upsert"); err != nil {
+					t.Fatalf("Failed to update user: %v", err)
+				}
+				summary, err := repo.ImportUsers(ctx, bytes.NewReader(exported.Bytes()), format, ImportOptions{Mode: ImportUpsert})
+				if err != nil {
+					t.Fatalf("Failed to import: %v", err)
+				}
+				if summary.Inserted != 0 || summary.Updated != len(want) || len(summary.Failed) != 0 {
+					t.Errorf("Expected all %d users updated, got: %+v", len(want), summary)
+				}
+				restored, err := repo.GetByID(ctx, want[0].ID)
+				if err != nil {
+					t.Fatalf("Failed to get user: %v", err)
+				}
+				if restored.Name != want[0].Name {
+					t.Errorf("Expected the name to be restored to %q, got: %q", want[0].Name, restored.Name)
+				}
+			})
+		})
+	}
+}
+
+// TestImportMalformed tests that the summary names each bad row by the line
+// it starts on, and that the good rows around it are still imported
+func TestImportMalformed(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+		failed []int
+	}{
+		{
+			name:   "CSV",
+			format: FormatCSV,
+			input: strings.Join([]string{
+				"id,uuid,email,name,role,created_at",
+				"1,0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c01,first@example.com,First,member,2024-03-01T10:00:00.123456Z",
+				`2,0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c02,second@example.com,"Multi`,
+				`Line",admin,2024-03-02T10:00:00Z`,
+				"3,0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c03,not-an-email,Bad Email,member,2024-03-03T10:00:00Z",
+				"4,0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c04,short@example.com,Too Few Columns",
+				"5,0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c05,late@example.com,Bad Time,member,yesterday",
+				"6,0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c06,last@example.com,Last,guest,2024-03-06T10:00:00Z",
+			}, "\n") + "\n",
+			failed: []int{5, 6, 7},
+		},
+		{
+			name:   "JSONL",
+			format: FormatJSONL,
+			input: strings.Join([]string{
+				`{"id":1,"uuid":"0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c01","email":"first@example.com","name":"First","role":"member","created_at":"2024-03-01T10:00:00.123456Z"}`,
+				`{"id":2,"uuid":"0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c02","email":"second@example.com","name":"Second","role":"admin","created_at":"2024-03-02T10:00:00Z"}`,
+				`{"id":3,"uuid":"0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c03","email":"third@example.com",`,
+				``,
+				`{"id":4,"uuid":"0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c04","email":"fourth@example.com","name":"Bad Role","role":"owner","created_at":"2024-03-04T10:00:00Z"}`,
+				`{"id":6,"uuid":"0b9f6cbe-4a43-4c1b-8a3f-4a2b0f3f1c06","email":"last@example.com","name":"Last","role":"guest","created_at":"2024-03-06T10:00:00Z"}`,
+			}, "\n"),
+			failed: []int{3, 5},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db := testContainer.CreateTestDatabase(ctx, t)
+			repo := NewUserRepository(db)
+			t.Cleanup(func() { repo.Close() })
+			wipeUsers(ctx, t, db)
+
+			summary, err := repo.ImportUsers(ctx, strings.NewReader(tc.input), tc.format, ImportOptions{})
+			if err != nil {
+				t.Fatalf("Failed to import: %v", err)
+			}
+			var failed []int
+			for _, f := range summary.Failed {
+				failed = append(failed, f.Row)
+			}
+			if !reflect.DeepEqual(failed, tc.failed) {
+				t.Errorf("Expected rows %v to fail, got: %+v", tc.failed, summary.Failed)
+			}
+			if summary.Inserted != 3 {
+				t.Errorf("Expected 3 users inserted, got: %d", summary.Inserted)
+			}
+
+			users, err := repo.List(ctx)
+			if err != nil {
+				t.Fatalf("Failed to list users: %v", err)
+			}
+			var ids []int
+			for _, u := range users {
+				ids = append(ids, u.ID)
+			}
+			if want := []int{1, 2, 6}; !reflect.DeepEqual(ids, want) {
+				t.Errorf("Expected users %v, got: %v", want, ids)
+			}
+			first := time.Date(2024, 3, 1, 10, 0, 0, 123456000, time.UTC)
+			if len(users) > 0 && !users[0].CreatedAt.Equal(first) {
+				t.Errorf("Expected created_at %s, got: %s", first, users[0].CreatedAt)
+			}
+
+			// Fail-fast stops at the first bad row, leaving the earlier ones in
+			wipeUsers(ctx, t, db)
+			summary, err = repo.ImportUsers(ctx, strings.NewReader(tc.input), tc.format, ImportOptions{Mode: ImportFailFast})
+			var rowErr *RowError
+			if !errors.As(err, &rowErr) || rowErr.Row != tc.failed[0] {
+				t.Fatalf("Expected a RowError for row %d, got: %v", tc.failed[0], err)
+			}
+			if summary.Inserted != 2 {
+				t.Errorf("Expected 2 users inserted before the bad row, got: %d", summary.Inserted)
+			}
+		})
+	}
+
+	t.Run("Wrong Header", func(t *testing.T) {
+		t.Parallel()
+		repo := NewUserRepository(testDB)
+		t.Cleanup(func() { repo.Close() })
+		_, err := repo.ImportUsers(ctx, strings.NewReader("email,name\na@example.com,A\n"), FormatCSV, ImportOptions{})
+		if err == nil || !strings.Contains(err.Error(), "unexpected CSV header") {
+			t.Errorf("Expected the header to be rejected, got: %v", err)
+		}
+	})
+}
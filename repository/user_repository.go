@@ -5,325 +5,1512 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"testcontainers-demo/models"
+	"testcontainers-demo/notifications"
+	"testcontainers-demo/pagination"
 
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/sync/singleflight"
 )
 
-// UserRepository handles database operations for users
+// DBTX is the subset of *sql.DB and *sql.Tx used by the repository, so the
+// same methods can run on a connection pool or inside a transaction
+type DBTX interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// UserRepository handles database operations for users. Every create,
+// update, and delete also records a models.UserEvent in the user_events
+// outbox, in the same statement.
 type UserRepository struct {
-	db *sql.DB
+	db         DBTX
+	retry      retryPolicy
+	hooks      []Hook
+	bcryptCost int
+	dialect    Dialect
+	mailer     Mailer
+	clock      Clock
+
+	// tenant scopes every query; see ForTenant
+	tenant string
+
+	// idempotencyTTL is how long PurgeIdempotencyKeys keeps a key
+	idempotencyTTL time.Duration
+
+	// explain enables Explain; see WithExplain
+	explain bool
+
+	// pool is the *sql.DB under db, which transactions begin on; nil in a
+	// copy made by WithTx
+	pool *sql.DB
+
+	// stmts caches prepared statements when db is a *sql.DB
+	stmts      *preparedDB
+	unprepared bool
+
+	// replica serves the read-only methods when set, falling back to db;
+	// replicaStmts are the statements prepared on it
+	replica      *fallbackDB
+	replicaStmts *preparedDB
 }
 
+// Option configures a UserRepository
+type Option func(*UserRepository)
+
+// WithRetry sets how often write methods are attempted when Postgres reports
+// a transient error, and the delay before the first retry; later retries
+// back off exponentially with jitter. maxAttempts of 1 disables retrying.
+func WithRetry(maxAttempts int, baseDelay time.Duration) Option {
+	return func(r *UserRepository) {
+		r.retry = retryPolicy{maxAttempts: maxAttempts, baseDelay: baseDelay}
+	}
+}
 
+// WithoutPreparedStatements sends every query as plain text instead of
+// preparing it once, e.g. behind a pooler that doesn't support prepared
+// statements
+func WithoutPreparedStatements() Option {
+	return func(r *UserRepository) {
+		r.unprepared = true
+	}
+}
 
-// NewUserRepository creates a new user repository
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+// NewUserRepository creates a new user repository. When db is a *sql.DB each
+// query is prepared on first use and reused; call Close to release the
+// statements. A query on a pooled connection that turns out dead, as all
+// are after Postgres restarts, runs once more on a live one. The repository
+// is bound to DefaultTenant; ForTenant binds a copy to another.
+func NewUserRepository(db DBTX, opts ...Option) *UserRepository {
+	r := &UserRepository{db: db, retry: defaultRetryPolicy, bcryptCost: bcrypt.DefaultCost, clock: systemClock{}, tenant: DefaultTenant, idempotencyTTL: DefaultIdempotencyTTL}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if pool, ok := db.(*sql.DB); ok {
+		r.pool = pool
+		var conns DBTX = pool
+		if !r.unprepared {
+			r.stmts = newPreparedDB(pool)
+			conns = r.stmts
+		}
+		r.db = &reconnectDB{DBTX: conns, pool: pool}
+	}
+	return r
+}
+
+// WithTx returns a copy of the repository whose queries run inside tx.
+// Writes are not retried there: after a serialization failure the whole
+// transaction has to be retried, not the single statement.
+func (r *UserRepository) WithTx(tx *sql.Tx) *UserRepository {
+	txRepo := &UserRepository{db: tx, retry: retryPolicy{maxAttempts: 1}, hooks: r.hooks, bcryptCost: r.bcryptCost, dialect: r.dialect, mailer: r.mailer, clock: r.clock, tenant: r.tenant, idempotencyTTL: r.idempotencyTTL, explain: r.explain}
+	if r.stmts != nil {
+		txRepo.db = &preparedTx{tx: tx, cache: r.stmts}
+	}
+	return txRepo
+}
+
+// Close releases the cached prepared statements. The repository stays usable
+// and prepares statements again on demand. Copies made by WithTx share the
+// parent's statements and have nothing to close.
+func (r *UserRepository) Close() error {
+	var errs []error
+	for _, stmts := range []*preparedDB{r.stmts, r.replicaStmts} {
+		if stmts != nil {
+			errs = append(errs, stmts.close())
+		}
+	}
+	return errors.Join(errs...)
 }
 
 // GetByID retrieves a user by their ID
-func (r *UserRepository) GetByID(id int) (*models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE id = $1"
+func (r *UserRepository) GetByID(ctx context.Context, id int) (_ *models.User, err error) {
+	const op = "UserRepository.GetByID"
+	key := fmt.Sprintf("id=%d", id)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectUserByID, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	var user models.User
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	user, err := r.getByID(ctx, id)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+
+	return user, nil
+}
 
+// selectUserByID is the query behind GetByID and GetByIDCached; $2 is the tenant
+const selectUserByID = "SELECT " + userDetailColumns + " FROM users WHERE id = $1 AND tenant_id = $2"
+
+// getByID is GetByID without hooks or a RepoError, returning
+// ErrUserNotFound bare; GetByIDCached reads through it too
+func (r *UserRepository) getByID(ctx context.Context, id int) (*models.User, error) {
+	user, err := scanUserDetail(r.reads().QueryRowContext(ctx, selectUserByID, id, r.tenant))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	return user, nil
+}
+
+// GetByUUID retrieves a user by their public UUID
+func (r *UserRepository) GetByUUID(ctx context.Context, id uuid.UUID) (_ *models.User, err error) {
+	const op = "UserRepository.GetByUUID"
+	key := "uuid=" + id.String()
+	query := "SELECT " + userDetailColumns + " FROM users WHERE uuid = $1 AND tenant_id = $2"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	user, err := scanUserDetail(r.reads().QueryRowContext(ctx, query, id, r.tenant))
+	if err == sql.ErrNoRows {
+		return nil, newRepoError(op, key, ErrUserNotFound)
+	}
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to get user: %w", err))
+	}
 
-	return &user, nil
+	return user, nil
 }
 
 // GetByEmail retrieves a user by their email
-func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE email = $1"
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (_ *models.User, err error) {
+	const op = "UserRepository.GetByEmail"
+	email = NormalizeEmail(email)
+	key := "email=" + email
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectUserByEmail}, email)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	user, err := r.getByEmail(ctx, email)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
 
-	var user models.User
-	err := r.db.QueryRow(query, email).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	return user, nil
+}
 
+// getByEmail is GetByEmail for an email already normalized, without hooks
+// or a RepoError; GetByEmailCached reads through it too
+func (r *UserRepository) getByEmail(ctx context.Context, email string) (*models.User, error) {
+	user, err := scanUserDetail(r.reads().QueryRowContext(ctx, selectUserByEmail, email, r.tenant))
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+		return nil, ErrUserNotFound
 	}
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
+	return user, nil
+}
+
+// Create inserts a new user with the default role (member)
+func (r *UserRepository) Create(ctx context.Context, email, name string) (*models.User, error) {
+	return r.create(ctx, "UserRepository.Create", CreateUserInput{Email: email, Name: name}, "")
+}
+
+// CreateWithRole inserts a new user with the given role
+func (r *UserRepository) CreateWithRole(ctx context.Context, email, name string, role models.Role) (*models.User, error) {
+	return r.create(ctx, "UserRepository.CreateWithRole", CreateUserInput{Email: email, Name: name, Role: role}, "")
+}
+
+// create validates and inserts in with passwordHash, if any, reporting errors
+// under op. The hash is left out of the hook arguments.
+func (r *UserRepository) create(ctx context.Context, op string, in CreateUserInput, passwordHash string) (_ *models.User, err error) {
+	key := "email=" + in.Email
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: r.insertStatement()}, in.Email, in.Name, in.Role)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if in, err = validated(in); err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+	user, err := r.insert(ctx, in, passwordHash)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
+	}
+
+	return user, nil
+}
 
-	return &user, nil
+// insertUser inserts user $1 to $3, with password hash $4, into tenant $5,
+// recording a user.created event, and returns the new row
+var insertUser = `
+	WITH u AS (
+		INSERT INTO users (email, name, role, password_hash, tenant_id)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING ` + userColumns + `
+	), e AS (` + insertUserEvent(models.EventUserCreated) + `)
+	SELECT ` + userColumns + ` FROM u
+`
+
+// insertStatement is the statement insert runs in the repository's dialect
+func (r *UserRepository) insertStatement() string {
+	if r.dialect == DialectSQLite {
+		return sqliteCreateUser
+	}
+	return insertUser
 }
 
-// Create inserts a new user
-func (r *UserRepository) Create(email, name string) (*models.User, error) {
+// insert creates the validated in with passwordHash, if any, retrying
+// transient errors. An email already taken returns ErrDuplicateEmail bare.
+func (r *UserRepository) insert(ctx context.Context, in CreateUserInput, passwordHash string) (*models.User, error) {
+	query := r.insertStatement()
+	args := []interface{}{in.Email, in.Name, in.Role, nullString(passwordHash), r.tenant}
+	if r.dialect == DialectSQLite {
+		args = append(args, uuid.New())
+	}
+
+	var user *models.User
+	err := r.withRetry(ctx, func() (err error) {
+		user, err = scanUser(r.db.QueryRowContext(ctx, query, args...))
+		return err
+	})
+
+	if isUniqueViolation(err) {
+		return nil, ErrDuplicateEmail
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	user.PasswordHash = passwordHash
+	return user, nil
+}
+
+// MaxBatchSize is the most users CreateBatch inserts at once, keeping its
+// statement well under Postgres's limit of 65535 parameters
+const MaxBatchSize = 1000
+
+// CreateBatch inserts users in a single statement and returns them in input
+// order. Either every user is created or, on any invalid input or duplicate
+// email, none is.
+func (r *UserRepository) CreateBatch(ctx context.Context, users []CreateUserInput) (_ []models.User, err error) {
+	const op = "UserRepository.CreateBatch"
+	key := fmt.Sprintf("count=%d", len(users))
+	if len(users) == 0 {
+		return nil, nil
+	}
+	if len(users) > MaxBatchSize {
+		return nil, newRepoError(op, key, fmt.Errorf("batch of %d users exceeds %d", len(users), MaxBatchSize))
+	}
+
+	// Sequence values are assigned in VALUES order, so ordering by id
+	// restores it. The tenant is the last argument, shared by every row.
+	values := make([]string, len(users))
+	for i := range users {
+		values[i] = fmt.Sprintf("($%d, $%d, $%d, $%d)", 3*i+1, 3*i+2, 3*i+3, 3*len(users)+1)
+	}
 	query := `
-		INSERT INTO users (email, name)
-		VALUES ($1, $2)
-		RETURNING id, email, name, created_at
+		WITH u AS (
+			INSERT INTO users (email, name, role, tenant_id)
+			VALUES ` + strings.Join(values, ", ") + `
+			RETURNING ` + userColumns + `
+		), e AS (` + insertUserEvent(models.EventUserCreated) + `)
+		SELECT ` + userColumns + ` FROM u ORDER BY id
 	`
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, len(users))
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	var user models.User
-	err := r.db.QueryRow(query, email, name).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	args := make([]interface{}, 0, 3*len(users)+1)
+	for i, in := range users {
+		if in, err = validated(in); err != nil {
+			return nil, newRepoError(op, fmt.Sprintf("index=%d", i), err)
+		}
+		args = append(args, in.Email, in.Name, in.Role)
+	}
+	args = append(args, r.tenant)
+
+	var created []models.User
+	err = r.withRetry(ctx, func() error {
+		rows, err := r.db.QueryContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+		created, err = scanUsers(rows)
+		return err
+	})
 
+	if isUniqueViolation(err) {
+		return nil, newRepoError(op, key, ErrDuplicateEmail)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return nil, newRepoError(op, key, fmt.Errorf("failed to create users: %w", err))
 	}
 
-	return &user, nil
+	return created, nil
 }
 
-// Update modifies an existing user
-func (r *UserRepository) Update(id int, email, name string) error {
-	query := "UPDATE users SET email = $1, name = $2 WHERE id = $3"
+// Update modifies an existing user's email and name, keeping their role
+func (r *UserRepository) Update(ctx context.Context, id int, email, name string) error {
+	return r.update(ctx, "UserRepository.Update", id, CreateUserInput{Email: email, Name: name}, false)
+}
+
+// UpdateWithRole modifies an existing user's email, name, and role
+func (r *UserRepository) UpdateWithRole(ctx context.Context, id int, email, name string, role models.Role) error {
+	return r.update(ctx, "UserRepository.UpdateWithRole", id, CreateUserInput{Email: email, Name: name, Role: role}, true)
+}
 
-	result, err := r.db.Exec(query, email, name, id)
+// update validates in and writes it to user id, including the role if setRole
+func (r *UserRepository) update(ctx context.Context, op string, id int, in CreateUserInput, setRole bool) (err error) {
+	key := fmt.Sprintf("id=%d", id)
+	set := "UPDATE users SET email = $1, name = $2 WHERE id = $3 AND tenant_id = $4"
+	if setRole {
+		set = "UPDATE users SET email = $1, name = $2, role = $5 WHERE id = $3 AND tenant_id = $4"
+	}
+	// RowsAffected counts the events inserted, one per updated user
+	query := "WITH u AS (" + set + " RETURNING " + userColumns + ") " +
+		insertUserEvent(models.EventUserUpdated)
+	if r.dialect == DialectSQLite {
+		query = sqliteUpdateUser
+		if setRole {
+			query = sqliteUpdateUserWithRole
+		}
+	}
+	o := &Op{Name: op, Statement: query, UserID: id}
+	ctx, finish := observe(ctx, r.hooks, o, id, in.Email, in.Name, in.Role)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if in, err = validated(in); err != nil {
+		return newRepoError(op, key, err)
+	}
+	args := []interface{}{in.Email, in.Name, id, r.tenant}
+	if setRole {
+		args = append(args, in.Role)
+	}
+
+	var result sql.Result
+	err = r.withRetry(ctx, func() (err error) {
+		result, err = r.db.ExecContext(ctx, query, args...)
+		return err
+	})
+	if isUniqueViolation(err) {
+		return newRepoError(op, key, ErrDuplicateEmail)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to update user: %w", err)
+		return newRepoError(op, key, fmt.Errorf("failed to update user: %w", err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return newRepoError(op, key, fmt.Errorf("failed to get rows affected: %w", err))
 	}
+	o.Rows = rowsAffected
 
 	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return newRepoError(op, key, ErrUserNotFound)
 	}
 
 	return nil
 }
 
-// Delete removes a user
-func (r *UserRepository) Delete(id int) error {
-	query := "DELETE FROM users WHERE id = $1"
+// UpdateWithVersion writes user's email, name, and role, but only if the
+// row is still at user.Version, as read by GetByID, GetByUUID, GetByEmail,
+// or GetByIDCached, and then moves user.Version on to match. If another
+// write got there first it returns ErrVersionConflict and changes nothing;
+// read the user again and retry.
+func (r *UserRepository) UpdateWithVersion(ctx context.Context, user *models.User) (err error) {
+	const op = "UserRepository.UpdateWithVersion"
+	key := fmt.Sprintf("id=%d", user.ID)
+	// The users_bump_version trigger increments version
+	query := "WITH u AS (UPDATE users SET email = $1, name = $2, role = $3 WHERE id = $4 AND version = $5 AND tenant_id = $6 " +
+		"RETURNING " + userColumns + ") " + insertUserEvent(models.EventUserUpdated)
+	if r.dialect == DialectSQLite {
+		query = sqliteUpdateUserWithVersion
+	}
+	o := &Op{Name: op, Statement: query, UserID: user.ID}
+	ctx, finish := observe(ctx, r.hooks, o, user.ID, user.Email, user.Name, user.Role, user.Version)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	result, err := r.db.Exec(query, id)
+	in, err := validated(CreateUserInput{Email: user.Email, Name: user.Name, Role: user.Role})
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
+
+	var result sql.Result
+	err = r.withRetry(ctx, func() (err error) {
+		result, err = r.db.ExecContext(ctx, query, in.Email, in.Name, in.Role, user.ID, user.Version, r.tenant)
+		return err
+	})
+	if isUniqueViolation(err) {
+		return newRepoError(op, key, ErrDuplicateEmail)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return newRepoError(op, key, fmt.Errorf("failed to update user: %w", err))
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to get rows affected: %w", err)
+		return newRepoError(op, key, fmt.Errorf("failed to get rows affected: %w", err))
 	}
+	o.Rows = rowsAffected
+	if rowsAffected == 0 {
+		_, err := missedVersion(ctx, r.db, r.tenant, user.ID)
+		return newRepoError(op, key, err)
+	}
+	user.Version++
+	return nil
+}
+
+// missedVersion tells why a versioned update of id matched no row:
+// ErrVersionConflict if tenant has the user, with its current email, or
+// ErrUserNotFound if not
+func missedVersion(ctx context.Context, db DBTX, tenant string, id int) (email string, err error) {
+	err = db.QueryRowContext(ctx, "SELECT email FROM users WHERE id = $1 AND tenant_id = $2", id, tenant).Scan(&email)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to check user: %w", err)
+	}
+	return email, ErrVersionConflict
+}
+
+// The updates behind UpdateCached and UpdateWithVersionCached, which also
+// return the email the update replaced, for its index key. old locks the
+// row first, so that is the email of the row actually updated; FOR UPDATE
+// waits out a concurrent write and then re-checks the version.
+var (
+	// $1 and $2 are the email and name, $3 the ID, $4 the tenant
+	updateUserReturningOld = `
+		WITH old AS (
+			SELECT id, email FROM users WHERE id = $3 AND tenant_id = $4 FOR UPDATE
+		), u AS (
+			UPDATE users SET email = $1, name = $2 FROM old WHERE users.id = old.id
+			RETURNING ` + prefixedUserColumns("users") + `
+		), e AS (` + insertUserEvent(models.EventUserUpdated) + `)
+		SELECT ` + prefixedUserColumns("u") + `, old.email FROM u JOIN old ON old.id = u.id
+	`
+	// $1 to $3 are the email, name, and role, $4 the ID, $5 the version it
+	// must be at, $6 the tenant
+	updateVersionReturningOld = `
+		WITH old AS (
+			SELECT id, email FROM users WHERE id = $4 AND version = $5 AND tenant_id = $6 FOR UPDATE
+		), u AS (
+			UPDATE users SET email = $1, name = $2, role = $3 FROM old WHERE users.id = old.id
+			RETURNING ` + prefixedUserColumns("users") + `
+		), e AS (` + insertUserEvent(models.EventUserUpdated) + `)
+		SELECT ` + prefixedUserColumns("u") + `, old.email FROM u JOIN old ON old.id = u.id
+	`
+)
+
+// updateReturningOld writes the validated in's email and name to user id,
+// keeping their role, and returns the updated row and the email it
+// replaced. ErrUserNotFound and ErrDuplicateEmail are returned bare.
+func (r *UserRepository) updateReturningOld(ctx context.Context, id int, in CreateUserInput) (user models.User, oldEmail string, err error) {
+	err = r.withRetry(ctx, func() error {
+		return r.db.QueryRowContext(ctx, updateUserReturningOld, in.Email, in.Name, id, r.tenant).
+			Scan(append(userFields(&user), &oldEmail)...)
+	})
+	return user, oldEmail, updateErr(err)
+}
+
+// updateVersionReturningOld is updateReturningOld writing the role too, as
+// UpdateWithVersion does, only while user id is at version. If the version
+// moved on it returns ErrVersionConflict with the user's current email.
+func (r *UserRepository) updateVersionReturningOld(ctx context.Context, id, version int, in CreateUserInput) (user models.User, oldEmail string, err error) {
+	err = r.withRetry(ctx, func() error {
+		return r.db.QueryRowContext(ctx, updateVersionReturningOld, in.Email, in.Name, in.Role, id, version, r.tenant).
+			Scan(append(userFields(&user), &oldEmail)...)
+	})
+	if err == sql.ErrNoRows {
+		email, err := missedVersion(ctx, r.db, r.tenant, id)
+		return user, email, err
+	}
+	return user, oldEmail, updateErr(err)
+}
+
+// updateErr maps the error of an update returning its row: no row is
+// ErrUserNotFound
+func updateErr(err error) error {
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if isUniqueViolation(err) {
+		return ErrDuplicateEmail
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	return nil
+}
 
+// updateUserRole sets user $2 of tenant $3 to role $1, recording a
+// user.updated event; RowsAffected counts the event
+var updateUserRole = "WITH u AS (UPDATE users SET role = $1 WHERE id = $2 AND tenant_id = $3 RETURNING " + userColumns + ") " +
+	insertUserEvent(models.EventUserUpdated)
+
+// updateRole sets user id's role, already validated, and returns how many
+// users it updated; none is ErrUserNotFound
+func (r *UserRepository) updateRole(ctx context.Context, id int, role models.Role) (int64, error) {
+	var result sql.Result
+	err := r.withRetry(ctx, func() (err error) {
+		result, err = r.db.ExecContext(ctx, updateUserRole, role, id, r.tenant)
+		return err
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to update role: %w", err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get rows affected: %w", err)
+	}
 	if rowsAffected == 0 {
-		return fmt.Errorf("user not found")
+		return 0, ErrUserNotFound
+	}
+	return rowsAffected, nil
+}
+
+// Delete removes a user. It returns ErrHasOrders while the user has orders.
+func (r *UserRepository) Delete(ctx context.Context, id int) (err error) {
+	const op = "UserRepository.Delete"
+	key := fmt.Sprintf("id=%d", id)
+	query := "WITH u AS (DELETE FROM users WHERE id = $1 AND tenant_id = $2 RETURNING " + userColumns + ") " +
+		insertUserEvent(models.EventUserDeleted)
+	if r.dialect == DialectSQLite {
+		query = sqliteDeleteUser
+	}
+	o := &Op{Name: op, Statement: query, UserID: id}
+	ctx, finish := observe(ctx, r.hooks, o, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	var result sql.Result
+	err = r.withRetry(ctx, func() (err error) {
+		result, err = r.db.ExecContext(ctx, query, id, r.tenant)
+		return err
+	})
+	if isForeignKeyViolation(err) {
+		return newRepoError(op, key, ErrHasOrders)
+	}
+	if err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to delete user: %w", err))
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to get rows affected: %w", err))
+	}
+	o.Rows = rowsAffected
+
+	if rowsAffected == 0 {
+		return newRepoError(op, key, ErrUserNotFound)
 	}
 
 	return nil
 }
 
+// deleteUserReturning deletes user $1 of tenant $2 with a user.deleted
+// event and returns the deleted row
+var deleteUserReturning = `
+	WITH u AS (
+		DELETE FROM users WHERE id = $1 AND tenant_id = $2
+		RETURNING ` + userColumns + `
+	), e AS (` + insertUserEvent(models.EventUserDeleted) + `)
+	SELECT ` + userColumns + ` FROM u
+`
+
+// deleteReturning deletes user id and returns the deleted row.
+// ErrUserNotFound and ErrHasOrders are returned bare.
+func (r *UserRepository) deleteReturning(ctx context.Context, id int) (*models.User, error) {
+	var user *models.User
+	err := r.withRetry(ctx, func() (err error) {
+		user, err = scanUser(r.db.QueryRowContext(ctx, deleteUserReturning, id, r.tenant))
+		return err
+	})
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if isForeignKeyViolation(err) {
+		return nil, ErrHasOrders
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete user: %w", err)
+	}
+	return user, nil
+}
+
+// selectAllUsers is the query behind List; $1 is the tenant
+const selectAllUsers = "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 ORDER BY id"
+
 // List retrieves all users
-func (r *UserRepository) List() ([]models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users ORDER BY id"
+func (r *UserRepository) List(ctx context.Context) (_ []models.User, err error) {
+	const op = "UserRepository.List"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectAllUsers})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	rows, err := r.db.Query(query)
+	rows, err := r.reads().QueryContext(ctx, selectAllUsers, r.tenant)
+	if err != nil {
+		return nil, newRepoError(op, "", fmt.Errorf("failed to list users: %w", err))
+	}
+	users, err := scanUsers(rows)
 	if err != nil {
-		return nil, fmt.Errorf("failed to list users: %w", err)
+		return nil, newRepoError(op, "", err)
 	}
-	defer rows.Close()
 
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
-		}
-		users = append(users, user)
+	return users, nil
+}
+
+// OrderField is a column ListOrdered can sort users by
+type OrderField string
+
+// The fields ListOrdered accepts
+const (
+	OrderByID        OrderField = "id"
+	OrderByEmail     OrderField = "email"
+	OrderByName      OrderField = "name"
+	OrderByCreatedAt OrderField = "created_at"
+)
+
+// orderColumns is the allowlist of OrderField values; only these are ever
+// written into a query
+var orderColumns = map[OrderField]string{
+	OrderByID:        "id",
+	OrderByEmail:     "email",
+	OrderByName:      "name",
+	OrderByCreatedAt: "created_at",
+}
+
+// Direction is the sort direction for ListOrdered
+type Direction string
+
+// The directions ListOrdered accepts
+const (
+	Ascending  Direction = "ASC"
+	Descending Direction = "DESC"
+)
+
+// ListOrdered retrieves all users sorted by orderBy in direction, with ties
+// broken by ID in the same direction so the order is deterministic. An
+// orderBy or direction other than the constants above returns
+// ErrInvalidOrder without querying.
+func (r *UserRepository) ListOrdered(ctx context.Context, orderBy OrderField, direction Direction) (_ []models.User, err error) {
+	const op = "UserRepository.ListOrdered"
+	key := fmt.Sprintf("order=%s %s", orderBy, direction)
+	orderClause, err := buildOrderClause(orderBy, direction)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
 	}
+	query := "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 ORDER BY " + orderClause
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
+	rows, err := r.reads().QueryContext(ctx, query, r.tenant)
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to list users: %w", err))
+	}
+	users, err := scanUsers(rows)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
 	}
 
 	return users, nil
 }
 
-// FindByNamePattern finds users whose name matches a pattern
-func (r *UserRepository) FindByNamePattern(pattern string) ([]models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE name ILIKE $1 ORDER BY id"
+// buildOrderClause returns the ORDER BY clause sorting by orderBy in
+// direction, then by ID, or ErrInvalidOrder for either outside the allowlist
+func buildOrderClause(orderBy OrderField, direction Direction) (string, error) {
+	column, ok := orderColumns[orderBy]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown field %q", ErrInvalidOrder, orderBy)
+	}
+	if direction != Ascending && direction != Descending {
+		return "", fmt.Errorf("%w: unknown direction %q", ErrInvalidOrder, direction)
+	}
+	orderClause := column + " " + string(direction)
+	if column != "id" {
+		orderClause += ", id " + string(direction)
+	}
+	return orderClause, nil
+}
 
-	rows, err := r.db.Query(query, "%"+pattern+"%")
+// ListEach calls fn for every user, ordered by ID, without loading the whole
+// table into memory. It stops at the first error from fn, which it returns
+// unchanged unless closing the rows fails too, or when ctx is done; either
+// way the rows are closed and their connection returned to the pool.
+func (r *UserRepository) ListEach(ctx context.Context, fn func(models.User) error) (err error) {
+	const op = "UserRepository.ListEach"
+	query := "SELECT " + userColumns + " FROM users WHERE tenant_id = $1 ORDER BY id"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	rows, err := r.reads().QueryContext(ctx, query, r.tenant)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find users by pattern: %w", err)
+		return newRepoError(op, "", fmt.Errorf("failed to list users: %w", err))
 	}
-	defer rows.Close()
+	defer closeRows(rows, &err)
 
-	users := []models.User{} // Initialize empty slice instead of nil
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
+		if err := ctx.Err(); err != nil {
+			return newRepoError(op, "", err)
+		}
+
+		user, err := scanUser(rows)
 		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
+			return newRepoError(op, "", fmt.Errorf("failed to scan user: %w", err))
+		}
+		if err := fn(*user); err != nil {
+			return err
 		}
-		users = append(users, user)
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
+		return newRepoError(op, "", fmt.Errorf("error iterating users: %w", err))
+	}
+
+	return nil
+}
+
+// ListChan streams every user, ordered by ID, on a channel buffered to buf.
+// Both channels are closed when the stream ends; the error channel then
+// yields ListEach's error, if any. A consumer that stops reading early must
+// cancel ctx so the producing goroutine can exit.
+func (r *UserRepository) ListChan(ctx context.Context, buf int) (<-chan models.User, <-chan error) {
+	users := make(chan models.User, buf)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(errc)
+		defer close(users)
+
+		err := r.ListEach(ctx, func(user models.User) error {
+			select {
+			case users <- user:
+				return nil
+			case <-ctx.Done():
+				return newRepoError("UserRepository.ListChan", "", ctx.Err())
+			}
+		})
+		if err != nil {
+			errc <- err
+		}
+	}()
+
+	return users, errc
+}
+
+// ListPaginated retrieves a page of up to limit users ordered by ID, after
+// cursor: the NextCursor of the page before, or "" for the first page. A
+// limit of 0 means every user. A negative limit returns a ValidationError,
+// and a cursor ListPaginated didn't hand out an error wrapping
+// pagination.ErrInvalidCursor.
+func (r *UserRepository) ListPaginated(ctx context.Context, cursor string, limit int) (_ UserPage, err error) {
+	const op = "UserRepository.ListPaginated"
+	key := fmt.Sprintf("cursor=%s limit=%d", cursor, limit)
+	afterID, err := pagination.DecodeID(cursor)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	query, args := withLimit("SELECT "+userColumns+" FROM users WHERE id > $1 AND tenant_id = $2 ORDER BY id", []interface{}{afterID, r.tenant}, limit)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, args...)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := ValidateLimit(limit); err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+
+	rows, err := r.reads().QueryContext(ctx, query, args...)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, fmt.Errorf("failed to list users: %w", err))
+	}
+	users, err := scanUsers(rows)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	total, err := r.countTotal(ctx, "SELECT COUNT(*) FROM users WHERE tenant_id = $1", r.tenant)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+
+	return pagination.New(users, limit, total, IDCursor), nil
+}
+
+// selectUsersByNamePattern is the query behind FindByNamePattern; $1 is the
+// pattern wrapped in %, $2 the tenant
+const selectUsersByNamePattern = "SELECT " + userColumns + " FROM users WHERE name ILIKE $1 AND tenant_id = $2 ORDER BY id"
+
+// FindByNamePattern finds users whose name contains pattern, ignoring case.
+// pattern is a LIKE pattern: % matches any run of characters, _ any one
+// character, and a backslash escapes the next; pass it through EscapeLike
+// to search for text literally. A pattern that isn't valid UTF-8 or holds a
+// NUL byte can't occur in a name, so it finds nobody.
+func (r *UserRepository) FindByNamePattern(ctx context.Context, pattern string) (_ []models.User, err error) {
+	const op = "UserRepository.FindByNamePattern"
+	key := "pattern=" + pattern
+	query := selectUsersByNamePattern
+	if r.dialect == DialectSQLite {
+		query = sqliteFindByNamePattern
+	}
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, pattern)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	// Postgres would reject either as an invalid UTF-8 byte sequence
+	if !utf8.ValidString(pattern) || strings.ContainsRune(pattern, 0) {
+		return []models.User{}, nil
+	}
+
+	rows, err := r.reads().QueryContext(ctx, query, "%"+pattern+"%", r.tenant)
+	if err != nil {
+		return nil, newRepoError(op, key, fmt.Errorf("failed to find users by pattern: %w", err))
+	}
+	users, err := scanUsers(rows)
+	if err != nil {
+		return nil, newRepoError(op, key, err)
 	}
 
 	return users, nil
 }
 
+// EscapeLike escapes LIKE's wildcards and escape character in s, so
+// FindByNamePattern matches it literally
+func EscapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 // CountUsers returns total number of users
-func (r *UserRepository) CountUsers() (int, error) {
-	query := "SELECT COUNT(*) FROM users"
+func (r *UserRepository) CountUsers(ctx context.Context) (_ int, err error) {
+	const op = "UserRepository.CountUsers"
+	query := "SELECT COUNT(*) FROM users WHERE tenant_id = $1"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
 	var count int
-	err := r.db.QueryRow(query).Scan(&count)
+	err = r.reads().QueryRowContext(ctx, query, r.tenant).Scan(&count)
 	if err != nil {
-		return 0, fmt.Errorf("failed to count users: %w", err)
+		return 0, newRepoError(op, "", fmt.Errorf("failed to count users: %w", err))
 	}
 
 	return count, nil
 }
 
-// GetRecentUsers returns users created in the last N days
-func (r *UserRepository) GetRecentUsers(days int) ([]models.User, error) {
-	query := `
-		SELECT id, email, name, created_at 
-		FROM users 
-		WHERE created_at >= NOW() - INTERVAL '1 day' * $1
-		ORDER BY created_at DESC
-	`
+// ListByRole retrieves a page of up to limit users with the given role,
+// ordered by ID, after cursor; see ListPaginated for cursor and limit
+func (r *UserRepository) ListByRole(ctx context.Context, role models.Role, cursor string, limit int) (_ UserPage, err error) {
+	const op = "UserRepository.ListByRole"
+	key := fmt.Sprintf("role=%s cursor=%s limit=%d", role, cursor, limit)
+	afterID, err := pagination.DecodeID(cursor)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	query, args := withLimit("SELECT "+userColumns+" FROM users WHERE role = $1 AND id > $2 AND tenant_id = $3 ORDER BY id", []interface{}{role, afterID, r.tenant}, limit)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, args...)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	// Checked here because Postgres rejects unknown enum values with a less useful error
+	if err := ValidateRole(role); err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	if err := ValidateLimit(limit); err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
 
-	rows, err := r.db.Query(query, days)
+	rows, err := r.reads().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get recent users: %w", err)
+		return UserPage{}, newRepoError(op, key, fmt.Errorf("failed to list users by role: %w", err))
 	}
-	defer rows.Close()
+	users, err := scanUsers(rows)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	total, err := r.countTotal(ctx, "SELECT COUNT(*) FROM users WHERE role = $1 AND tenant_id = $2", role, r.tenant)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+
+	return pagination.New(users, limit, total, IDCursor), nil
+}
+
+// CountByRole returns the number of users per role; every role is present,
+// with 0 if nobody has it
+func (r *UserRepository) CountByRole(ctx context.Context) (_ map[models.Role]int, err error) {
+	const op = "UserRepository.CountByRole"
+	query := "SELECT role, COUNT(*) FROM users WHERE tenant_id = $1 GROUP BY role"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query})
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	users := []models.User{} // Initialize empty slice instead of nil
+	rows, err := r.reads().QueryContext(ctx, query, r.tenant)
+	if err != nil {
+		return nil, newRepoError(op, "", fmt.Errorf("failed to count users by role: %w", err))
+	}
+	defer closeRows(rows, &err)
+
+	counts := make(map[models.Role]int, len(models.Roles))
+	for _, role := range models.Roles {
+		counts[role] = 0
+	}
 	for rows.Next() {
-		var user models.User
-		err := rows.Scan(&user.ID, &user.Email, &user.Name, &user.CreatedAt)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user: %w", err)
+		var role models.Role
+		var count int
+		if err := rows.Scan(&role, &count); err != nil {
+			return nil, newRepoError(op, "", fmt.Errorf("failed to scan role count: %w", err))
 		}
-		users = append(users, user)
+		counts[role] = count
 	}
 
 	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating users: %w", err)
+		return nil, newRepoError(op, "", fmt.Errorf("error iterating role counts: %w", err))
 	}
 
-	return users, nil
+	return counts, nil
+}
+
+// GetRecentUsers retrieves a page of up to limit users created in the last
+// N days, counted back from the repository's Clock, newest first, after
+// cursor; see ListPaginated for cursor and limit. Of opts, WithLimit
+// overrides limit, WithAscending lists oldest first, and WithDomain and
+// IncludeDeleted filter as in ListFiltered; a negative limit either way is
+// a ValidationError.
+func (r *UserRepository) GetRecentUsers(ctx context.Context, days int, cursor string, limit int, opts ...QueryOption) (_ UserPage, err error) {
+	const op = "UserRepository.GetRecentUsers"
+	q := Query{Page: PageOpts{Direction: Descending, Limit: limit}}.With(opts...)
+	limit = q.Page.Limit
+	key := fmt.Sprintf("days=%d cursor=%s limit=%d order=%s domain=%s", days, cursor, limit, q.Page.Direction, q.Filter.EmailDomain)
+	query, args, countQuery, countArgs, err := r.recentQueries(days, cursor, q)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: query}, days)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := ValidateLimit(limit); err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	if q.Filter.unmatchable() {
+		return pagination.New([]models.User{}, limit, 0, RecentCursor), nil
+	}
+
+	rows, err := r.reads().QueryContext(ctx, query, args...)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, fmt.Errorf("failed to get recent users: %w", err))
+	}
+	users, err := scanUsers(rows)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+	total, err := r.countTotal(ctx, countQuery, countArgs...)
+	if err != nil {
+		return UserPage{}, newRepoError(op, key, err)
+	}
+
+	return pagination.New(users, limit, total, RecentCursor), nil
+}
+
+// recentQueries builds GetRecentUsers' queries for q: the page of users
+// created in the last days days after cursor, and the count of them all.
+// Explain plans the page query too, so both run the same statement.
+func (r *UserRepository) recentQueries(days int, cursor string, q Query) (query string, args []interface{}, countQuery string, countArgs []interface{}, err error) {
+	afterCreated, afterID, err := ParseRecentCursor(cursor)
+	if err != nil {
+		return "", nil, "", nil, err
+	}
+	cutoff := r.clock.Now().UTC().AddDate(0, 0, -days)
+	where := q.Filter.where()
+	where.add("tenant_id = $%d", r.tenant)
+	where.add("created_at >= $%d", r.timeArg(cutoff))
+	countQuery, countArgs = "SELECT COUNT(*) FROM users"+where.String(), where.args
+	// The keyset follows the order, so a cursor pages on either way
+	keyset, order := "<", " ORDER BY created_at DESC, id DESC"
+	if q.Page.Direction == Ascending {
+		keyset, order = ">", " ORDER BY created_at, id"
+	}
+	if cursor != "" {
+		where.add("(created_at, id) "+keyset+" ($%d, $%d)", r.timeArg(afterCreated), afterID)
+	}
+	query, args = withLimit("SELECT "+userColumns+" FROM users"+where.String()+order, where.args, q.Page.Limit)
+	return query, args, countQuery, countArgs, nil
+}
+
+// timeArg is t as a query argument: a time.Time, or text in the layout the
+// SQLite schema stores
+func (r *UserRepository) timeArg(t time.Time) interface{} {
+	if r.dialect == DialectSQLite {
+		return t.UTC().Format(sqliteTimeLayout)
+	}
+	return t.UTC()
 }
 
 // ==================== CACHED USER REPOSITORY ====================
-// CachedUserRepository handles database operations with Redis caching
+// defaultCacheTTL is how long a cached user lives in Redis
+const defaultCacheTTL = 5 * time.Minute
+
+// CachedUserRepository handles database operations with Redis caching. It
+// keeps to the caching: every statement is run by a UserRepository.
 type CachedUserRepository struct {
-	db    *sql.DB
-	cache *redis.Client
+	users      *UserRepository
+	cache      redis.Cmdable
+	closeCache func() error // set when the repository owns the client
+
+	ttl          time.Duration
+	refreshAhead time.Duration
+	cmdTimeout   time.Duration
+	negativeTTL  time.Duration
+	warmBatch    int
+	warmWorkers  int
+	breaker      *breaker
+	group        *singleflight.Group
+	hooks        []Hook
+	bcryptCost   int
+	publisher    notifications.Publisher
+	sessions     SessionDestroyer
+	clock        Clock
+	updateLimit  int
+	updateWindow time.Duration
+	fenceTTL     time.Duration
+
+	// tenant scopes every query and cache key; see ForTenant
+	tenant string
+}
+
+// CachedOption configures a CachedUserRepository
+type CachedOption func(*CachedUserRepository)
+
+// WithCacheTTL sets the base TTL of cached users
+func WithCacheTTL(ttl time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.ttl = ttl
+	}
+}
+
+// WithRefreshAhead enables stale-while-revalidate: a cache hit whose remaining
+// TTL is below threshold is served immediately while the key is re-read from
+// the database and rewritten in the background. The remaining TTL is the
+// key's expiry time in Redis less the Clock's now, so the two should agree
+// to well within threshold; it needs Redis 7 for PEXPIRETIME.
+func WithRefreshAhead(threshold time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.refreshAhead = threshold
+	}
+}
+
+// WithCacheTimeout bounds every Redis command; the default is 50ms, and 0
+// leaves commands bounded only by the caller's context. A lookup that times
+// out is served from the database like any other cache miss, so a slow
+// Redis costs at most timeout per command, and timeouts count as failures
+// towards WithCircuitBreaker. go-redis only honors the deadline when the
+// client was created with ContextTimeoutEnabled.
+func WithCacheTimeout(timeout time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.cmdTimeout = timeout
+	}
+}
+
+// WithPublisher publishes an event after every CreateCached, UpdateCached,
+// and DeleteCached. The default, notifications.Noop, publishes nothing.
+func WithPublisher(p notifications.Publisher) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.publisher = p
+	}
+}
+
+// NewCachedUserRepository creates a new cached user repository. cache can be
+// a *redis.Client, including one from redis.NewFailoverClient for Sentinel,
+// or a *redis.ClusterClient; the repository never sends a command whose keys
+// span hash slots. It is bound to DefaultTenant; ForTenant binds a copy to
+// another.
+func NewCachedUserRepository(db *sql.DB, cache redis.Cmdable, opts ...CachedOption) *CachedUserRepository {
+	r := &CachedUserRepository{
+		// Unprepared, so Close has no statements to release, and without
+		// retries: a cached write runs once
+		users:       NewUserRepository(db, WithoutPreparedStatements(), WithRetry(1, 0)),
+		cache:       cache,
+		ttl:         defaultCacheTTL,
+		cmdTimeout:  defaultCacheTimeout,
+		warmBatch:   defaultWarmBatchSize,
+		warmWorkers: defaultWarmConcurrency,
+		breaker:     newBreaker(),
+		group:       new(singleflight.Group),
+		bcryptCost:  bcrypt.DefaultCost,
+		publisher:   notifications.Noop{},
+		clock:       systemClock{},
+		fenceTTL:    defaultFenceTTL,
+		tenant:      DefaultTenant,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	r.breaker.now = r.clock.Now
+	return r
+}
+
+// userCacheKey is the Redis key holding tenant's user id as JSON. Entries
+// from before tenancy, under user:{id}, are no longer read and expire with
+// their TTL.
+func userCacheKey(tenant string, id int) string {
+	return fmt.Sprintf("user:%s:%d", tenant, id)
 }
 
-// NewCachedUserRepository creates a new cached user repository
-func NewCachedUserRepository(db *sql.DB, cache *redis.Client) *CachedUserRepository {
-	return &CachedUserRepository{
-		db:    db,
-		cache: cache,
+// GetByIDCached retrieves a user by ID with caching. Under a context from
+// WithRequestCache, the user is read at most once per context and every
+// call gets the same *models.User, so callers must not modify it.
+func (r *CachedUserRepository) GetByIDCached(ctx context.Context, id int) (_ *models.User, err error) {
+	outer := &Op{Name: "CachedUserRepository.GetByIDCached", UserID: id}
+	ctx, finish := observe(ctx, r.hooks, outer, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	rc := requestCacheFrom(ctx)
+	if rc == nil {
+		return r.getByIDCached(ctx, outer, id)
 	}
+	read := false
+	user, err := rc.user(userCacheKey(r.tenant, id), func() (*models.User, error) {
+		read = true
+		return r.getByIDCached(ctx, outer, id)
+	})
+	if !read {
+		outer.Cache = CacheHit
+	}
+	return user, err
 }
 
-// GetByIDCached retrieves a user by ID with caching
-func (r *CachedUserRepository) GetByIDCached(ctx context.Context, id int) (*models.User, error) {
+// getByIDCached is GetByIDCached without the request cache, recording
+// whether Redis had the user in outer
+func (r *CachedUserRepository) getByIDCached(ctx context.Context, outer *Op, id int) (*models.User, error) {
 	// Try cache first
-	cacheKey := fmt.Sprintf("user:%d", id)
-	cached, err := r.cache.Get(ctx, cacheKey).Result()
-	if err == nil {
-		var user models.User
-		if err := json.Unmarshal([]byte(cached), &user); err == nil {
-			return &user, nil
+	cacheKey := userCacheKey(r.tenant, id)
+	if user, ok := r.lookup(ctx, cacheKey, id); ok {
+		outer.Cache = CacheHit
+		if user == nil {
+			return nil, newRepoError("CachedUserRepository.GetByIDCached", fmt.Sprintf("id=%d", id), ErrUserNotFound)
+		}
+		return user, nil
+	}
+	outer.Cache = CacheMiss
+
+	// Cache miss - query database (concurrent misses share one query)
+	v, err, _ := r.group.Do(cacheKey, func() (interface{}, error) {
+		return r.load(ctx, cacheKey, id)
+	})
+	if err != nil {
+		return nil, newRepoError("CachedUserRepository.GetByIDCached", fmt.Sprintf("id=%d", id), err)
+	}
+
+	return v.(*models.User), nil
+}
+
+// lookup returns the cached user for cacheKey, reporting the lookup to the
+// hooks as "cache.Get". Redis errors, an open circuit breaker, and
+// undecodable entries count as misses. A WithNegativeCaching entry is a hit
+// with a nil user.
+func (r *CachedUserRepository) lookup(ctx context.Context, cacheKey string, id int) (*models.User, bool) {
+	op := &Op{Name: "cache.Get", Cache: CacheMiss}
+	ctx, finish := observe(ctx, r.hooks, op, cacheKey)
+
+	var cached string
+	var remaining time.Duration
+	err := r.cacheDo(ctx, func(ctx context.Context) (err error) {
+		cached, remaining, err = r.getCached(ctx, cacheKey)
+		return err
+	})
+	if err != nil {
+		if err == redis.Nil {
+			err = nil
 		}
+		finish(err)
+		return nil, false
+	}
+	if cached == missingUserEntry {
+		op.Cache = CacheHit
+		finish(nil)
+		return nil, true
+	}
+
+	var user models.User
+	if err := json.Unmarshal([]byte(cached), &user); err != nil {
+		finish(err)
+		return nil, false
+	}
+	if user.Role == "" {
+		// Cached before users had roles
+		user.Role = models.RoleMember
+	}
+	op.Cache = CacheHit
+	finish(nil)
+
+	if r.refreshAhead > 0 && remaining > 0 && remaining < r.refreshAhead {
+		go r.refresh(context.WithoutCancel(ctx), cacheKey, id)
+	}
+	return &user, true
+}
+
+// getCached reads a key and, when refresh-ahead is enabled, its expiry time
+// in the same round trip, returning how long the key has left by the Clock;
+// 0 for a key without an expiry
+func (r *CachedUserRepository) getCached(ctx context.Context, cacheKey string) (string, time.Duration, error) {
+	if r.refreshAhead <= 0 {
+		cached, err := r.cache.Get(ctx, cacheKey).Result()
+		return cached, 0, err
+	}
+
+	pipe := r.cache.Pipeline()
+	get := pipe.Get(ctx, cacheKey)
+	expireTime := pipe.PExpireTime(ctx, cacheKey)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", 0, err
+	}
+	// PEXPIRETIME is -1 for a key without an expiry
+	if expireTime.Val() <= 0 {
+		return get.Val(), 0, nil
 	}
+	return get.Val(), time.UnixMilli(expireTime.Val().Milliseconds()).Sub(r.clock.Now()), nil
+}
 
-	// Cache miss - query database
-	user, err := r.getFromDB(id)
+// load queries the database and stores the result in the cache, dropping
+// it again if a write fenced the user meanwhile (see WithInvalidationFence)
+func (r *CachedUserRepository) load(ctx context.Context, cacheKey string, id int) (*models.User, error) {
+	f := r.beginFill(ctx, id)
+	dbCtx, finish := observe(ctx, r.hooks, &Op{Name: "db.GetByID", Statement: selectUserByID, UserID: id}, id)
+	user, err := r.users.getByID(dbCtx, id)
+	finish(err)
+	if errors.Is(err, ErrUserNotFound) && r.negativeTTL > 0 {
+		setCtx, finish := observe(ctx, r.hooks, &Op{Name: "cache.Set", UserID: id}, cacheKey)
+		finish(errors.Join(r.cacheWrite(setCtx, func(ctx context.Context) error {
+			return r.cache.Set(ctx, cacheKey, missingUserEntry, r.negativeTTL).Err()
+		}), r.endFill(setCtx, f)))
+	}
 	if err != nil {
 		return nil, err
 	}
 
-	// Store in cache
-	data, _ := json.Marshal(user)
-	r.cache.Set(ctx, cacheKey, data, 5*time.Minute)
+	// Store in cache. The user is returned either way; a failed write is
+	// reported to the hooks as the error of "cache.Set".
+	setCtx, finish := observe(ctx, r.hooks, &Op{Name: "cache.Set"}, cacheKey)
+	data, err := json.Marshal(user)
+	if err != nil {
+		finish(fmt.Errorf("failed to encode %s: %w", cacheKey, err))
+		return user, nil
+	}
+	finish(errors.Join(r.cacheWrite(setCtx, func(ctx context.Context) error {
+		return r.cache.Set(ctx, cacheKey, data, r.ttl).Err()
+	}), r.endFill(setCtx, f)))
 
 	return user, nil
 }
 
-// getFromDB is a helper method to query user from database
-func (r *CachedUserRepository) getFromDB(id int) (*models.User, error) {
-	query := "SELECT id, email, name, created_at FROM users WHERE id = $1"
+// cacheCtx bounds a single Redis command by the WithCacheTimeout timeout, if any
+func (r *CachedUserRepository) cacheCtx(ctx context.Context) (context.Context, context.CancelFunc) {
+	if r.cmdTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, r.cmdTimeout)
+}
+
+// refresh rewrites a key from the database; only one refresh (or load) per
+// key runs at a time. Nobody waits on a refresh: load reports its failures
+// to the hooks.
+func (r *CachedUserRepository) refresh(ctx context.Context, cacheKey string, id int) {
+	_, _, _ = r.group.Do(cacheKey, func() (interface{}, error) {
+		return r.load(ctx, cacheKey, id)
+	})
+}
 
-	var user models.User
-	err := r.db.QueryRow(query, id).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+// InvalidateCache removes users from the cache in one DEL. While the
+// circuit breaker is open it returns an error wrapping ErrCircuitOpen.
+func (r *CachedUserRepository) InvalidateCache(ctx context.Context, ids ...int) (err error) {
+	const op = "CachedUserRepository.InvalidateCache"
+	o := &Op{Name: op}
+	if len(ids) == 1 {
+		o.UserID = ids[0]
+	}
+	ctx, finish := observe(ctx, r.hooks, o, ids)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("user not found")
+	if len(ids) == 0 {
+		return nil
+	}
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = userCacheKey(r.tenant, id)
 	}
+	requestCacheFrom(ctx).forget(keys...)
+	// Fenced too: the caller may have just written the users some other way
+	if err := r.fence(ctx, ids...); err != nil {
+		return newRepoError(op, fmt.Sprintf("ids=%v", ids), err)
+	}
+	err = r.cacheDo(ctx, func(ctx context.Context) error {
+		return r.delKeys(ctx, keys...)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user: %w", err)
+		return newRepoError(op, fmt.Sprintf("ids=%v", ids), err)
+	}
+	return nil
+}
+
+// UpdateRoleCached changes a user's role and invalidates their cached entry,
+// so the next read sees the new role
+func (r *CachedUserRepository) UpdateRoleCached(ctx context.Context, id int, role models.Role) (err error) {
+	const op = "CachedUserRepository.UpdateRoleCached"
+	key := fmt.Sprintf("id=%d", id)
+	o := &Op{Name: op, Statement: updateUserRole, UserID: id}
+	ctx, finish := observe(ctx, r.hooks, o, id, role)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	if err := ValidateRole(role); err != nil {
+		return newRepoError(op, key, err)
 	}
 
-	return &user, nil
+	if o.Rows, err = r.users.updateRole(ctx, id, role); err != nil {
+		return newRepoError(op, key, err)
+	}
+
+	cacheKey := userCacheKey(r.tenant, id)
+	requestCacheFrom(ctx).forget(cacheKey)
+	if err := r.evict(ctx, []int{id}, cacheKey); err != nil {
+		return newRepoError(op, key, fmt.Errorf("failed to invalidate cache: %w", err))
+	}
+	return nil
 }
 
-// InvalidateCache removes a user from the cache
-func (r *CachedUserRepository) InvalidateCache(ctx context.Context, id int) error {
-	cacheKey := fmt.Sprintf("user:%d", id)
-	return r.cache.Del(ctx, cacheKey).Err()
+// CreateCached creates a user, indexes their email in the cache, and
+// publishes a user.created event. Indexing the email replaces a
+// WithNegativeCaching entry for it, and the one for the new ID is deleted,
+// so cached reads find the user straight away. An email already taken
+// returns ErrDuplicateEmail, as from Create. If only the publish fails, the
+// user is returned along with the error.
+func (r *CachedUserRepository) CreateCached(ctx context.Context, email, name string) (_ *models.User, err error) {
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "CachedUserRepository.CreateCached", Statement: insertUser}, email, name)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	in, err := validated(CreateUserInput{Email: email, Name: name})
+	if err != nil {
+		return nil, newRepoError("CachedUserRepository.CreateCached", "email="+email, err)
+	}
+	email = in.Email
+
+	user, err := r.users.insert(ctx, in, "")
+	if err != nil {
+		return nil, newRepoError("CachedUserRepository.CreateCached", "email="+email, err)
+	}
+	r.indexEmail(ctx, user.Email, user.ID)
+	r.forgetMissing(ctx, user.ID)
+
+	if err := r.publish(ctx, models.EventUserCreated, *user); err != nil {
+		return user, newRepoError("CachedUserRepository.CreateCached", "email="+email, err)
+	}
+	return user, nil
 }
 
-// CreateCached creates a user and invalidates cache
-func (r *CachedUserRepository) CreateCached(ctx context.Context, email, name string) (*models.User, error) {
-	query := `
-		INSERT INTO users (email, name)
-		VALUES ($1, $2)
-		RETURNING id, email, name, created_at
-	`
+// UpdateCached modifies an existing user's email and name, keeping their
+// role, invalidates their cached entries, and publishes a user.updated event.
+// With WithUpdateLimit it may return a *TooManyUpdatesError instead.
+func (r *CachedUserRepository) UpdateCached(ctx context.Context, id int, email, name string) (err error) {
+	const op = "CachedUserRepository.UpdateCached"
+	key := fmt.Sprintf("id=%d", id)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: updateUserReturningOld, UserID: id}, id, email, name)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
 
-	var user models.User
-	err := r.db.QueryRow(query, email, name).Scan(
-		&user.ID,
-		&user.Email,
-		&user.Name,
-		&user.CreatedAt,
-	)
+	in, err := validated(CreateUserInput{Email: email, Name: name})
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
+	if err := r.allowUpdate(ctx, id); err != nil {
+		return newRepoError(op, key, err)
+	}
 
+	user, oldEmail, err := r.users.updateReturningOld(ctx, id, in)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
+		return newRepoError(op, key, err)
 	}
 
-	return &user, nil
+	// The new email's key may hold a WithNegativeCaching entry
+	if err := r.invalidate(ctx, id, oldEmail, user.Email); err != nil {
+		return newRepoError(op, key, err)
+	}
+	if err := r.publish(ctx, models.EventUserUpdated, user); err != nil {
+		return newRepoError(op, key, err)
+	}
+	return nil
 }
 
+// UpdateWithVersionCached is UpdateWithVersion for the cache: on success it
+// moves user.Version on, invalidates the user's cached entries, and
+// publishes a user.updated event.
+// A conflict also invalidates them, since the version it was given most
+// likely came from a stale cached copy.
+func (r *CachedUserRepository) UpdateWithVersionCached(ctx context.Context, user *models.User) (err error) {
+	const op = "CachedUserRepository.UpdateWithVersionCached"
+	key := fmt.Sprintf("id=%d", user.ID)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: updateVersionReturningOld, UserID: user.ID}, user.ID, user.Email, user.Name, user.Role, user.Version)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	in, err := validated(CreateUserInput{Email: user.Email, Name: user.Name, Role: user.Role})
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
 
+	updated, oldEmail, err := r.users.updateVersionReturningOld(ctx, user.ID, user.Version, in)
+	if errors.Is(err, ErrVersionConflict) {
+		// oldEmail is the current one
+		if err := r.invalidate(ctx, user.ID, oldEmail); err != nil {
+			return newRepoError(op, key, err)
+		}
+	}
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
 
+	user.Version++
 
+	if err := r.invalidate(ctx, user.ID, oldEmail, updated.Email); err != nil {
+		return newRepoError(op, key, err)
+	}
+	if err := r.publish(ctx, models.EventUserUpdated, updated); err != nil {
+		return newRepoError(op, key, err)
+	}
+	return nil
+}
+
+// DeleteCached removes a user, invalidates their cached entries, and
+// publishes a user.deleted event carrying the deleted row. Like Delete, it
+// returns ErrHasOrders while the user has orders; DeleteUserCascade deletes
+// them too.
+func (r *CachedUserRepository) DeleteCached(ctx context.Context, id int) (err error) {
+	const op = "CachedUserRepository.DeleteCached"
+	key := fmt.Sprintf("id=%d", id)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: deleteUserReturning, UserID: id}, id)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	user, err := r.users.deleteReturning(ctx, id)
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
+
+	if err := r.invalidate(ctx, id, user.Email); err != nil {
+		return newRepoError(op, key, err)
+	}
+	if err := r.publish(ctx, models.EventUserDeleted, *user); err != nil {
+		return newRepoError(op, key, err)
+	}
+	return nil
+}
+
+// invalidate fences user id and deletes their cached entry and the email
+// index keys of emails
+func (r *CachedUserRepository) invalidate(ctx context.Context, id int, emails ...string) error {
+	keys := []string{userCacheKey(r.tenant, id)}
+	requestCacheFrom(ctx).forget(keys[0])
+	for _, email := range emails {
+		keys = append(keys, emailCacheKey(r.tenant, email))
+	}
+	if err := r.evict(ctx, []int{id}, keys...); err != nil {
+		return fmt.Errorf("failed to invalidate cache: %w", err)
+	}
+	return nil
+}
+
+// publish sends an eventType event for user, reporting it to the hooks as
+// "notifications.Publish"
+func (r *CachedUserRepository) publish(ctx context.Context, eventType models.EventType, user models.User) error {
+	event := notifications.NewEvent(eventType, user)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "notifications.Publish", UserID: user.ID}, event.Type, event.ID)
+	err := r.publisher.Publish(ctx, event)
+	finish(err)
+	if err != nil {
+		return fmt.Errorf("failed to publish %s event: %w", eventType, err)
+	}
+	return nil
+}
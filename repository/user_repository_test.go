@@ -4,99 +4,104 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"testcontainers-demo/db"
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/migrations"
+	"testcontainers-demo/models"
+	"testcontainers-demo/notifications"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/testhelpers"
+	"testcontainers-demo/testhelpers/sqlspy"
+
 	_ "github.com/lib/pq"
-	redis2 "github.com/redis/go-redis/v9"
-	"github.com/testcontainers/testcontainers-go"
-	"github.com/testcontainers/testcontainers-go/modules/postgres"
-	"github.com/testcontainers/testcontainers-go/modules/redis"
-	"github.com/testcontainers/testcontainers-go/wait"
+	"github.com/redis/go-redis/v9"
 )
 
 // Global test database connection
 var testDB *sql.DB
 
+// testContainer is the Postgres container behind testDB, used to clone databases
+// for tests that need one to themselves. Both are nil when Docker is
+// unavailable, and only the integration tests need them.
+var testContainer *testhelpers.PostgresContainer
+
 // TestMain sets up the test environment
-// This runs ONCE before all tests in this package
+// This runs ONCE before all tests in this package, or once per image with
+// TEST_POSTGRES_MATRIX set
 func TestMain(m *testing.M) {
-    ctx := context.Background()
-
-    // 🐳 START POSTGRESQL CONTAINER WITH WAIT STRATEGY
-    container, err := postgres.RunContainer(ctx,
-        testcontainers.WithImage("postgres:15"),
-        postgres.WithInitScripts("../migrations/init.sql"),
-        postgres.WithDatabase("testdb"),
-        postgres.WithUsername("testuser"),
-        postgres.WithPassword("testpass"),
-        testcontainers.WithWaitStrategy(
-            wait.ForLog("database system is ready").
-                WithOccurrence(2).
-                WithStartupTimeout(30*time.Second),
-        ),
-    )
-    if err != nil {
-        log.Fatalf("Failed to start container: %s", err)
-    }
-
-    // Get connection string with SSL mode disabled
-    connStr, err := container.ConnectionString(ctx, "sslmode=disable")
-    if err != nil {
-        log.Fatalf("Failed to get connection string: %s", err)
-    }
-
-    // Connect to database
-    testDB, err = sql.Open("postgres", connStr)
-    if err != nil {
-        log.Fatalf("Failed to connect to database: %s", err)
-    }
-
-    // Verify connection
-    if err = testDB.Ping(); err != nil {
-        log.Fatalf("Failed to ping database: %s", err)
-    }
-
-    log.Println("✅ Test database ready!")
-
-    // Run all tests
-    code := m.Run()
-
-    // Cleanup
-    testDB.Close()
-    if err := container.Terminate(ctx); err != nil {
-        log.Fatalf("Failed to terminate container: %s", err)
-    }
-
-    os.Exit(code)
+	os.Exit(testhelpers.RunPostgresMatrix(func(image string) int {
+		return testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+			testContainer = c
+			testDB = c.DB
+			log.Printf("✅ Test database ready on %s!", image)
+		}, testhelpers.WithImage(image))
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t testing.TB) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// missingID is an ID no test creates, for not-found cases
+const missingID = math.MaxInt32
+
+// newUser inserts a user with a unique email into testDB and deletes it when
+// the test finishes. Tests use their own users rather than the seed rows, so
+// they can run in parallel and in any order.
+func newUser(t *testing.T) models.User {
+	t.Helper()
+	return fixtures.SeedUsers(t, testDB, fixtures.NewUser())[0]
+}
+
+// deleteOnCleanup deletes the user with id from testDB when the test finishes
+func deleteOnCleanup(t *testing.T, id int) {
+	t.Helper()
+	t.Cleanup(func() { testDB.Exec("DELETE FROM users WHERE id = $1", id) })
 }
 
 // TestGetByID tests retrieving a user by ID
 func TestGetByID(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 	repo := NewUserRepository(testDB)
+	existing := newUser(t)
 
-	// Test case 1: User exists (from init.sql)
+	// Test case 1: User exists
 	t.Run("User Exists", func(t *testing.T) {
-		user, err := repo.GetByID(1)
+		user, err := repo.GetByID(ctx, existing.ID)
 		if err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if user.Email != "alice@example.com" {
-			t.Errorf("Expected email 'alice@example.com', got: %s", user.Email)
+		if user.Email != existing.Email {
+			t.Errorf("Expected email '%s', got: %s", existing.Email, user.Email)
 		}
 
-		if user.Name != "Alice Smith" {
-			t.Errorf("Expected name 'Alice Smith', got: %s", user.Name)
+		if user.Name != existing.Name {
+			t.Errorf("Expected name '%s', got: %s", existing.Name, user.Name)
 		}
 	})
 
 	// Test case 2: User does not exist
 	t.Run("User Not Found", func(t *testing.T) {
-		_, err := repo.GetByID(9999)
+		_, err := repo.GetByID(ctx, missingID)
 		if err == nil {
 			t.Fatal("Expected error for non-existent user, got nil")
 		}
@@ -105,21 +110,25 @@ func TestGetByID(t *testing.T) {
 
 // TestGetByEmail tests retrieving a user by email
 func TestGetByEmail(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 	repo := NewUserRepository(testDB)
+	existing := newUser(t)
 
 	t.Run("User Exists", func(t *testing.T) {
-		user, err := repo.GetByEmail("bob@example.com")
+		user, err := repo.GetByEmail(ctx, existing.Email)
 		if err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if user.Name != "Bob Johnson" {
-			t.Errorf("Expected name 'Bob Johnson', got: %s", user.Name)
+		if user.Name != existing.Name {
+			t.Errorf("Expected name '%s', got: %s", existing.Name, user.Name)
 		}
 	})
 
 	t.Run("User Not Found", func(t *testing.T) {
-		_, err := repo.GetByEmail("nonexistent@example.com")
+		_, err := repo.GetByEmail(ctx, "nonexistent@example.com")
 		if err == nil {
 			t.Fatal("Expected error for non-existent email, got nil")
 		}
@@ -128,215 +137,450 @@ func TestGetByEmail(t *testing.T) {
 
 // TestCreate tests user creation
 func TestCreate(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	existing := newUser(t)
 
 	t.Run("Create New User", func(t *testing.T) {
-		user, err := repo.Create("charlie@example.com", "Charlie Brown")
-		if err != nil {
-			t.Fatalf("Failed to create user: %v", err)
-		}
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			email := fixtures.GenerateEmail(t)
+			user, err := repo.Create(ctx, email, "Charlie Brown")
+			if err != nil {
+				t.Fatalf("Failed to create user: %v", err)
+			}
 
-		if user.ID == 0 {
-			t.Error("Expected non-zero ID for created user")
-		}
+			if user.ID == 0 {
+				t.Error("Expected non-zero ID for created user")
+			}
 
-		if user.Email != "charlie@example.com" {
-			t.Errorf("Expected email 'charlie@example.com', got: %s", user.Email)
-		}
+			if user.Email != email {
+				t.Errorf("Expected email '%s', got: %s", email, user.Email)
+			}
+
+			if user.CreatedAt.IsZero() {
+				t.Error("Expected non-zero created_at timestamp")
+			}
+		})
+	})
+
+	t.Run("Create Duplicate Email", func(t *testing.T) {
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			// Try to create user with an existing email
+			_, err := repo.Create(ctx, existing.Email, "Another User")
+			if err == nil {
+				t.Fatal("Expected error when creating user with duplicate email")
+			}
+		})
+	})
+}
+
+// TestCreateBatch tests inserting several users in one statement
+func TestCreateBatch(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	existing := newUser(t)
+
+	t.Run("Created In Input Order", func(t *testing.T) {
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			inputs := []CreateUserInput{
+				{Email: fixtures.GenerateEmail(t), Name: "First"},
+				{Email: fixtures.GenerateEmail(t), Name: "Second", Role: models.RoleAdmin},
+				{Email: fixtures.GenerateEmail(t), Name: "Third"},
+			}
+			users, err := repo.CreateBatch(ctx, inputs)
+			if err != nil {
+				t.Fatalf("Failed to create users: %v", err)
+			}
+			if len(users) != len(inputs) {
+				t.Fatalf("Expected %d users, got: %d", len(inputs), len(users))
+			}
+			for i, u := range users {
+				if u.Email != inputs[i].Email || u.Name != inputs[i].Name {
+					t.Errorf("User %d: expected %s, got: %s", i, inputs[i].Email, u.Email)
+				}
+			}
+			if users[0].Role != models.RoleMember || users[1].Role != models.RoleAdmin {
+				t.Errorf("Expected roles member and admin, got: %q and %q", users[0].Role, users[1].Role)
+			}
+		})
+	})
 
-		if user.CreatedAt.IsZero() {
-			t.Error("Expected non-zero created_at timestamp")
+	t.Run("Duplicate Creates None", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		t.Cleanup(func() { repo.Close() })
+
+		fresh := fixtures.GenerateEmail(t)
+		_, err := repo.CreateBatch(ctx, []CreateUserInput{
+			{Email: fresh, Name: "Fresh"},
+			{Email: existing.Email, Name: "Duplicate"},
+		})
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Fatalf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		if _, err := repo.GetByEmail(ctx, fresh); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected no user from the failed batch, got: %v", err)
 		}
+	})
+
+	t.Run("Invalid Input", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		t.Cleanup(func() { repo.Close() })
 
-		// Cleanup: delete the created user
-		defer repo.Delete(user.ID)
+		_, err := repo.CreateBatch(ctx, []CreateUserInput{
+			{Email: fixtures.GenerateEmail(t), Name: "Valid"},
+			{Email: "not-an-email", Name: "Invalid"},
+		})
+		var validationErr *ValidationError
+		if !errors.As(err, &validationErr) {
+			t.Fatalf("Expected ValidationError, got: %v", err)
+		}
+		var repoErr *RepoError
+		if !errors.As(err, &repoErr) || repoErr.Key != "index=1" {
+			t.Errorf("Expected the invalid input's index in the error, got: %v", err)
+		}
 	})
 
-	t.Run("Create Duplicate Email", func(t *testing.T) {
-		// Try to create user with existing email (from init.sql)
-		_, err := repo.Create("alice@example.com", "Another Alice")
-		if err == nil {
-			t.Fatal("Expected error when creating user with duplicate email")
+	t.Run("Too Large", func(t *testing.T) {
+		repo := NewUserRepository(testDB)
+		t.Cleanup(func() { repo.Close() })
+
+		if _, err := repo.CreateBatch(ctx, make([]CreateUserInput, MaxBatchSize+1)); err == nil {
+			t.Error("Expected an error for a batch over MaxBatchSize")
 		}
 	})
 }
 
 // TestUpdate tests user updates
 func TestUpdate(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 
 	t.Run("Update Existing User", func(t *testing.T) {
-		// First, create a user to update
-		user, err := repo.Create("david@example.com", "David Davis")
-		if err != nil {
-			t.Fatalf("Failed to create test user: %v", err)
-		}
-		defer repo.Delete(user.ID)
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			// First, create a user to update
+			user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "David Davis")
+			if err != nil {
+				t.Fatalf("Failed to create test user: %v", err)
+			}
 
-		// Update the user
-		err = repo.Update(user.ID, "david.updated@example.com", "David Updated")
-		if err != nil {
-			t.Fatalf("Failed to update user: %v", err)
-		}
+			// Update the user
+			updatedEmail := fixtures.GenerateEmail(t)
+			err = repo.Update(ctx, user.ID, updatedEmail, "David Updated")
+			if err != nil {
+				t.Fatalf("Failed to update user: %v", err)
+			}
 
-		// Verify the update
-		updatedUser, err := repo.GetByID(user.ID)
-		if err != nil {
-			t.Fatalf("Failed to retrieve updated user: %v", err)
-		}
+			// Verify the update
+			updatedUser, err := repo.GetByID(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Failed to retrieve updated user: %v", err)
+			}
 
-		if updatedUser.Email != "david.updated@example.com" {
-			t.Errorf("Expected email 'david.updated@example.com', got: %s", updatedUser.Email)
-		}
+			if updatedUser.Email != updatedEmail {
+				t.Errorf("Expected email '%s', got: %s", updatedEmail, updatedUser.Email)
+			}
 
-		if updatedUser.Name != "David Updated" {
-			t.Errorf("Expected name 'David Updated', got: %s", updatedUser.Name)
-		}
+			if updatedUser.Name != "David Updated" {
+				t.Errorf("Expected name 'David Updated', got: %s", updatedUser.Name)
+			}
+		})
 	})
 
 	t.Run("Update Non-Existent User", func(t *testing.T) {
-		err := repo.Update(9999, "nobody@example.com", "Nobody")
-		if err == nil {
-			t.Fatal("Expected error when updating non-existent user")
-		}
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			err := repo.Update(ctx, missingID, "nobody@example.com", "Nobody")
+			if err == nil {
+				t.Fatal("Expected error when updating non-existent user")
+			}
+		})
 	})
 }
 
 // TestDelete tests user deletion
 func TestDelete(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 
 	t.Run("Delete Existing User", func(t *testing.T) {
-		// Create a user to delete
-		user, err := repo.Create("temp@example.com", "Temporary User")
-		if err != nil {
-			t.Fatalf("Failed to create test user: %v", err)
-		}
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			// Create a user to delete
+			user, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Temporary User")
+			if err != nil {
+				t.Fatalf("Failed to create test user: %v", err)
+			}
 
-		// Delete the user
-		err = repo.Delete(user.ID)
-		if err != nil {
-			t.Fatalf("Failed to delete user: %v", err)
-		}
+			// Delete the user
+			err = repo.Delete(ctx, user.ID)
+			if err != nil {
+				t.Fatalf("Failed to delete user: %v", err)
+			}
 
-		// Verify deletion
-		_, err = repo.GetByID(user.ID)
-		if err == nil {
-			t.Fatal("Expected error when retrieving deleted user")
-		}
+			// Verify deletion
+			_, err = repo.GetByID(ctx, user.ID)
+			if err == nil {
+				t.Fatal("Expected error when retrieving deleted user")
+			}
+		})
 	})
 
 	t.Run("Delete Non-Existent User", func(t *testing.T) {
-		err := repo.Delete(9999)
-		if err == nil {
-			t.Fatal("Expected error when deleting non-existent user")
-		}
+		WithRollbackTx(t, testDB, func(repo *UserRepository) {
+			err := repo.Delete(ctx, missingID)
+			if err == nil {
+				t.Fatal("Expected error when deleting non-existent user")
+			}
+		})
 	})
 }
 
 // TestList tests listing all users
 func TestList(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 	repo := NewUserRepository(testDB)
 
-	users, err := repo.List()
+	seeded := fixtures.SeedUsers(t, testDB,
+		fixtures.NewUser().WithName("List First"),
+		fixtures.NewUser().WithName("List Second"),
+		fixtures.NewUser().WithName("List Third"),
+	)
+
+	users, err := repo.List(ctx)
 	if err != nil {
 		t.Fatalf("Failed to list users: %v", err)
 	}
 
-	// Should have at least 2 users from init.sql
-	if len(users) < 2 {
-		t.Errorf("Expected at least 2 users, got: %d", len(users))
+	// Seeded users appear in insertion (ID) order, whatever else is in the table
+	var listed []string
+	for _, user := range users {
+		for _, s := range seeded {
+			if user.ID == s.ID {
+				listed = append(listed, user.Email)
+			}
+		}
 	}
-
-	// Verify first user
-	if users[0].Email != "alice@example.com" {
-		t.Errorf("Expected first user email 'alice@example.com', got: %s", users[0].Email)
+	if len(listed) != len(seeded) {
+		t.Fatalf("Expected %d seeded users in list, got: %d", len(seeded), len(listed))
+	}
+	for i, s := range seeded {
+		if listed[i] != s.Email {
+			t.Errorf("Expected user %d to be %s, got: %s", i, s.Email, listed[i])
+		}
 	}
 }
 
-// TestFindByNamePattern tests finding users by name pattern
-func TestFindByNamePattern(t *testing.T) {
+// TestListOrdered tests each sort field and direction against users with
+// known values, ignoring whatever other tests have inserted
+func TestListOrdered(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 	repo := NewUserRepository(testDB)
 
-	t.Run("Find Users By Pattern", func(t *testing.T) {
-		// Search for users with "Smith" in their name (Alice Smith from init.sql)
-		users, err := repo.FindByNamePattern("Smith")
-		if err != nil {
-			t.Fatalf("Failed to find users: %v", err)
-		}
+	// Each field orders these differently, and two share a name to check
+	// that ties fall back to ID
+	day := func(d int) time.Time { return time.Date(2020, 1, d, 0, 0, 0, 0, time.UTC) }
+	email := func(prefix string) string { return prefix + "-" + fixtures.GenerateEmail(t) }
+	seeded := fixtures.SeedUsers(t, testDB,
+		fixtures.NewUser().WithEmail(email("b")).WithName("Order Charlie").WithCreatedAt(day(2)),
+		fixtures.NewUser().WithEmail(email("c")).WithName("Order Alpha").WithCreatedAt(day(1)),
+		fixtures.NewUser().WithEmail(email("a")).WithName("Order Bravo").WithCreatedAt(day(3)),
+		fixtures.NewUser().WithEmail(email("d")).WithName("Order Alpha").WithCreatedAt(day(4)),
+	)
+
+	tests := []struct {
+		orderBy OrderField
+		want    []int // indexes into seeded, ascending
+	}{
+		{OrderByID, []int{0, 1, 2, 3}},
+		{OrderByEmail, []int{2, 0, 1, 3}},
+		{OrderByName, []int{1, 3, 2, 0}},
+		{OrderByCreatedAt, []int{1, 0, 2, 3}},
+	}
+	for _, tt := range tests {
+		for _, direction := range []Direction{Ascending, Descending} {
+			t.Run(fmt.Sprintf("%s %s", tt.orderBy, direction), func(t *testing.T) {
+				users, err := repo.ListOrdered(ctx, tt.orderBy, direction)
+				if err != nil {
+					t.Fatalf("Failed to list users: %v", err)
+				}
 
-		if len(users) < 1 {
-			t.Errorf("Expected at least 1 user with pattern 'Smith', got: %d", len(users))
+				var got []int
+				for _, user := range users {
+					for i, s := range seeded {
+						if user.ID == s.ID {
+							got = append(got, i)
+						}
+					}
+				}
+				want := slices.Clone(tt.want)
+				if direction == Descending {
+					slices.Reverse(want)
+				}
+				if !slices.Equal(got, want) {
+					t.Errorf("Expected seeded users in order %v, got: %v", want, got)
+				}
+			})
 		}
+	}
 
-		// Verify Alice is in the results
-		found := false
-		for _, user := range users {
-			if user.Email == "alice@example.com" {
-				found = true
-				break
+	t.Run("Rejects Unknown Order", func(t *testing.T) {
+		for _, tt := range []struct {
+			orderBy   OrderField
+			direction Direction
+		}{
+			{"id; DROP TABLE users", Ascending},
+			{"password_hash", Ascending},
+			{"", Ascending},
+			{OrderByName, "asc"},
+			{OrderByName, "ASC, (SELECT 1)"},
+		} {
+			if _, err := repo.ListOrdered(ctx, tt.orderBy, tt.direction); !errors.Is(err, ErrInvalidOrder) {
+				t.Errorf("Expected ErrInvalidOrder for %q %q, got: %v", tt.orderBy, tt.direction, err)
 			}
 		}
-		if !found {
-			t.Error("Expected to find alice@example.com in results")
-		}
 	})
+}
 
-	t.Run("Find Users Case Insensitive", func(t *testing.T) {
-		// Search with lowercase should still find "Smith"
-		users, err := repo.FindByNamePattern("smith")
+// TestListPaginated tests keyset pagination over users
+func TestListPaginated(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+
+	// Whatever other tests have inserted, there are at least two users to page through
+	fixtures.SeedUsers(t, testDB, fixtures.NewUser(), fixtures.NewUser())
+
+	t.Run("Pages Do Not Overlap", func(t *testing.T) {
+		first, err := repo.ListPaginated(ctx, "", 1)
 		if err != nil {
-			t.Fatalf("Failed to find users: %v", err)
+			t.Fatalf("Failed to list first page: %v", err)
+		}
+		if len(first.Items) != 1 || !first.HasMore || first.TotalCount < 2 {
+			t.Fatalf("Expected 1 user on first page of at least 2, got: %+v", first)
+		}
+
+		second, err := repo.ListPaginated(ctx, first.NextCursor, 1)
+		if err != nil {
+			t.Fatalf("Failed to list second page: %v", err)
+		}
+		if len(second.Items) != 1 {
+			t.Fatalf("Expected 1 user on second page, got: %d", len(second.Items))
 		}
 
-		if len(users) < 1 {
-			t.Errorf("Expected at least 1 user with pattern 'smith', got: %d", len(users))
+		if second.Items[0].ID <= first.Items[0].ID {
+			t.Errorf("Expected second page ID > %d, got: %d", first.Items[0].ID, second.Items[0].ID)
 		}
 	})
 
-	t.Run("Pattern Not Found", func(t *testing.T) {
-		users, err := repo.FindByNamePattern("NonExistentPattern")
+	t.Run("Past The End", func(t *testing.T) {
+		page, err := repo.ListPaginated(ctx, IDCursor(models.User{ID: missingID}).Encode(), 10)
 		if err != nil {
 			t.Fatalf("Expected no error, got: %v", err)
 		}
 
-		if len(users) != 0 {
-			t.Errorf("Expected 0 users, got: %d", len(users))
+		if page.Items == nil || len(page.Items) != 0 || page.HasMore {
+			t.Errorf("Expected empty non-nil slice, got: %+v", page)
 		}
 	})
 
-	t.Run("Partial Pattern Match", func(t *testing.T) {
-		// Should match both "Alice Smith" and "Bob Johnson" with pattern containing common letter
-		users, err := repo.FindByNamePattern("o")
+	t.Run("Invalid Cursor", func(t *testing.T) {
+		for _, cursor := range []string{"garbage!", "MDEy", RecentCursor(models.User{ID: 12}).Encode()} {
+			_, err := repo.ListPaginated(ctx, cursor, 10)
+			var repoErr *RepoError
+			if !errors.Is(err, pagination.ErrInvalidCursor) || !errors.As(err, &repoErr) {
+				t.Errorf("Expected a RepoError wrapping ErrInvalidCursor for %q, got: %v", cursor, err)
+			}
+		}
+	})
+}
+
+// TestFindByNamePattern tests finding users by name pattern
+func TestFindByNamePattern(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	fixtures.LoadYAMLFixtures(t, testDB, "testdata/users.yaml")
+
+	// emails collects the result emails for membership checks
+	emails := func(t *testing.T, pattern string) map[string]bool {
+		t.Helper()
+		users, err := repo.FindByNamePattern(ctx, pattern)
 		if err != nil {
 			t.Fatalf("Failed to find users: %v", err)
 		}
-
-		// Bob Johnson should be found
-		found := false
+		found := make(map[string]bool, len(users))
 		for _, user := range users {
-			if user.Email == "bob@example.com" {
-				found = true
-				break
+			found[user.Email] = true
+		}
+		return found
+	}
+
+	t.Run("Find Users By Pattern", func(t *testing.T) {
+		found := emails(t, "Marlowe")
+
+		if len(found) != 2 {
+			t.Errorf("Expected 2 users with pattern 'Marlowe', got: %d", len(found))
+		}
+		for _, email := range []string{"quentin.marlowe@example.com", "quilla.marlowe@example.com"} {
+			if !found[email] {
+				t.Errorf("Expected to find %s in results", email)
 			}
 		}
-		if !found {
-			t.Error("Expected to find bob@example.com in results")
+	})
+
+	t.Run("Find Users Case Insensitive", func(t *testing.T) {
+		found := emails(t, "marlowe")
+
+		if len(found) != 2 {
+			t.Errorf("Expected 2 users with pattern 'marlowe', got: %d", len(found))
+		}
+	})
+
+	t.Run("Pattern Not Found", func(t *testing.T) {
+		found := emails(t, "NonExistentPattern")
+
+		if len(found) != 0 {
+			t.Errorf("Expected 0 users, got: %d", len(found))
+		}
+	})
+
+	t.Run("Partial Pattern Match", func(t *testing.T) {
+		// "Qu" matches both Marlowes but not Rosalind Oakhurst
+		found := emails(t, "Qu")
+
+		if !found["quentin.marlowe@example.com"] || !found["quilla.marlowe@example.com"] {
+			t.Error("Expected to find both Marlowes in results")
+		}
+		if found["rosalind.oakhurst@example.com"] {
+			t.Error("Expected rosalind.oakhurst@example.com not to match 'Qu'")
 		}
 	})
 }
 
 // TestCountUsers tests counting total users
 func TestCountUsers(t *testing.T) {
-	repo := NewUserRepository(testDB)
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// Counts are table-wide, so they need a database no other test writes to
+	database := testContainer.CreateTestDatabase(ctx, t)
+	repo := NewUserRepository(database)
 
 	t.Run("Count Users", func(t *testing.T) {
-		count, err := repo.CountUsers()
+		count, err := repo.CountUsers(ctx)
 		if err != nil {
 			t.Fatalf("Failed to count users: %v", err)
 		}
 
-		// Should have at least 2 users from init.sql
+		// Should have at least the 2 seed users
 		if count < 2 {
 			t.Errorf("Expected at least 2 users, got: %d", count)
 		}
@@ -344,20 +588,18 @@ func TestCountUsers(t *testing.T) {
 
 	t.Run("Count After Creating User", func(t *testing.T) {
 		// Get initial count
-		initialCount, err := repo.CountUsers()
+		initialCount, err := repo.CountUsers(ctx)
 		if err != nil {
 			t.Fatalf("Failed to get initial count: %v", err)
 		}
 
 		// Create a new user
-		user, err := repo.Create("count.test@example.com", "Count Test")
-		if err != nil {
+		if _, err := repo.Create(ctx, fixtures.GenerateEmail(t), "Count Test"); err != nil {
 			t.Fatalf("Failed to create user: %v", err)
 		}
-		defer repo.Delete(user.ID)
 
 		// Count should increase by 1
-		newCount, err := repo.CountUsers()
+		newCount, err := repo.CountUsers(ctx)
 		if err != nil {
 			t.Fatalf("Failed to get new count: %v", err)
 		}
@@ -368,131 +610,214 @@ func TestCountUsers(t *testing.T) {
 	})
 }
 
-// TestGetRecentUsers tests retrieving recently created users
+// TestGetRecentUsers tests that the cutoff counts back from the repository's
+// clock. The clock stands in 2040, after every other test's users, so the
+// results are exactly the users seeded here.
 func TestGetRecentUsers(t *testing.T) {
-	repo := NewUserRepository(testDB)
-
-	t.Run("Get Recent Users Within Days", func(t *testing.T) {
-		// Create a fresh user (will have current timestamp)
-		user, err := repo.Create("recent@example.com", "Recent User")
-		if err != nil {
-			t.Fatalf("Failed to create user: %v", err)
-		}
-		defer repo.Delete(user.ID)
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	now := time.Date(2040, time.January, 10, 12, 0, 0, 0, time.UTC)
+	clock := testhelpers.NewFakeClock(now)
+	repo := NewUserRepository(testDB, WithClock(clock))
+
+	seeded := fixtures.SeedUsers(t, testDB,
+		fixtures.NewUser().WithCreatedAt(now.Add(-time.Hour)),
+		fixtures.NewUser().WithCreatedAt(now.AddDate(0, 0, -3)),
+		fixtures.NewUser().WithCreatedAt(now.AddDate(0, 0, -8)),
+	)
+	hourAgo, threeDaysAgo, eightDaysAgo := seeded[0].ID, seeded[1].ID, seeded[2].ID
 
-		// Get users from last 7 days
-		users, err := repo.GetRecentUsers(7)
+	// recent returns the IDs GetRecentUsers finds for days, in its order
+	recent := func(t *testing.T, days int) []int {
+		t.Helper()
+		page, err := repo.GetRecentUsers(ctx, days, "", 0)
 		if err != nil {
 			t.Fatalf("Failed to get recent users: %v", err)
 		}
+		users := page.Items
+		if users == nil {
+			t.Fatal("Expected a non-nil slice")
+		}
+		ids := make([]int, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
+		}
+		return ids
+	}
 
-		// Should find at least the user we just created
-		found := false
-		for _, u := range users {
-			if u.ID == user.ID {
-				found = true
-				break
+	for _, tt := range []struct {
+		name    string
+		advance time.Duration
+		days    int
+		want    []int
+	}{
+		{"Last Day", 0, 1, []int{hourAgo}},
+		{"Last Week Newest First", 0, 7, []int{hourAgo, threeDaysAgo}},
+		{"Last Ten Days", 0, 10, []int{hourAgo, threeDaysAgo, eightDaysAgo}},
+		{"Zero Days", 0, 0, []int{}},
+		// Three days later the hour-old user is three days old too
+		{"Last Day Later", 72 * time.Hour, 1, []int{}},
+		{"Last Four Days Later", 0, 4, []int{hourAgo}},
+		{"Last Week Later", 0, 7, []int{hourAgo, threeDaysAgo}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			clock.Advance(tt.advance)
+			if got := recent(t, tt.days); !slices.Equal(got, tt.want) {
+				t.Errorf("Expected users %v, got: %v", tt.want, got)
 			}
-		}
+		})
+	}
+}
 
-		if !found {
-			t.Error("Expected to find recently created user in results")
-		}
-	})
+// TestGetRecentUsersOptions tests GetRecentUsers' options alone and
+// combined, and ListFiltered taking the same ones, against users seeded at
+// known times in a database of their own
+func TestGetRecentUsersOptions(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	wipeUsers(ctx, t, db)
+	now := time.Date(2041, time.March, 10, 12, 0, 0, 0, time.UTC)
+	repo := NewUserRepository(db, WithClock(testhelpers.NewFakeClock(now)))
+	t.Cleanup(func() { repo.Close() })
+
+	user := func(email string, age time.Duration) *fixtures.UserBuilder {
+		return fixtures.NewUser().WithEmail(email).WithCreatedAt(now.Add(-age))
+	}
+	const day = 24 * time.Hour
+	seeded := fixtures.SeedUsers(t, db,
+		user("ann@opts.test", time.Hour),
+		user("bob@OPTS.test", 2*day),
+		user("cat@other.test", 3*day),
+		user("deb@opts.test", 4*day),
+		user("eli@opts.test", 20*day),
+	)
+	ann, bob, cat, deb, eli := seeded[0].ID, seeded[1].ID, seeded[2].ID, seeded[3].ID, seeded[4].ID
+	if _, err := db.ExecContext(ctx, "UPDATE users SET deleted_at = now() WHERE id = $1", deb); err != nil {
+		t.Fatalf("Failed to soft-delete user: %v", err)
+	}
 
-	t.Run("Get Recent Users Last 1 Day", func(t *testing.T) {
-		// Create a user
-		user, err := repo.Create("today@example.com", "Today User")
-		if err != nil {
-			t.Fatalf("Failed to create user: %v", err)
+	// idsOf returns the IDs of users in order
+	idsOf := func(users []models.User) []int {
+		ids := make([]int, len(users))
+		for i, u := range users {
+			ids[i] = u.ID
 		}
-		defer repo.Delete(user.ID)
+		return ids
+	}
 
-		// Get users from last 1 day
-		users, err := repo.GetRecentUsers(1)
-		if err != nil {
-			t.Fatalf("Failed to get recent users: %v", err)
-		}
+	// The last week holds ann, bob, cat, and the deleted deb; eli is older
+	for _, tt := range []struct {
+		name  string
+		limit int
+		opts  []QueryOption
+		want  []int
+		total int64
+	}{
+		{"No Options", 0, nil, []int{ann, bob, cat}, 3},
+		{"Ascending", 0, []QueryOption{WithAscending()}, []int{cat, bob, ann}, 3},
+		{"Domain", 0, []QueryOption{WithDomain("opts.TEST")}, []int{ann, bob}, 2},
+		{"Domain Ascending", 0, []QueryOption{WithDomain("opts.test"), WithAscending()}, []int{bob, ann}, 2},
+		{"Include Deleted", 0, []QueryOption{IncludeDeleted()}, []int{ann, bob, cat, deb}, 4},
+		{"Include Deleted Ascending", 0, []QueryOption{IncludeDeleted(), WithAscending()}, []int{deb, cat, bob, ann}, 4},
+		{"Include Deleted Domain", 0, []QueryOption{IncludeDeleted(), WithDomain("opts.test")}, []int{ann, bob, deb}, 3},
+		{"Limit", 0, []QueryOption{WithLimit(1)}, []int{ann}, 3},
+		{"Limit Overrides Argument", 1, []QueryOption{WithLimit(0)}, []int{ann, bob, cat}, 3},
+		{"Later Option Wins", 0, []QueryOption{WithLimit(1), WithLimit(2)}, []int{ann, bob}, 3},
+		{"All Combined", 0, []QueryOption{IncludeDeleted(), WithDomain("opts.test"), WithAscending(), WithLimit(2)}, []int{deb, bob}, 3},
+		{"Domain Partial Match", 0, []QueryOption{WithDomain("opts")}, []int{}, 0},
+		{"Domain Wildcard", 0, []QueryOption{WithDomain("%")}, []int{}, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := repo.GetRecentUsers(ctx, 7, "", tt.limit, tt.opts...)
+			if err != nil {
+				t.Fatalf("Failed to get recent users: %v", err)
+			}
+			if got := idsOf(page.Items); !slices.Equal(got, tt.want) || page.TotalCount != tt.total {
+				t.Errorf("Expected users %v of %d, got: %v of %d", tt.want, tt.total, got, page.TotalCount)
+			}
+		})
+	}
 
-		// Should find the user created today
-		found := false
-		for _, u := range users {
-			if u.ID == user.ID {
-				found = true
+	t.Run("Pages Ascending", func(t *testing.T) {
+		opts := []QueryOption{IncludeDeleted(), WithDomain("opts.test"), WithAscending()}
+		var walked []int
+		cursor := ""
+		for {
+			page, err := repo.GetRecentUsers(ctx, 7, cursor, 1, opts...)
+			if err != nil {
+				t.Fatalf("Failed to get page %d: %v", len(walked)+1, err)
+			}
+			walked = append(walked, idsOf(page.Items)...)
+			if !page.HasMore {
 				break
 			}
+			cursor = page.NextCursor
 		}
-
-		if !found {
-			t.Error("Expected to find today's user in 1-day results")
+		if want := []int{deb, bob, ann}; !slices.Equal(walked, want) {
+			t.Errorf("Expected the pages to walk %v, got: %v", want, walked)
 		}
 	})
 
-	t.Run("Get Recent Users Ordered By Date", func(t *testing.T) {
-		// Create two users
-		user1, err := repo.Create("first@example.com", "First User")
-		if err != nil {
-			t.Fatalf("Failed to create first user: %v", err)
-		}
-		defer repo.Delete(user1.ID)
-
-		user2, err := repo.Create("second@example.com", "Second User")
-		if err != nil {
-			t.Fatalf("Failed to create second user: %v", err)
-		}
-		defer repo.Delete(user2.ID)
-
-		// Get recent users
-		users, err := repo.GetRecentUsers(7)
-		if err != nil {
-			t.Fatalf("Failed to get recent users: %v", err)
-		}
-
-		// Users should be ordered by created_at DESC (newest first)
-		if len(users) >= 2 {
-			// The most recent users should appear first
-			// Check if user2 (created second) appears before or at same position as user1
-			foundUser2 := false
-			for _, u := range users {
-				if u.ID == user2.ID {
-					foundUser2 = true
-					break
+	t.Run("ListFiltered Shares Options", func(t *testing.T) {
+		for _, tt := range []struct {
+			name string
+			opts []QueryOption
+			want []int
+		}{
+			{"Domain", []QueryOption{WithDomain("opts.test")}, []int{ann, bob, eli}},
+			{"Include Deleted", []QueryOption{WithDomain("opts.test"), IncludeDeleted()}, []int{ann, bob, deb, eli}},
+			{"Limit", []QueryOption{WithDomain("opts.test"), WithLimit(2)}, []int{ann, bob}},
+		} {
+			t.Run(tt.name, func(t *testing.T) {
+				users, err := repo.ListFiltered(ctx, Filter{}, PageOpts{}, tt.opts...)
+				if err != nil {
+					t.Fatalf("Failed to list users: %v", err)
 				}
-				if u.ID == user1.ID && !foundUser2 {
-					// Found user1 before user2, which violates DESC order
-					// This is acceptable if they have same timestamp
-					break
+				if got := idsOf(users); !slices.Equal(got, tt.want) {
+					t.Errorf("Expected users %v, got: %v", tt.want, got)
 				}
-			}
+			})
 		}
 	})
 
-	t.Run("Get Recent Users Zero Results", func(t *testing.T) {
-		// Get users from last 0 days (should return empty or users created exactly now)
-		users, err := repo.GetRecentUsers(0)
+	t.Run("Negative Limit Before The Database", func(t *testing.T) {
+		// A closed pool fails any query, so only a check made first passes
+		closed, err := sql.Open("postgres", "")
 		if err != nil {
-			t.Fatalf("Failed to get recent users: %v", err)
+			t.Fatalf("Failed to open pool: %v", err)
 		}
+		closed.Close()
+		closedRepo := NewUserRepository(closed)
 
-		// Result should be a valid slice (could be empty)
-		if users == nil {
-			t.Error("Expected non-nil slice")
+		var verr *ValidationError
+		if _, err := closedRepo.GetRecentUsers(ctx, 7, "", 10, WithLimit(-1)); !errors.As(err, &verr) {
+			t.Errorf("Expected a ValidationError, got: %v", err)
+		}
+		if _, err := closedRepo.ListFiltered(ctx, Filter{}, PageOpts{}, WithLimit(-1)); !errors.As(err, &verr) {
+			t.Errorf("Expected a ValidationError from ListFiltered, got: %v", err)
 		}
 	})
 }
 
 func TestTransactionRollback(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
 	repo := NewUserRepository(testDB)
-
-	// Count users before
-	countBefore, _ := repo.CountUsers()
+	email := fixtures.GenerateEmail(t)
 
 	// Start a transaction that will fail
-	tx, _ := testDB.Begin()
+	tx, err := testDB.Begin()
+	if err != nil {
+		t.Fatalf("Failed to begin transaction: %v", err)
+	}
 
 	// Create user in transaction
-	_, err := tx.Exec("INSERT INTO users (email, name) VALUES ($1, $2)",
-		"tx@example.com", "TX User")
+	_, err = tx.Exec("INSERT INTO users (email, name) VALUES ($1, $2)",
+		email, "TX User")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -500,93 +825,120 @@ func TestTransactionRollback(t *testing.T) {
 	// Rollback transaction
 	tx.Rollback()
 
-	// Verify count is unchanged
-	countAfter, _ := repo.CountUsers()
-	if countAfter != countBefore {
-		t.Error("Transaction was not rolled back properly")
+	// Verify the user is gone; other tests may be changing the count meanwhile
+	if _, err := repo.GetByEmail(ctx, email); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Transaction was not rolled back properly, got: %v", err)
 	}
 }
 
 // ==================== TESTS WITH MULTIPLE INTERCONNECTED CONTAINERS ====================
-// TestCachedUserRepository tests the cached repository with PostgreSQL + Redis containers
-func TestCachedUserRepository(t *testing.T) {
-	ctx := context.Background()
-
-	// 🐳 START REDIS CONTAINER
-	redisContainer, err := redis.RunContainer(ctx,
-		testcontainers.WithImage("redis:7-alpine"),
-		testcontainers.WithWaitStrategy(
-			wait.ForLog("Ready to accept connections").
-				WithStartupTimeout(30*time.Second),
-		),
-	)
-	if err != nil {
-		t.Fatalf("Failed to start Redis container: %s", err)
+// cachedBackends returns the database, its DSN, and Redis for
+// TestCachedUserRepository: testDB and a fresh Redis container, or with
+// TEST_COMPOSE_FILE set, the postgres and redis services of that compose stack
+func cachedBackends(ctx context.Context, t *testing.T) (*sql.DB, string, *redis.Client) {
+	t.Helper()
+
+	composeFile := testhelpers.ComposeFile()
+	if composeFile == "" {
+		return testDB, testContainer.ConnStr, testhelpers.StartRedis(ctx, t)
 	}
-	defer redisContainer.Terminate(ctx)
 
-	// Get Redis endpoint
-	redisHost, err := redisContainer.Host(ctx)
-	if err != nil {
-		t.Fatalf("Failed to get Redis host: %s", err)
-	}
+	stack := testhelpers.StartCompose(ctx, t, composeFile)
 
-	redisPort, err := redisContainer.MappedPort(ctx, "6379/tcp")
+	// Credentials as in docker-compose.yml
+	dsn := fmt.Sprintf("postgres://testuser:testpass@%s/testdb?sslmode=disable", stack.Endpoint(t, "postgres"))
+	conn, err := db.Connect(ctx, dsn)
 	if err != nil {
-		t.Fatalf("Failed to get Redis port: %s", err)
+		t.Fatalf("Failed to connect to compose postgres: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := migrations.RunMigrations(ctx, conn); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	if err := migrations.Seed(ctx, conn); err != nil {
+		t.Fatalf("Failed to seed database: %v", err)
 	}
 
-	// Connect to Redis
-	redisClient := redis2.NewClient(&redis2.Options{
-		Addr: fmt.Sprintf("%s:%s", redisHost, redisPort.Port()),
-	})
-	defer redisClient.Close()
+	client := redis.NewClient(&redis.Options{Addr: stack.Endpoint(t, "redis")})
+	t.Cleanup(func() { client.Close() })
+
+	return conn, dsn, client
+}
 
-	// Test Redis connection
-	if err := redisClient.Ping(ctx).Err(); err != nil {
-		t.Fatalf("Failed to ping Redis: %s", err)
+// TestUserCacheKey pins the Redis key format; entries cached by other
+// versions are only found while it stays the same, and every key of a
+// tenant starts with its prefix, which InvalidateAll scans for
+func TestUserCacheKey(t *testing.T) {
+	t.Parallel()
+	if got := userCacheKey("acme", 42); got != "user:acme:42" {
+		t.Errorf("Expected user:acme:42, got: %s", got)
+	}
+	if got := emailCacheKey("acme", "alice@example.com"); got != "user:acme:email:alice@example.com" {
+		t.Errorf("Expected user:acme:email:alice@example.com, got: %s", got)
+	}
+	if got := tenantKeyPrefix("acme"); got != "user:acme:" {
+		t.Errorf("Expected user:acme:, got: %s", got)
 	}
+}
 
-	log.Println("✅ Redis container ready!")
+// TestCachedUserRepository tests the cached repository with PostgreSQL + Redis
+// containers, or the composed stack when TEST_COMPOSE_FILE is set
+func TestCachedUserRepository(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	database, dsn, redisClient := cachedBackends(ctx, t)
 
-	// Create cached repository (uses existing testDB from TestMain)
-	cachedRepo := NewCachedUserRepository(testDB, redisClient)
+	// Create cached repository, on a pool that counts the statements it sends
+	spy := sqlspy.New()
+	spied, err := spy.Open(dsn)
+	if err != nil {
+		t.Fatalf("Failed to open spied database: %v", err)
+	}
+	t.Cleanup(func() { spied.Close() })
+	cachedRepo := NewCachedUserRepository(spied, redisClient)
+	users := fixtures.SeedUsers(t, database, fixtures.NewUser(), fixtures.NewUser())
+	first, second := users[0], users[1]
+	firstKey := fmt.Sprintf("user:default:%d", first.ID)
 
 	t.Run("Cache Miss - Fetch From Database", func(t *testing.T) {
 		// Clear cache first
-		cachedRepo.InvalidateCache(ctx, 1)
+		cachedRepo.InvalidateCache(ctx, first.ID)
 
 		// First call should fetch from database
-		user, err := cachedRepo.GetByIDCached(ctx, 1)
+		user, err := cachedRepo.GetByIDCached(ctx, first.ID)
 		if err != nil {
 			t.Fatalf("Failed to get user: %v", err)
 		}
 
-		if user.Email != "alice@example.com" {
-			t.Errorf("Expected email 'alice@example.com', got: %s", user.Email)
+		if user.Email != first.Email {
+			t.Errorf("Expected email '%s', got: %s", first.Email, user.Email)
 		}
 	})
 
 	t.Run("Cache Hit - Fetch From Redis", func(t *testing.T) {
 		// First call to populate cache
-		_, err := cachedRepo.GetByIDCached(ctx, 1)
+		_, err := cachedRepo.GetByIDCached(ctx, first.ID)
 		if err != nil {
 			t.Fatalf("Failed to populate cache: %v", err)
 		}
 
-		// Second call should hit cache
-		user, err := cachedRepo.GetByIDCached(ctx, 1)
+		// Second call should hit cache, without querying the database
+		spy.Reset()
+		user, err := cachedRepo.GetByIDCached(ctx, first.ID)
 		if err != nil {
 			t.Fatalf("Failed to get cached user: %v", err)
 		}
+		if n := spy.Count(""); n != 0 {
+			t.Errorf("Expected no database queries on a warm cache, got %d: %v", n, spy.Statements())
+		}
 
-		if user.Email != "alice@example.com" {
-			t.Errorf("Expected email 'alice@example.com', got: %s", user.Email)
+		if user.Email != first.Email {
+			t.Errorf("Expected email '%s', got: %s", first.Email, user.Email)
 		}
 
 		// Verify the data is actually in Redis
-		cacheKey := fmt.Sprintf("user:%d", 1)
-		cached, err := redisClient.Get(ctx, cacheKey).Result()
+		cached, err := redisClient.Get(ctx, firstKey).Result()
 		if err != nil {
 			t.Errorf("Expected user to be in cache, got error: %v", err)
 		}
@@ -597,31 +949,31 @@ func TestCachedUserRepository(t *testing.T) {
 
 	t.Run("Cache Invalidation", func(t *testing.T) {
 		// Populate cache
-		cachedRepo.GetByIDCached(ctx, 1)
+		cachedRepo.GetByIDCached(ctx, first.ID)
 
 		// Invalidate cache
-		err := cachedRepo.InvalidateCache(ctx, 1)
+		err := cachedRepo.InvalidateCache(ctx, first.ID)
 		if err != nil {
 			t.Fatalf("Failed to invalidate cache: %v", err)
 		}
 
 		// Verify cache is empty
-		cacheKey := fmt.Sprintf("user:%d", 1)
-		_, err = redisClient.Get(ctx, cacheKey).Result()
+		_, err = redisClient.Get(ctx, firstKey).Result()
 		if err == nil {
 			t.Error("Expected cache to be empty after invalidation")
 		}
 	})
 
 	t.Run("Create User With Cache", func(t *testing.T) {
-		user, err := cachedRepo.CreateCached(ctx, "cached@example.com", "Cached User")
+		email := fixtures.GenerateEmail(t)
+		user, err := cachedRepo.CreateCached(ctx, email, "Cached User")
 		if err != nil {
 			t.Fatalf("Failed to create user: %v", err)
 		}
-		defer testDB.Exec("DELETE FROM users WHERE id = $1", user.ID)
+		t.Cleanup(func() { database.Exec("DELETE FROM users WHERE id = $1", user.ID) })
 
-		if user.Email != "cached@example.com" {
-			t.Errorf("Expected email 'cached@example.com', got: %s", user.Email)
+		if user.Email != email {
+			t.Errorf("Expected email '%s', got: %s", email, user.Email)
 		}
 
 		// Fetch from cache
@@ -636,7 +988,7 @@ func TestCachedUserRepository(t *testing.T) {
 	})
 
 	t.Run("User Not Found", func(t *testing.T) {
-		_, err := cachedRepo.GetByIDCached(ctx, 99999)
+		_, err := cachedRepo.GetByIDCached(ctx, missingID)
 		if err == nil {
 			t.Fatal("Expected error for non-existent user, got nil")
 		}
@@ -644,23 +996,22 @@ func TestCachedUserRepository(t *testing.T) {
 
 	t.Run("Cache Expiration Simulation", func(t *testing.T) {
 		// Populate cache
-		user, err := cachedRepo.GetByIDCached(ctx, 1)
+		user, err := cachedRepo.GetByIDCached(ctx, first.ID)
 		if err != nil {
 			t.Fatalf("Failed to get user: %v", err)
 		}
 
 		// Verify cache exists
-		cacheKey := fmt.Sprintf("user:%d", 1)
-		_, cacheErr := redisClient.Get(ctx, cacheKey).Result()
+		_, cacheErr := redisClient.Get(ctx, firstKey).Result()
 		if cacheErr != nil {
 			t.Fatalf("Expected cached data: %v", cacheErr)
 		}
 
 		// Manually delete from Redis to simulate expiration
-		redisClient.Del(ctx, cacheKey)
+		redisClient.Del(ctx, firstKey)
 
 		// Should still work (fetch from DB)
-		user2, err := cachedRepo.GetByIDCached(ctx, 1)
+		user2, err := cachedRepo.GetByIDCached(ctx, first.ID)
 		if err != nil {
 			t.Fatalf("Failed to get user after cache expiration: %v", err)
 		}
@@ -672,15 +1023,12 @@ func TestCachedUserRepository(t *testing.T) {
 
 	t.Run("Multiple Cache Entries", func(t *testing.T) {
 		// Cache multiple users
-		cachedRepo.GetByIDCached(ctx, 1)
-		cachedRepo.GetByIDCached(ctx, 2)
+		cachedRepo.GetByIDCached(ctx, first.ID)
+		cachedRepo.GetByIDCached(ctx, second.ID)
 
 		// Verify both are cached
-		key1 := fmt.Sprintf("user:%d", 1)
-		key2 := fmt.Sprintf("user:%d", 2)
-
-		_, err1 := redisClient.Get(ctx, key1).Result()
-		_, err2 := redisClient.Get(ctx, key2).Result()
+		_, err1 := redisClient.Get(ctx, firstKey).Result()
+		_, err2 := redisClient.Get(ctx, fmt.Sprintf("user:default:%d", second.ID)).Result()
 
 		if err1 != nil || err2 != nil {
 			t.Error("Expected both users to be cached")
@@ -688,4 +1036,280 @@ func TestCachedUserRepository(t *testing.T) {
 	})
 }
 
+// recordingPublisher records the events it is given, failing with err
+type recordingPublisher struct {
+	mu     sync.Mutex
+	events []notifications.Event
+	err    error
+}
+
+func (p *recordingPublisher) Publish(_ context.Context, event notifications.Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.events = append(p.events, event)
+	return p.err
+}
+
+// TestCachedWritesPublish tests that UpdateCached and DeleteCached keep the
+// cache consistent, and that each cached write publishes one event
+func TestCachedWritesPublish(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	database, _, redisClient := cachedBackends(ctx, t)
+	publisher := &recordingPublisher{}
+	cachedRepo := NewCachedUserRepository(database, redisClient, WithPublisher(publisher))
+
+	email := fixtures.GenerateEmail(t)
+	user, err := cachedRepo.CreateCached(ctx, email, "Published User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// Cache the old row, so a stale read after the update would show
+	if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+	if err := cachedRepo.UpdateCached(ctx, user.ID, email, "Renamed User"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	got, err := cachedRepo.GetByIDCached(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if got.Name != "Renamed User" {
+		t.Errorf("Expected the updated name, got: %s", got.Name)
+	}
 
+	if err := cachedRepo.DeleteCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to delete user: %v", err)
+	}
+	if _, err := cachedRepo.GetByIDCached(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound after delete, got: %v", err)
+	}
+	if err := cachedRepo.DeleteCached(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound deleting twice, got: %v", err)
+	}
+
+	want := []models.EventType{models.EventUserCreated, models.EventUserUpdated, models.EventUserDeleted}
+	if len(publisher.events) != len(want) {
+		t.Fatalf("Expected %d events, got: %+v", len(want), publisher.events)
+	}
+	for i, event := range publisher.events {
+		if event.Type != want[i] || event.UserID != user.ID {
+			t.Errorf("Expected event %d to be %s for user %d, got: %s for user %d", i, want[i], user.ID, event.Type, event.UserID)
+		}
+	}
+
+	t.Run("Publish Failure", func(t *testing.T) {
+		publisher.err = errors.New("topic unavailable")
+		defer func() { publisher.err = nil }()
+
+		user, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Unpublished User")
+		if err == nil || !errors.Is(err, publisher.err) {
+			t.Fatalf("Expected the publish error, got: %v", err)
+		}
+		// The row was still written: the error only reports the lost event
+		if user == nil {
+			t.Fatal("Expected the created user alongside the publish error")
+		}
+		repo := NewUserRepository(database)
+		t.Cleanup(func() { repo.Close() })
+		if _, err := repo.GetByID(ctx, user.ID); err != nil {
+			t.Errorf("Expected the user to exist, got: %v", err)
+		}
+	})
+}
+
+// TestCachedUserRepositoryRefreshAhead tests stale-while-revalidate on hot
+// keys. The clock, not a sleep, brings the key close to expiry; Redis' own
+// TTL is long enough never to run out during the test.
+func TestCachedUserRepositoryRefreshAhead(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+
+	ttl := time.Minute
+	clock := testhelpers.NewFakeClock(time.Now())
+	cachedRepo := NewCachedUserRepository(testDB, redisClient,
+		WithCacheTTL(ttl),
+		WithRefreshAhead(ttl/5),
+		WithCachedClock(clock),
+	)
+
+	user, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Refresh Ahead")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, user.ID)
+	cacheKey := userCacheKey(DefaultTenant, user.ID)
+
+	// Populate the cache
+	if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to populate cache: %v", err)
+	}
+
+	// Change the row behind the cache's back so we can tell which copy a read returns
+	if _, err := testDB.Exec("UPDATE users SET name = $1 WHERE id = $2", "Refreshed", user.ID); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	// With more than the threshold left, a hit doesn't refresh
+	clock.Advance(ttl / 2)
+	if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+		t.Fatalf("Failed to get cached user: %v", err)
+	}
+	if cached, err := redisClient.Get(ctx, cacheKey).Result(); err != nil || strings.Contains(cached, "Refreshed") {
+		t.Fatalf("Expected the key not refreshed yet, got: %s, %v", cached, err)
+	}
+
+	// Let the key get close to expiry (remaining TTL below the threshold)
+	clock.Advance(ttl/2 - ttl/10)
+
+	// The read is served from the stale cached copy rather than waiting on the DB
+	stale, err := cachedRepo.GetByIDCached(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get cached user: %v", err)
+	}
+	if stale.Name != "Refresh Ahead" {
+		t.Errorf("Expected stale cached name 'Refresh Ahead', got: %s", stale.Name)
+	}
+
+	// The background refresh rewrites the key with the fresh value
+	deadline := time.Now().Add(time.Second)
+	for {
+		cached, err := redisClient.Get(ctx, cacheKey).Result()
+		if err != nil {
+			t.Fatalf("Failed to read cached user: %v", err)
+		}
+		if strings.Contains(cached, "Refreshed") {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the key rewritten with the new name, got: %s", cached)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	fresh, err := cachedRepo.GetByIDCached(ctx, user.ID)
+	if err != nil {
+		t.Fatalf("Failed to get refreshed user: %v", err)
+	}
+	if fresh.Name != "Refreshed" {
+		t.Errorf("Expected refreshed name 'Refreshed', got: %s", fresh.Name)
+	}
+}
+
+// TestCachedReadsMatchUncached tests that every cached read, from Postgres
+// on a miss and from Redis on a hit, returns a user deeply equal to
+// UserRepository's, detail columns included. The cached repository runs its
+// statements through a UserRepository, so a column added to one is read by
+// both.
+func TestCachedReadsMatchUncached(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+	cachedRepo := NewCachedUserRepository(testDB, testhelpers.StartRedis(ctx, t))
+
+	created, err := repo.CreateWithRole(ctx, fixtures.GenerateEmail(t), "Uncached Twin", models.RoleGuest)
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, created.ID)
+	// A version past 1 and an avatar key, so every detail column is set
+	if err := repo.SetAvatarKey(ctx, created.ID, "avatars/twin.png"); err != nil {
+		t.Fatalf("Failed to set avatar key: %v", err)
+	}
+	want, err := repo.GetByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if want.Version < 2 || want.AvatarKey == "" {
+		t.Fatalf("Expected every detail column set, got: %+v", want)
+	}
+	byEmail, err := repo.GetByEmail(ctx, want.Email)
+	if err != nil {
+		t.Fatalf("Failed to get user by email: %v", err)
+	}
+	if !reflect.DeepEqual(byEmail, want) {
+		t.Fatalf("Expected GetByEmail to match GetByID, got: %+v, want: %+v", byEmail, want)
+	}
+
+	reads := []struct {
+		name string
+		read func() (*models.User, error)
+	}{
+		{"GetByIDCached", func() (*models.User, error) {
+			return cachedRepo.GetByIDCached(ctx, want.ID)
+		}},
+		{"GetByEmailCached", func() (*models.User, error) {
+			return cachedRepo.GetByEmailCached(ctx, want.Email)
+		}},
+		{"GetByIDsCached", func() (*models.User, error) {
+			users, err := cachedRepo.GetByIDsCached(ctx, []int{want.ID})
+			if err != nil || len(users) != 1 {
+				return nil, fmt.Errorf("expected one user, got %d: %v", len(users), err)
+			}
+			return &users[0], nil
+		}},
+	}
+	for _, read := range reads {
+		t.Run(read.name, func(t *testing.T) {
+			if err := cachedRepo.InvalidateCache(ctx, want.ID); err != nil {
+				t.Fatalf("Failed to invalidate cache: %v", err)
+			}
+			for _, from := range []string{"miss", "hit"} {
+				got, err := read.read()
+				if err != nil {
+					t.Fatalf("Failed to read user on a %s: %v", from, err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("Expected the uncached user on a %s, got: %+v, want: %+v", from, got, want)
+				}
+			}
+		})
+	}
+}
+
+// TestResetDB tests that ResetDB discards rows written by an earlier test.
+// Restoring the snapshot replaces testDB's database under every other test,
+// so unlike the rest of the suite it must not run in parallel; Go runs it
+// before resuming the parallel tests.
+func TestResetDB(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	testContainer.ResetDB(t)
+	repo := NewUserRepository(testDB)
+
+	user, err := repo.Create(ctx, "leaked@example.com", "Leaked User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+
+	// Simulate the next top-level test starting without any cleanup
+	testContainer.ResetDB(t)
+
+	if _, err := repo.GetByID(ctx, user.ID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("Expected ErrUserNotFound for leaked user after reset, got: %v", err)
+	}
+
+	count, err := repo.CountUsers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to count users: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected only the 2 seed users after reset, got: %d", count)
+	}
+
+	// The seed data itself is intact, IDs included
+	alice, err := repo.GetByID(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to get seed user: %v", err)
+	}
+	if alice.Email != "alice@example.com" {
+		t.Errorf("Expected user 1 to be alice@example.com, got: %s", alice.Email)
+	}
+}
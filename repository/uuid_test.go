@@ -0,0 +1,78 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+
+	"github.com/google/uuid"
+)
+
+// TestUUID tests the public UUID alongside the integer ID
+func TestUUID(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+
+	email := fixtures.GenerateEmail(t)
+	created, err := repo.Create(ctx, email, "UUID User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, created.ID)
+
+	t.Run("Assigned On Create", func(t *testing.T) {
+		if created.UUID == uuid.Nil {
+			t.Fatal("Expected a UUID for the created user")
+		}
+		other, err := repo.GetByID(ctx, newUser(t).ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if other.UUID == uuid.Nil || other.UUID == created.UUID {
+			t.Errorf("Expected other users to get distinct UUIDs, got: %s", other.UUID)
+		}
+	})
+
+	t.Run("Get By UUID", func(t *testing.T) {
+		user, err := repo.GetByUUID(ctx, created.UUID)
+		if err != nil {
+			t.Fatalf("Failed to get user by UUID: %v", err)
+		}
+		if user.ID != created.ID || user.Email != email {
+			t.Errorf("Expected user %d, got: %+v", created.ID, user)
+		}
+	})
+
+	t.Run("Unknown UUID", func(t *testing.T) {
+		_, err := repo.GetByUUID(ctx, uuid.New())
+		if !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
+
+// TestParseUUID tests that malformed UUIDs fail with a typed error
+func TestParseUUID(t *testing.T) {
+	t.Parallel()
+	valid := uuid.New()
+	if got, err := ParseUUID(valid.String()); err != nil || got != valid {
+		t.Errorf("Expected %s to parse, got: %s, %v", valid, got, err)
+	}
+
+	for _, input := range []string{"", "42", "not-a-uuid", "123e4567-e89b-12d3-a456-42661417400", "123e4567-e89b-12d3-a456-42661417400zz"} {
+		_, err := ParseUUID(input)
+		var invalid *InvalidUUIDError
+		if !errors.As(err, &invalid) {
+			t.Errorf("ParseUUID(%q): expected *InvalidUUIDError, got: %v", input, err)
+			continue
+		}
+		if invalid.Input != input {
+			t.Errorf("Expected Input %q, got: %q", input, invalid.Input)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"net/mail"
+	"strings"
+	"unicode/utf8"
+
+	"testcontainers-demo/models"
+)
+
+// Column limits from the users table (VARCHAR(255))
+const (
+	maxEmailLength = 255
+	maxNameLength  = 255
+)
+
+// Password limits; bcrypt rejects passwords longer than 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordBytes  = 72
+)
+
+// roleFieldError reports a role outside models.Roles
+var roleFieldError = FieldError{Field: "role", Message: "must be one of admin, member, guest"}
+
+// CreateUserInput is the user data accepted by Create, CreateCached, and Update
+type CreateUserInput struct {
+	Email string
+	Name  string
+	Role  models.Role // empty means models.RoleMember
+}
+
+// FieldError describes why one field was rejected
+type FieldError struct {
+	Field   string // "email", "name", "role", or "password"
+	Message string
+}
+
+// ValidationError lists every invalid field of an input. It is returned,
+// wrapped in a RepoError, before any SQL runs.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error formats as "invalid input: email: ...; name: ..."
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "invalid input: " + strings.Join(parts, "; ")
+}
+
+// Normalized returns the input as it gets validated and stored: surrounding
+// whitespace removed, the email lowercased, and the role defaulted
+func (in CreateUserInput) Normalized() CreateUserInput {
+	role := in.Role
+	if role == "" {
+		role = models.RoleMember
+	}
+	return CreateUserInput{
+		Email: NormalizeEmail(in.Email),
+		Name:  strings.TrimSpace(in.Name),
+		Role:  role,
+	}
+}
+
+// NormalizeEmail is the form emails are stored, looked up, and cached under;
+// the lower(email) unique index enforces it in the database too
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// Validate checks the normalized input: the email must be a bare address
+// net/mail can parse, the name 1-255 characters, and the role one of
+// models.Roles. It returns a *ValidationError listing every failing field,
+// or nil.
+func (in CreateUserInput) Validate() error {
+	in = in.Normalized()
+
+	var fields []FieldError
+	if msg := validateEmail(in.Email); msg != "" {
+		fields = append(fields, FieldError{Field: "email", Message: msg})
+	}
+	switch n := utf8.RuneCountInString(in.Name); {
+	case n == 0:
+		fields = append(fields, FieldError{Field: "name", Message: "is required"})
+	case n > maxNameLength:
+		fields = append(fields, FieldError{Field: "name", Message: "must be at most 255 characters"})
+	}
+	if !in.Role.Valid() {
+		fields = append(fields, roleFieldError)
+	}
+
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// ValidateRole returns a *ValidationError for a role outside models.Roles,
+// or nil
+func ValidateRole(role models.Role) error {
+	if !role.Valid() {
+		return &ValidationError{Fields: []FieldError{roleFieldError}}
+	}
+	return nil
+}
+
+// validateEmail returns why email is invalid, or "" if it's fine
+func validateEmail(email string) string {
+	if email == "" {
+		return "is required"
+	}
+	if utf8.RuneCountInString(email) > maxEmailLength {
+		return "must be at most 255 characters"
+	}
+	// ParseAddress also accepts "Name <addr>"; only the bare address is allowed
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || addr.Name != "" {
+		return "must be a valid email address"
+	}
+	if !strings.Contains(email[strings.LastIndex(email, "@")+1:], ".") {
+		return "must be a valid email address"
+	}
+	return ""
+}
+
+// validatePassword returns why password is unacceptable, or "" if it's fine
+func validatePassword(password string) string {
+	switch {
+	case utf8.RuneCountInString(password) < minPasswordLength:
+		return "must be at least 8 characters"
+	case len(password) > maxPasswordBytes:
+		return "must be at most 72 bytes"
+	}
+	return ""
+}
+
+// validated returns in normalized, with the error from Validate
+func validated(in CreateUserInput) (CreateUserInput, error) {
+	in = in.Normalized()
+	return in, in.Validate()
+}
@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/fixtures"
+)
+
+// invalidInputs are rejected by CreateUserInput.Validate, with the fields that fail
+var invalidInputs = []struct {
+	name   string
+	input  CreateUserInput
+	fields []string
+}{
+	{"Empty", CreateUserInput{}, []string{"email", "name"}},
+	{"Whitespace Only", CreateUserInput{Email: "   ", Name: "\t\n"}, []string{"email", "name"}},
+	{"Missing Name", CreateUserInput{Email: "valid@example.com"}, []string{"name"}},
+	{"Missing Email", CreateUserInput{Name: "No Email"}, []string{"email"}},
+	{"No At Sign", CreateUserInput{Email: "not-an-email", Name: "Bad"}, []string{"email"}},
+	{"No Local Part", CreateUserInput{Email: "@example.com", Name: "Bad"}, []string{"email"}},
+	{"No Domain", CreateUserInput{Email: "user@", Name: "Bad"}, []string{"email"}},
+	{"Dotless Domain", CreateUserInput{Email: "user@localhost", Name: "Bad"}, []string{"email"}},
+	{"Display Name", CreateUserInput{Email: "Bad <bad@example.com>", Name: "Bad"}, []string{"email"}},
+	{"Two At Signs", CreateUserInput{Email: "a@b@example.com", Name: "Bad"}, []string{"email"}},
+	{"Inner Space", CreateUserInput{Email: "bad user@example.com", Name: "Bad"}, []string{"email"}},
+	{"Email Too Long", CreateUserInput{Email: strings.Repeat("a", 250) + "@example.com", Name: "Bad"}, []string{"email"}},
+	{"Name Too Long", CreateUserInput{Email: "long@example.com", Name: strings.Repeat("n", 256)}, []string{"name"}},
+	{"Huge Name", CreateUserInput{Email: "huge@example.com", Name: strings.Repeat("n", 10<<20)}, []string{"name"}},
+}
+
+// TestCreateUserInputValidate tests which inputs are accepted and that every
+// failing field is reported
+func TestCreateUserInputValidate(t *testing.T) {
+	t.Parallel()
+	for _, tc := range invalidInputs {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.input.Validate()
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("Expected *ValidationError, got: %v", err)
+			}
+
+			var fields []string
+			for _, f := range validationErr.Fields {
+				fields = append(fields, f.Field)
+			}
+			if !reflect.DeepEqual(fields, tc.fields) {
+				t.Errorf("Expected failing fields %v, got: %v", tc.fields, fields)
+			}
+		})
+	}
+
+	t.Run("Valid", func(t *testing.T) {
+		for _, in := range []CreateUserInput{
+			{Email: "alice@example.com", Name: "Alice"},
+			{Email: "  padded@example.com  ", Name: "  Padded  "},
+			{Email: "first.last+tag@sub.example.co.uk", Name: strings.Repeat("n", 255)},
+			{Email: "unicode@example.com", Name: "Zoë Ångström"},
+			{Email: " Mixed.Case@Example.COM ", Name: "Mixed Case"},
+		} {
+			if err := in.Validate(); err != nil {
+				t.Errorf("Expected %+v to be valid, got: %v", in, err)
+			}
+		}
+	})
+}
+
+// TestValidationBeforeSQL tests that invalid input never reaches the database
+func TestValidationBeforeSQL(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+
+	// An empty match counts every statement sent to the driver
+	faults := &faultInjector{}
+	db := openFaultyDB(t, faults)
+	repo := NewUserRepository(db, WithoutPreparedStatements())
+	cachedRepo := NewCachedUserRepository(db, nil)
+
+	for _, tc := range invalidInputs {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := map[string]func() error{
+				"Create": func() error {
+					_, err := repo.Create(ctx, tc.input.Email, tc.input.Name)
+					return err
+				},
+				"Update": func() error {
+					return repo.Update(ctx, missingID, tc.input.Email, tc.input.Name)
+				},
+				"CreateCached": func() error {
+					_, err := cachedRepo.CreateCached(ctx, tc.input.Email, tc.input.Name)
+					return err
+				},
+			}
+			for method, call := range calls {
+				err := call()
+				var validationErr *ValidationError
+				if !errors.As(err, &validationErr) {
+					t.Errorf("%s: expected *ValidationError, got: %v", method, err)
+				}
+			}
+		})
+	}
+
+	if got := faults.attempts(); got != 0 {
+		t.Errorf("Expected no statements to reach the database, got: %d", got)
+	}
+
+	t.Run("Stores Trimmed Values", func(t *testing.T) {
+		email := fixtures.GenerateEmail(t)
+		user, err := repo.Create(ctx, "  "+email+" ", " Trimmed User\n")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		deleteOnCleanup(t, user.ID)
+		if user.Email != email || user.Name != "Trimmed User" {
+			t.Errorf("Expected trimmed email and name, got: %q and %q", user.Email, user.Name)
+		}
+		if got := faults.attempts(); got != 1 {
+			t.Errorf("Expected one statement for a valid create, got: %d", got)
+		}
+	})
+}
+
+// TestEmailNormalization tests that emails differing only by case or
+// surrounding whitespace are one user
+func TestEmailNormalization(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+
+	email := fixtures.GenerateEmail(t)
+	created, err := repo.Create(ctx, " "+strings.ToUpper(email)+" ", "Mixed Case")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	deleteOnCleanup(t, created.ID)
+	if created.Email != email {
+		t.Errorf("Expected stored email %q, got: %s", email, created.Email)
+	}
+
+	t.Run("Lookup With Other Casing", func(t *testing.T) {
+		user, err := repo.GetByEmail(ctx, strings.ToUpper(email[:1])+email[1:])
+		if err != nil {
+			t.Fatalf("Failed to get user by email: %v", err)
+		}
+		if user.ID != created.ID {
+			t.Errorf("Expected user %d, got: %d", created.ID, user.ID)
+		}
+	})
+
+	t.Run("Duplicate By Case", func(t *testing.T) {
+		_, err := repo.Create(ctx, strings.ToUpper(email), "Another Mixed Case")
+		if !errors.Is(err, ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+	})
+
+	t.Run("Update Normalizes", func(t *testing.T) {
+		renamed := fixtures.GenerateEmail(t)
+		if err := repo.Update(ctx, created.ID, strings.ToUpper(renamed), "Mixed Case"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		user, err := repo.GetByID(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to get user: %v", err)
+		}
+		if user.Email != renamed {
+			t.Errorf("Expected stored email %q, got: %s", renamed, user.Email)
+		}
+	})
+
+	t.Run("Constraint Matches Code", func(t *testing.T) {
+		// Bypasses normalization, as a row written before it would have
+		_, err := testDB.ExecContext(ctx, "INSERT INTO users (email, name) VALUES ($1, $2)", strings.ToUpper(newUser(t).Email), "Raw Duplicate")
+		if !isUniqueViolation(err) {
+			t.Errorf("Expected the lower(email) index to reject a case-only duplicate, got: %v", err)
+		}
+	})
+}
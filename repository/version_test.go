@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"testcontainers-demo/testhelpers"
+)
+
+// TestUpdateWithVersion tests that of two writers holding the same version,
+// only the first gets through
+func TestUpdateWithVersion(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+	id := newUser(t).ID
+
+	a, err := repo.GetByID(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	b := *a
+	if a.Version < 1 {
+		t.Fatalf("Expected a version, got: %d", a.Version)
+	}
+
+	a.Name = "Writer A"
+	if err := repo.UpdateWithVersion(ctx, a); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+	b.Name = "Writer B"
+	if err := repo.UpdateWithVersion(ctx, &b); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got: %v", err)
+	}
+
+	got, err := repo.GetByID(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if got.Name != "Writer A" || got.Version != a.Version || a.Version != b.Version+1 {
+		t.Errorf("Expected Writer A at version %d, got: %s at %d", b.Version+1, got.Name, got.Version)
+	}
+
+	t.Run("Plain Update Bumps Version", func(t *testing.T) {
+		if err := repo.Update(ctx, id, got.Email, "Unversioned"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		// got was read before the plain update, so it is stale now
+		if err := repo.UpdateWithVersion(ctx, got); !errors.Is(err, ErrVersionConflict) {
+			t.Errorf("Expected ErrVersionConflict, got: %v", err)
+		}
+	})
+
+	t.Run("Missing User", func(t *testing.T) {
+		missing := *got
+		missing.ID = -1
+		if err := repo.UpdateWithVersion(ctx, &missing); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+}
+
+// TestUpdateWithVersionCached tests that a stale cached copy is refused and
+// dropped from the cache, so the next read sees the current version
+func TestUpdateWithVersionCached(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	cachedRepo := NewCachedUserRepository(testDB, testhelpers.StartRedis(ctx, t))
+	repo := NewUserRepository(testDB)
+	t.Cleanup(func() { repo.Close() })
+	id := newUser(t).ID
+
+	stale, err := cachedRepo.GetByIDCached(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	// A write that bypasses the cache leaves the cached copy behind
+	if err := repo.Update(ctx, id, stale.Email, "Changed Behind The Cache"); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	stale.Name = "From Stale Copy"
+	if err := cachedRepo.UpdateWithVersionCached(ctx, stale); !errors.Is(err, ErrVersionConflict) {
+		t.Fatalf("Expected ErrVersionConflict, got: %v", err)
+	}
+
+	fresh, err := cachedRepo.GetByIDCached(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if fresh.Version != stale.Version+1 {
+		t.Fatalf("Expected the conflict to drop the cached copy, got version %d", fresh.Version)
+	}
+	fresh.Name = "From Fresh Copy"
+	if err := cachedRepo.UpdateWithVersionCached(ctx, fresh); err != nil {
+		t.Fatalf("Failed to update user: %v", err)
+	}
+
+	got, err := cachedRepo.GetByIDCached(ctx, id)
+	if err != nil {
+		t.Fatalf("Failed to get user: %v", err)
+	}
+	if got.Name != "From Fresh Copy" || got.Version != fresh.Version {
+		t.Errorf("Expected the fresh write at version %d, got: %s at %d", fresh.Version, got.Name, got.Version)
+	}
+}
@@ -0,0 +1,221 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"testcontainers-demo/models"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Defaults for WarmCache and WarmCacheRecent
+const (
+	defaultWarmBatchSize   = 500
+	defaultWarmConcurrency = 4
+)
+
+// missingUserEntry is the cached value recording that a user ID doesn't
+// exist; a user is always cached as a JSON object, so it can't be mistaken
+// for one
+const missingUserEntry = "missing"
+
+// WithNegativeCaching caches that a user ID doesn't exist for ttl, so
+// repeated lookups of a missing ID, through GetByIDCached, GetByIDsCached,
+// or WarmCache, skip Postgres. CreateCached clears the entry for the ID it
+// creates, but a user created any other way stays hidden from the cached
+// reads for up to ttl, so keep it short. Off by default.
+func WithNegativeCaching(ttl time.Duration) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.negativeTTL = ttl
+	}
+}
+
+// WithWarmBatchSize sets how many users WarmCache and WarmCacheRecent
+// write per pipeline, and WarmCache reads per query; the default is 500
+func WithWarmBatchSize(n int) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.warmBatch = max(n, 1)
+	}
+}
+
+// WithWarmConcurrency sets how many batches WarmCache and WarmCacheRecent
+// run at once; the default is 4
+func WithWarmConcurrency(n int) CachedOption {
+	return func(r *CachedUserRepository) {
+		r.warmWorkers = max(n, 1)
+	}
+}
+
+// WarmError lists the users WarmCache or WarmCacheRecent failed to cache.
+// The rest were cached.
+type WarmError struct {
+	Failed []int // user IDs, ascending
+	Err    error // every batch's error, joined
+}
+
+func (e *WarmError) Error() string {
+	return "failed to warm " + strconv.Itoa(len(e.Failed)) + " users: " + e.Err.Error()
+}
+
+func (e *WarmError) Unwrap() error {
+	return e.Err
+}
+
+// warmFailures collects the failures of batches running concurrently
+type warmFailures struct {
+	mu     sync.Mutex
+	failed []int
+	errs   []error
+}
+
+func (w *warmFailures) add(ids []int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.failed = append(w.failed, ids...)
+	w.errs = append(w.errs, err)
+}
+
+// err returns a *WarmError if any batch failed, or nil
+func (w *warmFailures) err() error {
+	if len(w.errs) == 0 {
+		return nil
+	}
+	slices.Sort(w.failed)
+	return &WarmError{Failed: w.failed, Err: errors.Join(w.errs...)}
+}
+
+// WarmCache caches the users with ids ahead of their first read, e.g. the
+// hottest users after a deploy, in batches of WithWarmBatchSize: one query
+// and one pipeline of SETs each, WithWarmConcurrency at a time. IDs with no
+// user are skipped, unless WithNegativeCaching is on, which caches them as
+// missing. If some users couldn't be cached the error wraps a *WarmError
+// listing them; the others are cached regardless.
+func (r *CachedUserRepository) WarmCache(ctx context.Context, ids []int) (err error) {
+	const op = "CachedUserRepository.WarmCache"
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectUsersByIDs}, len(ids))
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	var failures warmFailures
+	g := new(errgroup.Group)
+	g.SetLimit(r.warmWorkers)
+	for batch := range slices.Chunk(uniqueIDs(ids), r.warmBatch) {
+		g.Go(func() error {
+			f := r.beginFill(ctx, batch...)
+			users, err := r.getManyFromDB(ctx, batch)
+			if err != nil {
+				failures.add(batch, err)
+				return nil
+			}
+			if failed, err := r.storeMany(ctx, users, notFound(batch, users)); err != nil {
+				failures.add(failed, err)
+			}
+			if err := r.endFill(ctx, f); err != nil {
+				failures.add(nil, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait() // the workers record their errors in failures
+
+	if err := failures.err(); err != nil {
+		return newRepoError(op, fmt.Sprintf("ids=%d", len(ids)), err)
+	}
+	return nil
+}
+
+// selectRecentUsers is the query behind WarmCacheRecent; $1 is the cutoff
+// and $3 the tenant
+const selectRecentUsers = `
+	SELECT ` + userDetailColumns + `
+	FROM users
+	WHERE created_at >= $1 AND tenant_id = $3
+	ORDER BY created_at DESC, id DESC
+	LIMIT $2
+`
+
+// createdSince returns up to limit users created at or after cutoff, newest
+// first. The rows are closed by the time it returns, so the connection is
+// back in the pool before WarmCacheRecent's cache writes.
+func (r *UserRepository) createdSince(ctx context.Context, cutoff time.Time, limit int) (_ []models.User, err error) {
+	rows, err := r.db.QueryContext(ctx, selectRecentUsers, cutoff, limit, r.tenant)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent users: %w", err)
+	}
+	defer closeRows(rows, &err)
+
+	var users []models.User
+	for rows.Next() {
+		user, err := scanUserDetail(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user: %w", err)
+		}
+		users = append(users, *user)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating users: %w", err)
+	}
+	return users, nil
+}
+
+// WarmCacheRecent caches up to limit users created in the last days days,
+// counted back from the Clock, newest first, read in one query and written
+// like WarmCache writes them
+func (r *CachedUserRepository) WarmCacheRecent(ctx context.Context, days, limit int) (err error) {
+	const op = "CachedUserRepository.WarmCacheRecent"
+	key := fmt.Sprintf("days=%d limit=%d", days, limit)
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: op, Statement: selectRecentUsers}, days, limit)
+	defer func() { err = contextErr(ctx, err); finish(err) }()
+
+	cutoff := r.clock.Now().UTC().AddDate(0, 0, -days)
+	readStart := time.Now()
+	users, err := r.users.createdSince(ctx, cutoff, limit)
+	if err != nil {
+		return newRepoError(op, key, err)
+	}
+
+	var failures warmFailures
+	g := new(errgroup.Group)
+	g.SetLimit(r.warmWorkers)
+	for batch := range slices.Chunk(users, r.warmBatch) {
+		g.Go(func() error {
+			// The IDs weren't known before the query, so any fence counts
+			ids := make([]int, len(batch))
+			for i, u := range batch {
+				ids[i] = u.ID
+			}
+			f := r.beginFillAfter(ids, readStart)
+			if failed, err := r.storeMany(ctx, batch, nil); err != nil {
+				failures.add(failed, err)
+			}
+			if err := r.endFill(ctx, f); err != nil {
+				failures.add(nil, err)
+			}
+			return nil
+		})
+	}
+	_ = g.Wait() // the workers record their errors in failures
+
+	if err := failures.err(); err != nil {
+		return newRepoError(op, key, err)
+	}
+	return nil
+}
+
+// forgetMissing clears a WithNegativeCaching entry for a user just created
+// with id, reporting it to the hooks as "cache.Del"; a failure leaves the
+// user hidden from cached reads until the entry expires
+func (r *CachedUserRepository) forgetMissing(ctx context.Context, id int) {
+	if r.negativeTTL <= 0 {
+		return
+	}
+	cacheKey := userCacheKey(r.tenant, id)
+	delCtx, finish := observe(ctx, r.hooks, &Op{Name: "cache.Del", UserID: id}, cacheKey)
+	// Fenced, so a lookup that read the user as missing can't put the entry back
+	finish(r.evict(delCtx, []int{id}, cacheKey))
+}
@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/testhelpers"
+	"testcontainers-demo/testhelpers/sqlspy"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// failingKeys is a go-redis hook failing the pipelined commands on its keys
+// after the pipeline runs, so the rest of each pipeline still succeeds
+type failingKeys struct {
+	keys map[string]bool
+	err  error
+}
+
+func (h failingKeys) DialHook(next redis.DialHook) redis.DialHook          { return next }
+func (h failingKeys) ProcessHook(next redis.ProcessHook) redis.ProcessHook { return next }
+
+func (h failingKeys) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			if key, ok := cmd.Args()[1].(string); ok && h.keys[key] {
+				cmd.SetErr(h.err)
+				err = h.err
+			}
+		}
+		return err
+	}
+}
+
+// TestWarmCache tests that warmed users are then read without touching
+// Postgres, that missing IDs are only cached with negative caching, and
+// that a partial failure names the users left uncached
+func TestWarmCache(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	redisClient := testhelpers.StartRedis(ctx, t)
+
+	spy := sqlspy.New()
+	spied, err := spy.Open(testContainer.ConnStr)
+	if err != nil {
+		t.Fatalf("Failed to open spied database: %v", err)
+	}
+	t.Cleanup(func() { spied.Close() })
+	// Batches of 2 so five users take three batches
+	cachedRepo := NewCachedUserRepository(spied, redisClient, WithWarmBatchSize(2), WithWarmConcurrency(2))
+
+	seeded := fixtures.SeedUsers(t, testDB,
+		fixtures.NewUser(), fixtures.NewUser(), fixtures.NewUser(), fixtures.NewUser(), fixtures.NewUser())
+	ids := make([]int, len(seeded))
+	for i, u := range seeded {
+		ids[i] = u.ID
+	}
+
+	// cached reports whether id has any cache entry
+	cached := func(t *testing.T, id int) bool {
+		t.Helper()
+		n, err := redisClient.Exists(ctx, userCacheKey(DefaultTenant, id)).Result()
+		if err != nil {
+			t.Fatalf("Failed to check user %d: %v", id, err)
+		}
+		return n == 1
+	}
+
+	t.Run("Reads After Warming Skip Postgres", func(t *testing.T) {
+		if err := cachedRepo.WarmCache(ctx, ids); err != nil {
+			t.Fatalf("Failed to warm cache: %v", err)
+		}
+
+		spy.Reset()
+		for _, s := range seeded {
+			user, err := cachedRepo.GetByIDCached(ctx, s.ID)
+			if err != nil {
+				t.Fatalf("Failed to get user %d: %v", s.ID, err)
+			}
+			if user.Email != s.Email {
+				t.Errorf("Expected email %s, got: %s", s.Email, user.Email)
+			}
+		}
+		if n := spy.Count(""); n != 0 {
+			t.Errorf("Expected no database queries after warming, got %d: %v", n, spy.Statements())
+		}
+	})
+
+	t.Run("Missing IDs Not Cached", func(t *testing.T) {
+		if err := cachedRepo.WarmCache(ctx, []int{missingID, ids[0]}); err != nil {
+			t.Fatalf("Failed to warm cache: %v", err)
+		}
+		if cached(t, missingID) {
+			t.Errorf("Expected no entry for missing user %d", missingID)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, missingID); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+	})
+
+	t.Run("Missing IDs Cached With Negative Caching", func(t *testing.T) {
+		negativeRepo := NewCachedUserRepository(spied, redisClient, WithNegativeCaching(time.Minute))
+		const missing = missingID - 1
+		if err := negativeRepo.WarmCache(ctx, []int{missing}); err != nil {
+			t.Fatalf("Failed to warm cache: %v", err)
+		}
+		if !cached(t, missing) {
+			t.Fatalf("Expected user %d cached as missing", missing)
+		}
+
+		spy.Reset()
+		if _, err := negativeRepo.GetByIDCached(ctx, missing); !errors.Is(err, ErrUserNotFound) {
+			t.Errorf("Expected ErrUserNotFound, got: %v", err)
+		}
+		if users, err := negativeRepo.GetByIDsCached(ctx, []int{missing, ids[0]}); err != nil || len(users) != 1 {
+			t.Errorf("Expected only user %d, got: %v, %v", ids[0], users, err)
+		}
+		if n := spy.Count(""); n != 0 {
+			t.Errorf("Expected the missing user answered from the cache, got %d queries: %v", n, spy.Statements())
+		}
+	})
+
+	t.Run("Partial Failure Names Failed Users", func(t *testing.T) {
+		errInjected := errors.New("injected SET failure")
+		failing := redis.NewClient(redisClient.Options())
+		t.Cleanup(func() { failing.Close() })
+		failing.AddHook(failingKeys{keys: map[string]bool{userCacheKey(DefaultTenant, ids[3]): true}, err: errInjected})
+		failingRepo := NewCachedUserRepository(testDB, failing, WithWarmBatchSize(2))
+
+		redisClient.Del(ctx, userCacheKey(DefaultTenant, ids[3]))
+		err := failingRepo.WarmCache(ctx, ids)
+		var warmErr *WarmError
+		if !errors.As(err, &warmErr) {
+			t.Fatalf("Expected a *WarmError, got: %v", err)
+		}
+		if !slices.Equal(warmErr.Failed, []int{ids[3]}) || !errors.Is(err, errInjected) {
+			t.Errorf("Expected only user %d failed with the injected error, got: %v", ids[3], err)
+		}
+		if cached(t, ids[3]) {
+			t.Errorf("Expected user %d left uncached", ids[3])
+		}
+	})
+}
+
+// TestNegativeCachingClearedOnCreate tests that CreateCached clears a
+// missing entry cached for the ID it creates
+func TestNegativeCachingClearedOnCreate(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	// A database of its own, so no other test takes the next ID
+	db := testContainer.CreateTestDatabase(ctx, t)
+	cachedRepo := NewCachedUserRepository(db, testhelpers.StartRedis(ctx, t), WithNegativeCaching(time.Minute))
+
+	var last int
+	if err := db.QueryRowContext(ctx, "SELECT nextval(pg_get_serial_sequence('users', 'id'))").Scan(&last); err != nil {
+		t.Fatalf("Failed to read the ID sequence: %v", err)
+	}
+	next := last + 1
+	if _, err := cachedRepo.GetByIDCached(ctx, next); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("Expected ErrUserNotFound, got: %v", err)
+	}
+
+	user, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Created After Miss")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if user.ID != next {
+		t.Fatalf("Expected user %d, got: %d", next, user.ID)
+	}
+	if _, err := cachedRepo.GetByIDCached(ctx, next); err != nil {
+		t.Errorf("Expected the new user, got: %v", err)
+	}
+}
+
+// TestWarmCacheRecent tests that only users created within the window are
+// warmed, newest first up to the limit
+func TestWarmCacheRecent(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+	cachedRepo := NewCachedUserRepository(db, redisClient, WithWarmBatchSize(1))
+
+	// Later than the template's seed rows, so they are the newest
+	now := time.Now()
+	seeded := fixtures.SeedUsers(t, db,
+		fixtures.NewUser().WithCreatedAt(now.Add(time.Hour)),
+		fixtures.NewUser().WithCreatedAt(now.Add(2*time.Hour)),
+		fixtures.NewUser().WithCreatedAt(now.AddDate(0, 0, -30)),
+	)
+	older, newest, old := seeded[0], seeded[1], seeded[2]
+
+	// cachedIDs returns which of seeded are cached, in order
+	cachedIDs := func(t *testing.T) []int {
+		t.Helper()
+		var ids []int
+		for _, s := range seeded {
+			if n, _ := redisClient.Exists(ctx, userCacheKey(DefaultTenant, s.ID)).Result(); n == 1 {
+				ids = append(ids, s.ID)
+			}
+		}
+		return ids
+	}
+
+	if err := cachedRepo.WarmCacheRecent(ctx, 7, 1); err != nil {
+		t.Fatalf("Failed to warm cache: %v", err)
+	}
+	if got := cachedIDs(t); !slices.Equal(got, []int{newest.ID}) {
+		t.Errorf("Expected only the newest user %d cached, got: %v", newest.ID, got)
+	}
+
+	if err := cachedRepo.WarmCacheRecent(ctx, 7, 100); err != nil {
+		t.Fatalf("Failed to warm cache: %v", err)
+	}
+	if got := cachedIDs(t); !slices.Equal(got, []int{older.ID, newest.ID}) {
+		t.Errorf("Expected users %d and %d cached but not %d, got: %v", older.ID, newest.ID, old.ID, got)
+	}
+}
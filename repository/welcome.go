@@ -0,0 +1,48 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"testcontainers-demo/models"
+)
+
+// Mailer sends the email a new user gets. *mailer.SMTPMailer implements it.
+type Mailer interface {
+	SendWelcome(ctx context.Context, user models.User) error
+}
+
+// WithMailer sets the mailer CreateAndNotify sends welcome emails with
+func WithMailer(m Mailer) Option {
+	return func(r *UserRepository) {
+		r.mailer = m
+	}
+}
+
+// errNoMailer is returned by CreateAndNotify on a repository without WithMailer
+var errNoMailer = errors.New("no mailer configured, use WithMailer")
+
+// CreateAndNotify creates a user, then sends them the welcome email. The
+// email is sent after the insert commits, so a failed send doesn't undo it:
+// the user is returned along with an error wrapping both
+// ErrNotificationFailed and the cause.
+func (r *UserRepository) CreateAndNotify(ctx context.Context, email, name string) (_ *models.User, err error) {
+	const op = "UserRepository.CreateAndNotify"
+	if r.mailer == nil {
+		return nil, newRepoError(op, "email="+email, errNoMailer)
+	}
+
+	user, err := r.Create(ctx, email, name)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, finish := observe(ctx, r.hooks, &Op{Name: "mailer.SendWelcome", UserID: user.ID}, user.Email)
+	err = r.mailer.SendWelcome(ctx, *user)
+	finish(err)
+	if err != nil {
+		return user, newRepoError(op, "email="+user.Email, fmt.Errorf("%w: %w", ErrNotificationFailed, err))
+	}
+	return user, nil
+}
@@ -0,0 +1,122 @@
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+)
+
+// Repository is a repository.UserStore that keeps an Index in sync with
+// every create, update, and delete. Reads other than Search go to the store.
+//
+// The index is written after the database, synchronously. When that fails
+// the write methods return an error wrapping ErrIndexOutOfSync; Create and
+// CreateWithRole still return the user they created.
+type Repository struct {
+	repository.UserStore
+	index *Index
+}
+
+// NewRepository creates a repository writing to users and indexing into index
+func NewRepository(users repository.UserStore, index *Index) *Repository {
+	return &Repository{UserStore: users, index: index}
+}
+
+// Create inserts a new user with the default role (member) and indexes them
+func (r *Repository) Create(ctx context.Context, email, name string) (*models.User, error) {
+	user, err := r.UserStore.Create(ctx, email, name)
+	if err != nil {
+		return nil, err
+	}
+	return user, r.put(ctx, *user)
+}
+
+// CreateWithRole inserts a new user with the given role and indexes them
+func (r *Repository) CreateWithRole(ctx context.Context, email, name string, role models.Role) (*models.User, error) {
+	user, err := r.UserStore.CreateWithRole(ctx, email, name, role)
+	if err != nil {
+		return nil, err
+	}
+	return user, r.put(ctx, *user)
+}
+
+// Update modifies an existing user's email and name and reindexes them
+func (r *Repository) Update(ctx context.Context, id int, email, name string) error {
+	if err := r.UserStore.Update(ctx, id, email, name); err != nil {
+		return err
+	}
+	return r.reindex(ctx, id)
+}
+
+// UpdateWithRole modifies an existing user's email, name, and role and reindexes them
+func (r *Repository) UpdateWithRole(ctx context.Context, id int, email, name string, role models.Role) error {
+	if err := r.UserStore.UpdateWithRole(ctx, id, email, name, role); err != nil {
+		return err
+	}
+	return r.reindex(ctx, id)
+}
+
+// Delete removes a user and their document
+func (r *Repository) Delete(ctx context.Context, id int) error {
+	if err := r.UserStore.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := r.index.Remove(ctx, id); err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexOutOfSync, err)
+	}
+	return nil
+}
+
+// Search returns a page of up to limit users matching query, most relevant
+// first, after cursor; see Index.Search. Writes become searchable within
+// about a second.
+func (r *Repository) Search(ctx context.Context, query, cursor string, limit int) (repository.UserPage, error) {
+	return r.index.Search(ctx, query, cursor, limit)
+}
+
+// ReindexAll rebuilds the index from the store and returns how many users
+// it indexed. It empties the index first, so searches miss users until it
+// finishes; writes made meanwhile may need another run.
+func (r *Repository) ReindexAll(ctx context.Context) (int, error) {
+	if err := r.index.clear(ctx); err != nil {
+		return 0, err
+	}
+
+	var total int
+	cursor := ""
+	for {
+		page, err := r.UserStore.ListPaginated(ctx, cursor, reindexPageSize)
+		if err != nil {
+			return total, fmt.Errorf("failed to read users to reindex: %w", err)
+		}
+		if len(page.Items) > 0 {
+			if err := r.index.putAll(ctx, page.Items); err != nil {
+				return total, err
+			}
+		}
+		total += len(page.Items)
+		if !page.HasMore {
+			return total, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// reindex indexes user id as the store now has them
+func (r *Repository) reindex(ctx context.Context, id int) error {
+	user, err := r.UserStore.GetByID(ctx, id)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexOutOfSync, err)
+	}
+	return r.put(ctx, *user)
+}
+
+// put indexes user, reporting a failure as ErrIndexOutOfSync
+func (r *Repository) put(ctx context.Context, user models.User) error {
+	if err := r.index.Put(ctx, user); err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexOutOfSync, err)
+	}
+	return nil
+}
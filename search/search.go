@@ -0,0 +1,261 @@
+// Package search indexes users into Elasticsearch for ranked, typo-tolerant
+// search, which FindByNamePattern's ILIKE can't do. Its Repository writes
+// through a repository.UserStore and then to the index, a synchronous dual
+// write: the database stays the source of truth, and ReindexAll rebuilds the
+// index from it after a failed index write.
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/repository"
+
+	es "github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ErrIndexOutOfSync is returned, wrapped, when a database write succeeded
+// but indexing it didn't. The write stands; run ReindexAll to catch the
+// index up.
+var ErrIndexOutOfSync = errors.New("search: index out of sync with database")
+
+// reindexPageSize is how many users ReindexAll reads and indexes at a time
+const reindexPageSize = 500
+
+// mapping indexes the fields of models.User as its JSON encoding names them.
+// Emails are normalized to lowercase before they are stored, so a keyword
+// field matches prefixes of a lowercased query.
+const mapping = `{
+	"mappings": {
+		"properties": {
+			"id":         {"type": "integer"},
+			"uuid":       {"type": "keyword"},
+			"email":      {"type": "keyword"},
+			"name":       {"type": "text"},
+			"role":       {"type": "keyword"},
+			"created_at": {"type": "date"}
+		}
+	}
+}`
+
+// Index is an Elasticsearch index of users, one document per user with the
+// user's ID as its document ID
+type Index struct {
+	es   *es.Client
+	name string
+}
+
+// NewIndex returns the index called name, creating it with the user mapping
+// if it doesn't exist
+func NewIndex(ctx context.Context, client *es.Client, name string) (*Index, error) {
+	idx := &Index{es: client, name: name}
+
+	res, err := esapi.IndicesExistsRequest{Index: []string{name}}.Do(ctx, client)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check index %s: %w", name, err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return idx, nil
+	}
+
+	res, err = esapi.IndicesCreateRequest{Index: name, Body: strings.NewReader(mapping)}.Do(ctx, client)
+	if err := responseError(res, err); err != nil {
+		return nil, fmt.Errorf("failed to create index %s: %w", name, err)
+	}
+	return idx, nil
+}
+
+// Put indexes user, replacing any document it had
+func (i *Index) Put(ctx context.Context, user models.User) error {
+	body, err := json.Marshal(user)
+	if err != nil {
+		return fmt.Errorf("failed to encode user %d: %w", user.ID, err)
+	}
+
+	res, err := esapi.IndexRequest{
+		Index:      i.name,
+		DocumentID: strconv.Itoa(user.ID),
+		Body:       bytes.NewReader(body),
+	}.Do(ctx, i.es)
+	if err := responseError(res, err); err != nil {
+		return fmt.Errorf("failed to index user %d: %w", user.ID, err)
+	}
+	return nil
+}
+
+// Remove deletes the document of user id; a user that isn't indexed is not an error
+func (i *Index) Remove(ctx context.Context, id int) error {
+	res, err := esapi.DeleteRequest{Index: i.name, DocumentID: strconv.Itoa(id)}.Do(ctx, i.es)
+	if err == nil && res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil
+	}
+	if err := responseError(res, err); err != nil {
+		return fmt.Errorf("failed to remove user %d: %w", id, err)
+	}
+	return nil
+}
+
+// Refresh makes every write so far visible to Search. Elasticsearch does
+// this on its own about once a second; tests call it to avoid waiting.
+func (i *Index) Refresh(ctx context.Context) error {
+	res, err := esapi.IndicesRefreshRequest{Index: []string{i.name}}.Do(ctx, i.es)
+	if err := responseError(res, err); err != nil {
+		return fmt.Errorf("failed to refresh index %s: %w", i.name, err)
+	}
+	return nil
+}
+
+// Search returns a page of up to limit users matching query, most relevant
+// first, after cursor: the NextCursor of the page before, or "" for the
+// first page. Names match fuzzily, so "Smth" finds Smith; emails match by
+// prefix. Ties in relevance are broken by ID, and later pages continue
+// with search_after, so they hold as long as the index doesn't change in
+// between. A limit of 0 returns the first page of Elasticsearch's default
+// size, 10.
+func (i *Index) Search(ctx context.Context, query, cursor string, limit int) (repository.UserPage, error) {
+	c, err := pagination.Decode(cursor)
+	if err != nil {
+		return repository.UserPage{}, err
+	}
+	if err := repository.ValidateLimit(limit); err != nil {
+		return repository.UserPage{}, err
+	}
+	request := map[string]interface{}{
+		"track_total_hits": true,
+		"sort":             []interface{}{map[string]string{"_score": "desc"}, map[string]string{"id": "asc"}},
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"match": map[string]interface{}{
+						"name": map[string]interface{}{"query": query, "fuzziness": "AUTO"},
+					}},
+					map[string]interface{}{"prefix": map[string]interface{}{
+						"email": map[string]interface{}{"value": repository.NormalizeEmail(query)},
+					}},
+				},
+				"minimum_should_match": 1,
+			},
+		},
+	}
+	if limit > 0 {
+		request["size"] = pagination.FetchLimit(limit)
+	}
+	if cursor != "" {
+		// ParseFloat accepts NaN and Inf, which no hit scores and JSON can't carry
+		score, err := strconv.ParseFloat(c.Key, 64)
+		if err != nil || math.IsNaN(score) || math.IsInf(score, 0) {
+			return repository.UserPage{}, fmt.Errorf("%w: bad score %q", pagination.ErrInvalidCursor, c.Key)
+		}
+		request["search_after"] = []interface{}{score, c.ID}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return repository.UserPage{}, fmt.Errorf("failed to encode search: %w", err)
+	}
+
+	res, err := esapi.SearchRequest{Index: []string{i.name}, Body: bytes.NewReader(body)}.Do(ctx, i.es)
+	if err := responseError(res, err); err != nil {
+		return repository.UserPage{}, fmt.Errorf("failed to search %q: %w", query, err)
+	}
+	defer res.Body.Close()
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Source models.User `json:"_source"`
+				Score  float64     `json:"_score"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return repository.UserPage{}, fmt.Errorf("failed to decode search results: %w", err)
+	}
+
+	users := make([]models.User, 0, len(result.Hits.Hits))
+	scores := make(map[int]float64, len(result.Hits.Hits))
+	for _, hit := range result.Hits.Hits {
+		users = append(users, hit.Source)
+		scores[hit.Source.ID] = hit.Score
+	}
+	return pagination.New(users, limit, result.Hits.Total.Value, func(u models.User) pagination.Cursor {
+		return pagination.Cursor{ID: u.ID, Key: strconv.FormatFloat(scores[u.ID], 'g', -1, 64)}
+	}), nil
+}
+
+// putAll indexes users in one bulk request
+func (i *Index) putAll(ctx context.Context, users []models.User) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, user := range users {
+		action := map[string]interface{}{"index": map[string]interface{}{"_id": strconv.Itoa(user.ID)}}
+		if err := enc.Encode(action); err != nil {
+			return err
+		}
+		if err := enc.Encode(user); err != nil {
+			return fmt.Errorf("failed to encode user %d: %w", user.ID, err)
+		}
+	}
+
+	res, err := esapi.BulkRequest{Index: i.name, Body: &body}.Do(ctx, i.es)
+	if err := responseError(res, err); err != nil {
+		return fmt.Errorf("failed to index users: %w", err)
+	}
+	defer res.Body.Close()
+
+	// A bulk request succeeds as a whole even when some of its items fail
+	var result struct {
+		Errors bool `json:"errors"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode bulk response: %w", err)
+	}
+	if result.Errors {
+		return fmt.Errorf("failed to index users: bulk request reported item errors")
+	}
+	return nil
+}
+
+// clear deletes every document
+func (i *Index) clear(ctx context.Context) error {
+	proceed := true
+	res, err := esapi.DeleteByQueryRequest{
+		Index:     []string{i.name},
+		Body:      strings.NewReader(`{"query": {"match_all": {}}}`),
+		Conflicts: "proceed",
+		Refresh:   &proceed,
+	}.Do(ctx, i.es)
+	if err := responseError(res, err); err != nil {
+		return fmt.Errorf("failed to clear index %s: %w", i.name, err)
+	}
+	return nil
+}
+
+// responseError returns err, or the error Elasticsearch responded with. It
+// closes the body of an error response; the caller closes any other.
+func responseError(res *esapi.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	if !res.IsError() {
+		return nil
+	}
+	defer res.Body.Close()
+	msg, _ := io.ReadAll(io.LimitReader(res.Body, 4096))
+	return fmt.Errorf("%s: %s", res.Status(), msg)
+}
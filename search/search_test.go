@@ -0,0 +1,232 @@
+package search_test
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"os"
+	"testing"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/pagination"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/search"
+	"testcontainers-demo/testhelpers"
+
+	es "github.com/elastic/go-elasticsearch/v8"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// newRepository returns a search repository over a fresh seeded database and
+// an index of its own on client, deleted when the test finishes
+func newRepository(ctx context.Context, t *testing.T, client *es.Client) (*search.Repository, *search.Index) {
+	t.Helper()
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("Failed to generate index name: %v", err)
+	}
+	name := "users_test_" + hex.EncodeToString(suffix)
+	index, err := search.NewIndex(ctx, client, name)
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	t.Cleanup(func() { client.Indices.Delete([]string{name}) })
+
+	users := repository.NewUserRepository(testContainer.CreateTestDatabase(ctx, t))
+	t.Cleanup(func() { users.Close() })
+	return search.NewRepository(users, index), index
+}
+
+// names returns the names of users in order
+func names(users []models.User) []string {
+	out := make([]string, len(users))
+	for i, u := range users {
+		out[i] = u.Name
+	}
+	return out
+}
+
+// TestSearch tests ranked fuzzy search over the seed users and that the index
+// follows creates, updates, and deletes
+func TestSearch(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	// One cluster for every subtest: Elasticsearch takes a while to start
+	client := testhelpers.StartElasticsearch(ctx, t)
+	repo, index := newRepository(ctx, t, client)
+
+	// The seed users were inserted by SQL, so only a reindex picks them up
+	n, err := repo.ReindexAll(ctx)
+	if err != nil {
+		t.Fatalf("Failed to reindex: %v", err)
+	}
+	if n != 2 {
+		t.Fatalf("Expected to index the 2 seed users, indexed: %d", n)
+	}
+	if err := index.Refresh(ctx); err != nil {
+		t.Fatal(err)
+	}
+	alice, err := repo.GetByEmail(ctx, "alice@example.com")
+	if err != nil {
+		t.Fatalf("Failed to get Alice: %v", err)
+	}
+
+	t.Run("Typo In Name", func(t *testing.T) {
+		page, err := repo.Search(ctx, "Smth", "", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		users := page.Items
+		if len(users) == 0 || users[0].ID != alice.ID {
+			t.Fatalf("Expected Alice Smith first, got: %v", names(users))
+		}
+		if users[0].Email != alice.Email || users[0].UUID != alice.UUID {
+			t.Errorf("Expected the indexed user to match the database, got: %+v", users[0])
+		}
+	})
+
+	t.Run("Email Prefix", func(t *testing.T) {
+		page, err := repo.Search(ctx, "BOB@ex", "", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		users := page.Items
+		if len(users) != 1 || users[0].Name != "Bob Johnson" {
+			t.Errorf("Expected only Bob Johnson, got: %v", names(users))
+		}
+	})
+
+	t.Run("Ranked By Relevance", func(t *testing.T) {
+		if _, err := repo.Create(ctx, "alice.smyth@example.com", "Alice Smyth"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if err := index.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		// Alice Smith matches both terms exactly, Alice Smyth one only fuzzily
+		page, err := repo.Search(ctx, "Alice Smith", "", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		users := page.Items
+		if len(users) < 2 || users[0].ID != alice.ID || users[1].Name != "Alice Smyth" {
+			t.Errorf("Expected Alice Smith then Alice Smyth, got: %v", names(users))
+		}
+	})
+
+	t.Run("Pages", func(t *testing.T) {
+		var walked []models.User
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("Expected the pages to end")
+			}
+			page, err := repo.Search(ctx, "Alice Smith", cursor, 1)
+			if err != nil {
+				t.Fatalf("Failed to search: %v", err)
+			}
+			walked = append(walked, page.Items...)
+			if int64(len(walked)) > page.TotalCount {
+				t.Fatalf("Expected at most %d hits, walked: %v", page.TotalCount, names(walked))
+			}
+			if !page.HasMore {
+				if int64(len(walked)) != page.TotalCount {
+					t.Errorf("Expected %d hits, walked: %v", page.TotalCount, names(walked))
+				}
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if len(walked) < 2 || walked[0].ID != alice.ID || walked[1].Name != "Alice Smyth" {
+			t.Errorf("Expected Alice Smith then Alice Smyth, got: %v", names(walked))
+		}
+
+		for _, cursor := range []string{
+			"not-a-cursor",
+			pagination.Cursor{ID: 5, Key: "NaN"}.Encode(),
+			pagination.Cursor{ID: 5, Key: "+Inf"}.Encode(),
+			pagination.Cursor{ID: 5, Key: "-Inf"}.Encode(),
+		} {
+			if _, err := repo.Search(ctx, "Alice", cursor, 1); !errors.Is(err, pagination.ErrInvalidCursor) {
+				t.Errorf("Cursor %q: expected ErrInvalidCursor, got: %v", cursor, err)
+			}
+		}
+	})
+
+	t.Run("Update Reindexes", func(t *testing.T) {
+		bob, err := repo.GetByEmail(ctx, "bob@example.com")
+		if err != nil {
+			t.Fatalf("Failed to get Bob: %v", err)
+		}
+		if err := repo.Update(ctx, bob.ID, bob.Email, "Robert Johnson"); err != nil {
+			t.Fatalf("Failed to update user: %v", err)
+		}
+		if err := index.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := repo.Search(ctx, "Robert", "", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		users := page.Items
+		if len(users) != 1 || users[0].ID != bob.ID {
+			t.Errorf("Expected the renamed Bob, got: %v", names(users))
+		}
+	})
+
+	t.Run("Deleted User Drops Out", func(t *testing.T) {
+		if err := repo.Delete(ctx, alice.ID); err != nil {
+			t.Fatalf("Failed to delete Alice: %v", err)
+		}
+		if err := index.Refresh(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := repo.Search(ctx, "Smth", "", 10)
+		if err != nil {
+			t.Fatalf("Failed to search: %v", err)
+		}
+		users := page.Items
+		for _, u := range users {
+			if u.ID == alice.ID {
+				t.Errorf("Expected Alice to be gone, got: %v", names(users))
+			}
+		}
+	})
+
+	t.Run("Reindex Matches Database", func(t *testing.T) {
+		n, err := repo.ReindexAll(ctx)
+		if err != nil {
+			t.Fatalf("Failed to reindex: %v", err)
+		}
+		count, err := repo.CountUsers(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		if n != count {
+			t.Errorf("Expected to index %d users, indexed: %d", count, n)
+		}
+	})
+}
@@ -0,0 +1,188 @@
+// Package sessions stores login sessions in Redis. A session holds only the
+// user ID; the user itself is read through the cached repository on every
+// Get, so sessions never serve stale user data.
+package sessions
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"testcontainers-demo/models"
+	"testcontainers-demo/repository"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// DefaultTTL is how long a session lives without a Refresh
+const DefaultTTL = 24 * time.Hour
+
+// tokenBytes is the entropy of a session token, before base64 encoding
+const tokenBytes = 32
+
+// ErrSessionNotFound is returned for a token that is unknown, expired,
+// destroyed, or whose user no longer exists
+var ErrSessionNotFound = errors.New("session not found")
+
+// UserGetter loads a session's user; *repository.CachedUserRepository implements it
+type UserGetter interface {
+	GetByIDCached(ctx context.Context, id int) (*models.User, error)
+}
+
+// destroyAllScript deletes every session in a user's index, then the index.
+// It runs atomically, so a session created concurrently is either indexed
+// in time to be deleted or created after the index is gone.
+var destroyAllScript = redis.NewScript(`
+local tokens = redis.call("SMEMBERS", KEYS[1])
+for _, token in ipairs(tokens) do
+	redis.call("DEL", ARGV[1] .. token)
+end
+redis.call("DEL", KEYS[1])
+return #tokens
+`)
+
+// Store creates and resolves sessions. Keys are session:{token}, holding the
+// user ID, and user_sessions:{id}, the set of that user's tokens.
+type Store struct {
+	client *redis.Client
+	users  UserGetter
+	ttl    time.Duration
+}
+
+// Option configures a Store
+type Option func(*Store)
+
+// WithTTL sets how long sessions live without a Refresh
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Store) {
+		s.ttl = ttl
+	}
+}
+
+// NewStore creates a session store on client that loads users from users.
+// Only Get uses users, so a store that just ends sessions, like the one
+// given to repository.WithSessions, can pass nil.
+func NewStore(client *redis.Client, users UserGetter, opts ...Option) *Store {
+	s := &Store{client: client, users: users, ttl: DefaultTTL}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// sessionKey is the Redis key of a session
+func sessionKey(token string) string {
+	return "session:" + token
+}
+
+// userSessionsKey is the Redis key of the set of a user's session tokens
+func userSessionsKey(userID int) string {
+	return fmt.Sprintf("user_sessions:%d", userID)
+}
+
+// Create starts a session for userID and returns its opaque token
+func (s *Store) Create(ctx context.Context, userID int) (string, error) {
+	token, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	// Every session has the same TTL, so the newest one decides when the index can go
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, sessionKey(token), userID, s.ttl)
+		pipe.SAdd(ctx, userSessionsKey(userID), token)
+		pipe.Expire(ctx, userSessionsKey(userID), s.ttl)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create session for user %d: %w", userID, err)
+	}
+	return token, nil
+}
+
+// Get returns the user a session belongs to. A session whose user was
+// deleted or erased is destroyed and reported as ErrSessionNotFound.
+func (s *Store) Get(ctx context.Context, token string) (*models.User, error) {
+	userID, err := sessionUserID(s.client.Get(ctx, sessionKey(token)))
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.GetByIDCached(ctx, userID)
+	if errors.Is(err, repository.ErrUserNotFound) || err == nil && user.Erased {
+		if err := s.Destroy(ctx, token); err != nil {
+			return nil, err
+		}
+		return nil, ErrSessionNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session user: %w", err)
+	}
+	return user, nil
+}
+
+// Refresh restarts a session's TTL
+func (s *Store) Refresh(ctx context.Context, token string) error {
+	userID, err := sessionUserID(s.client.GetEx(ctx, sessionKey(token), s.ttl))
+	if err != nil {
+		return err
+	}
+	if err := s.client.Expire(ctx, userSessionsKey(userID), s.ttl).Err(); err != nil {
+		return fmt.Errorf("failed to refresh session index: %w", err)
+	}
+	return nil
+}
+
+// Destroy ends a session; destroying an unknown session is not an error
+func (s *Store) Destroy(ctx context.Context, token string) error {
+	userID, err := sessionUserID(s.client.GetDel(ctx, sessionKey(token)))
+	if errors.Is(err, ErrSessionNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := s.client.SRem(ctx, userSessionsKey(userID), token).Err(); err != nil {
+		return fmt.Errorf("failed to unindex session: %w", err)
+	}
+	return nil
+}
+
+// DestroyAllForUser ends every session of userID, e.g. on "log out
+// everywhere" or a password change, and returns how many there were
+func (s *Store) DestroyAllForUser(ctx context.Context, userID int) (int, error) {
+	n, err := destroyAllScript.Run(ctx, s.client, []string{userSessionsKey(userID)}, sessionKey("")).Int()
+	if err != nil {
+		return 0, fmt.Errorf("failed to destroy sessions for user %d: %w", userID, err)
+	}
+	return n, nil
+}
+
+// sessionUserID parses the user ID a session command returned
+func sessionUserID(cmd *redis.StringCmd) (int, error) {
+	val, err := cmd.Result()
+	if errors.Is(err, redis.Nil) {
+		return 0, ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read session: %w", err)
+	}
+	id, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, fmt.Errorf("corrupt session: %w", err)
+	}
+	return id, nil
+}
+
+// newToken returns 32 bytes from crypto/rand, base64url encoded
+func newToken() (string, error) {
+	b := make([]byte, tokenBytes)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate session token: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,268 @@
+package sessions_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"testcontainers-demo/repository"
+	"testcontainers-demo/sessions"
+	"testcontainers-demo/testhelpers"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// newStore returns a session store backed by a fresh database and Redis
+func newStore(ctx context.Context, t *testing.T, opts ...sessions.Option) (*sessions.Store, *sql.DB, *redis.Client) {
+	t.Helper()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	client := testhelpers.StartRedis(ctx, t)
+	users := repository.NewCachedUserRepository(db, client)
+	return sessions.NewStore(client, users, opts...), db, client
+}
+
+// TestSessions tests the login, fetch and logout flows
+func TestSessions(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	store, db, client := newStore(ctx, t)
+
+	t.Run("Login And Fetch", func(t *testing.T) {
+		token, err := store.Create(ctx, 1)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		user, err := store.Get(ctx, token)
+		if err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+		if user.ID != 1 || user.Email != "alice@example.com" {
+			t.Errorf("Expected user 1, got: %+v", user)
+		}
+	})
+
+	t.Run("Unknown Token", func(t *testing.T) {
+		if _, err := store.Get(ctx, "not-a-session"); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound, got: %v", err)
+		}
+		if err := store.Refresh(ctx, "not-a-session"); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound on refresh, got: %v", err)
+		}
+	})
+
+	t.Run("Tokens Are Unique", func(t *testing.T) {
+		seen := map[string]bool{}
+		for i := 0; i < 100; i++ {
+			token, err := store.Create(ctx, 1)
+			if err != nil {
+				t.Fatalf("Failed to create session: %v", err)
+			}
+			if seen[token] {
+				t.Fatalf("Token %s issued twice", token)
+			}
+			seen[token] = true
+		}
+	})
+
+	t.Run("Logout", func(t *testing.T) {
+		token, err := store.Create(ctx, 1)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if err := store.Destroy(ctx, token); err != nil {
+			t.Fatalf("Failed to destroy session: %v", err)
+		}
+		if _, err := store.Get(ctx, token); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound after logout, got: %v", err)
+		}
+		if err := store.Destroy(ctx, token); err != nil {
+			t.Errorf("Expected a second logout to be a no-op, got: %v", err)
+		}
+	})
+
+	t.Run("Logout Everywhere", func(t *testing.T) {
+		laptop, err := store.Create(ctx, 2)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		phone, err := store.Create(ctx, 2)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		other, err := store.Create(ctx, 1)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+
+		n, err := store.DestroyAllForUser(ctx, 2)
+		if err != nil {
+			t.Fatalf("Failed to destroy sessions: %v", err)
+		}
+		if n != 2 {
+			t.Errorf("Expected 2 sessions destroyed, got: %d", n)
+		}
+		for _, token := range []string{laptop, phone} {
+			if _, err := store.Get(ctx, token); !errors.Is(err, sessions.ErrSessionNotFound) {
+				t.Errorf("Expected ErrSessionNotFound after logout everywhere, got: %v", err)
+			}
+		}
+		if _, err := store.Get(ctx, other); err != nil {
+			t.Errorf("Expected another user's session to survive, got: %v", err)
+		}
+	})
+
+	t.Run("Survives Cache Flush", func(t *testing.T) {
+		token, err := store.Create(ctx, 2)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if _, err := store.Get(ctx, token); err != nil {
+			t.Fatalf("Failed to get session: %v", err)
+		}
+
+		keys, err := client.Keys(ctx, "user:*").Result()
+		if err != nil {
+			t.Fatalf("Failed to list cache keys: %v", err)
+		}
+		if len(keys) == 0 {
+			t.Fatal("Expected Get to have cached the user")
+		}
+		if err := client.Del(ctx, keys...).Err(); err != nil {
+			t.Fatalf("Failed to flush the user cache: %v", err)
+		}
+
+		user, err := store.Get(ctx, token)
+		if err != nil {
+			t.Fatalf("Expected the session to survive a cache flush, got: %v", err)
+		}
+		if user.ID != 2 {
+			t.Errorf("Expected user 2, got: %+v", user)
+		}
+	})
+
+	t.Run("Deleted User", func(t *testing.T) {
+		repo := repository.NewUserRepository(db)
+		defer repo.Close()
+		created, err := repo.Create(ctx, "session.deleted@example.com", "Session Deleted")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		token, err := store.Create(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if err := repo.Delete(ctx, created.ID); err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		if _, err := store.Get(ctx, token); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound for a deleted user, got: %v", err)
+		}
+		if n, _ := client.Exists(ctx, "session:"+token).Result(); n != 0 {
+			t.Error("Expected the orphaned session to be destroyed")
+		}
+	})
+
+	t.Run("Erased User", func(t *testing.T) {
+		// A store without users is enough for erasure to end sessions
+		repo := repository.NewCachedUserRepository(db, client, repository.WithSessions(sessions.NewStore(client, nil)))
+		created, err := repo.CreateCached(ctx, "session.erased@example.com", "Session Erased")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		token, err := store.Create(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		if err := repo.EraseUser(ctx, created.ID); err != nil {
+			t.Fatalf("Failed to erase user: %v", err)
+		}
+
+		if _, err := store.Get(ctx, token); !errors.Is(err, sessions.ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound for an erased user, got: %v", err)
+		}
+		if n, _ := client.Exists(ctx, "session:"+token).Result(); n != 0 {
+			t.Error("Expected the erased user's session to be destroyed")
+		}
+	})
+
+	t.Run("Cascade Deleted User", func(t *testing.T) {
+		repo := repository.NewCachedUserRepository(db, client, repository.WithSessions(sessions.NewStore(client, nil)))
+		created, err := repo.CreateCached(ctx, "session.cascade@example.com", "Session Cascade")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if _, err := repository.NewOrderRepository(db).Create(ctx, created.ID, 1000); err != nil {
+			t.Fatalf("Failed to create order: %v", err)
+		}
+		token, err := store.Create(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to create session: %v", err)
+		}
+		summary, err := repo.DeleteUserCascade(ctx, created.ID)
+		if err != nil {
+			t.Fatalf("Failed to delete user: %v", err)
+		}
+
+		if summary.Sessions != 1 {
+			t.Errorf("Expected 1 session ended, got: %d", summary.Sessions)
+		}
+		if n, _ := client.Exists(ctx, "session:"+token, "user_sessions:"+strconv.Itoa(created.ID)).Result(); n != 0 {
+			t.Error("Expected the session and the user's session index deleted")
+		}
+	})
+}
+
+// TestSessionExpiry tests that sessions expire after their TTL unless refreshed
+func TestSessionExpiry(t *testing.T) {
+	requirePostgres(t)
+	ctx := context.Background()
+	store, _, _ := newStore(ctx, t, sessions.WithTTL(300*time.Millisecond))
+
+	idle, err := store.Create(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+	active, err := store.Create(ctx, 1)
+	if err != nil {
+		t.Fatalf("Failed to create session: %v", err)
+	}
+
+	// Keep one session alive past the TTL of the other
+	for i := 0; i < 3; i++ {
+		time.Sleep(150 * time.Millisecond)
+		if err := store.Refresh(ctx, active); err != nil {
+			t.Fatalf("Failed to refresh session: %v", err)
+		}
+	}
+
+	if _, err := store.Get(ctx, idle); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("Expected the idle session to expire, got: %v", err)
+	}
+	if _, err := store.Get(ctx, active); err != nil {
+		t.Errorf("Expected the refreshed session to survive, got: %v", err)
+	}
+}
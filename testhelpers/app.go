@@ -0,0 +1,75 @@
+package testhelpers
+
+import (
+	"context"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// The application image StartApp builds from the repository's Dockerfile.
+// A fixed name, kept after the test, lets Docker's layer cache serve repeated
+// runs instead of building from scratch.
+const (
+	appImageRepo = "testcontainers-demo-app"
+	appImageTag  = "test"
+	appPort      = "8080/tcp"
+)
+
+// AppAlias is the network alias StartApp gives the application container
+const AppAlias = "app"
+
+// StartApp builds the application image, runs it on nw with DATABASE_URL and
+// REDIS_ADDR set to pgDSN and redisAddr, waits for /healthz, and returns the
+// base URL the test process reaches it at, e.g. "http://localhost:32768".
+// pgDSN and redisAddr must be reachable from inside nw, such as a Stack's
+// InternalPostgresDSN and InternalRedisAddr. The container is removed when
+// the test finishes; the image is kept.
+func StartApp(ctx context.Context, t testing.TB, nw *testcontainers.DockerNetwork, pgDSN, redisAddr string) string {
+	t.Helper()
+	RequireDocker(ctx, t)
+
+	// 🐳 BUILD AND START THE APPLICATION CONTAINER
+	container, err := testcontainers.Run(ctx, "",
+		testcontainers.WithDockerfile(testcontainers.FromDockerfile{
+			Context:   repoRoot(),
+			Repo:      appImageRepo,
+			Tag:       appImageTag,
+			KeepImage: true,
+		}),
+		testcontainers.WithExposedPorts(appPort),
+		testcontainers.WithEnv(map[string]string{
+			"DATABASE_URL": pgDSN,
+			"REDIS_ADDR":   redisAddr,
+		}),
+		network.WithNetwork([]string{AppAlias}, nw),
+		testcontainers.WithWaitStrategy(
+			wait.ForHTTP("/healthz").
+				WithPort(appPort).
+				WithStartupTimeout(60*time.Second),
+		),
+		CaptureLogs(t, DefaultLogLines),
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start app container: %s", err)
+	}
+
+	endpoint, err := container.PortEndpoint(ctx, appPort, "http")
+	if err != nil {
+		t.Fatalf("Failed to get app endpoint: %s", err)
+	}
+	return endpoint
+}
+
+// repoRoot returns the repository root, the Docker build context, wherever
+// the calling test runs from
+func repoRoot() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(filepath.Dir(file))
+}
@@ -0,0 +1,130 @@
+package testhelpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/docker/docker/pkg/stdcopy"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+)
+
+// restoreDumpPath is where RestoreDatabase copies the dump inside the container
+const restoreDumpPath = "/tmp/restore.sql"
+
+// BackupDatabase writes a plain SQL dump of the container's database to w,
+// running pg_dump inside the container and streaming its output as it is
+// produced. The dump drops each object before recreating it, so
+// RestoreDatabase can load it over a database that has changed since.
+func BackupDatabase(ctx context.Context, container *PostgresContainer, w io.Writer) error {
+	if container.PostgresContainer == nil {
+		return fmt.Errorf("backup needs a Postgres container; unset %s", databaseURLEnv)
+	}
+	user, dbName, env, err := dumpTarget(container.ConnStr)
+	if err != nil {
+		return err
+	}
+
+	cmd := []string{"pg_dump", "--username", user, "--dbname", dbName, "--clean", "--if-exists", "--no-owner"}
+	return execStreaming(ctx, container, cmd, env, w)
+}
+
+// RestoreDatabase loads a dump written by BackupDatabase into the
+// container's database, in one transaction, so a failed restore changes
+// nothing. Exec has no stdin, so the dump is read into memory and copied
+// into the container first.
+//
+// Sessions still open on the database keep prepared statements for the
+// tables the dump recreated; prepare them again, e.g. with a new
+// repository.UserRepository.
+func RestoreDatabase(ctx context.Context, container *PostgresContainer, r io.Reader) error {
+	if container.PostgresContainer == nil {
+		return fmt.Errorf("restore needs a Postgres container; unset %s", databaseURLEnv)
+	}
+	user, dbName, env, err := dumpTarget(container.ConnStr)
+	if err != nil {
+		return err
+	}
+
+	dump, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read dump: %w", err)
+	}
+	if err := container.CopyToContainer(ctx, dump, restoreDumpPath, 0o644); err != nil {
+		return fmt.Errorf("failed to copy dump into container: %w", err)
+	}
+
+	cmd := []string{"psql", "--username", user, "--dbname", dbName, "--quiet",
+		"--single-transaction", "--set", "ON_ERROR_STOP=1", "--file", restoreDumpPath}
+	if err := execStreaming(ctx, container, cmd, env, io.Discard); err != nil {
+		return err
+	}
+
+	// Best effort: the file is rewritten by the next restore anyway
+	container.Exec(ctx, []string{"rm", "-f", restoreDumpPath})
+	return nil
+}
+
+// dumpTarget extracts the user and database pg_dump and psql connect as
+// from connStr, with the password in the environment they read it from
+func dumpTarget(connStr string) (user, dbName string, env []string, err error) {
+	u, err := url.Parse(connStr)
+	if err != nil {
+		return "", "", nil, fmt.Errorf("failed to parse connection string: %w", err)
+	}
+	password, _ := u.User.Password()
+	return u.User.Username(), strings.TrimPrefix(u.Path, "/"), []string{"PGPASSWORD=" + password}, nil
+}
+
+// execStreaming runs cmd in the container, copying its stdout to stdout
+// while it runs. A non-zero exit status is an error carrying what cmd
+// wrote to stderr.
+func execStreaming(ctx context.Context, container *PostgresContainer, cmd []string, env []string, stdout io.Writer) error {
+	var stderr bytes.Buffer
+	copied := make(chan error, 1)
+
+	code, _, err := container.Exec(ctx, cmd, tcexec.WithEnv(env), streamOutput(stdout, &stderr, copied))
+	if err != nil {
+		return fmt.Errorf("failed to run %s: %w", cmd[0], err)
+	}
+	// Exec returns once the process has exited; the copy ends at the last
+	// of its output
+	select {
+	case err = <-copied:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if code != 0 {
+		return fmt.Errorf("%s exited with status %d: %s", cmd[0], code, strings.TrimSpace(stderr.String()))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s output: %w", cmd[0], err)
+	}
+	return nil
+}
+
+// streamOutput is a ProcessOption that demultiplexes the exec's output
+// into stdout and stderr while the process runs, then sends the result on
+// done. tcexec.Multiplexed buffers all of it instead, and merges the two.
+//
+// Exec applies its options twice, before and after attaching; only the
+// second time is there a reader to copy from.
+func streamOutput(stdout, stderr io.Writer, done chan<- error) tcexec.ProcessOption {
+	return tcexec.ProcessOptionFunc(func(opts *tcexec.ProcessOptions) {
+		if opts.Reader == nil {
+			return
+		}
+		reader := opts.Reader
+		go func() {
+			_, err := stdcopy.StdCopy(stdout, stderr, reader)
+			if errors.Is(err, io.EOF) {
+				err = nil
+			}
+			done <- err
+		}()
+	})
+}
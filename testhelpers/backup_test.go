@@ -0,0 +1,109 @@
+package testhelpers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"testcontainers-demo/repository"
+)
+
+// TestBackupRestore tests that a backup brings back a dropped users table
+// with its rows and ID sequence, and that the repository works on it as before
+func TestBackupRestore(t *testing.T) {
+	ctx := context.Background()
+	requireContainers(ctx, t)
+
+	container, err := StartPostgres(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start postgres: %v", err)
+	}
+	t.Cleanup(func() { container.Terminate(ctx) })
+
+	repo := repository.NewUserRepository(container.DB)
+	for _, email := range []string{"backup1@example.com", "backup2@example.com"} {
+		if _, err := repo.Create(ctx, email, "Backup User"); err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+	}
+	want, err := repo.List(ctx)
+	if err != nil {
+		t.Fatalf("Failed to list users: %v", err)
+	}
+	repo.Close()
+
+	var dump bytes.Buffer
+	if err := BackupDatabase(ctx, container, &dump); err != nil {
+		t.Fatalf("Failed to back up: %v", err)
+	}
+	if !strings.Contains(dump.String(), "CREATE TABLE public.users") {
+		t.Fatalf("Expected the dump to create the users table, got %d bytes", dump.Len())
+	}
+
+	if _, err := container.DB.ExecContext(ctx, "DROP TABLE users"); err != nil {
+		t.Fatalf("Failed to drop users: %v", err)
+	}
+	if err := RestoreDatabase(ctx, container, bytes.NewReader(dump.Bytes())); err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+
+	// A new repository, since the old one's statements named the dropped table
+	restored := repository.NewUserRepository(container.DB)
+	t.Cleanup(func() { restored.Close() })
+
+	t.Run("Rows Restored", func(t *testing.T) {
+		got, err := restored.List(ctx)
+		if err != nil {
+			t.Fatalf("Failed to list users: %v", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %+v, got: %+v", want, got)
+		}
+	})
+
+	t.Run("Repository Works", func(t *testing.T) {
+		user, err := restored.GetByEmail(ctx, "backup1@example.com")
+		if err != nil {
+			t.Fatalf("Failed to get restored user: %v", err)
+		}
+		if err := restored.Update(ctx, user.ID, user.Email, "Backup User Renamed"); err != nil {
+			t.Errorf("Failed to update restored user: %v", err)
+		}
+
+		// The sequence was restored too, so new IDs follow the old ones
+		created, err := restored.Create(ctx, "after-restore@example.com", "After Restore")
+		if err != nil {
+			t.Fatalf("Failed to create user: %v", err)
+		}
+		if last := want[len(want)-1].ID; created.ID != last+1 {
+			t.Errorf("Expected ID %d, got: %d", last+1, created.ID)
+		}
+
+		// Constraints came back with the table
+		_, err = restored.Create(ctx, "BACKUP2@example.com", "Duplicate")
+		if !errors.Is(err, repository.ErrDuplicateEmail) {
+			t.Errorf("Expected ErrDuplicateEmail, got: %v", err)
+		}
+		if err := restored.Delete(ctx, created.ID); err != nil {
+			t.Errorf("Failed to delete user: %v", err)
+		}
+	})
+
+	t.Run("Failed Restore", func(t *testing.T) {
+		err := RestoreDatabase(ctx, container, strings.NewReader("DELETE FROM users;\nSELECT * FROM no_such_table;\n"))
+		if err == nil || !strings.Contains(err.Error(), "no_such_table") {
+			t.Fatalf("Expected psql's error in the error, got: %v", err)
+		}
+		// One transaction: the DELETE before the error was rolled back
+		count, err := restored.CountUsers(ctx)
+		if err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		if count != len(want) {
+			t.Errorf("Expected %d users after the failed restore, got: %d", len(want), count)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+package testhelpers
+
+import (
+	"testing"
+	"time"
+)
+
+// TestFakeClock tests that the clock stands still until advanced or set
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+
+	if !clock.Now().Equal(start) || !clock.Now().Equal(start) {
+		t.Errorf("Expected the clock stopped at %s, got: %s", start, clock.Now())
+	}
+	clock.Advance(90 * time.Minute)
+	if want := start.Add(90 * time.Minute); !clock.Now().Equal(want) {
+		t.Errorf("Expected %s after Advance, got: %s", want, clock.Now())
+	}
+	clock.Set(start)
+	if !clock.Now().Equal(start) {
+		t.Errorf("Expected %s after Set, got: %s", start, clock.Now())
+	}
+}
@@ -0,0 +1,147 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go/wait"
+	"gopkg.in/yaml.v3"
+)
+
+// composeFileEnv runs the compose-aware tests against the stack in this
+// docker-compose file instead of individually started containers
+const composeFileEnv = "TEST_COMPOSE_FILE"
+
+// ErrNoWaitStrategy is returned for a compose service StartCompose doesn't
+// know how to wait for
+var ErrNoWaitStrategy = errors.New("compose service has no wait strategy")
+
+// ComposeService tells StartCompose which port of a service to resolve and
+// how to tell that the service is ready
+type ComposeService struct {
+	Port string // container port, e.g. "5432/tcp"
+	Wait wait.Strategy
+}
+
+// DefaultComposeServices covers the services of the repository's
+// docker-compose.yml, plus an app service serving /healthz on 8080
+var DefaultComposeServices = map[string]ComposeService{
+	"postgres": {
+		Port: "5432/tcp",
+		Wait: WaitForPostgres(0, startupTimeout),
+	},
+	"redis": {
+		Port: "6379/tcp",
+		Wait: WaitForRedis(startupTimeout),
+	},
+	"app": {
+		Port: "8080/tcp",
+		Wait: wait.ForHTTP("/healthz").
+			WithPort("8080/tcp").
+			WithStartupTimeout(60 * time.Second),
+	},
+}
+
+// ComposeStack is a running docker-compose stack
+type ComposeStack struct {
+	// Endpoints maps each service to its host:port as seen from the tests
+	Endpoints map[string]string
+}
+
+// Endpoint returns the host:port of service, failing the test if the stack
+// has no such service
+func (s *ComposeStack) Endpoint(t testing.TB, service string) string {
+	t.Helper()
+
+	endpoint, ok := s.Endpoints[service]
+	if !ok {
+		t.Fatalf("Compose stack has no service %q", service)
+	}
+	return endpoint
+}
+
+// ComposeOption configures StartCompose
+type ComposeOption func(map[string]ComposeService)
+
+// WithComposeService sets how StartCompose resolves and waits for service,
+// for services DefaultComposeServices doesn't cover
+func WithComposeService(service, port string, strategy wait.Strategy) ComposeOption {
+	return func(services map[string]ComposeService) {
+		services[service] = ComposeService{Port: port, Wait: strategy}
+	}
+}
+
+// ComposeFile returns the docker-compose file set in TEST_COMPOSE_FILE, or ""
+// when the tests should start their own containers
+func ComposeFile() string {
+	return os.Getenv(composeFileEnv)
+}
+
+// StartCompose brings up the stack in composeFile, waits for every service,
+// and resolves their endpoints; the stack is torn down, volumes included,
+// when the test finishes. Every service in the file needs a wait strategy,
+// from DefaultComposeServices or WithComposeService.
+//
+// The compose module is only compiled in with -tags compose; without it
+// StartCompose fails the test.
+func StartCompose(ctx context.Context, t testing.TB, composeFile string, opts ...ComposeOption) *ComposeStack {
+	t.Helper()
+
+	services, err := composeServices(composeFile, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RequireDocker(ctx, t)
+
+	return runCompose(ctx, t, composeFile, services)
+}
+
+// composeServices reads the services of composeFile and pairs each with its
+// ComposeService, returning an error naming every service without one
+func composeServices(composeFile string, opts ...ComposeOption) (map[string]ComposeService, error) {
+	data, err := os.ReadFile(composeFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read compose file: %w", err)
+	}
+
+	var file struct {
+		Services map[string]yaml.Node `yaml:"services"`
+	}
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", composeFile, err)
+	}
+	if len(file.Services) == 0 {
+		return nil, fmt.Errorf("%s defines no services", composeFile)
+	}
+
+	known := make(map[string]ComposeService, len(DefaultComposeServices))
+	for name, svc := range DefaultComposeServices {
+		known[name] = svc
+	}
+	for _, opt := range opts {
+		opt(known)
+	}
+
+	services := make(map[string]ComposeService, len(file.Services))
+	var missing []string
+	for name := range file.Services {
+		svc, ok := known[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		services[name] = svc
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, fmt.Errorf("%w: %v in %s; pass testhelpers.WithComposeService for each",
+			ErrNoWaitStrategy, missing, composeFile)
+	}
+
+	return services, nil
+}
@@ -0,0 +1,67 @@
+//go:build compose
+
+package testhelpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go/modules/compose"
+)
+
+// runCompose brings up composeFile with testcontainers-go's compose module
+func runCompose(ctx context.Context, t testing.TB, composeFile string, services map[string]ComposeService) *ComposeStack {
+	t.Helper()
+
+	// A unique project name keeps parallel runs from sharing containers
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("Failed to generate compose project name: %s", err)
+	}
+
+	stack, err := compose.NewDockerComposeWith(
+		compose.WithStackFiles(composeFile),
+		compose.StackIdentifier("testcontainers-demo-"+hex.EncodeToString(suffix)),
+	)
+	if err != nil {
+		t.Fatalf("Failed to load compose file: %s", err)
+	}
+	t.Cleanup(func() {
+		err := stack.Down(context.Background(), compose.RemoveOrphans(true), compose.RemoveVolumes(true))
+		if err != nil {
+			t.Errorf("Failed to tear down compose stack: %s", err)
+		}
+	})
+
+	for name, svc := range services {
+		stack.WaitForService(name, svc.Wait)
+	}
+
+	// 🐳 START THE COMPOSED STACK
+	if err := stack.Up(ctx, compose.Wait(true)); err != nil {
+		t.Fatalf("Failed to start compose stack: %s", err)
+	}
+
+	endpoints := make(map[string]string, len(services))
+	for name, svc := range services {
+		container, err := stack.ServiceContainer(ctx, name)
+		if err != nil {
+			t.Fatalf("Failed to find %s container: %s", name, err)
+		}
+		host, err := container.Host(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get %s host: %s", name, err)
+		}
+		port, err := container.MappedPort(ctx, nat.Port(svc.Port))
+		if err != nil {
+			t.Fatalf("Failed to get %s port %s: %s", name, svc.Port, err)
+		}
+		endpoints[name] = net.JoinHostPort(host, port.Port())
+	}
+
+	return &ComposeStack{Endpoints: endpoints}
+}
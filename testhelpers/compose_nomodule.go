@@ -0,0 +1,16 @@
+//go:build !compose
+
+package testhelpers
+
+import (
+	"context"
+	"testing"
+)
+
+// runCompose fails the test: the compose module pulls in much of Docker
+// Compose, so it is only compiled in with -tags compose
+func runCompose(_ context.Context, t testing.TB, composeFile string, _ map[string]ComposeService) *ComposeStack {
+	t.Helper()
+	t.Fatalf("Compose support is not compiled in: run go test -tags compose to start %s", composeFile)
+	return nil
+}
@@ -0,0 +1,48 @@
+package testhelpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// TestComposeServices tests that every service in a compose file must have a wait strategy
+func TestComposeServices(t *testing.T) {
+	t.Run("Repository Compose File", func(t *testing.T) {
+		services, err := composeServices("../docker-compose.yml")
+		if err != nil {
+			t.Fatalf("Expected every service to be covered, got: %v", err)
+		}
+		if _, ok := services["postgres"]; !ok || len(services) != 2 {
+			t.Errorf("Expected postgres and redis, got: %v", services)
+		}
+	})
+
+	t.Run("Missing Wait Strategy", func(t *testing.T) {
+		_, err := composeServices("testdata/compose/missing_wait.yml")
+		if !errors.Is(err, ErrNoWaitStrategy) {
+			t.Fatalf("Expected ErrNoWaitStrategy, got: %v", err)
+		}
+		for _, want := range []string{"mailhog", "missing_wait.yml", "WithComposeService"} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Expected the error to mention %s, got: %v", want, err)
+			}
+		}
+		if strings.Contains(err.Error(), "postgres") {
+			t.Errorf("Expected only the uncovered service to be named, got: %v", err)
+		}
+	})
+
+	t.Run("Added With Option", func(t *testing.T) {
+		services, err := composeServices("testdata/compose/missing_wait.yml",
+			WithComposeService("mailhog", "8025/tcp", wait.ForListeningPort("8025/tcp")))
+		if err != nil {
+			t.Fatalf("Expected the option to cover mailhog, got: %v", err)
+		}
+		if services["mailhog"].Port != "8025/tcp" {
+			t.Errorf("Expected mailhog's port from the option, got: %+v", services["mailhog"])
+		}
+	})
+}
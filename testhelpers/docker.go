@@ -0,0 +1,62 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// skipWithoutDockerEnv turns "Docker is unreachable" into a skip instead of a failure when set to 1
+const skipWithoutDockerEnv = "TEST_SKIP_WITHOUT_DOCKER"
+
+// ErrDockerUnavailable is returned when no container can be started because
+// the Docker daemon is unreachable
+var ErrDockerUnavailable = errors.New("docker is not available: start Docker, or set " +
+	databaseURLEnv + ", " + redisAddrEnv + ", " + kafkaBrokersEnv + ", " + mongoURIEnv + ", " + elasticsearchURLEnv + ", " + minioURLEnv + ", " + localstackEndpointEnv + ", " + mailhogURLEnv + ", and " + natsURLEnv + " to run against existing services, or set " +
+	skipWithoutDockerEnv + "=1 to skip integration tests")
+
+// DockerAvailable reports whether testcontainers can reach a healthy Docker
+// daemon, returning an error wrapping ErrDockerUnavailable if not
+func DockerAvailable(ctx context.Context) (err error) {
+	// testcontainers panics when it can't find a Docker host at all
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w (%v)", ErrDockerUnavailable, r)
+		}
+	}()
+
+	provider, err := testcontainers.ProviderDocker.GetProvider()
+	if err != nil {
+		return fmt.Errorf("%w (%v)", ErrDockerUnavailable, err)
+	}
+	defer provider.Close()
+
+	if err := provider.Health(ctx); err != nil {
+		return fmt.Errorf("%w (%v)", ErrDockerUnavailable, err)
+	}
+
+	return nil
+}
+
+// SkipWithoutDocker reports whether integration tests should be skipped,
+// rather than failed, when Docker is unavailable
+func SkipWithoutDocker() bool {
+	return os.Getenv(skipWithoutDockerEnv) == "1"
+}
+
+// RequireDocker fails the test with an actionable message when Docker is
+// unreachable, or skips it when TEST_SKIP_WITHOUT_DOCKER=1
+func RequireDocker(ctx context.Context, t testing.TB) {
+	t.Helper()
+
+	if err := DockerAvailable(ctx); err != nil {
+		if SkipWithoutDocker() {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,26 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// TestDockerAvailable tests that an unreachable daemon yields an actionable error instead of a panic
+func TestDockerAvailable(t *testing.T) {
+	err := DockerAvailable(context.Background())
+	if err == nil {
+		t.Skip("Docker is available; nothing to check")
+	}
+
+	if !errors.Is(err, ErrDockerUnavailable) {
+		t.Fatalf("Expected ErrDockerUnavailable, got: %v", err)
+	}
+
+	for _, hint := range []string{databaseURLEnv, redisAddrEnv, kafkaBrokersEnv, skipWithoutDockerEnv} {
+		if !strings.Contains(err.Error(), hint) {
+			t.Errorf("Expected error to mention %s, got: %v", hint, err)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package testhelpers
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// PlanNode is one node of a plan printed by EXPLAIN (FORMAT JSON), with the
+// fields the assertions read
+type PlanNode struct {
+	NodeType  string     `json:"Node Type"`
+	IndexName string     `json:"Index Name"`
+	Relation  string     `json:"Relation Name"`
+	Plans     []PlanNode `json:"Plans"`
+}
+
+// indexScans are the node types that read an index
+var indexScans = map[string]bool{
+	"Index Scan":        true,
+	"Index Only Scan":   true,
+	"Bitmap Index Scan": true,
+}
+
+// ParsePlan returns the root node of planJSON, as
+// repository.UserRepository.Explain returns it, failing t if it doesn't
+// parse or has no node type
+func ParsePlan(t testing.TB, planJSON string) PlanNode {
+	t.Helper()
+	var plans []struct {
+		Plan PlanNode `json:"Plan"`
+	}
+	if err := json.Unmarshal([]byte(planJSON), &plans); err != nil {
+		t.Fatalf("Failed to parse plan: %v\n%s", err, planJSON)
+	}
+	if len(plans) != 1 || plans[0].Plan.NodeType == "" {
+		t.Fatalf("Expected one plan with a node type, got:\n%s", planJSON)
+	}
+	return plans[0].Plan
+}
+
+// AssertIndexScan fails t unless some node of planJSON scans indexName
+func AssertIndexScan(t testing.TB, planJSON, indexName string) {
+	t.Helper()
+	root := ParsePlan(t, planJSON)
+	var seen []string
+	var walk func(n PlanNode) bool
+	walk = func(n PlanNode) bool {
+		seen = append(seen, n.NodeType)
+		if indexScans[n.NodeType] && n.IndexName == indexName {
+			return true
+		}
+		for _, child := range n.Plans {
+			if walk(child) {
+				return true
+			}
+		}
+		return false
+	}
+	if !walk(root) {
+		t.Errorf("Expected a scan of index %s, got nodes %s:\n%s", indexName, strings.Join(seen, ", "), planJSON)
+	}
+}
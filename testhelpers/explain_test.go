@@ -0,0 +1,48 @@
+package testhelpers
+
+import (
+	"fmt"
+	"testing"
+)
+
+// plan is a nested EXPLAIN (FORMAT JSON) plan, trimmed to the fields read
+const plan = `[{"Plan": {"Node Type": "Limit", "Plans": [
+	{"Node Type": "Index Scan", "Index Name": "users_created_at_idx", "Relation Name": "users"}
+]}}]`
+
+// failureTB records Errorf calls instead of failing the test
+type failureTB struct {
+	testing.TB
+	errors []string
+}
+
+func (f *failureTB) Helper() {}
+
+func (f *failureTB) Errorf(format string, args ...any) {
+	f.errors = append(f.errors, fmt.Sprintf(format, args...))
+}
+
+// TestAssertIndexScan tests that the index is found below the root, and
+// that another index or a sequential scan fails
+func TestAssertIndexScan(t *testing.T) {
+	if root := ParsePlan(t, plan); root.NodeType != "Limit" || len(root.Plans) != 1 {
+		t.Errorf("Expected a Limit over one node, got: %+v", root)
+	}
+
+	for _, tt := range []struct {
+		name, plan, index string
+		pass              bool
+	}{
+		{"Nested Index Scan", plan, "users_created_at_idx", true},
+		{"Other Index", plan, "users_name_idx", false},
+		{"Seq Scan", `[{"Plan": {"Node Type": "Seq Scan", "Relation Name": "users"}}]`, "users_created_at_idx", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &failureTB{TB: t}
+			AssertIndexScan(tb, tt.plan, tt.index)
+			if pass := len(tb.errors) == 0; pass != tt.pass {
+				t.Errorf("Expected pass=%t, got errors: %v", tt.pass, tb.errors)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/testcontainers/testcontainers-go"
+	tcexec "github.com/testcontainers/testcontainers-go/exec"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// errorLogLines is how many lines of container output a failed
+// StartGeneric puts in its error
+const errorLogLines = 20
+
+// Spec describes a one-off container for StartGeneric
+type Spec struct {
+	Image string
+	// Ports to expose, e.g. "8025/tcp"; a bare number means TCP
+	Ports []string
+	Env   map[string]string
+	// WaitFor decides when the container is ready. Nil waits for the first
+	// of Ports to accept connections, or for nothing when there are none.
+	WaitFor wait.Strategy
+	// StartupTimeout bounds WaitFor; zero means 30 seconds
+	StartupTimeout time.Duration
+	// Cmd replaces the image's command
+	Cmd []string
+	// Files are copied into the container before it starts
+	Files []testcontainers.ContainerFile
+}
+
+// Generic is a container started by StartGeneric
+type Generic struct {
+	// Host is where the mapped ports listen
+	Host      string
+	Container testcontainers.Container
+
+	t testing.TB
+}
+
+// StartGeneric starts a container from spec; it is removed when the test
+// finishes. If the container doesn't become ready, the test fails with the
+// wait strategy's error and the last lines the container printed; its
+// output is captured as CaptureLogs does for later failures too. If Docker
+// is unreachable the test fails, or is skipped when
+// TEST_SKIP_WITHOUT_DOCKER=1.
+func StartGeneric(ctx context.Context, t testing.TB, spec Spec) *Generic {
+	t.Helper()
+	RequireDocker(ctx, t)
+
+	// 🐳 START GENERIC CONTAINER
+	container, err := runGeneric(ctx, spec, CaptureLogs(t, DefaultLogLines))
+	// Registered before the error check: a container that failed its wait strategy still needs removing
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get %s host: %s", spec.Image, err)
+	}
+
+	log.Printf("✅ %s container ready!", spec.Image)
+
+	return &Generic{Host: host, Container: container, t: t}
+}
+
+// runGeneric runs spec's container with any extra customizers, returning
+// it even when it failed to become ready, so the caller can remove it
+func runGeneric(ctx context.Context, spec Spec, extra ...testcontainers.ContainerCustomizer) (testcontainers.Container, error) {
+	if spec.Image == "" {
+		return nil, fmt.Errorf("generic container spec has no image")
+	}
+
+	ports := make([]string, len(spec.Ports))
+	for i, p := range spec.Ports {
+		ports[i] = string(containerPort(p))
+	}
+	timeout := spec.StartupTimeout
+	if timeout == 0 {
+		timeout = startupTimeout
+	}
+	strategy := spec.WaitFor
+	if strategy == nil && len(ports) > 0 {
+		strategy = wait.ForListeningPort(nat.Port(ports[0]))
+	}
+
+	opts := []testcontainers.ContainerCustomizer{testcontainers.WithExposedPorts(ports...)}
+	if len(spec.Env) > 0 {
+		opts = append(opts, testcontainers.WithEnv(spec.Env))
+	}
+	if len(spec.Cmd) > 0 {
+		opts = append(opts, testcontainers.WithCmd(spec.Cmd...))
+	}
+	if len(spec.Files) > 0 {
+		opts = append(opts, testcontainers.WithFiles(spec.Files...))
+	}
+	if strategy != nil {
+		opts = append(opts, testcontainers.WithWaitStrategy(wait.ForAll(strategy).WithDeadline(timeout)))
+	}
+
+	container, err := testcontainers.Run(ctx, spec.Image, append(opts, extra...)...)
+	if err != nil {
+		err = fmt.Errorf("failed to start %s container: %w", spec.Image, err)
+		if container != nil {
+			if out := lastLogLines(ctx, container, errorLogLines); out != "" {
+				err = fmt.Errorf("%w\nlast %d lines of container output:\n%s", err, errorLogLines, out)
+			}
+		}
+	}
+	return container, err
+}
+
+// lastLogLines returns the last n lines the container printed, or "" if
+// its logs can't be read
+func lastLogLines(ctx context.Context, container testcontainers.Container, n int) string {
+	rc, err := container.Logs(ctx)
+	if err != nil {
+		return ""
+	}
+	defer rc.Close()
+	out, err := io.ReadAll(rc)
+	if err != nil && len(out) == 0 {
+		return ""
+	}
+	buf := NewLogBuffer(n)
+	buf.Accept(testcontainers.Log{Content: out})
+	return buf.String()
+}
+
+// containerPort normalizes a port to Docker's "number/protocol" form
+func containerPort(port string) nat.Port {
+	if !strings.Contains(port, "/") {
+		port += "/tcp"
+	}
+	return nat.Port(port)
+}
+
+// MappedPort returns the host port port is published on, failing the test
+// if it isn't exposed
+func (g *Generic) MappedPort(port string) int {
+	g.t.Helper()
+
+	mapped, err := g.Container.MappedPort(context.Background(), containerPort(port))
+	if err != nil {
+		g.t.Fatalf("Failed to get mapped port %s: %s", port, err)
+	}
+	n, err := strconv.Atoi(mapped.Port())
+	if err != nil {
+		g.t.Fatalf("Failed to parse mapped port %s: %s", port, err)
+	}
+	return n
+}
+
+// Endpoint returns the host:port the tests reach port on
+func (g *Generic) Endpoint(port string) string {
+	g.t.Helper()
+	return net.JoinHostPort(g.Host, strconv.Itoa(g.MappedPort(port)))
+}
+
+// Exec runs cmd in the container and returns its combined output. A
+// non-zero exit status is an error carrying the output. Unlike the other
+// methods it doesn't fail the test, so it can be called from another
+// goroutine.
+func (g *Generic) Exec(ctx context.Context, cmd ...string) (string, error) {
+	code, r, err := g.Container.Exec(ctx, cmd, tcexec.Multiplexed())
+	if err != nil {
+		return "", fmt.Errorf("exec %q: %w", strings.Join(cmd, " "), err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return "", fmt.Errorf("exec %q: reading output: %w", strings.Join(cmd, " "), err)
+	}
+	if code != 0 {
+		return string(out), fmt.Errorf("exec %q: exit status %d: %s", strings.Join(cmd, " "), code, strings.TrimSpace(string(out)))
+	}
+	return string(out), nil
+}
+
+// MustExec runs cmd as Exec does, failing the test on an error
+func (g *Generic) MustExec(ctx context.Context, cmd ...string) string {
+	g.t.Helper()
+
+	out, err := g.Exec(ctx, cmd...)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	return out
+}
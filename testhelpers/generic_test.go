@@ -0,0 +1,85 @@
+package testhelpers
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// nginxImage is the tiny image the generic container tests start
+const nginxImage = "nginx:1.27-alpine"
+
+// TestContainerPort tests that a bare port number defaults to TCP
+func TestContainerPort(t *testing.T) {
+	for in, want := range map[string]string{"80": "80/tcp", "80/tcp": "80/tcp", "53/udp": "53/udp"} {
+		if got := containerPort(in); string(got) != want {
+			t.Errorf("containerPort(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+// TestStartGeneric tests that a generic container is reachable on its
+// mapped port once started, with its files, environment, and exec helpers
+func TestStartGeneric(t *testing.T) {
+	ctx := context.Background()
+	c := StartGeneric(ctx, t, Spec{
+		Image: nginxImage,
+		Ports: []string{"80"},
+		Env:   map[string]string{"GREETING": "hello from the spec"},
+		Files: []testcontainers.ContainerFile{{
+			HostFilePath:      "testdata/generic_index.html",
+			ContainerFilePath: "/usr/share/nginx/html/index.html",
+			FileMode:          0o644,
+		}},
+	})
+
+	// The default wait strategy already saw port 80 listening
+	resp, err := http.Get("http://" + c.Endpoint("80/tcp") + "/")
+	if err != nil {
+		t.Fatalf("Failed to reach nginx: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "served from a copied file") {
+		t.Errorf("Expected the copied index page, got %s: %s", resp.Status, body)
+	}
+
+	if got := c.MustExec(ctx, "printenv", "GREETING"); strings.TrimSpace(got) != "hello from the spec" {
+		t.Errorf("Expected the spec's environment, got: %q", got)
+	}
+	out, err := c.Exec(ctx, "sh", "-c", "echo gone wrong; exit 3")
+	if err == nil || !strings.Contains(err.Error(), "exit status 3") || !strings.Contains(err.Error(), "gone wrong") {
+		t.Errorf("Expected exit status 3 with the output, got: %v", err)
+	}
+	if strings.TrimSpace(out) != "gone wrong" {
+		t.Errorf("Expected the failed command's output, got: %q", out)
+	}
+}
+
+// TestStartGenericTimeout tests that a container that never becomes ready
+// fails with the container's last output in the error
+func TestStartGenericTimeout(t *testing.T) {
+	ctx := context.Background()
+	RequireDocker(ctx, t)
+
+	container, err := runGeneric(ctx, Spec{
+		Image:          nginxImage,
+		Ports:          []string{"80"},
+		Cmd:            []string{"sh", "-c", "echo never going to listen; sleep 300"},
+		StartupTimeout: 3 * time.Second,
+	})
+	testcontainers.CleanupContainer(t, container)
+	if err == nil {
+		t.Fatal("Expected the wait for port 80 to time out")
+	}
+	for _, want := range []string{nginxImage, "never going to listen"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected the error to mention %q, got: %v", want, err)
+		}
+	}
+}
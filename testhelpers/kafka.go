@@ -0,0 +1,50 @@
+package testhelpers
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/kafka"
+)
+
+// kafkaBrokersEnv points the tests at an existing Kafka (comma-separated host:port list) instead of a container
+const kafkaBrokersEnv = "TEST_KAFKA_BROKERS"
+
+// StartKafka starts a single-node Kafka container in KRaft mode and returns
+// its broker addresses; the container is removed when the test finishes.
+//
+// If TEST_KAFKA_BROKERS is set it returns those brokers instead. Tests
+// should create uniquely named topics so they don't see each other's
+// messages there. If Docker is unreachable the test fails, or is skipped
+// when TEST_SKIP_WITHOUT_DOCKER=1.
+func StartKafka(ctx context.Context, t testing.TB) []string {
+	t.Helper()
+
+	if brokers := os.Getenv(kafkaBrokersEnv); brokers != "" {
+		return strings.Split(brokers, ",")
+	}
+
+	RequireDocker(ctx, t)
+
+	// 🐳 START KAFKA CONTAINER
+	kafkaContainer, err := kafka.Run(ctx, "confluentinc/confluent-local:7.5.0",
+		kafka.WithClusterID("testcontainers-demo"),
+	)
+	testcontainers.CleanupContainer(t, kafkaContainer)
+	if err != nil {
+		t.Fatalf("Failed to start Kafka container: %s", err)
+	}
+
+	brokers, err := kafkaContainer.Brokers(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get Kafka brokers: %s", err)
+	}
+
+	log.Println("✅ Kafka container ready!")
+
+	return brokers
+}
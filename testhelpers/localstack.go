@@ -0,0 +1,62 @@
+package testhelpers
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/localstack"
+)
+
+// localstackImage is the image StartLocalStack starts
+const localstackImage = "localstack/localstack:3.8"
+
+// localstackEndpointEnv points the tests at an existing LocalStack
+// (http://host:4566) instead of a container
+const localstackEndpointEnv = "TEST_LOCALSTACK_ENDPOINT"
+
+// StartLocalStack starts a LocalStack container and returns an AWS config
+// whose clients talk to it; the container is removed when the test finishes.
+//
+// If TEST_LOCALSTACK_ENDPOINT is set it uses that instead. Tests share that
+// instance, so each should create topics and queues of its own. If Docker is
+// unreachable the test fails, or is skipped when TEST_SKIP_WITHOUT_DOCKER=1.
+func StartLocalStack(ctx context.Context, t testing.TB) aws.Config {
+	t.Helper()
+
+	if endpoint := os.Getenv(localstackEndpointEnv); endpoint != "" {
+		return localstackConfig(endpoint)
+	}
+
+	RequireDocker(ctx, t)
+
+	// 🐳 START LOCALSTACK CONTAINER
+	container, err := localstack.Run(ctx, localstackImage, CaptureLogs(t, DefaultLogLines))
+	// Registered before the error check: a container that failed its wait strategy still needs removing
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start LocalStack container: %s", err)
+	}
+
+	endpoint, err := container.PortEndpoint(ctx, "4566/tcp", "http")
+	if err != nil {
+		t.Fatalf("Failed to get LocalStack endpoint: %s", err)
+	}
+
+	log.Println("✅ LocalStack container ready!")
+
+	return localstackConfig(endpoint)
+}
+
+// localstackConfig is an AWS config for endpoint with LocalStack's dummy credentials
+func localstackConfig(endpoint string) aws.Config {
+	return aws.Config{
+		Region:       "us-east-1",
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		BaseEndpoint: aws.String(endpoint),
+	}
+}
@@ -0,0 +1,76 @@
+package testhelpers
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+// DefaultLogLines is how many lines of output StartPostgres and StartRedis
+// keep per container
+const DefaultLogLines = 100
+
+// LogBuffer is a testcontainers.LogConsumer that keeps the last lines a
+// container wrote to stdout and stderr, so a failed startup or test can show
+// what happened inside the container rather than just a wait timeout
+type LogBuffer struct {
+	mu    sync.Mutex
+	lines []string
+	max   int
+}
+
+// NewLogBuffer returns a buffer keeping the last max lines
+func NewLogBuffer(max int) *LogBuffer {
+	return &LogBuffer{max: max}
+}
+
+// Accept implements testcontainers.LogConsumer
+func (b *LogBuffer) Accept(l testcontainers.Log) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(l.Content), "\r\n"), "\n") {
+		b.lines = append(b.lines, strings.TrimRight(line, "\r"))
+	}
+	if len(b.lines) > b.max {
+		b.lines = append([]string(nil), b.lines[len(b.lines)-b.max:]...)
+	}
+}
+
+// Lines returns the buffered lines, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.lines...)
+}
+
+// String returns the buffered lines joined by newlines
+func (b *LogBuffer) String() string {
+	return strings.Join(b.Lines(), "\n")
+}
+
+// CaptureLogs buffers the last lines of a container's output and logs them
+// through t if the test fails, including when the container never becomes
+// ready. Pass it to any Run call; StartPostgres and StartRedis already do.
+func CaptureLogs(t testing.TB, lines int) testcontainers.CustomizeRequestOption {
+	t.Helper()
+
+	buf := NewLogBuffer(lines)
+	t.Cleanup(func() {
+		if t.Failed() && len(buf.Lines()) > 0 {
+			t.Logf("Last %d lines of container output:\n%s", lines, buf)
+		}
+	})
+	return testcontainers.WithLogConsumers(buf)
+}
+
+// logContainerOutput logs what a container printed before its setup failed,
+// for callers without a testing.TB such as TestMain
+func logContainerOutput(name string, buf *LogBuffer) {
+	if out := buf.String(); out != "" {
+		log.Printf("Last %d lines of %s container output:\n%s", buf.max, name, out)
+	}
+}
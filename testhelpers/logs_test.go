@@ -0,0 +1,55 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// TestLogBuffer tests that the buffer splits output into lines and keeps only the last ones
+func TestLogBuffer(t *testing.T) {
+	buf := NewLogBuffer(3)
+	buf.Accept(testcontainers.Log{LogType: testcontainers.StdoutLog, Content: []byte("one\n")})
+	buf.Accept(testcontainers.Log{LogType: testcontainers.StderrLog, Content: []byte("two\r\nthree\n")})
+	buf.Accept(testcontainers.Log{LogType: testcontainers.StdoutLog, Content: []byte("four")})
+
+	if got := fmt.Sprint(buf.Lines()); got != "[two three four]" {
+		t.Errorf("Expected the last 3 lines, got: %s", got)
+	}
+	if got := buf.String(); got != "two\nthree\nfour" {
+		t.Errorf("Expected newline-joined lines, got: %q", got)
+	}
+}
+
+// TestCaptureLogsInvalidInitScript tests that a container failing its init
+// script leaves the SQL error in the captured output, not just a wait timeout
+func TestCaptureLogsInvalidInitScript(t *testing.T) {
+	ctx := context.Background()
+	RequireDocker(ctx, t)
+
+	buf := NewLogBuffer(DefaultLogLines)
+	container, err := postgres.Run(ctx, PostgresImage(),
+		append(postgresCustomizers(),
+			postgres.WithInitScripts("testdata/invalid_init.sql"),
+			testcontainers.WithLogConsumers(buf),
+		)...,
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err == nil {
+		t.Fatal("Expected the container to fail its wait strategy")
+	}
+
+	// Logs can still be in flight when the wait strategy gives up
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(buf.String(), `syntax error at or near "TABEL"`) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the syntax error in the captured output, got:\n%s", buf)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
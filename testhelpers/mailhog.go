@@ -0,0 +1,66 @@
+package testhelpers
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+const (
+	// mailhogImage is the image StartMailhog starts
+	mailhogImage = "mailhog/mailhog:v1.0.1"
+
+	mailhogSMTPPort = "1025/tcp"
+	mailhogAPIPort  = "8025/tcp"
+)
+
+// mailhogURLEnv points the tests at an existing Mailhog's HTTP API
+// (http://host:8025) instead of a container; its SMTP server is expected on
+// port 1025 of the same host
+const mailhogURLEnv = "TEST_MAILHOG_URL"
+
+// Mailhog is a running Mailhog: an SMTP server that accepts every message
+// and an HTTP API to read them back
+type Mailhog struct {
+	SMTPHost string
+	SMTPPort int
+	APIURL   string // e.g. http://localhost:32768, without a trailing slash
+}
+
+// StartMailhog starts a Mailhog container; it is removed when the test
+// finishes.
+//
+// If TEST_MAILHOG_URL is set it uses that instead. Tests share that
+// instance, so each should look only for mail to recipients of its own. If
+// Docker is unreachable the test fails, or is skipped when
+// TEST_SKIP_WITHOUT_DOCKER=1.
+func StartMailhog(ctx context.Context, t testing.TB) *Mailhog {
+	t.Helper()
+
+	if apiURL := os.Getenv(mailhogURLEnv); apiURL != "" {
+		u, err := url.Parse(apiURL)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %s", mailhogURLEnv, err)
+		}
+		return &Mailhog{SMTPHost: u.Hostname(), SMTPPort: 1025, APIURL: apiURL}
+	}
+
+	// 🐳 START MAILHOG CONTAINER
+	container := StartGeneric(ctx, t, Spec{
+		Image: mailhogImage,
+		Ports: []string{mailhogSMTPPort, mailhogAPIPort},
+		WaitFor: wait.ForAll(
+			wait.ForListeningPort(mailhogSMTPPort),
+			wait.ForHTTP("/api/v2/messages").WithPort(mailhogAPIPort),
+		),
+	})
+
+	return &Mailhog{
+		SMTPHost: container.Host,
+		SMTPPort: container.MappedPort(mailhogSMTPPort),
+		APIURL:   "http://" + container.Endpoint(mailhogAPIPort),
+	}
+}
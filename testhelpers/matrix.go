@@ -0,0 +1,67 @@
+package testhelpers
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// postgresMatrixEnv lists, comma separated, the Postgres images a suite runs
+// against one after the other, e.g. postgres:13,postgres:15,postgres:16
+const postgresMatrixEnv = "TEST_POSTGRES_MATRIX"
+
+// PostgresMatrix returns the images in TEST_POSTGRES_MATRIX, or just
+// PostgresImage() when it is unset. Against TEST_DATABASE_URL there is no
+// image to choose, so the matrix is ignored.
+func PostgresMatrix() []string {
+	if os.Getenv(databaseURLEnv) != "" {
+		return []string{PostgresImage()}
+	}
+
+	var images []string
+	for _, image := range strings.Split(os.Getenv(postgresMatrixEnv), ",") {
+		if image = strings.TrimSpace(image); image != "" {
+			images = append(images, image)
+		}
+	}
+	if len(images) == 0 {
+		return []string{PostgresImage()}
+	}
+	return images
+}
+
+// RunPostgresMatrix calls run once for each image of PostgresMatrix, in
+// order, and returns 1 if any run failed. run is a TestMain body that starts
+// Postgres with WithImage(image) and returns m.Run's exit code.
+//
+// With more than one image, each run is announced and a per-image summary is
+// logged at the end, since go test's own output doesn't say which version a
+// failing test ran against.
+func RunPostgresMatrix(run func(image string) int) int {
+	images := PostgresMatrix()
+	if len(images) == 1 {
+		return run(images[0])
+	}
+
+	var failed []string
+	for _, image := range images {
+		log.Printf("=== Postgres matrix: %s", image)
+		if code := run(image); code != 0 {
+			failed = append(failed, image)
+		}
+	}
+
+	for _, image := range images {
+		result := "ok"
+		for _, f := range failed {
+			if f == image {
+				result = "FAIL"
+			}
+		}
+		log.Printf("--- Postgres matrix: %s %s", result, image)
+	}
+	if len(failed) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,70 @@
+package testhelpers
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestPostgresMatrix tests which images a suite runs against
+func TestPostgresMatrix(t *testing.T) {
+	t.Setenv(databaseURLEnv, "")
+
+	t.Run("Default Image", func(t *testing.T) {
+		t.Setenv(postgresMatrixEnv, "")
+		t.Setenv(postgresImageEnv, "")
+		if got := PostgresMatrix(); !reflect.DeepEqual(got, []string{DefaultPostgresImage}) {
+			t.Errorf("Expected [%s], got: %v", DefaultPostgresImage, got)
+		}
+	})
+
+	t.Run("Image Override", func(t *testing.T) {
+		t.Setenv(postgresMatrixEnv, "")
+		t.Setenv(postgresImageEnv, "postgres:16")
+		if got := PostgresMatrix(); !reflect.DeepEqual(got, []string{"postgres:16"}) {
+			t.Errorf("Expected [postgres:16], got: %v", got)
+		}
+	})
+
+	t.Run("Matrix", func(t *testing.T) {
+		t.Setenv(postgresMatrixEnv, " postgres:13, postgres:15,,postgres:16 ")
+		want := []string{"postgres:13", "postgres:15", "postgres:16"}
+		if got := PostgresMatrix(); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %v, got: %v", want, got)
+		}
+	})
+
+	t.Run("Runs Every Image In Order", func(t *testing.T) {
+		t.Setenv(postgresMatrixEnv, "postgres:13,postgres:15,postgres:16")
+
+		var ran []string
+		code := RunPostgresMatrix(func(image string) int {
+			ran = append(ran, image)
+			if image == "postgres:13" {
+				return 1
+			}
+			return 0
+		})
+		if code != 1 {
+			t.Errorf("Expected exit code 1 after a failing image, got: %d", code)
+		}
+		if want := []string{"postgres:13", "postgres:15", "postgres:16"}; !reflect.DeepEqual(ran, want) {
+			t.Errorf("Expected every image to run after a failure, got: %v", ran)
+		}
+	})
+
+	t.Run("Ignored For External Database", func(t *testing.T) {
+		t.Setenv(postgresMatrixEnv, "postgres:13,postgres:16")
+		t.Setenv(postgresImageEnv, "")
+		t.Setenv(databaseURLEnv, "postgres://localhost/testdb")
+		if got := PostgresMatrix(); len(got) != 1 {
+			t.Errorf("Expected a single run, got: %v", got)
+		}
+	})
+}
+
+// TestContainerNameSuffix tests that image references become valid name parts
+func TestContainerNameSuffix(t *testing.T) {
+	if got := containerNameSuffix("ghcr.io/org/postgres:16-alpine"); got != "ghcr.io-org-postgres-16-alpine" {
+		t.Errorf("Expected ghcr.io-org-postgres-16-alpine, got: %s", got)
+	}
+}
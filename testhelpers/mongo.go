@@ -0,0 +1,79 @@
+package testhelpers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/mongodb"
+	"go.mongodb.org/mongo-driver/v2/mongo"
+	"go.mongodb.org/mongo-driver/v2/mongo/options"
+)
+
+// mongoImage is the image StartMongo starts
+const mongoImage = "mongo:7"
+
+// mongoURIEnv points the tests at an existing MongoDB (a mongodb:// URI)
+// instead of a container
+const mongoURIEnv = "TEST_MONGO_URI"
+
+// StartMongo starts a MongoDB container and returns an empty database on it;
+// both are torn down when the test finishes.
+//
+// If TEST_MONGO_URI is set it connects there instead and returns a database
+// with a random name, dropped when the test finishes. If Docker is
+// unreachable the test fails, or is skipped when TEST_SKIP_WITHOUT_DOCKER=1.
+func StartMongo(ctx context.Context, t testing.TB) *mongo.Database {
+	t.Helper()
+
+	if uri := os.Getenv(mongoURIEnv); uri != "" {
+		suffix := make([]byte, 8)
+		if _, err := rand.Read(suffix); err != nil {
+			t.Fatalf("Failed to generate database name: %v", err)
+		}
+		db := connectMongo(ctx, t, uri).Database("test_" + hex.EncodeToString(suffix))
+		t.Cleanup(func() { db.Drop(context.Background()) })
+		return db
+	}
+
+	RequireDocker(ctx, t)
+
+	// 🐳 START MONGODB CONTAINER
+	container, err := mongodb.Run(ctx, mongoImage, CaptureLogs(t, DefaultLogLines))
+	// Registered before the error check: a container that failed its wait strategy still needs removing
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start MongoDB container: %s", err)
+	}
+
+	uri, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get MongoDB connection string: %s", err)
+	}
+	db := connectMongo(ctx, t, uri).Database(postgresDB)
+
+	log.Println("✅ MongoDB container ready!")
+
+	return db
+}
+
+// connectMongo connects to uri and checks the server answers, disconnecting
+// when the test finishes
+func connectMongo(ctx context.Context, t testing.TB, uri string) *mongo.Client {
+	t.Helper()
+
+	client, err := mongo.Connect(options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatalf("Failed to connect to MongoDB: %s", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+
+	if err := client.Ping(ctx, nil); err != nil {
+		t.Fatalf("Failed to ping MongoDB: %s", err)
+	}
+	return client
+}
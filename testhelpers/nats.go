@@ -0,0 +1,48 @@
+package testhelpers
+
+import (
+	"context"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+	tcnats "github.com/testcontainers/testcontainers-go/modules/nats"
+)
+
+// natsURLEnv points the tests at an existing NATS server with JetStream
+// enabled (nats://host:4222) instead of a container
+const natsURLEnv = "TEST_NATS_URL"
+
+// StartNATS starts a NATS container with JetStream enabled and returns its
+// client URL; the container is removed when the test finishes.
+//
+// If TEST_NATS_URL is set it returns that URL instead. Streams there are
+// shared between tests, so use a disposable server. If Docker is
+// unreachable the test fails, or is skipped when TEST_SKIP_WITHOUT_DOCKER=1.
+func StartNATS(ctx context.Context, t testing.TB) string {
+	t.Helper()
+
+	if url := os.Getenv(natsURLEnv); url != "" {
+		return url
+	}
+
+	RequireDocker(ctx, t)
+
+	// 🐳 START NATS CONTAINER
+	container, err := tcnats.Run(ctx, "nats:2.10", CaptureLogs(t, DefaultLogLines))
+	// Registered before the error check: a container that failed its wait strategy still needs removing
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start NATS container: %s", err)
+	}
+
+	url, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get NATS URL: %s", err)
+	}
+
+	log.Println("✅ NATS container ready!")
+
+	return url
+}
@@ -0,0 +1,145 @@
+package testhelpers
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+
+	"testcontainers-demo/db"
+	"testcontainers-demo/migrations"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+	"github.com/testcontainers/testcontainers-go/network"
+)
+
+// Network aliases of the services StartStack and the proxy helpers start,
+// and the addresses sibling containers reach them at
+const (
+	PostgresAlias        = "postgres"
+	RedisAlias           = "redis"
+	PostgresInternalAddr = PostgresAlias + ":5432"
+	RedisInternalAddr    = RedisAlias + ":6379"
+)
+
+// Stack is a migrated and seeded Postgres and a Redis on one Docker network.
+// The test process reaches them through host-mapped ports; containers on
+// Network, such as the application under test, use the aliases.
+type Stack struct {
+	Network *testcontainers.DockerNetwork
+
+	// For the test process
+	PostgresDSN string
+	RedisAddr   string
+
+	// For containers on Network
+	InternalPostgresDSN string // postgres://...@postgres:5432/testdb
+	InternalRedisAddr   string // redis:6379
+}
+
+// StartStack creates a network and starts Postgres and Redis on it under
+// PostgresAlias and RedisAlias; everything is removed when the test
+// finishes. It always needs Docker, even when TEST_DATABASE_URL or
+// TEST_REDIS_ADDR is set.
+func StartStack(ctx context.Context, t testing.TB) *Stack {
+	t.Helper()
+	RequireDocker(ctx, t)
+	nw := newNetwork(ctx, t)
+
+	pgDSN := startPostgresOn(ctx, t, nw, PostgresAlias)
+	redisAddr := startRedisOn(ctx, t, nw, RedisAlias)
+
+	internalURL, err := url.Parse(pgDSN)
+	if err != nil {
+		t.Fatalf("Failed to parse connection string: %s", err)
+	}
+	internalURL.Host = PostgresInternalAddr
+
+	return &Stack{
+		Network:             nw,
+		PostgresDSN:         pgDSN,
+		RedisAddr:           redisAddr,
+		InternalPostgresDSN: internalURL.String(),
+		InternalRedisAddr:   RedisInternalAddr,
+	}
+}
+
+// newNetwork creates a Docker network that is removed when the test finishes
+func newNetwork(ctx context.Context, t testing.TB) *testcontainers.DockerNetwork {
+	t.Helper()
+
+	nw, err := network.New(ctx)
+	if err != nil {
+		t.Fatalf("Failed to create Docker network: %s", err)
+	}
+	// Registered before the containers, so it runs after they are gone
+	t.Cleanup(func() { nw.Remove(context.Background()) })
+	return nw
+}
+
+// startPostgresOn starts Postgres on nw under alias, migrates and seeds it,
+// and returns its host-mapped connection string
+func startPostgresOn(ctx context.Context, t testing.TB, nw *testcontainers.DockerNetwork, alias string) string {
+	t.Helper()
+
+	// 🐳 START POSTGRESQL CONTAINER ON THE NETWORK
+	container, err := postgres.Run(ctx, PostgresImage(),
+		append(postgresCustomizers(),
+			network.WithNetwork([]string{alias}, nw),
+			CaptureLogs(t, DefaultLogLines),
+		)...,
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start Postgres container: %s", err)
+	}
+
+	connStr, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to get connection string: %s", err)
+	}
+
+	conn, err := db.Connect(ctx, connStr)
+	if err != nil {
+		t.Fatalf("Failed to connect to Postgres: %s", err)
+	}
+	defer conn.Close()
+	if err := migrations.RunMigrations(ctx, conn); err != nil {
+		t.Fatalf("Failed to run migrations: %s", err)
+	}
+	if err := migrations.Seed(ctx, conn); err != nil {
+		t.Fatalf("Failed to seed database: %s", err)
+	}
+
+	return connStr
+}
+
+// startRedisOn starts Redis on nw under alias and returns its host-mapped address
+func startRedisOn(ctx context.Context, t testing.TB, nw *testcontainers.DockerNetwork, alias string) string {
+	t.Helper()
+
+	// 🐳 START REDIS CONTAINER ON THE NETWORK
+	container, err := redis.Run(ctx, redisImage,
+		append(redisCustomizers(),
+			network.WithNetwork([]string{alias}, nw),
+			CaptureLogs(t, DefaultLogLines),
+		)...,
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start Redis container: %s", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get Redis host: %s", err)
+	}
+	port, err := container.MappedPort(ctx, "6379/tcp")
+	if err != nil {
+		t.Fatalf("Failed to get Redis port: %s", err)
+	}
+
+	return net.JoinHostPort(host, port.Port())
+}
@@ -0,0 +1,46 @@
+package testhelpers
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// TestStartStack tests that a sibling container on the stack's network
+// reaches Postgres and Redis by their aliases
+func TestStartStack(t *testing.T) {
+	ctx := context.Background()
+	stack := StartStack(ctx, t)
+
+	if !strings.Contains(stack.InternalPostgresDSN, "@"+PostgresInternalAddr+"/") {
+		t.Errorf("Expected the internal DSN to use %s, got: %s", PostgresInternalAddr, stack.InternalPostgresDSN)
+	}
+	if stack.PostgresDSN == stack.InternalPostgresDSN || stack.RedisAddr == stack.InternalRedisAddr {
+		t.Errorf("Expected host-mapped endpoints, got: %s and %s", stack.PostgresDSN, stack.RedisAddr)
+	}
+
+	// 🐳 START A SIBLING CONTAINER THAT ONLY CHECKS THE PORTS
+	prober, err := testcontainers.Run(ctx, "alpine:3.20",
+		network.WithNetwork(nil, stack.Network),
+		testcontainers.WithCmd("sh", "-c",
+			"nc -z -w 5 "+PostgresAlias+" 5432 && nc -z -w 5 "+RedisAlias+" 6379"),
+		testcontainers.WithWaitStrategy(wait.ForExit().WithExitTimeout(30*time.Second)),
+	)
+	testcontainers.CleanupContainer(t, prober)
+	if err != nil {
+		t.Fatalf("Failed to run prober: %v", err)
+	}
+
+	state, err := prober.State(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get prober state: %v", err)
+	}
+	if state.ExitCode != 0 {
+		t.Errorf("Expected nc to reach %s and %s, exit code: %d", PostgresInternalAddr, RedisInternalAddr, state.ExitCode)
+	}
+}
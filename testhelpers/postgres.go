@@ -0,0 +1,514 @@
+// Package testhelpers starts the containers shared by the integration tests
+package testhelpers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"testcontainers-demo/db"
+	"testcontainers-demo/migrations"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+)
+
+// defaultReuseName is the container name shared by every package in reuse mode
+const defaultReuseName = "testcontainers-demo-postgres"
+
+// DefaultPostgresImage is the image the Postgres helpers start unless
+// TEST_POSTGRES_IMAGE or WithImage names another
+const DefaultPostgresImage = "postgres:15"
+
+// postgresImageEnv overrides DefaultPostgresImage for every Postgres helper,
+// e.g. TEST_POSTGRES_IMAGE=postgres:16
+const postgresImageEnv = "TEST_POSTGRES_IMAGE"
+
+// reuseEnv enables reuse mode for every StartPostgres call when set to 1
+const reuseEnv = "TESTCONTAINERS_REUSE"
+
+// databaseURLEnv points the tests at an existing database instead of a container
+const databaseURLEnv = "TEST_DATABASE_URL"
+
+// templateDB is the snapshot of the seeded database, used both by ResetDB and
+// as the TEMPLATE for CreateTestDatabase
+const templateDB = "testdb_template"
+
+// PostgresContainer is a running Postgres container with an open connection.
+// When TEST_DATABASE_URL is set there is no container and the embedded
+// *postgres.PostgresContainer is nil.
+type PostgresContainer struct {
+	*postgres.PostgresContainer
+	DB      *sql.DB
+	ConnStr string
+
+	// Logs keeps the container's last lines of output; nil without a container
+	Logs *LogBuffer
+
+	// pool is the connection pool configuration shared by DB and the
+	// databases from CreateTestDatabase
+	pool db.Config
+
+	// dsn is the connection string DB dials, moved by RestartPostgres to
+	// the container's new port; nil against TEST_DATABASE_URL
+	dsn *atomic.Pointer[string]
+
+	reused   bool
+	external bool
+
+	// createMu serializes CREATE DATABASE, which can fail while another
+	// session is copying the same template
+	createMu sync.Mutex
+}
+
+// postgresConfig holds the StartPostgres options
+type postgresConfig struct {
+	reuseName string
+	image     string
+}
+
+// PostgresOption configures StartPostgres
+type PostgresOption func(*postgresConfig)
+
+// WithReuse attaches to an already-running container with the given name
+// (or creates it) instead of starting a fresh one, and leaves it running on
+// Terminate. An empty name uses the name shared with TESTCONTAINERS_REUSE=1.
+//
+// Seed data is reloaded on every attach, so packages sharing the container
+// must not run concurrently (go test -p 1 ./...). To keep the container
+// across go test runs, Ryuk must also be disabled
+// (TESTCONTAINERS_RYUK_DISABLED=true), otherwise it is reaped with the session.
+func WithReuse(name string) PostgresOption {
+	return func(cfg *postgresConfig) {
+		if name == "" {
+			name = defaultReuseName
+		}
+		cfg.reuseName = name
+	}
+}
+
+// WithImage starts the given Postgres image instead of PostgresImage(). The
+// migrations and seed data are the same for every version.
+func WithImage(image string) PostgresOption {
+	return func(cfg *postgresConfig) {
+		cfg.image = image
+	}
+}
+
+// PostgresImage returns the image the Postgres helpers start:
+// TEST_POSTGRES_IMAGE if set, otherwise DefaultPostgresImage
+func PostgresImage() string {
+	if image := os.Getenv(postgresImageEnv); image != "" {
+		return image
+	}
+	return DefaultPostgresImage
+}
+
+// StartPostgres starts a PostgresImage() container, runs the migrations, loads the
+// seed data, and connects to it. If TEST_DATABASE_URL is set it migrates and
+// seeds that database instead; if the variable is unset and Docker is
+// unreachable the error wraps ErrDockerUnavailable.
+func StartPostgres(ctx context.Context, opts ...PostgresOption) (*PostgresContainer, error) {
+	pool, err := db.NewConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure connection pool: %w", err)
+	}
+
+	if dsn := os.Getenv(databaseURLEnv); dsn != "" {
+		return connectExternal(ctx, dsn, pool)
+	}
+	if err := DockerAvailable(ctx); err != nil {
+		return nil, err
+	}
+
+	cfg := postgresConfig{image: PostgresImage()}
+	if os.Getenv(reuseEnv) == "1" {
+		WithReuse("")(&cfg)
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	// Packages on different versions must not attach to each other's container
+	if cfg.reuseName == defaultReuseName && cfg.image != DefaultPostgresImage {
+		cfg.reuseName += "-" + containerNameSuffix(cfg.image)
+	}
+
+	logs := NewLogBuffer(DefaultLogLines)
+	customizers := append(postgresCustomizers(), testcontainers.WithLogConsumers(logs))
+	if cfg.reused() {
+		customizers = append(customizers, testcontainers.WithReuseByName(cfg.reuseName))
+	}
+
+	// 🐳 START POSTGRESQL CONTAINER WITH WAIT STRATEGY
+	// A container that started but failed its wait strategy is returned along
+	// with the error, and setupPostgres removes it
+	container, err := postgres.Run(ctx, cfg.image, customizers...)
+	if err != nil {
+		err = fmt.Errorf("failed to start %s container: %w", cfg.image, err)
+		logContainerOutput("postgres", logs)
+		if container != nil && !cfg.reused() {
+			terminateAfterError(container, err)
+		}
+		return nil, err
+	}
+
+	// DB dials whatever dsn holds, so it survives RestartPostgres
+	dsn := &atomic.Pointer[string]{}
+	connect := func(ctx context.Context, connStr string, opts ...db.Option) (*sql.DB, error) {
+		dsn.Store(&connStr)
+		return db.ConnectFunc(ctx, func() string { return *dsn.Load() }, opts...)
+	}
+	conn, connStr, err := setupPostgres(ctx, container, cfg, pool, connect)
+	if err != nil {
+		logContainerOutput("postgres", logs)
+		return nil, err
+	}
+
+	return &PostgresContainer{
+		PostgresContainer: container,
+		DB:                conn,
+		ConnStr:           connStr,
+		Logs:              logs,
+		pool:              pool,
+		dsn:               dsn,
+		reused:            cfg.reused(),
+	}, nil
+}
+
+// containerNameSuffix turns an image reference such as "postgres:16-alpine"
+// into characters Docker accepts in a container name
+func containerNameSuffix(image string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '-'
+	}, image)
+}
+
+// reused reports whether the container is shared by name with other packages
+func (cfg postgresConfig) reused() bool {
+	return cfg.reuseName != ""
+}
+
+// postgresInstance is the part of *postgres.PostgresContainer setupPostgres
+// uses, so its cleanup can be tested without Docker
+type postgresInstance interface {
+	ConnectionString(ctx context.Context, args ...string) (string, error)
+	Snapshot(ctx context.Context, opts ...postgres.SnapshotOption) error
+	Terminate(ctx context.Context, opts ...testcontainers.TerminateOption) error
+}
+
+// connector opens a connection pool; db.Connect outside of tests
+type connector func(ctx context.Context, dsn string, opts ...db.Option) (*sql.DB, error)
+
+// setupPostgres connects to a started container, migrates and seeds it, and
+// snapshots the result. If any step fails the container is terminated and
+// that step's error returned; a failed Terminate is only logged, so it can't
+// hide the cause. A reused container is left running for the next attach.
+func setupPostgres(ctx context.Context, container postgresInstance, cfg postgresConfig, pool db.Config, connect connector) (conn *sql.DB, connStr string, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		if conn != nil {
+			conn.Close()
+			conn = nil
+		}
+		if !cfg.reused() {
+			terminateAfterError(container, err)
+		}
+	}()
+
+	// Get connection string with SSL mode disabled
+	connStr, err = container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to get connection string: %w", err)
+	}
+
+	// Connect to database, retrying until it accepts connections
+	conn, err = connect(ctx, connStr, db.WithConfig(pool))
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := migrations.RunMigrations(ctx, conn); err != nil {
+		return conn, "", fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	// A reused container keeps whatever the previous run left behind
+	if cfg.reused() {
+		err = reloadSeed(ctx, conn)
+	} else {
+		err = migrations.Seed(ctx, conn)
+	}
+	if err != nil {
+		return conn, "", err
+	}
+
+	// Snapshot the seeded database so tests can ResetDB back to it; Postgres
+	// refuses to copy a database that has open sessions
+	dropIdleConns(conn, pool)
+	if err := container.Snapshot(ctx, postgres.WithSnapshotName(templateDB)); err != nil {
+		return conn, "", fmt.Errorf("failed to snapshot database: %w", err)
+	}
+
+	return conn, connStr, nil
+}
+
+// terminateAfterError removes a container whose setup failed with cause. A
+// failure to remove it is logged rather than returned, so the caller reports
+// cause, the error that matters.
+func terminateAfterError(container postgresInstance, cause error) {
+	if err := container.Terminate(context.Background()); err != nil {
+		log.Printf("Failed to terminate container after %v: %v", cause, err)
+	}
+}
+
+// postgresCustomizers configures the Postgres container every helper starts
+func postgresCustomizers() []testcontainers.ContainerCustomizer {
+	return []testcontainers.ContainerCustomizer{
+		postgres.WithDatabase(postgresDB),
+		postgres.WithUsername(postgresUser),
+		postgres.WithPassword(postgresPassword),
+		// The seed data is loaded after startup, so there are no rows to wait for
+		testcontainers.WithWaitStrategy(WaitForPostgres(0, startupTimeout)),
+	}
+}
+
+// connectExternal connects to an existing database and makes sure the schema and seed data exist
+func connectExternal(ctx context.Context, dsn string, pool db.Config) (*PostgresContainer, error) {
+	conn, err := db.Connect(ctx, dsn, db.WithConfig(pool))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", databaseURLEnv, err)
+	}
+
+	if err := migrations.RunMigrations(ctx, conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+	if err := migrations.Seed(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &PostgresContainer{
+		DB:       conn,
+		ConnStr:  dsn,
+		pool:     pool,
+		external: true,
+	}, nil
+}
+
+// reloadSeed empties the users, user_events, audit_log, archived_users, orders, and webhook_deliveries tables and reloads the seed data so IDs start at 1 again
+func reloadSeed(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, "TRUNCATE users, user_events, audit_log, archived_users, orders, webhook_deliveries RESTART IDENTITY CASCADE"); err != nil {
+		return fmt.Errorf("failed to truncate users: %w", err)
+	}
+	return migrations.Seed(ctx, db)
+}
+
+// ResetDB restores the database to the snapshot taken right after seeding,
+// discarding every row written since. Call it at the start of each top-level test.
+//
+// Against TEST_DATABASE_URL there is no snapshot; the users table is
+// truncated and the seed data reloaded instead.
+func (c *PostgresContainer) ResetDB(t testing.TB) {
+	t.Helper()
+
+	if c.external {
+		if err := reloadSeed(context.Background(), c.DB); err != nil {
+			t.Fatalf("Failed to reset database: %v", err)
+		}
+		return
+	}
+
+	if err := c.Restore(context.Background()); err != nil {
+		t.Fatalf("Failed to restore database snapshot: %v", err)
+	}
+
+	// Restore drops and recreates the database, which kills every pooled connection
+	dropIdleConns(c.DB, c.pool)
+}
+
+// CreateTestDatabase clones the seeded template into a new database and
+// returns a connection to it; the database is dropped when the test finishes.
+// Cloning is much faster than re-running the migrations and seed data, so
+// each parallel test can own a database.
+func (c *PostgresContainer) CreateTestDatabase(ctx context.Context, t testing.TB) *sql.DB {
+	t.Helper()
+
+	if c.external {
+		t.Skipf("CreateTestDatabase needs the container's template database; unset %s to run it", databaseURLEnv)
+	}
+
+	return c.createDatabase(ctx, t, templateDB, "")
+}
+
+// CreateTestDatabaseInTimeZone is CreateTestDatabase with every session's
+// TimeZone set to timeZone, e.g. "Pacific/Chatham", for tests that times
+// read back the same whatever the server's zone is
+func (c *PostgresContainer) CreateTestDatabaseInTimeZone(ctx context.Context, t testing.TB, timeZone string) *sql.DB {
+	t.Helper()
+
+	if c.external {
+		t.Skipf("CreateTestDatabaseInTimeZone needs the container's template database; unset %s to run it", databaseURLEnv)
+	}
+
+	return c.createDatabase(ctx, t, templateDB, timeZone)
+}
+
+// CreateEmptyDatabase creates a database with no tables, not even
+// schema_migrations, for tests that start from scratch; it is dropped when
+// the test finishes
+func (c *PostgresContainer) CreateEmptyDatabase(ctx context.Context, t testing.TB) *sql.DB {
+	t.Helper()
+
+	if c.external {
+		t.Skipf("CreateEmptyDatabase needs CREATE DATABASE on the test container; unset %s to run it", databaseURLEnv)
+	}
+
+	return c.createDatabase(ctx, t, "template0", "")
+}
+
+// createDatabase copies template into a uniquely named database and connects
+// to it. A timeZone other than "" becomes the database's default TimeZone,
+// set before the first connection.
+func (c *PostgresContainer) createDatabase(ctx context.Context, t testing.TB, template, timeZone string) *sql.DB {
+	t.Helper()
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		t.Fatalf("Failed to generate database name: %v", err)
+	}
+	name := "test_" + hex.EncodeToString(suffix)
+
+	c.createMu.Lock()
+	_, err := c.DB.ExecContext(ctx, fmt.Sprintf(`CREATE DATABASE "%s" TEMPLATE "%s"`, name, template))
+	c.createMu.Unlock()
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := c.DB.Exec(fmt.Sprintf(`DROP DATABASE IF EXISTS "%s" WITH (FORCE)`, name)); err != nil {
+			t.Errorf("Failed to drop test database %s: %v", name, err)
+		}
+	})
+	if timeZone != "" {
+		// SET takes no parameters, so the zone is quoted as a literal
+		setting := fmt.Sprintf(`ALTER DATABASE "%s" SET TimeZone = '%s'`, name, strings.ReplaceAll(timeZone, "'", "''"))
+		if _, err := c.DB.ExecContext(ctx, setting); err != nil {
+			t.Fatalf("Failed to set the test database's time zone: %v", err)
+		}
+	}
+
+	connURL, err := url.Parse(c.ConnStr)
+	if err != nil {
+		t.Fatalf("Failed to parse connection string: %v", err)
+	}
+	connURL.Path = "/" + name
+
+	conn, err := db.Connect(ctx, connURL.String(), db.WithConfig(c.pool))
+	if err != nil {
+		t.Fatalf("Failed to connect to test database: %v", err)
+	}
+	// Registered after the drop, so it runs first
+	t.Cleanup(func() { conn.Close() })
+
+	return conn
+}
+
+// DSN returns the connection string of c.DB as of now. Unlike ConnStr it
+// is safe to read while RestartPostgres runs, so a client that dials its own
+// connections, such as a changefeed.Listener, can follow the container to
+// its new port.
+func (c *PostgresContainer) DSN() string {
+	if c.dsn == nil {
+		return c.ConnStr
+	}
+	return *c.dsn.Load()
+}
+
+// RestartPostgres stops and starts the container, as a crash or a
+// maintenance restart would, and waits until Postgres accepts connections
+// again. c.DB is kept: its connections are dead, but it dials the
+// container's new port from now on, and RestartPostgres fails the test
+// unless a ping through it succeeds. Every session on the container is cut
+// off, so a test calling it needs a container of its own from
+// StartPostgres; pools from CreateTestDatabase made before the restart keep
+// dialing the old port.
+func (c *PostgresContainer) RestartPostgres(ctx context.Context, t testing.TB) {
+	t.Helper()
+
+	if c.external {
+		t.Skipf("RestartPostgres needs a container; unset %s to run it", databaseURLEnv)
+	}
+	if c.reused {
+		t.Skip("RestartPostgres would cut off every package sharing the reused container")
+	}
+
+	stopTimeout := 10 * time.Second
+	if err := c.Stop(ctx, &stopTimeout); err != nil {
+		t.Fatalf("Failed to stop Postgres: %v", err)
+	}
+	// Start waits for the container's wait strategy, as StartPostgres did
+	if err := c.Start(ctx); err != nil {
+		logContainerOutput("postgres", c.Logs)
+		t.Fatalf("Failed to start Postgres again: %v", err)
+	}
+
+	// Docker may map the port anew
+	connStr, err := c.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to get connection string: %v", err)
+	}
+	c.ConnStr = connStr
+	c.dsn.Store(&connStr)
+
+	// A ping discards the dead connections it draws, then dials a new one
+	pingCtx, cancel := context.WithTimeout(ctx, startupTimeout)
+	defer cancel()
+	for {
+		err := c.DB.PingContext(pingCtx)
+		if err == nil {
+			return
+		}
+		select {
+		case <-pingCtx.Done():
+			t.Fatalf("Pool didn't recover after the restart: %v", err)
+		case <-time.After(readyPollInterval):
+		}
+	}
+}
+
+// dropIdleConns closes the pool's idle connections so no session is left on
+// the database, then restores the configured idle limit
+func dropIdleConns(conn *sql.DB, pool db.Config) {
+	conn.SetMaxIdleConns(0)
+	conn.SetMaxIdleConns(pool.MaxIdleConns)
+}
+
+// Terminate closes the connection and removes the container; in reuse mode
+// the container is left running for the next attach, and an external
+// database is never touched
+func (c *PostgresContainer) Terminate(ctx context.Context) error {
+	c.DB.Close()
+	if c.reused || c.external {
+		return nil
+	}
+	if err := c.PostgresContainer.Terminate(ctx); err != nil {
+		return fmt.Errorf("failed to terminate container: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package testhelpers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+)
+
+// redisImage is the image every Redis helper starts
+const redisImage = "redis:7-alpine"
+
+// redisAddrEnv points the tests at an existing Redis (host:port) instead of a container
+const redisAddrEnv = "TEST_REDIS_ADDR"
+
+// externalRedisDBs holds the logical databases of TEST_REDIS_ADDR not in
+// use by a test; a test needing one while all 16 are taken waits for one
+var externalRedisDBs = func() chan int {
+	dbs := make(chan int, 16)
+	for i := 0; i < cap(dbs); i++ {
+		dbs <- i
+	}
+	return dbs
+}()
+
+// RedisContainer is a Redis started for one test and a client connected to it
+type RedisContainer struct {
+	// RedisContainer is nil when TEST_REDIS_ADDR points at an external Redis
+	*redis.RedisContainer
+	Client *goredis.Client
+}
+
+// StartRedis starts a Redis container and returns a connected client; both
+// are torn down when the test finishes.
+//
+// If TEST_REDIS_ADDR is set it connects there instead, to a logical
+// database no other test in the package is using, and flushes it so every
+// test starts from an empty cache, so point it at a disposable instance. If
+// Docker is unreachable the test fails, or is skipped when
+// TEST_SKIP_WITHOUT_DOCKER=1.
+func StartRedis(ctx context.Context, t testing.TB) *goredis.Client {
+	t.Helper()
+	return StartRedisContainer(ctx, t).Client
+}
+
+// StartRedisContainer is StartRedis for tests that also need the container,
+// e.g. to stop it and simulate an outage. Such tests should skip when
+// RedisContainer is nil.
+func StartRedisContainer(ctx context.Context, t testing.TB) *RedisContainer {
+	t.Helper()
+
+	if addr := os.Getenv(redisAddrEnv); addr != "" {
+		// Parallel tests each get a logical database, so none flushes another's keys
+		index := <-externalRedisDBs
+		t.Cleanup(func() { externalRedisDBs <- index })
+
+		client := goredis.NewClient(&goredis.Options{Addr: addr, DB: index})
+		t.Cleanup(func() { client.Close() })
+
+		if err := client.FlushDB(ctx).Err(); err != nil {
+			t.Fatalf("Failed to flush %s: %s", redisAddrEnv, err)
+		}
+		return &RedisContainer{Client: client}
+	}
+
+	RequireDocker(ctx, t)
+
+	// 🐳 START REDIS CONTAINER
+	redisContainer, err := redis.Run(ctx, redisImage,
+		append(redisCustomizers(), CaptureLogs(t, DefaultLogLines))...,
+	)
+	// Registered before the error check: a container that failed its wait strategy still needs removing
+	testcontainers.CleanupContainer(t, redisContainer)
+	if err != nil {
+		t.Fatalf("Failed to start Redis container: %s", err)
+	}
+
+	// Get Redis endpoint
+	redisHost, err := redisContainer.Host(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get Redis host: %s", err)
+	}
+
+	redisPort, err := redisContainer.MappedPort(ctx, "6379/tcp")
+	if err != nil {
+		t.Fatalf("Failed to get Redis port: %s", err)
+	}
+
+	// Connect to Redis
+	client := goredis.NewClient(&goredis.Options{
+		Addr: fmt.Sprintf("%s:%s", redisHost, redisPort.Port()),
+	})
+	t.Cleanup(func() { client.Close() })
+
+	// Test Redis connection
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatalf("Failed to ping Redis: %s", err)
+	}
+
+	log.Println("✅ Redis container ready!")
+
+	return &RedisContainer{RedisContainer: redisContainer, Client: client}
+}
+
+// redisCustomizers configures the redisImage container every helper starts
+func redisCustomizers() []testcontainers.ContainerCustomizer {
+	return []testcontainers.ContainerCustomizer{
+		testcontainers.WithWaitStrategy(WaitForRedis(startupTimeout)),
+	}
+}
+
+// clusterSlots is the number of hash slots in a Redis Cluster
+const clusterSlots = 16384
+
+// StartRedisCluster starts Redis in cluster mode as a single node owning
+// every hash slot and returns a cluster client connected to it; both are
+// torn down when the test finishes. The node announces its address inside
+// Docker, so the client is given the mapped address as the only node
+// instead of discovering it. Cluster mode rejects multi-key commands whose
+// keys hash to different slots (CROSSSLOT), which a plain Redis allows. It
+// always needs Docker, even when TEST_REDIS_ADDR is set.
+func StartRedisCluster(ctx context.Context, t testing.TB) *goredis.ClusterClient {
+	t.Helper()
+	RequireDocker(ctx, t)
+
+	// 🐳 START REDIS CONTAINER IN CLUSTER MODE
+	container, err := redis.Run(ctx, redisImage,
+		append(redisCustomizers(),
+			testcontainers.WithCmdArgs("--cluster-enabled", "yes"),
+			CaptureLogs(t, DefaultLogLines),
+		)...,
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start Redis container: %s", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get Redis host: %s", err)
+	}
+	port, err := container.MappedPort(ctx, "6379/tcp")
+	if err != nil {
+		t.Fatalf("Failed to get Redis port: %s", err)
+	}
+	addr := net.JoinHostPort(host, port.Port())
+
+	node := goredis.NewClient(&goredis.Options{Addr: addr})
+	defer node.Close()
+	if err := node.ClusterAddSlotsRange(ctx, 0, clusterSlots-1).Err(); err != nil {
+		t.Fatalf("Failed to assign hash slots: %s", err)
+	}
+	if err := waitForClusterOK(ctx, node); err != nil {
+		t.Fatalf("Failed waiting for the cluster: %s", err)
+	}
+
+	client := goredis.NewClusterClient(&goredis.ClusterOptions{
+		ClusterSlots: func(context.Context) ([]goredis.ClusterSlot, error) {
+			return []goredis.ClusterSlot{{Start: 0, End: clusterSlots - 1, Nodes: []goredis.ClusterNode{{Addr: addr}}}}, nil
+		},
+		ContextTimeoutEnabled: true,
+	})
+	t.Cleanup(func() { client.Close() })
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Fatalf("Failed to ping Redis cluster: %s", err)
+	}
+
+	log.Println("✅ Redis cluster ready!")
+
+	return client
+}
+
+// waitForClusterOK polls CLUSTER INFO until node reports cluster_state:ok
+func waitForClusterOK(ctx context.Context, node *goredis.Client) error {
+	ctx, cancel := context.WithTimeout(ctx, startupTimeout)
+	defer cancel()
+	for {
+		info, err := node.ClusterInfo(ctx).Result()
+		if err == nil && strings.Contains(info, "cluster_state:ok") {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("cluster_state never became ok: %w", ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
@@ -0,0 +1,255 @@
+// Package sqlspy is a database/sql driver wrapper that counts the statements
+// sent to Postgres, so tests can prove whether a call hit the database or was
+// served from a cache.
+//
+// Give each test a Spy of its own and open the pool through it:
+//
+//	spy := sqlspy.New()
+//	db, err := spy.Open(dsn)
+//	...
+//	spy.Reset()
+//	repo.GetByIDCached(ctx, id)
+//	if n := spy.Count("FROM users"); n != 0 { ... }
+//
+// As a drop-in for sql.Open("postgres", dsn), the driver is also registered as
+// "spy-postgres". Pools opened that way all report to Default.
+package sqlspy
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/lib/pq"
+)
+
+// DriverName is the name the spying lib/pq driver is registered under
+const DriverName = "spy-postgres"
+
+// Default counts the statements of every pool opened with DriverName. Tests
+// sharing it can't run in parallel; prefer New and Spy.Open.
+var Default = New()
+
+func init() {
+	sql.Register(DriverName, spyDriver{})
+}
+
+// Spy counts executed statements by their text, with runs of whitespace
+// collapsed to one space. Queries and execs count once per execution, whether
+// sent directly or through a prepared statement; preparing one counts nothing.
+type Spy struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// New returns a Spy with nothing counted
+func New() *Spy {
+	return &Spy{counts: make(map[string]int)}
+}
+
+// Open returns a lib/pq pool on dsn whose statements s counts
+func (s *Spy) Open(dsn string) (*sql.DB, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return sql.OpenDB(s.Connector(connector)), nil
+}
+
+// Connector wraps connector so s counts the statements of its connections
+func (s *Spy) Connector(connector driver.Connector) driver.Connector {
+	return &spyConnector{Connector: connector, spy: s}
+}
+
+// Reset forgets everything counted so far
+func (s *Spy) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts = make(map[string]int)
+}
+
+// Counts returns how often each statement ran since the last Reset
+func (s *Spy) Counts() map[string]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := make(map[string]int, len(s.counts))
+	for query, n := range s.counts {
+		counts[query] = n
+	}
+	return counts
+}
+
+// Count returns how many statements containing pattern ran since the last
+// Reset, e.g. Count("FROM users WHERE id"). An empty pattern counts every
+// statement.
+func (s *Spy) Count(pattern string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for query, n := range s.counts {
+		if strings.Contains(query, pattern) {
+			total += n
+		}
+	}
+	return total
+}
+
+// Statements returns the distinct statements that ran since the last Reset,
+// sorted, for failure messages
+func (s *Spy) Statements() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queries := make([]string, 0, len(s.counts))
+	for query := range s.counts {
+		queries = append(queries, query)
+	}
+	sort.Strings(queries)
+	return queries
+}
+
+// record counts one execution of query
+func (s *Spy) record(query string) {
+	query = strings.Join(strings.Fields(query), " ")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counts[query]++
+}
+
+// spyDriver is the lib/pq driver reporting to Default
+type spyDriver struct{}
+
+func (spyDriver) Open(dsn string) (driver.Conn, error) {
+	connector, err := spyDriver{}.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+func (spyDriver) OpenConnector(dsn string) (driver.Connector, error) {
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return Default.Connector(connector), nil
+}
+
+// spyConnector wraps each connection the underlying connector makes
+type spyConnector struct {
+	driver.Connector
+	spy *Spy
+}
+
+func (c *spyConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &spyConn{Conn: conn, spy: c.spy}, nil
+}
+
+func (c *spyConnector) Driver() driver.Driver {
+	return spyDriver{}
+}
+
+// spyConn records statements before passing them on. The optional interfaces
+// database/sql looks for are forwarded when the wrapped connection has them.
+type spyConn struct {
+	driver.Conn
+	spy *Spy
+}
+
+func (c *spyConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	c.spy.record(query)
+	return execer.ExecContext(ctx, query, args)
+}
+
+func (c *spyConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	c.spy.record(query)
+	return queryer.QueryContext(ctx, query, args)
+}
+
+func (c *spyConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.Conn.Prepare(query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &spyStmt{Stmt: stmt, query: query, spy: c.spy}, nil
+}
+
+func (c *spyConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *spyConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *spyConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *spyConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// errNoContext is returned for a wrapped statement without context support
+var errNoContext = errors.New("sqlspy: driver statement doesn't support contexts")
+
+// spyStmt records its query on every execution
+type spyStmt struct {
+	driver.Stmt
+	query string
+	spy   *Spy
+}
+
+func (s *spyStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := s.Stmt.(driver.StmtExecContext)
+	if !ok {
+		return nil, errNoContext
+	}
+	s.spy.record(s.query)
+	return execer.ExecContext(ctx, args)
+}
+
+func (s *spyStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := s.Stmt.(driver.StmtQueryContext)
+	if !ok {
+		return nil, errNoContext
+	}
+	s.spy.record(s.query)
+	return queryer.QueryContext(ctx, args)
+}
@@ -0,0 +1,167 @@
+package sqlspy
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// fakeConnector makes connections that accept any statement and return no rows
+type fakeConnector struct{}
+
+func (fakeConnector) Connect(context.Context) (driver.Conn, error) { return fakeConn{}, nil }
+func (fakeConnector) Driver() driver.Driver                        { return nil }
+
+type fakeConn struct{}
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return fakeStmt{}, nil }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return nil, errors.New("not supported") }
+
+func (fakeConn) ExecContext(context.Context, string, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (fakeConn) QueryContext(context.Context, string, []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeStmt struct{}
+
+func (fakeStmt) Close() error                               { return nil }
+func (fakeStmt) NumInput() int                              { return -1 }
+func (fakeStmt) Exec([]driver.Value) (driver.Result, error) { return driver.RowsAffected(0), nil }
+func (fakeStmt) Query([]driver.Value) (driver.Rows, error)  { return fakeRows{}, nil }
+
+func (fakeStmt) ExecContext(context.Context, []driver.NamedValue) (driver.Result, error) {
+	return driver.RowsAffected(0), nil
+}
+
+func (fakeStmt) QueryContext(context.Context, []driver.NamedValue) (driver.Rows, error) {
+	return fakeRows{}, nil
+}
+
+type fakeRows struct{}
+
+func (fakeRows) Columns() []string         { return []string{"n"} }
+func (fakeRows) Close() error              { return nil }
+func (fakeRows) Next([]driver.Value) error { return io.EOF }
+
+// openFake returns a pool of fake connections that spy counts
+func openFake(t *testing.T, spy *Spy) *sql.DB {
+	t.Helper()
+	db := sql.OpenDB(spy.Connector(fakeConnector{}))
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// TestSpyCounts tests counting by statement and by pattern
+func TestSpyCounts(t *testing.T) {
+	ctx := context.Background()
+	spy := New()
+	db := openFake(t, spy)
+
+	for i := 0; i < 3; i++ {
+		if _, err := db.QueryContext(ctx, "SELECT id FROM users\n\t\tWHERE id = $1", i); err != nil {
+			t.Fatalf("Failed to query: %v", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM users WHERE id = $1", 1); err != nil {
+		t.Fatalf("Failed to exec: %v", err)
+	}
+
+	want := map[string]int{
+		"SELECT id FROM users WHERE id = $1": 3,
+		"DELETE FROM users WHERE id = $1":    1,
+	}
+	if got := spy.Counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got: %v", want, got)
+	}
+	if got := spy.Count("FROM users"); got != 4 {
+		t.Errorf("Expected 4 statements on users, got: %d", got)
+	}
+	if got := spy.Count("SELECT"); got != 3 {
+		t.Errorf("Expected 3 SELECTs, got: %d", got)
+	}
+
+	spy.Reset()
+	if got := spy.Count(""); got != 0 {
+		t.Errorf("Expected nothing counted after Reset, got: %d", got)
+	}
+}
+
+// TestSpyPreparedStatements tests that a prepared statement counts once per
+// execution and not when it is prepared
+func TestSpyPreparedStatements(t *testing.T) {
+	ctx := context.Background()
+	spy := New()
+	db := openFake(t, spy)
+
+	stmt, err := db.PrepareContext(ctx, "SELECT id FROM users WHERE id = $1")
+	if err != nil {
+		t.Fatalf("Failed to prepare: %v", err)
+	}
+	defer stmt.Close()
+	if got := spy.Count(""); got != 0 {
+		t.Errorf("Expected preparing to count nothing, got: %d", got)
+	}
+
+	for i := 0; i < 2; i++ {
+		rows, err := stmt.QueryContext(ctx, i)
+		if err != nil {
+			t.Fatalf("Failed to query: %v", err)
+		}
+		rows.Close()
+	}
+	if _, err := stmt.ExecContext(ctx, 3); err != nil {
+		t.Fatalf("Failed to exec: %v", err)
+	}
+
+	if got := spy.Count("SELECT id FROM users"); got != 3 {
+		t.Errorf("Expected 3 executions, got: %d", got)
+	}
+}
+
+// TestSpyConcurrent tests that concurrent statements are all counted
+func TestSpyConcurrent(t *testing.T) {
+	ctx := context.Background()
+	spy := New()
+	db := openFake(t, spy)
+
+	const goroutines, perGoroutine = 20, 50
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perGoroutine; j++ {
+				if _, err := db.ExecContext(ctx, "UPDATE users SET name = $1", "n"); err != nil {
+					t.Errorf("Failed to exec: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := spy.Count("UPDATE users"); got != goroutines*perGoroutine {
+		t.Errorf("Expected %d statements, got: %d", goroutines*perGoroutine, got)
+	}
+}
+
+// TestDriverRegistered tests that the drop-in driver name is registered
+func TestDriverRegistered(t *testing.T) {
+	db, err := sql.Open(DriverName, "postgres://localhost/testdb?sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", DriverName, err)
+	}
+	defer db.Close()
+
+	if _, ok := db.Driver().(spyDriver); !ok {
+		t.Errorf("Expected the spy driver, got: %T", db.Driver())
+	}
+}
@@ -0,0 +1,3 @@
+-- Deliberately broken: the typo makes the entrypoint's psql fail, so the
+-- container exits before it is ready
+CREATE TABEL users (id SERIAL PRIMARY KEY);
@@ -0,0 +1,5 @@
+-- Deliberately slow: the server has already logged "database system is
+-- ready" once when this runs, and the seed rows only appear after the sleep
+CREATE TABLE users (id SERIAL PRIMARY KEY, email TEXT NOT NULL);
+SELECT pg_sleep(3);
+INSERT INTO users (email) VALUES ('alice@example.com'), ('bob@example.com');
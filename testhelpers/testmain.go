@@ -0,0 +1,41 @@
+package testhelpers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"testing"
+)
+
+// RunWithPostgres is a package's TestMain body: it starts Postgres with
+// opts, passes it to setup, runs the tests, and terminates the container.
+// It returns the exit code instead of calling os.Exit, so the deferred
+// Terminate runs on every path, and a failed Terminate fails a passing run.
+//
+// When Docker is unavailable and TEST_SKIP_WITHOUT_DOCKER=1, the tests still
+// run but setup is never called, so each test that needs the database must
+// skip itself.
+func RunWithPostgres(m *testing.M, setup func(*PostgresContainer), opts ...PostgresOption) (code int) {
+	ctx := context.Background()
+
+	container, err := StartPostgres(ctx, opts...)
+	if errors.Is(err, ErrDockerUnavailable) && SkipWithoutDocker() {
+		log.Printf("Skipping integration tests: %s", err)
+		return m.Run()
+	}
+	if err != nil {
+		log.Printf("Failed to start postgres: %s", err)
+		return 1
+	}
+	defer func() {
+		if err := container.Terminate(ctx); err != nil {
+			log.Printf("Failed to terminate container: %s", err)
+			if code == 0 {
+				code = 1
+			}
+		}
+	}()
+	setup(container)
+
+	return m.Run()
+}
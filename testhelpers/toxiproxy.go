@@ -0,0 +1,210 @@
+package testhelpers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/network"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// toxiproxyImage runs the proxy; its HTTP API listens on toxiproxyAPIPort
+// and the one proxy each helper creates on toxiproxyProxyPort
+const (
+	toxiproxyImage       = "ghcr.io/shopify/toxiproxy:2.12.0"
+	toxiproxyAPIPort     = "8474/tcp"
+	toxiproxyProxyPort   = "8666/tcp"
+	toxiproxyProxyListen = "0.0.0.0:8666"
+)
+
+// Proxy is a Toxiproxy proxy in front of one container. Toxics added to it
+// degrade the traffic between the tests and the container until removed.
+// The methods return errors rather than failing the test, so they can be
+// called from another goroutine, e.g. to cut a connection mid-query.
+type Proxy struct {
+	// Endpoint is the host:port the tests connect to instead of the container
+	Endpoint string
+
+	name string
+	api  string // base URL of the Toxiproxy HTTP API
+}
+
+// StartPostgresWithProxy starts a migrated and seeded Postgres container
+// behind Toxiproxy and returns a connection string routed through the proxy.
+// Both containers are removed when the test finishes. It always needs
+// Docker, even when TEST_DATABASE_URL is set.
+func StartPostgresWithProxy(ctx context.Context, t testing.TB) (string, *Proxy) {
+	t.Helper()
+	RequireDocker(ctx, t)
+	nw := newNetwork(ctx, t)
+
+	// Migrated and seeded directly, so toxics never get in the way of the setup
+	connStr := startPostgresOn(ctx, t, nw, PostgresAlias)
+
+	proxy := startToxiproxy(ctx, t, nw, "postgres", PostgresInternalAddr)
+
+	proxiedURL, err := url.Parse(connStr)
+	if err != nil {
+		t.Fatalf("Failed to parse connection string: %s", err)
+	}
+	proxiedURL.Host = proxy.Endpoint
+
+	return proxiedURL.String(), proxy
+}
+
+// StartRedisWithProxy starts a Redis container behind Toxiproxy and returns
+// the proxied host:port. Both containers are removed when the test finishes.
+// It always needs Docker, even when TEST_REDIS_ADDR is set.
+func StartRedisWithProxy(ctx context.Context, t testing.TB) (string, *Proxy) {
+	t.Helper()
+	RequireDocker(ctx, t)
+	nw := newNetwork(ctx, t)
+
+	startRedisOn(ctx, t, nw, RedisAlias)
+
+	proxy := startToxiproxy(ctx, t, nw, "redis", RedisInternalAddr)
+	return proxy.Endpoint, proxy
+}
+
+// startToxiproxy starts a Toxiproxy container on nw and creates a proxy
+// named name that forwards to upstream, a host:port on nw
+func startToxiproxy(ctx context.Context, t testing.TB, nw *testcontainers.DockerNetwork, name, upstream string) *Proxy {
+	t.Helper()
+
+	// 🐳 START TOXIPROXY CONTAINER
+	container, err := testcontainers.Run(ctx, toxiproxyImage,
+		testcontainers.WithExposedPorts(toxiproxyAPIPort, toxiproxyProxyPort),
+		network.WithNetwork([]string{"toxiproxy"}, nw),
+		testcontainers.WithWaitStrategy(
+			wait.ForHTTP("/version").
+				WithPort(toxiproxyAPIPort).
+				WithStartupTimeout(30*time.Second),
+		),
+	)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start Toxiproxy container: %s", err)
+	}
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get Toxiproxy host: %s", err)
+	}
+	apiPort, err := container.MappedPort(ctx, toxiproxyAPIPort)
+	if err != nil {
+		t.Fatalf("Failed to get Toxiproxy API port: %s", err)
+	}
+	proxyPort, err := container.MappedPort(ctx, toxiproxyProxyPort)
+	if err != nil {
+		t.Fatalf("Failed to get Toxiproxy proxy port: %s", err)
+	}
+
+	proxy := &Proxy{
+		Endpoint: net.JoinHostPort(host, proxyPort.Port()),
+		name:     name,
+		api:      "http://" + net.JoinHostPort(host, apiPort.Port()),
+	}
+	err = proxy.call(ctx, http.MethodPost, "/proxies", map[string]interface{}{
+		"name":     name,
+		"listen":   toxiproxyProxyListen,
+		"upstream": upstream,
+		"enabled":  true,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create proxy: %s", err)
+	}
+
+	log.Printf("✅ Toxiproxy ready in front of %s!", upstream)
+
+	return proxy
+}
+
+// AddLatency delays every response from the container by latency
+func (p *Proxy) AddLatency(ctx context.Context, latency time.Duration) error {
+	return p.addToxic(ctx, "latency", map[string]interface{}{
+		"latency": latency.Milliseconds(),
+	})
+}
+
+// AddTimeout stops all data from the container and closes the connection
+// after timeout; a timeout of 0 holds the connection open indefinitely
+func (p *Proxy) AddTimeout(ctx context.Context, timeout time.Duration) error {
+	return p.addToxic(ctx, "timeout", map[string]interface{}{
+		"timeout": timeout.Milliseconds(),
+	})
+}
+
+// AddBandwidth limits the data from the container to kbps kilobytes per second
+func (p *Proxy) AddBandwidth(ctx context.Context, kbps int) error {
+	return p.addToxic(ctx, "bandwidth", map[string]interface{}{
+		"rate": kbps,
+	})
+}
+
+// RemoveToxic removes the toxic of the given type, e.g. "latency"
+func (p *Proxy) RemoveToxic(ctx context.Context, toxicType string) error {
+	return p.call(ctx, http.MethodDelete, "/proxies/"+p.name+"/toxics/"+toxicType, nil)
+}
+
+// Disable closes every open connection through the proxy and refuses new
+// ones until Enable, like a network partition
+func (p *Proxy) Disable(ctx context.Context) error {
+	return p.call(ctx, http.MethodPost, "/proxies/"+p.name, map[string]interface{}{"enabled": false})
+}
+
+// Enable accepts connections through the proxy again
+func (p *Proxy) Enable(ctx context.Context) error {
+	return p.call(ctx, http.MethodPost, "/proxies/"+p.name, map[string]interface{}{"enabled": true})
+}
+
+// addToxic adds a toxic of toxicType to the downstream (container to test)
+// traffic, named after its type so RemoveToxic can find it
+func (p *Proxy) addToxic(ctx context.Context, toxicType string, attributes map[string]interface{}) error {
+	return p.call(ctx, http.MethodPost, "/proxies/"+p.name+"/toxics", map[string]interface{}{
+		"name":       toxicType,
+		"type":       toxicType,
+		"stream":     "downstream",
+		"toxicity":   1.0,
+		"attributes": attributes,
+	})
+}
+
+// call sends a request to the Toxiproxy API, failing on any non-2xx status
+func (p *Proxy) call(ctx context.Context, method, path string, body interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to encode toxiproxy request: %w", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, p.api+path, reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to build toxiproxy request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("toxiproxy %s %s: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("toxiproxy %s %s: %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package testhelpers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"time"
+
+	"testcontainers-demo/db"
+
+	"github.com/docker/go-connections/nat"
+	"github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// Credentials every Postgres helper creates its database with
+const (
+	postgresDB       = "testdb"
+	postgresUser     = "testuser"
+	postgresPassword = "testpass"
+)
+
+// Container ports the wait strategies probe
+const (
+	postgresPort nat.Port = "5432/tcp"
+	redisPort    nat.Port = "6379/tcp"
+)
+
+// startupTimeout bounds how long the helpers wait for a container to be ready
+const startupTimeout = 30 * time.Second
+
+// readyPollInterval is the pause between readiness probes
+const readyPollInterval = 100 * time.Millisecond
+
+// WaitForPostgres is satisfied once Postgres answers SELECT 1 over its mapped
+// port and, when minUsers is above zero, the users table holds at least
+// minUsers rows, such as those an init script inserts. Both checks share
+// timeout.
+//
+// Unlike waiting for "database system is ready" to be logged twice, it
+// doesn't depend on the image's log language, and it can't pass while the
+// init scripts are still running, since the entrypoint's temporary server
+// doesn't listen on TCP.
+func WaitForPostgres(minUsers int, timeout time.Duration) wait.Strategy {
+	strategies := []wait.Strategy{
+		wait.ForSQL(postgresPort, db.DefaultDriver, postgresURL).
+			WithQuery("SELECT 1").
+			WithPollInterval(readyPollInterval),
+	}
+	if minUsers > 0 {
+		strategies = append(strategies, &usersStrategy{minUsers: minUsers})
+	}
+	return wait.ForAll(strategies...).WithDeadline(timeout)
+}
+
+// WaitForRedis is satisfied once Redis answers PING over its mapped port
+func WaitForRedis(timeout time.Duration) wait.Strategy {
+	return wait.ForAll(&pingStrategy{}).WithDeadline(timeout)
+}
+
+// postgresURL is the connection string of the helpers' database at host:port
+func postgresURL(host string, port nat.Port) string {
+	return fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
+		postgresUser, postgresPassword, net.JoinHostPort(host, port.Port()), postgresDB)
+}
+
+// usersStrategy polls until the users table holds at least minUsers rows
+type usersStrategy struct {
+	minUsers int
+}
+
+// WaitUntilReady implements wait.Strategy
+func (s *usersStrategy) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	var count int
+	err := poll(ctx, target, postgresPort, func(host string, port nat.Port) error {
+		conn, err := sql.Open(db.DefaultDriver, postgresURL(host, port))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+			return err
+		}
+		if count < s.minUsers {
+			return fmt.Errorf("users has %d rows, want at least %d", count, s.minUsers)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for seed rows: %w", err)
+	}
+	return nil
+}
+
+// pingStrategy polls until Redis answers PING
+type pingStrategy struct{}
+
+// WaitUntilReady implements wait.Strategy
+func (s *pingStrategy) WaitUntilReady(ctx context.Context, target wait.StrategyTarget) error {
+	err := poll(ctx, target, redisPort, func(host string, port nat.Port) error {
+		client := redis.NewClient(&redis.Options{Addr: net.JoinHostPort(host, port.Port()), MaxRetries: -1})
+		defer client.Close()
+		return client.Ping(ctx).Err()
+	})
+	if err != nil {
+		return fmt.Errorf("waiting for PING: %w", err)
+	}
+	return nil
+}
+
+// poll calls probe with the host and mapped port of port until it succeeds, the
+// container stops running, or ctx is done; the last probe error is returned
+// with ctx's
+func poll(ctx context.Context, target wait.StrategyTarget, port nat.Port, probe func(host string, port nat.Port) error) error {
+	ticker := time.NewTicker(readyPollInterval)
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if lastErr == nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("%w: %w", ctx.Err(), lastErr)
+		case <-ticker.C:
+		}
+
+		state, err := target.State(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if !state.Running {
+			return fmt.Errorf("container is %s (exit code %d)", state.Status, state.ExitCode)
+		}
+
+		lastErr = probeAddr(ctx, target, port, probe)
+		if lastErr == nil {
+			return nil
+		}
+	}
+}
+
+// probeAddr resolves the host and mapped port of port and calls probe with them
+func probeAddr(ctx context.Context, target wait.StrategyTarget, port nat.Port, probe func(host string, port nat.Port) error) error {
+	host, err := target.Host(ctx)
+	if err != nil {
+		return err
+	}
+	mapped, err := target.MappedPort(ctx, port)
+	if err != nil {
+		return err
+	}
+	return probe(host, mapped)
+}
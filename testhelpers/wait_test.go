@@ -0,0 +1,104 @@
+package testhelpers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"testcontainers-demo/db"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/modules/redis"
+)
+
+// slowInitSleep is how long testdata/slow_init.sql sleeps before seeding
+const slowInitSleep = 3 * time.Second
+
+// TestWaitForPostgresSlowInit tests that the wait strategy doesn't return
+// while the init script is still running
+func TestWaitForPostgresSlowInit(t *testing.T) {
+	ctx := context.Background()
+	RequireDocker(ctx, t)
+
+	t.Run("Waits For Seed Rows", func(t *testing.T) {
+		start := time.Now()
+		container, err := postgres.Run(ctx, PostgresImage(),
+			postgres.WithDatabase(postgresDB),
+			postgres.WithUsername(postgresUser),
+			postgres.WithPassword(postgresPassword),
+			postgres.WithInitScripts("testdata/slow_init.sql"),
+			testcontainers.WithWaitStrategy(WaitForPostgres(2, time.Minute)),
+			CaptureLogs(t, DefaultLogLines),
+		)
+		testcontainers.CleanupContainer(t, container)
+		if err != nil {
+			t.Fatalf("Failed to start Postgres container: %s", err)
+		}
+		if elapsed := time.Since(start); elapsed < slowInitSleep {
+			t.Errorf("Expected the wait to outlast the %s init script, returned after %s", slowInitSleep, elapsed)
+		}
+
+		connStr, err := container.ConnectionString(ctx, "sslmode=disable")
+		if err != nil {
+			t.Fatalf("Failed to get connection string: %s", err)
+		}
+		conn, err := db.Connect(ctx, connStr)
+		if err != nil {
+			t.Fatalf("Failed to connect: %s", err)
+		}
+		defer conn.Close()
+
+		var count int
+		if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM users").Scan(&count); err != nil {
+			t.Fatalf("Expected the seeded users table, got: %s", err)
+		}
+		if count != 2 {
+			t.Errorf("Expected 2 seed rows, got: %d", count)
+		}
+	})
+
+	t.Run("Times Out Without Enough Rows", func(t *testing.T) {
+		container, err := postgres.Run(ctx, PostgresImage(),
+			postgres.WithDatabase(postgresDB),
+			postgres.WithUsername(postgresUser),
+			postgres.WithPassword(postgresPassword),
+			postgres.WithInitScripts("testdata/slow_init.sql"),
+			testcontainers.WithWaitStrategy(WaitForPostgres(3, slowInitSleep+10*time.Second)),
+		)
+		testcontainers.CleanupContainer(t, container)
+		if err == nil {
+			t.Fatal("Expected the wait to time out with only 2 of 3 rows")
+		}
+	})
+}
+
+// TestWaitForRedis tests that Redis answers PING as soon as the helper returns
+func TestWaitForRedis(t *testing.T) {
+	ctx := context.Background()
+	RequireDocker(ctx, t)
+
+	container, err := redis.Run(ctx, redisImage, redisCustomizers()...)
+	testcontainers.CleanupContainer(t, container)
+	if err != nil {
+		t.Fatalf("Failed to start Redis container: %s", err)
+	}
+
+	addr, err := container.ConnectionString(ctx)
+	if err != nil {
+		t.Fatalf("Failed to get connection string: %s", err)
+	}
+	opts, err := goredis.ParseURL(addr)
+	if err != nil {
+		t.Fatalf("Failed to parse connection string: %s", err)
+	}
+	opts.MaxRetries = -1
+	client := goredis.NewClient(opts)
+	defer client.Close()
+
+	// No retries: the first PING must succeed
+	if err := client.Ping(ctx).Err(); err != nil {
+		t.Errorf("Expected PING to succeed, got: %s", err)
+	}
+}
@@ -0,0 +1,289 @@
+// Package webhooks delivers user events to HTTP endpoints, for deployments
+// without a message broker. A Dispatcher is a notifications.Publisher: pass
+// it to repository.WithPublisher and every endpoint receives a signed JSON
+// POST of each event, retried with exponential backoff, with the outcome
+// recorded in the webhook_deliveries table.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"testcontainers-demo/backoff"
+	"testcontainers-demo/notifications"
+)
+
+// Headers of every delivery
+const (
+	// SignatureHeader is "sha256=" and the hex HMAC-SHA256 of the body keyed
+	// by the endpoint's secret; check it with Verify
+	SignatureHeader = "X-Webhook-Signature"
+	// EventIDHeader is the event ID, the same on every attempt, so receivers
+	// can drop a delivery they already handled
+	EventIDHeader = "X-Webhook-Event-ID"
+	// EventTypeHeader is the event type, e.g. user.created
+	EventTypeHeader = "X-Webhook-Event-Type"
+)
+
+// Delivery statuses in webhook_deliveries
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Defaults for NewDispatcher's options
+const (
+	DefaultMaxAttempts = 5
+	DefaultBaseDelay   = time.Second
+	DefaultTimeout     = 10 * time.Second
+)
+
+// maxDelay caps the wait between attempts of a delivery
+const maxDelay = time.Minute
+
+// Endpoint is a registered receiver of events
+type Endpoint struct {
+	URL    string
+	Secret string
+}
+
+// Dispatcher POSTs each published event to every endpoint. Publish records
+// a pending delivery per endpoint and returns; the attempts run in the
+// background, so a slow or failing endpoint never holds up the user write
+// that published the event. Deliveries still in flight when the process
+// exits stay pending: call Close on shutdown to wait for them.
+type Dispatcher struct {
+	db          *sql.DB
+	endpoints   []Endpoint
+	client      *http.Client
+	maxAttempts int
+	baseDelay   time.Duration
+	inflight    sync.WaitGroup
+	done        chan struct{} // closed by Close once it stops waiting
+	closeOnce   sync.Once
+}
+
+// Option configures a Dispatcher
+type Option func(*Dispatcher)
+
+// WithMaxAttempts sets how many times a delivery is attempted in all before
+// it is marked failed
+func WithMaxAttempts(n int) Option {
+	return func(d *Dispatcher) {
+		d.maxAttempts = n
+	}
+}
+
+// WithBaseDelay sets the wait before the first retry; it doubles, with
+// jitter, before each further one, up to a minute
+func WithBaseDelay(delay time.Duration) Option {
+	return func(d *Dispatcher) {
+		d.baseDelay = delay
+	}
+}
+
+// WithHTTPClient sets the client deliveries are sent with; its Timeout
+// bounds each attempt
+func WithHTTPClient(c *http.Client) Option {
+	return func(d *Dispatcher) {
+		d.client = c
+	}
+}
+
+// NewDispatcher creates a dispatcher to endpoints, recording deliveries in db
+func NewDispatcher(db *sql.DB, endpoints []Endpoint, opts ...Option) *Dispatcher {
+	d := &Dispatcher{
+		db:          db,
+		endpoints:   endpoints,
+		client:      &http.Client{Timeout: DefaultTimeout},
+		maxAttempts: DefaultMaxAttempts,
+		baseDelay:   DefaultBaseDelay,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	return d
+}
+
+// Publish records a pending delivery of event to each endpoint and starts
+// delivering them. It only fails if the event can't be encoded or a
+// delivery can't be recorded; delivery failures end up in the table.
+func (d *Dispatcher) Publish(ctx context.Context, event notifications.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event %s: %w", event.ID, err)
+	}
+
+	for _, endpoint := range d.endpoints {
+		var id int
+		err := d.db.QueryRowContext(ctx, `
+			INSERT INTO webhook_deliveries (event_id, event_type, user_id, endpoint_url)
+			VALUES ($1, $2, $3, $4)
+			RETURNING id`,
+			event.ID, event.Type, event.UserID, endpoint.URL,
+		).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("failed to record delivery of event %s to %s: %w", event.ID, endpoint.URL, err)
+		}
+
+		d.inflight.Add(1)
+		go func() {
+			defer d.inflight.Done()
+			d.deliver(context.WithoutCancel(ctx), id, endpoint, event, body)
+		}()
+	}
+	return nil
+}
+
+// Close waits for the deliveries in flight, or for ctx to be done. In that
+// case a delivery waiting to retry stops, staying pending, and one in the
+// middle of an attempt stops once the attempt is recorded.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	defer d.closeOnce.Do(func() { close(d.done) })
+
+	finished := make(chan struct{})
+	go func() {
+		d.inflight.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("deliveries still in flight: %w", ctx.Err())
+	}
+}
+
+// deliver attempts delivery id until it succeeds, fails permanently, runs
+// out of attempts, or Close stops it, recording each attempt
+func (d *Dispatcher) deliver(ctx context.Context, id int, endpoint Endpoint, event notifications.Event, body []byte) {
+	for attempt := 1; ; attempt++ {
+		code, err := d.post(ctx, endpoint, event, body)
+
+		status := StatusPending
+		switch {
+		case err == nil:
+			status = StatusDelivered
+		case !retryable(code) || attempt >= d.maxAttempts:
+			status = StatusFailed
+		}
+		if recErr := d.record(ctx, id, attempt, status, code, err); recErr != nil {
+			log.Printf("webhooks: %v", recErr)
+		}
+		if status != StatusPending {
+			return
+		}
+
+		timer := time.NewTimer(backoff.Delay(attempt, d.baseDelay, maxDelay))
+		select {
+		case <-d.done:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// post sends one attempt, returning the response status, or 0 if there was
+// no response. Any status other than 2xx is an error.
+func (d *Dispatcher) post(ctx context.Context, endpoint Endpoint, event notifications.Event, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, body))
+	req.Header.Set(EventIDHeader, event.ID.String())
+	req.Header.Set(EventTypeHeader, string(event.Type))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drain a little so the connection can be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryable reports whether an attempt that got code, or no response for
+// 0, may succeed if tried again: any failure but a 4xx other than 408 and
+// 429, which say the request itself is wrong
+func retryable(code int) bool {
+	if code == http.StatusRequestTimeout || code == http.StatusTooManyRequests {
+		return true
+	}
+	return code < 400 || code >= 500
+}
+
+// record stores the outcome of attempt number attempt of delivery id
+func (d *Dispatcher) record(ctx context.Context, id, attempt int, status string, code int, err error) error {
+	var responseStatus sql.NullInt64
+	if code != 0 {
+		responseStatus = sql.NullInt64{Int64: int64(code), Valid: true}
+	}
+	var lastError sql.NullString
+	if err != nil {
+		lastError = sql.NullString{String: err.Error(), Valid: true}
+	}
+
+	_, dbErr := d.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status = $1, attempts = $2, response_status = $3, last_error = $4, updated_at = CURRENT_TIMESTAMP
+		WHERE id = $5`,
+		status, attempt, responseStatus, lastError, id,
+	)
+	if dbErr != nil {
+		return fmt.Errorf("failed to record attempt %d of delivery %d: %w", attempt, id, dbErr)
+	}
+	return nil
+}
+
+// Sign returns the SignatureHeader value for body under secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// ErrInvalidSignature is returned by Verify for a missing or wrong signature
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Verify checks a delivery's SignatureHeader value against its body, in
+// constant time. Receivers should read the raw body, verify it, and only
+// then decode it.
+func Verify(secret string, body []byte, signature string) error {
+	got, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return ErrInvalidSignature
+	}
+	gotMAC, err := hex.DecodeString(got)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	if !hmac.Equal(gotMAC, mac.Sum(nil)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package webhooks_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"testcontainers-demo/fixtures"
+	"testcontainers-demo/models"
+	"testcontainers-demo/notifications"
+	"testcontainers-demo/repository"
+	"testcontainers-demo/testhelpers"
+	"testcontainers-demo/webhooks"
+)
+
+// testContainer provides a fresh seeded database per test. It is nil when
+// Docker is unavailable, and only the integration tests need it.
+var testContainer *testhelpers.PostgresContainer
+
+// TestMain starts one Postgres container for the whole package
+func TestMain(m *testing.M) {
+	os.Exit(testhelpers.RunWithPostgres(m, func(c *testhelpers.PostgresContainer) {
+		testContainer = c
+	}))
+}
+
+// requirePostgres skips t when TestMain could not start Postgres
+func requirePostgres(t *testing.T) {
+	t.Helper()
+	if testContainer == nil {
+		t.Skip("Postgres is unavailable without Docker")
+	}
+}
+
+// receiver is an endpoint answering the first len(statuses) requests with
+// statuses in turn and every later one with 200, keeping each request
+type receiver struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   [][]byte
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	n := len(rc.bodies)
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	rc.mu.Unlock()
+
+	if n < len(rc.statuses) {
+		w.WriteHeader(rc.statuses[n])
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// requests returns how many requests the receiver got
+func (rc *receiver) requests() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return len(rc.bodies)
+}
+
+// delivery is a webhook_deliveries row
+type delivery struct {
+	status         string
+	attempts       int
+	responseStatus sql.NullInt64
+	lastError      sql.NullString
+}
+
+// deliveryTo returns the delivery of user id's only event to url
+func deliveryTo(t *testing.T, db *sql.DB, userID int, url string) delivery {
+	t.Helper()
+	var d delivery
+	err := db.QueryRow(`
+		SELECT status, attempts, response_status, last_error
+		FROM webhook_deliveries WHERE user_id = $1 AND endpoint_url = $2`,
+		userID, url,
+	).Scan(&d.status, &d.attempts, &d.responseStatus, &d.lastError)
+	if err != nil {
+		t.Fatalf("Failed to read delivery: %v", err)
+	}
+	return d
+}
+
+// TestDispatcher tests delivering a user's creation through the repository
+// to a flaky endpoint, a failing one, and one rejecting the request
+func TestDispatcher(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+	redisClient := testhelpers.StartRedis(ctx, t)
+
+	flaky := &receiver{statuses: []int{http.StatusServiceUnavailable, http.StatusBadGateway}}
+	down := &receiver{statuses: []int{500, 500, 500, 500}}
+	rejecting := &receiver{statuses: []int{http.StatusUnprocessableEntity}}
+	var endpoints []webhooks.Endpoint
+	for _, h := range []*receiver{flaky, down, rejecting} {
+		srv := httptest.NewServer(h)
+		t.Cleanup(srv.Close)
+		endpoints = append(endpoints, webhooks.Endpoint{URL: srv.URL, Secret: "secret-" + srv.URL})
+	}
+
+	dispatcher := webhooks.NewDispatcher(db, endpoints,
+		webhooks.WithMaxAttempts(3),
+		webhooks.WithBaseDelay(50*time.Millisecond),
+	)
+	cachedRepo := repository.NewCachedUserRepository(db, redisClient, repository.WithPublisher(dispatcher))
+
+	user, err := cachedRepo.CreateCached(ctx, fixtures.GenerateEmail(t), "Webhook User")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	// The third attempt comes at least 75ms of backoff later
+	if n := down.requests(); n >= 3 {
+		t.Errorf("Expected the write to return before the retries, saw %d attempts", n)
+	}
+
+	closeCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := dispatcher.Close(closeCtx); err != nil {
+		t.Fatalf("Failed to finish deliveries: %v", err)
+	}
+
+	t.Run("Delivered On Third Attempt", func(t *testing.T) {
+		if n := flaky.requests(); n != 3 {
+			t.Fatalf("Expected 3 attempts, got: %d", n)
+		}
+		for i, body := range flaky.bodies {
+			if err := webhooks.Verify(endpoints[0].Secret, body, flaky.headers[i].Get(webhooks.SignatureHeader)); err != nil {
+				t.Errorf("Expected attempt %d validly signed, got: %v", i+1, err)
+			}
+		}
+		var event notifications.Event
+		if err := json.Unmarshal(flaky.bodies[2], &event); err != nil {
+			t.Fatalf("Failed to decode event: %v", err)
+		}
+		if event.Type != models.EventUserCreated || event.UserID != user.ID {
+			t.Errorf("Expected user.created for user %d, got: %+v", user.ID, event)
+		}
+		if got := flaky.headers[0].Get(webhooks.EventIDHeader); got != event.ID.String() || flaky.headers[2].Get(webhooks.EventIDHeader) != got {
+			t.Errorf("Expected every attempt to carry event ID %s, got: %s", event.ID, got)
+		}
+
+		d := deliveryTo(t, db, user.ID, endpoints[0].URL)
+		if d.status != webhooks.StatusDelivered || d.attempts != 3 || d.responseStatus.Int64 != http.StatusOK || d.lastError.Valid {
+			t.Errorf("Expected delivered after 3 attempts, got: %+v", d)
+		}
+	})
+
+	t.Run("Failing Endpoint Gives Up", func(t *testing.T) {
+		if n := down.requests(); n != 3 {
+			t.Errorf("Expected 3 attempts, got: %d", n)
+		}
+		d := deliveryTo(t, db, user.ID, endpoints[1].URL)
+		if d.status != webhooks.StatusFailed || d.attempts != 3 || d.responseStatus.Int64 != 500 || !d.lastError.Valid {
+			t.Errorf("Expected failed after 3 attempts, got: %+v", d)
+		}
+		if _, err := cachedRepo.GetByIDCached(ctx, user.ID); err != nil {
+			t.Errorf("Expected the user written regardless, got: %v", err)
+		}
+	})
+
+	t.Run("Rejected Request Not Retried", func(t *testing.T) {
+		if n := rejecting.requests(); n != 1 {
+			t.Errorf("Expected 1 attempt, got: %d", n)
+		}
+		d := deliveryTo(t, db, user.ID, endpoints[2].URL)
+		if d.status != webhooks.StatusFailed || d.attempts != 1 {
+			t.Errorf("Expected failed after 1 attempt, got: %+v", d)
+		}
+	})
+}
+
+// TestCloseStopsRetries tests that a Close whose context is done stops a
+// delivery waiting to retry instead of leaving it asleep for the backoff
+func TestCloseStopsRetries(t *testing.T) {
+	requirePostgres(t)
+	t.Parallel()
+	ctx := context.Background()
+	db := testContainer.CreateTestDatabase(ctx, t)
+
+	down := &receiver{statuses: []int{500, 500}}
+	srv := httptest.NewServer(down)
+	t.Cleanup(srv.Close)
+	dispatcher := webhooks.NewDispatcher(db, []webhooks.Endpoint{{URL: srv.URL, Secret: "secret"}},
+		webhooks.WithBaseDelay(time.Hour),
+	)
+
+	user, err := repository.NewUserRepository(db).Create(ctx, fixtures.GenerateEmail(t), "Closed On")
+	if err != nil {
+		t.Fatalf("Failed to create user: %v", err)
+	}
+	if err := dispatcher.Publish(ctx, notifications.NewEvent(models.EventUserCreated, *user)); err != nil {
+		t.Fatalf("Failed to publish: %v", err)
+	}
+
+	closeCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if err := dispatcher.Close(closeCtx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the delivery still waiting to retry, got: %v", err)
+	}
+	// The delivery stopped, so a second Close has nothing left to wait for
+	closeCtx, cancel = context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := dispatcher.Close(closeCtx); err != nil {
+		t.Fatalf("Expected the delivery stopped by the first Close, got: %v", err)
+	}
+
+	if n := down.requests(); n != 1 {
+		t.Errorf("Expected 1 attempt, got: %d", n)
+	}
+	if d := deliveryTo(t, db, user.ID, srv.URL); d.status != webhooks.StatusPending || d.attempts != 1 {
+		t.Errorf("Expected pending after 1 attempt, got: %+v", d)
+	}
+}
+
+// TestVerify tests that only the body signed with the right secret verifies
+func TestVerify(t *testing.T) {
+	body := []byte(`{"type":"user.created"}`)
+	signature := webhooks.Sign("secret", body)
+
+	if err := webhooks.Verify("secret", body, signature); err != nil {
+		t.Errorf("Expected the signature valid, got: %v", err)
+	}
+	for name, tc := range map[string]struct {
+		secret, signature string
+		body              []byte
+	}{
+		"Wrong Secret":   {"other", signature, body},
+		"Tampered Body":  {"secret", signature, []byte(`{"type":"user.deleted"}`)},
+		"Missing Prefix": {"secret", signature[len("sha256="):], body},
+		"Not Hex":        {"secret", "sha256=zz", body},
+		"Empty":          {"secret", "", body},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if err := webhooks.Verify(tc.secret, tc.body, tc.signature); !errors.Is(err, webhooks.ErrInvalidSignature) {
+				t.Errorf("Expected ErrInvalidSignature, got: %v", err)
+			}
+		})
+	}
+}